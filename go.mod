@@ -4,7 +4,10 @@ go 1.24.0
 
 toolchain go1.24.10
 
-require github.com/shopspring/decimal v1.4.0
+require (
+	github.com/golang-jwt/jwt/v5 v5.3.0
+	github.com/shopspring/decimal v1.4.0
+)
 
 // Allow local development imports of the tea-api submodule
 require tea-api v0.0.0
@@ -28,7 +31,6 @@ require (
 	github.com/go-sql-driver/mysql v1.8.1 // indirect
 	github.com/goccy/go-json v0.10.2 // indirect
 	github.com/goccy/go-yaml v1.18.0 // indirect
-	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"tea-api/internal/config"
+	"tea-api/internal/service/ledger"
+	"tea-api/pkg/database"
+)
+
+// 启用复式账本时补录期初余额：先以 -dry-run 查看差额，确认后再正式执行；可重复执行，仅补差额。
+// 每个科目在锁定科目行后计算并过账，无需停机；dry-run 不加锁，输出仅供参考
+func main() {
+	var cfgPath string
+	var dryRun bool
+	flag.StringVar(&cfgPath, "config", "configs/config.yaml", "config file path")
+	flag.BoolVar(&dryRun, "dry-run", false, "only report differences, do not write")
+	flag.Parse()
+
+	if err := config.LoadConfig(cfgPath); err != nil {
+		panic(fmt.Errorf("load config: %w", err))
+	}
+	database.InitDatabase()
+	rep, err := ledger.Backfill(database.GetDB(), dryRun)
+	if err != nil {
+		panic(err)
+	}
+	for _, it := range rep.Items {
+		fmt.Printf("%-32s ledger=%d target=%d diff=%d\n", it.Account, it.Ledger, it.Target, it.Target-it.Ledger)
+	}
+	fmt.Printf("accounts=%d opening_total_cents=%d wallets_created=%d users_resynced=%d dry_run=%v\n",
+		len(rep.Items), rep.OpeningTotalCts, rep.WalletsCreated, rep.UsersResynced, dryRun)
+	fmt.Println("ledger backfill done")
+}
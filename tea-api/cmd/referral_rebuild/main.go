@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"tea-api/internal/config"
+	"tea-api/internal/service"
+	"tea-api/pkg/database"
+)
+
+// 由直接推荐关系（depth=1）重建推荐闭包表：先以 -dry-run 查看重复推荐人与成环情况，确认后再正式执行；可重复执行
+func main() {
+	var cfgPath string
+	var dryRun bool
+	flag.StringVar(&cfgPath, "config", "configs/config.yaml", "config file path")
+	flag.BoolVar(&dryRun, "dry-run", false, "only report, do not write")
+	flag.Parse()
+
+	if err := config.LoadConfig(cfgPath); err != nil {
+		panic(fmt.Errorf("load config: %w", err))
+	}
+	database.InitDatabase()
+	rep, err := service.NewReferralService().RebuildClosure(dryRun)
+	if err != nil {
+		panic(err)
+	}
+	if len(rep.DuplicateParents) > 0 {
+		fmt.Printf("duplicate_parents=%v\n", rep.DuplicateParents)
+	}
+	if len(rep.CycleDropped) > 0 {
+		fmt.Printf("cycle_dropped=%v\n", rep.CycleDropped)
+	}
+	fmt.Printf("users=%d edges=%d rows=%d dry_run=%v\n", rep.Users, rep.Edges, rep.Rows, dryRun)
+	fmt.Println("referral closure rebuild done")
+}
@@ -26,6 +26,10 @@ type config struct {
 	Upload        Upload        `mapstructure:"upload" json:"upload" yaml:"upload"`
 	System        System        `mapstructure:"system" json:"system" yaml:"system"`
 	Finance       Finance       `mapstructure:"finance" json:"finance" yaml:"finance"`
+	Invoice       Invoice       `mapstructure:"invoice" json:"invoice" yaml:"invoice"`
+	Print         Print         `mapstructure:"print" json:"print" yaml:"print"`
+	Inventory     Inventory     `mapstructure:"inventory" json:"inventory" yaml:"inventory"`
+	Payment       Payment       `mapstructure:"payment" json:"payment" yaml:"payment"`
 	Observability Observability `mapstructure:"observability" json:"observability" yaml:"observability"`
 	AI            AI            `mapstructure:"ai" json:"ai" yaml:"ai"`
 }
@@ -120,6 +124,12 @@ type WeChat struct {
 	CertPath  string `mapstructure:"cert_path" json:"cert_path" yaml:"cert_path"`
 	KeyPath   string `mapstructure:"key_path" json:"key_path" yaml:"key_path"`
 	NotifyURL string `mapstructure:"notify_url" json:"notify_url" yaml:"notify_url"`
+	// 微信支付 APIv3
+	PayMode          string `mapstructure:"pay_mode" json:"pay_mode" yaml:"pay_mode"`                               // mock（本地模拟）/ v3（真实 APIv3）
+	APIBaseURL       string `mapstructure:"api_base_url" json:"api_base_url" yaml:"api_base_url"`                   // 默认 https://api.mch.weixin.qq.com，联调可指向本地桩服务
+	APIv3Key         string `mapstructure:"api_v3_key" json:"api_v3_key" yaml:"api_v3_key"`                         // 32 字节 APIv3 密钥，用于解密回调与平台证书
+	SerialNo         string `mapstructure:"serial_no" json:"serial_no" yaml:"serial_no"`                            // 商户证书序列号，为空时从 cert_path 读取
+	PlatformCertPath string `mapstructure:"platform_cert_path" json:"platform_cert_path" yaml:"platform_cert_path"` // 平台证书（可含多个 PEM），为空时自动下载
 }
 
 type Alipay struct {
@@ -127,6 +137,9 @@ type Alipay struct {
 	PrivateKey string `mapstructure:"private_key" json:"private_key" yaml:"private_key"`
 	PublicKey  string `mapstructure:"public_key" json:"public_key" yaml:"public_key"`
 	NotifyURL  string `mapstructure:"notify_url" json:"notify_url" yaml:"notify_url"`
+	PayMode    string `mapstructure:"pay_mode" json:"pay_mode" yaml:"pay_mode"`          // mock（本地模拟）/ live（真实开放平台）
+	GatewayURL string `mapstructure:"gateway_url" json:"gateway_url" yaml:"gateway_url"` // 默认 https://openapi.alipay.com/gateway.do，联调可指向沙箱或本地桩服务
+	ReturnURL  string `mapstructure:"return_url" json:"return_url" yaml:"return_url"`    // H5 支付完成后跳转地址
 }
 
 type Delivery struct {
@@ -172,6 +185,11 @@ type Finance struct {
 	Accrual           Accrual           `mapstructure:"accrual" json:"accrual" yaml:"accrual"`
 	CommissionRelease CommissionRelease `mapstructure:"commission_release" json:"commission_release" yaml:"commission_release"`
 	Withdrawal        Withdrawal        `mapstructure:"withdrawal" json:"withdrawal" yaml:"withdrawal"`
+	WalletAudit       WalletAudit       `mapstructure:"wallet_audit" json:"wallet_audit" yaml:"wallet_audit"`
+	GiftCard          GiftCard          `mapstructure:"gift_card" json:"gift_card" yaml:"gift_card"`
+	PointsExpiry      PointsExpiry      `mapstructure:"points_expiry" json:"points_expiry" yaml:"points_expiry"`
+	BankPayout        BankPayout        `mapstructure:"bank_payout" json:"bank_payout" yaml:"bank_payout"`
+	Referral          Referral          `mapstructure:"referral" json:"referral" yaml:"referral"`
 }
 
 type Accrual struct {
@@ -194,6 +212,77 @@ type CommissionRelease struct {
 	LockTTLSecond int    `mapstructure:"lock_ttl_second" json:"lock_ttl_second" yaml:"lock_ttl_second"`
 	Timezone      string `mapstructure:"timezone" json:"timezone" yaml:"timezone"`
 	BatchSize     int    `mapstructure:"batch_size" json:"batch_size" yaml:"batch_size"`
+
+	HoldDays int `mapstructure:"hold_days" json:"hold_days" yaml:"hold_days"` // 订单佣金冻结期（天），到期后由解冻任务置为可提现
+}
+
+// Referral 推荐关系绑定策略：首次绑定生效，绑定后不可自行更换推荐人（管理员可迁移）
+type Referral struct {
+	BindWindowHours int `mapstructure:"bind_window_hours" json:"bind_window_hours" yaml:"bind_window_hours"` // 注册后多少小时内可绑定推荐人，0 不限
+}
+
+// WalletAudit 钱包余额巡检（每日重放流水核对 balance_after 链与钱包/账本余额）
+type WalletAudit struct {
+	Enabled       bool   `mapstructure:"enabled" json:"enabled" yaml:"enabled"`
+	Time          string `mapstructure:"time" json:"time" yaml:"time"` // HH:MM (24h)
+	UseRedisLock  bool   `mapstructure:"use_redis_lock" json:"use_redis_lock" yaml:"use_redis_lock"`
+	LockTTLSecond int    `mapstructure:"lock_ttl_second" json:"lock_ttl_second" yaml:"lock_ttl_second"`
+	Timezone      string `mapstructure:"timezone" json:"timezone" yaml:"timezone"`
+	OpenTickets   bool   `mapstructure:"open_tickets" json:"open_tickets" yaml:"open_tickets"` // 新发现的问题自动创建工单
+}
+
+// PointsExpiry 积分过期调度（每日作废到期积分批次并发送到期提醒，有效期规则见 points.rules）
+type PointsExpiry struct {
+	Enabled       bool   `mapstructure:"enabled" json:"enabled" yaml:"enabled"`
+	Time          string `mapstructure:"time" json:"time" yaml:"time"` // HH:MM (24h)
+	UseRedisLock  bool   `mapstructure:"use_redis_lock" json:"use_redis_lock" yaml:"use_redis_lock"`
+	LockTTLSecond int    `mapstructure:"lock_ttl_second" json:"lock_ttl_second" yaml:"lock_ttl_second"`
+	Timezone      string `mapstructure:"timezone" json:"timezone" yaml:"timezone"`
+	NoticeChannel string `mapstructure:"notice_channel" json:"notice_channel" yaml:"notice_channel"` // 到期提醒通知类型：sms / wechat / push
+}
+
+// BankPayout 银行批量代付文件配置：按银行配置付款文件版式与回盘文件解析规则
+type BankPayout struct {
+	DefaultLayout string                      `mapstructure:"default_layout" json:"default_layout" yaml:"default_layout"`
+	Layouts       map[string]BankPayoutLayout `mapstructure:"layouts" json:"layouts" yaml:"layouts"` // 为空时使用内置 generic_csv 版式
+}
+
+// BankPayoutLayout 单个银行的付款文件版式
+type BankPayoutLayout struct {
+	Name      string            `mapstructure:"name" json:"name" yaml:"name"`
+	Format    string            `mapstructure:"format" json:"format" yaml:"format"`          // csv / fixed（定长）
+	Delimiter string            `mapstructure:"delimiter" json:"delimiter" yaml:"delimiter"` // csv 分隔符，默认逗号
+	Header    bool              `mapstructure:"header" json:"header" yaml:"header"`          // csv 是否输出表头
+	Fields    []BankPayoutField `mapstructure:"fields" json:"fields" yaml:"fields"`
+	Result    BankPayoutResult  `mapstructure:"result" json:"result" yaml:"result"`
+}
+
+// BankPayoutField 付款文件字段：key 取值 line_no / batch_no / withdraw_no / account_name / account_no / bank_name / amount / amount_cents / remark / date
+type BankPayoutField struct {
+	Key   string `mapstructure:"key" json:"key" yaml:"key"`
+	Title string `mapstructure:"title" json:"title" yaml:"title"` // csv 表头
+	Width int    `mapstructure:"width" json:"width" yaml:"width"` // 定长宽度（中文按 2 位计）
+	Align string `mapstructure:"align" json:"align" yaml:"align"` // left / right，定长对齐方式
+	Pad   string `mapstructure:"pad" json:"pad" yaml:"pad"`       // 定长填充字符，默认空格
+}
+
+// BankPayoutResult 回盘文件解析规则（按分隔符切分，列号从 1 开始）
+type BankPayoutResult struct {
+	Delimiter     string   `mapstructure:"delimiter" json:"delimiter" yaml:"delimiter"`
+	SkipRows      int      `mapstructure:"skip_rows" json:"skip_rows" yaml:"skip_rows"`
+	RefColumn     int      `mapstructure:"ref_column" json:"ref_column" yaml:"ref_column"` // 提现单号所在列
+	StatusColumn  int      `mapstructure:"status_column" json:"status_column" yaml:"status_column"`
+	ReasonColumn  int      `mapstructure:"reason_column" json:"reason_column" yaml:"reason_column"`
+	AmountColumn  int      `mapstructure:"amount_column" json:"amount_column" yaml:"amount_column"`    // 金额所在列，须与代付明细金额一致
+	AmountUnit    string   `mapstructure:"amount_unit" json:"amount_unit" yaml:"amount_unit"`          // yuan（默认）/ cents
+	SuccessValues []string `mapstructure:"success_values" json:"success_values" yaml:"success_values"` // 表示成功的状态取值
+}
+
+// GiftCard 实体礼品卡配置
+type GiftCard struct {
+	PinSecret      string `mapstructure:"pin_secret" json:"pin_secret" yaml:"pin_secret"`                   // 卡密摘要与加密密钥，为空时使用 jwt.secret；上线后不可更换
+	MaxPinFailures int    `mapstructure:"max_pin_failures" json:"max_pin_failures" yaml:"max_pin_failures"` // 单卡连续卡密错误上限，达到后锁定
+	MaxBatchSize   int    `mapstructure:"max_batch_size" json:"max_batch_size" yaml:"max_batch_size"`       // 单批次最多制卡数量
 }
 
 // Withdrawal 提现费用与限额配置
@@ -203,6 +292,69 @@ type Withdrawal struct {
 	FeeRateBp      int64 `mapstructure:"fee_rate_bp" json:"fee_rate_bp" yaml:"fee_rate_bp"` // 手续费比例，基点（万分制），如 30 表示 0.30%
 	FeeMinCents    int64 `mapstructure:"fee_min_cents" json:"fee_min_cents" yaml:"fee_min_cents"`
 	FeeCapCents    int64 `mapstructure:"fee_cap_cents" json:"fee_cap_cents" yaml:"fee_cap_cents"`
+	// 风控：限额与频次（0 表示不限制），超出直接拒绝申请
+	DailyAmountCents   int64 `mapstructure:"daily_amount_cents" json:"daily_amount_cents" yaml:"daily_amount_cents"`
+	DailyCount         int64 `mapstructure:"daily_count" json:"daily_count" yaml:"daily_count"`
+	MonthlyAmountCents int64 `mapstructure:"monthly_amount_cents" json:"monthly_amount_cents" yaml:"monthly_amount_cents"`
+	MonthlyCount       int64 `mapstructure:"monthly_count" json:"monthly_count" yaml:"monthly_count"`
+	// 提现账户新增/删除后的冷静期（小时），期内不可提现
+	AccountCoolingHours int `mapstructure:"account_cooling_hours" json:"account_cooling_hours" yaml:"account_cooling_hours"`
+	// 要求实名：用户须已实名核验，且提现账户户名与实名一致
+	RequireRealName bool `mapstructure:"require_real_name" json:"require_real_name" yaml:"require_real_name"`
+	// 人工复核：新注册用户、大额提现、大额充值后短时间内提现
+	ReviewNewUserDays   int   `mapstructure:"review_new_user_days" json:"review_new_user_days" yaml:"review_new_user_days"`
+	ReviewAmountCents   int64 `mapstructure:"review_amount_cents" json:"review_amount_cents" yaml:"review_amount_cents"`
+	ReviewRechargeCents int64 `mapstructure:"review_recharge_cents" json:"review_recharge_cents" yaml:"review_recharge_cents"`
+	ReviewRechargeHours int   `mapstructure:"review_recharge_hours" json:"review_recharge_hours" yaml:"review_recharge_hours"`
+}
+
+// Invoice 电子发票配置
+type Invoice struct {
+	Provider    string `mapstructure:"provider" json:"provider" yaml:"provider"`       // 开票通道：local（本地占位文件）
+	OutputDir   string `mapstructure:"output_dir" json:"output_dir" yaml:"output_dir"` // local 通道生成文件的目录
+	PublicURL   string `mapstructure:"public_url" json:"public_url" yaml:"public_url"` // 生成文件对外访问前缀，为空时仅记录文件名
+	SellerName  string `mapstructure:"seller_name" json:"seller_name" yaml:"seller_name"`
+	SellerTaxNo string `mapstructure:"seller_tax_no" json:"seller_tax_no" yaml:"seller_tax_no"`
+}
+
+// Print 门店小票打印配置
+type Print struct {
+	CloudBaseURL   string `mapstructure:"cloud_base_url" json:"cloud_base_url" yaml:"cloud_base_url"` // 云打印平台接口地址
+	CloudAppID     string `mapstructure:"cloud_app_id" json:"cloud_app_id" yaml:"cloud_app_id"`
+	CloudAppSecret string `mapstructure:"cloud_app_secret" json:"cloud_app_secret" yaml:"cloud_app_secret"`
+	TimeoutSeconds int    `mapstructure:"timeout_seconds" json:"timeout_seconds" yaml:"timeout_seconds"`
+	FileSinkDir    string `mapstructure:"file_sink_dir" json:"file_sink_dir" yaml:"file_sink_dir"` // file 通道落地目录（联调/测试）
+}
+
+// Inventory 库存预占配置
+type Inventory struct {
+	ReserveTTLMinutes int  `mapstructure:"reserve_ttl_minutes" json:"reserve_ttl_minutes" yaml:"reserve_ttl_minutes"` // 未支付订单库存预占时长（分钟）
+	ExpireEnabled     bool `mapstructure:"expire_enabled" json:"expire_enabled" yaml:"expire_enabled"`                // 是否启用超时取消调度
+	ExpireScanSeconds int  `mapstructure:"expire_scan_seconds" json:"expire_scan_seconds" yaml:"expire_scan_seconds"` // 超时扫描间隔（秒）
+	ExpireBatchSize   int  `mapstructure:"expire_batch_size" json:"expire_batch_size" yaml:"expire_batch_size"`       // 每次扫描处理的订单数
+}
+
+// Payment 支付后台任务配置
+type Payment struct {
+	RefundRetryEnabled        bool `mapstructure:"refund_retry_enabled" json:"refund_retry_enabled" yaml:"refund_retry_enabled"`                         // 是否启用退款重试/同步调度
+	RefundRetryScanSeconds    int  `mapstructure:"refund_retry_scan_seconds" json:"refund_retry_scan_seconds" yaml:"refund_retry_scan_seconds"`          // 扫描间隔（秒）
+	RefundMaxRetries          int  `mapstructure:"refund_max_retries" json:"refund_max_retries" yaml:"refund_max_retries"`                               // 失败退款最大自动重试次数
+	RefundSyncAfterMinutes    int  `mapstructure:"refund_sync_after_minutes" json:"refund_sync_after_minutes" yaml:"refund_sync_after_minutes"`          // 处理中超过该时长未收到通知则主动查询
+	RefundBatchSize           int  `mapstructure:"refund_batch_size" json:"refund_batch_size" yaml:"refund_batch_size"`                                  // 每次扫描处理的退款数
+	ReconcileEnabled          bool `mapstructure:"reconcile_enabled" json:"reconcile_enabled" yaml:"reconcile_enabled"`                                  // 是否启用待支付单对账（主动查询渠道）
+	ReconcileScanSeconds      int  `mapstructure:"reconcile_scan_seconds" json:"reconcile_scan_seconds" yaml:"reconcile_scan_seconds"`                   // 对账扫描间隔（秒）
+	ReconcileAfterMinutes     int  `mapstructure:"reconcile_after_minutes" json:"reconcile_after_minutes" yaml:"reconcile_after_minutes"`                // 待支付超过该时长仍无回调才查询渠道
+	ReconcileBatchSize        int  `mapstructure:"reconcile_batch_size" json:"reconcile_batch_size" yaml:"reconcile_batch_size"`                         // 每次扫描处理的支付单数
+	DisableLegacySign         bool `mapstructure:"disable_legacy_sign" json:"disable_legacy_sign" yaml:"disable_legacy_sign"`                            // 关闭旧版 MD5(payment_no|trade_state|secret) 回调签名，仅接受 HMAC
+	NotifyReplayWindowSeconds int  `mapstructure:"notify_replay_window_seconds" json:"notify_replay_window_seconds" yaml:"notify_replay_window_seconds"` // 回调时间戳允许的偏差（秒）
+	CallbackRequireNonce      bool `mapstructure:"callback_require_nonce" json:"callback_require_nonce" yaml:"callback_require_nonce"`                   // JSON 回调必须携带 nonce 与 timestamp
+	// 提现微信商家转账
+	TransferEnabled          bool   `mapstructure:"transfer_enabled" json:"transfer_enabled" yaml:"transfer_enabled"`                                  // 审核通过后自动发起微信转账到零钱
+	TransferBaseURL          string `mapstructure:"transfer_base_url" json:"transfer_base_url" yaml:"transfer_base_url"`                               // 转账接口地址，为空沿用 wechat.api_base_url；联调可指向本地桩服务
+	TransferNotifyURL        string `mapstructure:"transfer_notify_url" json:"transfer_notify_url" yaml:"transfer_notify_url"`                         // 转账结果回调地址
+	TransferSyncScanSeconds  int    `mapstructure:"transfer_sync_scan_seconds" json:"transfer_sync_scan_seconds" yaml:"transfer_sync_scan_seconds"`    // 处理中转账同步扫描间隔（秒）
+	TransferSyncAfterMinutes int    `mapstructure:"transfer_sync_after_minutes" json:"transfer_sync_after_minutes" yaml:"transfer_sync_after_minutes"` // 处理中超过该时长未收到回调则主动查询
+	TransferBatchSize        int    `mapstructure:"transfer_batch_size" json:"transfer_batch_size" yaml:"transfer_batch_size"`                         // 每次扫描处理的转账单数
 }
 
 // Observability 可观测性配置
@@ -283,6 +435,82 @@ func LoadConfig(path string) error {
 	viper.SetDefault("finance.withdrawal.fee_min_cents", 100)     // 最低手续费 1 元
 	viper.SetDefault("finance.withdrawal.fee_cap_cents", 0)       // 封顶手续费（0 表示不封顶）
 
+	// Withdrawal risk defaults
+	viper.SetDefault("finance.withdrawal.daily_amount_cents", 2000000)    // 单日累计 2 万元
+	viper.SetDefault("finance.withdrawal.daily_count", 3)                 // 单日 3 笔
+	viper.SetDefault("finance.withdrawal.monthly_amount_cents", 20000000) // 单月累计 20 万元
+	viper.SetDefault("finance.withdrawal.monthly_count", 30)              // 单月 30 笔
+	viper.SetDefault("finance.withdrawal.account_cooling_hours", 24)
+	viper.SetDefault("finance.withdrawal.require_real_name", false)
+	viper.SetDefault("finance.withdrawal.review_new_user_days", 7)
+	viper.SetDefault("finance.withdrawal.review_amount_cents", 500000)   // 单笔 5000 元及以上
+	viper.SetDefault("finance.withdrawal.review_recharge_cents", 100000) // 充值 1000 元及以上
+	viper.SetDefault("finance.withdrawal.review_recharge_hours", 48)
+
+	// Commission defaults
+	viper.SetDefault("finance.commission_release.hold_days", 7)
+	viper.SetDefault("finance.referral.bind_window_hours", 72)
+
+	// Wallet audit defaults
+	viper.SetDefault("finance.wallet_audit.enabled", false)
+	viper.SetDefault("finance.wallet_audit.time", "04:30")
+	viper.SetDefault("finance.wallet_audit.use_redis_lock", true)
+	viper.SetDefault("finance.wallet_audit.lock_ttl_second", 3600)
+	viper.SetDefault("finance.wallet_audit.open_tickets", false)
+
+	// Points expiry defaults
+	viper.SetDefault("finance.points_expiry.enabled", false)
+	viper.SetDefault("finance.points_expiry.time", "00:10")
+	viper.SetDefault("finance.points_expiry.use_redis_lock", true)
+	viper.SetDefault("finance.points_expiry.lock_ttl_second", 3600)
+	viper.SetDefault("finance.points_expiry.notice_channel", "wechat")
+
+	// Gift card defaults
+	viper.SetDefault("finance.gift_card.max_pin_failures", 5)
+	viper.SetDefault("finance.gift_card.max_batch_size", 5000)
+
+	// Invoice defaults
+	viper.SetDefault("invoice.provider", "local")
+	viper.SetDefault("invoice.output_dir", "uploads/invoices")
+
+	// WeChat Pay defaults
+	viper.SetDefault("wechat.pay_mode", "mock")
+	viper.SetDefault("wechat.api_base_url", "https://api.mch.weixin.qq.com")
+
+	// Alipay defaults
+	viper.SetDefault("alipay.pay_mode", "mock")
+	viper.SetDefault("alipay.gateway_url", "https://openapi.alipay.com/gateway.do")
+
+	// Print defaults
+	viper.SetDefault("print.timeout_seconds", 5)
+	viper.SetDefault("print.file_sink_dir", "uploads/prints")
+
+	// Inventory defaults
+	viper.SetDefault("inventory.reserve_ttl_minutes", 30)
+	viper.SetDefault("inventory.expire_enabled", true)
+	viper.SetDefault("inventory.expire_scan_seconds", 60)
+	viper.SetDefault("inventory.expire_batch_size", 100)
+
+	// Payment defaults
+	viper.SetDefault("payment.refund_retry_enabled", true)
+	viper.SetDefault("payment.refund_retry_scan_seconds", 60)
+	viper.SetDefault("payment.refund_max_retries", 5)
+	viper.SetDefault("payment.refund_sync_after_minutes", 10)
+	viper.SetDefault("payment.refund_batch_size", 50)
+	viper.SetDefault("payment.reconcile_enabled", true)
+	viper.SetDefault("payment.reconcile_scan_seconds", 120)
+	viper.SetDefault("payment.reconcile_after_minutes", 5)
+	viper.SetDefault("payment.reconcile_batch_size", 100)
+	viper.SetDefault("payment.disable_legacy_sign", false)
+	viper.SetDefault("payment.notify_replay_window_seconds", 300)
+	viper.SetDefault("payment.callback_require_nonce", false)
+	viper.SetDefault("payment.transfer_enabled", false)
+	viper.SetDefault("payment.transfer_base_url", "")
+	viper.SetDefault("payment.transfer_notify_url", "")
+	viper.SetDefault("payment.transfer_sync_scan_seconds", 120)
+	viper.SetDefault("payment.transfer_sync_after_minutes", 5)
+	viper.SetDefault("payment.transfer_batch_size", 50)
+
 	if err := viper.ReadInConfig(); err != nil {
 		return fmt.Errorf("failed to read config file: %w", err)
 	}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+
+	"tea-api/internal/service"
+	"tea-api/pkg/utils"
+)
+
+// 对账单上传大小上限（日账单通常在几 MB 以内）
+const maxSettlementFileSize = 20 << 20
+
+// POST /api/v1/admin/finance/settlements/import
+// multipart 表单：provider=wechat|alipay，file=渠道下载的日账单 CSV
+func (h *FinanceReportHandler) ImportSettlement(c *gin.Context) {
+	provider := strings.ToLower(strings.TrimSpace(c.PostForm("provider")))
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		utils.InvalidParam(c, "请选择要导入的对账单文件")
+		return
+	}
+	defer file.Close()
+	if header.Size > maxSettlementFileSize {
+		utils.InvalidParam(c, "对账单文件过大")
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxSettlementFileSize+1))
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	uidVal, _ := c.Get("user_id")
+	operatorID, _ := uidVal.(uint)
+	res, err := service.NewSettlementService().ImportStatement(provider, data, operatorID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	_ = writeOpLog(c, operatorID, "finance", "settlement.import", map[string]any{
+		"provider": provider,
+		"filename": header.Filename,
+		"batch_no": res.BatchNo,
+		"lines":    res.Lines,
+	})
+	utils.Success(c, res)
+}
+
+// parseSettlementQuery 解析对账区间：start/end 为 YYYY-MM-DD（end 含当天），默认昨天
+func parseSettlementQuery(c *gin.Context) (service.SettlementMatchQuery, error) {
+	q := service.SettlementMatchQuery{Provider: strings.ToLower(strings.TrimSpace(c.Query("provider")))}
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	q.Start, q.End = today.AddDate(0, 0, -1), today
+	if s := strings.TrimSpace(c.Query("start")); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return q, errors.New("start 参数非法")
+		}
+		q.Start = t
+		q.End = t.AddDate(0, 0, 1)
+	}
+	if s := strings.TrimSpace(c.Query("end")); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return q, errors.New("end 参数非法")
+		}
+		q.End = t.AddDate(0, 0, 1)
+	}
+	if !q.End.After(q.Start) {
+		return q, errors.New("结束日期不能早于开始日期")
+	}
+	return q, nil
+}
+
+// GET /api/v1/admin/finance/settlements/match
+// 渠道对账单与本地支付/退款三方核对：provider, start, end, kind(missing_local|missing_provider|amount_mismatch), page, limit
+func (h *FinanceReportHandler) SettlementMatch(c *gin.Context) {
+	q, err := parseSettlementQuery(c)
+	if err != nil {
+		utils.InvalidParam(c, err.Error())
+		return
+	}
+	report, err := service.NewSettlementService().MatchReport(q)
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	rows := report.Rows
+	if kind := strings.TrimSpace(c.Query("kind")); kind != "" {
+		filtered := make([]service.SettlementMatchRow, 0)
+		for _, r := range rows {
+			if r.Kind == kind {
+				filtered = append(filtered, r)
+			}
+		}
+		rows = filtered
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page <= 0 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit <= 0 || limit > 200 {
+		limit = 20
+	}
+	total := len(rows)
+	startIdx := (page - 1) * limit
+	if startIdx > total {
+		startIdx = total
+	}
+	endIdx := startIdx + limit
+	if endIdx > total {
+		endIdx = total
+	}
+	utils.Success(c, gin.H{
+		"missing_local":    report.MissingLocal,
+		"missing_provider": report.MissingProvider,
+		"amount_mismatch":  report.AmountMismatch,
+		"totals":           report.Totals,
+		"total":            total,
+		"rows":             rows[startIdx:endIdx],
+	})
+}
+
+// GET /api/v1/admin/finance/settlements/match/export?format=csv|xlsx
+// 导出差异明细，xlsx 额外包含渠道汇总（含手续费）工作表
+func (h *FinanceReportHandler) ExportSettlementMatch(c *gin.Context) {
+	q, err := parseSettlementQuery(c)
+	if err != nil {
+		utils.InvalidParam(c, err.Error())
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(c.DefaultQuery("format", "csv")))
+	report, err := service.NewSettlementService().MatchReport(q)
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+
+	headers := []string{"Kind", "Provider", "Type", "Payment No", "Refund No", "Transaction ID", "Order ID", "Order No",
+		"Provider Amount", "Local Amount", "Diff Amount", "Local Status", "Trade Time"}
+	rowValues := func(r service.SettlementMatchRow) []any {
+		tradeTime := ""
+		if r.TradeTime != nil {
+			tradeTime = r.TradeTime.Format("2006-01-02 15:04:05")
+		}
+		return []any{r.Kind, r.Provider, r.LineType, r.PaymentNo, r.RefundNo, r.TransactionID, r.OrderID, r.OrderNo,
+			r.ProviderAmount.String(), r.LocalAmount.String(), r.DiffAmount.String(), r.LocalStatus, tradeTime}
+	}
+	filename := "settlement_match_" + q.Start.Format("20060102") + "_" + q.End.AddDate(0, 0, -1).Format("20060102")
+
+	if format == "xlsx" {
+		xf := excelize.NewFile()
+		sheet := xf.GetSheetName(0)
+		for i, h := range headers {
+			col, _ := excelize.ColumnNumberToName(i + 1)
+			_ = xf.SetCellValue(sheet, col+"1", h)
+		}
+		for idx, r := range report.Rows {
+			for j, v := range rowValues(r) {
+				col, _ := excelize.ColumnNumberToName(j + 1)
+				_ = xf.SetCellValue(sheet, col+fmt.Sprintf("%d", idx+2), v)
+			}
+		}
+		totalsSheet := "Totals"
+		_, _ = xf.NewSheet(totalsSheet)
+		totalHeaders := []string{"Provider", "Payment Count", "Payment Amount", "Refund Count", "Refund Amount", "Fee", "Settled Amount",
+			"Local Payment Count", "Local Payment Amount", "Local Refund Count", "Local Refund Amount"}
+		for i, h := range totalHeaders {
+			col, _ := excelize.ColumnNumberToName(i + 1)
+			_ = xf.SetCellValue(totalsSheet, col+"1", h)
+		}
+		for idx, t := range report.Totals {
+			row := []any{t.Provider, t.PaymentCount, t.PaymentAmount.String(), t.RefundCount, t.RefundAmount.String(), t.Fee.String(),
+				t.SettledAmount.String(), t.LocalPaymentCount, t.LocalPaymentAmount.String(), t.LocalRefundCount, t.LocalRefundAmount.String()}
+			for j, v := range row {
+				col, _ := excelize.ColumnNumberToName(j + 1)
+				_ = xf.SetCellValue(totalsSheet, col+fmt.Sprintf("%d", idx+2), v)
+			}
+		}
+		var buf bytes.Buffer
+		if err := xf.Write(&buf); err != nil {
+			utils.Error(c, utils.CodeError, err.Error())
+			return
+		}
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		c.Header("Content-Disposition", "attachment; filename="+url.PathEscape(filename+".xlsx"))
+		_, _ = c.Writer.Write(buf.Bytes())
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename="+url.PathEscape(filename+".csv"))
+	_, _ = c.Writer.WriteString("kind,provider,type,payment_no,refund_no,transaction_id,order_id,order_no,provider_amount,local_amount,diff_amount,local_status,trade_time\n")
+	for _, r := range report.Rows {
+		vals := rowValues(r)
+		cells := make([]string, len(vals))
+		for i, v := range vals {
+			cells[i] = csvSafePay(fmt.Sprint(v))
+		}
+		_, _ = c.Writer.WriteString(strings.Join(cells, ",") + "\n")
+	}
+	// 渠道汇总（含手续费）附在明细之后
+	_, _ = c.Writer.WriteString("\nprovider,payment_count,payment_amount,refund_count,refund_amount,fee,settled_amount,local_payment_count,local_payment_amount,local_refund_count,local_refund_amount\n")
+	for _, t := range report.Totals {
+		line := fmt.Sprintf("%s,%d,%s,%d,%s,%s,%s,%d,%s,%d,%s\n", t.Provider, t.PaymentCount, t.PaymentAmount.String(), t.RefundCount,
+			t.RefundAmount.String(), t.Fee.String(), t.SettledAmount.String(), t.LocalPaymentCount, t.LocalPaymentAmount.String(),
+			t.LocalRefundCount, t.LocalRefundAmount.String())
+		_, _ = c.Writer.WriteString(line)
+	}
+}
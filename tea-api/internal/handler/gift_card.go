@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"tea-api/internal/service"
+	"tea-api/pkg/response"
+)
+
+type giftCardCredentialReq struct {
+	CardNo string `json:"card_no" binding:"required"`
+	Pin    string `json:"pin" binding:"required"`
+}
+
+// QueryGiftCard POST /api/v1/gift-cards/query
+// 凭卡号与卡密查询面值、余额、状态与有效期（卡密错误计入失败次数）。
+func QueryGiftCard(c *gin.Context) {
+	var req giftCardCredentialReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	info, err := service.NewGiftCardService().Lookup(req.CardNo, req.Pin)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, info)
+}
+
+// RedeemGiftCard POST /api/v1/gift-cards/redeem
+// 兑换礼品卡，卡内余额一次性转入钱包余额。
+func RedeemGiftCard(c *gin.Context) {
+	uidVal, _ := c.Get("user_id")
+	userID, _ := uidVal.(uint)
+	var req giftCardCredentialReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	res, err := service.NewGiftCardService().Redeem(userID, req.CardNo, req.Pin)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, res)
+}
+
+// ListMyGiftCards GET /api/v1/gift-cards?page=&limit=
+// 返回当前用户已兑换的礼品卡（分页）。
+func ListMyGiftCards(c *gin.Context) {
+	uidVal, _ := c.Get("user_id")
+	userID, _ := uidVal.(uint)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	list, total, err := service.NewGiftCardService().ListUserCards(userID, page, limit)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.SuccessWithPagination(c, list, total, page, limit)
+}
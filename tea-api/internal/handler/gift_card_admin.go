@@ -0,0 +1,312 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service"
+	"tea-api/pkg/database"
+	"tea-api/pkg/response"
+)
+
+type GiftCardAdminHandler struct {
+	db *gorm.DB
+}
+
+func NewGiftCardAdminHandler() *GiftCardAdminHandler {
+	return &GiftCardAdminHandler{db: database.GetDB()}
+}
+
+type giftCardBatchReq struct {
+	Name           string `json:"name" binding:"required"`
+	FaceValueCents int64  `json:"face_value_cents" binding:"required"`
+	Quantity       int    `json:"quantity" binding:"required"`
+	StoreID        uint   `json:"store_id"`    // 0 表示平台通用
+	ValidFrom      string `json:"valid_from"`  // YYYY-MM-DD，当天 00:00 起可兑换
+	ValidUntil     string `json:"valid_until"` // YYYY-MM-DD，当天 23:59:59 后过期
+	Remark         string `json:"remark"`
+}
+
+type giftCardBatchActionReq struct {
+	Remark string `json:"remark"`
+}
+
+func adminOperatorID(c *gin.Context) uint {
+	uidVal, _ := c.Get("user_id")
+	uid, _ := uidVal.(uint)
+	return uid
+}
+
+// CreateBatch POST /api/v1/admin/gift-cards/batches
+// 创建礼品卡批次并生成卡号卡密，卡密通过导出接口获取用于印刷。
+func (h *GiftCardAdminHandler) CreateBatch(c *gin.Context) {
+	var req giftCardBatchReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	in := service.GiftCardBatchInput{
+		Name:           req.Name,
+		FaceValueCents: req.FaceValueCents,
+		Quantity:       req.Quantity,
+		StoreID:        req.StoreID,
+		Remark:         req.Remark,
+	}
+	if s := strings.TrimSpace(req.ValidFrom); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			response.BadRequest(c, "valid_from 格式应为 YYYY-MM-DD")
+			return
+		}
+		in.ValidFrom = &t
+	}
+	if s := strings.TrimSpace(req.ValidUntil); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			response.BadRequest(c, "valid_until 格式应为 YYYY-MM-DD")
+			return
+		}
+		end := t.AddDate(0, 0, 1).Add(-time.Second)
+		in.ValidUntil = &end
+	}
+	batch, err := service.NewGiftCardService().CreateBatch(in, adminOperatorID(c))
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, batch)
+}
+
+// ListBatches GET /api/v1/admin/gift-cards/batches?status=&store_id=&keyword=&page=&limit=
+func (h *GiftCardAdminHandler) ListBatches(c *gin.Context) {
+	status := strings.TrimSpace(c.Query("status"))
+	storeID := strings.TrimSpace(c.Query("store_id"))
+	keyword := strings.TrimSpace(c.Query("keyword"))
+	page := toInt(c.DefaultQuery("page", "1"))
+	limit := toInt(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 20
+	}
+
+	q := h.db.Model(&model.GiftCardBatch{})
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if storeID != "" {
+		q = q.Where("store_id = ?", storeID)
+	}
+	if keyword != "" {
+		q = q.Where("batch_no LIKE ? OR name LIKE ?", "%"+keyword+"%", "%"+keyword+"%")
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var list []model.GiftCardBatch
+	if err := q.Order("id desc").Limit(limit).Offset((page - 1) * limit).Find(&list).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.SuccessWithPagination(c, list, total, page, limit)
+}
+
+// GetBatch GET /api/v1/admin/gift-cards/batches/:id
+// 返回批次信息及各状态卡数（1待兑换 2已兑换 3已冻结 4已作废）。
+func (h *GiftCardAdminHandler) GetBatch(c *gin.Context) {
+	id := parseUintParam(c, "id")
+	var batch model.GiftCardBatch
+	if id == 0 || h.db.First(&batch, id).Error != nil {
+		response.NotFound(c, "礼品卡批次不存在")
+		return
+	}
+	counts, err := service.NewGiftCardService().BatchStatusCounts(batch.ID)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"batch": batch, "status_counts": counts})
+}
+
+// ExportBatch GET /api/v1/admin/gift-cards/batches/:id/export?format=csv|xlsx
+// 导出批次卡号与明文卡密用于制卡印刷，每次导出均记录操作日志。
+func (h *GiftCardAdminHandler) ExportBatch(c *gin.Context) {
+	id := parseUintParam(c, "id")
+	if id == 0 {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(c.DefaultQuery("format", "csv")))
+	batch, rows, err := service.NewGiftCardService().ExportBatch(id)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	_ = writeOpLog(c, adminOperatorID(c), "gift_card", "batch.export", map[string]any{
+		"batch_id":     batch.ID,
+		"batch_no":     batch.BatchNo,
+		"cards":        len(rows),
+		"export_count": batch.ExportCount,
+	})
+
+	validUntil := ""
+	if batch.ValidUntil != nil {
+		validUntil = batch.ValidUntil.Format("2006-01-02")
+	}
+	filename := "gift_cards_" + batch.BatchNo
+	if format == "xlsx" {
+		xf := excelize.NewFile()
+		sheet := xf.GetSheetName(0)
+		headers := []string{"Card No", "PIN", "Face Value", "Valid Until", "Status"}
+		for i, hname := range headers {
+			col, _ := excelize.ColumnNumberToName(i + 1)
+			_ = xf.SetCellValue(sheet, col+"1", hname)
+		}
+		for idx, r := range rows {
+			row := []any{r.CardNo, r.Pin, fmt.Sprintf("%.2f", float64(r.FaceValueCents)/100), validUntil, r.Status}
+			for j, v := range row {
+				col, _ := excelize.ColumnNumberToName(j + 1)
+				// 卡号卡密按文本写入，避免被表格软件转成科学计数法或丢失前导零
+				if s, ok := v.(string); ok && j < 2 {
+					_ = xf.SetCellStr(sheet, col+fmt.Sprintf("%d", idx+2), s)
+					continue
+				}
+				_ = xf.SetCellValue(sheet, col+fmt.Sprintf("%d", idx+2), v)
+			}
+		}
+		var buf bytes.Buffer
+		if err := xf.Write(&buf); err != nil {
+			response.Error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		c.Header("Content-Disposition", "attachment; filename="+url.PathEscape(filename+".xlsx"))
+		_, _ = c.Writer.Write(buf.Bytes())
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename="+url.PathEscape(filename+".csv"))
+	_, _ = c.Writer.WriteString("card_no,pin,face_value,valid_until,status\n")
+	for _, r := range rows {
+		_, _ = c.Writer.WriteString(fmt.Sprintf("%s,%s,%.2f,%s,%d\n",
+			r.CardNo, r.Pin, float64(r.FaceValueCents)/100, validUntil, r.Status))
+	}
+}
+
+func (h *GiftCardAdminHandler) batchAction(c *gin.Context, fn func(batchID, operatorID uint, remark string) (int, error)) {
+	id := parseUintParam(c, "id")
+	if id == 0 {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	var req giftCardBatchActionReq
+	_ = c.ShouldBindJSON(&req)
+	n, err := fn(id, adminOperatorID(c), strings.TrimSpace(req.Remark))
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"ok": true, "cards": n})
+}
+
+// FreezeBatch POST /api/v1/admin/gift-cards/batches/:id/freeze
+func (h *GiftCardAdminHandler) FreezeBatch(c *gin.Context) {
+	h.batchAction(c, service.NewGiftCardService().FreezeBatch)
+}
+
+// UnfreezeBatch POST /api/v1/admin/gift-cards/batches/:id/unfreeze
+func (h *GiftCardAdminHandler) UnfreezeBatch(c *gin.Context) {
+	h.batchAction(c, service.NewGiftCardService().UnfreezeBatch)
+}
+
+// VoidBatch POST /api/v1/admin/gift-cards/batches/:id/void
+// 作废批次内未兑换的卡，已兑换的金额不追回。
+func (h *GiftCardAdminHandler) VoidBatch(c *gin.Context) {
+	h.batchAction(c, service.NewGiftCardService().VoidBatch)
+}
+
+// ListCards GET /api/v1/admin/gift-cards?batch_id=&status=&card_no=&user_id=&page=&limit=
+func (h *GiftCardAdminHandler) ListCards(c *gin.Context) {
+	batchID := strings.TrimSpace(c.Query("batch_id"))
+	status := strings.TrimSpace(c.Query("status"))
+	cardNo := strings.TrimSpace(c.Query("card_no"))
+	userID := strings.TrimSpace(c.Query("user_id"))
+	page := toInt(c.DefaultQuery("page", "1"))
+	limit := toInt(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 20
+	}
+
+	q := h.db.Model(&model.GiftCard{})
+	if batchID != "" {
+		q = q.Where("batch_id = ?", batchID)
+	}
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if cardNo != "" {
+		q = q.Where("card_no = ?", cardNo)
+	}
+	if userID != "" {
+		q = q.Where("redeemed_by = ?", userID)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var list []model.GiftCard
+	if err := q.Order("id desc").Limit(limit).Offset((page - 1) * limit).Find(&list).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.SuccessWithPagination(c, list, total, page, limit)
+}
+
+// GetCard GET /api/v1/admin/gift-cards/:id
+// 返回礼品卡信息及流水。
+func (h *GiftCardAdminHandler) GetCard(c *gin.Context) {
+	id := parseUintParam(c, "id")
+	var card model.GiftCard
+	if id == 0 || h.db.First(&card, id).Error != nil {
+		response.NotFound(c, "礼品卡不存在")
+		return
+	}
+	txs, err := service.NewGiftCardService().CardTransactions(card.ID)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"card": card, "transactions": txs})
+}
+
+// ResetPinFailures POST /api/v1/admin/gift-cards/:id/reset-pin-failures
+// 核实持卡人后解除卡密错误锁定。
+func (h *GiftCardAdminHandler) ResetPinFailures(c *gin.Context) {
+	id := parseUintParam(c, "id")
+	if id == 0 {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	if err := service.NewGiftCardService().ResetPinFailures(id); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"ok": true})
+}
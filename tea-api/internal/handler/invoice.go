@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service"
+	"tea-api/pkg/response"
+)
+
+type InvoiceHandler struct{ svc *service.InvoiceService }
+
+func NewInvoiceHandler() *InvoiceHandler { return &InvoiceHandler{svc: service.NewInvoiceService()} }
+
+type invoiceTitleReq struct {
+	TitleType   int    `json:"title_type" binding:"required"` // 1个人 2企业
+	Name        string `json:"name" binding:"required"`
+	TaxNo       string `json:"tax_no"`
+	Address     string `json:"address"`
+	Phone       string `json:"phone"`
+	BankName    string `json:"bank_name"`
+	BankAccount string `json:"bank_account"`
+	Email       string `json:"email"`
+	IsDefault   bool   `json:"is_default"`
+}
+
+func (r invoiceTitleReq) toModel() model.InvoiceTitle {
+	return model.InvoiceTitle{
+		TitleType:   r.TitleType,
+		Name:        r.Name,
+		TaxNo:       r.TaxNo,
+		Address:     r.Address,
+		Phone:       r.Phone,
+		BankName:    r.BankName,
+		BankAccount: r.BankAccount,
+		Email:       r.Email,
+		IsDefault:   r.IsDefault,
+	}
+}
+
+// ListTitles GET /api/v1/invoice-titles
+func (h *InvoiceHandler) ListTitles(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		response.Unauthorized(c, "未获取到用户身份")
+		return
+	}
+	list, err := h.svc.ListTitles(uid)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, list)
+}
+
+// CreateTitle POST /api/v1/invoice-titles
+func (h *InvoiceHandler) CreateTitle(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		response.Unauthorized(c, "未获取到用户身份")
+		return
+	}
+	var req invoiceTitleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	t := req.toModel()
+	if err := h.svc.SaveTitle(uid, &t); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, t)
+}
+
+// UpdateTitle PUT /api/v1/invoice-titles/:id
+func (h *InvoiceHandler) UpdateTitle(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		response.Unauthorized(c, "未获取到用户身份")
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || id == 0 {
+		response.BadRequest(c, "非法ID")
+		return
+	}
+	var req invoiceTitleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	t := req.toModel()
+	t.ID = uint(id)
+	if err := h.svc.SaveTitle(uid, &t); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, t)
+}
+
+// DeleteTitle DELETE /api/v1/invoice-titles/:id
+func (h *InvoiceHandler) DeleteTitle(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		response.Unauthorized(c, "未获取到用户身份")
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || id == 0 {
+		response.BadRequest(c, "非法ID")
+		return
+	}
+	if err := h.svc.DeleteTitle(uid, uint(id)); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"ok": true})
+}
+
+// Request POST /api/v1/invoices
+// 对一笔或多笔已完成订单申请发票，多笔订单合并开具
+func (h *InvoiceHandler) Request(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		response.Unauthorized(c, "未获取到用户身份")
+		return
+	}
+	var req struct {
+		TitleID  uint   `json:"title_id" binding:"required"`
+		OrderIDs []uint `json:"order_ids" binding:"required"`
+		Email    string `json:"email"`
+		Remark   string `json:"remark"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.OrderIDs) == 0 {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	inv, err := h.svc.RequestForOrders(uid, req.TitleID, req.OrderIDs, req.Email, req.Remark)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, inv)
+}
+
+// ListMine GET /api/v1/invoices
+func (h *InvoiceHandler) ListMine(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		response.Unauthorized(c, "未获取到用户身份")
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	status, _ := strconv.Atoi(c.Query("status"))
+	list, total, err := h.svc.ListInvoices(uid, status, c.Query("source_type"), "", page, limit)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.SuccessWithPagination(c, list, total, page, limit)
+}
+
+// GetMine GET /api/v1/invoices/:id
+func (h *InvoiceHandler) GetMine(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		response.Unauthorized(c, "未获取到用户身份")
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || id == 0 {
+		response.BadRequest(c, "非法ID")
+		return
+	}
+	inv, err := h.svc.GetInvoice(uid, uint(id))
+	if err != nil {
+		response.Error(c, http.StatusNotFound, err.Error())
+		return
+	}
+	response.Success(c, inv)
+}
+
+// DownloadMine GET /api/v1/invoices/:id/download?format=pdf|xml
+func (h *InvoiceHandler) DownloadMine(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		response.Unauthorized(c, "未获取到用户身份")
+		return
+	}
+	h.download(c, uid)
+}
+
+// AdminList GET /api/v1/admin/invoices
+func (h *InvoiceHandler) AdminList(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	status, _ := strconv.Atoi(c.Query("status"))
+	var userID uint
+	if v, err := strconv.ParseUint(c.Query("user_id"), 10, 32); err == nil {
+		userID = uint(v)
+	}
+	list, total, err := h.svc.ListInvoices(userID, status, c.Query("source_type"), c.Query("keyword"), page, limit)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.SuccessWithPagination(c, list, total, page, limit)
+}
+
+// AdminDetail GET /api/v1/admin/invoices/:id
+func (h *InvoiceHandler) AdminDetail(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || id == 0 {
+		response.BadRequest(c, "非法ID")
+		return
+	}
+	inv, err := h.svc.GetInvoice(0, uint(id))
+	if err != nil {
+		response.Error(c, http.StatusNotFound, err.Error())
+		return
+	}
+	response.Success(c, inv)
+}
+
+// AdminApprove POST /api/v1/admin/invoices/:id/approve
+// 审核通过并立即开具；开具失败的申请可重复调用重试
+func (h *InvoiceHandler) AdminApprove(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || id == 0 {
+		response.BadRequest(c, "非法ID")
+		return
+	}
+	operatorID, _ := currentUserID(c)
+	inv, err := h.svc.ApproveAndIssue(c.Request.Context(), uint(id), operatorID)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeOpLog(c, operatorID, "invoice", "approve", map[string]any{"invoice_id": inv.ID, "invoice_no": inv.InvoiceNo})
+	response.Success(c, inv)
+}
+
+// AdminReject POST /api/v1/admin/invoices/:id/reject
+func (h *InvoiceHandler) AdminReject(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || id == 0 {
+		response.BadRequest(c, "非法ID")
+		return
+	}
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请填写驳回原因")
+		return
+	}
+	operatorID, _ := currentUserID(c)
+	if err := h.svc.Reject(uint(id), operatorID, req.Reason); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeOpLog(c, operatorID, "invoice", "reject", map[string]any{"invoice_id": id, "reason": req.Reason})
+	response.Success(c, gin.H{"ok": true})
+}
+
+// AdminDownload GET /api/v1/admin/invoices/:id/download?format=pdf|xml
+func (h *InvoiceHandler) AdminDownload(c *gin.Context) { h.download(c, 0) }
+
+func (h *InvoiceHandler) download(c *gin.Context, userID uint) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || id == 0 {
+		response.BadRequest(c, "非法ID")
+		return
+	}
+	inv, err := h.svc.GetInvoice(userID, uint(id))
+	if err != nil {
+		response.Error(c, http.StatusNotFound, err.Error())
+		return
+	}
+	format := c.DefaultQuery("format", "pdf")
+	url, path, err := service.InvoiceFileLocation(inv, format)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if url != "" {
+		c.Redirect(http.StatusFound, url)
+		return
+	}
+	ext := ".pdf"
+	if format == "xml" {
+		ext = ".xml"
+	}
+	c.FileAttachment(path, inv.InvoiceNo+ext)
+}
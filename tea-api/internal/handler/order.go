@@ -10,16 +10,16 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/shopspring/decimal"
 
+	"tea-api/internal/config"
 	"tea-api/internal/model"
 	"tea-api/internal/service"
-	"tea-api/internal/service/commission"
 	"tea-api/pkg/database"
 	"tea-api/pkg/response"
 )
 
 // orderService 定义了 OrderHandler 所需的服务方法，便于在测试中注入 fake 实现。
 type orderService interface {
-	CreateOrderFromCart(userID uint, deliveryType int, addressInfo, remark string, userCouponID uint, storeID uint, orderType int) (*model.Order, error)
+	CreateOrderFromCart(userID uint, deliveryType int, addressInfo, remark string, userCouponID uint, storeID uint, orderType int, deliveryTime *time.Time, usePoints int64) (*model.Order, error)
 	ListOrders(userID uint, status int, page, limit int, storeID uint) ([]model.Order, int64, error)
 	GetOrder(userID, orderID uint) (*model.Order, []model.OrderItem, error)
 	AdminListOrders(status int, page, limit int, storeID uint, startTime, endTime *time.Time) ([]model.Order, int64, error)
@@ -49,7 +49,9 @@ type createOrderReq struct {
 	Remark       string `json:"remark"`
 	UserCouponID uint   `json:"user_coupon_id"`
 	StoreID      uint   `json:"store_id"`
-	OrderType    int    `json:"order_type"` // 1商城 2堂食 3外卖
+	OrderType    int    `json:"order_type"`    // 1商城 2堂食 3外卖
+	DeliveryTime string `json:"delivery_time"` // 预约自取/配送时间（RFC3339），需为门店可预约时段的开始时间
+	UsePoints    int64  `json:"use_points"`    // 使用积分抵扣（可选），实际抵扣按积分规则计算
 }
 
 // availableCouponsReq 查询当前订单可用优惠券的请求体（最小版，仅按金额与门店过滤）
@@ -68,7 +70,16 @@ func (h *OrderHandler) CreateFromCart(c *gin.Context) {
 		response.BadRequest(c, "参数错误")
 		return
 	}
-	order, err := h.svc.CreateOrderFromCart(userID, req.DeliveryType, req.AddressInfo, req.Remark, req.UserCouponID, req.StoreID, req.OrderType)
+	var deliveryTime *time.Time
+	if req.DeliveryTime != "" {
+		t, err := time.Parse(time.RFC3339, req.DeliveryTime)
+		if err != nil {
+			response.BadRequest(c, "delivery_time 格式错误，应为 RFC3339")
+			return
+		}
+		deliveryTime = &t
+	}
+	order, err := h.svc.CreateOrderFromCart(userID, req.DeliveryType, req.AddressInfo, req.Remark, req.UserCouponID, req.StoreID, req.OrderType, deliveryTime, req.UsePoints)
 	if err != nil {
 		response.Error(c, http.StatusBadRequest, err.Error())
 		return
@@ -357,8 +368,12 @@ func (h *OrderHandler) Cancel(c *gin.Context) {
 	response.Success(c, gin.H{"ok": true})
 }
 
-// Pay 模拟支付
+// Pay 模拟支付（仅 local/dev 生效）
 func (h *OrderHandler) Pay(c *gin.Context) {
+	if env := config.Config.System.Env; env != "local" && env != "dev" {
+		response.Forbidden(c, "仅开发环境可用")
+		return
+	}
 	uidVal, _ := c.Get("user_id")
 	userID := uint(uidVal.(uint))
 	oid, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -533,20 +548,6 @@ func (h *OrderHandler) AdminRefundConfirm(c *gin.Context) {
 		return
 	}
 
-	// 退款完成后尝试回滚该订单对应的未提现佣金
-	// 若回滚失败，不阻塞退款，仅写一条操作日志供财务后续人工处理
-	var opIDPtr *uint
-	if operatorID != 0 {
-		opIDPtr = &operatorID
-	}
-	rollbackNote := fmt.Sprintf("order refund confirm: %s", req.Reason)
-	if _, err := commission.ReverseOrderCommissions(uint(oid), opIDPtr, rollbackNote); err != nil {
-		_ = writeOpLog(c, operatorID, "finance", "commission.rollback_failed", map[string]any{
-			"order_id": uint(oid),
-			"reason":   req.Reason,
-			"error":    err.Error(),
-		})
-	}
 	var after model.Order
 	_ = database.GetDB().First(&after, uint(oid)).Error
 	_ = writeOpLog(c, operatorID, "finance", "order.refund_confirm", map[string]any{
@@ -26,7 +26,7 @@ type fakeOrderService struct {
 	errToReturn    error
 }
 
-func (f *fakeOrderService) CreateOrderFromCart(userID uint, deliveryType int, addressInfo, remark string, userCouponID uint, storeID uint, orderType int) (*model.Order, error) {
+func (f *fakeOrderService) CreateOrderFromCart(userID uint, deliveryType int, addressInfo, remark string, userCouponID uint, storeID uint, orderType int, deliveryTime *time.Time, usePoints int64) (*model.Order, error) {
 	return nil, nil
 }
 func (f *fakeOrderService) ListOrders(userID uint, status int, page, limit int, storeID uint) ([]model.Order, int64, error) {
@@ -2,8 +2,10 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -25,8 +27,17 @@ type createIntentReq struct {
 type unifiedOrderReq struct {
 	OrderID   uint   `json:"order_id" binding:"required"`
 	Method    int    `json:"method"`
-	TradeType string `json:"trade_type"`
+	TradeType string `json:"trade_type"` // 支付宝：MINI（默认）/ H5
 	NotifyURL string `json:"notify_url"`
+	BuyerID   string `json:"buyer_id"` // 支付宝小程序 my.getAuthCode 换取的 user_id
+}
+
+type balancePayReq struct {
+	OrderID      uint   `json:"order_id" binding:"required"`
+	BalanceCents int64  `json:"balance_cents"` // 使用的余额（分），0 表示尽量使用全部余额
+	Method       int    `json:"method"`        // 余额不足时剩余金额的支付方式：1 微信 2 支付宝
+	TradeType    string `json:"trade_type"`
+	BuyerID      string `json:"buyer_id"`
 }
 
 type paymentCallbackReq struct {
@@ -36,10 +47,13 @@ type paymentCallbackReq struct {
 	TradeState    string `json:"trade_state" binding:"required"`
 	PaidAt        string `json:"paid_at"`
 	Sign          string `json:"sign"`
+	Nonce         string `json:"nonce"`
+	Timestamp     int64  `json:"timestamp"` // Unix 秒
 	TestMode      bool   `json:"test_mode"`
 }
 
-// UnifiedOrder 模拟微信统一下单接口
+// UnifiedOrder 统一下单接口（按配置走模拟渠道、微信支付 APIv3 JSAPI 或支付宝）
+// 支付宝小程序的 tradeNO 通过 prepay_id 返回，H5 支付跳转 pay_url
 func (h *PaymentHandler) UnifiedOrder(c *gin.Context) {
 	uidVal, _ := c.Get("user_id")
 	userID := uint(uidVal.(uint))
@@ -51,7 +65,10 @@ func (h *PaymentHandler) UnifiedOrder(c *gin.Context) {
 	if req.Method == 0 {
 		req.Method = 1
 	}
-	res, err := h.svc.UnifiedOrder(userID, req.OrderID, req.Method)
+	res, err := h.svc.UnifiedOrder(userID, req.OrderID, req.Method, service.UnifiedOrderOptions{
+		TradeType: req.TradeType,
+		BuyerID:   req.BuyerID,
+	})
 	if err != nil {
 		response.Error(c, http.StatusBadRequest, err.Error())
 		return
@@ -66,18 +83,102 @@ func (h *PaymentHandler) UnifiedOrder(c *gin.Context) {
 		"package":    res.Package,
 		"sign":       res.Sign,
 		"pay_url":    res.PayURL,
+		"app_id":     res.AppID,
+		"sign_type":  res.SignType,
+		"pay_sign":   res.PaySign,
+		"provider":   res.Provider,
 	})
 }
 
-// Callback 处理第三方支付回调
+// BalancePay 余额支付（支持余额 + 微信/支付宝组合支付）
+// POST /api/v1/payments/balance
+func (h *PaymentHandler) BalancePay(c *gin.Context) {
+	uidVal, _ := c.Get("user_id")
+	userID := uint(uidVal.(uint))
+	var req balancePayReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	if req.Method == 0 {
+		req.Method = service.PayMethodWechat
+	}
+	res, err := h.svc.PayWithBalance(userID, req.OrderID, req.BalanceCents, req.Method, service.UnifiedOrderOptions{
+		TradeType: req.TradeType,
+		BuyerID:   req.BuyerID,
+	})
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, res)
+}
+
+// WechatNotify 微信支付 APIv3 异步通知
+// POST /api/v1/payment/wechat/notify
+func (h *PaymentHandler) WechatNotify(c *gin.Context) {
+	h.providerNotify(c, service.PayMethodWechat)
+}
+
+// AlipayNotify 支付宝异步通知
+// POST /api/v1/payment/alipay/notify
+func (h *PaymentHandler) AlipayNotify(c *gin.Context) {
+	h.providerNotify(c, service.PayMethodAlipay)
+}
+
+// providerNotify 原始报文留痕后交由渠道验签处理，并按渠道要求的格式应答：
+// 微信 {"code":"SUCCESS"} / {"code":"FAIL"}，支付宝纯文本 success / fail
+func (h *PaymentHandler) providerNotify(c *gin.Context, method int) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err == nil {
+		err = h.svc.ReceiveProviderNotify(c.Request.Context(), method, service.PaymentNotifyRequest{
+			Header:   c.Request.Header,
+			Body:     body,
+			RemoteIP: c.ClientIP(),
+		})
+	}
+	if method == service.PayMethodAlipay {
+		if err != nil {
+			c.String(http.StatusOK, "fail")
+			return
+		}
+		c.String(http.StatusOK, "success")
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": "FAIL", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": "SUCCESS", "message": "成功"})
+}
+
+// callbackProvider 根据报文特征识别回调渠道：微信 APIv3 带 Wechatpay-Signature 头，支付宝为表单提交
+func callbackProvider(c *gin.Context) int {
+	if c.GetHeader("Wechatpay-Signature") != "" {
+		return service.PayMethodWechat
+	}
+	if strings.HasPrefix(c.ContentType(), "application/x-www-form-urlencoded") {
+		return service.PayMethodAlipay
+	}
+	return 0
+}
+
+// Callback 处理第三方支付回调：按渠道分发，无渠道特征的报文走原有 JSON 签名回调
+// POST /api/v1/payments/callback
 func (h *PaymentHandler) Callback(c *gin.Context) {
-	body, err := io.ReadAll(c.Request.Body)
+	if method := callbackProvider(c); method != 0 {
+		h.providerNotify(c, method)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
 	if err != nil {
 		response.Error(c, http.StatusBadRequest, "读取回调失败")
 		return
 	}
+	notifyReq := service.PaymentNotifyRequest{Header: c.Request.Header, Body: body, RemoteIP: c.ClientIP()}
 	var req paymentCallbackReq
 	if err := json.Unmarshal(body, &req); err != nil {
+		_ = h.svc.RejectCallback(notifyReq, errors.New("回调报文格式错误"))
 		response.BadRequest(c, "回调报文格式错误")
 		return
 	}
@@ -92,6 +193,7 @@ func (h *PaymentHandler) Callback(c *gin.Context) {
 		if env := config.Config.System.Env; env == "local" || env == "dev" {
 			skipVerify = true
 		} else {
+			_ = h.svc.RejectCallback(notifyReq, errors.New("test_mode 仅在本地/开发环境允许"))
 			response.Forbidden(c, "test_mode 仅在本地/开发环境允许")
 			return
 		}
@@ -105,8 +207,10 @@ func (h *PaymentHandler) Callback(c *gin.Context) {
 		RawBody:       string(body),
 		TestMode:      req.TestMode,
 		SkipVerify:    skipVerify,
+		Nonce:         req.Nonce,
+		Timestamp:     req.Timestamp,
 	}
-	if err := h.svc.HandleCallback(payload); err != nil {
+	if err := h.svc.ReceiveCallback(notifyReq, payload); err != nil {
 		response.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
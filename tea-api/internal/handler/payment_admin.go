@@ -11,6 +11,7 @@ import (
 	"github.com/xuri/excelize/v2"
 
 	"tea-api/internal/model"
+	"tea-api/internal/service"
 	"tea-api/pkg/database"
 	"tea-api/pkg/utils"
 )
@@ -244,6 +245,109 @@ func (h *PaymentAdminHandler) ExportPayments(c *gin.Context) {
 	}
 }
 
+// GET /api/v1/admin/payments/reconcile/metrics
+// 待支付单对账任务累计指标：查询数、补记成功、同步失败、主动关单与处理失败数
+func (h *PaymentAdminHandler) ReconcileMetrics(c *gin.Context) {
+	utils.Success(c, service.GetPaymentReconcileMetrics())
+}
+
+// GET /api/v1/admin/payments/notifications
+// 回调通知留痕列表，支持查询参数：provider, payment_no, status, verified(0|1), start, end, page, limit
+func (h *PaymentAdminHandler) ListNotifications(c *gin.Context) {
+	provider := strings.TrimSpace(c.Query("provider"))
+	paymentNo := strings.TrimSpace(c.Query("payment_no"))
+	status := strings.TrimSpace(c.Query("status"))
+	verified := strings.TrimSpace(c.Query("verified"))
+	start := strings.TrimSpace(c.Query("start"))
+	end := strings.TrimSpace(c.Query("end"))
+
+	page := toIntPay(c.DefaultQuery("page", "1"))
+	size := toIntPay(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if size <= 0 || size > 200 {
+		size = 20
+	}
+
+	// 列表不返回报文正文，详情接口查看
+	q := database.GetDB().Model(&model.PaymentNotification{}).Omit("headers", "body", "parsed")
+	if provider != "" {
+		q = q.Where("provider = ?", provider)
+	}
+	if paymentNo != "" {
+		q = q.Where("payment_no = ?", paymentNo)
+	}
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if verified != "" {
+		q = q.Where("verified = ?", verified == "1" || verified == "true")
+	}
+	if start != "" {
+		q = q.Where("created_at >= ?", start)
+	}
+	if end != "" {
+		q = q.Where("created_at <= ?", end)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	var list []model.PaymentNotification
+	if err := q.Order("id desc").Limit(size).Offset((page - 1) * size).Find(&list).Error; err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	utils.PageSuccess(c, list, total, page, size)
+}
+
+// GET /api/v1/admin/payments/notifications/:id
+// 回调通知详情：原始请求头、报文、验签与处理结果
+func (h *PaymentAdminHandler) GetNotification(c *gin.Context) {
+	id := toIntPay(c.Param("id"))
+	if id <= 0 {
+		utils.InvalidParam(c, "非法的通知ID")
+		return
+	}
+	var rec model.PaymentNotification
+	if err := database.GetDB().First(&rec, id).Error; err != nil {
+		utils.NotFound(c, "回调通知不存在")
+		return
+	}
+	utils.Success(c, rec)
+}
+
+// POST /api/v1/admin/payments/notifications/:id/reprocess
+// 按已验签的通知数据重新执行业务处理，处理结果写回通知记录
+func (h *PaymentAdminHandler) ReprocessNotification(c *gin.Context) {
+	id := toIntPay(c.Param("id"))
+	if id <= 0 {
+		utils.InvalidParam(c, "非法的通知ID")
+		return
+	}
+	rec, err := service.NewPaymentService().ReprocessNotification(uint(id))
+	if rec != nil {
+		uidVal, _ := c.Get("user_id")
+		operatorID, _ := uidVal.(uint)
+		_ = writeOpLog(c, operatorID, "finance", "payment_notification.reprocess", map[string]any{
+			"notification_id": rec.ID,
+			"provider":        rec.Provider,
+			"payment_no":      rec.PaymentNo,
+			"refund_no":       rec.RefundNo,
+			"status":          rec.Status,
+			"process_error":   rec.ProcessError,
+		})
+	}
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	utils.Success(c, rec)
+}
+
 func toIntPay(s string) int { var n int; _, _ = fmt.Sscanf(s, "%d", &n); return n }
 func csvSafePay(s string) string {
 	s = strings.ReplaceAll(s, ",", " ")
@@ -7,12 +7,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"tea-api/internal/service"
 	"tea-api/pkg/database"
 	"tea-api/pkg/response"
 )
 
 // GetMyPoints GET /api/v1/points
-// 返回当前用户积分总额（可用）
+// 返回当前用户积分总额（可用）及近期即将过期的积分
 func GetMyPoints(c *gin.Context) {
 	uid, ok := currentUserID(c)
 	if !ok {
@@ -27,7 +28,17 @@ func GetMyPoints(c *gin.Context) {
 		response.Error(c, http.StatusInternalServerError, "积分查询失败")
 		return
 	}
-	response.Success(c, gin.H{"points": r.Total})
+	// 即将过期的积分：按积分规则的提醒天数统计，未配置时按 30 天
+	days := 30
+	if rules, err := service.LoadPointsRules(database.GetDB()); err == nil && rules.ExpiryNoticeDays > 0 {
+		days = rules.ExpiryNoticeDays
+	}
+	expiring, earliest, err := service.NewPointsService().ExpiringPoints(uid, time.Now(), time.Duration(days)*24*time.Hour)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "积分查询失败")
+		return
+	}
+	response.Success(c, gin.H{"points": r.Total, "expiring_points": expiring, "expiring_at": earliest})
 }
 
 // ListMyPointsTransactions GET /api/v1/points/transactions
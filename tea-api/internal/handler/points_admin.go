@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service"
+	"tea-api/pkg/database"
+	"tea-api/pkg/response"
+)
+
+type PointsAdminHandler struct {
+	db *gorm.DB
+}
+
+func NewPointsAdminHandler() *PointsAdminHandler {
+	return &PointsAdminHandler{db: database.GetDB()}
+}
+
+// GetRules GET /api/v1/admin/points/rules
+// 返回当前积分发放规则（未配置时为关闭状态的默认规则）。
+func (h *PointsAdminHandler) GetRules(c *gin.Context) {
+	rules, err := service.LoadPointsRules(h.db)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, rules)
+}
+
+// UpdateRules PUT /api/v1/admin/points/rules
+// 整体覆盖积分发放规则，对之后完成的订单生效。
+func (h *PointsAdminHandler) UpdateRules(c *gin.Context) {
+	var req service.PointsRules
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	if err := service.NewPointsService().SaveRules(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	response.Success(c, req)
+}
+
+// Reconcile POST /api/v1/admin/points/reconcile?dry_run=1
+// 为 users.points 与积分流水合计不一致的用户补记期初流水并补齐积分批次；dry_run 只返回差异统计。
+func (h *PointsAdminHandler) Reconcile(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "1" || c.Query("dry_run") == "true"
+	rep, err := service.NewPointsService().ReconcileOpeningBalances(dryRun)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"dry_run": dryRun, "users": rep.Users, "total_delta": rep.TotalDelta,
+		"lot_users": rep.LotUsers, "lot_delta": rep.LotDelta})
+}
+
+// RunExpiry POST /api/v1/admin/points/expiry/run
+// 手动执行一次积分过期处理与到期提醒（与每日调度相同，按批次幂等）。
+func (h *PointsAdminHandler) RunExpiry(c *gin.Context) {
+	rep, err := service.NewPointsService().RunExpiry(time.Now())
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, rep)
+}
+
+// ListTransactions GET /api/v1/admin/points/transactions
+// 积分流水查询，支持 user_id / order_id / type 过滤。
+func (h *PointsAdminHandler) ListTransactions(c *gin.Context) {
+	page := toInt(c.DefaultQuery("page", "1"))
+	limit := toInt(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 20
+	}
+	q := h.db.Model(&model.PointsTransaction{})
+	if v := strings.TrimSpace(c.Query("user_id")); v != "" {
+		q = q.Where("user_id = ?", v)
+	}
+	if v := strings.TrimSpace(c.Query("order_id")); v != "" {
+		q = q.Where("order_id = ?", v)
+	}
+	if v := strings.TrimSpace(c.Query("type")); v != "" {
+		q = q.Where("type = ?", v)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var list []model.PointsTransaction
+	if err := q.Order("id desc").Limit(limit).Offset((page - 1) * limit).Find(&list).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.SuccessWithPagination(c, list, total, page, limit)
+}
+
+type pointsMallItemReq struct {
+	Name         string          `json:"name" binding:"required"`
+	ItemType     int             `json:"item_type" binding:"required"` // 1商品 2优惠券
+	ProductID    *uint           `json:"product_id"`
+	SkuID        *uint           `json:"sku_id"`
+	CouponID     *uint           `json:"coupon_id"`
+	Image        string          `json:"image"`
+	Description  string          `json:"description"`
+	PointsPrice  int64           `json:"points_price" binding:"required"`
+	CashPrice    decimal.Decimal `json:"cash_price"`
+	Stock        *int            `json:"stock"`          // 不传或 -1 表示不限
+	PerUserLimit int             `json:"per_user_limit"` // 0 表示不限
+	Sort         int             `json:"sort"`
+	Status       int             `json:"status"`   // 1上架 2下架
+	StartAt      *time.Time      `json:"start_at"` // RFC3339，可选
+	EndAt        *time.Time      `json:"end_at"`
+}
+
+func (r *pointsMallItemReq) toModel() *model.PointsMallItem {
+	stock := -1
+	if r.Stock != nil {
+		stock = *r.Stock
+	}
+	return &model.PointsMallItem{
+		Name:         r.Name,
+		ItemType:     r.ItemType,
+		ProductID:    r.ProductID,
+		SkuID:        r.SkuID,
+		CouponID:     r.CouponID,
+		Image:        r.Image,
+		Description:  r.Description,
+		PointsPrice:  r.PointsPrice,
+		CashPrice:    r.CashPrice,
+		Stock:        stock,
+		PerUserLimit: r.PerUserLimit,
+		Sort:         r.Sort,
+		Status:       r.Status,
+		StartAt:      r.StartAt,
+		EndAt:        r.EndAt,
+	}
+}
+
+// ListMallItems GET /api/v1/admin/points/mall-items?item_type=&page=&limit=
+// 积分商城商品列表（含下架与过期商品）。
+func (h *PointsAdminHandler) ListMallItems(c *gin.Context) {
+	page := toInt(c.DefaultQuery("page", "1"))
+	limit := toInt(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	list, total, err := service.NewPointsMallService().ListItems(false, toInt(c.Query("item_type")), page, limit)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.SuccessWithPagination(c, list, total, page, limit)
+}
+
+// CreateMallItem POST /api/v1/admin/points/mall-items
+// 新增积分商城商品（商品或优惠券，积分 + 可选现金，可设库存与每人限兑）。
+func (h *PointsAdminHandler) CreateMallItem(c *gin.Context) {
+	var req pointsMallItemReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	item := req.toModel()
+	if err := service.NewPointsMallService().CreateItem(item); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	response.Success(c, item)
+}
+
+// UpdateMallItem PUT /api/v1/admin/points/mall-items/:id
+// 整体更新积分商城商品，已兑换数量保持不变。
+func (h *PointsAdminHandler) UpdateMallItem(c *gin.Context) {
+	id := parseUintParam(c, "id")
+	if id == 0 {
+		response.BadRequest(c, "非法的商品ID")
+		return
+	}
+	var req pointsMallItemReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	item, err := service.NewPointsMallService().UpdateItem(id, req.toModel())
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	response.Success(c, item)
+}
+
+// ListExchanges GET /api/v1/admin/points/exchanges?user_id=&item_id=&status=&page=&limit=
+// 积分兑换记录查询。
+func (h *PointsAdminHandler) ListExchanges(c *gin.Context) {
+	page := toInt(c.DefaultQuery("page", "1"))
+	limit := toInt(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	list, total, err := service.NewPointsMallService().ListExchanges(uint(toInt(c.Query("user_id"))), uint(toInt(c.Query("item_id"))),
+		toInt(c.Query("status")), page, limit)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.SuccessWithPagination(c, list, total, page, limit)
+}
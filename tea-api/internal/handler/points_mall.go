@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"tea-api/internal/service"
+	"tea-api/pkg/response"
+)
+
+type pointsExchangeReq struct {
+	Quantity     int    `json:"quantity"`
+	StoreID      uint   `json:"store_id"`      // 实物商品可选，门店自取/配送时传入
+	DeliveryType int    `json:"delivery_type"` // 1自取 2配送，默认自取
+	AddressInfo  string `json:"address_info"`
+	Remark       string `json:"remark"`
+}
+
+// ListPointsMallItems GET /api/v1/points/mall?item_type=&page=&limit=
+// 返回上架中且在兑换时间内的积分商城商品。
+func ListPointsMallItems(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	itemType, _ := strconv.Atoi(c.Query("item_type"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	list, total, err := service.NewPointsMallService().ListItems(true, itemType, page, limit)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
+	response.SuccessWithPagination(c, list, total, page, limit)
+}
+
+// ExchangePointsMallItem POST /api/v1/points/mall/:id/exchange
+// 使用积分（+现金）兑换商品或优惠券；需支付现金时返回待支付订单，按普通订单支付。
+func ExchangePointsMallItem(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		response.Unauthorized(c, "未获取到用户身份")
+		return
+	}
+	id := parseUintParam(c, "id")
+	if id == 0 {
+		response.BadRequest(c, "非法的商品ID")
+		return
+	}
+	var req pointsExchangeReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	ex, order, err := service.NewPointsMallService().Exchange(uid, service.PointsExchangeInput{
+		ItemID:       id,
+		Quantity:     req.Quantity,
+		StoreID:      req.StoreID,
+		DeliveryType: req.DeliveryType,
+		AddressInfo:  req.AddressInfo,
+		Remark:       req.Remark,
+	})
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"exchange": ex, "order": order})
+}
+
+// ListMyPointsExchanges GET /api/v1/points/exchanges?page=&limit=
+// 返回当前用户的积分兑换记录（分页）。
+func ListMyPointsExchanges(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		response.Unauthorized(c, "未获取到用户身份")
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	list, total, err := service.NewPointsMallService().ListUserExchanges(uid, page, limit)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
+	response.SuccessWithPagination(c, list, total, page, limit)
+}
@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service"
+	"tea-api/pkg/response"
+)
+
+type PrintHandler struct{ svc *service.PrintService }
+
+func NewPrintHandler() *PrintHandler { return &PrintHandler{svc: service.NewPrintService()} }
+
+type storePrinterReq struct {
+	Name       string `json:"name" binding:"required"`
+	Channel    string `json:"channel"` // cloud / file，缺省 cloud
+	DeviceSN   string `json:"device_sn" binding:"required"`
+	DeviceKey  string `json:"device_key"` // 更新时为空表示不修改
+	PaperWidth int    `json:"paper_width"`
+	Templates  string `json:"templates"` // 例如 "kitchen,receipt"
+	Copies     int    `json:"copies"`
+	AutoPrint  *bool  `json:"auto_print"` // 缺省开启
+	Status     int    `json:"status"`
+}
+
+func (r storePrinterReq) toModel(storeID uint) model.StorePrinter {
+	autoPrint := true
+	if r.AutoPrint != nil {
+		autoPrint = *r.AutoPrint
+	}
+	return model.StorePrinter{
+		StoreID:    storeID,
+		Name:       r.Name,
+		Channel:    r.Channel,
+		DeviceSN:   r.DeviceSN,
+		DeviceKey:  r.DeviceKey,
+		PaperWidth: r.PaperWidth,
+		Templates:  r.Templates,
+		Copies:     r.Copies,
+		AutoPrint:  autoPrint,
+		Status:     r.Status,
+	}
+}
+
+// ListPrinters 门店打印机列表
+// GET /api/v1/admin/stores/:id/printers
+func (h *PrintHandler) ListPrinters(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || sid == 0 {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	list, err := h.svc.ListPrinters(uint(sid))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, list)
+}
+
+// CreatePrinter 登记门店打印机
+// POST /api/v1/admin/stores/:id/printers
+func (h *PrintHandler) CreatePrinter(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || sid == 0 {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	var req storePrinterReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	p := req.toModel(uint(sid))
+	if err := h.svc.CreatePrinter(&p); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, p)
+}
+
+// UpdatePrinter 更新门店打印机
+// PUT /api/v1/admin/stores/:id/printers/:pid
+func (h *PrintHandler) UpdatePrinter(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || sid == 0 {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	pid, err := strconv.ParseUint(c.Param("pid"), 10, 32)
+	if err != nil || pid == 0 {
+		response.BadRequest(c, "非法打印机ID")
+		return
+	}
+	var req storePrinterReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	p, err := h.svc.UpdatePrinter(uint(sid), uint(pid), req.toModel(uint(sid)))
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, p)
+}
+
+// DeletePrinter 删除门店打印机
+// DELETE /api/v1/admin/stores/:id/printers/:pid
+func (h *PrintHandler) DeletePrinter(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || sid == 0 {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	pid, err := strconv.ParseUint(c.Param("pid"), 10, 32)
+	if err != nil || pid == 0 {
+		response.BadRequest(c, "非法打印机ID")
+		return
+	}
+	if err := h.svc.DeletePrinter(uint(sid), uint(pid)); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"ok": true})
+}
+
+// ListTemplates 门店生效的打印模板（门店 ID 为 0 表示全局模板）
+// GET /api/v1/admin/stores/:id/print-templates
+func (h *PrintHandler) ListTemplates(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	list, err := h.svc.ListTemplates(uint(sid))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, list)
+}
+
+// SaveTemplate 保存打印模板
+// PUT /api/v1/admin/stores/:id/print-templates/:type
+func (h *PrintHandler) SaveTemplate(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	var req struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	tpl, err := h.svc.SaveTemplate(uint(sid), c.Param("type"), req.Content)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, tpl)
+}
+
+// DeleteTemplate 删除打印模板，恢复为上级模板
+// DELETE /api/v1/admin/stores/:id/print-templates/:type
+func (h *PrintHandler) DeleteTemplate(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	if err := h.svc.DeleteTemplate(uint(sid), c.Param("type")); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"ok": true})
+}
+
+// Reprint 后台订单详情补打
+// POST /api/v1/admin/orders/:id/reprint  body: {"printer_id":0,"template_types":["receipt"]}
+func (h *PrintHandler) Reprint(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || id == 0 {
+		response.BadRequest(c, "非法订单ID")
+		return
+	}
+	var req struct {
+		PrinterID     uint     `json:"printer_id"`
+		TemplateTypes []string `json:"template_types"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.BadRequest(c, "参数错误")
+			return
+		}
+	}
+	operatorID, _ := currentUserID(c)
+	jobs, err := h.svc.PrintOrder(c.Request.Context(), uint(id), service.PrintTriggerReprint, operatorID, service.PrintOrderOptions{
+		PrinterID:     req.PrinterID,
+		TemplateTypes: req.TemplateTypes,
+	})
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeOpLog(c, operatorID, "order", "reprint", map[string]any{"order_id": id, "jobs": len(jobs)})
+	response.Success(c, jobs)
+}
+
+// ListJobs 订单打印记录
+// GET /api/v1/admin/orders/:id/print-jobs
+func (h *PrintHandler) ListJobs(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || id == 0 {
+		response.BadRequest(c, "非法订单ID")
+		return
+	}
+	list, err := h.svc.ListJobs(uint(id))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, list)
+}
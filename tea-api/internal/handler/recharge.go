@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"tea-api/internal/service"
+	"tea-api/pkg/response"
+)
+
+type createRechargeOrderReq struct {
+	AmountCents int64 `json:"amount_cents" binding:"required"`
+}
+
+// GetRechargeOptions GET /api/v1/recharge/options
+// 返回充值开关、启用中的档位（含赠送与剩余可充次数）及每日剩余额度（分）。
+func GetRechargeOptions(c *gin.Context) {
+	uidVal, _ := c.Get("user_id")
+	userID, _ := uidVal.(uint)
+	opts, err := service.NewRechargeService().UserOptions(userID)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, opts)
+}
+
+// CreateRechargeOrder POST /api/v1/recharge/orders
+// 按档位金额创建充值订单，返回的 order_id 通过 /payments/unified-order 支付，支付成功后本金与赠送分别入账钱包。
+func CreateRechargeOrder(c *gin.Context) {
+	uidVal, _ := c.Get("user_id")
+	userID, _ := uidVal.(uint)
+	var req createRechargeOrderReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	order, rec, err := service.NewRechargeService().CreateRechargeOrder(userID, req.AmountCents)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, gin.H{
+		"order_id":         order.ID,
+		"order_no":         order.OrderNo,
+		"pay_amount":       order.PayAmount,
+		"amount_cents":     rec.AmountCents,
+		"bonus_cents":      rec.BonusCents,
+		"bonus_coupon_ids": rec.BonusCouponIDs,
+	})
+}
+
+// ListMyRecharges GET /api/v1/recharge/orders?page=&limit=
+// 返回当前用户的充值记录（分页）。
+func ListMyRecharges(c *gin.Context) {
+	uidVal, _ := c.Get("user_id")
+	userID, _ := uidVal.(uint)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	list, total, err := service.NewRechargeService().ListUserRecharges(userID, page, limit)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.SuccessWithPagination(c, list, total, page, limit)
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -8,6 +9,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
+	"tea-api/internal/service"
+	"tea-api/internal/service/ledger"
 	"tea-api/pkg/database"
 	"tea-api/pkg/response"
 )
@@ -227,24 +230,16 @@ func (h *RechargeAdminHandler) mutateWallet(c *gin.Context, typ string, deltaBal
 		if newBal < 0 || newFrozen < 0 {
 			return gorm.ErrInvalidData
 		}
-		if err := tx.Exec("UPDATE wallets SET balance = ?, frozen = ? WHERE user_id = ?", newBal, newFrozen, userID).Error; err != nil {
-			return err
-		}
-		// wallet_transactions.amount records the balance delta
-		if err := tx.Exec(
-			"INSERT INTO wallet_transactions (user_id, type, amount, balance_after, remark, created_at) VALUES (?,?,?,?,?,NOW())",
-			userID, typ, deltaBalance, newBal, remark,
-		).Error; err != nil {
-			return err
-		}
-		return nil
+		// 经账本过账，余额投影随之更新；流水 amount 记录可用余额变动
+		_, err = service.AdjustWalletTx(tx, userID, deltaBalance, deltaFrozen, typ, remark)
+		return err
 	})
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			response.BadRequest(c, "用户不存在")
 			return
 		}
-		if err == gorm.ErrInvalidData {
+		if err == gorm.ErrInvalidData || errors.Is(err, ledger.ErrInsufficientBalance) || errors.Is(err, ledger.ErrInsufficientFrozen) {
 			response.BadRequest(c, "余额不足")
 			return
 		}
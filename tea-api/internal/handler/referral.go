@@ -1,51 +1,110 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
-
-	"tea-api/internal/model"
-	"tea-api/pkg/database"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"tea-api/internal/service"
+	"tea-api/pkg/response"
 )
 
 type bindReferralReq struct {
-    ReferrerID uint `json:"referrer_id" binding:"required"`
+	ReferrerID uint `json:"referrer_id" binding:"required"`
 }
 
-// BindReferral 建立推荐关系（最小版：插入 referrals_closure depth=1）
+// BindReferral 绑定推荐人：首次绑定生效，写入完整的祖先-后代闭包记录；拒绝绑定自己的下级
 func BindReferral(c *gin.Context) {
-    uidVal, _ := c.Get("user_id")
-    currUserID := uint(uidVal.(uint))
-
-    var req bindReferralReq
-    if err := c.ShouldBindJSON(&req); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"code": 4001, "message": "参数错误", "data": nil})
-        return
-    }
-    if req.ReferrerID == 0 || req.ReferrerID == currUserID {
-        c.JSON(http.StatusOK, gin.H{"code": 0, "message": "ok", "data": gin.H{"bound": false}})
-        return
-    }
-
-    db := database.GetDB()
-    // 简化：若已存在则跳过；否则插入一条闭包关系（referrer -> current user, depth=1）
-    var existing model.ReferralClosure
-    if err := db.Where("ancestor_user_id = ? AND descendant_user_id = ? AND depth = 1", req.ReferrerID, currUserID).First(&existing).Error; err == nil && existing.AncestorUserID != 0 {
-        c.JSON(http.StatusOK, gin.H{"code": 0, "message": "ok", "data": gin.H{"bound": true}})
-        return
-    }
-
-    rc := model.ReferralClosure{AncestorUserID: req.ReferrerID, DescendantUserID: currUserID, Depth: 1}
-    if err := db.Create(&rc).Error; err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"code": 5001, "message": "绑定失败", "data": nil})
-        return
-    }
-    // 可选：确保当前用户的自闭包（depth=0）存在（忽略错误）
-    _ = db.Where("ancestor_user_id = ? AND descendant_user_id = ? AND depth = 0", currUserID, currUserID).First(&existing).Error
-    if existing.AncestorUserID == 0 {
-        _ = db.Create(&model.ReferralClosure{AncestorUserID: currUserID, DescendantUserID: currUserID, Depth: 0}).Error
-    }
-
-    c.JSON(http.StatusOK, gin.H{"code": 0, "message": "ok", "data": gin.H{"bound": true}})
+	uidVal, _ := c.Get("user_id")
+	currUserID := uint(uidVal.(uint))
+
+	var req bindReferralReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 4001, "message": "参数错误", "data": nil})
+		return
+	}
+	if req.ReferrerID == 0 || req.ReferrerID == currUserID {
+		c.JSON(http.StatusOK, gin.H{"code": 0, "message": "ok", "data": gin.H{"bound": false}})
+		return
+	}
+
+	err := service.NewReferralService().Bind(currUserID, req.ReferrerID, time.Now())
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"code": 0, "message": "ok", "data": gin.H{"bound": true}})
+	case errors.Is(err, service.ErrReferralAlreadyBound), errors.Is(err, service.ErrReferralWindowClosed),
+		errors.Is(err, service.ErrReferralCycle), errors.Is(err, service.ErrReferrerNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"code": 4001, "message": err.Error(), "data": nil})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 5001, "message": "绑定失败", "data": nil})
+	}
+}
+
+// ReferralAdminHandler 管理端推荐关系维护
+type ReferralAdminHandler struct {
+	svc *service.ReferralService
+}
+
+func NewReferralAdminHandler() *ReferralAdminHandler {
+	return &ReferralAdminHandler{svc: service.NewReferralService()}
+}
+
+// Ancestors GET /api/v1/admin/referrals/:id/ancestors 用户的推荐链（由近及远）
+func (h *ReferralAdminHandler) Ancestors(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		response.BadRequest(c, "非法ID")
+		return
+	}
+	list, err := h.svc.Ancestors(uint(id))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, list)
+}
+
+type moveReferralReq struct {
+	UserID      uint   `json:"user_id" binding:"required"`
+	NewParentID uint   `json:"new_parent_id"` // 0 表示解除推荐关系
+	Reason      string `json:"reason" binding:"required"`
+}
+
+// Move POST /api/v1/admin/referrals/move 将用户连同其下级迁移到新推荐人下，重建闭包并记录审计日志
+func (h *ReferralAdminHandler) Move(c *gin.Context) {
+	var req moveReferralReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	uid, _ := c.Get("user_id")
+	opID, _ := uid.(uint)
+	log, err := h.svc.MoveSubtree(req.UserID, req.NewParentID, opID, req.Reason)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	response.Success(c, log)
+}
+
+// MoveLogs GET /api/v1/admin/referrals/move-logs?user_id=&page=&limit=
+func (h *ReferralAdminHandler) MoveLogs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 20
+	}
+	userID, _ := strconv.ParseUint(c.Query("user_id"), 10, 64)
+	list, total, err := h.svc.ListMoveLogs(uint(userID), page, limit)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.SuccessWithPagination(c, list, total, page, limit)
 }
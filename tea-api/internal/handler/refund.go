@@ -12,6 +12,7 @@ import (
 	"github.com/xuri/excelize/v2"
 
 	"tea-api/internal/model"
+	"tea-api/internal/service"
 	"tea-api/pkg/database"
 	"tea-api/pkg/utils"
 )
@@ -197,6 +198,32 @@ func (h *RefundHandler) ExportRefunds(c *gin.Context) {
 }
 
 func toIntRef(s string) int { var n int; _, _ = fmt.Sscanf(s, "%d", &n); return n }
+
+// RetryRefund 重试单笔退款：失败的退款重新提交渠道，处理中的退款主动查询结果
+// POST /api/v1/admin/refunds/:id/retry
+func (h *RefundHandler) RetryRefund(c *gin.Context) {
+	rid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || rid == 0 {
+		utils.InvalidParam(c, "非法的退款ID")
+		return
+	}
+	refund, err := service.NewPaymentService().RetryRefund(uint(rid))
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	uidVal, _ := c.Get("user_id")
+	operatorID, _ := uidVal.(uint)
+	_ = writeOpLog(c, operatorID, "finance", "refund.retry", map[string]any{
+		"refund_id":  refund.ID,
+		"refund_no":  refund.RefundNo,
+		"order_id":   refund.OrderID,
+		"status":     refund.Status,
+		"last_error": refund.LastError,
+	})
+	utils.Success(c, refund)
+}
+
 func csvSafeRef(s string) string {
 	s = strings.ReplaceAll(s, ",", " ")
 	s = strings.ReplaceAll(s, "\n", " ")
@@ -264,7 +291,7 @@ func (h *RefundHandler) ListMyRefunds(c *gin.Context) {
 		return
 	}
 	var list []model.Refund
-	if err := q.Order("refunds.id desc").Limit(size).Offset((page-1)*size).Find(&list).Error; err != nil {
+	if err := q.Order("refunds.id desc").Limit(size).Offset((page - 1) * size).Find(&list).Error; err != nil {
 		utils.Error(c, utils.CodeError, err.Error())
 		return
 	}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"tea-api/internal/service"
+	"tea-api/pkg/response"
+)
+
+// TeamHandler 合伙人团队（下级）统计：团队概览、成员列表与团队规模趋势
+type TeamHandler struct {
+	svc *service.ReferralService
+}
+
+func NewTeamHandler() *TeamHandler {
+	return &TeamHandler{svc: service.NewReferralService()}
+}
+
+// Summary GET /api/v1/partner/team 当前用户的团队概览
+func (h *TeamHandler) Summary(c *gin.Context) {
+	teamSummary(c, h.svc, currentTeamUserID(c))
+}
+
+// Members GET /api/v1/partner/team/members?depth=&after_id=&limit= 团队成员及其 GMV/佣金贡献（游标分页）
+func (h *TeamHandler) Members(c *gin.Context) {
+	teamMembers(c, h.svc, currentTeamUserID(c), 0)
+}
+
+// Growth GET /api/v1/partner/team/growth?granularity=day|month&from=YYYY-MM-DD&to=YYYY-MM-DD 团队规模趋势
+func (h *TeamHandler) Growth(c *gin.Context) {
+	teamGrowth(c, h.svc, currentTeamUserID(c))
+}
+
+// TeamSummary GET /api/v1/admin/referrals/:id/team 指定用户的团队概览
+func (h *ReferralAdminHandler) TeamSummary(c *gin.Context) {
+	if id := teamPathUserID(c); id != 0 {
+		teamSummary(c, h.svc, id)
+	}
+}
+
+// Tree GET /api/v1/admin/referrals/:id/tree?depth=1&after_id=&limit= 团队树浏览：默认返回直属下级（含各自团队人数），
+// 逐级展开时以子节点ID再次请求
+func (h *ReferralAdminHandler) Tree(c *gin.Context) {
+	if id := teamPathUserID(c); id != 0 {
+		teamMembers(c, h.svc, id, 1)
+	}
+}
+
+// TeamGrowth GET /api/v1/admin/referrals/:id/growth 指定用户的团队规模趋势
+func (h *ReferralAdminHandler) TeamGrowth(c *gin.Context) {
+	if id := teamPathUserID(c); id != 0 {
+		teamGrowth(c, h.svc, id)
+	}
+}
+
+func currentTeamUserID(c *gin.Context) uint {
+	uid, _ := c.Get("user_id")
+	id, _ := uid.(uint)
+	return id
+}
+
+func teamPathUserID(c *gin.Context) uint {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		response.BadRequest(c, "非法ID")
+		return 0
+	}
+	return uint(id)
+}
+
+func teamSummary(c *gin.Context, svc *service.ReferralService, userID uint) {
+	out, err := svc.TeamSummary(userID)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, out)
+}
+
+// teamMembers defaultDepth 为未传 depth 时的层级，0 表示全部层级
+func teamMembers(c *gin.Context, svc *service.ReferralService, userID uint, defaultDepth int) {
+	depth := defaultDepth
+	if v := c.Query("depth"); v != "" {
+		depth, _ = strconv.Atoi(v)
+	}
+	afterID, _ := strconv.ParseUint(c.Query("after_id"), 10, 64)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	page, err := svc.TeamMembers(userID, depth, uint(afterID), limit)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, page)
+}
+
+func teamGrowth(c *gin.Context, svc *service.ReferralService, userID uint) {
+	granularity := c.DefaultQuery("granularity", "day")
+	now := time.Now()
+	to := now
+	from := now.AddDate(0, 0, -29)
+	if granularity == "month" {
+		from = now.AddDate(0, -11, 0)
+	}
+	if v := c.Query("from"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			response.BadRequest(c, "from 格式应为 YYYY-MM-DD")
+			return
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			response.BadRequest(c, "to 格式应为 YYYY-MM-DD")
+			return
+		}
+		to = t.AddDate(0, 0, 1) // 含结束日
+	}
+	points, err := svc.TeamGrowth(userID, granularity, from, to)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	response.Success(c, points)
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service"
+	"tea-api/pkg/response"
+)
+
+type TimeSlotHandler struct{ svc *service.TimeSlotService }
+
+func NewTimeSlotHandler() *TimeSlotHandler {
+	return &TimeSlotHandler{svc: service.NewTimeSlotService()}
+}
+
+type slotTemplateReq struct {
+	DeliveryType  int    `json:"delivery_type"` // 0不限 1自取 2配送
+	Weekdays      string `json:"weekdays"`      // 例如 "1,2,3,4,5"，为空表示每天
+	StartTime     string `json:"start_time" binding:"required"`
+	EndTime       string `json:"end_time" binding:"required"`
+	SlotMinutes   int    `json:"slot_minutes" binding:"required"`
+	Capacity      int    `json:"capacity" binding:"required"`
+	CutoffMinutes int    `json:"cutoff_minutes"`
+	LeadMinutes   int    `json:"lead_minutes"`
+	Status        int    `json:"status"` // 1启用 2停用，缺省为启用
+}
+
+func (r slotTemplateReq) toModel(storeID uint) model.StoreSlotTemplate {
+	return model.StoreSlotTemplate{
+		StoreID:       storeID,
+		DeliveryType:  r.DeliveryType,
+		Weekdays:      r.Weekdays,
+		StartTime:     r.StartTime,
+		EndTime:       r.EndTime,
+		SlotMinutes:   r.SlotMinutes,
+		Capacity:      r.Capacity,
+		CutoffMinutes: r.CutoffMinutes,
+		LeadMinutes:   r.LeadMinutes,
+		Status:        r.Status,
+	}
+}
+
+// parseSlotDate 解析 date 查询参数（YYYY-MM-DD，按服务器本地时区），缺省为今天
+func parseSlotDate(c *gin.Context) (time.Time, bool) {
+	v := c.Query("date")
+	if v == "" {
+		return time.Now(), true
+	}
+	d, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return d, true
+}
+
+// AvailableSlots 查询门店某日可预约时段
+// GET /api/v1/stores/:id/slots?date=2025-01-01&delivery_type=1
+func (h *TimeSlotHandler) AvailableSlots(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || sid == 0 {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	day, ok := parseSlotDate(c)
+	if !ok {
+		response.BadRequest(c, "date 格式错误，应为 YYYY-MM-DD")
+		return
+	}
+	deliveryType, _ := strconv.Atoi(c.DefaultQuery("delivery_type", "0"))
+	list, err := h.svc.AvailableSlots(uint(sid), day, deliveryType)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, list)
+}
+
+// ListTemplates 管理端列出门店时段模板
+// GET /api/v1/admin/stores/:id/slot-templates
+func (h *TimeSlotHandler) ListTemplates(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || sid == 0 {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	list, err := h.svc.ListTemplates(uint(sid))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, list)
+}
+
+// CreateTemplate 管理端新建门店时段模板
+// POST /api/v1/admin/stores/:id/slot-templates
+func (h *TimeSlotHandler) CreateTemplate(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || sid == 0 {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	var req slotTemplateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	tpl := req.toModel(uint(sid))
+	if err := h.svc.CreateTemplate(&tpl); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, tpl)
+}
+
+// UpdateTemplate 管理端更新门店时段模板
+// PUT /api/v1/admin/stores/:id/slot-templates/:tid
+func (h *TimeSlotHandler) UpdateTemplate(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || sid == 0 {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	tid, err := strconv.ParseUint(c.Param("tid"), 10, 32)
+	if err != nil || tid == 0 {
+		response.BadRequest(c, "非法模板ID")
+		return
+	}
+	var req slotTemplateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误")
+		return
+	}
+	tpl, err := h.svc.UpdateTemplate(uint(sid), uint(tid), req.toModel(uint(sid)))
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, tpl)
+}
+
+// DeleteTemplate 管理端删除门店时段模板
+// DELETE /api/v1/admin/stores/:id/slot-templates/:tid
+func (h *TimeSlotHandler) DeleteTemplate(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || sid == 0 {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	tid, err := strconv.ParseUint(c.Param("tid"), 10, 32)
+	if err != nil || tid == 0 {
+		response.BadRequest(c, "非法模板ID")
+		return
+	}
+	if err := h.svc.DeleteTemplate(uint(sid), uint(tid)); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"ok": true})
+}
+
+// Utilization 管理端查看门店某日时段利用率
+// GET /api/v1/admin/stores/:id/slots/utilization?date=2025-01-01
+func (h *TimeSlotHandler) Utilization(c *gin.Context) {
+	sid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || sid == 0 {
+		response.BadRequest(c, "非法门店ID")
+		return
+	}
+	day, ok := parseSlotDate(c)
+	if !ok {
+		response.BadRequest(c, "date 格式错误，应为 YYYY-MM-DD")
+		return
+	}
+	res, err := h.svc.Utilization(uint(sid), day)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Success(c, res)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -439,6 +440,46 @@ func (h *UserHandler) AdminSetBlacklist(c *gin.Context) {
 	utils.Success(c, userInfo)
 }
 
+// AdminSetRealName 管理端登记用户实名核验结果，提现时用于校验账户户名
+func (h *UserHandler) AdminSetRealName(c *gin.Context) {
+	userIDStr := c.Param("id")
+	userID, err := strconv.ParseUint(userIDStr, 10, 32)
+	if err != nil || userID == 0 {
+		utils.InvalidParam(c, "用户ID格式错误")
+		return
+	}
+
+	var req struct {
+		RealName string `json:"real_name"`
+		Verified bool   `json:"verified"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.InvalidParam(c, err.Error())
+		return
+	}
+	req.RealName = strings.TrimSpace(req.RealName)
+	if req.Verified && req.RealName == "" {
+		utils.InvalidParam(c, "核验通过须填写真实姓名")
+		return
+	}
+
+	updates := map[string]interface{}{"real_name": req.RealName, "real_name_verified_at": nil}
+	if req.Verified {
+		updates["real_name_verified_at"] = time.Now()
+	}
+	if err := h.userService.UpdateUserInfo(uint(userID), updates); err != nil {
+		utils.Error(c, utils.CodeError, "更新实名信息失败: "+err.Error())
+		return
+	}
+
+	userInfo, err := h.userService.GetUserModel(uint(userID))
+	if err != nil {
+		utils.Error(c, utils.CodeError, "读取用户信息失败: "+err.Error())
+		return
+	}
+	utils.Success(c, userInfo)
+}
+
 // AdminSetWhitelist 管理端设置用户白名单状态（黑名单会被自动清除）
 func (h *UserHandler) AdminSetWhitelist(c *gin.Context) {
 	userIDStr := c.Param("id")
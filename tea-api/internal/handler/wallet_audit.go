@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service"
+	"tea-api/pkg/database"
+	"tea-api/pkg/utils"
+)
+
+type walletAuditRunReq struct {
+	UserID      uint `json:"user_id"`      // 为空时全量巡检
+	OpenTickets bool `json:"open_tickets"` // 新发现的问题是否自动创建工单
+}
+
+// POST /api/v1/admin/finance/wallet-audit/run
+// 手动触发钱包余额巡检，可指定 user_id 只巡检单个用户
+func (h *FinanceReportHandler) RunWalletAudit(c *gin.Context) {
+	var req walletAuditRunReq
+	_ = c.ShouldBindJSON(&req)
+	uidVal, _ := c.Get("user_id")
+	operatorID, _ := uidVal.(uint)
+	opts := service.WalletAuditOptions{
+		Trigger:     service.WalletAuditTriggerManual,
+		UserID:      req.UserID,
+		OpenTickets: req.OpenTickets,
+	}
+	if operatorID != 0 {
+		opts.OperatorID = &operatorID
+	}
+	run, err := service.NewWalletAuditService().Run(opts)
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	_ = writeOpLog(c, operatorID, "finance", "wallet_audit.run", map[string]any{
+		"run_id":       run.ID,
+		"user_id":      req.UserID,
+		"open_tickets": req.OpenTickets,
+		"findings":     run.FindingCount,
+	})
+	utils.Success(c, run)
+}
+
+// GET /api/v1/admin/finance/wallet-audit/runs
+// 巡检批次列表，支持查询参数：trigger, status, page, limit
+func (h *FinanceReportHandler) ListWalletAuditRuns(c *gin.Context) {
+	trigger := strings.TrimSpace(c.Query("trigger"))
+	status := strings.TrimSpace(c.Query("status"))
+	page, size := walletAuditPage(c)
+
+	q := database.GetDB().Model(&model.WalletAuditRun{})
+	if trigger != "" {
+		q = q.Where("trigger_type = ?", trigger)
+	}
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	var list []model.WalletAuditRun
+	if err := q.Order("id desc").Limit(size).Offset((page - 1) * size).Find(&list).Error; err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	utils.PageSuccess(c, list, total, page, size)
+}
+
+// GET /api/v1/admin/finance/wallet-audit/findings
+// 巡检发现列表，支持查询参数：user_id, kind, status(open|resolved|ignored), run_id(最近检出批次), page, limit
+func (h *FinanceReportHandler) ListWalletAuditFindings(c *gin.Context) {
+	userID := strings.TrimSpace(c.Query("user_id"))
+	kind := strings.TrimSpace(c.Query("kind"))
+	status := strings.TrimSpace(c.Query("status"))
+	runID := strings.TrimSpace(c.Query("run_id"))
+	page, size := walletAuditPage(c)
+
+	q := database.GetDB().Model(&model.WalletAuditFinding{})
+	if userID != "" {
+		q = q.Where("user_id = ?", userID)
+	}
+	if kind != "" {
+		q = q.Where("kind = ?", kind)
+	}
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if runID != "" {
+		q = q.Where("last_run_id = ?", runID)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	var list []model.WalletAuditFinding
+	if err := q.Order("id desc").Limit(size).Offset((page - 1) * size).Find(&list).Error; err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	utils.PageSuccess(c, list, total, page, size)
+}
+
+type walletAuditIgnoreReq struct {
+	Note string `json:"note"`
+}
+
+// POST /api/v1/admin/finance/wallet-audit/findings/:id/ignore
+// 忽略待处理的巡检发现（如已确认的历史数据问题），后续巡检不再重复上报
+func (h *FinanceReportHandler) IgnoreWalletAuditFinding(c *gin.Context) {
+	id := toIntPay(c.Param("id"))
+	if id <= 0 {
+		utils.InvalidParam(c, "非法的巡检记录ID")
+		return
+	}
+	var req walletAuditIgnoreReq
+	_ = c.ShouldBindJSON(&req)
+	f, err := service.NewWalletAuditService().IgnoreFinding(uint(id), strings.TrimSpace(req.Note))
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	uidVal, _ := c.Get("user_id")
+	operatorID, _ := uidVal.(uint)
+	_ = writeOpLog(c, operatorID, "finance", "wallet_audit.ignore", map[string]any{
+		"finding_id": f.ID,
+		"user_id":    f.UserID,
+		"kind":       f.Kind,
+		"note":       f.Note,
+	})
+	utils.Success(c, f)
+}
+
+func walletAuditPage(c *gin.Context) (int, int) {
+	page := toIntPay(c.DefaultQuery("page", "1"))
+	size := toIntPay(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if size <= 0 || size > 200 {
+		size = 20
+	}
+	return page, size
+}
@@ -2,6 +2,8 @@ package handler
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
@@ -13,6 +15,7 @@ import (
 	"gorm.io/gorm"
 
 	"tea-api/internal/model"
+	"tea-api/internal/service"
 	"tea-api/internal/service/commission"
 	"tea-api/pkg/database"
 	"tea-api/pkg/utils"
@@ -73,7 +76,18 @@ func (h *WithdrawAdminHandler) List(c *gin.Context) {
 		utils.Error(c, utils.CodeError, err.Error())
 		return
 	}
-	utils.PageSuccess(c, list, total, page, size)
+	// 附带申请时落库的风控复核原因，便于审核判断
+	rows := make([]withdrawListRow, 0, len(list))
+	for i := range list {
+		rows = append(rows, withdrawListRow{WithdrawRecord: list[i], RiskReasons: service.ParseWithdrawRiskFlags(list[i].RiskFlags)})
+	}
+	utils.PageSuccess(c, rows, total, page, size)
+}
+
+// withdrawListRow 提现列表行：记录本身（含 need_review）附带解析后的风控复核原因
+type withdrawListRow struct {
+	model.WithdrawRecord
+	RiskReasons []service.WithdrawRiskReason `json:"risk_reasons"`
 }
 
 // GET /api/v1/admin/withdraws/export?format=csv|xlsx
@@ -160,31 +174,87 @@ func (h *WithdrawAdminHandler) Approve(c *gin.Context) {
 	id := strings.TrimSpace(c.Param("id"))
 	var req withdrawActionReq
 	_ = c.ShouldBindJSON(&req)
-	db := database.GetDB()
-	var rec model.WithdrawRecord
-	if err := db.First(&rec, id).Error; err != nil {
-		utils.Error(c, utils.CodeError, "记录不存在")
+	uid, _ := c.Get("user_id")
+	opID, _ := uid.(uint)
+	rec, tr, err := approveWithdraw(c.Request.Context(), toUintWd(id), opID, req.Remark)
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
 		return
 	}
-	if rec.Status != model.WithdrawStatusPending { // 仅申请中可受理
-		utils.Error(c, utils.CodeError, "当前状态不可受理")
+	if tr != nil {
+		utils.Success(c, gin.H{"withdraw": rec, "transfer": tr})
+		return
+	}
+	utils.Success(c, rec)
+}
+
+type batchApproveReq struct {
+	IDs    []uint `json:"ids" binding:"required"`
+	Remark string `json:"remark"`
+}
+
+// POST /api/v1/admin/withdraws/batch-approve 批量受理，body: {"ids":[1,2],"remark":""}，返回每笔的处理结果
+func (h *WithdrawAdminHandler) BatchApprove(c *gin.Context) {
+	var req batchApproveReq
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 {
+		utils.Error(c, utils.CodeError, "请选择要受理的提现记录")
+		return
+	}
+	if len(req.IDs) > 100 {
+		utils.Error(c, utils.CodeError, "单次最多处理 100 笔")
 		return
 	}
 	uid, _ := c.Get("user_id")
+	opID, _ := uid.(uint)
+	out := make(map[uint]string, len(req.IDs))
+	for _, id := range req.IDs {
+		if _, _, err := approveWithdraw(c.Request.Context(), id, opID, req.Remark); err != nil {
+			out[id] = err.Error()
+			continue
+		}
+		out[id] = "approved"
+	}
+	utils.Success(c, out)
+}
+
+// approveWithdraw 受理申请中的提现；启用自动转账时，个人微信提现受理后立即发起微信商家转账，结果同步落地到提现记录
+func approveWithdraw(ctx context.Context, id, operatorID uint, remark string) (*model.WithdrawRecord, *model.WechatTransferRecord, error) {
+	db := database.GetDB()
+	var rec model.WithdrawRecord
+	if id == 0 || db.First(&rec, id).Error != nil {
+		return nil, nil, errors.New("记录不存在")
+	}
+	if rec.Status != model.WithdrawStatusPending { // 仅申请中可受理
+		return nil, nil, errors.New("当前状态不可受理")
+	}
 	now := time.Now()
-	rec.Status = model.WithdrawStatusProcessing
-	rec.ProcessedAt = &now
-	if u, ok := uid.(uint); ok {
-		rec.ProcessedBy = u
+	updates := map[string]any{"status": model.WithdrawStatusProcessing, "processed_at": now, "processed_by": operatorID}
+	if remark != "" {
+		updates["remark"] = remark
 	}
-	if req.Remark != "" {
-		rec.Remark = req.Remark
+	// 按状态条件更新：与并发的拒绝只有一方生效，避免已解冻退回的提现又被受理并发起转账
+	res := db.Model(&model.WithdrawRecord{}).Where("id = ? AND status = ?", rec.ID, model.WithdrawStatusPending).Updates(updates)
+	if res.Error != nil {
+		return nil, nil, res.Error
 	}
-	if err := db.Save(&rec).Error; err != nil {
-		utils.Error(c, utils.CodeError, err.Error())
-		return
+	if res.RowsAffected == 0 {
+		return nil, nil, errors.New("当前状态不可受理")
 	}
-	utils.Success(c, rec)
+	rec.Status = model.WithdrawStatusProcessing
+	rec.ProcessedAt = &now
+	rec.ProcessedBy = operatorID
+	if remark != "" {
+		rec.Remark = remark
+	}
+	if service.TransferEnabled() && rec.StoreID == 0 && rec.WithdrawType == model.WithdrawTypeWechat {
+		tr, err := service.NewWithdrawTransferService().StartTransfer(ctx, rec.ID)
+		if err != nil {
+			return nil, nil, errors.New("已受理，发起微信转账失败: " + err.Error())
+		}
+		_ = db.First(&rec, rec.ID).Error
+		return &rec, tr, nil
+	}
+	return &rec, nil, nil
 }
 
 // POST /api/v1/admin/withdraws/:id/complete 将状态置为已完成(3)
@@ -192,42 +262,24 @@ func (h *WithdrawAdminHandler) Complete(c *gin.Context) {
 	id := strings.TrimSpace(c.Param("id"))
 	var req withdrawActionReq
 	_ = c.ShouldBindJSON(&req)
-	db := database.GetDB()
-	var rec model.WithdrawRecord
-	if err := db.First(&rec, id).Error; err != nil {
-		utils.Error(c, utils.CodeError, "记录不存在")
-		return
-	}
-	if rec.Status != model.WithdrawStatusProcessing { // 仅处理中可完成
-		utils.Error(c, utils.CodeError, "当前状态不可完成")
-		return
-	}
 	uid, _ := c.Get("user_id")
-	now := time.Now()
-	rec.Status = model.WithdrawStatusCompleted
-	rec.ProcessedAt = &now
-	if u, ok := uid.(uint); ok {
-		rec.ProcessedBy = u
-	}
-	// 统一完成阶段的备注为 JSON（含 withdraw_no/amount/fee/net）
-	toCents := func(d decimal.Decimal) int64 { return d.Mul(decimal.NewFromInt(100)).IntPart() }
-	rmkPaid := buildPaidRemark(rec.WithdrawNo, toCents(rec.Amount), toCents(rec.Fee), toCents(rec.ActualAmount))
-	rec.Remark = rmkPaid
-	if err := db.Save(&rec).Error; err != nil {
+	operatorID, _ := uid.(uint)
+	// 锁定提现记录后校验状态与在途打款，并在同一事务内完成扣减冻结与过账
+	rec, err := service.CompleteWithdrawManually(database.GetDB(), toUintWd(id), operatorID)
+	if err != nil {
 		utils.Error(c, utils.CodeError, err.Error())
 		return
 	}
 
-	// paid：解冻并完成扣减（申请时已将可用余额转入冻结，这里仅减少冻结并记录完成流水）
-	if err := finalizeWalletForWithdraw(db, &rec); err != nil {
-		utils.Error(c, utils.CodeError, fmt.Sprintf("wallet deduction failed: %s", err.Error()))
+	// 门店提现不涉及个人佣金
+	if rec.StoreID > 0 {
+		utils.Success(c, rec)
 		return
 	}
-
 	// 按时间顺序消费该用户已解冻佣金，直至覆盖本次实际打款金额
 	var opIDPtr *uint
-	if u, ok := uid.(uint); ok && u != 0 {
-		opIDPtr = &u
+	if operatorID != 0 {
+		opIDPtr = &operatorID
 	}
 	note := fmt.Sprintf("withdraw %s", rec.WithdrawNo)
 	if _, consumed, err := commission.ConsumeUserAvailableCommissions(rec.UserID, rec.ActualAmount, rec.WithdrawNo, opIDPtr, note); err != nil {
@@ -238,91 +290,22 @@ func (h *WithdrawAdminHandler) Complete(c *gin.Context) {
 	utils.Success(c, rec)
 }
 
-// finalizeWalletForWithdraw 解冻冻结金额并记录完成流水（金额单位：分）
-func finalizeWalletForWithdraw(db *gorm.DB, rec *model.WithdrawRecord) error {
-	// 将 decimal 金额转换为分（int64）
-	toCents := func(d decimal.Decimal) int64 { return d.Mul(decimal.NewFromInt(100)).IntPart() }
-	amountCents := toCents(rec.Amount)
-	feeCents := toCents(rec.Fee)
-	netCents := toCents(rec.ActualAmount)
-
-	// 开启事务
-	tx := db.Begin()
-	if tx.Error != nil {
-		return tx.Error
-	}
-
-	// 读取并锁定钱包当前冻结金额
-	var row struct{ Frozen int64 }
-	if err := tx.Table("wallets").Select("frozen").Where("user_id = ?", rec.UserID).Take(&row).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("query wallet frozen: %w", err)
-	}
-	if row.Frozen < amountCents {
-		tx.Rollback()
-		return fmt.Errorf("insufficient wallet frozen: have=%d need=%d", row.Frozen, amountCents)
-	}
-	newFrozen := row.Frozen - amountCents
-	if err := tx.Exec("UPDATE wallets SET frozen = ? WHERE user_id = ?", newFrozen, rec.UserID).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("update wallet frozen: %w", err)
-	}
-	// 记录完成流水（对可用余额无影响，amount=0；备注体现手续费与实付）
-	// 为便于对账，可查询最新余额作为 balance_after
-	var balRow struct{ Balance int64 }
-	if err := tx.Table("wallets").Select("balance").Where("user_id = ?", rec.UserID).Take(&balRow).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("query wallet balance: %w", err)
-	}
-	// remark 统一为 JSON
-	rmkJSON := buildPaidRemark(rec.WithdrawNo, amountCents, feeCents, netCents)
-	if err := tx.Exec("INSERT INTO wallet_transactions (user_id, type, amount, balance_after, remark, created_at) VALUES (?,?,?,?,?,NOW())",
-		rec.UserID, "withdraw_paid", 0, balRow.Balance, rmkJSON).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("insert wallet tx: %w", err)
-	}
-	return tx.Commit().Error
-}
-
-// rollbackWalletForWithdrawReject 拒绝提现：从冻结中释放并返还到可用余额；记录 JSON remark
+// rollbackWalletForWithdrawReject 拒绝提现：从冻结中释放并返还到可用余额（经账本过账）；记录 JSON remark
 func rollbackWalletForWithdrawReject(db *gorm.DB, rec *model.WithdrawRecord) error {
+	// 门店提现申请时未冻结资金，无需回滚
+	if rec.StoreID > 0 {
+		return nil
+	}
 	// 将 decimal 金额转换为分（int64）
 	toCents := func(d decimal.Decimal) int64 { return d.Mul(decimal.NewFromInt(100)).IntPart() }
 	amountCents := toCents(rec.Amount)
-	feeCents := toCents(rec.Fee)
-	netCents := toCents(rec.ActualAmount)
-
-	tx := db.Begin()
-	if tx.Error != nil {
-		return tx.Error
-	}
-	// 读取并锁定钱包当前余额与冻结
-	var row struct {
-		Balance int64
-		Frozen  int64
-	}
-	if err := tx.Table("wallets").Select("balance,frozen").Where("user_id = ?", rec.UserID).Take(&row).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("query wallet: %w", err)
-	}
-	if row.Frozen < amountCents {
-		tx.Rollback()
-		return fmt.Errorf("insufficient frozen to rollback: have=%d need=%d", row.Frozen, amountCents)
-	}
-	newFrozen := row.Frozen - amountCents
-	newBalance := row.Balance + amountCents
-	if err := tx.Exec("UPDATE wallets SET balance = ?, frozen = ? WHERE user_id = ?", newBalance, newFrozen, rec.UserID).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("update wallet rollback: %w", err)
-	}
-	// 插入解冻流水（可用余额增加），remark 为 JSON
-	rmkJSON := buildRejectUnfreezeRemark(rec.WithdrawNo, amountCents, feeCents, netCents)
-	if err := tx.Exec("INSERT INTO wallet_transactions (user_id, type, amount, balance_after, remark, created_at) VALUES (?,?,?,?,?,NOW())",
-		rec.UserID, "withdraw_reject_unfreeze", amountCents, newBalance, rmkJSON).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("insert wallet tx rollback: %w", err)
-	}
-	return tx.Commit().Error
+	rmkJSON := buildRejectUnfreezeRemark(rec.WithdrawNo, amountCents, toCents(rec.Fee), toCents(rec.ActualAmount))
+	return db.Transaction(func(tx *gorm.DB) error {
+		if _, err := service.AdjustWalletTx(tx, rec.UserID, amountCents, -amountCents, "withdraw_reject_unfreeze", rmkJSON); err != nil {
+			return fmt.Errorf("unfreeze wallet: %w", err)
+		}
+		return nil
+	})
 }
 
 // POST /api/v1/admin/withdraws/:id/reject 将状态置为已拒绝(4)
@@ -330,40 +313,23 @@ func (h *WithdrawAdminHandler) Reject(c *gin.Context) {
 	id := strings.TrimSpace(c.Param("id"))
 	var req withdrawActionReq
 	_ = c.ShouldBindJSON(&req)
-	db := database.GetDB()
-	var rec model.WithdrawRecord
-	if err := db.First(&rec, id).Error; err != nil {
-		utils.Error(c, utils.CodeError, "记录不存在")
-		return
-	}
-	if rec.Status != model.WithdrawStatusPending && rec.Status != model.WithdrawStatusProcessing { // 申请中/处理中可拒绝
-		utils.Error(c, utils.CodeError, "当前状态不可拒绝")
-		return
-	}
 	uid, _ := c.Get("user_id")
-	now := time.Now()
-	rec.Status = model.WithdrawStatusRejected
-	rec.ProcessedAt = &now
-	if u, ok := uid.(uint); ok {
-		rec.ProcessedBy = u
-	}
-	// 统一拒绝阶段的备注为 JSON（含 withdraw_no/amount/fee/net）
-	toCents := func(d decimal.Decimal) int64 { return d.Mul(decimal.NewFromInt(100)).IntPart() }
-	rmkRej := buildRejectUnfreezeRemark(rec.WithdrawNo, toCents(rec.Amount), toCents(rec.Fee), toCents(rec.ActualAmount))
-	rec.Remark = rmkRej
-	if err := db.Save(&rec).Error; err != nil {
+	operatorID, _ := uid.(uint)
+	// 锁定提现记录后按状态条件更新并解冻，并发拒绝只退回一次
+	rec, err := service.RejectWithdrawManually(database.GetDB(), toUintWd(id), operatorID)
+	if err != nil {
 		utils.Error(c, utils.CodeError, err.Error())
 		return
 	}
-	// 拒绝：解冻并恢复可用余额，记录解冻流水
-	if err := rollbackWalletForWithdrawReject(db, &rec); err != nil {
-		utils.Error(c, utils.CodeError, fmt.Sprintf("wallet rollback failed: %s", err.Error()))
-		return
-	}
 	utils.Success(c, rec)
 }
 
 func toIntWd(s string) int { var n int; _, _ = fmt.Sscanf(s, "%d", &n); return n }
+func toUintWd(s string) uint {
+	var n uint
+	_, _ = fmt.Sscanf(s, "%d", &n)
+	return n
+}
 func csvSafeWd(s string) string {
 	s = strings.ReplaceAll(s, ",", " ")
 	s = strings.ReplaceAll(s, "\n", " ")
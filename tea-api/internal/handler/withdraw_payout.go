@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"io"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+
+	"tea-api/internal/service"
+	"tea-api/pkg/utils"
+)
+
+// WithdrawPayoutHandler 银行批量代付：生成付款文件、下载与导入回盘文件
+type WithdrawPayoutHandler struct {
+	svc *service.WithdrawPayoutService
+}
+
+func NewWithdrawPayoutHandler() *WithdrawPayoutHandler {
+	return &WithdrawPayoutHandler{svc: service.NewWithdrawPayoutService()}
+}
+
+// GET /api/v1/admin/withdraws/payout-layouts 可选付款文件版式
+func (h *WithdrawPayoutHandler) Layouts(c *gin.Context) {
+	utils.Success(c, h.svc.Layouts())
+}
+
+type createPayoutBatchReq struct {
+	Layout string `json:"layout"`
+	IDs    []uint `json:"ids"`
+}
+
+// POST /api/v1/admin/withdraws/payout-batches 由已受理的提现生成代付批次，body: {"layout":"generic_csv","ids":[1,2]}；
+// ids 为空时取全部已受理的银行卡提现
+func (h *WithdrawPayoutHandler) Create(c *gin.Context) {
+	var req createPayoutBatchReq
+	_ = c.ShouldBindJSON(&req)
+	if len(req.IDs) > 1000 {
+		utils.Error(c, utils.CodeError, "单批次最多 1000 笔")
+		return
+	}
+	uid, _ := c.Get("user_id")
+	opID, _ := uid.(uint)
+	batch, skipped, err := h.svc.GenerateBatch(req.Layout, req.IDs, opID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	if skipped == nil {
+		skipped = []service.PayoutSkip{}
+	}
+	utils.Success(c, gin.H{"batch": batch, "skipped": skipped})
+}
+
+// GET /api/v1/admin/withdraws/payout-batches?status=&page=&limit=
+func (h *WithdrawPayoutHandler) List(c *gin.Context) {
+	page := toIntWd(c.DefaultQuery("page", "1"))
+	size := toIntWd(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if size <= 0 || size > 200 {
+		size = 20
+	}
+	list, total, err := h.svc.ListBatches(toIntWd(c.Query("status")), page, size)
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	utils.PageSuccess(c, list, total, page, size)
+}
+
+// GET /api/v1/admin/withdraws/payout-batches/:id 批次详情（含明细）
+func (h *WithdrawPayoutHandler) Get(c *gin.Context) {
+	id := parseUintParam(c, "id")
+	if id == 0 {
+		utils.Error(c, utils.CodeError, "无效的批次ID")
+		return
+	}
+	batch, err := h.svc.GetBatch(id)
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	utils.Success(c, batch)
+}
+
+// GET /api/v1/admin/withdraws/payout-batches/:id/file 下载银行付款文件
+func (h *WithdrawPayoutHandler) Download(c *gin.Context) {
+	id := parseUintParam(c, "id")
+	if id == 0 {
+		utils.Error(c, utils.CodeError, "无效的批次ID")
+		return
+	}
+	data, filename, err := h.svc.RenderBatchFile(id)
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename="+url.PathEscape(filename))
+	c.Data(200, "application/octet-stream", data)
+}
+
+// POST /api/v1/admin/withdraws/payout-batches/:id/result 导入银行回盘文件（multipart 字段 file，或直接以请求体上传）
+func (h *WithdrawPayoutHandler) ImportResult(c *gin.Context) {
+	id := parseUintParam(c, "id")
+	if id == 0 {
+		utils.Error(c, utils.CodeError, "无效的批次ID")
+		return
+	}
+	var data []byte
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			utils.Error(c, utils.CodeError, err.Error())
+			return
+		}
+		defer f.Close()
+		data, err = io.ReadAll(io.LimitReader(f, 10<<20))
+		if err != nil {
+			utils.Error(c, utils.CodeError, err.Error())
+			return
+		}
+	} else {
+		data, _ = io.ReadAll(io.LimitReader(c.Request.Body, 10<<20))
+	}
+	if len(data) == 0 {
+		utils.Error(c, utils.CodeError, "请上传回盘文件")
+		return
+	}
+	uid, _ := c.Get("user_id")
+	opID, _ := uid.(uint)
+	report, err := h.svc.ImportResult(id, data, opID)
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	utils.Success(c, report)
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service"
+	"tea-api/pkg/database"
+	"tea-api/pkg/utils"
+)
+
+// WithdrawTransferHandler 提现微信商家转账：发起/同步转账、查询转账单与接收渠道回调
+type WithdrawTransferHandler struct {
+	svc *service.WithdrawTransferService
+}
+
+func NewWithdrawTransferHandler() *WithdrawTransferHandler {
+	return &WithdrawTransferHandler{svc: service.NewWithdrawTransferService()}
+}
+
+// POST /api/v1/admin/withdraws/:id/transfer 对已受理的提现发起微信转账；已有处理中转账单时同步其结果
+func (h *WithdrawTransferHandler) Start(c *gin.Context) {
+	id := parseUintParam(c, "id")
+	if id == 0 {
+		utils.Error(c, utils.CodeError, "无效的提现ID")
+		return
+	}
+	tr, err := h.svc.StartTransfer(c.Request.Context(), id)
+	if err != nil {
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	utils.Success(c, tr)
+}
+
+type batchTransferReq struct {
+	IDs []uint `json:"ids" binding:"required"`
+}
+
+// POST /api/v1/admin/withdraws/transfer 批量发起微信转账，body: {"ids":[1,2]}，返回每笔的处理结果
+func (h *WithdrawTransferHandler) BatchStart(c *gin.Context) {
+	var req batchTransferReq
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 {
+		utils.Error(c, utils.CodeError, "请选择要转账的提现记录")
+		return
+	}
+	if len(req.IDs) > 100 {
+		utils.Error(c, utils.CodeError, "单次最多处理 100 笔")
+		return
+	}
+	utils.Success(c, h.svc.StartTransfers(c.Request.Context(), req.IDs))
+}
+
+// GET /api/v1/admin/withdraws/:id/transfer 查询提现对应的转账单
+func (h *WithdrawTransferHandler) Get(c *gin.Context) {
+	id := parseUintParam(c, "id")
+	if id == 0 {
+		utils.Error(c, utils.CodeError, "无效的提现ID")
+		return
+	}
+	var tr model.WechatTransferRecord
+	if err := database.GetDB().Where("withdraw_id = ?", id).First(&tr).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.Error(c, utils.CodeError, "该提现尚未发起转账")
+			return
+		}
+		utils.Error(c, utils.CodeError, err.Error())
+		return
+	}
+	utils.Success(c, tr)
+}
+
+// Notify 微信商家转账批次回调，验签后主动查询明细结果并落地
+// POST /api/v1/payment/wechat/transfer-notify
+func (h *WithdrawTransferHandler) Notify(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err == nil {
+		err = h.svc.HandleNotify(c.Request.Context(), c.Request.Header, body)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": "FAIL", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": "SUCCESS", "message": "成功"})
+}
@@ -1,13 +1,19 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"tea-api/internal/config"
+	"tea-api/internal/model"
+	"tea-api/internal/service"
+	"tea-api/internal/service/ledger"
 	"tea-api/pkg/database"
 	"tea-api/pkg/response"
 )
@@ -44,7 +50,7 @@ func ListMyBankAccounts(c *gin.Context) {
 	}
 	if err := database.GetDB().Table("user_bank_accounts").
 		Select("id, account_type, account_name, account_no, bank_name, is_default, created_at").
-		Where("user_id = ?", uid).Order("id desc").
+		Where("user_id = ? AND deleted_at IS NULL", uid).Order("id desc").
 		Find(&[]rawRow{}).Error; err != nil {
 		response.Error(c, http.StatusInternalServerError, "查询提现账户失败")
 		return
@@ -52,7 +58,7 @@ func ListMyBankAccounts(c *gin.Context) {
 	var raw []rawRow
 	database.GetDB().Table("user_bank_accounts").
 		Select("id, account_type, account_name, account_no, bank_name, is_default, created_at").
-		Where("user_id = ?", uid).Order("id desc").Find(&raw)
+		Where("user_id = ? AND deleted_at IS NULL", uid).Order("id desc").Find(&raw)
 	rows = make([]bankAccountRow, 0, len(raw))
 	for _, r := range raw {
 		masked := maskAccount(r.AccountNo)
@@ -108,7 +114,8 @@ func DeleteMyBankAccount(c *gin.Context) {
 		response.BadRequest(c, "非法ID")
 		return
 	}
-	tx := database.GetDB().Exec("DELETE FROM user_bank_accounts WHERE id = ? AND user_id = ?", bid, uid)
+	// 软删除，保留删除时间用于提现账户变更冷静期判断
+	tx := database.GetDB().Where("id = ? AND user_id = ?", bid, uid).Delete(&model.UserBankAccount{})
 	if tx.Error != nil {
 		response.Error(c, http.StatusInternalServerError, "删除失败")
 		return
@@ -119,15 +126,17 @@ func DeleteMyBankAccount(c *gin.Context) {
 // ===== 用户提现申请 =====
 
 type withdrawalRow struct {
-	ID            uint   `json:"id"`
-	UserID        uint   `json:"user_id"`
-	AmountCents   int64  `json:"amount_cents"`
-	FeeCents      int64  `json:"fee_cents"`
-	Status        string `json:"status"`
-	BankAccountID *uint  `json:"bank_account_id"`
-	Remark        string `json:"remark"`
-	RequestedAt   string `json:"requested_at"`
-	ProcessedAt   string `json:"processed_at,omitempty"`
+	ID              uint   `json:"id"`
+	UserID          uint   `json:"user_id"`
+	AmountCents     int64  `json:"amount_cents"`
+	FeeCents        int64  `json:"fee_cents"`
+	Status          string `json:"status"`
+	BankAccountID   *uint  `json:"bank_account_id"`
+	Remark          string `json:"remark"`
+	InvoiceRequired bool   `json:"invoice_required"`
+	InvoiceNo       string `json:"invoice_no,omitempty"`
+	RequestedAt     string `json:"requested_at"`
+	ProcessedAt     string `json:"processed_at,omitempty"`
 }
 
 // CreateMyWithdrawal POST /api/v1/wallet/withdrawals （当前用户）
@@ -156,6 +165,9 @@ func createUserWithdrawalCommon(c *gin.Context, useCurrent bool) {
 		AmountCents   int64  `json:"amount_cents"`
 		Currency      string `json:"currency"`
 		Note          string `json:"note"`
+		// 需要开具发票的提现（如企业/个体合伙人）须指定发票抬头，开票走统一发票子系统
+		InvoiceRequired bool `json:"invoice_required"`
+		InvoiceTitleID  uint `json:"invoice_title_id"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil || req.AmountCents <= 0 {
 		response.BadRequest(c, "参数错误或金额需大于0")
@@ -178,10 +190,17 @@ func createUserWithdrawalCommon(c *gin.Context, useCurrent bool) {
 		response.BadRequest(c, "余额不足")
 		return
 	}
+	if req.InvoiceRequired {
+		var cnt int64
+		if req.InvoiceTitleID == 0 || database.GetDB().Model(&model.InvoiceTitle{}).Where("id = ? AND user_id = ?", req.InvoiceTitleID, pathUID).Count(&cnt).Error != nil || cnt == 0 {
+			response.BadRequest(c, "需开票的提现须选择有效的发票抬头")
+			return
+		}
+	}
 	// 校验提现账户归属（如传入）
 	if req.BankAccountID != 0 {
 		var cnt int64
-		if err := database.GetDB().Table("user_bank_accounts").Where("id = ? AND user_id = ?", req.BankAccountID, pathUID).Count(&cnt).Error; err != nil || cnt == 0 {
+		if err := database.GetDB().Table("user_bank_accounts").Where("id = ? AND user_id = ? AND deleted_at IS NULL", req.BankAccountID, pathUID).Count(&cnt).Error; err != nil || cnt == 0 {
 			response.BadRequest(c, "提现账户无效或不属于当前用户")
 			return
 		}
@@ -198,47 +217,87 @@ func createUserWithdrawalCommon(c *gin.Context, useCurrent bool) {
 	}
 	net := req.AmountCents - fee
 
-	// 冻结资金：将可用余额转入冻结，减少并发风险
-	// 读取当前可用余额
-	var cur struct {
-		Balance int64
-		Frozen  int64
-	}
-	if err := database.GetDB().Table("wallets").Select("balance,frozen").Where("user_id = ?", pathUID).Take(&cur).Error; err != nil {
-		response.Error(c, http.StatusInternalServerError, "读取钱包失败")
-		return
-	}
-	if cur.Balance < req.AmountCents {
-		response.BadRequest(c, "余额不足（可用余额）")
-		return
-	}
-	newBal := cur.Balance - req.AmountCents
-	newFrozen := cur.Frozen + req.AmountCents
-	if err := database.GetDB().Exec("UPDATE wallets SET balance = ?, frozen = ? WHERE user_id = ?", newBal, newFrozen, pathUID).Error; err != nil {
-		response.Error(c, http.StatusInternalServerError, "冻结资金失败")
-		return
-	}
-	// 记录冻结流水（可用余额减少），remark 统一为 JSON
+	// 冻结资金：可用余额转入冻结并记冻结流水（remark 统一为 JSON），经账本过账；
+	// 与风控检查、提现请求记录、发票申请同一事务，风控拒绝、余额不足、登记发票或落库失败时整体回滚
 	remarkJSON := buildFreezeRemark(req.AmountCents, fee, net)
-	if err := database.GetDB().Exec("INSERT INTO wallet_transactions (user_id, type, amount, balance_after, remark, created_at) VALUES (?,?,?,?,?,NOW())",
-		pathUID, "withdraw_freeze", -req.AmountCents, newBal, remarkJSON).Error; err != nil {
-		response.Error(c, http.StatusInternalServerError, "记录冻结流水失败")
-		return
+	wr := model.WithdrawalRequest{
+		UserID:          pathUID,
+		Amount:          req.AmountCents,
+		Fee:             fee,
+		Status:          "pending",
+		InvoiceRequired: req.InvoiceRequired,
+		Remark:          req.Note,
 	}
-
-	// 创建提现请求记录（记录金额与手续费）
-	tx := database.GetDB().Exec("INSERT INTO withdrawal_requests (user_id, amount, fee, status, bank_account_id, remark, requested_at) VALUES (?,?,?,?,?,?,NOW())",
-		pathUID, req.AmountCents, fee, "pending", req.BankAccountID, req.Note)
-	if tx.Error != nil {
-		response.Error(c, http.StatusInternalServerError, "创建提现请求失败")
+	if req.BankAccountID != 0 {
+		wr.BankAccountID = &req.BankAccountID
+	}
+	var (
+		inv        *model.Invoice
+		invoiceErr error
+		riskErr    error
+		blocked    *service.WithdrawRiskReason
+	)
+	if err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		// 先锁定钱包行，同一用户的并发申请串行执行，限额与频次按已落库的申请计算
+		var wallet model.Wallet
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", pathUID).First(&wallet).Error; err != nil {
+			return err
+		}
+		// 风控：限额频次、账户冷静期与实名一致性不通过直接拒绝；可疑模式标记人工复核
+		risk, err := service.NewWithdrawRiskService().CheckApplicationTx(tx, pathUID, req.AmountCents, req.BankAccountID, time.Now())
+		if err != nil {
+			riskErr = err
+			return err
+		}
+		if blocked = risk.Blocked(); blocked != nil {
+			return errors.New(blocked.Message)
+		}
+		wr.RiskFlags = risk.ReviewFlagsJSON()
+		wr.NeedReview = len(risk.ReviewReasons()) > 0
+		if _, err := service.AdjustWalletTx(tx, pathUID, -req.AmountCents, req.AmountCents, "withdraw_freeze", remarkJSON); err != nil {
+			return err
+		}
+		if err := tx.Create(&wr).Error; err != nil {
+			return err
+		}
+		// 需开票时登记发票申请，开具后回写 withdrawal_requests.invoice_no
+		if req.InvoiceRequired {
+			inv, invoiceErr = service.RequestWithdrawalInvoiceTx(tx, pathUID, req.InvoiceTitleID, wr.ID, req.AmountCents)
+			return invoiceErr
+		}
+		return nil
+	}); err != nil {
+		if blocked != nil {
+			response.BadRequest(c, blocked.Message)
+			return
+		}
+		if riskErr != nil {
+			response.Error(c, http.StatusInternalServerError, "提现风控检查失败")
+			return
+		}
+		if errors.Is(err, ledger.ErrInsufficientBalance) {
+			response.BadRequest(c, "余额不足（可用余额）")
+			return
+		}
+		if invoiceErr != nil {
+			response.BadRequest(c, "登记发票申请失败: "+invoiceErr.Error())
+			return
+		}
+		response.Error(c, http.StatusInternalServerError, "冻结资金失败")
 		return
 	}
-	response.Success(c, gin.H{
+	resp := gin.H{
+		"id":               wr.ID,
 		"status":           "pending",
 		"amount_cents":     req.AmountCents,
 		"fee_cents":        fee,
 		"net_amount_cents": net,
-	})
+		"need_review":      wr.NeedReview,
+	}
+	if inv != nil {
+		resp["invoice_request_no"] = inv.RequestNo
+	}
+	response.Success(c, resp)
 }
 
 // ListUserWithdrawals GET /api/v1/users/:id/withdrawals
@@ -263,15 +322,17 @@ func ListUserWithdrawals(c *gin.Context) {
 	}
 
 	type raw struct {
-		ID            uint
-		UserID        uint
-		Amount        int64
-		Fee           int64
-		Status        string
-		BankAccountID *uint
-		Remark        string
-		RequestedAt   time.Time
-		ProcessedAt   *time.Time
+		ID              uint
+		UserID          uint
+		Amount          int64
+		Fee             int64
+		Status          string
+		BankAccountID   *uint
+		Remark          string
+		InvoiceRequired bool
+		InvoiceNo       string
+		RequestedAt     time.Time
+		ProcessedAt     *time.Time
 	}
 	var total int64
 	q := database.GetDB().Table("withdrawal_requests").Where("user_id = ?", uid)
@@ -293,6 +354,7 @@ func ListUserWithdrawals(c *gin.Context) {
 		items = append(items, withdrawalRow{
 			ID: r.ID, UserID: r.UserID, AmountCents: r.Amount, FeeCents: r.Fee, Status: r.Status,
 			BankAccountID: r.BankAccountID, Remark: r.Remark,
+			InvoiceRequired: r.InvoiceRequired, InvoiceNo: r.InvoiceNo,
 			RequestedAt: r.RequestedAt.Format(time.RFC3339), ProcessedAt: processed,
 		})
 	}
@@ -0,0 +1,311 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/pkg/database"
+)
+
+// IdempotencyHeader 客户端传入的幂等键请求头
+const IdempotencyHeader = "Idempotency-Key"
+
+const (
+	idempotencyTTL        = 24 * time.Hour
+	idempotencyMaxBody    = 1 << 20
+	idempotencyMaxKeyLen  = 128
+	idempotencyRedisKey   = "idem:%s"
+	idempotencyProcessing = "processing"
+	idempotencyDone       = "done"
+)
+
+// idemEntry 幂等记录（Redis 中以 JSON 存储）
+type idemEntry struct {
+	State       string `json:"state"`
+	RequestHash string `json:"request_hash"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        string `json:"body"`
+}
+
+// idemStore 幂等记录存储：acquire 成功占位时返回 nil，已存在时返回已有记录
+type idemStore interface {
+	acquire(ctx context.Context, scope string, userID uint, route string, e idemEntry) (*idemEntry, error)
+	complete(ctx context.Context, scope string, e idemEntry) error
+	release(ctx context.Context, scope string) error
+}
+
+// Idempotency 幂等中间件：需挂在认证中间件之后。
+// 请求携带 Idempotency-Key 时，以 用户+方法+路径+Key 为作用域：
+//   - 首次请求正常执行，仅保存成功响应（2xx 且响应体 code 为 0 或不含 code），
+//     失败响应释放占位，客户端可用同一 Key 重试；
+//   - 重复请求直接回放首次响应，并带上 Idempotent-Replayed: true；
+//   - 同一 Key 但请求体不同返回 422；首次请求尚未完成时返回 409；
+//   - 请求体超过 1MB 时返回 413，处理中 panic 时释放占位后继续抛出。
+//
+// 未携带该请求头的请求不受影响。
+func Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimSpace(c.GetHeader(IdempotencyHeader))
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > idempotencyMaxKeyLen {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Idempotency-Key 过长"})
+			return
+		}
+
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(c.Request.Body, idempotencyMaxBody+1))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": 400, "message": "读取请求体失败"})
+				return
+			}
+			// 超限请求体若截断后再哈希，不同请求可能得到相同摘要，直接拒绝
+			if len(body) > idempotencyMaxBody {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"code": 413, "message": "请求体过大"})
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+		}
+
+		var userID uint
+		if v, ok := c.Get("user_id"); ok {
+			if id, ok2 := v.(uint); ok2 {
+				userID = id
+			}
+		}
+		route := c.Request.Method + " " + c.Request.URL.Path
+		scope := hashHex(fmt.Sprintf("%d|%s|%s", userID, route, key))
+		reqHash := hashHex(string(body))
+
+		ctx := c.Request.Context()
+		store := pickIdemStore()
+		if store == nil {
+			// 无可用存储时不阻断业务
+			c.Next()
+			return
+		}
+		existing, err := store.acquire(ctx, scope, userID, route, idemEntry{State: idempotencyProcessing, RequestHash: reqHash})
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "幂等校验失败"})
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.RequestHash != reqHash:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"code": 422, "message": "Idempotency-Key 已用于不同的请求"})
+			case existing.State != idempotencyDone:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"code": 409, "message": "相同请求正在处理中，请稍后重试"})
+			default:
+				if existing.ContentType != "" {
+					c.Header("Content-Type", existing.ContentType)
+				}
+				c.Header("Idempotent-Replayed", "true")
+				c.Status(existing.Status)
+				_, _ = c.Writer.WriteString(existing.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		// 处理中 panic 时释放占位，否则该 Key 会在 TTL 内一直处于处理中而阻塞重试
+		defer func() {
+			if r := recover(); r != nil {
+				_ = store.release(context.Background(), scope)
+				panic(r)
+			}
+		}()
+		w := &idemResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		status := c.Writer.Status()
+		if !idempotentSucceeded(status, w.buf.Bytes()) {
+			_ = store.release(context.Background(), scope)
+			return
+		}
+		_ = store.complete(context.Background(), scope, idemEntry{
+			State:       idempotencyDone,
+			RequestHash: reqHash,
+			Status:      status,
+			ContentType: c.Writer.Header().Get("Content-Type"),
+			Body:        w.buf.String(),
+		})
+	}
+}
+
+// idempotentSucceeded 判断响应是否成功：handler 多以 HTTP 200/400 + 非 0 的 code 返回业务失败，
+// 这类响应（含超时等临时错误）不应在 TTL 内被回放
+func idempotentSucceeded(status int, body []byte) bool {
+	if status < 200 || status >= 300 {
+		return false
+	}
+	var env struct {
+		Code *int `json:"code"`
+	}
+	if err := json.Unmarshal(body, &env); err != nil || env.Code == nil {
+		return true
+	}
+	return *env.Code == 0
+}
+
+func hashHex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// pickIdemStore 优先使用 Redis，不可用时退化为数据库
+func pickIdemStore() idemStore {
+	if r := database.GetRedis(); r != nil {
+		return &redisIdemStore{rdb: r, fallback: dbIdemStoreOrNil()}
+	}
+	return dbIdemStoreOrNil()
+}
+
+func dbIdemStoreOrNil() idemStore {
+	if db := database.GetDB(); db != nil {
+		return &dbIdemStore{db: db}
+	}
+	return nil
+}
+
+// idemResponseWriter 在写出响应的同时缓存响应体
+type idemResponseWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *idemResponseWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idemResponseWriter) WriteString(s string) (int, error) {
+	w.buf.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// ===== Redis 存储 =====
+
+type redisIdemStore struct {
+	rdb      *redis.Client
+	fallback idemStore
+	// Redis 占位失败而改用数据库时，后续 complete/release 也走数据库
+	useFallback bool
+}
+
+func (s *redisIdemStore) acquire(ctx context.Context, scope string, userID uint, route string, e idemEntry) (*idemEntry, error) {
+	key := fmt.Sprintf(idempotencyRedisKey, scope)
+	bs, _ := json.Marshal(e)
+	ok, err := s.rdb.SetNX(ctx, key, bs, idempotencyTTL).Result()
+	if err != nil {
+		if s.fallback == nil {
+			return nil, err
+		}
+		s.useFallback = true
+		return s.fallback.acquire(ctx, scope, userID, route, e)
+	}
+	if ok {
+		return nil, nil
+	}
+	raw, err := s.rdb.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var existing idemEntry
+	if err := json.Unmarshal(raw, &existing); err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+func (s *redisIdemStore) complete(ctx context.Context, scope string, e idemEntry) error {
+	if s.useFallback {
+		return s.fallback.complete(ctx, scope, e)
+	}
+	bs, _ := json.Marshal(e)
+	return s.rdb.Set(ctx, fmt.Sprintf(idempotencyRedisKey, scope), bs, idempotencyTTL).Err()
+}
+
+func (s *redisIdemStore) release(ctx context.Context, scope string) error {
+	if s.useFallback {
+		return s.fallback.release(ctx, scope)
+	}
+	return s.rdb.Del(ctx, fmt.Sprintf(idempotencyRedisKey, scope)).Err()
+}
+
+// ===== 数据库存储 =====
+
+type dbIdemStore struct{ db *gorm.DB }
+
+func (s *dbIdemStore) acquire(ctx context.Context, scope string, userID uint, route string, e idemEntry) (*idemEntry, error) {
+	db := s.db.WithContext(ctx)
+	for attempt := 0; attempt < 2; attempt++ {
+		rec := model.IdempotencyRecord{
+			ScopeKey:    scope,
+			UserID:      userID,
+			Route:       route,
+			RequestHash: e.RequestHash,
+			State:       e.State,
+			ExpiresAt:   time.Now().Add(idempotencyTTL),
+		}
+		createErr := db.Create(&rec).Error
+		if createErr == nil {
+			return nil, nil
+		}
+		var existing model.IdempotencyRecord
+		if err := db.Where("scope_key = ?", scope).First(&existing).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, createErr
+			}
+			return nil, err
+		}
+		// 过期记录清理后重试一次占位
+		if time.Now().After(existing.ExpiresAt) {
+			if err := db.Where("id = ?", existing.ID).Delete(&model.IdempotencyRecord{}).Error; err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return &idemEntry{
+			State:       existing.State,
+			RequestHash: existing.RequestHash,
+			Status:      existing.ResponseStatus,
+			ContentType: existing.ContentType,
+			Body:        existing.ResponseBody,
+		}, nil
+	}
+	return nil, errors.New("幂等记录占位失败")
+}
+
+func (s *dbIdemStore) complete(ctx context.Context, scope string, e idemEntry) error {
+	return s.db.WithContext(ctx).Model(&model.IdempotencyRecord{}).Where("scope_key = ?", scope).
+		Updates(map[string]any{
+			"state":           e.State,
+			"response_status": e.Status,
+			"content_type":    e.ContentType,
+			"response_body":   e.Body,
+		}).Error
+}
+
+func (s *dbIdemStore) release(ctx context.Context, scope string) error {
+	return s.db.WithContext(ctx).Where("scope_key = ?", scope).Delete(&model.IdempotencyRecord{}).Error
+}
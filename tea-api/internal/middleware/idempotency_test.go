@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/pkg/database"
+)
+
+func TestIdempotency_ReplayAndMismatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file:idem?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.IdempotencyRecord{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	database.DB = db
+
+	calls := 0
+	r := gin.New()
+	r.POST("/orders/from-cart", func(c *gin.Context) { c.Set("user_id", uint(7)) }, Idempotency(), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusOK, gin.H{"order_id": calls})
+	})
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders/from-cart", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(IdempotencyHeader, key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("k1", `{"store_id":1}`)
+	second := send("k1", `{"store_id":1}`)
+	if calls != 1 {
+		t.Fatalf("handler should run once, got %d", calls)
+	}
+	if second.Code != http.StatusOK || second.Body.String() != first.Body.String() || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("unexpected replay: %d %s", second.Code, second.Body.String())
+	}
+	if w := send("k1", `{"store_id":2}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key with different body should be 422, got %d", w.Code)
+	}
+	if w := send("k2", `{"store_id":2}`); w.Code != http.StatusOK || calls != 2 {
+		t.Fatalf("new key should execute handler, got %d calls=%d", w.Code, calls)
+	}
+}
+
+func TestIdempotency_PanicReleasesKeyAndOversizedBodyRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file:idem_panic?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.IdempotencyRecord{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	database.DB = db
+
+	calls := 0
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.POST("/wallet/pay", func(c *gin.Context) { c.Set("user_id", uint(7)) }, Idempotency(), func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		c.JSON(http.StatusOK, gin.H{"paid": true})
+	})
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/wallet/pay", strings.NewReader(body))
+		req.Header.Set(IdempotencyHeader, "k-panic")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(`{"order_id":1}`); w.Code != http.StatusInternalServerError {
+		t.Fatalf("panicking handler should yield 500, got %d", w.Code)
+	}
+	if w := send(`{"order_id":1}`); w.Code != http.StatusOK || calls != 2 {
+		t.Fatalf("retry after panic should run the handler, got %d calls=%d", w.Code, calls)
+	}
+
+	big := `{"note":"` + strings.Repeat("x", idempotencyMaxBody) + `"}`
+	if w := send(big); w.Code != http.StatusRequestEntityTooLarge || calls != 2 {
+		t.Fatalf("oversized body should be 413 without running the handler, got %d calls=%d", w.Code, calls)
+	}
+}
+
+func TestIdempotency_FailedResponseReleasesKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file:idem_fail?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.IdempotencyRecord{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	database.DB = db
+
+	calls := 0
+	r := gin.New()
+	r.POST("/payments/unified-order", func(c *gin.Context) { c.Set("user_id", uint(7)) }, Idempotency(), func(c *gin.Context) {
+		calls++
+		switch calls {
+		case 1: // 通道超时：HTTP 200 + 非 0 code
+			c.JSON(http.StatusOK, gin.H{"code": 1005, "message": "支付通道超时"})
+		case 2:
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误"})
+		default:
+			c.JSON(http.StatusOK, gin.H{"code": 0, "data": gin.H{"prepay_id": calls}})
+		}
+	})
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/payments/unified-order", strings.NewReader(`{"order_id":1}`))
+		req.Header.Set(IdempotencyHeader, "retry-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	send()
+	send()
+	ok := send()
+	if calls != 3 || ok.Header().Get("Idempotent-Replayed") == "true" || !strings.Contains(ok.Body.String(), `"prepay_id":3`) {
+		t.Fatalf("failed attempts should not be cached, calls=%d body=%s", calls, ok.Body.String())
+	}
+	if replay := send(); calls != 3 || replay.Header().Get("Idempotent-Replayed") != "true" || replay.Body.String() != ok.Body.String() {
+		t.Fatalf("successful response should be replayed, calls=%d", calls)
+	}
+}
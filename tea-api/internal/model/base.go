@@ -65,6 +65,8 @@ type User struct {
 	InterestRate            decimal.Decimal `gorm:"type:decimal(8,6);default:0" json:"interest_rate"` // 用户定制日利率，>0 时覆盖默认
 	Points                  int             `gorm:"default:0" json:"points"`
 	Role                    string          `gorm:"type:varchar(30);default:'user';index" json:"role"` // 简化角色标识，详尽权限通过关联表
+	RealName                string          `gorm:"type:varchar(64)" json:"real_name"`
+	RealNameVerifiedAt      *time.Time      `json:"real_name_verified_at"` // 实名核验通过时间，为空表示未实名
 }
 
 // BeforeSave normalizes JSON columns to ensure they persist valid values.
@@ -11,10 +11,10 @@ type Commission struct {
 	BaseModel
 	UserID           uint            `gorm:"index;not null" json:"user_id"`
 	OrderID          *uint           `gorm:"index" json:"order_id"`
-	OrderItemID      *uint           `gorm:"index" json:"order_item_id"`
+	OrderItemID      *uint           `gorm:"uniqueIndex:idx_commissions_item_type" json:"order_item_id"` // 同一订单明细每种佣金只计提一次
 	PackageID        *uint           `gorm:"index" json:"package_id"`
 	LevelID          *uint           `gorm:"index" json:"level_id"`
-	CommissionType   string          `gorm:"type:varchar(32);index;default:'direct'" json:"commission_type"` // direct|indirect|upgrade
+	CommissionType   string          `gorm:"type:varchar(32);index;uniqueIndex:idx_commissions_item_type;default:'direct'" json:"commission_type"` // direct|indirect|upgrade
 	SourceUserID     *uint           `gorm:"index" json:"source_user_id"`
 	Rate             decimal.Decimal `gorm:"type:decimal(8,6);default:0" json:"rate"`
 	CalculationBasis decimal.Decimal `gorm:"type:decimal(12,2);default:0" json:"calculation_basis"`
@@ -78,4 +78,17 @@ type ReferralClosure struct {
 	AncestorUserID   uint `gorm:"primaryKey;autoIncrement:false" json:"ancestor_user_id"`
 	DescendantUserID uint `gorm:"primaryKey;autoIncrement:false" json:"descendant_user_id"`
 	Depth            int  `json:"depth"`
+
+	BoundAt *time.Time `json:"bound_at"` // 建立该祖先关系的时间（绑定或迁移），历史数据为空时以用户注册时间计
+}
+
+// ReferralMoveLog 推荐关系迁移审计：管理员将用户（连同其下级）挂到新的推荐人下
+type ReferralMoveLog struct {
+	BaseModel
+	UserID      uint   `gorm:"index;not null" json:"user_id"` // 被迁移子树的根用户
+	OldParentID uint   `gorm:"index" json:"old_parent_id"`    // 原推荐人，0 表示无
+	NewParentID uint   `gorm:"index" json:"new_parent_id"`    // 新推荐人，0 表示解除推荐关系
+	SubtreeSize int    `json:"subtree_size"`                  // 随迁用户数（含根用户）
+	OperatorID  uint   `gorm:"index" json:"operator_id"`
+	Reason      string `gorm:"type:varchar(255)" json:"reason"`
 }
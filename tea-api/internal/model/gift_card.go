@@ -0,0 +1,75 @@
+package model
+
+import "time"
+
+// 礼品卡批次状态
+const (
+	GiftCardBatchActive = 1 // 正常
+	GiftCardBatchFrozen = 2 // 已冻结（未兑换的卡暂停兑换）
+	GiftCardBatchVoided = 3 // 已作废（未兑换的卡永久失效）
+)
+
+// 礼品卡状态
+const (
+	GiftCardStatusActive   = 1 // 待兑换
+	GiftCardStatusRedeemed = 2 // 已兑换
+	GiftCardStatusFrozen   = 3 // 已冻结
+	GiftCardStatusVoided   = 4 // 已作废
+)
+
+// 礼品卡流水类型
+const (
+	GiftCardTxIssue    = "issue"    // 制卡
+	GiftCardTxRedeem   = "redeem"   // 兑换入钱包
+	GiftCardTxFreeze   = "freeze"   // 随批次冻结
+	GiftCardTxUnfreeze = "unfreeze" // 随批次解冻
+	GiftCardTxVoid     = "void"     // 随批次作废
+)
+
+// GiftCardBatch 实体储值卡批次（金额单位：分）
+// StoreID 非 0 表示由该门店售卡，兑换时冲减门店待结算款；为 0 表示平台通用卡
+type GiftCardBatch struct {
+	BaseModel
+	BatchNo        string     `gorm:"type:varchar(32);uniqueIndex;not null" json:"batch_no"`
+	Name           string     `gorm:"type:varchar(100);not null" json:"name"`
+	FaceValueCents int64      `gorm:"not null" json:"face_value_cents"`
+	Quantity       int        `gorm:"not null" json:"quantity"`
+	StoreID        uint       `gorm:"index;default:0" json:"store_id"`
+	ValidFrom      *time.Time `json:"valid_from"`
+	ValidUntil     *time.Time `json:"valid_until"`
+	Status         int        `gorm:"type:tinyint;default:1;index" json:"status"`
+	ExportCount    int        `gorm:"default:0" json:"export_count"` // 导出卡密次数（每次导出均记操作日志）
+	ExportedAt     *time.Time `json:"exported_at"`
+	Remark         string     `gorm:"type:varchar(255)" json:"remark"`
+}
+
+// GiftCard 礼品卡：卡密仅保存 HMAC 摘要用于校验，另以 AES-GCM 密文保存供制卡导出
+type GiftCard struct {
+	BaseModel
+	BatchID        uint       `gorm:"index;not null" json:"batch_id"`
+	CardNo         string     `gorm:"type:varchar(32);uniqueIndex;not null" json:"card_no"`
+	PinHash        string     `gorm:"type:varchar(64);not null" json:"-"`
+	PinCipher      string     `gorm:"type:varchar(128);not null" json:"-"`
+	FaceValueCents int64      `gorm:"not null" json:"face_value_cents"`
+	BalanceCents   int64      `gorm:"not null" json:"balance_cents"` // 兑换后为 0
+	StoreID        uint       `gorm:"index;default:0" json:"store_id"`
+	Status         int        `gorm:"type:tinyint;default:1;index" json:"status"`
+	PinFailures    int        `gorm:"default:0" json:"pin_failures"` // 连续卡密错误次数，达到上限后锁定
+	RedeemedBy     *uint      `gorm:"index" json:"redeemed_by"`
+	RedeemedAt     *time.Time `json:"redeemed_at"`
+}
+
+// GiftCardTransaction 礼品卡流水
+type GiftCardTransaction struct {
+	ID           uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CardID       uint      `gorm:"index;not null" json:"card_id"`
+	BatchID      uint      `gorm:"index;not null" json:"batch_id"`
+	UserID       *uint     `gorm:"index" json:"user_id"`
+	Type         string    `gorm:"type:varchar(20);not null" json:"type"`
+	AmountCents  int64     `gorm:"not null;default:0" json:"amount_cents"` // 卡余额变动
+	BalanceAfter int64     `gorm:"not null;default:0" json:"balance_after"`
+	WalletTxID   *uint     `json:"wallet_tx_id"`
+	OperatorID   *uint     `json:"operator_id"`
+	Remark       string    `gorm:"type:varchar(255)" json:"remark"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
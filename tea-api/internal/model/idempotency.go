@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// IdempotencyRecord 幂等请求记录（Redis 不可用时的落库兜底）
+// ScopeKey 为 用户 + 方法 + 路径 + Idempotency-Key 的哈希，保证同一用户同一接口内唯一。
+type IdempotencyRecord struct {
+	ID             uint      `gorm:"primarykey" json:"id"`
+	ScopeKey       string    `gorm:"type:varchar(64);uniqueIndex;not null" json:"scope_key"`
+	UserID         uint      `gorm:"index" json:"user_id"`
+	Route          string    `gorm:"type:varchar(255)" json:"route"`
+	RequestHash    string    `gorm:"type:varchar(64);not null" json:"request_hash"`
+	State          string    `gorm:"type:varchar(16);not null" json:"state"` // processing / done
+	ResponseStatus int       `json:"response_status"`
+	ContentType    string    `gorm:"type:varchar(128)" json:"content_type"`
+	ResponseBody   string    `gorm:"type:mediumtext" json:"response_body"`
+	ExpiresAt      time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// InvoiceTitle 用户发票抬头
+type InvoiceTitle struct {
+	BaseModel
+	UserID      uint   `gorm:"index;not null" json:"user_id"`
+	TitleType   int    `gorm:"type:tinyint;not null" json:"title_type"` // 1:个人 2:企业
+	Name        string `gorm:"type:varchar(128);not null" json:"name"`
+	TaxNo       string `gorm:"type:varchar(32)" json:"tax_no"` // 纳税人识别号（企业必填）
+	Address     string `gorm:"type:varchar(255)" json:"address"`
+	Phone       string `gorm:"type:varchar(32)" json:"phone"`
+	BankName    string `gorm:"type:varchar(128)" json:"bank_name"`
+	BankAccount string `gorm:"type:varchar(64)" json:"bank_account"`
+	Email       string `gorm:"type:varchar(128)" json:"email"` // 电子发票接收邮箱
+	IsDefault   bool   `gorm:"default:false" json:"is_default"`
+}
+
+// Invoice 发票申请/开票记录
+// 一张发票可合并多笔订单（批量开票），也可对应一笔提现申请（SourceType=withdrawal）。
+type Invoice struct {
+	BaseModel
+	RequestNo    string          `gorm:"type:varchar(32);uniqueIndex;not null" json:"request_no"`
+	UserID       uint            `gorm:"index;not null" json:"user_id"`
+	SourceType   string          `gorm:"type:varchar(20);index;not null" json:"source_type"` // order / withdrawal
+	TitleID      uint            `gorm:"index" json:"title_id"`
+	TitleType    int             `gorm:"type:tinyint" json:"title_type"` // 申请时抬头快照
+	TitleName    string          `gorm:"type:varchar(128)" json:"title_name"`
+	TaxNo        string          `gorm:"type:varchar(32)" json:"tax_no"`
+	Email        string          `gorm:"type:varchar(128)" json:"email"`
+	Amount       decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"`
+	Status       int             `gorm:"type:tinyint;default:1;index" json:"status"` // 1:待审核 2:已开具 3:已驳回 4:开具失败 5:开具中
+	Provider     string          `gorm:"type:varchar(32)" json:"provider"`
+	InvoiceNo    string          `gorm:"type:varchar(64);index" json:"invoice_no"`
+	InvoiceCode  string          `gorm:"type:varchar(64)" json:"invoice_code"`
+	FileURL      string          `gorm:"type:varchar(500)" json:"file_url"` // PDF 版式文件地址
+	XMLURL       string          `gorm:"type:varchar(500)" json:"xml_url"`  // XML 数据文件地址
+	Remark       string          `gorm:"type:varchar(255)" json:"remark"`
+	RejectReason string          `gorm:"type:varchar(255)" json:"reject_reason"`
+	FailReason   string          `gorm:"type:varchar(255)" json:"fail_reason"`
+	ReviewedBy   uint            `json:"reviewed_by"`
+	ReviewedAt   *time.Time      `json:"reviewed_at"`
+	IssuedAt     *time.Time      `json:"issued_at"`
+
+	Items []InvoiceItem `gorm:"foreignKey:InvoiceID" json:"items,omitempty"`
+}
+
+// InvoiceItem 发票关联的业务单据
+type InvoiceItem struct {
+	BaseModel
+	InvoiceID  uint            `gorm:"index;not null" json:"invoice_id"`
+	SourceType string          `gorm:"type:varchar(20);index:idx_invoice_source,priority:1;not null" json:"source_type"`
+	SourceID   uint            `gorm:"index:idx_invoice_source,priority:2;not null" json:"source_id"`
+	SourceNo   string          `gorm:"type:varchar(64)" json:"source_no"`
+	Amount     decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"`
+}
+
+// 发票状态
+const (
+	InvoiceStatusPending  = 1
+	InvoiceStatusIssued   = 2
+	InvoiceStatusRejected = 3
+	InvoiceStatusFailed   = 4
+	InvoiceStatusIssuing  = 5 // 已占用、正在调用开票通道
+)
+
+// 发票业务来源
+const (
+	InvoiceSourceOrder      = "order"
+	InvoiceSourceWithdrawal = "withdrawal"
+)
@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+// LedgerAccount 复式记账科目（单位：分）
+// Code 形如 user_wallet:12、store:3、provider_clearing:wechat、platform_revenue；
+// Balance 按科目正常方向记余额（负债/收入类贷方为正，资产/费用类借方为正），随过账同步更新
+type LedgerAccount struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	Code      string    `gorm:"type:varchar(64);uniqueIndex;not null" json:"code"`
+	Type      string    `gorm:"type:varchar(32);index;not null" json:"type"`
+	OwnerID   uint      `gorm:"index;default:0" json:"owner_id"` // 用户/门店ID，平台科目为 0
+	Balance   int64     `gorm:"not null;default:0" json:"balance"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// LedgerEntry 记账凭证：一次资金事件对应一张凭证，凭证下分录借贷合计为 0
+type LedgerEntry struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	EventType string    `gorm:"type:varchar(64);index;not null" json:"event_type"` // payment / refund / order_pay / withdraw_paid ...
+	BizKey    *string   `gorm:"type:varchar(128);uniqueIndex" json:"biz_key"`      // 幂等键，同一业务事件只过账一次
+	RefType   string    `gorm:"type:varchar(32);index:idx_ledger_entry_ref" json:"ref_type"`
+	RefID     string    `gorm:"type:varchar(64);index:idx_ledger_entry_ref" json:"ref_id"`
+	Memo      string    `gorm:"type:varchar(255)" json:"memo"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+
+	Postings []LedgerPosting `gorm:"foreignKey:EntryID" json:"postings,omitempty"`
+}
+
+// LedgerPosting 凭证分录：Amount 借方为正、贷方为负
+type LedgerPosting struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	EntryID     uint      `gorm:"index;not null" json:"entry_id"`
+	AccountID   uint      `gorm:"index;not null" json:"account_id"`
+	AccountCode string    `gorm:"type:varchar(64);index;not null" json:"account_code"`
+	Amount      int64     `gorm:"not null" json:"amount"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -21,7 +21,7 @@ type Order struct {
 	DeliveryFee         decimal.Decimal `gorm:"type:decimal(10,2);default:0" json:"delivery_fee"`
 	Status              int             `gorm:"type:tinyint;default:1" json:"status"`        // 1:待付款 2:已付款 3:配送中 4:已完成 5:已取消
 	PayStatus           int             `gorm:"type:tinyint;default:1" json:"pay_status"`    // 1:未付款 2:已付款 3:退款中 4:已退款
-	OrderType           int             `gorm:"type:tinyint;default:1" json:"order_type"`    // 1:商城 2:堂食 3:外卖 4:会员订单
+	OrderType           int             `gorm:"type:tinyint;default:1" json:"order_type"`    // 1:商城 2:堂食 3:外卖 4:会员订单 5:充值订单 6:积分兑换
 	DeliveryType        int             `gorm:"type:tinyint;default:1" json:"delivery_type"` // 1:自取 2:配送
 	DeliveryTime        *time.Time      `json:"delivery_time"`
 	AddressInfo         string          `gorm:"type:json" json:"address_info"`
@@ -32,6 +32,10 @@ type Order struct {
 	CancelledAt         *time.Time      `json:"cancelled_at"`
 	CancelReason        string          `gorm:"type:varchar(200)" json:"cancel_reason"`
 
+	// PointsUsed 下单抵扣或积分兑换使用的积分，PointsDeduction 为积分抵扣的金额
+	PointsUsed      int64           `gorm:"default:0" json:"points_used"`
+	PointsDeduction decimal.Decimal `gorm:"type:decimal(10,2);default:0" json:"points_deduction"`
+
 	User User `gorm:"foreignKey:UserID"`
 }
 
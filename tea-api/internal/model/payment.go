@@ -11,9 +11,9 @@ type Payment struct {
 	BaseModel
 	OrderID       uint            `gorm:"index;not null" json:"order_id"`
 	PaymentNo     string          `gorm:"type:varchar(64);uniqueIndex;not null" json:"payment_no"`
-	PaymentMethod int             `gorm:"type:tinyint;not null" json:"payment_method"` // 1:微信 2:支付宝
+	PaymentMethod int             `gorm:"type:tinyint;not null" json:"payment_method"` // 1:微信 2:支付宝 3:余额
 	Amount        decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"`
-	Status        int             `gorm:"type:tinyint;default:1" json:"status"` // 1:待支付 2:支付成功 3:支付失败
+	Status        int             `gorm:"type:tinyint;default:1" json:"status"` // 1:待支付 2:支付成功 3:支付失败 4:关单后支付（自动退款）
 	ThirdPayNo    string          `gorm:"type:varchar(64)" json:"third_pay_no"`
 	ThirdResponse string          `gorm:"type:text" json:"third_response"`
 	PaidAt        *time.Time      `json:"paid_at"`
@@ -22,6 +22,16 @@ type Payment struct {
 	Order Order `gorm:"foreignKey:OrderID"`
 }
 
+// PaymentStatusPaidAfterClose 订单已关闭（超时/取消）后渠道才通知支付成功：不恢复订单，按原路自动退款
+const PaymentStatusPaidAfterClose = 4
+
+// 退款状态常量
+const (
+	RefundStatusProcessing = 1 // 申请中（已提交渠道，等待结果）
+	RefundStatusSuccess    = 2 // 退款成功
+	RefundStatusFailed     = 3 // 退款失败（可重试）
+)
+
 // Refund 退款记录模型
 type Refund struct {
 	BaseModel
@@ -34,11 +44,48 @@ type Refund struct {
 	ThirdRefundNo string          `gorm:"type:varchar(64)" json:"third_refund_no"`
 	ThirdResponse string          `gorm:"type:text" json:"third_response"`
 	RefundedAt    *time.Time      `json:"refunded_at"`
+	RetryCount    int             `gorm:"default:0" json:"retry_count"`
+	NextRetryAt   *time.Time      `gorm:"index" json:"next_retry_at"`
+	LastError     string          `gorm:"type:varchar(255)" json:"last_error"`
 
 	Order   Order   `gorm:"foreignKey:OrderID"`
 	Payment Payment `gorm:"foreignKey:PaymentID"`
 }
 
+// 支付回调通知处理状态常量
+const (
+	PaymentNotifyReceived  = 1 // 已接收（处理中）
+	PaymentNotifyProcessed = 2 // 处理成功
+	PaymentNotifyFailed    = 3 // 验签通过但业务处理失败
+	PaymentNotifyRejected  = 4 // 验签失败或时间戳超出窗口
+	PaymentNotifyReplay    = 5 // nonce 重复，按重放拒绝
+	PaymentNotifyDuplicate = 6 // 已处理通知的原样重发（应答丢失后渠道重试），仅留痕并应答成功
+)
+
+// PaymentNotification 支付/退款回调通知留痕：每次入站通知的原始报文、验签与处理结果
+type PaymentNotification struct {
+	BaseModel
+	Provider       string     `gorm:"type:varchar(16);index;not null" json:"provider"` // wechat / alipay / legacy（JSON 签名回调）
+	Method         int        `gorm:"type:tinyint;default:0" json:"method"`
+	Kind           string     `gorm:"type:varchar(16)" json:"kind"` // payment / refund
+	PaymentNo      string     `gorm:"type:varchar(64);index" json:"payment_no"`
+	RefundNo       string     `gorm:"type:varchar(64)" json:"refund_no"`
+	TradeState     string     `gorm:"type:varchar(32)" json:"trade_state"`
+	Nonce          string     `gorm:"type:varchar(64)" json:"nonce"`
+	NotifyTime     *time.Time `json:"notify_time"`                            // 通知携带的时间戳
+	ReplayKey      *string    `gorm:"type:varchar(128);uniqueIndex" json:"-"` // provider:nonce，仅首个有效通知占用
+	RemoteIP       string     `gorm:"type:varchar(64)" json:"remote_ip"`
+	Headers        string     `gorm:"type:text" json:"headers"`
+	Body           string     `gorm:"type:mediumtext" json:"body"`
+	Parsed         string     `gorm:"type:text" json:"parsed"` // 验签后的业务数据，重新处理时使用
+	Verified       bool       `gorm:"default:false" json:"verified"`
+	VerifyError    string     `gorm:"type:varchar(255)" json:"verify_error"`
+	Status         int        `gorm:"type:tinyint;default:1;index" json:"status"`
+	ProcessError   string     `gorm:"type:varchar(255)" json:"process_error"`
+	ProcessedAt    *time.Time `json:"processed_at"`
+	ReprocessCount int        `gorm:"default:0" json:"reprocess_count"`
+}
+
 // 提现状态常量
 const (
 	WithdrawStatusPending    = 1 // 申请中
@@ -47,6 +94,12 @@ const (
 	WithdrawStatusRejected   = 4 // 已拒绝
 )
 
+// 提现方式常量
+const (
+	WithdrawTypeWechat = 1 // 微信转账
+	WithdrawTypeBank   = 2 // 银行卡（批量代付文件）
+)
+
 // WithdrawRecord 提现记录模型
 type WithdrawRecord struct {
 	BaseModel
@@ -56,15 +109,25 @@ type WithdrawRecord struct {
 	Amount       decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"`
 	Fee          decimal.Decimal `gorm:"type:decimal(10,2);default:0" json:"fee"`
 	ActualAmount decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"actual_amount"`
-	WithdrawType int             `gorm:"type:tinyint;not null" json:"withdraw_type"` // 1:微信转账
+	WithdrawType int             `gorm:"type:tinyint;not null" json:"withdraw_type"` // 参见 WithdrawType* 常量
 	Status       int             `gorm:"type:tinyint;default:1" json:"status"`       // 参见 WithdrawStatus* 常量
 	Remark       string          `gorm:"type:varchar(200)" json:"remark"`
 	ProcessedAt  *time.Time      `json:"processed_at"`
 	ProcessedBy  uint            `json:"processed_by"`
+	// 风控：申请时命中的需人工复核原因（JSON 数组），NeedReview 为真时需人工审核后再处理
+	RiskFlags  string `gorm:"column:risk_flags;type:varchar(512)" json:"risk_flags,omitempty"`
+	NeedReview bool   `gorm:"column:need_review;default:0;index" json:"need_review"`
 
 	User User `gorm:"foreignKey:UserID"`
 }
 
+// 微信转账状态常量
+const (
+	WechatTransferStatusProcessing = 1 // 处理中
+	WechatTransferStatusSuccess    = 2 // 转账成功
+	WechatTransferStatusFailed     = 3 // 转账失败
+)
+
 // WechatTransferRecord 微信转账记录模型
 type WechatTransferRecord struct {
 	BaseModel
@@ -73,7 +136,7 @@ type WechatTransferRecord struct {
 	OpenID         string          `gorm:"type:varchar(50);not null" json:"open_id"`
 	Amount         decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"`
 	Description    string          `gorm:"type:varchar(100)" json:"description"`
-	Status         int             `gorm:"type:tinyint;default:1" json:"status"` // 1:处理中 2:转账成功 3:转账失败
+	Status         int             `gorm:"type:tinyint;default:1" json:"status"` // 参见 WechatTransferStatus* 常量
 	PaymentNo      string          `gorm:"type:varchar(64)" json:"payment_no"`
 	PaymentTime    *time.Time      `json:"payment_time"`
 	ErrorCode      string          `gorm:"type:varchar(20)" json:"error_code"`
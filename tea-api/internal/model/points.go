@@ -0,0 +1,97 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 积分流水类型
+const (
+	PointsTxOrderEarn       = "order_earn"        // 订单完成发放
+	PointsTxOrderReverse    = "order_reverse"     // 订单退款按比例扣回
+	PointsTxSignupBonus     = "signup_bonus"      // 注册奖励
+	PointsTxFirstOrderBonus = "first_order_bonus" // 首单奖励
+	PointsTxOpeningBalance  = "opening_balance"   // 启用积分流水前 users.points 的期初差额
+	PointsTxOrderDeduct     = "order_deduct"      // 下单抵扣/积分商城兑换
+	PointsTxDeductReturn    = "deduct_return"     // 订单取消或退款退还抵扣积分
+	PointsTxExpire          = "expire"            // 积分批次到期作废
+)
+
+// PointsTransaction 积分流水：users.points 为流水合计的投影，所有变动经积分服务写入
+type PointsTransaction struct {
+	ID           uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID       uint      `gorm:"index;not null" json:"user_id"`
+	Change       int64     `gorm:"column:change;not null" json:"change"`
+	Type         string    `gorm:"type:varchar(32);index;not null" json:"type"`
+	Reason       string    `gorm:"type:varchar(255)" json:"reason"`
+	OrderID      *uint     `gorm:"index" json:"order_id"`
+	BizKey       *string   `gorm:"type:varchar(128);uniqueIndex" json:"-"` // 幂等键，同一业务事件只记一次
+	BalanceAfter int64     `gorm:"not null;default:0" json:"balance_after"`
+	CreatedAt    time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+// PointsLot 积分获得批次：每笔入账积分一个批次，扣减按到期时间先到先扣（FIFO），到期后剩余部分作废
+type PointsLot struct {
+	ID           uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID       uint       `gorm:"index:idx_points_lot_user;not null" json:"user_id"`
+	TxID         *uint      `gorm:"index" json:"tx_id"` // 入账流水，期初对齐生成的批次为空
+	Points       int64      `gorm:"not null" json:"points"`
+	Remaining    int64      `gorm:"index:idx_points_lot_user;not null" json:"remaining"`
+	EarnedAt     time.Time  `gorm:"not null" json:"earned_at"`
+	ExpiresAt    *time.Time `gorm:"index" json:"expires_at"` // 为空表示不过期
+	NoticeSentAt *time.Time `json:"notice_sent_at"`          // 到期提醒发送时间
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// 积分商城商品类型
+const (
+	PointsMallItemProduct = 1 // 实物商品，兑换后按普通订单履约
+	PointsMallItemCoupon  = 2 // 优惠券，兑换成功即发放
+)
+
+// 积分兑换状态
+const (
+	PointsExchangePending   = 1 // 待支付现金部分
+	PointsExchangeCompleted = 2 // 兑换成功
+	PointsExchangeCancelled = 3 // 已取消（积分与库存已退回）
+)
+
+// PointsMallItem 积分商城商品：积分 + 可选现金兑换商品或优惠券
+type PointsMallItem struct {
+	BaseModel
+	Name           string          `gorm:"type:varchar(100);not null" json:"name"`
+	ItemType       int             `gorm:"type:tinyint;not null" json:"item_type"` // 1:商品 2:优惠券
+	ProductID      *uint           `gorm:"index" json:"product_id"`
+	SkuID          *uint           `json:"sku_id"`
+	CouponID       *uint           `gorm:"index" json:"coupon_id"`
+	Image          string          `gorm:"type:varchar(500)" json:"image"`
+	Description    string          `gorm:"type:text" json:"description"`
+	PointsPrice    int64           `gorm:"not null" json:"points_price"`
+	CashPrice      decimal.Decimal `gorm:"type:decimal(10,2);default:0" json:"cash_price"`
+	Stock          int             `gorm:"not null;default:-1" json:"stock"`         // 剩余可兑换数量，-1 表示不限
+	PerUserLimit   int             `gorm:"not null;default:0" json:"per_user_limit"` // 每人累计可兑换数量，0 表示不限
+	ExchangedCount int             `gorm:"not null;default:0" json:"exchanged_count"`
+	Sort           int             `gorm:"default:0" json:"sort"`
+	Status         int             `gorm:"type:tinyint;default:1" json:"status"` // 1:上架 2:下架
+	StartAt        *time.Time      `json:"start_at"`
+	EndAt          *time.Time      `json:"end_at"`
+}
+
+// PointsExchange 积分兑换记录，每次兑换对应一笔积分兑换订单（order_type=6）
+type PointsExchange struct {
+	BaseModel
+	UserID       uint            `gorm:"index;not null" json:"user_id"`
+	ItemID       uint            `gorm:"index;not null" json:"item_id"`
+	ItemName     string          `gorm:"type:varchar(100)" json:"item_name"`
+	ItemType     int             `gorm:"type:tinyint;not null" json:"item_type"`
+	Quantity     int             `gorm:"not null" json:"quantity"`
+	Points       int64           `gorm:"not null" json:"points"`
+	CashAmount   decimal.Decimal `gorm:"type:decimal(10,2);default:0" json:"cash_amount"`
+	OrderID      uint            `gorm:"uniqueIndex;not null" json:"order_id"`
+	Status       int             `gorm:"type:tinyint;default:1;index" json:"status"` // 1:待支付 2:已完成 3:已取消
+	UserCouponID *uint           `json:"user_coupon_id"`                             // 优惠券兑换发放的首张用户券
+	CompletedAt  *time.Time      `json:"completed_at"`
+	CancelledAt  *time.Time      `json:"cancelled_at"`
+}
@@ -0,0 +1,56 @@
+package model
+
+import "time"
+
+// 打印模板类型
+const (
+	PrintTemplateKitchen = "kitchen" // 后厨/制作小票
+	PrintTemplateReceipt = "receipt" // 顾客小票
+	PrintTemplateLabel   = "label"   // 配送标签
+)
+
+// 打印任务状态
+const (
+	PrintJobPending = 1 // 待发送
+	PrintJobSuccess = 2 // 已发送
+	PrintJobFailed  = 3 // 发送失败
+)
+
+// StorePrinter 门店打印机登记
+type StorePrinter struct {
+	BaseModel
+	StoreID    uint   `gorm:"index;not null" json:"store_id"`
+	Name       string `gorm:"type:varchar(64);not null" json:"name"`
+	Channel    string `gorm:"type:varchar(16);default:'cloud'" json:"channel"` // 下发通道：cloud 云打印 / file 文件落地（联调）
+	DeviceSN   string `gorm:"type:varchar(64);not null" json:"device_sn"`
+	DeviceKey  string `gorm:"type:varchar(128)" json:"-"`           // 云打印机密钥，不对外返回
+	PaperWidth int    `gorm:"default:58" json:"paper_width"`        // 纸宽(mm)：58 / 80
+	Templates  string `gorm:"type:varchar(64)" json:"templates"`    // 负责打印的模板，逗号分隔：kitchen,receipt,label
+	Copies     int    `gorm:"default:1" json:"copies"`              // 每张小票打印份数
+	AutoPrint  bool   `json:"auto_print"`                           // 订单支付后自动打印
+	Status     int    `gorm:"type:tinyint;default:1" json:"status"` // 1:启用 2:停用
+}
+
+// PrintTemplate 打印模板（StoreID=0 为全局模板，门店模板优先）
+// Content 为 text/template 文本，每行可用 [C] 居中、[R] 右对齐、[B] 加粗倍高、[HR] 分隔线、[CUT] 切纸 等行首指令。
+type PrintTemplate struct {
+	BaseModel
+	StoreID      uint   `gorm:"not null;default:0;uniqueIndex:uk_store_print_tpl" json:"store_id"`
+	TemplateType string `gorm:"type:varchar(16);not null;uniqueIndex:uk_store_print_tpl" json:"template_type"`
+	Content      string `gorm:"type:text;not null" json:"content"`
+}
+
+// PrintJob 打印任务记录（一台打印机的一张小票对应一条）
+type PrintJob struct {
+	BaseModel
+	StoreID      uint       `gorm:"index;not null" json:"store_id"`
+	PrinterID    uint       `gorm:"index;not null" json:"printer_id"`
+	OrderID      uint       `gorm:"index;not null" json:"order_id"`
+	TemplateType string     `gorm:"type:varchar(16);not null" json:"template_type"`
+	TriggerType  string     `gorm:"type:varchar(16);not null" json:"trigger_type"` // paid:支付自动打印 reprint:后台补打
+	Status       int        `gorm:"type:tinyint;default:1" json:"status"`          // 1:待发送 2:已发送 3:发送失败
+	ExternalID   string     `gorm:"type:varchar(64)" json:"external_id"`           // 云打印平台返回的任务号
+	Error        string     `gorm:"type:varchar(500)" json:"error"`
+	OperatorID   uint       `gorm:"default:0" json:"operator_id"`
+	PrintedAt    *time.Time `json:"printed_at"`
+}
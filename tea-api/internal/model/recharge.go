@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// 充值记录状态常量
+const (
+	RechargeStatusPending  = 1 // 待支付
+	RechargeStatusCredited = 2 // 已到账
+	RechargeStatusRefunded = 3 // 已退款（本金与赠送已扣回）
+)
+
+// RechargeRecord 用户充值记录：对应一笔 OrderType=5 的充值订单，下单时按 recharge.packages 档位快照赠送内容
+type RechargeRecord struct {
+	BaseModel
+	OrderID          uint       `gorm:"uniqueIndex;not null" json:"order_id"`
+	UserID           uint       `gorm:"index;not null" json:"user_id"`
+	AmountCents      int64      `gorm:"not null" json:"amount_cents"`                // 充值本金（分）
+	BonusCents       int64      `gorm:"default:0" json:"bonus_cents"`                // 赠送金额（分）
+	BonusCouponIDs   string     `gorm:"type:varchar(255)" json:"bonus_coupon_ids"`   // 赠送优惠券模板ID（JSON 数组）
+	GrantedCouponIDs string     `gorm:"type:varchar(255)" json:"granted_coupon_ids"` // 实际发放的用户券ID（JSON 数组），退款时作废
+	Status           int        `gorm:"type:tinyint;default:1;index" json:"status"`
+	CreditedAt       *time.Time `json:"credited_at"`
+	RefundedAt       *time.Time `json:"refunded_at"`
+
+	Order Order `gorm:"foreignKey:OrderID" json:"-"`
+}
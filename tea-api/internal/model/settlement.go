@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 渠道对账单明细类型
+const (
+	SettlementLinePayment = "payment" // 交易
+	SettlementLineRefund  = "refund"  // 退款
+)
+
+// SettlementStatementLine 支付渠道对账单明细（微信/支付宝日账单导入）
+// 同一渠道按 LineKey（渠道交易号+退款单号）去重，重复导入覆盖原记录。
+type SettlementStatementLine struct {
+	BaseModel
+	Provider      string          `gorm:"type:varchar(16);not null;uniqueIndex:uk_settlement_line,priority:1" json:"provider"` // wechat / alipay
+	LineKey       string          `gorm:"type:varchar(160);not null;uniqueIndex:uk_settlement_line,priority:2" json:"line_key"`
+	BatchNo       string          `gorm:"type:varchar(64);index" json:"batch_no"`     // 导入批次
+	LineType      string          `gorm:"type:varchar(16);not null" json:"line_type"` // payment / refund
+	TradeTime     time.Time       `gorm:"index" json:"trade_time"`
+	TransactionID string          `gorm:"type:varchar(64)" json:"transaction_id"`   // 渠道交易号
+	PaymentNo     string          `gorm:"type:varchar(64);index" json:"payment_no"` // 商户订单号（payments.payment_no）
+	RefundNo      string          `gorm:"type:varchar(64);index" json:"refund_no"`  // 商户退款单号（refunds.refund_no）
+	TradeState    string          `gorm:"type:varchar(32)" json:"trade_state"`
+	Amount        decimal.Decimal `gorm:"type:decimal(12,2);not null" json:"amount"` // 交易金额或退款金额（正数）
+	Fee           decimal.Decimal `gorm:"type:decimal(12,2);default:0" json:"fee"`   // 渠道手续费，退款退回手续费为负数
+	RawLine       string          `gorm:"type:text" json:"raw_line"`
+	ImportedBy    uint            `json:"imported_by"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// 库存预占状态
+const (
+	StockReservationActive    = 1 // 预占中（占用可售库存，未扣减实物库存）
+	StockReservationCommitted = 2 // 已提交（支付成功，已扣减实物库存）
+	StockReservationReleased  = 3 // 已释放（取消/超时/退款）
+)
+
+// StockReservation 订单库存预占（每个订单明细一条）
+// 可售库存 = 实物库存(stock) - 预占中数量；下单时预占，支付时提交扣减，取消或超时释放。
+type StockReservation struct {
+	BaseModel
+	OrderID       uint       `gorm:"index;not null" json:"order_id"`
+	ProductID     uint       `gorm:"index:idx_stock_resv_product;not null" json:"product_id"`
+	SkuID         *uint      `gorm:"index" json:"sku_id"`
+	StoreID       uint       `gorm:"index:idx_stock_resv_product;default:0" json:"store_id"` // 0 表示未绑定门店
+	Quantity      int        `gorm:"not null" json:"quantity"`
+	Status        int        `gorm:"type:tinyint;default:1;index" json:"status"` // 1:预占中 2:已提交 3:已释放
+	ExpiresAt     time.Time  `gorm:"index" json:"expires_at"`
+	CommittedAt   *time.Time `json:"committed_at"`
+	ReleasedAt    *time.Time `json:"released_at"`
+	ReleaseReason string     `gorm:"type:varchar(32)" json:"release_reason"` // cancel / expire / refund
+}
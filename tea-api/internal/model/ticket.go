@@ -7,7 +7,7 @@ import "time"
 // 状态枚举：new, pending, waiting_customer, resolved, rejected, closed
 // 优先级：low, normal, high
 // 类型：consult, order, refund, recharge, complaint, other
-// 来源：miniapp_feedback, miniapp_order, store_staff, phone, manual
+// 来源：miniapp_feedback, miniapp_order, store_staff, phone, manual, system（系统巡检自动创建）
 
 type Ticket struct {
 	BaseModel
@@ -0,0 +1,50 @@
+package model
+
+import "time"
+
+// StoreSlotTemplate 门店预约时段模板
+// 一条模板描述某门店在指定星期内的营业窗口，窗口按 SlotMinutes 切分为若干时段，每个时段最多接待 Capacity 单。
+type StoreSlotTemplate struct {
+	BaseModel
+	StoreID       uint   `gorm:"index;not null" json:"store_id"`
+	DeliveryType  int    `gorm:"type:tinyint;default:0" json:"delivery_type"` // 0:不限 1:自取 2:配送
+	Weekdays      string `gorm:"type:varchar(20)" json:"weekdays"`            // 逗号分隔的星期(0=周日..6=周六)，为空表示每天
+	StartTime     string `gorm:"type:varchar(5);not null" json:"start_time"`  // 窗口开始 HH:MM
+	EndTime       string `gorm:"type:varchar(5);not null" json:"end_time"`    // 窗口结束 HH:MM
+	SlotMinutes   int    `gorm:"default:30" json:"slot_minutes"`              // 单个时段长度（分钟）
+	Capacity      int    `gorm:"default:10" json:"capacity"`                  // 单个时段可接单量
+	CutoffMinutes int    `gorm:"default:0" json:"cutoff_minutes"`             // 时段开始前多少分钟停止预约
+	LeadMinutes   int    `gorm:"default:0" json:"lead_minutes"`               // 下单时间至时段开始的最短备货时间（分钟）
+	Status        int    `gorm:"type:tinyint;default:1" json:"status"`        // 1:启用 2:停用
+}
+
+// StoreSlotUsage 门店时段占用计数（按门店+模板+时段开始时间唯一）
+// 预约时通过 reserved < capacity 的条件更新实现原子占位，避免超卖。
+type StoreSlotUsage struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	StoreID    uint      `gorm:"not null;uniqueIndex:uk_store_slot" json:"store_id"`
+	SlotStart  time.Time `gorm:"not null;uniqueIndex:uk_store_slot" json:"slot_start"`
+	TemplateID uint      `gorm:"not null;uniqueIndex:uk_store_slot" json:"template_id"`
+	Capacity   int       `gorm:"not null" json:"capacity"` // 占位时的容量快照
+	Reserved   int       `gorm:"not null;default:0" json:"reserved"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// StoreSlotReservation 订单时段预约记录（每个订单至多一条）
+type StoreSlotReservation struct {
+	BaseModel
+	OrderID    uint       `gorm:"uniqueIndex;not null" json:"order_id"`
+	StoreID    uint       `gorm:"index;not null" json:"store_id"`
+	TemplateID uint       `gorm:"index" json:"template_id"`
+	SlotStart  time.Time  `gorm:"index;not null" json:"slot_start"`
+	SlotEnd    time.Time  `json:"slot_end"`
+	Status     int        `gorm:"type:tinyint;default:1" json:"status"` // 1:已占用 2:已释放
+	ReleasedAt *time.Time `json:"released_at"`
+}
+
+// 时段预约状态
+const (
+	SlotReservationActive   = 1
+	SlotReservationReleased = 2
+)
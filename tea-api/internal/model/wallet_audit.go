@@ -0,0 +1,58 @@
+package model
+
+import "time"
+
+// 钱包巡检发现类型
+const (
+	WalletAuditGap             = "gap"              // 流水缺少 balance_after
+	WalletAuditChainBreak      = "chain_break"      // 上一条 balance_after + 本条金额 != 本条 balance_after
+	WalletAuditNegative        = "negative"         // 重放或记录的余额出现负数
+	WalletAuditBalanceMismatch = "balance_mismatch" // 流水重放结果与 wallets.balance 不一致
+	WalletAuditLedgerMismatch  = "ledger_mismatch"  // wallets.balance 与账本可用余额科目不一致
+	WalletAuditFrozenMismatch  = "frozen_mismatch"  // wallets.frozen 与账本冻结科目不一致
+	WalletAuditFrozenReplay    = "frozen_replay"    // 冻结/解冻类流水重放结果与 wallets.frozen 不一致
+)
+
+// 钱包巡检发现状态
+const (
+	WalletAuditFindingOpen     = "open"     // 待处理
+	WalletAuditFindingResolved = "resolved" // 复检已不再出现
+	WalletAuditFindingIgnored  = "ignored"  // 人工确认忽略（如历史数据），后续巡检不再重复上报
+)
+
+// WalletAuditRun 钱包余额巡检批次
+type WalletAuditRun struct {
+	ID            uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	Trigger       string     `gorm:"column:trigger_type;type:varchar(20);not null" json:"trigger"` // schedule / manual
+	UserID        *uint      `gorm:"index" json:"user_id"`                                         // 仅巡检单个用户时非空
+	Status        string     `gorm:"type:varchar(20);not null;default:'running'" json:"status"`    // running / success / failed
+	UsersChecked  int        `gorm:"not null;default:0" json:"users_checked"`
+	TxChecked     int        `gorm:"not null;default:0" json:"tx_checked"`
+	FindingCount  int        `gorm:"not null;default:0" json:"finding_count"` // 本次检出（含已存在）的问题数
+	NewFindings   int        `gorm:"not null;default:0" json:"new_findings"`
+	Resolved      int        `gorm:"not null;default:0" json:"resolved"`
+	TicketsOpened int        `gorm:"not null;default:0" json:"tickets_opened"`
+	Error         string     `gorm:"type:varchar(500)" json:"error"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at"`
+	OperatorID    *uint      `json:"operator_id"`
+}
+
+// WalletAuditFinding 钱包巡检发现：同一用户、类型、流水的问题只保留一条，复检时刷新
+type WalletAuditFinding struct {
+	ID         uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	RunID      uint       `gorm:"index;not null" json:"run_id"`      // 首次发现的批次
+	LastRunID  uint       `gorm:"index;not null" json:"last_run_id"` // 最近一次检出的批次
+	UserID     uint       `gorm:"index:idx_wallet_audit_finding_key;not null" json:"user_id"`
+	Kind       string     `gorm:"type:varchar(32);index:idx_wallet_audit_finding_key;not null" json:"kind"`
+	TxID       uint       `gorm:"index:idx_wallet_audit_finding_key;default:0" json:"tx_id"` // 关联流水，用户级问题为 0
+	Expected   int64      `json:"expected"`                                                  // 分
+	Actual     int64      `json:"actual"`                                                    // 分
+	Detail     string     `gorm:"type:varchar(500)" json:"detail"`
+	Status     string     `gorm:"type:varchar(20);index;not null;default:'open'" json:"status"`
+	TicketID   *uint      `json:"ticket_id"`
+	Note       string     `gorm:"type:varchar(255)" json:"note"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 银行代付批次状态常量
+const (
+	PayoutBatchStatusGenerated = 1 // 已生成付款文件，待回盘
+	PayoutBatchStatusPartial   = 2 // 部分回盘
+	PayoutBatchStatusFinished  = 3 // 全部回盘
+)
+
+// 代付明细状态常量
+const (
+	PayoutLineStatusPending = 1 // 待回盘
+	PayoutLineStatusSuccess = 2 // 付款成功
+	PayoutLineStatusFailed  = 3 // 付款失败
+)
+
+// WithdrawPayoutBatch 银行批量代付批次：由已受理的提现生成付款文件，导入回盘文件后逐笔完成或退回
+type WithdrawPayoutBatch struct {
+	BaseModel
+	BatchNo      string          `gorm:"type:varchar(64);uniqueIndex;not null" json:"batch_no"`
+	Layout       string          `gorm:"type:varchar(64);not null" json:"layout"` // 付款文件版式，见 finance.bank_payout.layouts
+	Status       int             `gorm:"type:tinyint;default:1;index" json:"status"`
+	TotalCount   int             `json:"total_count"`
+	TotalAmount  decimal.Decimal `gorm:"type:decimal(12,2);default:0" json:"total_amount"`
+	SuccessCount int             `json:"success_count"`
+	FailCount    int             `json:"fail_count"`
+	CreatedBy    uint            `json:"created_by"`
+	ImportedAt   *time.Time      `json:"imported_at"`
+
+	Lines []WithdrawPayoutLine `gorm:"foreignKey:BatchID" json:"lines,omitempty"`
+}
+
+// WithdrawPayoutLine 代付明细：生成时快照收款账户，回盘后记录结果
+type WithdrawPayoutLine struct {
+	BaseModel
+	BatchID     uint            `gorm:"index;not null" json:"batch_id"`
+	LineNo      int             `json:"line_no"`
+	WithdrawID  uint            `gorm:"index;not null" json:"withdraw_id"`
+	WithdrawNo  string          `gorm:"type:varchar(64);index" json:"withdraw_no"`
+	AccountName string          `gorm:"type:varchar(128)" json:"account_name"`
+	AccountNo   string          `gorm:"type:varchar(128)" json:"account_no"`
+	BankName    string          `gorm:"type:varchar(128)" json:"bank_name"`
+	Amount      decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"` // 实付金额
+	Status      int             `gorm:"type:tinyint;default:1" json:"status"`
+	FailReason  string          `gorm:"type:varchar(255)" json:"fail_reason"`
+	SettledAt   *time.Time      `json:"settled_at"`
+}
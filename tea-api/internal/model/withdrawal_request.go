@@ -15,4 +15,7 @@ type WithdrawalRequest struct {
     Remark        string     `gorm:"type:varchar(255)" json:"remark,omitempty"`
     RequestedAt   time.Time  `gorm:"column:requested_at;autoCreateTime" json:"requested_at"`
     ProcessedAt   *time.Time `gorm:"column:processed_at" json:"processed_at,omitempty"`
+    // 风控：申请时命中的需人工复核原因（JSON 数组），NeedReview 为真时需人工审核后再处理
+    RiskFlags     string     `gorm:"column:risk_flags;type:varchar(512)" json:"risk_flags,omitempty"`
+    NeedReview    bool       `gorm:"column:need_review;default:0;index" json:"need_review"`
 }
@@ -26,12 +26,16 @@ func SetupRouter() *gin.Engine {
 	systemConfigHandler := handler.NewSystemConfigHandler()
 	rechargeAdminHandler := handler.NewRechargeAdminHandler()
 	rechargeConfigHandler := handler.NewRechargeConfigHandler()
+	giftCardAdminHandler := handler.NewGiftCardAdminHandler()
+	pointsAdminHandler := handler.NewPointsAdminHandler()
 	bannerHandler := handler.NewBannerHandler()
 	refundHandler := handler.NewRefundHandler()
 	financeReportHandler := handler.NewFinanceReportHandler()
 	commissionAdminHandler := handler.NewCommissionAdminHandler()
 	membershipAdminHandler := handler.NewMembershipAdminHandler()
 	partnerAdminHandler := handler.NewPartnerAdminHandler()
+	referralAdminHandler := handler.NewReferralAdminHandler()
+	teamHandler := handler.NewTeamHandler()
 	membershipHandler := handler.NewMembershipHandler()
 	dashboardHandler := handler.NewDashboardHandler()
 	contentHandler := handler.NewContentHandler()
@@ -43,9 +47,14 @@ func SetupRouter() *gin.Engine {
 	paymentHandler := handler.NewPaymentHandler()
 	paymentAdminHandler := handler.NewPaymentAdminHandler()
 	withdrawAdminHandler := handler.NewWithdrawAdminHandler()
+	withdrawTransferHandler := handler.NewWithdrawTransferHandler()
+	withdrawPayoutHandler := handler.NewWithdrawPayoutHandler()
 	couponHandler := handler.NewCouponHandler()
 	storeHandler := handler.NewStoreHandler()
 	invHandler := handler.NewStoreInventoryHandler()
+	timeSlotHandler := handler.NewTimeSlotHandler()
+	printHandler := handler.NewPrintHandler()
+	invoiceHandler := handler.NewInvoiceHandler()
 	modelHandler := handler.NewModelHandler()
 	uploadHandler := handler.NewUploadHandler()
 	activityHandler := handler.NewActivityHandler()
@@ -68,22 +77,45 @@ func SetupRouter() *gin.Engine {
 	api.GET("/wallet", middleware.AuthJWT(), handler.GetMyWallet)
 	api.GET("/wallet/transactions", middleware.AuthJWT(), handler.ListMyWalletTransactions)
 
+	// 用户充值：档位查询、创建充值订单（支付走统一下单）与充值记录
+	api.GET("/recharge/options", middleware.AuthJWT(), handler.GetRechargeOptions)
+	api.POST("/recharge/orders", middleware.AuthJWT(), middleware.Idempotency(), handler.CreateRechargeOrder)
+	api.GET("/recharge/orders", middleware.AuthJWT(), handler.ListMyRecharges)
+
+	// 礼品卡：凭卡号卡密查询与兑换入钱包，已兑换卡列表
+	api.POST("/gift-cards/query", middleware.AuthJWT(), handler.QueryGiftCard)
+	api.POST("/gift-cards/redeem", middleware.AuthJWT(), middleware.Idempotency(), handler.RedeemGiftCard)
+	api.GET("/gift-cards", middleware.AuthJWT(), handler.ListMyGiftCards)
+
 	// Sprint B: 用户提现账户与申请
 	api.GET("/wallet/bank-accounts", middleware.AuthJWT(), handler.ListMyBankAccounts)
 	api.POST("/wallet/bank-accounts", middleware.AuthJWT(), handler.CreateMyBankAccount)
 	api.DELETE("/wallet/bank-accounts/:id", middleware.AuthJWT(), handler.DeleteMyBankAccount)
 	// 两种入口：按 PRD 支持 /wallet/withdrawals 以及 /users/{id}/withdrawals
-	api.POST("/wallet/withdrawals", middleware.AuthJWT(), handler.CreateMyWithdrawal)
+	api.POST("/wallet/withdrawals", middleware.AuthJWT(), middleware.Idempotency(), handler.CreateMyWithdrawal)
 	api.GET("/users/:id/withdrawals", middleware.AuthJWT(), handler.ListUserWithdrawals)
-	api.POST("/users/:id/withdrawals", middleware.AuthJWT(), handler.CreateUserWithdrawal)
+	api.POST("/users/:id/withdrawals", middleware.AuthJWT(), middleware.Idempotency(), handler.CreateUserWithdrawal)
 
 	// Sprint B: 积分查询与流水
 	api.GET("/points", middleware.AuthJWT(), handler.GetMyPoints)
 	api.GET("/points/transactions", middleware.AuthJWT(), handler.ListMyPointsTransactions)
+	api.GET("/points/mall", middleware.AuthJWT(), handler.ListPointsMallItems)
+	api.POST("/points/mall/:id/exchange", middleware.AuthJWT(), middleware.Idempotency(), handler.ExchangePointsMallItem)
+	api.GET("/points/exchanges", middleware.AuthJWT(), handler.ListMyPointsExchanges)
 
 	// 用户侧退款查询（列表）
 	api.GET("/refunds", middleware.AuthJWT(), refundHandler.ListMyRefunds)
 
+	// 发票抬头与开票申请
+	api.GET("/invoice-titles", middleware.AuthJWT(), invoiceHandler.ListTitles)
+	api.POST("/invoice-titles", middleware.AuthJWT(), invoiceHandler.CreateTitle)
+	api.PUT("/invoice-titles/:id", middleware.AuthJWT(), invoiceHandler.UpdateTitle)
+	api.DELETE("/invoice-titles/:id", middleware.AuthJWT(), invoiceHandler.DeleteTitle)
+	api.GET("/invoices", middleware.AuthJWT(), invoiceHandler.ListMine)
+	api.POST("/invoices", middleware.AuthJWT(), invoiceHandler.Request)
+	api.GET("/invoices/:id", middleware.AuthJWT(), invoiceHandler.GetMine)
+	api.GET("/invoices/:id/download", middleware.AuthJWT(), invoiceHandler.DownloadMine)
+
 	// Sprint B: 优惠券模板与领取
 	api.GET("/coupons/templates", middleware.AuthJWT(), handler.ListCouponTemplates)
 	api.POST("/coupons/claim", middleware.AuthJWT(), handler.ClaimCouponFromTemplate)
@@ -94,12 +126,17 @@ func SetupRouter() *gin.Engine {
 	// 分享/推荐关系（最小版）
 	api.POST("/referrals/bind", middleware.AuthJWT(), handler.BindReferral)
 
+	// 合伙人团队统计：团队概览、成员贡献（游标分页）与规模趋势
+	api.GET("/partner/team", middleware.AuthJWT(), teamHandler.Summary)
+	api.GET("/partner/team/members", middleware.AuthJWT(), teamHandler.Members)
+	api.GET("/partner/team/growth", middleware.AuthJWT(), teamHandler.Growth)
+
 	// 小程序码生成（wxacodeunlimit）
 	api.POST("/wx/wxacode", middleware.AuthJWT(), handler.GetWxaCode)
 
 	// 会员相关（小程序/用户侧只读接口）
 	api.GET("/membership-packages", middleware.AuthMiddleware(), membershipHandler.ListPackages)
-	api.POST("/membership-orders", middleware.AuthMiddleware(), membershipHandler.CreateOrder)
+	api.POST("/membership-orders", middleware.AuthMiddleware(), middleware.Idempotency(), membershipHandler.CreateOrder)
 
 	// 用户相关路由
 	userGroup := api.Group("/user")
@@ -143,6 +180,7 @@ func SetupRouter() *gin.Engine {
 		adminGroup.POST("/users/:id/reset-password", middleware.OperationLogMiddleware(), userHandler.AdminResetPassword)
 		adminGroup.POST("/users/:id/blacklist", middleware.OperationLogMiddleware(), userHandler.AdminSetBlacklist)
 		adminGroup.POST("/users/:id/whitelist", middleware.OperationLogMiddleware(), userHandler.AdminSetWhitelist)
+		adminGroup.POST("/users/:id/real-name", middleware.OperationLogMiddleware(), userHandler.AdminSetRealName)
 		adminGroup.POST("/uploads", uploadHandler.UploadMedia)
 		// 门店订单统计
 		adminGroup.GET("/stores/:id/orders/stats", storeHandler.OrderStats)
@@ -152,6 +190,21 @@ func SetupRouter() *gin.Engine {
 		adminGroup.GET("/stores/:id/products", invHandler.List)
 		adminGroup.POST("/stores/:id/products", invHandler.Upsert)
 		adminGroup.DELETE("/stores/:id/products/:pid", invHandler.Delete)
+		// 门店预约时段模板与利用率
+		adminGroup.GET("/stores/:id/slot-templates", timeSlotHandler.ListTemplates)
+		adminGroup.POST("/stores/:id/slot-templates", timeSlotHandler.CreateTemplate)
+		adminGroup.PUT("/stores/:id/slot-templates/:tid", timeSlotHandler.UpdateTemplate)
+		adminGroup.DELETE("/stores/:id/slot-templates/:tid", timeSlotHandler.DeleteTemplate)
+		adminGroup.GET("/stores/:id/slots/utilization", timeSlotHandler.Utilization)
+
+		// 门店小票打印：打印机登记与打印模板（门店 ID 为 0 表示全局模板）
+		adminGroup.GET("/stores/:id/printers", printHandler.ListPrinters)
+		adminGroup.POST("/stores/:id/printers", printHandler.CreatePrinter)
+		adminGroup.PUT("/stores/:id/printers/:pid", printHandler.UpdatePrinter)
+		adminGroup.DELETE("/stores/:id/printers/:pid", printHandler.DeletePrinter)
+		adminGroup.GET("/stores/:id/print-templates", printHandler.ListTemplates)
+		adminGroup.PUT("/stores/:id/print-templates/:type", printHandler.SaveTemplate)
+		adminGroup.DELETE("/stores/:id/print-templates/:type", printHandler.DeleteTemplate)
 
 		// 客服工单管理
 		adminGroup.GET("/tickets", ticketHandler.List)
@@ -163,6 +216,8 @@ func SetupRouter() *gin.Engine {
 		adminGroup.GET("/orders", orderHandler.AdminList)
 		adminGroup.GET("/orders/export", orderHandler.AdminExport)
 		adminGroup.GET("/orders/:id", orderHandler.AdminDetail)
+		adminGroup.POST("/orders/:id/reprint", printHandler.Reprint)
+		adminGroup.GET("/orders/:id/print-jobs", printHandler.ListJobs)
 
 		// 会员与合伙人配置
 		adminGroup.GET("/membership-packages", membershipAdminHandler.ListPackages)
@@ -178,11 +233,18 @@ func SetupRouter() *gin.Engine {
 		// 合伙人管理（管理员视图）
 		adminGroup.GET("/partners", middleware.RequirePermission("user:partner:view"), partnerAdminHandler.ListPartners)
 		adminGroup.GET("/partners/:id/commissions", middleware.RequirePermission("user:partner:view"), partnerAdminHandler.ListCommissions)
+		// 推荐关系：推荐链查询、子树迁移与审计日志、团队树浏览与统计
+		adminGroup.GET("/referrals/:id/ancestors", middleware.RequirePermission("user:partner:view"), referralAdminHandler.Ancestors)
+		adminGroup.GET("/referrals/move-logs", middleware.RequirePermission("user:partner:view"), referralAdminHandler.MoveLogs)
+		adminGroup.POST("/referrals/move", middleware.RequirePermission("user:partner:manage"), middleware.Idempotency(), referralAdminHandler.Move)
+		adminGroup.GET("/referrals/:id/team", middleware.RequirePermission("user:partner:view"), referralAdminHandler.TeamSummary)
+		adminGroup.GET("/referrals/:id/tree", middleware.RequirePermission("user:partner:view"), referralAdminHandler.Tree)
+		adminGroup.GET("/referrals/:id/growth", middleware.RequirePermission("user:partner:view"), referralAdminHandler.TeamGrowth)
 
 		// 提现申请审批（管理员）别名路径，复用 withdraws 处理器
 		adminGroup.GET("/withdrawals", withdrawAdminHandler.List)
-		adminGroup.POST("/withdrawals/:id/approve", withdrawAdminHandler.Approve)
-		adminGroup.POST("/withdrawals/:id/reject", withdrawAdminHandler.Reject)
+		adminGroup.POST("/withdrawals/:id/approve", middleware.Idempotency(), withdrawAdminHandler.Approve)
+		adminGroup.POST("/withdrawals/:id/reject", middleware.Idempotency(), withdrawAdminHandler.Reject)
 	}
 
 	// 调试与容错：为订单趋势提供一个仅鉴权、不做角色校验的别名，便于前端联调
@@ -278,18 +340,55 @@ func SetupRouter() *gin.Engine {
 
 		rechargeGroup.Use(middleware.OperationLogMiddleware())
 		rechargeGroup.PUT("/configs", middleware.RequirePermission("marketing:recharge:manage"), rechargeConfigHandler.UpsertMany)
-		rechargeGroup.POST("/users/:id/freeze", middleware.RequirePermission("marketing:recharge:manage"), rechargeAdminHandler.Freeze)
-		rechargeGroup.POST("/users/:id/unfreeze", middleware.RequirePermission("marketing:recharge:manage"), rechargeAdminHandler.Unfreeze)
-		rechargeGroup.POST("/users/:id/credit", middleware.RequirePermission("marketing:recharge:manage"), rechargeAdminHandler.Credit)
-		rechargeGroup.POST("/users/:id/debit", middleware.RequirePermission("marketing:recharge:manage"), rechargeAdminHandler.Debit)
+		rechargeGroup.POST("/users/:id/freeze", middleware.RequirePermission("marketing:recharge:manage"), middleware.Idempotency(), rechargeAdminHandler.Freeze)
+		rechargeGroup.POST("/users/:id/unfreeze", middleware.RequirePermission("marketing:recharge:manage"), middleware.Idempotency(), rechargeAdminHandler.Unfreeze)
+		rechargeGroup.POST("/users/:id/credit", middleware.RequirePermission("marketing:recharge:manage"), middleware.Idempotency(), rechargeAdminHandler.Credit)
+		rechargeGroup.POST("/users/:id/debit", middleware.RequirePermission("marketing:recharge:manage"), middleware.Idempotency(), rechargeAdminHandler.Debit)
+	}
+
+	// 营销：积分发放/抵扣规则、期初对齐、流水查询与积分商城
+	pointsGroup := api.Group("/admin/points")
+	pointsGroup.Use(middleware.AuthMiddleware())
+	{
+		pointsGroup.GET("/rules", middleware.RequirePermission("marketing:points:view"), pointsAdminHandler.GetRules)
+		pointsGroup.GET("/transactions", middleware.RequirePermission("marketing:points:view"), pointsAdminHandler.ListTransactions)
+		pointsGroup.GET("/mall-items", middleware.RequirePermission("marketing:points:view"), pointsAdminHandler.ListMallItems)
+		pointsGroup.GET("/exchanges", middleware.RequirePermission("marketing:points:view"), pointsAdminHandler.ListExchanges)
+
+		pointsGroup.Use(middleware.OperationLogMiddleware())
+		pointsGroup.PUT("/rules", middleware.RequirePermission("marketing:points:manage"), pointsAdminHandler.UpdateRules)
+		pointsGroup.POST("/reconcile", middleware.RequirePermission("marketing:points:manage"), middleware.Idempotency(), pointsAdminHandler.Reconcile)
+		pointsGroup.POST("/expiry/run", middleware.RequirePermission("marketing:points:manage"), middleware.Idempotency(), pointsAdminHandler.RunExpiry)
+		pointsGroup.POST("/mall-items", middleware.RequirePermission("marketing:points:manage"), middleware.Idempotency(), pointsAdminHandler.CreateMallItem)
+		pointsGroup.PUT("/mall-items/:id", middleware.RequirePermission("marketing:points:manage"), pointsAdminHandler.UpdateMallItem)
 	}
 
-	// 退款记录（只读列表与导出，按退款权限控制）
+	// 营销：实体礼品卡（批次制卡、卡密导出、批次冻结/作废、卡片查询）
+	giftCardGroup := api.Group("/admin/gift-cards")
+	giftCardGroup.Use(middleware.AuthMiddleware())
+	{
+		giftCardGroup.GET("", middleware.RequirePermission("marketing:recharge:view"), giftCardAdminHandler.ListCards)
+		giftCardGroup.GET("/batches", middleware.RequirePermission("marketing:recharge:view"), giftCardAdminHandler.ListBatches)
+		giftCardGroup.GET("/batches/:id", middleware.RequirePermission("marketing:recharge:view"), giftCardAdminHandler.GetBatch)
+		giftCardGroup.GET("/:id", middleware.RequirePermission("marketing:recharge:view"), giftCardAdminHandler.GetCard)
+		// 导出明文卡密，仅限管理权限
+		giftCardGroup.GET("/batches/:id/export", middleware.RequirePermission("marketing:recharge:manage"), giftCardAdminHandler.ExportBatch)
+
+		giftCardGroup.Use(middleware.OperationLogMiddleware())
+		giftCardGroup.POST("/batches", middleware.RequirePermission("marketing:recharge:manage"), middleware.Idempotency(), giftCardAdminHandler.CreateBatch)
+		giftCardGroup.POST("/batches/:id/freeze", middleware.RequirePermission("marketing:recharge:manage"), giftCardAdminHandler.FreezeBatch)
+		giftCardGroup.POST("/batches/:id/unfreeze", middleware.RequirePermission("marketing:recharge:manage"), giftCardAdminHandler.UnfreezeBatch)
+		giftCardGroup.POST("/batches/:id/void", middleware.RequirePermission("marketing:recharge:manage"), giftCardAdminHandler.VoidBatch)
+		giftCardGroup.POST("/:id/reset-pin-failures", middleware.RequirePermission("marketing:recharge:manage"), giftCardAdminHandler.ResetPinFailures)
+	}
+
+	// 退款记录（列表、导出与失败重试，按退款权限控制）
 	refundsGroup := api.Group("/admin/refunds")
 	refundsGroup.Use(middleware.AuthMiddleware())
 	{
 		refundsGroup.GET("", middleware.RequirePermission("order:refund"), refundHandler.ListRefunds)
 		refundsGroup.GET("/export", middleware.RequirePermission("order:refund"), refundHandler.ExportRefunds)
+		refundsGroup.POST("/:id/retry", middleware.RequirePermission("order:refund"), middleware.Idempotency(), refundHandler.RetryRefund)
 	}
 
 	// 支付记录（财务流水，只读列表与导出，与退款同权限控制）
@@ -298,6 +397,10 @@ func SetupRouter() *gin.Engine {
 	{
 		paymentsGroup.GET("", middleware.RequirePermission("order:refund"), paymentAdminHandler.ListPayments)
 		paymentsGroup.GET("/export", middleware.RequirePermission("order:refund"), paymentAdminHandler.ExportPayments)
+		paymentsGroup.GET("/reconcile/metrics", middleware.RequirePermission("order:refund"), paymentAdminHandler.ReconcileMetrics)
+		paymentsGroup.GET("/notifications", middleware.RequirePermission("order:refund"), paymentAdminHandler.ListNotifications)
+		paymentsGroup.GET("/notifications/:id", middleware.RequirePermission("order:refund"), paymentAdminHandler.GetNotification)
+		paymentsGroup.POST("/notifications/:id/reprocess", middleware.RequirePermission("order:refund"), middleware.Idempotency(), paymentAdminHandler.ReprocessNotification)
 	}
 
 	// 提现记录（财务）
@@ -306,9 +409,32 @@ func SetupRouter() *gin.Engine {
 	{
 		withdrawGroup.GET("", middleware.RequirePermission("order:refund"), withdrawAdminHandler.List)
 		withdrawGroup.GET("/export", middleware.RequirePermission("order:refund"), withdrawAdminHandler.Export)
-		withdrawGroup.POST("/:id/approve", middleware.RequirePermission("order:refund"), withdrawAdminHandler.Approve)
-		withdrawGroup.POST("/:id/complete", middleware.RequirePermission("order:refund"), withdrawAdminHandler.Complete)
-		withdrawGroup.POST("/:id/reject", middleware.RequirePermission("order:refund"), withdrawAdminHandler.Reject)
+		withdrawGroup.POST("/:id/approve", middleware.RequirePermission("order:refund"), middleware.Idempotency(), withdrawAdminHandler.Approve)
+		withdrawGroup.POST("/:id/complete", middleware.RequirePermission("order:refund"), middleware.Idempotency(), withdrawAdminHandler.Complete)
+		withdrawGroup.POST("/:id/reject", middleware.RequirePermission("order:refund"), middleware.Idempotency(), withdrawAdminHandler.Reject)
+		// 微信商家转账：单笔发起/同步、批量发起与转账单查询
+		withdrawGroup.GET("/:id/transfer", middleware.RequirePermission("order:refund"), withdrawTransferHandler.Get)
+		withdrawGroup.POST("/:id/transfer", middleware.RequirePermission("order:refund"), middleware.Idempotency(), withdrawTransferHandler.Start)
+		withdrawGroup.POST("/transfer", middleware.RequirePermission("order:refund"), middleware.Idempotency(), withdrawTransferHandler.BatchStart)
+		withdrawGroup.POST("/batch-approve", middleware.RequirePermission("order:refund"), middleware.Idempotency(), withdrawAdminHandler.BatchApprove)
+		// 银行批量代付：生成付款文件、下载、导入回盘
+		withdrawGroup.GET("/payout-layouts", middleware.RequirePermission("order:refund"), withdrawPayoutHandler.Layouts)
+		withdrawGroup.GET("/payout-batches", middleware.RequirePermission("order:refund"), withdrawPayoutHandler.List)
+		withdrawGroup.POST("/payout-batches", middleware.RequirePermission("order:refund"), middleware.Idempotency(), withdrawPayoutHandler.Create)
+		withdrawGroup.GET("/payout-batches/:id", middleware.RequirePermission("order:refund"), withdrawPayoutHandler.Get)
+		withdrawGroup.GET("/payout-batches/:id/file", middleware.RequirePermission("order:refund"), withdrawPayoutHandler.Download)
+		withdrawGroup.POST("/payout-batches/:id/result", middleware.RequirePermission("order:refund"), middleware.Idempotency(), withdrawPayoutHandler.ImportResult)
+	}
+
+	// 发票审核与开具（财务）
+	invoiceGroup := api.Group("/admin/invoices")
+	invoiceGroup.Use(middleware.AuthMiddleware())
+	{
+		invoiceGroup.GET("", middleware.RequirePermission("order:refund"), invoiceHandler.AdminList)
+		invoiceGroup.GET("/:id", middleware.RequirePermission("order:refund"), invoiceHandler.AdminDetail)
+		invoiceGroup.GET("/:id/download", middleware.RequirePermission("order:refund"), invoiceHandler.AdminDownload)
+		invoiceGroup.POST("/:id/approve", middleware.RequirePermission("order:refund"), invoiceHandler.AdminApprove)
+		invoiceGroup.POST("/:id/reject", middleware.RequirePermission("order:refund"), invoiceHandler.AdminReject)
 	}
 
 	// 财务报表（对账概要/导出）
@@ -320,10 +446,19 @@ func SetupRouter() *gin.Engine {
 		// 支付对账差异
 		financeGroup.GET("/reconcile/diff", middleware.RequirePermission("order:refund"), financeReportHandler.ReconcileDiff)
 		financeGroup.GET("/reconcile/diff/export", middleware.RequirePermission("order:refund"), financeReportHandler.ExportReconcileDiff)
+		// 渠道对账单导入与三方核对
+		financeGroup.POST("/settlements/import", middleware.RequirePermission("order:refund"), financeReportHandler.ImportSettlement)
+		financeGroup.GET("/settlements/match", middleware.RequirePermission("order:refund"), financeReportHandler.SettlementMatch)
+		financeGroup.GET("/settlements/match/export", middleware.RequirePermission("order:refund"), financeReportHandler.ExportSettlementMatch)
+		// 钱包余额巡检
+		financeGroup.POST("/wallet-audit/run", middleware.RequirePermission("order:refund"), financeReportHandler.RunWalletAudit)
+		financeGroup.GET("/wallet-audit/runs", middleware.RequirePermission("order:refund"), financeReportHandler.ListWalletAuditRuns)
+		financeGroup.GET("/wallet-audit/findings", middleware.RequirePermission("order:refund"), financeReportHandler.ListWalletAuditFindings)
+		financeGroup.POST("/wallet-audit/findings/:id/ignore", middleware.RequirePermission("order:refund"), financeReportHandler.IgnoreWalletAuditFinding)
 		// 佣金解冻手动触发，仅限具备财务权限的账号
 		financeGroup.POST("/commission/release", middleware.RequirePermission("order:refund"), commissionAdminHandler.TriggerRelease)
 		// 按订单一键回滚未提现佣金
-		financeGroup.POST("/commission/reverse-order", middleware.RequirePermission("order:refund"), commissionAdminHandler.ReverseOrder)
+		financeGroup.POST("/commission/reverse-order", middleware.RequirePermission("order:refund"), middleware.Idempotency(), commissionAdminHandler.ReverseOrder)
 	}
 
 	// 商品分类路由
@@ -363,21 +498,21 @@ func SetupRouter() *gin.Engine {
 	orderGroup := api.Group("/orders")
 	orderGroup.Use(middleware.AuthJWT())
 	{
-		orderGroup.POST("/from-cart", orderHandler.CreateFromCart)
+		orderGroup.POST("/from-cart", middleware.Idempotency(), orderHandler.CreateFromCart)
 		orderGroup.POST("/available-coupons", orderHandler.AvailableCoupons)
 		orderGroup.GET("", orderHandler.List)
 		orderGroup.GET("/:id", orderHandler.Detail)
 		orderGroup.POST("/:id/cancel", orderHandler.Cancel)
-		orderGroup.POST("/:id/pay", orderHandler.Pay)
+		orderGroup.POST("/:id/pay", middleware.Idempotency(), orderHandler.Pay)
 		orderGroup.POST("/:id/receive", orderHandler.Receive)
 		// 下列操作仅允许具备相应权限（或admin）
 		orderGroup.POST("/:id/deliver", middleware.RequirePermission("order:deliver"), orderHandler.Deliver)
 		orderGroup.POST("/:id/complete", middleware.RequirePermission("order:complete"), orderHandler.Complete)
 		orderGroup.POST("/:id/admin-cancel", middleware.RequirePermission("order:cancel"), orderHandler.AdminCancel)
 		orderGroup.POST("/:id/adjust", middleware.RequirePermission("order:adjust"), orderHandler.AdminAdjustPayAmount)
-		orderGroup.POST("/:id/refund", middleware.RequirePermission("order:refund"), orderHandler.AdminRefund)
+		orderGroup.POST("/:id/refund", middleware.RequirePermission("order:refund"), middleware.Idempotency(), orderHandler.AdminRefund)
 		orderGroup.POST("/:id/refund/start", middleware.RequirePermission("order:refund"), orderHandler.AdminRefundStart)
-		orderGroup.POST("/:id/refund/confirm", middleware.RequirePermission("order:refund"), orderHandler.AdminRefundConfirm)
+		orderGroup.POST("/:id/refund/confirm", middleware.RequirePermission("order:refund"), middleware.Idempotency(), orderHandler.AdminRefundConfirm)
 	}
 
 	// 门店相关路由
@@ -385,6 +520,8 @@ func SetupRouter() *gin.Engine {
 	{
 		storeGroup.GET("", storeHandler.List)
 		storeGroup.GET(":id", storeHandler.Get)
+		// 门店可预约时段（自取/配送）
+		storeGroup.GET(":id/slots", timeSlotHandler.AvailableSlots)
 		storeGroup.POST("", middleware.AuthJWT(), storeHandler.Create)
 		storeGroup.PUT(":id", middleware.AuthJWT(), storeHandler.Update)
 		storeGroup.DELETE(":id", middleware.AuthJWT(), storeHandler.Delete)
@@ -396,7 +533,7 @@ func SetupRouter() *gin.Engine {
 		// 门店钱包与提现接口（需要登录，后续可按角色细化权限）
 		storeGroup.GET(":id/wallet", middleware.AuthJWT(), middleware.RequirePermission("store:wallet:view"), storeHandler.Wallet)
 		storeGroup.GET(":id/withdraws", middleware.AuthJWT(), middleware.RequirePermission("store:withdraw:view"), storeHandler.ListWithdraws)
-		storeGroup.POST(":id/withdraws", middleware.AuthJWT(), middleware.RequirePermission("store:withdraw:apply"), storeHandler.ApplyWithdraw)
+		storeGroup.POST(":id/withdraws", middleware.AuthJWT(), middleware.RequirePermission("store:withdraw:apply"), middleware.Idempotency(), storeHandler.ApplyWithdraw)
 		// 门店资金流水（支付/退款/提现聚合）与导出
 		storeGroup.GET(":id/finance/transactions", middleware.AuthJWT(), middleware.RequirePermission("store:wallet:view"), storeHandler.FinanceTransactions)
 		storeGroup.GET(":id/finance/transactions/export", middleware.AuthJWT(), middleware.RequirePermission("store:wallet:view"), storeHandler.ExportFinanceTransactions)
@@ -451,15 +588,19 @@ func SetupRouter() *gin.Engine {
 	payGroup := api.Group("/payment")
 	payGroup.Use(middleware.AuthJWT())
 	{
-		payGroup.POST("/intents", paymentHandler.CreateIntent)
+		payGroup.POST("/intents", middleware.Idempotency(), paymentHandler.CreateIntent)
 	}
 
 	userPaymentsGroup := api.Group("/payments")
 	userPaymentsGroup.Use(middleware.AuthJWT())
 	{
-		userPaymentsGroup.POST("/unified-order", paymentHandler.UnifiedOrder)
+		userPaymentsGroup.POST("/unified-order", middleware.Idempotency(), paymentHandler.UnifiedOrder)
+		userPaymentsGroup.POST("/balance", middleware.Idempotency(), paymentHandler.BalancePay)
 	}
 	api.POST("/payments/callback", paymentHandler.Callback)
+	api.POST("/payment/wechat/notify", paymentHandler.WechatNotify)
+	api.POST("/payment/wechat/transfer-notify", withdrawTransferHandler.Notify)
+	api.POST("/payment/alipay/notify", paymentHandler.AlipayNotify)
 	// 模拟回调（仅开发环境）
 	api.POST("/payment/mock-callback", paymentHandler.MockCallback)
 
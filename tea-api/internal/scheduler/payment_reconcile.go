@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"tea-api/internal/config"
+	"tea-api/internal/service"
+	"tea-api/pkg/database"
+)
+
+const paymentReconcileLockKey = "payment:reconcile:lock"
+
+// StartPaymentReconcileScheduler 启动待支付单对账：回调丢失时主动查询渠道补单，并关闭过期支付单
+func StartPaymentReconcileScheduler() {
+	cfg := config.Config.Payment
+	if !cfg.ReconcileEnabled {
+		zap.L().Info("payment reconcile scheduler disabled")
+		return
+	}
+	interval := time.Duration(cfg.ReconcileScanSeconds) * time.Second
+	if interval <= 0 {
+		interval = 2 * time.Minute
+	}
+	after := time.Duration(cfg.ReconcileAfterMinutes) * time.Minute
+	if after <= 0 {
+		after = 5 * time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runPaymentReconcileOnce(time.Now(), after, cfg.ReconcileBatchSize, interval)
+		}
+	}()
+}
+
+func runPaymentReconcileOnce(now time.Time, after time.Duration, batchSize int, interval time.Duration) {
+	// 多实例部署时通过 Redis 锁避免重复查询渠道；Redis 不可用时直接执行（回调处理本身幂等）
+	if r := database.GetRedis(); r != nil {
+		ok, err := r.SetNX(context.Background(), paymentReconcileLockKey, "1", interval).Result()
+		if err == nil && !ok {
+			zap.L().Info("payment reconcile skipped: lock exists")
+			return
+		}
+		if err == nil {
+			defer r.Del(context.Background(), paymentReconcileLockKey)
+		}
+	}
+	st, err := service.NewPaymentService().ReconcilePendingPayments(now, after, batchSize)
+	if err != nil {
+		zap.L().Error("payment reconcile failed", zap.Error(err))
+		return
+	}
+	if st.Scanned > 0 {
+		zap.L().Info("payment reconcile done", zap.Int("scanned", st.Scanned), zap.Int("paid", st.Paid),
+			zap.Int("failed", st.Failed), zap.Int("closed", st.Closed), zap.Int("errors", st.Errors))
+	}
+}
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"tea-api/internal/config"
+	"tea-api/internal/service"
+	"tea-api/pkg/database"
+)
+
+// StartPointsExpiryScheduler 启动每日积分过期处理与到期提醒
+func StartPointsExpiryScheduler() {
+	cfg := config.Config.Finance.PointsExpiry
+	if !cfg.Enabled {
+		zap.L().Info("points expiry scheduler disabled")
+		return
+	}
+	// 默认每天 00:10 执行，年末到期的积分在跨年后尽快作废
+	hhmm := strings.TrimSpace(cfg.Time)
+	if hhmm == "" {
+		hhmm = "00:10"
+	}
+	loc := time.Local
+	if cfg.Timezone != "" {
+		if l, err := time.LoadLocation(cfg.Timezone); err == nil {
+			loc = l
+		}
+	}
+	go loopDailyPointsExpiry(hhmm, cfg, loc)
+}
+
+func loopDailyPointsExpiry(hhmm string, cfg config.PointsExpiry, loc *time.Location) {
+	for {
+		next := nextTimeCommission(time.Now().In(loc), hhmm, loc)
+		wait := time.Until(next)
+		zap.L().Info("points expiry scheduled", zap.Time("next", next), zap.Duration("wait", wait))
+		timer := time.NewTimer(wait)
+		<-timer.C
+		runPointsExpiryOnce(next, cfg)
+	}
+}
+
+func runPointsExpiryOnce(date time.Time, cfg config.PointsExpiry) {
+	if cfg.UseRedisLock {
+		if r := database.GetRedis(); r != nil {
+			if !acquirePointsExpiryLock(r, date, cfg.LockTTLSecond) {
+				zap.L().Info("points expiry skipped: lock exists", zap.Time("date", date))
+				return
+			}
+			defer releasePointsExpiryLock(r, date)
+		} else {
+			zap.L().Warn("redis not available for points expiry, proceeding without distributed lock")
+		}
+	}
+
+	rep, err := service.NewPointsService().RunExpiry(time.Now())
+	if err != nil {
+		zap.L().Error("points expiry run failed", zap.Error(err))
+		return
+	}
+	zap.L().Info("points expiry run ok",
+		zap.Int("expired_lots", rep.ExpiredLots),
+		zap.Int("expired_users", rep.ExpiredUsers),
+		zap.Int64("expired_points", rep.ExpiredPoints),
+		zap.Int("notices_sent", rep.NoticesSent),
+		zap.Int("notices_failed", rep.NoticesFailed))
+}
+
+func acquirePointsExpiryLock(r *redis.Client, date time.Time, ttlSec int) bool {
+	key := fmt.Sprintf("points_expiry:lock:%s", date.Format("2006-01-02"))
+	if ttlSec <= 0 {
+		ttlSec = 3600
+	}
+	ok, _ := r.SetNX(context.Background(), key, "1", time.Duration(ttlSec)*time.Second).Result()
+	return ok
+}
+
+func releasePointsExpiryLock(r *redis.Client, date time.Time) {
+	key := fmt.Sprintf("points_expiry:lock:%s", date.Format("2006-01-02"))
+	_ = r.Del(context.Background(), key).Err()
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"tea-api/internal/config"
+	"tea-api/internal/service"
+	"tea-api/pkg/database"
+)
+
+const refundRetryLockKey = "refund:retry:lock"
+
+// StartRefundRetryScheduler 启动退款重试/同步扫描：重新提交到期的失败退款，主动查询超时未回调的处理中退款
+func StartRefundRetryScheduler() {
+	cfg := config.Config.Payment
+	if !cfg.RefundRetryEnabled {
+		zap.L().Info("refund retry scheduler disabled")
+		return
+	}
+	interval := time.Duration(cfg.RefundRetryScanSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runRefundRetryOnce(time.Now(), cfg.RefundBatchSize, interval)
+		}
+	}()
+}
+
+func runRefundRetryOnce(now time.Time, batchSize int, interval time.Duration) {
+	// 多实例部署时通过 Redis 锁避免重复提交；渠道退款按退款单号幂等，Redis 不可用时直接执行
+	if r := database.GetRedis(); r != nil {
+		ok, err := r.SetNX(context.Background(), refundRetryLockKey, "1", interval).Result()
+		if err == nil && !ok {
+			return
+		}
+		if err == nil {
+			defer r.Del(context.Background(), refundRetryLockKey)
+		}
+	}
+	n, err := service.NewPaymentService().RetryRefunds(now, batchSize)
+	if err != nil {
+		zap.L().Error("refund retry failed", zap.Int("processed", n), zap.Error(err))
+		return
+	}
+	if n > 0 {
+		zap.L().Info("refunds retried", zap.Int("refunds", n))
+	}
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"tea-api/internal/config"
+	"tea-api/internal/service"
+	"tea-api/pkg/database"
+)
+
+const stockExpireLockKey = "stock_reservation:expire:lock"
+
+// StartStockReservationExpirer 启动库存预占超时扫描：取消超时未支付订单并释放库存
+func StartStockReservationExpirer() {
+	cfg := config.Config.Inventory
+	if !cfg.ExpireEnabled {
+		zap.L().Info("stock reservation expirer disabled")
+		return
+	}
+	interval := time.Duration(cfg.ExpireScanSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runStockExpireOnce(time.Now(), cfg.ExpireBatchSize, interval)
+		}
+	}()
+}
+
+func runStockExpireOnce(now time.Time, batchSize int, interval time.Duration) {
+	// 多实例部署时通过 Redis 锁避免重复扫描；Redis 不可用时直接执行（取消操作本身是条件更新）
+	if r := database.GetRedis(); r != nil {
+		ok, err := r.SetNX(context.Background(), stockExpireLockKey, "1", interval).Result()
+		if err == nil && !ok {
+			return
+		}
+		if err == nil {
+			defer r.Del(context.Background(), stockExpireLockKey)
+		}
+	}
+	n, err := service.NewOrderService().ExpireUnpaidOrders(now, batchSize)
+	if err != nil {
+		// 失败订单已跳过，其余订单照常处理
+		zap.L().Error("stock reservation expire failed", zap.Int("processed", n), zap.Error(err))
+	}
+	if n > 0 {
+		zap.L().Info("stock reservation expired", zap.Int("orders", n))
+	}
+}
@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"tea-api/internal/config"
+	"tea-api/internal/service"
+	"tea-api/pkg/database"
+)
+
+// StartWalletAuditScheduler 启动每日钱包余额巡检
+func StartWalletAuditScheduler() {
+	cfg := config.Config.Finance.WalletAudit
+	if !cfg.Enabled {
+		zap.L().Info("wallet audit scheduler disabled")
+		return
+	}
+	// 默认每天 04:30 执行，错开计息与佣金解冻
+	hhmm := strings.TrimSpace(cfg.Time)
+	if hhmm == "" {
+		hhmm = "04:30"
+	}
+	loc := time.Local
+	if cfg.Timezone != "" {
+		if l, err := time.LoadLocation(cfg.Timezone); err == nil {
+			loc = l
+		}
+	}
+	go loopDailyWalletAudit(hhmm, cfg, loc)
+}
+
+func loopDailyWalletAudit(hhmm string, cfg config.WalletAudit, loc *time.Location) {
+	for {
+		next := nextTimeCommission(time.Now().In(loc), hhmm, loc)
+		wait := time.Until(next)
+		zap.L().Info("wallet audit scheduled", zap.Time("next", next), zap.Duration("wait", wait))
+		timer := time.NewTimer(wait)
+		<-timer.C
+		runWalletAuditOnce(next, cfg)
+	}
+}
+
+func runWalletAuditOnce(date time.Time, cfg config.WalletAudit) {
+	if cfg.UseRedisLock {
+		if r := database.GetRedis(); r != nil {
+			if !acquireWalletAuditLock(r, date, cfg.LockTTLSecond) {
+				zap.L().Info("wallet audit skipped: lock exists", zap.Time("date", date))
+				return
+			}
+			defer releaseWalletAuditLock(r, date)
+		} else {
+			zap.L().Warn("redis not available for wallet audit, proceeding without distributed lock")
+		}
+	}
+
+	run, err := service.NewWalletAuditService().Run(service.WalletAuditOptions{
+		Trigger:     service.WalletAuditTriggerSchedule,
+		OpenTickets: cfg.OpenTickets,
+	})
+	if err != nil {
+		zap.L().Error("wallet audit run failed", zap.Error(err))
+		return
+	}
+	zap.L().Info("wallet audit run ok",
+		zap.Uint("run_id", run.ID),
+		zap.Int("users", run.UsersChecked),
+		zap.Int("findings", run.FindingCount),
+		zap.Int("new", run.NewFindings),
+		zap.Int("resolved", run.Resolved))
+}
+
+func acquireWalletAuditLock(r *redis.Client, date time.Time, ttlSec int) bool {
+	key := fmt.Sprintf("wallet_audit:lock:%s", date.Format("2006-01-02"))
+	if ttlSec <= 0 {
+		ttlSec = 3600
+	}
+	ok, _ := r.SetNX(context.Background(), key, "1", time.Duration(ttlSec)*time.Second).Result()
+	return ok
+}
+
+func releaseWalletAuditLock(r *redis.Client, date time.Time) {
+	key := fmt.Sprintf("wallet_audit:lock:%s", date.Format("2006-01-02"))
+	_ = r.Del(context.Background(), key).Err()
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"tea-api/internal/config"
+	"tea-api/internal/service"
+	"tea-api/pkg/database"
+)
+
+const withdrawTransferLockKey = "withdraw:transfer:sync:lock"
+
+// StartWithdrawTransferSyncScheduler 启动提现转账结果同步：主动查询超时未回调的处理中转账，渠道侧不存在的按原单号重新提交
+func StartWithdrawTransferSyncScheduler() {
+	cfg := config.Config.Payment
+	if !cfg.TransferEnabled {
+		zap.L().Info("withdraw transfer sync scheduler disabled")
+		return
+	}
+	interval := time.Duration(cfg.TransferSyncScanSeconds) * time.Second
+	if interval <= 0 {
+		interval = 2 * time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runWithdrawTransferSyncOnce(time.Now(), cfg.TransferBatchSize, interval)
+		}
+	}()
+}
+
+func runWithdrawTransferSyncOnce(now time.Time, batchSize int, interval time.Duration) {
+	// 多实例部署时通过 Redis 锁避免重复查询；转账按单号幂等，Redis 不可用时直接执行
+	if r := database.GetRedis(); r != nil {
+		ok, err := r.SetNX(context.Background(), withdrawTransferLockKey, "1", interval).Result()
+		if err == nil && !ok {
+			return
+		}
+		if err == nil {
+			defer r.Del(context.Background(), withdrawTransferLockKey)
+		}
+	}
+	n, err := service.NewWithdrawTransferService().SyncTransfers(now, batchSize)
+	if err != nil {
+		zap.L().Error("withdraw transfer sync failed", zap.Int("processed", n), zap.Error(err))
+		return
+	}
+	if n > 0 {
+		zap.L().Info("withdraw transfers synced", zap.Int("transfers", n))
+	}
+}
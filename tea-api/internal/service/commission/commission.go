@@ -35,6 +35,8 @@ type CommissionRecord struct {
 	Fee              int64   // 手续费（分）
 	NetAmount        int64   // 净佣金（分）
 	AvailableAt      time.Time
+	LevelID          int64 // 费率来源：合伙人等级
+	PackageID        int64 // 费率来源：会员套餐
 }
 
 // CalculateDirectCommission 按基数和比例计算毛佣金（向下取整）
@@ -50,9 +52,9 @@ func CalculateIndirectCommission(directGross int64, indirectRate float64) int64
 }
 
 // BuildCommissionRecords 根据订单与传入的分佣配置生成佣金记录（内存计算）
-// 参数：directRate 为直推比例（对推荐人），indirectRate 为间接比例（对上级对应的团队奖）
-// 返回：生成的 CommissionRecord 列表（包含 direct + indirect）
-func BuildCommissionRecords(order Order, directReferrerID int64, directRate float64, indirectRate float64, holdPeriodDays int) []CommissionRecord {
+// 参数：directRate 为直推比例（对推荐人），indirectRate 为间接比例（对推荐人的上级 indirectReferrerID 的团队奖）
+// 返回：生成的 CommissionRecord 列表（包含 direct + indirect；无上级时仅 direct）
+func BuildCommissionRecords(order Order, directReferrerID, indirectReferrerID int64, directRate float64, indirectRate float64, holdPeriodDays int) []CommissionRecord {
 	// 计算结算基数：订单应付项减去运费/优惠/折扣（按需求：商品售价 - 快递费用 - 营销优惠费用 - 折扣费用）
 	calculationBasis := order.TotalAmount - order.ShippingAmount - order.CouponAmount - order.DiscountAmount
 	if calculationBasis < 0 {
@@ -77,10 +79,9 @@ func BuildCommissionRecords(order Order, directReferrerID int64, directRate floa
 	// 间接佣金（对直接推荐人的上级）
 	indirectGross := CalculateIndirectCommission(directGross, indirectRate)
 	var indirect CommissionRecord
-	if indirectGross > 0 {
-		// 在此 stub 中我们不传入上级 ID（实际应通过 referrals_closure 查找祖先），用 0 占位
+	if indirectGross > 0 && indirectReferrerID != 0 {
 		indirect = CommissionRecord{
-			UserID:           0,
+			UserID:           indirectReferrerID,
 			OrderID:          order.ID,
 			CommissionType:   "indirect",
 			SourceUserID:     order.UserID,
@@ -94,7 +95,7 @@ func BuildCommissionRecords(order Order, directReferrerID int64, directRate floa
 	}
 
 	out := []CommissionRecord{direct}
-	if indirectGross > 0 {
+	if indirect.UserID != 0 {
 		out = append(out, indirect)
 	}
 	return out
@@ -34,7 +34,7 @@ func TestBuildCommissionRecords(t *testing.T) {
 		CouponAmount:   5000,   // 50.00
 		DiscountAmount: 0,
 	}
-	recs := BuildCommissionRecords(order, 3001, 0.30, 0.10, 7)
+	recs := BuildCommissionRecords(order, 3001, 3002, 0.30, 0.10, 7)
 	if len(recs) != 2 {
 		t.Fatalf("expected 2 commission records (direct+indirect), got %d", len(recs))
 	}
@@ -46,12 +46,15 @@ func TestBuildCommissionRecords(t *testing.T) {
 		t.Fatalf("direct gross wrong: want 27900 got %d", direct.GrossAmount)
 	}
 	indirect := recs[1]
-	if indirect.CommissionType != "indirect" {
+	if indirect.CommissionType != "indirect" || indirect.UserID != 3002 {
 		t.Fatalf("indirect record mismatch: %+v", indirect)
 	}
 	if indirect.GrossAmount != 2790 {
 		t.Fatalf("indirect gross wrong: want 2790 got %d", indirect.GrossAmount)
 	}
+	if recs := BuildCommissionRecords(order, 3001, 0, 0.30, 0.10, 7); len(recs) != 1 {
+		t.Fatalf("without an indirect referrer only the direct record is expected, got %d", len(recs))
+	}
 	// available_at 应约等于 now + 7 天（允许少许时间差）
 	if time.Until(direct.AvailableAt) < 6*24*time.Hour {
 		t.Fatalf("direct AvailableAt too soon: %v", direct.AvailableAt)
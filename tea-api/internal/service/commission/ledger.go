@@ -0,0 +1,36 @@
+package commission
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service/ledger"
+)
+
+// 佣金账本事件类型
+const (
+	LedgerEventAccrue   = "commission_accrue"   // 计提：借平台费用、贷用户佣金
+	LedgerEventWithdraw = "commission_withdraw" // 打款：借用户佣金、贷渠道清算
+	LedgerEventReverse  = "commission_reverse"  // 回滚：借用户佣金、贷平台费用
+)
+
+// postCommissionTx 按净佣金过账；accrue 为 true 时贷记用户佣金，否则借记用户佣金，counter 为对方科目
+func postCommissionTx(tx *gorm.DB, cm *model.Commission, event, counter string, accrue bool) error {
+	cents := cm.NetAmount.Mul(decimal.NewFromInt(100)).Round(0).IntPart()
+	lines := []ledger.Line{ledger.Debit(ledger.Commission(cm.UserID), cents), ledger.Credit(counter, cents)}
+	if accrue {
+		lines = []ledger.Line{ledger.Debit(counter, cents), ledger.Credit(ledger.Commission(cm.UserID), cents)}
+	}
+	_, err := ledger.Post(tx, ledger.Journal{
+		EventType: event,
+		BizKey:    fmt.Sprintf("%s:%d", event, cm.ID),
+		RefType:   "commission",
+		RefID:     fmt.Sprintf("%d", cm.ID),
+		Memo:      fmt.Sprintf("%s commission #%d", cm.CommissionType, cm.ID),
+		Lines:     lines,
+	})
+	return err
+}
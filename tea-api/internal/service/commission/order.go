@@ -0,0 +1,159 @@
+package commission
+
+import (
+	"errors"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+// referrerRates 推荐人的分佣比例及其来源
+type referrerRates struct {
+	Direct    float64
+	Team      float64
+	LevelID   int64
+	PackageID int64
+}
+
+// CreateOrderCommissionsTx 订单完成时在调用方事务内生成佣金：按推荐闭包表取下单用户的直接推荐人（depth=1）
+// 与其上级（depth=2），直推比例取直接推荐人的合伙人等级（优先）或生效会员套餐，团队奖比例取上级的对应配置；
+// 逐个订单明细计算（优惠券与积分抵扣按金额比例分摊），冻结 holdDays 天后由解冻任务置为可提现。
+// 已生成过佣金的明细跳过，重复调用不会重复计提；并发计提由 (order_item_id, commission_type) 唯一索引兜底。
+// 返回新生成的佣金记录数
+func CreateOrderCommissionsTx(tx *gorm.DB, order *model.Order, holdDays int) (int, error) {
+	var ancestors []model.ReferralClosure
+	if err := tx.Where("descendant_user_id = ? AND depth IN ?", order.UserID, []int{1, 2}).
+		Find(&ancestors).Error; err != nil {
+		return 0, err
+	}
+	var directID, indirectID uint
+	for _, a := range ancestors {
+		if a.AncestorUserID == order.UserID {
+			continue
+		}
+		if a.Depth == 1 {
+			directID = a.AncestorUserID
+		} else {
+			indirectID = a.AncestorUserID
+		}
+	}
+	if directID == 0 {
+		return 0, nil
+	}
+	direct, err := loadReferrerRates(tx, directID)
+	if err != nil || direct.Direct <= 0 {
+		return 0, err
+	}
+	var team referrerRates
+	if indirectID != 0 {
+		if team, err = loadReferrerRates(tx, indirectID); err != nil {
+			return 0, err
+		}
+		if team.Team <= 0 {
+			indirectID = 0
+		}
+	}
+
+	var items []model.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Order("id ASC").Find(&items).Error; err != nil {
+		return 0, err
+	}
+	var totalCents int64
+	for _, it := range items {
+		totalCents += toCents(it.Amount)
+	}
+	if totalCents <= 0 {
+		return 0, nil
+	}
+	// 订单级优惠（优惠券与积分抵扣）按明细金额比例分摊，尾差计入最后一项
+	deduction := toCents(order.DiscountAmount) + toCents(order.PointsDeduction)
+	if deduction > totalCents {
+		deduction = totalCents
+	}
+
+	created := 0
+	var allocated int64
+	for i, it := range items {
+		itemCents := toCents(it.Amount)
+		share := deduction * itemCents / totalCents
+		if i == len(items)-1 {
+			share = deduction - allocated
+		}
+		allocated += share
+
+		var exists int64
+		if err := tx.Model(&model.Commission{}).
+			Where("order_item_id = ? AND commission_type IN ?", it.ID, []string{"direct", "indirect"}).
+			Count(&exists).Error; err != nil {
+			return created, err
+		}
+		if exists > 0 {
+			continue
+		}
+		recs := BuildCommissionRecords(Order{ID: int64(order.ID), UserID: int64(order.UserID), TotalAmount: itemCents, DiscountAmount: share},
+			int64(directID), int64(indirectID), direct.Direct, team.Team, holdDays)
+		out := recs[:0]
+		for _, r := range recs {
+			if r.GrossAmount <= 0 {
+				continue
+			}
+			r.OrderItemID = int64(it.ID)
+			src := direct
+			if r.CommissionType == "indirect" {
+				src = team
+			}
+			r.LevelID, r.PackageID = src.LevelID, src.PackageID
+			out = append(out, r)
+		}
+		if err := SaveCommissionRecordsTx(tx, out); err != nil {
+			return created, err
+		}
+		created += len(out)
+	}
+	return created, nil
+}
+
+// loadReferrerRates 推荐人的分佣比例：设置了合伙人等级（users.partner_level_id）时取等级比例，否则取当前生效的会员套餐
+func loadReferrerRates(tx *gorm.DB, userID uint) (referrerRates, error) {
+	var out referrerRates
+	var row struct{ PartnerLevelID *uint }
+	if err := tx.Table("users").Select("partner_level_id").Where("id = ?", userID).Scan(&row).Error; err != nil {
+		return out, err
+	}
+	if row.PartnerLevelID != nil && *row.PartnerLevelID > 0 {
+		var lv model.PartnerLevel
+		err := tx.First(&lv, *row.PartnerLevelID).Error
+		if err == nil {
+			out.Direct = lv.DirectCommissionRate.InexactFloat64()
+			out.Team = lv.TeamCommissionRate.InexactFloat64()
+			out.LevelID = int64(lv.ID)
+			return out, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return out, err
+		}
+	}
+	var ids []uint
+	if err := tx.Table("user_memberships").Where("user_id = ? AND status = 'active'", userID).
+		Order("started_at DESC").Limit(1).Pluck("package_id", &ids).Error; err != nil {
+		return out, err
+	}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	var pkg model.MembershipPackage
+	if err := tx.First(&pkg, ids[0]).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return out, nil
+		}
+		return out, err
+	}
+	out.Direct = pkg.DirectCommissionRate.InexactFloat64()
+	out.Team = pkg.TeamCommissionRate.InexactFloat64()
+	out.PackageID = int64(pkg.ID)
+	return out, nil
+}
+
+func toCents(d decimal.Decimal) int64 { return d.Mul(decimal.NewFromInt(100)).Round(0).IntPart() }
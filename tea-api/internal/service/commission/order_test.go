@@ -0,0 +1,82 @@
+package commission
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+func TestCreateOrderCommissionsTx(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:order_commission?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.ReferralClosure{}, &model.PartnerLevel{}, &model.MembershipPackage{},
+		&model.OrderItem{}, &model.Commission{}, &model.CommissionTransaction{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	db.Exec("ALTER TABLE users ADD COLUMN partner_level_id integer")
+	db.Exec("CREATE TABLE user_memberships (id integer primary key, user_id integer, package_id integer, status text, started_at datetime)")
+
+	// 上级 1 持有会员套餐（团队奖 10%），推荐人 2 为合伙人等级（直推 20%），买家 3
+	pkg := model.MembershipPackage{Name: "会员", Price: decimal.NewFromInt(99), TeamCommissionRate: decimal.RequireFromString("0.10")}
+	db.Create(&pkg)
+	level := model.PartnerLevel{Name: "合伙人", DirectCommissionRate: decimal.RequireFromString("0.20")}
+	db.Create(&level)
+	for i := 1; i <= 3; i++ {
+		db.Create(&model.User{BaseModel: model.BaseModel{ID: uint(i)}, OpenID: "oc-" + string(rune('0'+i)), Phone: "1390000000" + string(rune('0'+i))})
+	}
+	db.Exec("UPDATE users SET partner_level_id = ? WHERE id = 2", level.ID)
+	db.Exec("INSERT INTO user_memberships (user_id, package_id, status, started_at) VALUES (1, ?, 'active', ?)", pkg.ID, time.Now())
+	db.Create(&model.ReferralClosure{AncestorUserID: 2, DescendantUserID: 3, Depth: 1})
+	db.Create(&model.ReferralClosure{AncestorUserID: 1, DescendantUserID: 3, Depth: 2})
+
+	order := model.Order{BaseModel: model.BaseModel{ID: 501}, UserID: 3, DiscountAmount: decimal.NewFromInt(10)}
+	db.Create(&model.OrderItem{OrderID: 501, ProductID: 1, ProductName: "红茶", Price: decimal.NewFromInt(60), Quantity: 1, Amount: decimal.NewFromInt(60)})
+	db.Create(&model.OrderItem{OrderID: 501, ProductID: 2, ProductName: "绿茶", Price: decimal.NewFromInt(40), Quantity: 1, Amount: decimal.NewFromInt(40)})
+
+	n, err := CreateOrderCommissionsTx(db, &order, 7)
+	if err != nil || n != 4 {
+		t.Fatalf("expected 4 commissions, got %d err=%v", n, err)
+	}
+	// 重复调用不重复计提
+	if n, err := CreateOrderCommissionsTx(db, &order, 7); err != nil || n != 0 {
+		t.Fatalf("repeat call should create nothing, got %d err=%v", n, err)
+	}
+
+	var list []model.Commission
+	db.Order("id").Find(&list)
+	// 并发计提由唯一索引兜底：同一明细同类佣金不可重复写入
+	dup := list[0]
+	dup.ID = 0
+	if err := db.Create(&dup).Error; err == nil {
+		t.Fatalf("duplicate commission for the same order item and type should be rejected")
+	}
+	// 优惠 10 元按 60:40 分摊：基数 54 / 36 元，直推 20%，团队奖为直推的 10%
+	want := []struct {
+		user  uint
+		typ   string
+		gross string
+	}{{2, "direct", "10.8"}, {1, "indirect", "1.08"}, {2, "direct", "7.2"}, {1, "indirect", "0.72"}}
+	if len(list) != len(want) {
+		t.Fatalf("expected %d commissions, got %d", len(want), len(list))
+	}
+	for i, w := range want {
+		cm := list[i]
+		if cm.UserID != w.user || cm.CommissionType != w.typ || !cm.GrossAmount.Equal(decimal.RequireFromString(w.gross)) {
+			t.Fatalf("commission %d = user %d %s %s, want %+v", i, cm.UserID, cm.CommissionType, cm.GrossAmount, w)
+		}
+		if cm.Status != StatusFrozen || cm.AvailableAt == nil || time.Until(*cm.AvailableAt) < 6*24*time.Hour || cm.OrderItemID == nil {
+			t.Fatalf("commission %d should be frozen for the hold period with its order item: %+v", i, cm)
+		}
+	}
+	if list[0].LevelID == nil || *list[0].LevelID != level.ID || list[1].PackageID == nil || *list[1].PackageID != pkg.ID {
+		t.Fatalf("rate sources should be recorded: %+v / %+v", list[0], list[1])
+	}
+}
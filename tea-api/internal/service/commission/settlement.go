@@ -9,6 +9,7 @@ import (
 	"gorm.io/gorm/clause"
 
 	"tea-api/internal/model"
+	"tea-api/internal/service/ledger"
 	"tea-api/pkg/database"
 )
 
@@ -129,6 +130,10 @@ func MarkCommissionWithdrawn(commissionIDs []uint, externalTxnID string, operato
 			tx.Rollback()
 			return processed, err
 		}
+		if err := postCommissionTx(tx, &cm, LedgerEventWithdraw, ledger.ProviderClearing("bank"), false); err != nil {
+			tx.Rollback()
+			return processed, err
+		}
 		processed++
 	}
 
@@ -203,34 +208,47 @@ func ConsumeUserAvailableCommissions(userID uint, targetAmount decimal.Decimal,
 //   - 将其状态置为 reversed，并记录一条 adjust 流水，便于审计；
 //   - 已提现（paid）的佣金不做自动回滚，需走人工财务调整。
 func ReverseOrderCommissions(orderID uint, operatorID *uint, note string) (int, error) {
-	if orderID == 0 {
-		return 0, errors.New("orderID cannot be zero")
-	}
-
 	db, err := requireDB()
 	if err != nil {
 		return 0, err
 	}
+	processed := 0
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		processed, err = ReverseOrderCommissionsTx(tx, orderID, operatorID, note)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return processed, nil
+}
+
+// ReverseOrderCommissionsTx 在调用方事务内回滚订单未提现佣金，规则同 ReverseOrderCommissions；
+// 状态已被并发变更的记录跳过，不重复记流水与过账
+func ReverseOrderCommissionsTx(tx *gorm.DB, orderID uint, operatorID *uint, note string) (int, error) {
+	if orderID == 0 {
+		return 0, errors.New("orderID cannot be zero")
+	}
 
 	var list []model.Commission
-	if err := db.Where("order_id = ? AND status IN ?", orderID, []string{StatusFrozen, StatusAvailable}).
+	if err := tx.Where("order_id = ? AND status IN ?", orderID, []string{StatusFrozen, StatusAvailable}).
 		Order("id ASC").
 		Find(&list).Error; err != nil {
 		return 0, err
 	}
-	if len(list) == 0 {
-		return 0, nil
-	}
 
-	tx := db.Begin()
 	processed := 0
 	for _, cm := range list {
 		// 再次限定状态，避免并发状态变化
-		if err := tx.Model(&model.Commission{}).
+		res := tx.Model(&model.Commission{}).
 			Where("id = ? AND status IN ?", cm.ID, []string{StatusFrozen, StatusAvailable}).
-			Update("status", StatusReversed).Error; err != nil {
-			tx.Rollback()
-			return processed, err
+			Update("status", StatusReversed)
+		if res.Error != nil {
+			return processed, res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
 		}
 
 		adj := model.CommissionTransaction{
@@ -244,15 +262,13 @@ func ReverseOrderCommissions(orderID uint, operatorID *uint, note string) (int,
 			adj.OperatorID = operatorID
 		}
 		if err := tx.Create(&adj).Error; err != nil {
-			tx.Rollback()
+			return processed, err
+		}
+		if err := postCommissionTx(tx, &cm, LedgerEventReverse, ledger.PlatformExpense, false); err != nil {
 			return processed, err
 		}
 		processed++
 	}
-
-	if err := tx.Commit().Error; err != nil {
-		return processed, err
-	}
 	return processed, nil
 }
 
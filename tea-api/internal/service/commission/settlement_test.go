@@ -18,7 +18,8 @@ func setupCommissionTestDB(t *testing.T) *gorm.DB {
 	if err != nil {
 		t.Fatalf("open sqlite: %v", err)
 	}
-	if err := db.AutoMigrate(&model.Commission{}, &model.CommissionTransaction{}); err != nil {
+	if err := db.AutoMigrate(&model.Commission{}, &model.CommissionTransaction{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
 		t.Fatalf("auto migrate: %v", err)
 	}
 	database.DB = db
@@ -4,8 +4,10 @@ import (
 	"fmt"
 
 	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
 
 	"tea-api/internal/model"
+	"tea-api/internal/service/ledger"
 	"tea-api/pkg/database"
 )
 
@@ -14,14 +16,13 @@ func SaveCommissionRecords(records []CommissionRecord) error {
 	if len(records) == 0 {
 		return nil
 	}
-	db := database.GetDB()
-	tx := db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		return SaveCommissionRecordsTx(tx, records)
+	})
+}
 
+// SaveCommissionRecordsTx 在调用方事务内持久化佣金记录：写入冻结状态的佣金、初始冻结流水并计提过账
+func SaveCommissionRecordsTx(tx *gorm.DB, records []CommissionRecord) error {
 	for _, r := range records {
 		cm := model.Commission{
 			UserID:         uint(r.UserID),
@@ -41,6 +42,14 @@ func SaveCommissionRecords(records []CommissionRecord) error {
 			su := uint(r.SourceUserID)
 			cm.SourceUserID = &su
 		}
+		if r.LevelID != 0 {
+			lv := uint(r.LevelID)
+			cm.LevelID = &lv
+		}
+		if r.PackageID != 0 {
+			pk := uint(r.PackageID)
+			cm.PackageID = &pk
+		}
 
 		// 货币从分转换为元（decimal）
 		cm.CalculationBasis = decimal.NewFromInt(r.CalculationBasis).Div(decimal.NewFromInt(100))
@@ -54,7 +63,6 @@ func SaveCommissionRecords(records []CommissionRecord) error {
 		}
 
 		if err := tx.Create(&cm).Error; err != nil {
-			tx.Rollback()
 			return fmt.Errorf("create commission failed: %w", err)
 		}
 
@@ -66,10 +74,11 @@ func SaveCommissionRecords(records []CommissionRecord) error {
 			BalanceAfter: cm.GrossAmount,
 		}
 		if err := tx.Create(&cTx).Error; err != nil {
-			tx.Rollback()
 			return fmt.Errorf("create commission tx failed: %w", err)
 		}
+		if err := postCommissionTx(tx, &cm, LedgerEventAccrue, ledger.PlatformExpense, true); err != nil {
+			return fmt.Errorf("post commission ledger failed: %w", err)
+		}
 	}
-
-	return tx.Commit().Error
+	return nil
 }
@@ -0,0 +1,574 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+	"tea-api/internal/service/ledger"
+	"tea-api/pkg/database"
+	"tea-api/pkg/utils"
+)
+
+// WalletTxGiftCard 礼品卡兑换入账的钱包流水类型
+const WalletTxGiftCard = "gift_card_redeem"
+
+const (
+	giftCardNoPrefix = "88"
+	giftCardNoLen    = 16 // 前缀 + 随机数字 + Luhn 校验位
+	giftCardPinLen   = 8
+)
+
+var (
+	// ErrGiftCardInvalid 卡号或卡密错误（不区分卡号不存在与卡密错误，避免枚举卡号）
+	ErrGiftCardInvalid = errors.New("卡号或卡密错误")
+	// ErrGiftCardLocked 卡密错误次数达到上限
+	ErrGiftCardLocked = errors.New("卡密错误次数过多，请联系客服")
+)
+
+type GiftCardService struct {
+	db *gorm.DB
+}
+
+func NewGiftCardService() *GiftCardService {
+	return &GiftCardService{db: database.GetDB()}
+}
+
+// GiftCardBatchInput 制卡参数
+type GiftCardBatchInput struct {
+	Name           string     `json:"name"`
+	FaceValueCents int64      `json:"face_value_cents"`
+	Quantity       int        `json:"quantity"`
+	StoreID        uint       `json:"store_id"` // 0 表示平台通用
+	ValidFrom      *time.Time `json:"valid_from"`
+	ValidUntil     *time.Time `json:"valid_until"`
+	Remark         string     `json:"remark"`
+}
+
+// GiftCardExportRow 制卡导出行（含明文卡密）
+type GiftCardExportRow struct {
+	CardNo         string
+	Pin            string
+	FaceValueCents int64
+	Status         int
+}
+
+// GiftCardInfo 凭卡号卡密查询到的卡面信息
+type GiftCardInfo struct {
+	CardNo         string     `json:"card_no"`
+	FaceValueCents int64      `json:"face_value_cents"`
+	BalanceCents   int64      `json:"balance_cents"`
+	Status         int        `json:"status"`
+	StoreID        uint       `json:"store_id"`
+	ValidFrom      *time.Time `json:"valid_from"`
+	ValidUntil     *time.Time `json:"valid_until"`
+	RedeemedAt     *time.Time `json:"redeemed_at"`
+}
+
+// GiftCardRedeemResult 兑换结果
+type GiftCardRedeemResult struct {
+	CardNo        string `json:"card_no"`
+	AmountCents   int64  `json:"amount_cents"`
+	WalletBalance int64  `json:"wallet_balance_cents"`
+}
+
+// CreateBatch 创建礼品卡批次并生成卡号与卡密；卡密仅保存摘要与密文，明文通过 ExportBatch 导出制卡
+func (s *GiftCardService) CreateBatch(in GiftCardBatchInput, operatorID uint) (*model.GiftCardBatch, error) {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return nil, errors.New("批次名称不能为空")
+	}
+	if in.FaceValueCents <= 0 {
+		return nil, errors.New("面值必须大于0")
+	}
+	maxSize := config.Config.Finance.GiftCard.MaxBatchSize
+	if maxSize <= 0 {
+		maxSize = 5000
+	}
+	if in.Quantity <= 0 || in.Quantity > maxSize {
+		return nil, fmt.Errorf("制卡数量须在 1-%d 之间", maxSize)
+	}
+	if in.ValidFrom != nil && in.ValidUntil != nil && !in.ValidUntil.After(*in.ValidFrom) {
+		return nil, errors.New("有效期结束时间须晚于开始时间")
+	}
+	if in.StoreID > 0 {
+		var n int64
+		if err := s.db.Model(&model.Store{}).Where("id = ?", in.StoreID).Count(&n).Error; err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, errors.New("门店不存在")
+		}
+	}
+	secret, err := giftCardSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &model.GiftCardBatch{
+		BatchNo:        generateOrderNo("GC"),
+		Name:           in.Name,
+		FaceValueCents: in.FaceValueCents,
+		Quantity:       in.Quantity,
+		StoreID:        in.StoreID,
+		ValidFrom:      in.ValidFrom,
+		ValidUntil:     in.ValidUntil,
+		Status:         model.GiftCardBatchActive,
+		Remark:         strings.TrimSpace(in.Remark),
+	}
+	batch.CreatedBy = operatorID
+	cards := make([]model.GiftCard, 0, in.Quantity)
+	seen := make(map[string]struct{}, in.Quantity)
+	for len(cards) < in.Quantity {
+		no := generateGiftCardNo()
+		if _, dup := seen[no]; dup {
+			continue
+		}
+		seen[no] = struct{}{}
+		pin := utils.GenerateRandomCode(giftCardPinLen)
+		sealed, err := sealGiftCardPin(secret, pin)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, model.GiftCard{
+			CardNo:         no,
+			PinHash:        giftCardPinHash(secret, no, pin),
+			PinCipher:      sealed,
+			FaceValueCents: in.FaceValueCents,
+			BalanceCents:   in.FaceValueCents,
+			StoreID:        in.StoreID,
+			Status:         model.GiftCardStatusActive,
+		})
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(batch).Error; err != nil {
+			return err
+		}
+		for i := range cards {
+			cards[i].BatchID = batch.ID
+			cards[i].CreatedBy = operatorID
+		}
+		if err := tx.CreateInBatches(cards, 200).Error; err != nil {
+			// 卡号随机空间足够大，唯一索引冲突时整批回滚由管理员重试
+			return fmt.Errorf("生成卡号失败，请重试: %w", err)
+		}
+		txs := make([]model.GiftCardTransaction, 0, len(cards))
+		for _, c := range cards {
+			txs = append(txs, model.GiftCardTransaction{
+				CardID: c.ID, BatchID: batch.ID, Type: model.GiftCardTxIssue,
+				AmountCents: c.FaceValueCents, BalanceAfter: c.BalanceCents,
+				OperatorID: giftCardOperator(operatorID), Remark: "制卡",
+			})
+		}
+		return tx.CreateInBatches(txs, 200).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// ExportBatch 解密批次卡密用于制卡印刷，累计导出次数；已作废批次不可导出
+func (s *GiftCardService) ExportBatch(batchID uint) (*model.GiftCardBatch, []GiftCardExportRow, error) {
+	var batch model.GiftCardBatch
+	if err := s.db.First(&batch, batchID).Error; err != nil {
+		return nil, nil, errors.New("礼品卡批次不存在")
+	}
+	if batch.Status == model.GiftCardBatchVoided {
+		return nil, nil, errors.New("批次已作废，不可导出")
+	}
+	secret, err := giftCardSecret()
+	if err != nil {
+		return nil, nil, err
+	}
+	var cards []model.GiftCard
+	if err := s.db.Where("batch_id = ?", batchID).Order("id asc").Find(&cards).Error; err != nil {
+		return nil, nil, err
+	}
+	rows := make([]GiftCardExportRow, 0, len(cards))
+	for _, c := range cards {
+		pin, err := openGiftCardPin(secret, c.PinCipher)
+		if err != nil {
+			return nil, nil, fmt.Errorf("卡号%s卡密解密失败: %w", c.CardNo, err)
+		}
+		rows = append(rows, GiftCardExportRow{CardNo: c.CardNo, Pin: pin, FaceValueCents: c.FaceValueCents, Status: c.Status})
+	}
+	now := time.Now()
+	if err := s.db.Model(&batch).Updates(map[string]any{
+		"export_count": gorm.Expr("export_count + 1"),
+		"exported_at":  now,
+	}).Error; err != nil {
+		return nil, nil, err
+	}
+	batch.ExportCount++
+	batch.ExportedAt = &now
+	return &batch, rows, nil
+}
+
+// FreezeBatch 冻结批次：未兑换的卡暂停兑换
+func (s *GiftCardService) FreezeBatch(batchID, operatorID uint, remark string) (int, error) {
+	return s.changeBatchStatus(batchID, operatorID, remark,
+		[]int{model.GiftCardBatchActive}, model.GiftCardBatchFrozen,
+		[]int{model.GiftCardStatusActive}, model.GiftCardStatusFrozen, model.GiftCardTxFreeze)
+}
+
+// UnfreezeBatch 解冻批次：随批次冻结的卡恢复可兑换
+func (s *GiftCardService) UnfreezeBatch(batchID, operatorID uint, remark string) (int, error) {
+	return s.changeBatchStatus(batchID, operatorID, remark,
+		[]int{model.GiftCardBatchFrozen}, model.GiftCardBatchActive,
+		[]int{model.GiftCardStatusFrozen}, model.GiftCardStatusActive, model.GiftCardTxUnfreeze)
+}
+
+// VoidBatch 作废批次：未兑换的卡永久失效，已兑换入钱包的金额不追回
+func (s *GiftCardService) VoidBatch(batchID, operatorID uint, remark string) (int, error) {
+	return s.changeBatchStatus(batchID, operatorID, remark,
+		[]int{model.GiftCardBatchActive, model.GiftCardBatchFrozen}, model.GiftCardBatchVoided,
+		[]int{model.GiftCardStatusActive, model.GiftCardStatusFrozen}, model.GiftCardStatusVoided, model.GiftCardTxVoid)
+}
+
+// changeBatchStatus 批次状态流转并同步批次内卡片状态，每张受影响的卡记一条流水，返回受影响卡数
+func (s *GiftCardService) changeBatchStatus(batchID, operatorID uint, remark string,
+	fromBatch []int, toBatch int, fromCard []int, toCard int, txType string) (int, error) {
+	affected := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.GiftCardBatch{}).Where("id = ? AND status IN ?", batchID, fromBatch).Update("status", toBatch)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			var n int64
+			if err := tx.Model(&model.GiftCardBatch{}).Where("id = ?", batchID).Count(&n).Error; err != nil {
+				return err
+			}
+			if n == 0 {
+				return errors.New("礼品卡批次不存在")
+			}
+			return errors.New("当前批次状态不允许该操作")
+		}
+		var cards []model.GiftCard
+		if err := tx.Select("id", "balance_cents").Where("batch_id = ? AND status IN ?", batchID, fromCard).
+			Find(&cards).Error; err != nil {
+			return err
+		}
+		if len(cards) == 0 {
+			return nil
+		}
+		ids := make([]uint, 0, len(cards))
+		for _, c := range cards {
+			ids = append(ids, c.ID)
+		}
+		updates := map[string]any{"status": toCard}
+		if toCard == model.GiftCardStatusVoided {
+			updates["balance_cents"] = 0
+		}
+		if err := tx.Model(&model.GiftCard{}).Where("id IN ? AND status IN ?", ids, fromCard).Updates(updates).Error; err != nil {
+			return err
+		}
+		txs := make([]model.GiftCardTransaction, 0, len(cards))
+		for _, c := range cards {
+			row := model.GiftCardTransaction{CardID: c.ID, BatchID: batchID, Type: txType, BalanceAfter: c.BalanceCents,
+				OperatorID: giftCardOperator(operatorID), Remark: truncateText(remark, 255)}
+			if toCard == model.GiftCardStatusVoided {
+				row.AmountCents, row.BalanceAfter = -c.BalanceCents, 0
+			}
+			txs = append(txs, row)
+		}
+		affected = len(cards)
+		return tx.CreateInBatches(txs, 200).Error
+	})
+	return affected, err
+}
+
+// Lookup 凭卡号卡密查询卡面信息（计入卡密错误次数）
+func (s *GiftCardService) Lookup(cardNo, pin string) (*GiftCardInfo, error) {
+	card, batch, err := s.verify(cardNo, pin)
+	if err != nil {
+		return nil, err
+	}
+	return &GiftCardInfo{
+		CardNo:         card.CardNo,
+		FaceValueCents: card.FaceValueCents,
+		BalanceCents:   card.BalanceCents,
+		Status:         card.Status,
+		StoreID:        card.StoreID,
+		ValidFrom:      batch.ValidFrom,
+		ValidUntil:     batch.ValidUntil,
+		RedeemedAt:     card.RedeemedAt,
+	}, nil
+}
+
+// Redeem 兑换礼品卡：卡内余额一次性转入用户钱包。
+// 门店售出的卡冲减该门店待结算款（售卡款已由门店收取），平台通用卡记礼品卡售卡款科目。
+func (s *GiftCardService) Redeem(userID uint, cardNo, pin string) (*GiftCardRedeemResult, error) {
+	if userID == 0 {
+		return nil, errors.New("用户未登录")
+	}
+	card, batch, err := s.verify(cardNo, pin)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	switch card.Status {
+	case model.GiftCardStatusRedeemed:
+		return nil, errors.New("礼品卡已兑换")
+	case model.GiftCardStatusFrozen:
+		return nil, errors.New("礼品卡已冻结，请联系客服")
+	case model.GiftCardStatusVoided:
+		return nil, errors.New("礼品卡已作废")
+	}
+	if batch.ValidFrom != nil && now.Before(*batch.ValidFrom) {
+		return nil, errors.New("礼品卡尚未到可兑换时间")
+	}
+	if batch.ValidUntil != nil && now.After(*batch.ValidUntil) {
+		return nil, errors.New("礼品卡已过期")
+	}
+
+	res := &GiftCardRedeemResult{CardNo: card.CardNo, AmountCents: card.BalanceCents}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// 条件更新防止并发重复兑换，以及与批次冻结/作废并发
+		upd := tx.Model(&model.GiftCard{}).Where("id = ? AND status = ?", card.ID, model.GiftCardStatusActive).
+			Updates(map[string]any{"status": model.GiftCardStatusRedeemed, "balance_cents": 0, "redeemed_by": userID, "redeemed_at": now})
+		if upd.Error != nil {
+			return upd.Error
+		}
+		if upd.RowsAffected == 0 {
+			return errors.New("礼品卡已兑换或不可用")
+		}
+		counter := ledger.GiftCard
+		if card.StoreID > 0 {
+			counter = ledger.Store(card.StoreID)
+		}
+		wtx, err := walletChange(tx, userID, card.BalanceCents, WalletTxGiftCard, fmt.Sprintf("礼品卡%s兑换", maskGiftCardNo(card.CardNo)), counter)
+		if err != nil {
+			return err
+		}
+		uid := userID
+		if err := tx.Create(&model.GiftCardTransaction{
+			CardID: card.ID, BatchID: card.BatchID, UserID: &uid, Type: model.GiftCardTxRedeem,
+			AmountCents: -card.BalanceCents, BalanceAfter: 0, WalletTxID: &wtx.ID, Remark: "兑换入钱包",
+		}).Error; err != nil {
+			return err
+		}
+		if wtx.BalanceAfter != nil {
+			res.WalletBalance = *wtx.BalanceAfter
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// verify 校验卡号卡密：错误时累计失败次数，达到上限后锁定；校验通过清零失败次数
+func (s *GiftCardService) verify(cardNo, pin string) (*model.GiftCard, *model.GiftCardBatch, error) {
+	cardNo = normalizeGiftCardInput(cardNo)
+	pin = normalizeGiftCardInput(pin)
+	if cardNo == "" || pin == "" {
+		return nil, nil, ErrGiftCardInvalid
+	}
+	secret, err := giftCardSecret()
+	if err != nil {
+		return nil, nil, err
+	}
+	var card model.GiftCard
+	if err := s.db.Where("card_no = ?", cardNo).Limit(1).Find(&card).Error; err != nil {
+		return nil, nil, err
+	}
+	if card.ID == 0 {
+		return nil, nil, ErrGiftCardInvalid
+	}
+	maxFailures := config.Config.Finance.GiftCard.MaxPinFailures
+	if maxFailures <= 0 {
+		maxFailures = 5
+	}
+	if card.PinFailures >= maxFailures {
+		return nil, nil, ErrGiftCardLocked
+	}
+	if !hmac.Equal([]byte(giftCardPinHash(secret, cardNo, pin)), []byte(card.PinHash)) {
+		if err := s.db.Model(&model.GiftCard{}).Where("id = ?", card.ID).
+			Update("pin_failures", gorm.Expr("pin_failures + 1")).Error; err != nil {
+			return nil, nil, err
+		}
+		if card.PinFailures+1 >= maxFailures {
+			return nil, nil, ErrGiftCardLocked
+		}
+		return nil, nil, ErrGiftCardInvalid
+	}
+	if card.PinFailures > 0 {
+		if err := s.db.Model(&model.GiftCard{}).Where("id = ?", card.ID).Update("pin_failures", 0).Error; err != nil {
+			return nil, nil, err
+		}
+		card.PinFailures = 0
+	}
+	var batch model.GiftCardBatch
+	if err := s.db.First(&batch, card.BatchID).Error; err != nil {
+		return nil, nil, err
+	}
+	return &card, &batch, nil
+}
+
+// ResetPinFailures 管理员核实持卡人后解除卡密错误锁定
+func (s *GiftCardService) ResetPinFailures(cardID uint) error {
+	res := s.db.Model(&model.GiftCard{}).Where("id = ?", cardID).Update("pin_failures", 0)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("礼品卡不存在")
+	}
+	return nil
+}
+
+// ListUserCards 用户已兑换的礼品卡（分页）
+func (s *GiftCardService) ListUserCards(userID uint, page, limit int) ([]model.GiftCard, int64, error) {
+	q := s.db.Model(&model.GiftCard{}).Where("redeemed_by = ?", userID)
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []model.GiftCard
+	if err := q.Order("redeemed_at DESC").Limit(limit).Offset((page - 1) * limit).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// CardTransactions 礼品卡流水（按时间正序）
+func (s *GiftCardService) CardTransactions(cardID uint) ([]model.GiftCardTransaction, error) {
+	var list []model.GiftCardTransaction
+	err := s.db.Where("card_id = ?", cardID).Order("id asc").Find(&list).Error
+	return list, err
+}
+
+// BatchStatusCounts 批次内各状态卡数
+func (s *GiftCardService) BatchStatusCounts(batchID uint) (map[int]int64, error) {
+	var rows []struct {
+		Status int
+		N      int64
+	}
+	if err := s.db.Model(&model.GiftCard{}).Select("status, COUNT(*) AS n").
+		Where("batch_id = ?", batchID).Group("status").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[int]int64, len(rows))
+	for _, r := range rows {
+		out[r.Status] = r.N
+	}
+	return out, nil
+}
+
+func giftCardOperator(operatorID uint) *uint {
+	if operatorID == 0 {
+		return nil
+	}
+	return &operatorID
+}
+
+func giftCardSecret() ([]byte, error) {
+	secret := config.Config.Finance.GiftCard.PinSecret
+	if secret == "" {
+		secret = config.Config.JWT.Secret
+	}
+	if secret == "" {
+		return nil, errors.New("未配置礼品卡卡密密钥")
+	}
+	return []byte(secret), nil
+}
+
+// giftCardPinHash 卡密摘要：HMAC-SHA256(secret, 卡号:卡密)，同一卡密在不同卡上摘要不同
+func giftCardPinHash(secret []byte, cardNo, pin string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(cardNo + ":" + pin))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func giftCardCipher(secret []byte) (cipher.AEAD, error) {
+	key := sha256.Sum256(append([]byte("gift_card_pin:"), secret...))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealGiftCardPin AES-GCM 加密卡密，输出 base64(nonce|密文)
+func sealGiftCardPin(secret []byte, pin string) (string, error) {
+	gcm, err := giftCardCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(pin), nil)), nil
+}
+
+func openGiftCardPin(secret []byte, sealed string) (string, error) {
+	gcm, err := giftCardCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < gcm.NonceSize() {
+		return "", errors.New("卡密密文格式错误")
+	}
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// generateGiftCardNo 生成 16 位数字卡号，末位为 Luhn 校验位，便于在输入阶段拦截错号
+func generateGiftCardNo() string {
+	body := giftCardNoPrefix + utils.GenerateRandomCode(giftCardNoLen-len(giftCardNoPrefix)-1)
+	return body + luhnCheckDigit(body)
+}
+
+func luhnCheckDigit(body string) string {
+	sum := 0
+	double := true
+	for i := len(body) - 1; i >= 0; i-- {
+		d := int(body[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return fmt.Sprintf("%d", (10-sum%10)%10)
+}
+
+// normalizeGiftCardInput 去除用户输入中的空格与连字符
+func normalizeGiftCardInput(s string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(s))
+}
+
+// maskGiftCardNo 卡号脱敏：保留前 4 位与后 4 位
+func maskGiftCardNo(no string) string {
+	if len(no) <= 8 {
+		return no
+	}
+	return no[:4] + strings.Repeat("*", len(no)-8) + no[len(no)-4:]
+}
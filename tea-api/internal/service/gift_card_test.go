@@ -0,0 +1,135 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+	"tea-api/internal/service/ledger"
+)
+
+func TestGiftCard_BatchRedeemAndLifecycle(t *testing.T) {
+	db := setupLedgerFlowDB(t, "gift_card")
+	if err := db.AutoMigrate(&model.Store{}, &model.GiftCardBatch{}, &model.GiftCard{}, &model.GiftCardTransaction{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	old := config.Config.Finance.GiftCard
+	config.Config.Finance.GiftCard = config.GiftCard{PinSecret: "gift_secret", MaxPinFailures: 3, MaxBatchSize: 100}
+	defer func() { config.Config.Finance.GiftCard = old }()
+
+	svc := &GiftCardService{db: db}
+	user := model.User{Phone: "13800000041", Nickname: "gift", OpenID: "openid-gift"}
+	db.Create(&user)
+	store := model.Store{Name: "西湖店"}
+	db.Create(&store)
+
+	if _, err := svc.CreateBatch(GiftCardBatchInput{Name: "超量", FaceValueCents: 100, Quantity: 101}, 1); err == nil {
+		t.Fatalf("quantity above max batch size should be rejected")
+	}
+	batch, err := svc.CreateBatch(GiftCardBatchInput{Name: "中秋卡", FaceValueCents: 20000, Quantity: 3}, 1)
+	if err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+	var stored model.GiftCard
+	db.Where("batch_id = ?", batch.ID).First(&stored)
+	if len(stored.CardNo) != 16 || luhnCheckDigit(stored.CardNo[:15]) != stored.CardNo[15:] || stored.PinHash == "" {
+		t.Fatalf("unexpected card: %+v", stored)
+	}
+
+	exported, rows, err := svc.ExportBatch(batch.ID)
+	if err != nil || len(rows) != 3 || exported.ExportCount != 1 {
+		t.Fatalf("export: rows=%d batch=%+v err=%v", len(rows), exported, err)
+	}
+	if rows[0].Pin == "" || rows[0].Pin == stored.PinCipher {
+		t.Fatalf("export should decrypt pin")
+	}
+
+	// 卡密错误累计到上限后锁定，正确卡密也无法使用
+	locked := rows[2]
+	for i := 0; i < 2; i++ {
+		if _, err := svc.Lookup(locked.CardNo, "00000000"); !errors.Is(err, ErrGiftCardInvalid) {
+			t.Fatalf("wrong pin should be rejected, got %v", err)
+		}
+	}
+	if _, err := svc.Lookup(locked.CardNo, "00000000"); !errors.Is(err, ErrGiftCardLocked) {
+		t.Fatalf("third failure should lock the card, got %v", err)
+	}
+	if _, err := svc.Redeem(user.ID, locked.CardNo, locked.Pin); !errors.Is(err, ErrGiftCardLocked) {
+		t.Fatalf("locked card should not redeem, got %v", err)
+	}
+
+	// 兑换入钱包并记礼品卡科目，重复兑换被拒绝
+	res, err := svc.Redeem(user.ID, rows[0].CardNo[:4]+" "+rows[0].CardNo[4:], rows[0].Pin)
+	if err != nil {
+		t.Fatalf("redeem: %v", err)
+	}
+	if res.AmountCents != 20000 || res.WalletBalance != 20000 || walletBalance(t, db, user.ID) != 20000 {
+		t.Fatalf("wallet should be credited: %+v", res)
+	}
+	if got := ledgerBalance(t, db, ledger.GiftCard); got != 20000 {
+		t.Fatalf("gift card account should be debited 200 yuan, got %d", got)
+	}
+	if _, err := svc.Redeem(user.ID, rows[0].CardNo, rows[0].Pin); err == nil {
+		t.Fatalf("card should not redeem twice")
+	}
+	info, err := svc.Lookup(rows[0].CardNo, rows[0].Pin)
+	if err != nil || info.Status != model.GiftCardStatusRedeemed || info.BalanceCents != 0 {
+		t.Fatalf("lookup after redeem: %+v err=%v", info, err)
+	}
+
+	// 批次冻结后不可兑换，解冻后恢复；作废只影响未兑换的卡
+	if n, err := svc.FreezeBatch(batch.ID, 1, "挂失"); err != nil || n != 2 {
+		t.Fatalf("freeze: n=%d err=%v", n, err)
+	}
+	if _, err := svc.Redeem(user.ID, rows[1].CardNo, rows[1].Pin); err == nil {
+		t.Fatalf("frozen card should not redeem")
+	}
+	if _, err := svc.FreezeBatch(batch.ID, 1, ""); err == nil {
+		t.Fatalf("freezing a frozen batch should fail")
+	}
+	if n, err := svc.UnfreezeBatch(batch.ID, 1, ""); err != nil || n != 2 {
+		t.Fatalf("unfreeze: n=%d err=%v", n, err)
+	}
+	if n, err := svc.VoidBatch(batch.ID, 1, "停售"); err != nil || n != 2 {
+		t.Fatalf("void: n=%d err=%v", n, err)
+	}
+	if _, err := svc.Redeem(user.ID, rows[1].CardNo, rows[1].Pin); err == nil {
+		t.Fatalf("voided card should not redeem")
+	}
+	if _, _, err := svc.ExportBatch(batch.ID); err == nil {
+		t.Fatalf("voided batch should not export")
+	}
+	var redeemedCard model.GiftCard
+	db.Where("card_no = ?", rows[0].CardNo).First(&redeemedCard)
+	txs, _ := svc.CardTransactions(redeemedCard.ID)
+	if redeemedCard.Status != model.GiftCardStatusRedeemed || len(txs) != 2 || txs[1].Type != model.GiftCardTxRedeem || txs[1].WalletTxID == nil {
+		t.Fatalf("redeemed card should keep its status and have issue+redeem txs: %+v %+v", redeemedCard, txs)
+	}
+
+	// 门店售出的卡兑换时冲减门店待结算；过期卡不可兑换
+	storeBatch, err := svc.CreateBatch(GiftCardBatchInput{Name: "门店卡", FaceValueCents: 5000, Quantity: 1, StoreID: store.ID}, 1)
+	if err != nil {
+		t.Fatalf("store batch: %v", err)
+	}
+	_, storeRows, _ := svc.ExportBatch(storeBatch.ID)
+	if _, err := svc.Redeem(user.ID, storeRows[0].CardNo, storeRows[0].Pin); err != nil {
+		t.Fatalf("redeem store card: %v", err)
+	}
+	if got := ledgerBalance(t, db, ledger.Store(store.ID)); got != -5000 {
+		t.Fatalf("store settlement should be reduced by 50 yuan, got %d", got)
+	}
+	past := time.Now().Add(-time.Hour)
+	expired, err := svc.CreateBatch(GiftCardBatchInput{Name: "过期卡", FaceValueCents: 100, Quantity: 1, ValidUntil: &past}, 1)
+	if err != nil {
+		t.Fatalf("expired batch: %v", err)
+	}
+	_, expRows, _ := svc.ExportBatch(expired.ID)
+	if _, err := svc.Redeem(user.ID, expRows[0].CardNo, expRows[0].Pin); err == nil {
+		t.Fatalf("expired card should not redeem")
+	}
+	if walletBalance(t, db, user.ID) != 25000 {
+		t.Fatalf("wallet should hold both redeemed cards, got %d", walletBalance(t, db, user.ID))
+	}
+}
@@ -8,12 +8,16 @@ import (
 
 	"strings"
 	"tea-api/internal/model"
+	"tea-api/internal/service/ledger"
 	"tea-api/pkg/database"
 	"tea-api/pkg/utils"
 
 	"github.com/shopspring/decimal"
 )
 
+// WalletTxInterest 余额计息钱包流水类型
+const WalletTxInterest = "interest"
+
 type AccrualService struct {
 	db *gorm.DB
 }
@@ -81,8 +85,9 @@ func (s *AccrualService) accrueUser(u *model.User, date time.Time, rate float64)
 	principalAfter := principalBefore.Add(interest).Round(2)
 
 	return s.db.Transaction(func(tx *gorm.DB) error {
-		// 更新余额
-		if err := tx.Model(&model.User{}).Where("id = ?", u.ID).Update("balance", principalAfter).Error; err != nil {
+		// 利息记入用户钱包（借平台费用、贷用户余额），users.balance 由账本投影同步
+		if _, err := walletChange(tx, u.ID, yuanDecimalToCents(interest), WalletTxInterest,
+			"余额计息 "+date.Format("2006-01-02"), ledger.PlatformExpense); err != nil {
 			return err
 		}
 		// 写入记录
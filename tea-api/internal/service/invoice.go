@@ -0,0 +1,440 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+	"tea-api/pkg/database"
+)
+
+type InvoiceService struct {
+	db       *gorm.DB
+	provider InvoiceProvider
+}
+
+func NewInvoiceService() *InvoiceService {
+	p, err := NewInvoiceProvider()
+	if err != nil {
+		// 配置错误时退化为本地占位通道，避免阻断申请流程；审核开票时会记录实际通道
+		p = &LocalInvoiceProvider{OutputDir: "uploads/invoices"}
+	}
+	return &InvoiceService{db: database.GetDB(), provider: p}
+}
+
+// taxNoPattern 统一社会信用代码（18位）或旧版税号（15/17/20位）
+var taxNoPattern = regexp.MustCompile(`^[0-9A-Z]{15,20}$`)
+
+// validateInvoiceTitle 校验抬头：企业抬头必须填写合法税号
+func validateInvoiceTitle(t *model.InvoiceTitle) error {
+	t.Name = strings.TrimSpace(t.Name)
+	t.TaxNo = strings.ToUpper(strings.TrimSpace(t.TaxNo))
+	if t.Name == "" {
+		return errors.New("抬头名称不能为空")
+	}
+	switch t.TitleType {
+	case 1:
+		t.TaxNo = ""
+	case 2:
+		if !taxNoPattern.MatchString(t.TaxNo) {
+			return errors.New("企业抬头需填写正确的纳税人识别号")
+		}
+	default:
+		return errors.New("非法的抬头类型")
+	}
+	return nil
+}
+
+// ListTitles 列出用户发票抬头
+func (s *InvoiceService) ListTitles(userID uint) ([]model.InvoiceTitle, error) {
+	var list []model.InvoiceTitle
+	if err := s.db.Where("user_id = ?", userID).Order("is_default DESC, id DESC").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// SaveTitle 新建或更新用户发票抬头（ID 为 0 时新建）
+func (s *InvoiceService) SaveTitle(userID uint, t *model.InvoiceTitle) error {
+	if userID == 0 || t == nil {
+		return errors.New("参数错误")
+	}
+	if err := validateInvoiceTitle(t); err != nil {
+		return err
+	}
+	t.UserID = userID
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if t.ID != 0 {
+			var cnt int64
+			if err := tx.Model(&model.InvoiceTitle{}).Where("id = ? AND user_id = ?", t.ID, userID).Count(&cnt).Error; err != nil {
+				return err
+			}
+			if cnt == 0 {
+				return errors.New("发票抬头不存在")
+			}
+		}
+		if t.IsDefault {
+			if err := tx.Model(&model.InvoiceTitle{}).Where("user_id = ? AND id <> ?", userID, t.ID).
+				Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(t).Error
+	})
+}
+
+// DeleteTitle 删除用户发票抬头
+func (s *InvoiceService) DeleteTitle(userID, id uint) error {
+	res := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&model.InvoiceTitle{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("发票抬头不存在")
+	}
+	return nil
+}
+
+// ensureNotInvoiced 校验业务单据未处于有效的发票申请中（待审核/开具中/已开具/开具失败待重试）。
+// 调用方须已锁定业务单据行，否则并发申请可能各自通过校验
+func ensureNotInvoiced(tx *gorm.DB, sourceType string, sourceIDs []uint) error {
+	var cnt int64
+	if err := tx.Model(&model.InvoiceItem{}).
+		Joins("JOIN invoices ON invoices.id = invoice_items.invoice_id").
+		Where("invoice_items.source_type = ? AND invoice_items.source_id IN ? AND invoices.status IN ?",
+			sourceType, sourceIDs, []int{model.InvoiceStatusPending, model.InvoiceStatusIssuing, model.InvoiceStatusIssued, model.InvoiceStatusFailed}).
+		Where("invoices.deleted_at IS NULL").
+		Count(&cnt).Error; err != nil {
+		return err
+	}
+	if cnt > 0 {
+		return errors.New("所选单据已申请过发票")
+	}
+	return nil
+}
+
+// loadTitle 读取用户抬头并生成发票快照
+func loadTitle(tx *gorm.DB, userID, titleID uint) (*model.InvoiceTitle, error) {
+	var t model.InvoiceTitle
+	if err := tx.Where("id = ? AND user_id = ?", titleID, userID).First(&t).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("发票抬头不存在")
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+func newInvoiceFromTitle(userID uint, sourceType string, t *model.InvoiceTitle, email, remark string) *model.Invoice {
+	if strings.TrimSpace(email) == "" {
+		email = t.Email
+	}
+	return &model.Invoice{
+		RequestNo:  generateOrderNo("IV"),
+		UserID:     userID,
+		SourceType: sourceType,
+		TitleID:    t.ID,
+		TitleType:  t.TitleType,
+		TitleName:  t.Name,
+		TaxNo:      t.TaxNo,
+		Email:      strings.TrimSpace(email),
+		Status:     model.InvoiceStatusPending,
+		Remark:     remark,
+	}
+}
+
+// RequestForOrders 为一笔或多笔已完成订单申请发票（多笔订单合并开具一张）
+func (s *InvoiceService) RequestForOrders(userID, titleID uint, orderIDs []uint, email, remark string) (*model.Invoice, error) {
+	if userID == 0 || titleID == 0 || len(orderIDs) == 0 {
+		return nil, errors.New("参数错误")
+	}
+	if len(orderIDs) > 100 {
+		return nil, errors.New("单次最多合并 100 笔订单")
+	}
+	var inv *model.Invoice
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		t, err := loadTitle(tx, userID, titleID)
+		if err != nil {
+			return err
+		}
+		// 按 id 顺序锁定订单，同一订单的并发开票申请串行校验
+		var orders []model.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ? AND user_id = ?", orderIDs, userID).
+			Order("id").Find(&orders).Error; err != nil {
+			return err
+		}
+		if len(orders) != len(uniqueUints(orderIDs)) {
+			return errors.New("包含不存在或不属于当前用户的订单")
+		}
+		ids := make([]uint, 0, len(orders))
+		for _, o := range orders {
+			if o.Status != 4 || o.PayStatus != 2 {
+				return fmt.Errorf("订单 %s 未完成或已退款，暂不可开票", o.OrderNo)
+			}
+			if o.PayAmount.LessThanOrEqual(decimal.Zero) {
+				return fmt.Errorf("订单 %s 实付金额为0，无需开票", o.OrderNo)
+			}
+			ids = append(ids, o.ID)
+		}
+		if err := ensureNotInvoiced(tx, model.InvoiceSourceOrder, ids); err != nil {
+			return err
+		}
+
+		inv = newInvoiceFromTitle(userID, model.InvoiceSourceOrder, t, email, remark)
+		inv.Amount = decimal.Zero
+		for _, o := range orders {
+			inv.Amount = inv.Amount.Add(o.PayAmount)
+			inv.Items = append(inv.Items, model.InvoiceItem{
+				SourceType: model.InvoiceSourceOrder,
+				SourceID:   o.ID,
+				SourceNo:   o.OrderNo,
+				Amount:     o.PayAmount,
+			})
+		}
+		return tx.Create(inv).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// RequestWithdrawalInvoiceTx 在提现申请事务内登记发票（金额单位：分），与冻结资金、提现记录同事务落库
+func RequestWithdrawalInvoiceTx(tx *gorm.DB, userID, titleID, withdrawalID uint, amountCents int64) (*model.Invoice, error) {
+	if userID == 0 || titleID == 0 || withdrawalID == 0 || amountCents <= 0 {
+		return nil, errors.New("参数错误")
+	}
+	t, err := loadTitle(tx, userID, titleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureNotInvoiced(tx, model.InvoiceSourceWithdrawal, []uint{withdrawalID}); err != nil {
+		return nil, err
+	}
+	amount := decimal.NewFromInt(amountCents).Div(decimal.NewFromInt(100))
+	inv := newInvoiceFromTitle(userID, model.InvoiceSourceWithdrawal, t, "", "")
+	inv.Amount = amount
+	inv.Items = []model.InvoiceItem{{
+		SourceType: model.InvoiceSourceWithdrawal,
+		SourceID:   withdrawalID,
+		SourceNo:   fmt.Sprintf("%d", withdrawalID),
+		Amount:     amount,
+	}}
+	if err := tx.Create(inv).Error; err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// ListInvoices 分页查询发票申请；userID 为 0 时不限用户（管理端）
+func (s *InvoiceService) ListInvoices(userID uint, status int, sourceType, keyword string, page, limit int) ([]model.Invoice, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 20
+	}
+	q := s.db.Model(&model.Invoice{})
+	if userID != 0 {
+		q = q.Where("user_id = ?", userID)
+	}
+	if status > 0 {
+		q = q.Where("status = ?", status)
+	}
+	if sourceType = strings.TrimSpace(sourceType); sourceType != "" {
+		q = q.Where("source_type = ?", sourceType)
+	}
+	if keyword = strings.TrimSpace(keyword); keyword != "" {
+		like := "%" + keyword + "%"
+		q = q.Where("request_no LIKE ? OR invoice_no LIKE ? OR title_name LIKE ?", like, like, like)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []model.Invoice
+	if err := q.Order("id DESC").Limit(limit).Offset((page - 1) * limit).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// GetInvoice 获取发票详情（含关联单据）；userID 为 0 时不校验归属
+func (s *InvoiceService) GetInvoice(userID, id uint) (*model.Invoice, error) {
+	q := s.db.Preload("Items").Where("id = ?", id)
+	if userID != 0 {
+		q = q.Where("user_id = ?", userID)
+	}
+	var inv model.Invoice
+	if err := q.First(&inv).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("发票申请不存在")
+		}
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// ApproveAndIssue 审核通过并通过开票通道开具；开具失败的申请可再次调用重试。
+// 调用通道前先以条件更新将申请置为开具中，并发审核或重试只有一方会实际开具
+func (s *InvoiceService) ApproveAndIssue(ctx context.Context, id, operatorID uint) (*model.Invoice, error) {
+	inv, err := s.GetInvoice(0, id)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Status != model.InvoiceStatusPending && inv.Status != model.InvoiceStatusFailed {
+		return nil, errors.New("当前状态不可开票")
+	}
+	claim := s.db.Model(&model.Invoice{}).
+		Where("id = ? AND status IN ?", inv.ID, []int{model.InvoiceStatusPending, model.InvoiceStatusFailed}).
+		Updates(map[string]any{"status": model.InvoiceStatusIssuing, "provider": s.provider.Name()})
+	if claim.Error != nil {
+		return nil, claim.Error
+	}
+	if claim.RowsAffected != 1 {
+		return nil, errors.New("发票状态已变化，请刷新后重试")
+	}
+
+	req := InvoiceIssueRequest{
+		RequestNo:   inv.RequestNo,
+		TitleType:   inv.TitleType,
+		TitleName:   inv.TitleName,
+		TaxNo:       inv.TaxNo,
+		Email:       inv.Email,
+		Amount:      inv.Amount,
+		SellerName:  config.Config.Invoice.SellerName,
+		SellerTaxNo: config.Config.Invoice.SellerTaxNo,
+	}
+	for _, it := range inv.Items {
+		name := "商品销售"
+		if it.SourceType == model.InvoiceSourceWithdrawal {
+			name = "推广服务费"
+		}
+		req.Items = append(req.Items, InvoiceIssueItem{Name: name, Amount: it.Amount})
+	}
+
+	now := time.Now()
+	res, issueErr := s.provider.Issue(ctx, req)
+	if issueErr != nil {
+		reason := issueErr.Error()
+		if len(reason) > 250 {
+			reason = reason[:250]
+		}
+		if err := s.db.Model(&model.Invoice{}).
+			Where("id = ? AND status = ?", inv.ID, model.InvoiceStatusIssuing).
+			Updates(map[string]any{
+				"status":      model.InvoiceStatusFailed,
+				"provider":    s.provider.Name(),
+				"fail_reason": reason,
+				"reviewed_by": operatorID,
+				"reviewed_at": now,
+			}).Error; err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("开票失败: %w", issueErr)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		upd := tx.Model(&model.Invoice{}).
+			Where("id = ? AND status = ?", inv.ID, model.InvoiceStatusIssuing).
+			Updates(map[string]any{
+				"status":       model.InvoiceStatusIssued,
+				"provider":     s.provider.Name(),
+				"invoice_no":   res.InvoiceNo,
+				"invoice_code": res.InvoiceCode,
+				"file_url":     res.FileURL,
+				"xml_url":      res.XMLURL,
+				"fail_reason":  "",
+				"reviewed_by":  operatorID,
+				"reviewed_at":  now,
+				"issued_at":    res.IssuedAt,
+			})
+		if upd.Error != nil {
+			return upd.Error
+		}
+		if upd.RowsAffected == 0 {
+			return errors.New("发票状态已变化，请刷新后重试")
+		}
+		// 提现发票：回写提现申请的发票号
+		if inv.SourceType == model.InvoiceSourceWithdrawal {
+			for _, it := range inv.Items {
+				if err := tx.Model(&model.WithdrawalRequest{}).Where("id = ?", it.SourceID).
+					Update("invoice_no", res.InvoiceNo).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetInvoice(0, id)
+}
+
+// Reject 驳回发票申请，驳回后相关单据可重新申请
+func (s *InvoiceService) Reject(id, operatorID uint, reason string) error {
+	if strings.TrimSpace(reason) == "" {
+		return errors.New("请填写驳回原因")
+	}
+	res := s.db.Model(&model.Invoice{}).
+		Where("id = ? AND status IN ?", id, []int{model.InvoiceStatusPending, model.InvoiceStatusFailed}).
+		Updates(map[string]any{
+			"status":        model.InvoiceStatusRejected,
+			"reject_reason": strings.TrimSpace(reason),
+			"reviewed_by":   operatorID,
+			"reviewed_at":   time.Now(),
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("发票申请不存在或当前状态不可驳回")
+	}
+	return nil
+}
+
+func uniqueUints(in []uint) []uint {
+	seen := make(map[uint]struct{}, len(in))
+	out := make([]uint, 0, len(in))
+	for _, v := range in {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		out = append(out, v)
+	}
+	return out
+}
+
+// InvoiceFileLocation 返回发票文件位置：配置了访问前缀的地址直接返回 URL，本地占位文件返回磁盘路径
+func InvoiceFileLocation(inv *model.Invoice, format string) (url, path string, err error) {
+	if inv.Status != model.InvoiceStatusIssued {
+		return "", "", errors.New("发票尚未开具")
+	}
+	ref := inv.FileURL
+	if strings.EqualFold(format, "xml") {
+		ref = inv.XMLURL
+	}
+	if ref == "" {
+		return "", "", errors.New("发票文件不存在")
+	}
+	if strings.Contains(ref, "/") {
+		return ref, "", nil
+	}
+	dir := config.Config.Invoice.OutputDir
+	if dir == "" {
+		dir = "uploads/invoices"
+	}
+	return "", filepath.Join(dir, filepath.Base(ref)), nil
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"tea-api/internal/config"
+	"tea-api/pkg/utils"
+)
+
+// InvoiceIssueRequest 开票请求（与具体开票通道无关）
+type InvoiceIssueRequest struct {
+	RequestNo   string
+	TitleType   int // 1:个人 2:企业
+	TitleName   string
+	TaxNo       string
+	Email       string
+	Amount      decimal.Decimal
+	SellerName  string
+	SellerTaxNo string
+	Items       []InvoiceIssueItem
+}
+
+// InvoiceIssueItem 开票明细
+type InvoiceIssueItem struct {
+	Name   string
+	Amount decimal.Decimal
+}
+
+// InvoiceIssueResult 开票结果
+type InvoiceIssueResult struct {
+	InvoiceNo   string
+	InvoiceCode string
+	FileURL     string // PDF
+	XMLURL      string
+	IssuedAt    time.Time
+}
+
+// InvoiceProvider 电子发票开具通道
+type InvoiceProvider interface {
+	Name() string
+	Issue(ctx context.Context, req InvoiceIssueRequest) (*InvoiceIssueResult, error)
+}
+
+// NewInvoiceProvider 按配置创建开票通道，未配置时使用本地占位实现
+func NewInvoiceProvider() (InvoiceProvider, error) {
+	cfg := config.Config.Invoice
+	switch strings.ToLower(strings.TrimSpace(cfg.Provider)) {
+	case "", "local":
+		dir := cfg.OutputDir
+		if dir == "" {
+			dir = "uploads/invoices"
+		}
+		return &LocalInvoiceProvider{OutputDir: dir, PublicURL: cfg.PublicURL}, nil
+	default:
+		return nil, fmt.Errorf("不支持的开票通道: %s", cfg.Provider)
+	}
+}
+
+// LocalInvoiceProvider 本地占位开票实现：生成发票号并落地 PDF/XML 占位文件，供联调与测试使用
+type LocalInvoiceProvider struct {
+	OutputDir string
+	PublicURL string
+	Now       func() time.Time
+}
+
+func (p *LocalInvoiceProvider) Name() string { return "local" }
+
+type localInvoiceXML struct {
+	XMLName     xml.Name              `xml:"Invoice"`
+	InvoiceNo   string                `xml:"InvoiceNo"`
+	InvoiceCode string                `xml:"InvoiceCode"`
+	RequestNo   string                `xml:"RequestNo"`
+	IssuedAt    string                `xml:"IssuedAt"`
+	Seller      localInvoiceXMLParty  `xml:"Seller"`
+	Buyer       localInvoiceXMLParty  `xml:"Buyer"`
+	Amount      string                `xml:"Amount"`
+	Items       []localInvoiceXMLItem `xml:"Items>Item"`
+	Placeholder bool                  `xml:"Placeholder"`
+}
+
+type localInvoiceXMLParty struct {
+	Name  string `xml:"Name"`
+	TaxNo string `xml:"TaxNo,omitempty"`
+	Type  int    `xml:"Type,omitempty"`
+}
+
+type localInvoiceXMLItem struct {
+	Name   string `xml:"Name"`
+	Amount string `xml:"Amount"`
+}
+
+func (p *LocalInvoiceProvider) Issue(ctx context.Context, req InvoiceIssueRequest) (*InvoiceIssueResult, error) {
+	if req.RequestNo == "" || req.TitleName == "" {
+		return nil, errors.New("开票请求缺少必要字段")
+	}
+	now := time.Now()
+	if p.Now != nil {
+		now = p.Now()
+	}
+	// 占位发票号：日期 + 随机串，不代表真实税控号码
+	invoiceNo := "LOCAL" + now.Format("20060102") + strings.ToUpper(utils.GenerateUID()[:8])
+	invoiceCode := "000000000000"
+
+	doc := localInvoiceXML{
+		InvoiceNo:   invoiceNo,
+		InvoiceCode: invoiceCode,
+		RequestNo:   req.RequestNo,
+		IssuedAt:    now.Format(time.RFC3339),
+		Seller:      localInvoiceXMLParty{Name: req.SellerName, TaxNo: req.SellerTaxNo},
+		Buyer:       localInvoiceXMLParty{Name: req.TitleName, TaxNo: req.TaxNo, Type: req.TitleType},
+		Amount:      req.Amount.StringFixed(2),
+		Placeholder: true,
+	}
+	for _, it := range req.Items {
+		doc.Items = append(doc.Items, localInvoiceXMLItem{Name: it.Name, Amount: it.Amount.StringFixed(2)})
+	}
+	xmlBody, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("生成发票XML失败: %w", err)
+	}
+
+	if err := os.MkdirAll(p.OutputDir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建发票目录失败: %w", err)
+	}
+	pdfName := invoiceNo + ".pdf"
+	xmlName := invoiceNo + ".xml"
+	pdf := renderPlaceholderPDF([]string{
+		"E-INVOICE PLACEHOLDER (NOT A VALID TAX INVOICE)",
+		"Invoice No: " + invoiceNo,
+		"Request No: " + req.RequestNo,
+		"Buyer Tax No: " + req.TaxNo,
+		"Amount (CNY): " + req.Amount.StringFixed(2),
+		"Issued At: " + now.Format("2006-01-02 15:04:05"),
+	})
+	if err := os.WriteFile(filepath.Join(p.OutputDir, pdfName), pdf, 0o644); err != nil {
+		return nil, fmt.Errorf("写入发票PDF失败: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(p.OutputDir, xmlName), append([]byte(xml.Header), xmlBody...), 0o644); err != nil {
+		return nil, fmt.Errorf("写入发票XML失败: %w", err)
+	}
+
+	return &InvoiceIssueResult{
+		InvoiceNo:   invoiceNo,
+		InvoiceCode: invoiceCode,
+		FileURL:     p.fileURL(pdfName),
+		XMLURL:      p.fileURL(xmlName),
+		IssuedAt:    now,
+	}, nil
+}
+
+func (p *LocalInvoiceProvider) fileURL(name string) string {
+	if p.PublicURL == "" {
+		return name
+	}
+	return strings.TrimRight(p.PublicURL, "/") + "/" + name
+}
+
+// renderPlaceholderPDF 生成仅包含若干行 ASCII 文本的最小 PDF 文档
+func renderPlaceholderPDF(lines []string) []byte {
+	var content bytes.Buffer
+	content.WriteString("BT /F1 12 Tf 50 790 Td 16 TL\n")
+	for _, l := range lines {
+		l = strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(l)
+		// 内置 Helvetica 字体仅支持 ASCII，非 ASCII 字符以 ? 代替
+		l = strings.Map(func(r rune) rune {
+			if r > 126 {
+				return '?'
+			}
+			return r
+		}, l)
+		content.WriteString("(" + l + ") Tj T*\n")
+	}
+	content.WriteString("ET")
+
+	objs := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objs))
+	for i, o := range objs {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, xref)
+	return buf.Bytes()
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+func TestLocalInvoiceProvider_IssueWritesPDFAndXML(t *testing.T) {
+	dir := t.TempDir()
+	fixed := time.Date(2025, 5, 1, 10, 0, 0, 0, time.Local)
+	p := &LocalInvoiceProvider{OutputDir: dir, PublicURL: "https://cdn.example.com/invoices/", Now: func() time.Time { return fixed }}
+
+	res, err := p.Issue(context.Background(), InvoiceIssueRequest{
+		RequestNo: "IV20250501",
+		TitleType: 2,
+		TitleName: "示例科技有限公司",
+		TaxNo:     "91310000MA1FL0000X",
+		Amount:    decimal.RequireFromString("128.50"),
+		Items:     []InvoiceIssueItem{{Name: "商品销售", Amount: decimal.RequireFromString("128.50")}},
+	})
+	if err != nil {
+		t.Fatalf("issue failed: %v", err)
+	}
+	if res.InvoiceNo == "" || !res.IssuedAt.Equal(fixed) {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if res.FileURL != "https://cdn.example.com/invoices/"+res.InvoiceNo+".pdf" {
+		t.Fatalf("unexpected pdf url: %s", res.FileURL)
+	}
+
+	pdf, err := os.ReadFile(filepath.Join(dir, res.InvoiceNo+".pdf"))
+	if err != nil {
+		t.Fatalf("read pdf: %v", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) || !bytes.Contains(pdf, []byte("%%EOF")) {
+		t.Fatalf("pdf placeholder malformed")
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, res.InvoiceNo+".xml"))
+	if err != nil {
+		t.Fatalf("read xml: %v", err)
+	}
+	var doc localInvoiceXML
+	if err := xml.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("xml invalid: %v", err)
+	}
+	if doc.Buyer.TaxNo != "91310000MA1FL0000X" || doc.Amount != "128.50" || len(doc.Items) != 1 {
+		t.Fatalf("unexpected xml content: %+v", doc)
+	}
+}
+
+func TestValidateInvoiceTitle(t *testing.T) {
+	personal := model.InvoiceTitle{TitleType: 1, Name: " 张三 ", TaxNo: "ignored"}
+	if err := validateInvoiceTitle(&personal); err != nil {
+		t.Fatalf("personal title should pass: %v", err)
+	}
+	if personal.Name != "张三" || personal.TaxNo != "" {
+		t.Fatalf("personal title not normalized: %+v", personal)
+	}
+	company := model.InvoiceTitle{TitleType: 2, Name: "示例公司", TaxNo: "91310000ma1fl0000x"}
+	if err := validateInvoiceTitle(&company); err != nil || company.TaxNo != "91310000MA1FL0000X" {
+		t.Fatalf("company title should pass with normalized tax no: %v %s", err, company.TaxNo)
+	}
+	missing := model.InvoiceTitle{TitleType: 2, Name: "示例公司"}
+	if err := validateInvoiceTitle(&missing); err == nil {
+		t.Fatalf("company title without tax no should fail")
+	}
+}
+
+// countingInvoiceProvider 记录开具次数，开具时稍作停顿以放大并发窗口
+type countingInvoiceProvider struct{ calls int32 }
+
+func (p *countingInvoiceProvider) Name() string { return "counting" }
+
+func (p *countingInvoiceProvider) Issue(ctx context.Context, req InvoiceIssueRequest) (*InvoiceIssueResult, error) {
+	n := atomic.AddInt32(&p.calls, 1)
+	time.Sleep(20 * time.Millisecond)
+	return &InvoiceIssueResult{InvoiceNo: req.RequestNo + string(rune('0'+n)), IssuedAt: time.Now()}, nil
+}
+
+func TestInvoice_ConcurrentRequestAndApproveIssueOnce(t *testing.T) {
+	dsn := "file:" + filepath.Join(t.TempDir(), "invoice.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.Order{}, &model.InvoiceTitle{}, &model.Invoice{}, &model.InvoiceItem{}, &model.WithdrawalRequest{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	title := model.InvoiceTitle{UserID: 7, TitleType: 1, Name: "张三"}
+	db.Create(&title)
+	order := model.Order{OrderNo: "INV001", UserID: 7, Status: 4, PayStatus: 2, TotalAmount: decimal.NewFromInt(88), PayAmount: decimal.NewFromInt(88)}
+	db.Create(&order)
+	p := &countingInvoiceProvider{}
+	svc := &InvoiceService{db: db, provider: p}
+
+	// 同一订单并发申请：只能生成一张发票
+	var (
+		wg       sync.WaitGroup
+		requests int32
+		inv      *model.Invoice
+		mu       sync.Mutex
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := svc.RequestForOrders(7, title.ID, []uint{order.ID}, "", ""); err == nil {
+				atomic.AddInt32(&requests, 1)
+				mu.Lock()
+				inv = got
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if requests != 1 {
+		t.Fatalf("concurrent requests for the same order should create one invoice, got %d", requests)
+	}
+
+	// 并发审核/重试：通道只被调用一次
+	var issued int32
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := svc.ApproveAndIssue(context.Background(), inv.ID, 1); err == nil {
+				atomic.AddInt32(&issued, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if issued != 1 || p.calls != 1 {
+		t.Fatalf("concurrent approvals should issue once, succeeded=%d provider calls=%d", issued, p.calls)
+	}
+	got, _ := svc.GetInvoice(0, inv.ID)
+	if got.Status != model.InvoiceStatusIssued || got.InvoiceNo == "" {
+		t.Fatalf("unexpected invoice after issue: %+v", got)
+	}
+}
@@ -0,0 +1,260 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/model"
+)
+
+// BackfillItem 单个科目的期初补录
+type BackfillItem struct {
+	Account string `json:"account"`
+	Ledger  int64  `json:"ledger"` // 补录前科目余额
+	Target  int64  `json:"target"` // 按现有业务数据应有的余额
+}
+
+// BackfillReport 期初补录结果
+type BackfillReport struct {
+	Items           []BackfillItem `json:"items"`
+	WalletsCreated  int            `json:"wallets_created"` // 仅有 users.balance 的用户新建钱包数
+	UsersResynced   int            `json:"users_resynced"`  // users.balance 与钱包不一致而重写的用户数
+	OpeningTotalCts int64          `json:"opening_total_cents"`
+}
+
+// Backfill 启用账本时补录期初余额：以现有 wallets、未提现佣金与门店收支为准，
+// 与科目余额的差额记为期初凭证（对方科目 opening_balance，不回写投影），重复执行只补差额。
+// 没有钱包的用户按 users.balance 建立钱包，最后将 users.balance 重写为钱包余额的投影。
+// 每个科目在锁定科目行的事务内计算并过账，可在业务运行期间执行。
+// dryRun 为 true 时只计算差额，不落库。
+func Backfill(db *gorm.DB, dryRun bool) (*BackfillReport, error) {
+	rep := &BackfillReport{}
+	hundred := decimal.NewFromInt(100)
+
+	// 仅有 users.balance 的历史用户：以其余额建立钱包
+	var orphans []struct {
+		ID      uint
+		Balance decimal.Decimal
+	}
+	if err := db.Table("users").Select("users.id, users.balance").
+		Joins("LEFT JOIN wallets ON wallets.user_id = users.id").
+		Where("wallets.user_id IS NULL AND users.balance > 0").Scan(&orphans).Error; err != nil {
+		return nil, err
+	}
+	for _, u := range orphans {
+		rep.WalletsCreated++
+		if dryRun {
+			rep.Items = append(rep.Items, BackfillItem{Account: UserWallet(u.ID), Target: u.Balance.Mul(hundred).Round(0).IntPart()})
+			continue
+		}
+		w := model.Wallet{UserID: u.ID, Balance: u.Balance.Mul(hundred).Round(0).IntPart()}
+		if err := db.Create(&w).Error; err != nil {
+			return nil, fmt.Errorf("create wallet for user %d: %w", u.ID, err)
+		}
+	}
+
+	targets, err := backfillTargets(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		if dryRun {
+			cur, _, err := AccountBalance(db, t.code)
+			if err != nil {
+				return nil, err
+			}
+			target, err := t.compute(db)
+			if err != nil {
+				return nil, err
+			}
+			if target != cur {
+				rep.Items = append(rep.Items, BackfillItem{Account: t.code, Ledger: cur, Target: target})
+				rep.OpeningTotalCts += target - cur
+			}
+			continue
+		}
+		// 锁定科目行后再读取业务数据与科目余额并过账：并发业务在同一事务内更新业务表与科目余额，
+		// 须等待本事务提交，期初差额不会混入进行中的发生额
+		var item *BackfillItem
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			acc, err := ensureAccount(tx, t.code)
+			if err != nil {
+				return err
+			}
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(acc, acc.ID).Error; err != nil {
+				return err
+			}
+			target, err := t.compute(tx)
+			if err != nil {
+				return err
+			}
+			diff := target - acc.Balance
+			if diff == 0 {
+				return nil
+			}
+			item = &BackfillItem{Account: t.code, Ledger: acc.Balance, Target: target}
+			_, err = post(tx, Journal{
+				EventType: TypeOpeningBalance,
+				RefType:   "backfill",
+				RefID:     t.code,
+				Memo:      fmt.Sprintf("期初补录 %d -> %d", acc.Balance, target),
+				Lines:     []Line{Credit(t.code, diff), Debit(OpeningBalance, diff)},
+			}, false)
+			return err
+		}); err != nil {
+			return nil, fmt.Errorf("backfill %s: %w", t.code, err)
+		}
+		if item != nil {
+			rep.Items = append(rep.Items, *item)
+			rep.OpeningTotalCts += item.Target - item.Ledger
+		}
+	}
+
+	if !dryRun {
+		if err := markBackfillDone(db); err != nil {
+			return nil, err
+		}
+	}
+
+	// users.balance 作为钱包余额投影重写
+	var users []struct {
+		ID            uint
+		Balance       decimal.Decimal
+		WalletBalance int64
+	}
+	if err := db.Table("users").Select("users.id, users.balance, wallets.balance AS wallet_balance").
+		Joins("JOIN wallets ON wallets.user_id = users.id").Scan(&users).Error; err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		want := decimal.NewFromInt(u.WalletBalance).Div(hundred)
+		if u.Balance.Equal(want) {
+			continue
+		}
+		rep.UsersResynced++
+		if dryRun {
+			continue
+		}
+		// 锁定钱包后按最新余额重写，避免覆盖并发的余额变动
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			var w model.Wallet
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", u.ID).First(&w).Error; err != nil {
+				return err
+			}
+			return tx.Model(&model.User{}).Where("id = ?", u.ID).
+				Update("balance", decimal.NewFromInt(w.Balance).Div(hundred)).Error
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return rep, nil
+}
+
+// backfillDoneKey 期初补录完成标记凭证的幂等键
+const backfillDoneKey = "backfill:done"
+
+// markBackfillDone 写入不含分录的期初补录完成标记，重复执行不重复写入
+func markBackfillDone(db *gorm.DB) error {
+	done, err := BackfillDone(db)
+	if err != nil || done {
+		return err
+	}
+	key := backfillDoneKey
+	return db.Create(&model.LedgerEntry{EventType: TypeOpeningBalance, BizKey: &key, RefType: "backfill", RefID: "done", Memo: "期初补录完成"}).Error
+}
+
+// BackfillDone 期初补录是否已完成；完成前科目余额只含启用账本后的发生额，不能替代业务表口径
+func BackfillDone(db *gorm.DB) (bool, error) {
+	var n int64
+	if err := db.Model(&model.LedgerEntry{}).Where("biz_key = ?", backfillDoneKey).Count(&n).Error; err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// backfillTarget 待补录科目及其按业务表计算应有余额的方法（在锁定科目行的事务内调用）
+type backfillTarget struct {
+	code    string
+	compute func(db *gorm.DB) (int64, error)
+}
+
+// backfillTargets 列出需要核对的科目：所有钱包的可用与冻结、有未提现佣金的用户、有收支的门店
+func backfillTargets(db *gorm.DB) ([]backfillTarget, error) {
+	var out []backfillTarget
+
+	var userIDs []uint
+	if err := db.Model(&model.Wallet{}).Order("user_id").Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range userIDs {
+		out = append(out, backfillTarget{UserWallet(id), walletTarget(id, "balance")}, backfillTarget{UserFrozen(id), walletTarget(id, "frozen")})
+	}
+
+	var commUsers []uint
+	if err := db.Model(&model.Commission{}).Where("status IN ?", []string{"frozen", "available"}).
+		Distinct("user_id").Order("user_id").Pluck("user_id", &commUsers).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range commUsers {
+		out = append(out, backfillTarget{Commission(id), commissionTarget(id)})
+	}
+
+	var storeIDs []uint
+	if err := db.Raw(`SELECT o.store_id FROM payments p JOIN orders o ON o.id = p.order_id WHERE o.store_id > 0 AND p.status = ?
+		UNION SELECT store_id FROM withdraw_records WHERE store_id > 0 AND status = ?`, 2, model.WithdrawStatusCompleted).
+		Scan(&storeIDs).Error; err != nil {
+		return nil, err
+	}
+	sort.Slice(storeIDs, func(i, j int) bool { return storeIDs[i] < storeIDs[j] })
+	for _, id := range storeIDs {
+		out = append(out, backfillTarget{Store(id), storeTarget(id)})
+	}
+	return out, nil
+}
+
+// walletTarget 钱包可用（balance）或冻结（frozen）余额
+func walletTarget(userID uint, column string) func(db *gorm.DB) (int64, error) {
+	return func(db *gorm.DB) (int64, error) {
+		var v int64
+		err := db.Model(&model.Wallet{}).Where("user_id = ?", userID).Select(column).Scan(&v).Error
+		return v, err
+	}
+}
+
+// commissionTarget 未提现佣金（frozen / available），已打款与已回滚的不计
+func commissionTarget(userID uint) func(db *gorm.DB) (int64, error) {
+	return func(db *gorm.DB) (int64, error) {
+		var total decimal.Decimal
+		if err := db.Model(&model.Commission{}).Select("COALESCE(SUM(net_amount), 0)").
+			Where("user_id = ? AND status IN ?", userID, []string{"frozen", "available"}).Scan(&total).Error; err != nil {
+			return 0, err
+		}
+		return total.Mul(decimal.NewFromInt(100)).Round(0).IntPart(), nil
+	}
+}
+
+// storeTarget 门店待结算：已支付 - 已退款 - 已完成提现（含手续费），与过账口径一致
+func storeTarget(storeID uint) func(db *gorm.DB) (int64, error) {
+	return func(db *gorm.DB) (int64, error) {
+		var paid, refunded, withdrawn decimal.Decimal
+		if err := db.Table("payments AS p").Joins("JOIN orders AS o ON o.id = p.order_id").
+			Where("o.store_id = ? AND p.status = ?", storeID, 2).
+			Select("COALESCE(SUM(p.amount), 0)").Scan(&paid).Error; err != nil {
+			return 0, err
+		}
+		if err := db.Table("refunds AS r").Joins("JOIN orders AS o ON o.id = r.order_id").
+			Where("o.store_id = ? AND r.status = ?", storeID, model.RefundStatusSuccess).
+			Select("COALESCE(SUM(r.refund_amount), 0)").Scan(&refunded).Error; err != nil {
+			return 0, err
+		}
+		if err := db.Model(&model.WithdrawRecord{}).Where("store_id = ? AND status = ?", storeID, model.WithdrawStatusCompleted).
+			Select("COALESCE(SUM(amount), 0)").Scan(&withdrawn).Error; err != nil {
+			return 0, err
+		}
+		return paid.Sub(refunded).Sub(withdrawn).Mul(decimal.NewFromInt(100)).Round(0).IntPart(), nil
+	}
+}
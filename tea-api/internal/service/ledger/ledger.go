@@ -0,0 +1,250 @@
+// Package ledger 复式记账：所有资金事件以借贷平衡的凭证过账，钱包/用户余额等为科目余额的投影。
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+// 科目类型
+const (
+	TypeUserWallet       = "user_wallet"       // 用户可用余额（负债），投影到 wallets.balance 与 users.balance
+	TypeUserFrozen       = "user_frozen"       // 用户冻结余额（负债），投影到 wallets.frozen
+	TypeCommission       = "commission"        // 用户未提现佣金（负债）
+	TypeStore            = "store"             // 门店待结算款（负债）
+	TypePlatformRevenue  = "platform_revenue"  // 平台收入：无门店订单收款、提现手续费
+	TypePlatformExpense  = "platform_expense"  // 平台费用：充值赠送、利息、佣金
+	TypeProviderClearing = "provider_clearing" // 渠道待清算资金（资产），按 wechat/alipay/bank 区分
+	TypeManualAdjust     = "manual_adjustment" // 后台人工调账
+	TypeGiftCard         = "gift_card"         // 平台通用礼品卡售卡款（资产），兑换时借记，按批次与线下售卡收入核对
+	TypeOpeningBalance   = "opening_balance"   // 启用账本前的期初余额
+)
+
+// 平台级科目编码
+const (
+	PlatformRevenue  = TypePlatformRevenue
+	PlatformExpense  = TypePlatformExpense
+	ManualAdjustment = TypeManualAdjust
+	OpeningBalance   = TypeOpeningBalance
+	GiftCard         = TypeGiftCard
+)
+
+var (
+	// ErrUnbalanced 凭证借贷不平
+	ErrUnbalanced = errors.New("凭证借贷不平衡")
+	// ErrInsufficientBalance 过账后用户余额为负
+	ErrInsufficientBalance = errors.New("余额不足")
+	// ErrInsufficientFrozen 过账后用户冻结余额为负
+	ErrInsufficientFrozen = errors.New("冻结余额不足")
+)
+
+func UserWallet(userID uint) string     { return fmt.Sprintf("%s:%d", TypeUserWallet, userID) }
+func UserFrozen(userID uint) string     { return fmt.Sprintf("%s:%d", TypeUserFrozen, userID) }
+func Commission(userID uint) string     { return fmt.Sprintf("%s:%d", TypeCommission, userID) }
+func Store(storeID uint) string         { return fmt.Sprintf("%s:%d", TypeStore, storeID) }
+func ProviderClearing(ch string) string { return TypeProviderClearing + ":" + ch }
+
+// Line 凭证分录，Amount 借方为正、贷方为负（分）
+type Line struct {
+	Account string
+	Amount  int64
+}
+
+// Debit 借记
+func Debit(account string, cents int64) Line { return Line{Account: account, Amount: cents} }
+
+// Credit 贷记
+func Credit(account string, cents int64) Line { return Line{Account: account, Amount: -cents} }
+
+// Journal 待过账凭证
+type Journal struct {
+	EventType string
+	BizKey    string // 非空时同一键只过账一次，重复调用返回已有凭证
+	RefType   string
+	RefID     string
+	Memo      string
+	Lines     []Line
+}
+
+// Post 在调用方事务内过账：校验借贷平衡，更新科目余额并同步钱包/用户余额投影。
+// 用户可用/冻结余额不足时返回 ErrInsufficientBalance / ErrInsufficientFrozen，调用方应回滚事务。
+func Post(tx *gorm.DB, j Journal) (*model.LedgerEntry, error) {
+	return post(tx, j, true)
+}
+
+func post(tx *gorm.DB, j Journal, project bool) (*model.LedgerEntry, error) {
+	var sum int64
+	lines := make([]Line, 0, len(j.Lines))
+	for _, l := range j.Lines {
+		if l.Amount == 0 {
+			continue
+		}
+		if l.Account == "" {
+			return nil, errors.New("分录科目不能为空")
+		}
+		sum += l.Amount
+		lines = append(lines, l)
+	}
+	if sum != 0 {
+		return nil, fmt.Errorf("%w: %s 合计 %d", ErrUnbalanced, j.EventType, sum)
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	entry := &model.LedgerEntry{EventType: j.EventType, RefType: j.RefType, RefID: j.RefID, Memo: truncate(j.Memo, 255)}
+	if j.BizKey != "" {
+		var exist model.LedgerEntry
+		err := tx.Where("biz_key = ?", j.BizKey).Limit(1).Find(&exist).Error
+		if err != nil {
+			return nil, err
+		}
+		if exist.ID != 0 {
+			return &exist, nil
+		}
+		key := j.BizKey
+		entry.BizKey = &key
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return nil, err
+	}
+
+	for _, l := range lines {
+		acc, err := ensureAccount(tx, l.Account)
+		if err != nil {
+			return nil, err
+		}
+		delta := l.Amount * normalSign(acc.Type)
+		if err := tx.Model(&model.LedgerAccount{}).Where("id = ?", acc.ID).
+			Update("balance", gorm.Expr("balance + ?", delta)).Error; err != nil {
+			return nil, err
+		}
+		p := model.LedgerPosting{EntryID: entry.ID, AccountID: acc.ID, AccountCode: acc.Code, Amount: l.Amount}
+		if err := tx.Create(&p).Error; err != nil {
+			return nil, err
+		}
+		entry.Postings = append(entry.Postings, p)
+		if project {
+			if err := applyProjection(tx, acc, delta); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entry, nil
+}
+
+// normalSign 资产/费用类科目借方为正，其余（负债、收入、权益）贷方为正
+func normalSign(typ string) int64 {
+	switch typ {
+	case TypeProviderClearing, TypePlatformExpense, TypeGiftCard:
+		return 1
+	default:
+		return -1
+	}
+}
+
+func parseCode(code string) (typ string, owner uint) {
+	typ, rest, found := strings.Cut(code, ":")
+	if !found {
+		return typ, 0
+	}
+	switch typ {
+	case TypeUserWallet, TypeUserFrozen, TypeCommission, TypeStore:
+		if n, err := strconv.ParseUint(rest, 10, 64); err == nil {
+			owner = uint(n)
+		}
+	}
+	return typ, owner
+}
+
+func ensureAccount(tx *gorm.DB, code string) (*model.LedgerAccount, error) {
+	var acc model.LedgerAccount
+	if err := tx.Where("code = ?", code).Limit(1).Find(&acc).Error; err != nil {
+		return nil, err
+	}
+	if acc.ID != 0 {
+		return &acc, nil
+	}
+	typ, owner := parseCode(code)
+	acc = model.LedgerAccount{Code: code, Type: typ, OwnerID: owner}
+	if err := tx.Create(&acc).Error; err != nil {
+		// 并发创建由唯一索引拦截，重新读取
+		var again model.LedgerAccount
+		if err2 := tx.Where("code = ?", code).First(&again).Error; err2 != nil {
+			return nil, err
+		}
+		return &again, nil
+	}
+	return &acc, nil
+}
+
+// applyProjection 将科目余额变动同步到 wallets / users 投影，扣减时要求投影余额充足
+func applyProjection(tx *gorm.DB, acc *model.LedgerAccount, delta int64) error {
+	switch acc.Type {
+	case TypeUserWallet:
+		if err := updateWallet(tx, acc.OwnerID, "balance", delta, ErrInsufficientBalance); err != nil {
+			return err
+		}
+		yuan := decimal.NewFromInt(delta).Div(decimal.NewFromInt(100))
+		return tx.Model(&model.User{}).Where("id = ?", acc.OwnerID).
+			Update("balance", gorm.Expr("balance + ?", yuan)).Error
+	case TypeUserFrozen:
+		return updateWallet(tx, acc.OwnerID, "frozen", delta, ErrInsufficientFrozen)
+	}
+	return nil
+}
+
+func updateWallet(tx *gorm.DB, userID uint, column string, delta int64, insufficient error) error {
+	if delta > 0 {
+		if err := ensureWallet(tx, userID); err != nil {
+			return err
+		}
+	}
+	q := tx.Table("wallets").Where("user_id = ?", userID)
+	if delta < 0 {
+		q = q.Where(column+" >= ?", -delta)
+	}
+	res := q.Update(column, gorm.Expr(column+" + ?", delta))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return insufficient
+	}
+	return nil
+}
+
+func ensureWallet(tx *gorm.DB, userID uint) error {
+	var n int64
+	if err := tx.Model(&model.Wallet{}).Where("user_id = ?", userID).Count(&n).Error; err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	return tx.Create(&model.Wallet{UserID: userID}).Error
+}
+
+// AccountBalance 查询科目余额（正常方向），科目不存在时 ok 为 false
+func AccountBalance(db *gorm.DB, code string) (balance int64, ok bool, err error) {
+	var acc model.LedgerAccount
+	if err := db.Where("code = ?", code).Limit(1).Find(&acc).Error; err != nil {
+		return 0, false, err
+	}
+	return acc.Balance, acc.ID != 0, nil
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service/ledger"
+)
+
+// 账本凭证事件类型（钱包相关事件直接使用钱包流水类型）
+const (
+	LedgerEventPayment = "payment" // 外部渠道收款
+	LedgerEventRefund  = "refund"  // 外部渠道退款
+)
+
+// orderRevenueAccount 订单收款归属科目：门店订单记门店待结算，无门店订单记平台收入
+func orderRevenueAccount(order *model.Order) string {
+	if order.StoreID > 0 {
+		return ledger.Store(order.StoreID)
+	}
+	return ledger.PlatformRevenue
+}
+
+// payMethodClearing 支付方式对应的渠道清算科目
+func payMethodClearing(method int) string {
+	return ledger.ProviderClearing(notifyProviderName(method))
+}
+
+// postExternalPaymentTx 外部渠道支付成功：借渠道清算、贷订单收款科目；充值订单由到账分录入账，不在此过账
+func postExternalPaymentTx(tx *gorm.DB, pay *model.Payment, order *model.Order) error {
+	if pay.PaymentMethod == PayMethodBalance || order.OrderType == OrderTypeRecharge {
+		return nil
+	}
+	cents := yuanDecimalToCents(pay.Amount)
+	_, err := ledger.Post(tx, ledger.Journal{
+		EventType: LedgerEventPayment,
+		BizKey:    "payment:" + pay.PaymentNo,
+		RefType:   "payment",
+		RefID:     pay.PaymentNo,
+		Memo:      fmt.Sprintf("订单%s渠道收款", order.OrderNo),
+		Lines: []ledger.Line{
+			ledger.Debit(payMethodClearing(pay.PaymentMethod), cents),
+			ledger.Credit(orderRevenueAccount(order), cents),
+		},
+	})
+	return err
+}
+
+// postExternalRefundTx 外部渠道退款成功：借订单收款科目、贷渠道清算；充值订单的本金已在扣回时冲减
+func postExternalRefundTx(tx *gorm.DB, r *model.Refund) error {
+	var pay model.Payment
+	if err := tx.Select("id", "payment_method").First(&pay, r.PaymentID).Error; err != nil {
+		return err
+	}
+	if pay.PaymentMethod == PayMethodBalance {
+		return nil
+	}
+	var order model.Order
+	if err := tx.Select("id", "order_no", "store_id", "order_type").First(&order, r.OrderID).Error; err != nil {
+		return err
+	}
+	if order.OrderType == OrderTypeRecharge {
+		return nil
+	}
+	cents := yuanDecimalToCents(r.RefundAmount)
+	_, err := ledger.Post(tx, ledger.Journal{
+		EventType: LedgerEventRefund,
+		BizKey:    "refund:" + r.RefundNo,
+		RefType:   "refund",
+		RefID:     r.RefundNo,
+		Memo:      fmt.Sprintf("订单%s渠道退款", order.OrderNo),
+		Lines: []ledger.Line{
+			ledger.Debit(orderRevenueAccount(&order), cents),
+			ledger.Credit(payMethodClearing(pay.PaymentMethod), cents),
+		},
+	})
+	return err
+}
+
+// recordWalletTx 写入钱包流水并以其为凭证来源过账，流水的 balance_after 取过账后的钱包投影
+func recordWalletTx(tx *gorm.DB, wtx *model.WalletTransaction, lines []ledger.Line) error {
+	if err := tx.Create(wtx).Error; err != nil {
+		return err
+	}
+	id := strconv.FormatUint(uint64(wtx.ID), 10)
+	if _, err := ledger.Post(tx, ledger.Journal{
+		EventType: wtx.Type,
+		BizKey:    "wallet_tx:" + id,
+		RefType:   "wallet_transaction",
+		RefID:     id,
+		Memo:      wtx.Remark,
+		Lines:     lines,
+	}); err != nil {
+		return err
+	}
+	var balance int64
+	if err := tx.Table("wallets").Select("balance").Where("user_id = ?", wtx.UserID).Scan(&balance).Error; err != nil {
+		return err
+	}
+	wtx.BalanceAfter = &balance
+	return tx.Model(wtx).Update("balance_after", balance).Error
+}
+
+// AdjustWalletTx 在事务内调整用户可用/冻结余额并记钱包流水（流水金额为可用余额变动）。
+// 可用与冻结之间的划转（冻结、解冻）凭证自平衡；净增减部分记人工调账科目。
+func AdjustWalletTx(tx *gorm.DB, userID uint, deltaBalance, deltaFrozen int64, typ, remark string) (*model.WalletTransaction, error) {
+	wtx := &model.WalletTransaction{UserID: userID, Type: typ, Amount: deltaBalance, Remark: remark}
+	if err := recordWalletTx(tx, wtx, []ledger.Line{
+		ledger.Credit(ledger.UserWallet(userID), deltaBalance),
+		ledger.Credit(ledger.UserFrozen(userID), deltaFrozen),
+		ledger.Debit(ledger.ManualAdjustment, deltaBalance+deltaFrozen),
+	}); err != nil {
+		return nil, err
+	}
+	return wtx, nil
+}
+
+// withdrawClearing 提现打款渠道科目：微信转账记 wechat，其余记 bank
+func withdrawClearing(withdrawType int) string {
+	if withdrawType == 1 {
+		return ledger.ProviderClearing("wechat")
+	}
+	return ledger.ProviderClearing("bank")
+}
+
+// SettleWithdrawTx 提现打款完成：借用户冻结余额（门店提现借门店待结算），贷渠道清算（实付）与平台收入（手续费）。
+// 用户提现同时记一条金额为 0 的 withdraw_paid 钱包流水，remark 由调用方提供。
+func SettleWithdrawTx(tx *gorm.DB, rec *model.WithdrawRecord, remark string) error {
+	amount := yuanDecimalToCents(rec.Amount)
+	fee := yuanDecimalToCents(rec.Fee)
+	source := ledger.UserFrozen(rec.UserID)
+	if rec.StoreID > 0 {
+		source = ledger.Store(rec.StoreID)
+	}
+	if _, err := ledger.Post(tx, ledger.Journal{
+		EventType: "withdraw_paid",
+		BizKey:    "withdraw_paid:" + rec.WithdrawNo,
+		RefType:   "withdraw",
+		RefID:     rec.WithdrawNo,
+		Memo:      remark,
+		Lines: []ledger.Line{
+			ledger.Debit(source, amount),
+			ledger.Credit(withdrawClearing(rec.WithdrawType), amount-fee),
+			ledger.Credit(ledger.PlatformRevenue, fee),
+		},
+	}); err != nil {
+		return err
+	}
+	if rec.StoreID > 0 {
+		return nil
+	}
+	var balance int64
+	if err := tx.Table("wallets").Select("balance").Where("user_id = ?", rec.UserID).Scan(&balance).Error; err != nil {
+		return err
+	}
+	return tx.Create(&model.WalletTransaction{UserID: rec.UserID, Type: "withdraw_paid", Amount: 0, BalanceAfter: &balance, Remark: remark}).Error
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service/ledger"
+)
+
+func setupLedgerFlowDB(t *testing.T, name string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Order{}, &model.OrderItem{}, &model.Payment{}, &model.Refund{},
+		&model.StockReservation{}, &model.StoreSlotTemplate{}, &model.Wallet{}, &model.WalletTransaction{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}, &model.PointsTransaction{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func ledgerBalance(t *testing.T, db *gorm.DB, code string) int64 {
+	t.Helper()
+	b, _, err := ledger.AccountBalance(db, code)
+	if err != nil {
+		t.Fatalf("account balance %s: %v", code, err)
+	}
+	return b
+}
+
+func TestLedger_PaymentsAndRefundsStayBalanced(t *testing.T) {
+	db := setupLedgerFlowDB(t, "ledger_flow")
+	user := model.User{Phone: "13800000011", Nickname: "ledger", OpenID: "openid-ledger"}
+	db.Create(&user)
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		_, err := AdjustWalletTx(tx, user.ID, 5000, 0, "admin_credit", "期初充值")
+		return err
+	}); err != nil {
+		t.Fatalf("admin credit: %v", err)
+	}
+
+	provider := &refundStubProvider{}
+	svc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return provider, nil }}
+
+	// 门店订单：余额支付 30 元
+	o1 := model.Order{OrderNo: "ORDL1", UserID: user.ID, StoreID: 3, Status: 1, PayStatus: 1,
+		TotalAmount: decimal.NewFromInt(30), PayAmount: decimal.NewFromInt(30)}
+	db.Create(&o1)
+	if _, err := svc.PayWithBalance(user.ID, o1.ID, 0, PayMethodWechat, UnifiedOrderOptions{}); err != nil {
+		t.Fatalf("balance pay: %v", err)
+	}
+	// 门店订单：微信支付 20 元，重复回调只过账一次
+	o2 := model.Order{OrderNo: "ORDL2", UserID: user.ID, StoreID: 3, Status: 1, PayStatus: 1,
+		TotalAmount: decimal.NewFromInt(20), PayAmount: decimal.NewFromInt(20)}
+	db.Create(&o2)
+	db.Create(&model.Payment{OrderID: o2.ID, PaymentNo: "PL0002", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(20), Status: 1})
+	cb := PaymentCallbackPayload{PaymentNo: "PL0002", TransactionID: "4200000201", TradeState: "SUCCESS", SkipVerify: true}
+	for i := 0; i < 2; i++ {
+		if err := svc.HandleCallback(cb); err != nil {
+			t.Fatalf("callback: %v", err)
+		}
+	}
+	if got := ledgerBalance(t, db, ledger.Store(3)); got != 5000 {
+		t.Fatalf("store should hold 50 yuan, got %d", got)
+	}
+	if got := ledgerBalance(t, db, ledger.ProviderClearing("wechat")); got != 2000 {
+		t.Fatalf("wechat clearing should hold 20 yuan, got %d", got)
+	}
+
+	// 微信订单退款成功（含重复通知）后冲回门店与渠道清算
+	if err := svc.StartOrderRefund(o2.ID, "顾客取消"); err != nil {
+		t.Fatalf("start refund: %v", err)
+	}
+	var r model.Refund
+	db.Where("order_id = ?", o2.ID).First(&r)
+	provider.notify = &PayNotifyResult{Kind: PayNotifyRefund, RefundNo: r.RefundNo, RefundID: "5030000000000201", RefundStatus: "SUCCESS"}
+	for i := 0; i < 2; i++ {
+		if err := svc.HandleProviderNotify(context.Background(), PayMethodWechat, nil, nil); err != nil {
+			t.Fatalf("refund notify: %v", err)
+		}
+	}
+	if ledgerBalance(t, db, ledger.Store(3)) != 3000 || ledgerBalance(t, db, ledger.ProviderClearing("wechat")) != 0 {
+		t.Fatalf("refund should reverse store and clearing, store=%d clearing=%d",
+			ledgerBalance(t, db, ledger.Store(3)), ledgerBalance(t, db, ledger.ProviderClearing("wechat")))
+	}
+
+	// 余额不足时扣款失败，账本与投影均不变
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		_, err := AdjustWalletTx(tx, user.ID, -9999, 0, "admin_debit", "超额扣款")
+		return err
+	}); err == nil {
+		t.Fatalf("overdraft should be rejected")
+	}
+
+	// 所有分录借贷合计为 0，钱包与 users.balance 均为账本投影
+	var sum int64
+	db.Model(&model.LedgerPosting{}).Select("COALESCE(SUM(amount), 0)").Scan(&sum)
+	if sum != 0 {
+		t.Fatalf("trial balance should be zero, got %d", sum)
+	}
+	wallet := ledgerBalance(t, db, ledger.UserWallet(user.ID))
+	db.First(&user, user.ID)
+	if wallet != 2000 || walletBalance(t, db, user.ID) != wallet || !user.Balance.Equal(decimal.NewFromInt(20)) {
+		t.Fatalf("projections should match ledger: ledger=%d wallets=%d users=%s", wallet, walletBalance(t, db, user.ID), user.Balance)
+	}
+}
+
+func TestLedger_BackfillOpeningBalances(t *testing.T) {
+	db := setupLedgerFlowDB(t, "ledger_backfill")
+	if err := db.AutoMigrate(&model.Commission{}, &model.WithdrawRecord{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	legacy := model.User{Phone: "13800000021", Nickname: "legacy", OpenID: "openid-legacy", Balance: decimal.NewFromFloat(12.5)}
+	synced := model.User{Phone: "13800000022", Nickname: "synced", OpenID: "openid-synced", Balance: decimal.NewFromInt(1)}
+	db.Create(&legacy)
+	db.Create(&synced)
+	db.Create(&model.Wallet{UserID: synced.ID, Balance: 800, Frozen: 200})
+	db.Create(&model.Commission{UserID: synced.ID, GrossAmount: decimal.NewFromInt(3), NetAmount: decimal.NewFromInt(3), Status: "available"})
+	paidOrder := model.Order{OrderNo: "ORDLB1", UserID: synced.ID, StoreID: 9, Status: 4, PayStatus: 2,
+		TotalAmount: decimal.NewFromInt(40), PayAmount: decimal.NewFromInt(40)}
+	db.Create(&paidOrder)
+	db.Create(&model.Payment{OrderID: paidOrder.ID, PaymentNo: "PLB001", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(40), Status: 2})
+
+	dry, err := ledger.Backfill(db, true)
+	if err != nil || dry.WalletsCreated != 1 {
+		t.Fatalf("dry run: %+v err=%v", dry, err)
+	}
+	var entries int64
+	db.Model(&model.LedgerEntry{}).Count(&entries)
+	if entries != 0 {
+		t.Fatalf("dry run must not post entries")
+	}
+
+	if _, err := ledger.Backfill(db, false); err != nil {
+		t.Fatalf("backfill: %v", err)
+	}
+	checks := map[string]int64{
+		ledger.UserWallet(legacy.ID): 1250,
+		ledger.UserWallet(synced.ID): 800,
+		ledger.UserFrozen(synced.ID): 200,
+		ledger.Commission(synced.ID): 300,
+		ledger.Store(9):              4000,
+	}
+	for code, want := range checks {
+		if got := ledgerBalance(t, db, code); got != want {
+			t.Fatalf("%s: want %d got %d", code, want, got)
+		}
+	}
+	db.First(&synced, synced.ID)
+	if walletBalance(t, db, legacy.ID) != 1250 || !synced.Balance.Equal(decimal.NewFromInt(8)) {
+		t.Fatalf("projections should be rebuilt: legacy wallet=%d synced users.balance=%s", walletBalance(t, db, legacy.ID), synced.Balance)
+	}
+
+	// 重复执行只补差额
+	again, err := ledger.Backfill(db, false)
+	if err != nil || len(again.Items) != 0 || again.UsersResynced != 0 {
+		t.Fatalf("second backfill should be a no-op: %+v err=%v", again, err)
+	}
+}
+
+func TestLedger_BackfillConcurrentWithPostings(t *testing.T) {
+	dsn := "file:" + filepath.Join(t.TempDir(), "backfill.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Order{}, &model.Payment{}, &model.Refund{}, &model.Wallet{}, &model.WalletTransaction{},
+		&model.Commission{}, &model.WithdrawRecord{}, &model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	var ids []uint
+	for i := 0; i < 5; i++ {
+		u := model.User{Phone: fmt.Sprintf("1380000031%d", i), Nickname: "bf", OpenID: fmt.Sprintf("openid-bf-%d", i), Balance: decimal.NewFromInt(10)}
+		db.Create(&u)
+		db.Create(&model.Wallet{UserID: u.ID, Balance: 1000})
+		ids = append(ids, u.ID)
+	}
+
+	// 补录期间持续有入账：每个科目的期初只含补录时刻之前的余额，补录后科目余额与钱包一致
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := 0; round < 4; round++ {
+			for _, id := range ids {
+				if err := db.Transaction(func(tx *gorm.DB) error {
+					_, err := AdjustWalletTx(tx, id, 100, 0, "admin_credit", "补录期间入账")
+					return err
+				}); err != nil {
+					t.Errorf("adjust wallet: %v", err)
+				}
+			}
+		}
+	}()
+	if _, err := ledger.Backfill(db, false); err != nil {
+		t.Fatalf("backfill: %v", err)
+	}
+	wg.Wait()
+	for _, id := range ids {
+		if got, want := ledgerBalance(t, db, ledger.UserWallet(id)), walletBalance(t, db, id); got != want || want != 1400 {
+			t.Fatalf("user %d: ledger=%d wallet=%d", id, got, want)
+		}
+	}
+}
+
+func TestStoreWalletSummary_LedgerOnlyAfterBackfill(t *testing.T) {
+	db := setupLedgerFlowDB(t, "ledger_store_summary")
+	if err := db.AutoMigrate(&model.Store{}, &model.Commission{}, &model.WithdrawRecord{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	store := model.Store{Name: "补录门店"}
+	db.Create(&store)
+	legacy := model.Order{OrderNo: "ORDSS1", UserID: 1, StoreID: store.ID, Status: 4, PayStatus: 2,
+		TotalAmount: decimal.NewFromInt(40), PayAmount: decimal.NewFromInt(40)}
+	db.Create(&legacy)
+	db.Create(&model.Payment{OrderID: legacy.ID, PaymentNo: "PSS001", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(40), Status: 2})
+	// 启用账本后首笔过账建立门店科目，但历史收入尚未补录
+	db.Create(&model.LedgerAccount{Code: ledger.Store(store.ID), Type: "store", OwnerID: store.ID, Balance: 500})
+
+	svc := &StoreService{db: db}
+	summary, err := svc.GetStoreWalletSummary(store.ID)
+	if err != nil || !summary.Available.Equal(decimal.NewFromInt(40)) {
+		t.Fatalf("before backfill summary should use business tables, got %+v err=%v", summary, err)
+	}
+
+	if _, err := ledger.Backfill(db, false); err != nil {
+		t.Fatalf("backfill: %v", err)
+	}
+	db.Model(&model.LedgerAccount{}).Where("code = ?", ledger.Store(store.ID)).Update("balance", gorm.Expr("balance + ?", 700))
+	summary, err = svc.GetStoreWalletSummary(store.ID)
+	if err != nil || !summary.Available.Equal(decimal.NewFromInt(47)) {
+		t.Fatalf("after backfill summary should use the ledger balance, got %+v err=%v", summary, err)
+	}
+}
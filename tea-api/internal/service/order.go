@@ -10,7 +10,9 @@ import (
 
 	"github.com/shopspring/decimal"
 
+	"tea-api/internal/config"
 	"tea-api/internal/model"
+	"tea-api/internal/service/commission"
 	"tea-api/pkg/database"
 	"tea-api/pkg/utils"
 )
@@ -64,7 +66,9 @@ func (s *OrderService) CreateMembershipOrder(userID, packageID uint, remark stri
 }
 
 // CreateOrderFromCart 从购物车生成订单
-func (s *OrderService) CreateOrderFromCart(userID uint, deliveryType int, addressInfo, remark string, userCouponID uint, storeID uint, orderType int) (*model.Order, error) {
+// deliveryTime 为预约的自取/配送时间（可选）；门店配置了时段模板时会在同一事务内占用对应时段。
+// usePoints 为本单希望使用的抵扣积分（可选），按积分抵扣规则在优惠券之后抵扣，下单即扣减积分。
+func (s *OrderService) CreateOrderFromCart(userID uint, deliveryType int, addressInfo, remark string, userCouponID uint, storeID uint, orderType int, deliveryTime *time.Time, usePoints int64) (*model.Order, error) {
 	if deliveryType != 1 && deliveryType != 2 {
 		return nil, errors.New("非法的配送类型")
 	}
@@ -99,6 +103,7 @@ func (s *OrderService) CreateOrderFromCart(userID uint, deliveryType int, addres
 		PayStatus:      1, // 未付款
 		OrderType:      orderType,
 		DeliveryType:   deliveryType,
+		DeliveryTime:   deliveryTime,
 		AddressInfo:    addressInfo,
 		Remark:         remark,
 		TotalAmount:    decimal.NewFromInt(0),
@@ -123,9 +128,10 @@ func (s *OrderService) CreateOrderFromCart(userID uint, deliveryType int, addres
 			}
 		}
 
-		// 逐项校验库存与价格，并扣减库存
+		// 逐项校验可售库存与价格；库存在订单创建后预占，支付成功时才扣减实物库存
 		var orderItems []model.OrderItem
 		total := decimal.NewFromInt(0)
+		checker := newStockChecker(tx)
 		for _, it := range items {
 			// 刷新商品/sku 以获取最新库存和价格
 			var prod model.Product
@@ -155,20 +161,13 @@ func (s *OrderService) CreateOrderFromCart(userID uint, deliveryType int, addres
 				if sku.ProductID != prod.ID {
 					return errors.New("SKU与商品不匹配")
 				}
-				// 扣减 SKU 库存（乐观锁）
-				res := tx.Model(&model.ProductSku{}).
-					Where("id = ? AND stock >= ?", sku.ID, it.Quantity).
-					Update("stock", gorm.Expr("stock - ?", it.Quantity))
-				if res.Error != nil {
-					return fmt.Errorf("扣减SKU库存失败: %w", res.Error)
-				}
-				if res.RowsAffected == 0 {
-					return fmt.Errorf("SKU库存不足: %s", sku.SkuName)
+				if err := checker.checkSku(sku, it.Quantity); err != nil {
+					return err
 				}
 				price = sku.Price
 			}
 
-			// 如指定门店，则校验并扣减门店库存，并应用可能的门店价格覆盖
+			// 如指定门店，则校验门店可售库存，并应用可能的门店价格覆盖
 			if storeID != 0 {
 				var sp model.StoreProduct
 				if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("store_id = ? AND product_id = ?", storeID, it.ProductID).First(&sp).Error; err != nil {
@@ -177,29 +176,16 @@ func (s *OrderService) CreateOrderFromCart(userID uint, deliveryType int, addres
 					}
 					return err
 				}
-				resSp := tx.Model(&model.StoreProduct{}).
-					Where("id = ? AND stock >= ?", sp.ID, it.Quantity).
-					Update("stock", gorm.Expr("stock - ?", it.Quantity))
-				if resSp.Error != nil {
-					return fmt.Errorf("扣减门店库存失败: %w", resSp.Error)
-				}
-				if resSp.RowsAffected == 0 {
-					return errors.New("门店库存不足")
+				if err := checker.checkStore(sp, it.Quantity); err != nil {
+					return err
 				}
 				if sp.PriceOverride.GreaterThan(decimal.Zero) {
 					price = sp.PriceOverride
 				}
 			}
 
-			// 扣减商品库存（乐观锁）
-			res2 := tx.Model(&model.Product{}).
-				Where("id = ? AND stock >= ?", prod.ID, it.Quantity).
-				Update("stock", gorm.Expr("stock - ?", it.Quantity))
-			if res2.Error != nil {
-				return fmt.Errorf("扣减商品库存失败: %w", res2.Error)
-			}
-			if res2.RowsAffected == 0 {
-				return fmt.Errorf("商品库存不足: %s", prod.Name)
+			if err := checker.checkProduct(prod, it.Quantity); err != nil {
+				return err
 			}
 
 			qty := decimal.NewFromInt(int64(it.Quantity))
@@ -274,13 +260,26 @@ func (s *OrderService) CreateOrderFromCart(userID uint, deliveryType int, addres
 			couponIDForUse = uc.CouponID
 		}
 
+		// 积分抵扣（可选）
+		pointsUsed, pointsDeduction, err := applyOrderPointsDeduction(tx, userID, usePoints, total.Sub(discount))
+		if err != nil {
+			return err
+		}
+		order.PointsUsed = pointsUsed
+		order.PointsDeduction = pointsDeduction
+
 		// 暂不收取运费
-		order.PayAmount = total.Sub(discount)
+		order.PayAmount = total.Sub(discount).Sub(pointsDeduction)
 
 		if err := tx.Create(order).Error; err != nil {
 			return fmt.Errorf("创建订单失败: %w", err)
 		}
 
+		// 占用预约时段（门店未配置时段模板时跳过）
+		if err := reserveOrderSlot(tx, order, time.Now()); err != nil {
+			return err
+		}
+
 		// 写入订单项
 		for i := range orderItems {
 			orderItems[i].OrderID = order.ID
@@ -289,6 +288,19 @@ func (s *OrderService) CreateOrderFromCart(userID uint, deliveryType int, addres
 			return fmt.Errorf("创建订单明细失败: %w", err)
 		}
 
+		// 预占库存，超时未支付由调度任务取消并释放
+		if err := reserveOrderStock(tx, order, orderItems, time.Now()); err != nil {
+			return err
+		}
+
+		if order.PointsUsed > 0 {
+			orderID := order.ID
+			if _, err := postPointsTx(tx, userID, -order.PointsUsed, model.PointsTxOrderDeduct,
+				fmt.Sprintf("订单%s积分抵扣", order.OrderNo), &orderID, fmt.Sprintf("order_deduct:%d", order.ID)); err != nil {
+				return err
+			}
+		}
+
 		// 清空购物车
 		if err := tx.Where("cart_id = ?", cart.ID).Delete(&model.CartItem{}).Error; err != nil {
 			return fmt.Errorf("清空购物车失败: %w", err)
@@ -377,44 +389,46 @@ func (s *OrderService) CancelOrder(userID, orderID uint, reason string) error {
 	if order.Status != 1 {
 		return errors.New("当前状态不可取消")
 	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return cancelUnpaidOrder(tx, &order, reason, StockReleaseCancel, time.Now())
+	})
+}
 
-	var items []model.OrderItem
-	if err := s.db.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
-		return err
+// cancelUnpaidOrder 取消待付款订单：条件更新订单状态后释放库存预占与预约时段
+// 以 status=1 为条件更新，避免与支付回调或超时取消并发时重复处理。
+func cancelUnpaidOrder(tx *gorm.DB, order *model.Order, reason, releaseReason string, now time.Time) error {
+	res := tx.Model(&model.Order{}).Where("id = ? AND status = ?", order.ID, 1).
+		Updates(map[string]any{"status": 5, "cancelled_at": now, "cancel_reason": reason})
+	if res.Error != nil {
+		return res.Error
 	}
-	// 回补库存（逐条更新，避免事务在 SQLite 上长时间锁表）
-	for _, it := range items {
-		if it.SkuID != nil {
-			if err := s.db.Model(&model.ProductSku{}).Where("id = ?", *it.SkuID).
-				Update("stock", gorm.Expr("stock + ?", it.Quantity)).Error; err != nil {
-				return err
-			}
-		}
-		if err := s.db.Model(&model.Product{}).Where("id = ?", it.ProductID).
-			Update("stock", gorm.Expr("stock + ?", it.Quantity)).Error; err != nil {
-			return err
-		}
-		// 如订单绑定了门店，回补门店库存
-		if order.StoreID != 0 {
-			if err := s.db.Model(&model.StoreProduct{}).
-				Where("store_id = ? AND product_id = ?", order.StoreID, it.ProductID).
-				Update("stock", gorm.Expr("stock + ?", it.Quantity)).Error; err != nil {
-				return err
-			}
-		}
+	if res.RowsAffected == 0 {
+		return errors.New("当前状态不可取消")
 	}
-
-	now := time.Now()
 	order.Status = 5
 	order.CancelledAt = &now
 	order.CancelReason = reason
-	if err := s.db.Save(&order).Error; err != nil {
+	if err := releaseOrderStock(tx, order, releaseReason, true, now); err != nil {
 		return err
 	}
-	return nil
+	// 退回组合支付中已预扣的余额
+	if err := releaseBalanceHold(tx, order.ID, reason); err != nil {
+		return err
+	}
+	// 退还抵扣积分；积分兑换订单同时退回兑换库存
+	if err := returnCancelledOrderPointsTx(tx, order); err != nil {
+		return err
+	}
+	if order.OrderType == OrderTypePointsMall {
+		if err := cancelPointsExchangeTx(tx, order.ID, now); err != nil {
+			return err
+		}
+	}
+	// 释放预约时段
+	return releaseOrderSlot(tx, order.ID)
 }
 
-// AdminCancelOrder 管理端取消订单（需权限），仅允许取消待付款订单；执行库存回补
+// AdminCancelOrder 管理端取消订单（需权限），仅允许取消待付款订单；释放库存预占
 func (s *OrderService) AdminCancelOrder(orderID uint, reason string) error {
 	var order model.Order
 	if err := s.db.First(&order, orderID).Error; err != nil {
@@ -426,40 +440,9 @@ func (s *OrderService) AdminCancelOrder(orderID uint, reason string) error {
 	if order.Status != 1 {
 		return errors.New("当前状态不可取消")
 	}
-
-	var items []model.OrderItem
-	if err := s.db.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
-		return err
-	}
-	// 回补库存
-	for _, it := range items {
-		if it.SkuID != nil {
-			if err := s.db.Model(&model.ProductSku{}).Where("id = ?", *it.SkuID).
-				Update("stock", gorm.Expr("stock + ?", it.Quantity)).Error; err != nil {
-				return err
-			}
-		}
-		if err := s.db.Model(&model.Product{}).Where("id = ?", it.ProductID).
-			Update("stock", gorm.Expr("stock + ?", it.Quantity)).Error; err != nil {
-			return err
-		}
-		if order.StoreID != 0 {
-			if err := s.db.Model(&model.StoreProduct{}).
-				Where("store_id = ? AND product_id = ?", order.StoreID, it.ProductID).
-				Update("stock", gorm.Expr("stock + ?", it.Quantity)).Error; err != nil {
-				return err
-			}
-		}
-	}
-
-	now := time.Now()
-	order.Status = 5
-	order.CancelledAt = &now
-	order.CancelReason = reason
-	if err := s.db.Save(&order).Error; err != nil {
-		return err
-	}
-	return nil
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return cancelUnpaidOrder(tx, &order, reason, StockReleaseCancel, time.Now())
+	})
 }
 
 // AdminAdjustPayAmount 管理端调价（需权限）
@@ -481,6 +464,11 @@ func (s *OrderService) AdminAdjustPayAmount(orderID uint, newPayAmount decimal.D
 		if order.Status != 1 || order.PayStatus != 1 {
 			return errors.New("仅支持未支付的待付款订单调价")
 		}
+		if hold, err := pendingBalanceHold(tx, order.ID); err != nil {
+			return err
+		} else if hold != nil {
+			return errors.New("订单存在未完成的组合支付，暂不可调价")
+		}
 
 		order.PayAmount = newPayAmount
 		return tx.Save(&order).Error
@@ -497,27 +485,15 @@ func generateOrderNo(prefix string) string {
 	return fmt.Sprintf("%s%s%s", prefix, ts, uid)
 }
 
-// MarkPaid 模拟支付成功：仅待付款可支付
+// MarkPaid 模拟支付成功（仅供开发环境，由 handler 校验运行环境）：
+// 创建模拟支付单并走支付回调，与真实支付一致经 markOrderPaidTx 完成余额预扣确认、库存提交与打印
 func (s *OrderService) MarkPaid(userID, orderID uint) error {
-	var order model.Order
-	if err := s.db.First(&order, orderID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("订单不存在")
-		}
+	ps := &PaymentService{db: s.db}
+	pay, _, err := ps.CreateIntent(userID, orderID, PayMethodWechat)
+	if err != nil {
 		return err
 	}
-	// 支付只能由订单所属用户执行
-	if order.UserID != userID {
-		return errors.New("无权操作该订单")
-	}
-	if order.Status != 1 || order.PayStatus != 1 {
-		return errors.New("当前状态不可支付")
-	}
-	now := time.Now()
-	order.Status = 2    // 已付款
-	order.PayStatus = 2 // 已付款
-	order.PaidAt = &now
-	return s.db.Save(&order).Error
+	return ps.MockCallback(pay.PaymentNo)
 }
 
 // StartDelivery 发货：仅已付款可发货
@@ -550,10 +526,7 @@ func (s *OrderService) Complete(userID, orderID uint) error {
 	if order.Status != 3 {
 		return errors.New("当前状态不可完成")
 	}
-	now := time.Now()
-	order.Status = 4
-	order.CompletedAt = &now
-	return s.db.Save(&order).Error
+	return s.completeOrder(&order, 3, false)
 }
 
 // Receive 用户确认收货/完成订单：
@@ -583,176 +556,62 @@ func (s *OrderService) Receive(userID, orderID uint) error {
 	default:
 		return errors.New("非法的配送类型")
 	}
-	now := time.Now()
-	order.Status = 4
-	order.CompletedAt = &now
-	return s.db.Save(&order).Error
+	return s.completeOrder(&order, order.Status, true)
 }
 
-// AdminRefundOrder 管理端手动退款（需权限）
-// 规则：
-// - 仅在 PayStatus=2(已付款) 时可退款
-// - 允许状态为 已付款(2) 或 配送中(3)
-// - 若状态为 已付款(2)（未发货），执行库存回补；若已配送中(3)，不回补库存
-// - 将订单状态置为 已取消(5)，支付状态置为 已退款(4)
-func (s *OrderService) AdminRefundOrder(orderID uint, reason string) error {
-	var order model.Order
-	if err := s.db.First(&order, orderID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("订单不存在")
+// completeOrder 订单由 from 状态置为已完成并在同一事务内发放积分、按推荐关系生成佣金（充值与积分兑换订单不分佣）。
+// 状态按条件更新，并发完成同一订单时只有一方生效，积分与佣金不重复发放。
+// 积分与佣金只按已确认的支付单发放；requirePayment 为 true（用户确认收货）时，没有支付记录的订单不可完成
+func (s *OrderService) completeOrder(order *model.Order, from int, requirePayment bool) error {
+	now := time.Now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		paid, err := orderPaymentConfirmed(tx, order.ID)
+		if err != nil {
+			return err
 		}
-		return err
-	}
-	if order.PayStatus != 2 {
-		return errors.New("当前支付状态不可退款")
-	}
-	if order.Status != 2 && order.Status != 3 {
-		return errors.New("当前状态不可退款")
-	}
-
-	if order.Status == 2 { // 未发货，回补库存
-		var items []model.OrderItem
-		if err := s.db.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		if !paid && requirePayment && order.OrderType != OrderTypePointsMall {
+			return errors.New("订单未查询到支付记录，不可确认收货")
+		}
+		res := tx.Model(&model.Order{}).Where("id = ? AND status = ?", order.ID, from).
+			Updates(map[string]any{"status": 4, "completed_at": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errors.New("订单状态已变更，请刷新后重试")
+		}
+		order.Status = 4
+		order.CompletedAt = &now
+		if err := earnOrderPointsTx(tx, order, now); err != nil {
 			return err
 		}
-		for _, it := range items {
-			if it.SkuID != nil {
-				if err := s.db.Model(&model.ProductSku{}).Where("id = ?", *it.SkuID).
-					Update("stock", gorm.Expr("stock + ?", it.Quantity)).Error; err != nil {
-					return err
-				}
-			}
-			if err := s.db.Model(&model.Product{}).Where("id = ?", it.ProductID).
-				Update("stock", gorm.Expr("stock + ?", it.Quantity)).Error; err != nil {
-				return err
-			}
-			if order.StoreID != 0 {
-				if err := s.db.Model(&model.StoreProduct{}).
-					Where("store_id = ? AND product_id = ?", order.StoreID, it.ProductID).
-					Update("stock", gorm.Expr("stock + ?", it.Quantity)).Error; err != nil {
-					return err
-				}
-			}
+		if !paid || order.OrderType == OrderTypeRecharge || order.OrderType == OrderTypePointsMall {
+			return nil
 		}
-	}
-
-	// 标记为已退款+已取消
-	now := time.Now()
-	order.Status = 5
-	order.PayStatus = 4
-	order.CancelledAt = &now
-	if reason != "" {
-		order.CancelReason = reason
-	}
-	if err := s.db.Save(&order).Error; err != nil {
+		_, err = commission.CreateOrderCommissionsTx(tx, order, config.Config.Finance.CommissionRelease.HoldDays)
 		return err
-	}
+	})
+}
 
-	// 回滚已使用的优惠券（如有）
-	var uc model.UserCoupon
-	if err := s.db.Where("order_id = ? AND status = 2", order.ID).First(&uc).Error; err == nil {
-		if err2 := s.db.Model(&model.UserCoupon{}).Where("id = ?", uc.ID).
-			Updates(map[string]any{"status": 1, "used_at": nil, "order_id": nil}).Error; err2 != nil {
-			return err2
-		}
-		// 安全递减已使用计数
-		if err2 := s.db.Model(&model.Coupon{}).Where("id = ? AND used_count > 0", uc.CouponID).
-			Update("used_count", gorm.Expr("used_count - 1")).Error; err2 != nil {
-			return err2
-		}
-	}
-	return nil
+// AdminRefundOrder 管理端手动退款（需权限）
+// 规则：
+// - 仅在 PayStatus=2(已付款) 时可退款
+// - 允许状态为 已付款(2)、配送中(3) 或 已完成(4，售后退款)
+// - 按支付单原路退款（余额部分退回钱包），订单先置为退款中(3)
+// - 渠道确认全部退款成功后，订单置为 已取消(5)/已退款(4)；未发货(2)回补库存，配送中(3)与已完成(4)不回补
+// - 已完成订单按退款比例扣回积分并回滚未提现佣金
+func (s *OrderService) AdminRefundOrder(orderID uint, reason string) error {
+	return (&PaymentService{db: s.db}).StartOrderRefund(orderID, reason)
 }
 
-// AdminRefundStart 标记退款中
+// AdminRefundStart 发起退款：标记退款中并向支付渠道提交退款
 func (s *OrderService) AdminRefundStart(orderID uint, reason string) error {
-	var order model.Order
-	if err := s.db.First(&order, orderID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("订单不存在")
-		}
-		return err
-	}
-	if order.PayStatus != 2 {
-		return errors.New("当前支付状态不可标记退款")
-	}
-	if order.Status != 2 && order.Status != 3 {
-		return errors.New("当前状态不可标记退款")
-	}
-	order.PayStatus = 3 // 退款中
-	if reason != "" {
-		order.CancelReason = reason
-	}
-	return s.db.Save(&order).Error
+	return (&PaymentService{db: s.db}).StartOrderRefund(orderID, reason)
 }
 
-// AdminRefundConfirm 确认退款完成
+// AdminRefundConfirm 确认退款完成：同步渠道退款结果，全部退款成功后完成订单退款
 func (s *OrderService) AdminRefundConfirm(orderID uint, reason string) error {
-	var order model.Order
-	if err := s.db.First(&order, orderID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("订单不存在")
-		}
-		return err
-	}
-	if order.PayStatus != 3 {
-		return errors.New("未处于退款中状态")
-	}
-	if order.Status != 2 && order.Status != 3 {
-		return errors.New("当前状态不可确认退款")
-	}
-
-	// 若未发货则回补库存
-	if order.Status == 2 {
-		var items []model.OrderItem
-		if err := s.db.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
-			return err
-		}
-		for _, it := range items {
-			if it.SkuID != nil {
-				if err := s.db.Model(&model.ProductSku{}).Where("id = ?", *it.SkuID).
-					Update("stock", gorm.Expr("stock + ?", it.Quantity)).Error; err != nil {
-					return err
-				}
-			}
-			if err := s.db.Model(&model.Product{}).Where("id = ?", it.ProductID).
-				Update("stock", gorm.Expr("stock + ?", it.Quantity)).Error; err != nil {
-				return err
-			}
-			if order.StoreID != 0 {
-				if err := s.db.Model(&model.StoreProduct{}).
-					Where("store_id = ? AND product_id = ?", order.StoreID, it.ProductID).
-					Update("stock", gorm.Expr("stock + ?", it.Quantity)).Error; err != nil {
-					return err
-				}
-			}
-		}
-	}
-
-	// 完成退款
-	now := time.Now()
-	order.Status = 5
-	order.PayStatus = 4
-	order.CancelledAt = &now
-	if reason != "" {
-		order.CancelReason = reason
-	}
-	if err := s.db.Save(&order).Error; err != nil {
-		return err
-	}
-	// 回滚优惠券
-	var uc model.UserCoupon
-	if err := s.db.Where("order_id = ? AND status = 2", order.ID).First(&uc).Error; err == nil {
-		if err2 := s.db.Model(&model.UserCoupon{}).Where("id = ?", uc.ID).
-			Updates(map[string]any{"status": 1, "used_at": nil, "order_id": nil}).Error; err2 != nil {
-			return err2
-		}
-		if err2 := s.db.Model(&model.Coupon{}).Where("id = ? AND used_count > 0", uc.CouponID).
-			Update("used_count", gorm.Expr("used_count - 1")).Error; err2 != nil {
-			return err2
-		}
-	}
-	return nil
+	return (&PaymentService{db: s.db}).SyncOrderRefund(orderID)
 }
 
 // StoreOrderStats 门店订单统计结果
@@ -1,14 +1,18 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
@@ -18,9 +22,21 @@ import (
 	"tea-api/pkg/utils"
 )
 
-type PaymentService struct{ db *gorm.DB }
+type PaymentService struct {
+	db       *gorm.DB
+	provider func(method int) (PaymentProvider, error)
+}
 
-func NewPaymentService() *PaymentService { return &PaymentService{db: database.GetDB()} }
+func NewPaymentService() *PaymentService {
+	return &PaymentService{db: database.GetDB(), provider: NewPaymentProvider}
+}
+
+func (s *PaymentService) providerFor(method int) (PaymentProvider, error) {
+	if s.provider == nil {
+		return NewPaymentProvider(method)
+	}
+	return s.provider(method)
+}
 
 // UnifiedOrderResult 描述统一下单结果，供前端/小程序拉起支付
 type UnifiedOrderResult struct {
@@ -34,6 +50,10 @@ type UnifiedOrderResult struct {
 	Package   string          `json:"package"`
 	Sign      string          `json:"sign"`
 	PayURL    string          `json:"pay_url"`
+	AppID     string          `json:"app_id"`
+	SignType  string          `json:"sign_type"`
+	PaySign   string          `json:"pay_sign"`
+	Provider  string          `json:"provider"`
 }
 
 // PaymentCallbackPayload 为第三方支付回调封装的参数
@@ -46,6 +66,16 @@ type PaymentCallbackPayload struct {
 	RawBody       string
 	TestMode      bool
 	SkipVerify    bool
+	AmountCents   int64  // 渠道实付金额（分），非 0 时与支付单金额核对
+	Method        int    // 通知来源渠道，非 0 时与支付单的支付方式核对
+	Nonce         string // 回调随机串，用于重放防护
+	Timestamp     int64  // 回调发送时间（Unix 秒），非 0 时校验时间窗口
+}
+
+// UnifiedOrderOptions 统一下单的渠道参数
+type UnifiedOrderOptions struct {
+	TradeType string // 支付宝：MINI（默认）/ H5
+	BuyerID   string // 支付宝小程序 buyer_id；微信使用用户绑定的 openid
 }
 
 // CreateIntent 创建支付意图（模拟）
@@ -63,12 +93,24 @@ func (s *PaymentService) CreateIntent(userID, orderID uint, method int) (*model.
 	if order.Status != 1 || order.PayStatus != 1 {
 		return nil, "", errors.New("订单当前不可创建支付")
 	}
+	if method == PayMethodBalance {
+		return nil, "", errors.New("余额支付请使用余额支付接口")
+	}
+	// 组合支付：外部渠道只需支付扣除余额预扣后的部分
+	amount := order.PayAmount
+	hold, err := pendingBalanceHold(s.db, order.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if hold != nil {
+		amount = amount.Sub(hold.Amount)
+	}
 
 	var existing model.Payment
 	if err := s.db.Where("order_id = ? AND payment_method = ? AND status = 1", order.ID, method).
-		Order("id desc").First(&existing).Error; err == nil {
+		Order("id desc").First(&existing).Error; err == nil && existing.Amount.Equal(amount) {
 		return &existing, fmt.Sprintf("mockpay://%s", existing.PaymentNo), nil
-	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+	} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, "", err
 	}
 
@@ -76,7 +118,7 @@ func (s *PaymentService) CreateIntent(userID, orderID uint, method int) (*model.
 		OrderID:       order.ID,
 		PaymentNo:     generatePaymentNo("P"),
 		PaymentMethod: method,
-		Amount:        order.PayAmount,
+		Amount:        amount,
 		Status:        1, // 待支付
 	}
 	if err := s.db.Create(pay).Error; err != nil {
@@ -87,33 +129,96 @@ func (s *PaymentService) CreateIntent(userID, orderID uint, method int) (*model.
 	return pay, payURL, nil
 }
 
-// UnifiedOrder 模拟微信统一下单，返回前端拉起支付所需参数
-func (s *PaymentService) UnifiedOrder(userID, orderID uint, method int) (*UnifiedOrderResult, error) {
-	pay, payURL, err := s.CreateIntent(userID, orderID, method)
+// UnifiedOrder 统一下单：创建支付单后调用支付渠道下单，返回前端拉起支付所需参数
+func (s *PaymentService) UnifiedOrder(userID, orderID uint, method int, opts UnifiedOrderOptions) (*UnifiedOrderResult, error) {
+	provider, err := s.providerFor(method)
 	if err != nil {
 		return nil, err
 	}
-	nonce := utils.GenerateRandomString(18)
-	timestamp := time.Now().Unix()
-	prepayID := fmt.Sprintf("prepay_%s", pay.PaymentNo)
-	packageStr := "Sign=WXPay"
-	secret := config.Config.WeChat.APIKey
-	signPayload := fmt.Sprintf("%s|%s|%d|%s", prepayID, nonce, timestamp, secret)
-	sign := strings.ToUpper(utils.MD5Hash(signPayload))
+	pay, _, err := s.CreateIntent(userID, orderID, method)
+	if err != nil {
+		return nil, err
+	}
+	var order model.Order
+	if err := s.db.First(&order, pay.OrderID).Error; err != nil {
+		return nil, err
+	}
+	var user model.User
+	if err := s.db.Select("id", "open_id").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	req := PayCreateRequest{
+		PaymentNo:   pay.PaymentNo,
+		Description: fmt.Sprintf("订单%s", order.OrderNo),
+		AmountCents: yuanDecimalToCents(pay.Amount),
+		OpenID:      user.OpenID,
+		TradeType:   opts.TradeType,
+	}
+	if method == PayMethodAlipay {
+		req.OpenID = opts.BuyerID
+	}
+	// 支付截止时间与库存预占一致，超时后订单会被自动取消
+	if expireAt := order.CreatedAt.Add(stockReserveTTL()); expireAt.After(time.Now()) {
+		req.ExpireAt = &expireAt
+	}
+	created, err := provider.CreatePayment(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	params := created.ClientParams
+	timestamp, _ := strconv.ParseInt(params["timeStamp"], 10, 64)
 	return &UnifiedOrderResult{
 		PaymentNo: pay.PaymentNo,
 		OrderID:   pay.OrderID,
 		Method:    pay.PaymentMethod,
 		Amount:    pay.Amount,
-		PrepayID:  prepayID,
-		NonceStr:  nonce,
+		PrepayID:  created.PrepayID,
+		NonceStr:  params["nonceStr"],
 		Timestamp: timestamp,
-		Package:   packageStr,
-		Sign:      sign,
-		PayURL:    payURL,
+		Package:   params["package"],
+		Sign:      params["paySign"],
+		PayURL:    created.PayURL,
+		AppID:     params["appId"],
+		SignType:  params["signType"],
+		PaySign:   params["paySign"],
+		Provider:  provider.Name(),
 	}, nil
 }
 
+// HandleProviderNotify 处理支付渠道异步通知：由渠道验签解密后，按支付结果走 HandleCallback 同一事务更新支付单与订单
+func (s *PaymentService) HandleProviderNotify(ctx context.Context, method int, header http.Header, body []byte) error {
+	provider, err := s.providerFor(method)
+	if err != nil {
+		return err
+	}
+	notify, err := provider.ParseNotify(ctx, header, body)
+	if err != nil {
+		return err
+	}
+	return s.applyProviderNotify(method, notify)
+}
+
+// applyProviderNotify 按已验签的渠道通知更新支付单/退款单
+func (s *PaymentService) applyProviderNotify(method int, notify *PayNotifyResult) error {
+	if notify.Kind == PayNotifyRefund {
+		return s.handleRefundNotify(method, notify)
+	}
+	if notify.TradeState == "NOTPAY" || notify.TradeState == "USERPAYING" {
+		// 交易创建等中间状态不影响支付单
+		return nil
+	}
+	return s.HandleCallback(PaymentCallbackPayload{
+		PaymentNo:     notify.PaymentNo,
+		TransactionID: notify.TransactionID,
+		TradeState:    notify.TradeState,
+		PaidAt:        notify.PaidAt,
+		RawBody:       notify.Raw,
+		SkipVerify:    true, // 渠道层已完成验签
+		AmountCents:   notify.AmountCents,
+		Method:        method,
+	})
+}
+
 // MockCallback 模拟第三方回调，根据 payment_no 标记支付成功并更新订单
 func (s *PaymentService) MockCallback(paymentNo string) error {
 	payload := PaymentCallbackPayload{
@@ -134,41 +239,15 @@ func (s *PaymentService) HandleCallback(payload PaymentCallbackPayload) error {
 		return errors.New("trade_state 不能为空")
 	}
 	if !payload.SkipVerify {
-		secret := config.Config.WeChat.APIKey
-		// 严格 HMAC 路径：规范化 JSON（移除 sign，按 key 排序，紧凑编码）后计算 HMAC
-		var obj map[string]any
-		if err := json.Unmarshal([]byte(payload.RawBody), &obj); err != nil {
-			return fmt.Errorf("invalid json body: %v", err)
-		}
-		delete(obj, "sign")
-		keys := make([]string, 0, len(obj))
-		for k := range obj {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-		var bldr strings.Builder
-		bldr.WriteString("{")
-		for i, k := range keys {
-			v := obj[k]
-			val, _ := json.Marshal(v)
-			bldr.WriteString("\"")
-			bldr.WriteString(k)
-			bldr.WriteString("\":")
-			bldr.Write(val)
-			if i < len(keys)-1 {
-				bldr.WriteString(",")
-			}
-		}
-		bldr.WriteString("}")
-		canonical := bldr.String()
-		hmacExpected := utils.HMACSHA256Hex(secret, canonical)
-		// 兼容旧版：MD5(payment_no|trade_state|secret) 大写
-		legacyExpected := strings.ToUpper(utils.MD5Hash(fmt.Sprintf("%s|%s|%s", payload.PaymentNo, payload.TradeState, secret)))
-		if payload.Sign == "" || (payload.Sign != hmacExpected && payload.Sign != legacyExpected) {
-			return fmt.Errorf("签名校验失败: expected=%s got=%s", hmacExpected, payload.Sign)
+		if err := verifyCallbackSign(payload); err != nil {
+			return err
 		}
 	}
-	return s.db.Transaction(func(tx *gorm.DB) error {
+	var (
+		paidOrderID uint
+		lateRefund  *model.Refund
+	)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
 		var pay model.Payment
 		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("payment_no = ?", payload.PaymentNo).First(&pay).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -177,6 +256,14 @@ func (s *PaymentService) HandleCallback(payload PaymentCallbackPayload) error {
 			return err
 		}
 
+		if payload.Method != 0 && pay.PaymentMethod != payload.Method {
+			return errors.New("支付渠道与支付单不一致")
+		}
+		// 已支付成功（含关单后支付）的支付单不再被迟到的失败/关闭通知覆盖
+		if pay.Status == 2 || pay.Status == model.PaymentStatusPaidAfterClose {
+			return nil
+		}
+
 		now := time.Now()
 		pay.ThirdPayNo = payload.TransactionID
 		pay.ThirdResponse = payload.RawBody
@@ -187,45 +274,188 @@ func (s *PaymentService) HandleCallback(payload PaymentCallbackPayload) error {
 			if err := tx.Save(&pay).Error; err != nil {
 				return err
 			}
-			return nil
+			// 组合支付的外部部分失败且没有其他待支付的外部支付单时，退回余额预扣
+			var otherPending int64
+			if err := tx.Model(&model.Payment{}).Where("order_id = ? AND status = 1 AND payment_method <> ?", pay.OrderID, PayMethodBalance).
+				Count(&otherPending).Error; err != nil {
+				return err
+			}
+			if otherPending > 0 {
+				return nil
+			}
+			return releaseBalanceHold(tx, pay.OrderID, "外部渠道支付失败")
 		}
 
-		if pay.Status == 2 {
-			return nil
+		if payload.AmountCents != 0 && payload.AmountCents != yuanDecimalToCents(pay.Amount) {
+			return errors.New("支付金额与支付单不一致")
 		}
 
 		paidAt := payload.PaidAt
 		if paidAt == nil {
 			paidAt = &now
 		}
-		pay.Status = 2
 		pay.PaidAt = paidAt
-		if err := tx.Save(&pay).Error; err != nil {
+
+		var order model.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, pay.OrderID).Error; err != nil {
 			return err
 		}
+		// 订单已超时关闭、取消或已由其他支付单完成支付：库存、余额预扣、积分与时段均已释放，
+		// 不再恢复订单，支付单记为关单后支付并原路退款
+		if order.Status != 1 || order.PayStatus != 1 {
+			r, err := refundPaidAfterCloseTx(tx, &pay, &order)
+			if err != nil {
+				return err
+			}
+			lateRefund = r
+			return nil
+		}
 
-		var order model.Order
-		if err := tx.First(&order, pay.OrderID).Error; err != nil {
+		pay.Status = 2
+		if err := tx.Save(&pay).Error; err != nil {
 			return err
 		}
-		order.Status = 2
-		order.PayStatus = 2
-		order.PaidAt = paidAt
-		if err := tx.Save(&order).Error; err != nil {
+		if err := confirmBalanceHold(tx, pay.OrderID, paidAt); err != nil {
 			return err
 		}
-
-		// 若该订单关联活动报名记录，则将报名状态从「已报名」更新为「已支付报名」
-		// 测试环境可能不存在该表，先探测再更新
-		if tx.Migrator().HasTable(&model.ActivityRegistration{}) {
-			if err := tx.Model(&model.ActivityRegistration{}).
-				Where("order_id = ? AND status = ?", order.ID, 1).
-				Update("status", 2).Error; err != nil {
-				return err
-			}
+		if err := markOrderPaidTx(tx, &order, paidAt, now); err != nil {
+			return err
+		}
+		if err := postExternalPaymentTx(tx, &pay, &order); err != nil {
+			return err
+		}
+		if order.OrderType != OrderTypeRecharge {
+			paidOrderID = order.ID
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	if lateRefund != nil {
+		// 提交失败时退款单已标记失败并安排重试，由退款重试任务继续处理
+		if err := s.submitRefund(context.Background(), lateRefund); err != nil {
+			zap.L().Warn("submit paid-after-close refund failed", zap.String("refund_no", lateRefund.RefundNo), zap.Error(err))
+		}
+		return nil
+	}
+	// 事务提交后再触发自动打印，避免打印到未落库的支付状态
+	TriggerOrderPaidPrint(paidOrderID)
+	return nil
+}
+
+// refundPaidAfterCloseTx 订单关闭后才支付成功的支付单：记为关单后支付并入账渠道收款，
+// 同时生成全额退款单，由调用方在事务提交后提交渠道退款
+func refundPaidAfterCloseTx(tx *gorm.DB, pay *model.Payment, order *model.Order) (*model.Refund, error) {
+	pay.Status = model.PaymentStatusPaidAfterClose
+	if err := tx.Save(pay).Error; err != nil {
+		return nil, err
+	}
+	if err := postExternalPaymentTx(tx, pay, order); err != nil {
+		return nil, err
+	}
+	r := &model.Refund{
+		OrderID:      order.ID,
+		PaymentID:    pay.ID,
+		RefundNo:     generatePaymentNo("R"),
+		RefundAmount: pay.Amount,
+		RefundReason: "订单已关闭，支付自动退回",
+		Status:       model.RefundStatusProcessing,
+	}
+	if err := tx.Create(r).Error; err != nil {
+		return nil, err
+	}
+	zap.L().Warn("payment succeeded after order closed, refunding",
+		zap.String("payment_no", pay.PaymentNo), zap.Uint("order_id", order.ID), zap.Int("order_status", order.Status))
+	return r, nil
+}
+
+// verifyCallbackSign 校验 JSON 回调签名：规范化报文的 HMAC-SHA256，未关闭旧版签名时兼容 MD5
+func verifyCallbackSign(payload PaymentCallbackPayload) error {
+	secret := config.Config.WeChat.APIKey
+	// 严格 HMAC 路径：规范化 JSON（移除 sign，按 key 排序，紧凑编码）后计算 HMAC
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(payload.RawBody), &obj); err != nil {
+		return fmt.Errorf("invalid json body: %v", err)
+	}
+	delete(obj, "sign")
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var bldr strings.Builder
+	bldr.WriteString("{")
+	for i, k := range keys {
+		v := obj[k]
+		val, _ := json.Marshal(v)
+		bldr.WriteString("\"")
+		bldr.WriteString(k)
+		bldr.WriteString("\":")
+		bldr.Write(val)
+		if i < len(keys)-1 {
+			bldr.WriteString(",")
+		}
+	}
+	bldr.WriteString("}")
+	canonical := bldr.String()
+	hmacExpected := utils.HMACSHA256Hex(secret, canonical)
+	if payload.Sign != "" && payload.Sign == hmacExpected {
+		return nil
+	}
+	// 兼容旧版：MD5(payment_no|trade_state|secret) 大写；旧版签名不覆盖 nonce/timestamp，可通过配置关闭
+	if !config.Config.Payment.DisableLegacySign && payload.Sign != "" {
+		legacyExpected := strings.ToUpper(utils.MD5Hash(fmt.Sprintf("%s|%s|%s", payload.PaymentNo, payload.TradeState, secret)))
+		if payload.Sign == legacyExpected {
+			return nil
+		}
+	}
+	// 不回显期望签名，避免被用来伪造回调
+	return errors.New("签名校验失败")
+}
+
+// markOrderPaidTx 在支付事务内将订单标记为已支付：提交库存预占，充值到账/积分兑换履约，并同步活动报名状态
+func markOrderPaidTx(tx *gorm.DB, order *model.Order, paidAt *time.Time, now time.Time) error {
+	// 已关闭的订单其库存、余额预扣、积分与时段均已释放，不可再恢复为已支付
+	if order.Status == 5 {
+		return errors.New("订单已关闭，不可标记为已支付")
+	}
+	order.Status = 2
+	order.PayStatus = 2
+	order.PaidAt = paidAt
+	if err := tx.Save(order).Error; err != nil {
+		return err
+	}
+	if err := commitOrderStock(tx, order.ID, now); err != nil {
+		return err
+	}
+	if order.OrderType == OrderTypeRecharge {
+		return creditRechargeTx(tx, order, now)
+	}
+	if order.OrderType == OrderTypePointsMall {
+		return fulfillPointsExchangeTx(tx, order, now)
+	}
+
+	// 若该订单关联活动报名记录，则将报名状态从「已报名」更新为「已支付报名」
+	// 测试环境可能不存在该表，先探测再更新
+	if tx.Migrator().HasTable(&model.ActivityRegistration{}) {
+		if err := tx.Model(&model.ActivityRegistration{}).
+			Where("order_id = ? AND status = ?", order.ID, 1).
+			Update("status", 2).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderPaymentConfirmed 订单是否有已确认的实际收款（渠道支付成功或余额支付确认，status=2）。
+// 积分与佣金只按真实收款发放，未留下支付单的"已付款"订单不计
+func orderPaymentConfirmed(tx *gorm.DB, orderID uint) (bool, error) {
+	var n int64
+	if err := tx.Model(&model.Payment{}).Where("order_id = ? AND status = ? AND amount > 0", orderID, 2).Count(&n).Error; err != nil {
+		return false, err
+	}
+	return n > 0, nil
 }
 
 func generatePaymentNo(prefix string) string {
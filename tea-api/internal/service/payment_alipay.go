@@ -0,0 +1,497 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"tea-api/internal/config"
+)
+
+const (
+	alipayDefaultGateway = "https://openapi.alipay.com/gateway.do"
+	alipayTimeLayout     = "2006-01-02 15:04:05"
+	alipayCodeSuccess    = "10000"
+)
+
+// 支付宝下单方式
+const (
+	AlipayTradeMini = "MINI" // 小程序：alipay.trade.create，需 buyer_id
+	AlipayTradeWap  = "H5"   // 手机网站：alipay.trade.wap.pay，返回跳转链接
+)
+
+// AlipayProvider 支付宝开放平台渠道（RSA2）
+// 请求参数使用应用私钥签名；应答与异步通知使用支付宝公钥验签。
+type AlipayProvider struct {
+	GatewayURL string
+	AppID      string
+	NotifyURL  string
+	ReturnURL  string
+	PrivateKey *rsa.PrivateKey
+	PublicKey  *rsa.PublicKey // 支付宝公钥
+	Client     *http.Client
+	now        func() time.Time
+}
+
+// NewAlipayProvider 根据配置加载应用私钥与支付宝公钥（支持 PEM 或开放平台导出的裸 base64）
+func NewAlipayProvider(cfg config.Alipay) (*AlipayProvider, error) {
+	if cfg.AppID == "" {
+		return nil, errors.New("支付宝未配置 app_id")
+	}
+	priv, err := parseAlipayPrivateKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := parseAlipayPublicKey(cfg.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	p := &AlipayProvider{
+		GatewayURL: cfg.GatewayURL,
+		AppID:      cfg.AppID,
+		NotifyURL:  cfg.NotifyURL,
+		ReturnURL:  cfg.ReturnURL,
+		PrivateKey: priv,
+		PublicKey:  pub,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		now:        time.Now,
+	}
+	if p.GatewayURL == "" {
+		p.GatewayURL = alipayDefaultGateway
+	}
+	return p, nil
+}
+
+func (p *AlipayProvider) Name() string { return "alipay" }
+
+// CreatePayment 小程序走 alipay.trade.create 返回 tradeNO；H5 生成 alipay.trade.wap.pay 跳转链接
+func (p *AlipayProvider) CreatePayment(ctx context.Context, req PayCreateRequest) (*PayCreateResult, error) {
+	if req.AmountCents <= 0 {
+		return nil, errors.New("支付金额必须大于 0")
+	}
+	biz := map[string]any{
+		"out_trade_no": req.PaymentNo,
+		"total_amount": centsToYuan(req.AmountCents),
+		"subject":      req.Description,
+	}
+	if req.ExpireAt != nil {
+		biz["time_expire"] = req.ExpireAt.In(chinaZone()).Format(alipayTimeLayout)
+	}
+	notifyURL := req.NotifyURL
+	if notifyURL == "" {
+		notifyURL = p.NotifyURL
+	}
+	if req.TradeType == AlipayTradeWap {
+		// 手机网站支付由浏览器跳转网关完成，无需服务端请求
+		biz["product_code"] = "QUICK_WAP_WAY"
+		params, err := p.buildParams("alipay.trade.wap.pay", biz, notifyURL)
+		if err != nil {
+			return nil, err
+		}
+		if p.ReturnURL != "" {
+			params.Set("return_url", p.ReturnURL)
+		}
+		if err := p.sign(params); err != nil {
+			return nil, err
+		}
+		return &PayCreateResult{PayURL: p.GatewayURL + "?" + params.Encode(), ClientParams: map[string]string{}}, nil
+	}
+	if req.OpenID == "" {
+		return nil, errors.New("缺少支付宝 buyer_id，无法发起小程序支付")
+	}
+	biz["buyer_id"] = req.OpenID
+	biz["product_code"] = "JSAPI_PAY"
+	var resp struct {
+		TradeNo    string `json:"trade_no"`
+		OutTradeNo string `json:"out_trade_no"`
+	}
+	if _, err := p.call(ctx, "alipay.trade.create", biz, notifyURL, &resp); err != nil {
+		return nil, err
+	}
+	return &PayCreateResult{PrepayID: resp.TradeNo, ClientParams: map[string]string{"tradeNO": resp.TradeNo}}, nil
+}
+
+// QueryPayment 查询交易，交易状态映射为与微信一致的 trade_state
+func (p *AlipayProvider) QueryPayment(ctx context.Context, paymentNo string) (*PayQueryResult, error) {
+	var resp struct {
+		TradeNo     string `json:"trade_no"`
+		OutTradeNo  string `json:"out_trade_no"`
+		TradeStatus string `json:"trade_status"`
+		TotalAmount string `json:"total_amount"`
+		SendPayDate string `json:"send_pay_date"`
+	}
+	raw, err := p.call(ctx, "alipay.trade.query", map[string]any{"out_trade_no": paymentNo}, "", &resp)
+	if err != nil {
+		// 用户未扫码/未登录收银台时支付宝侧尚无交易
+		var ae *alipayError
+		if errors.As(err, &ae) && ae.SubCode == "ACQ.TRADE_NOT_EXIST" {
+			return &PayQueryResult{PaymentNo: paymentNo, TradeState: "NOTPAY", Raw: string(raw)}, nil
+		}
+		return nil, err
+	}
+	return &PayQueryResult{
+		PaymentNo:     resp.OutTradeNo,
+		TransactionID: resp.TradeNo,
+		TradeState:    alipayTradeState(resp.TradeStatus),
+		AmountCents:   yuanToCents(resp.TotalAmount),
+		PaidAt:        parseAlipayTime(resp.SendPayDate),
+		Raw:           string(raw),
+	}, nil
+}
+
+// ClosePayment 关闭未支付交易；支付宝侧无交易时视为已关闭
+func (p *AlipayProvider) ClosePayment(ctx context.Context, paymentNo string) error {
+	_, err := p.call(ctx, "alipay.trade.close", map[string]any{"out_trade_no": paymentNo}, "", nil)
+	var ae *alipayError
+	if errors.As(err, &ae) && ae.SubCode == "ACQ.TRADE_NOT_EXIST" {
+		return nil
+	}
+	return err
+}
+
+// Refund 申请退款；fund_change=Y 表示资金已退回，否则为处理中
+func (p *AlipayProvider) Refund(ctx context.Context, req PayRefundRequest) (*PayRefundResult, error) {
+	biz := map[string]any{
+		"out_trade_no":   req.PaymentNo,
+		"out_request_no": req.RefundNo,
+		"refund_amount":  centsToYuan(req.RefundCents),
+		"refund_reason":  req.Reason,
+	}
+	var resp struct {
+		TradeNo      string `json:"trade_no"`
+		FundChange   string `json:"fund_change"`
+		GmtRefundPay string `json:"gmt_refund_pay"`
+	}
+	raw, err := p.call(ctx, "alipay.trade.refund", biz, "", &resp)
+	if err != nil {
+		return nil, err
+	}
+	res := &PayRefundResult{RefundNo: req.RefundNo, RefundID: resp.TradeNo, Status: "PROCESSING", Raw: string(raw)}
+	if resp.FundChange == "Y" {
+		res.Status = "SUCCESS"
+		res.RefundedAt = parseAlipayTime(resp.GmtRefundPay)
+	}
+	return res, nil
+}
+
+// QueryRefund 查询退款：有返回数据且 refund_status 为空或 REFUND_SUCCESS 表示退款成功，无数据表示退款未受理
+func (p *AlipayProvider) QueryRefund(ctx context.Context, paymentNo, refundNo string) (*PayRefundResult, error) {
+	var resp struct {
+		TradeNo      string `json:"trade_no"`
+		OutRequestNo string `json:"out_request_no"`
+		RefundStatus string `json:"refund_status"`
+		GmtRefundPay string `json:"gmt_refund_pay"`
+	}
+	raw, err := p.call(ctx, "alipay.trade.fastpay.refund.query", map[string]any{
+		"out_trade_no":   paymentNo,
+		"out_request_no": refundNo,
+		"query_options":  []string{"gmt_refund_pay"},
+	}, "", &resp)
+	if err != nil {
+		return nil, err
+	}
+	res := &PayRefundResult{RefundNo: refundNo, RefundID: resp.TradeNo, Status: "CLOSED", Raw: string(raw)}
+	if resp.OutRequestNo != "" && (resp.RefundStatus == "" || resp.RefundStatus == "REFUND_SUCCESS") {
+		res.Status = "SUCCESS"
+		res.RefundedAt = parseAlipayTime(resp.GmtRefundPay)
+	}
+	return res, nil
+}
+
+// ParseNotify 校验异步通知签名（form 表单，sign/sign_type 不参与签名）
+func (p *AlipayProvider) ParseNotify(_ context.Context, _ http.Header, body []byte) (*PayNotifyResult, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("回调报文格式错误: %w", err)
+	}
+	sign := form.Get("sign")
+	if sign == "" {
+		return nil, errors.New("支付宝通知缺少签名")
+	}
+	if err := rsaVerifySHA256(p.PublicKey, alipaySignContent(form, "sign", "sign_type"), sign); err != nil {
+		return nil, errors.New("支付宝通知签名校验失败")
+	}
+	if appID := form.Get("app_id"); appID != "" && appID != p.AppID {
+		return nil, errors.New("支付宝通知 app_id 不匹配")
+	}
+	raw, _ := json.Marshal(flattenForm(form))
+	// 退款成功也会以交易通知推送，携带 out_biz_no（退款请求号）与 refund_fee
+	if form.Get("out_biz_no") != "" || form.Get("refund_fee") != "" {
+		return &PayNotifyResult{
+			Kind:         PayNotifyRefund,
+			PaymentNo:    form.Get("out_trade_no"),
+			RefundNo:     form.Get("out_biz_no"),
+			RefundID:     form.Get("trade_no"),
+			RefundStatus: "SUCCESS",
+			RefundedAt:   parseAlipayTime(form.Get("gmt_refund")),
+			Nonce:        form.Get("notify_id"),
+			NotifyTime:   parseAlipayTime(form.Get("notify_time")),
+			Raw:          string(raw),
+		}, nil
+	}
+	return &PayNotifyResult{
+		Kind:          PayNotifyPayment,
+		PaymentNo:     form.Get("out_trade_no"),
+		TransactionID: form.Get("trade_no"),
+		TradeState:    alipayTradeState(form.Get("trade_status")),
+		AmountCents:   yuanToCents(form.Get("total_amount")),
+		PaidAt:        parseAlipayTime(form.Get("gmt_payment")),
+		Nonce:         form.Get("notify_id"),
+		NotifyTime:    parseAlipayTime(form.Get("notify_time")),
+		Raw:           string(raw),
+	}, nil
+}
+
+type alipayError struct {
+	Code    string
+	Msg     string
+	SubCode string
+	SubMsg  string
+}
+
+func (e *alipayError) Error() string {
+	return fmt.Sprintf("支付宝请求失败: %s %s %s %s", e.Code, e.Msg, e.SubCode, e.SubMsg)
+}
+
+// buildParams 构造公共参数与 biz_content（未签名）
+func (p *AlipayProvider) buildParams(method string, biz map[string]any, notifyURL string) (url.Values, error) {
+	content, err := json.Marshal(biz)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Set("app_id", p.AppID)
+	params.Set("method", method)
+	params.Set("format", "JSON")
+	params.Set("charset", "utf-8")
+	params.Set("sign_type", "RSA2")
+	params.Set("timestamp", p.now().In(chinaZone()).Format(alipayTimeLayout))
+	params.Set("version", "1.0")
+	params.Set("biz_content", string(content))
+	if notifyURL != "" {
+		params.Set("notify_url", notifyURL)
+	}
+	return params, nil
+}
+
+// sign 使用应用私钥 RSA2 签名，sign_type 参与签名
+func (p *AlipayProvider) sign(params url.Values) error {
+	params.Del("sign")
+	sum := sha256.Sum256([]byte(alipaySignContent(params, "sign")))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.PrivateKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return fmt.Errorf("签名失败: %w", err)
+	}
+	params.Set("sign", base64.StdEncoding.EncodeToString(sig))
+	return nil
+}
+
+// call 调用网关接口并校验应答签名，签名覆盖 xxx_response 节点的原始 JSON
+func (p *AlipayProvider) call(ctx context.Context, method string, biz map[string]any, notifyURL string, out any) ([]byte, error) {
+	params, err := p.buildParams(method, biz, notifyURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.sign(params); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.GatewayURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded;charset=utf-8")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求支付宝失败: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("读取支付宝应答失败: %w", err)
+	}
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return raw, fmt.Errorf("解析支付宝应答失败: %w", err)
+	}
+	node, ok := envelope[strings.ReplaceAll(method, ".", "_")+"_response"]
+	if !ok {
+		node, ok = envelope["error_response"]
+	}
+	if !ok {
+		return raw, errors.New("支付宝应答缺少业务节点")
+	}
+	var common struct {
+		Code    string `json:"code"`
+		Msg     string `json:"msg"`
+		SubCode string `json:"sub_code"`
+		SubMsg  string `json:"sub_msg"`
+	}
+	if err := json.Unmarshal(node, &common); err != nil {
+		return raw, fmt.Errorf("解析支付宝应答失败: %w", err)
+	}
+	// 网关级错误（如签名错误）可能不带签名，业务错误与成功应答均需验签
+	var sign string
+	if s, ok := envelope["sign"]; ok {
+		_ = json.Unmarshal(s, &sign)
+	}
+	if sign != "" {
+		if err := rsaVerifySHA256(p.PublicKey, string(node), sign); err != nil {
+			return raw, errors.New("支付宝应答签名校验失败")
+		}
+	} else if common.Code == alipayCodeSuccess {
+		return raw, errors.New("支付宝应答缺少签名")
+	}
+	if common.Code != alipayCodeSuccess {
+		return node, &alipayError{Code: common.Code, Msg: common.Msg, SubCode: common.SubCode, SubMsg: common.SubMsg}
+	}
+	if out != nil {
+		if err := json.Unmarshal(node, out); err != nil {
+			return node, fmt.Errorf("解析支付宝应答失败: %w", err)
+		}
+	}
+	return node, nil
+}
+
+// alipaySignContent 按 key 排序拼接非空参数：k1=v1&k2=v2
+func alipaySignContent(params url.Values, exclude ...string) string {
+	skip := map[string]bool{}
+	for _, k := range exclude {
+		skip[k] = true
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if !skip[k] && params.Get(k) != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(params.Get(k))
+	}
+	return b.String()
+}
+
+func rsaVerifySHA256(pub *rsa.PublicKey, message, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(message))
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig)
+}
+
+// alipayTradeState 支付宝交易状态映射为微信 trade_state 取值，便于上层统一处理
+func alipayTradeState(status string) string {
+	switch status {
+	case "TRADE_SUCCESS", "TRADE_FINISHED":
+		return "SUCCESS"
+	case "WAIT_BUYER_PAY":
+		return "NOTPAY"
+	case "TRADE_CLOSED":
+		return "CLOSED"
+	default:
+		return status
+	}
+}
+
+// alipayKeyPEM 开放平台导出的密钥通常为不带头尾的 base64，补齐为 PEM
+func alipayKeyPEM(key, typ string) []byte {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(key, "-----BEGIN") {
+		return []byte(key)
+	}
+	// 解码失败时返回空块，由后续解析给出错误
+	der, _ := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(key), ""))
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
+
+func parseAlipayPrivateKey(key string) (*rsa.PrivateKey, error) {
+	if key == "" {
+		return nil, errors.New("支付宝未配置应用私钥")
+	}
+	k, err := parseRSAPrivateKey(alipayKeyPEM(key, "PRIVATE KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("支付宝应用私钥无效: %w", err)
+	}
+	return k, nil
+}
+
+func parseAlipayPublicKey(key string) (*rsa.PublicKey, error) {
+	if key == "" {
+		return nil, errors.New("支付宝未配置支付宝公钥")
+	}
+	block, _ := pem.Decode(alipayKeyPEM(key, "PUBLIC KEY"))
+	if block == nil {
+		return nil, errors.New("支付宝公钥不是有效的 PEM/base64")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("支付宝公钥无效: %w", err)
+	}
+	rk, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("支付宝公钥不是 RSA 公钥")
+	}
+	return rk, nil
+}
+
+func flattenForm(form url.Values) map[string]string {
+	m := make(map[string]string, len(form))
+	for k := range form {
+		m[k] = form.Get(k)
+	}
+	return m
+}
+
+func centsToYuan(cents int64) string {
+	return decimal.New(cents, -2).StringFixed(2)
+}
+
+func yuanToCents(s string) int64 {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return 0
+	}
+	return d.Mul(decimal.NewFromInt(100)).Round(0).IntPart()
+}
+
+// chinaZone 支付宝时间字段均为北京时间
+func chinaZone() *time.Location {
+	if loc, err := time.LoadLocation("Asia/Shanghai"); err == nil {
+		return loc
+	}
+	return time.FixedZone("CST", 8*3600)
+}
+
+func parseAlipayTime(s string) *time.Time {
+	if s == "" {
+		return nil
+	}
+	t, err := time.ParseInLocation(alipayTimeLayout, s, chinaZone())
+	if err != nil {
+		return nil
+	}
+	return &t
+}
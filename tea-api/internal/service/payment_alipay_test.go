@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+)
+
+// alipayStub 本地支付宝网关桩：校验应用签名，并以支付宝私钥签名 xxx_response 节点
+type alipayStub struct {
+	t         *testing.T
+	appPub    *rsa.PublicKey
+	alipayKey *rsa.PrivateKey
+	lastBiz   map[string]any
+}
+
+func (s *alipayStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	form := r.PostForm
+	if !rsaVerify(s.appPub, alipaySignContent(form, "sign"), form.Get("sign")) {
+		_, _ = w.Write([]byte(`{"error_response":{"code":"40002","msg":"Invalid Arguments","sub_code":"isv.invalid-signature","sub_msg":"验签出错"}}`))
+		return
+	}
+	s.lastBiz = map[string]any{}
+	_ = json.Unmarshal([]byte(form.Get("biz_content")), &s.lastBiz)
+	method := form.Get("method")
+	var node string
+	switch method {
+	case "alipay.trade.create":
+		node = `{"code":"10000","msg":"Success","out_trade_no":"PA0001","trade_no":"2024010122001400000000000001"}`
+	case "alipay.trade.query":
+		node = `{"code":"40004","msg":"Business Failed","sub_code":"ACQ.TRADE_NOT_EXIST","sub_msg":"交易不存在"}`
+	default:
+		node = `{"code":"40004","msg":"Business Failed","sub_code":"ACQ.SYSTEM_ERROR"}`
+	}
+	sign := rsaSign(s.t, s.alipayKey, node)
+	key := strings.ReplaceAll(method, ".", "_") + "_response"
+	_, _ = w.Write([]byte(`{"` + key + `":` + node + `,"sign":"` + sign + `"}`))
+}
+
+func newTestAlipayProvider(t *testing.T) (*AlipayProvider, *alipayStub) {
+	t.Helper()
+	appKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("gen app key: %v", err)
+	}
+	aliKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("gen alipay key: %v", err)
+	}
+	stub := &alipayStub{t: t, appPub: &appKey.PublicKey, alipayKey: aliKey}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+
+	// 按开放平台导出的裸 base64 格式配置密钥
+	privDER, _ := x509.MarshalPKCS8PrivateKey(appKey)
+	pubDER, _ := x509.MarshalPKIXPublicKey(&aliKey.PublicKey)
+	p, err := NewAlipayProvider(config.Alipay{
+		AppID:      "2021000000000001",
+		PrivateKey: base64.StdEncoding.EncodeToString(privDER),
+		PublicKey:  base64.StdEncoding.EncodeToString(pubDER),
+		NotifyURL:  "https://example.com/api/v1/payment/alipay/notify",
+		GatewayURL: srv.URL,
+	})
+	if err != nil {
+		t.Fatalf("new alipay provider: %v", err)
+	}
+	return p, stub
+}
+
+func TestAlipay_CreateQueryAndWap(t *testing.T) {
+	p, stub := newTestAlipayProvider(t)
+	res, err := p.CreatePayment(context.Background(), PayCreateRequest{
+		PaymentNo: "PA0001", Description: "订单ORD1", AmountCents: 3650, OpenID: "2088102146225135",
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if res.ClientParams["tradeNO"] != "2024010122001400000000000001" || stub.lastBiz["total_amount"] != "36.50" {
+		t.Fatalf("unexpected create result %+v biz=%+v", res, stub.lastBiz)
+	}
+
+	q, err := p.QueryPayment(context.Background(), "PA0001")
+	if err != nil || q.TradeState != "NOTPAY" {
+		t.Fatalf("trade not exist should map to NOTPAY, got %+v err=%v", q, err)
+	}
+
+	wap, err := p.CreatePayment(context.Background(), PayCreateRequest{PaymentNo: "PA0002", Description: "订单ORD2", AmountCents: 100, TradeType: AlipayTradeWap})
+	if err != nil {
+		t.Fatalf("wap: %v", err)
+	}
+	u, _ := url.Parse(wap.PayURL)
+	params := u.Query()
+	if params.Get("method") != "alipay.trade.wap.pay" || !rsaVerify(&p.PrivateKey.PublicKey, alipaySignContent(params, "sign"), params.Get("sign")) {
+		t.Fatalf("wap pay url not signed correctly: %s", wap.PayURL)
+	}
+
+	// 应答被篡改时拒绝
+	p.PublicKey = &p.PrivateKey.PublicKey
+	if _, err := p.CreatePayment(context.Background(), PayCreateRequest{PaymentNo: "PA0001", AmountCents: 1, OpenID: "x"}); err == nil {
+		t.Fatalf("response signed by unknown key should be rejected")
+	}
+}
+
+func TestAlipay_NotifyMarksOrderPaid(t *testing.T) {
+	p, stub := newTestAlipayProvider(t)
+
+	db, err := gorm.Open(sqlite.Open("file:alipay_notify?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Order{}, &model.Payment{}, &model.StockReservation{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	order := model.Order{OrderNo: "ORDA1", UserID: 1, Status: 1, PayStatus: 1, TotalAmount: decimal.NewFromInt(20), PayAmount: decimal.NewFromInt(20)}
+	db.Create(&order)
+	pay := model.Payment{OrderID: order.ID, PaymentNo: "PA1001", PaymentMethod: 2, Amount: decimal.NewFromInt(20), Status: 1}
+	db.Create(&pay)
+	svc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return p, nil }}
+
+	form := url.Values{}
+	form.Set("app_id", p.AppID)
+	form.Set("notify_id", "2024010100222")
+	form.Set("out_trade_no", "PA1001")
+	form.Set("trade_no", "2024010122001400000000000009")
+	form.Set("trade_status", "TRADE_SUCCESS")
+	form.Set("total_amount", "20.00")
+	form.Set("gmt_payment", time.Now().Format(alipayTimeLayout))
+	form.Set("sign_type", "RSA2")
+	form.Set("sign", rsaSign(t, stub.alipayKey, alipaySignContent(form, "sign", "sign_type")))
+
+	forged := url.Values{}
+	for k, v := range form {
+		forged[k] = v
+	}
+	forged.Set("total_amount", "0.01")
+	if err := svc.HandleProviderNotify(context.Background(), PayMethodAlipay, nil, []byte(forged.Encode())); err == nil {
+		t.Fatalf("forged notify should fail signature verification")
+	}
+	// 微信渠道的通知不能用来确认支付宝支付单
+	if err := svc.HandleProviderNotify(context.Background(), PayMethodWechat, nil, []byte(form.Encode())); err == nil {
+		t.Fatalf("notify for mismatched payment method should be rejected")
+	}
+
+	if err := svc.HandleProviderNotify(context.Background(), PayMethodAlipay, nil, []byte(form.Encode())); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+	db.First(&pay, pay.ID)
+	db.First(&order, order.ID)
+	if pay.Status != 2 || pay.ThirdPayNo != "2024010122001400000000000009" || order.PayStatus != 2 {
+		t.Fatalf("payment/order not marked paid: pay=%d order=%d", pay.Status, order.PayStatus)
+	}
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+)
+
+// PayNotifyProviderLegacy 原有 JSON 签名回调（/payments/callback 无渠道特征的报文）
+const PayNotifyProviderLegacy = "legacy"
+
+// ErrPaymentNotifyReplay nonce 已被有效通知使用
+var ErrPaymentNotifyReplay = errors.New("重复的回调通知")
+
+// PaymentNotifyRequest 入站回调的原始请求，用于留痕
+type PaymentNotifyRequest struct {
+	Header   http.Header
+	Body     []byte
+	RemoteIP string
+}
+
+func notifyReplayWindow() time.Duration {
+	if sec := config.Config.Payment.NotifyReplayWindowSeconds; sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+func notifyProviderName(method int) string {
+	switch method {
+	case PayMethodWechat:
+		return "wechat"
+	case PayMethodAlipay:
+		return "alipay"
+	default:
+		return PayNotifyProviderLegacy
+	}
+}
+
+// truncateText 按字符截断，避免超出 varchar 长度或截断半个汉字
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+func newPaymentNotification(provider string, method int, req PaymentNotifyRequest) *model.PaymentNotification {
+	headers, _ := json.Marshal(req.Header)
+	return &model.PaymentNotification{
+		Provider: provider,
+		Method:   method,
+		RemoteIP: req.RemoteIP,
+		Headers:  string(headers),
+		Body:     string(req.Body),
+		Status:   model.PaymentNotifyReceived,
+	}
+}
+
+// ReceiveProviderNotify 渠道异步通知入口：原始报文落库，验签、时间窗口与 nonce 防重放通过后交由 applyProviderNotify 处理
+func (s *PaymentService) ReceiveProviderNotify(ctx context.Context, method int, req PaymentNotifyRequest) error {
+	rec := newPaymentNotification(notifyProviderName(method), method, req)
+	provider, err := s.providerFor(method)
+	if err != nil {
+		return s.rejectNotification(rec, err)
+	}
+	notify, err := provider.ParseNotify(ctx, req.Header, req.Body)
+	if err != nil {
+		return s.rejectNotification(rec, err)
+	}
+	return s.processNotification(rec, notify, func() error { return s.applyProviderNotify(method, notify) })
+}
+
+// ReceiveCallback JSON 签名回调入口：原始报文落库，验签后按 nonce/timestamp 防重放再交由 HandleCallback 处理
+func (s *PaymentService) ReceiveCallback(req PaymentNotifyRequest, payload PaymentCallbackPayload) error {
+	rec := newPaymentNotification(PayNotifyProviderLegacy, 0, req)
+	notify := &PayNotifyResult{
+		Kind:          PayNotifyPayment,
+		PaymentNo:     payload.PaymentNo,
+		TransactionID: payload.TransactionID,
+		TradeState:    payload.TradeState,
+		PaidAt:        payload.PaidAt,
+		Nonce:         payload.Nonce,
+		Raw:           payload.RawBody,
+	}
+	if payload.Timestamp > 0 {
+		t := time.Unix(payload.Timestamp, 0)
+		notify.NotifyTime = &t
+	}
+	fillNotification(rec, notify)
+	if !payload.SkipVerify {
+		if err := verifyCallbackSign(payload); err != nil {
+			return s.rejectNotification(rec, err)
+		}
+		if config.Config.Payment.CallbackRequireNonce && (payload.Nonce == "" || payload.Timestamp == 0) {
+			return s.rejectNotification(rec, errors.New("回调缺少 nonce 或 timestamp"))
+		}
+	}
+	payload.SkipVerify = true
+	return s.processNotification(rec, notify, func() error { return s.HandleCallback(payload) })
+}
+
+// RejectCallback 记录在解析阶段即被拒绝的 JSON 回调（报文格式错误、非法 test_mode 等）
+func (s *PaymentService) RejectCallback(req PaymentNotifyRequest, cause error) error {
+	return s.rejectNotification(newPaymentNotification(PayNotifyProviderLegacy, 0, req), cause)
+}
+
+func fillNotification(rec *model.PaymentNotification, notify *PayNotifyResult) {
+	rec.Kind = notify.Kind
+	rec.PaymentNo = notify.PaymentNo
+	rec.RefundNo = notify.RefundNo
+	rec.TradeState = notify.TradeState
+	if notify.Kind == PayNotifyRefund {
+		rec.TradeState = notify.RefundStatus
+	}
+	rec.Nonce = truncateText(notify.Nonce, 64)
+	rec.NotifyTime = notify.NotifyTime
+}
+
+// rejectNotification 记录验签失败/超出时间窗口的通知，返回原始错误
+func (s *PaymentService) rejectNotification(rec *model.PaymentNotification, cause error) error {
+	rec.Status = model.PaymentNotifyRejected
+	rec.VerifyError = truncateText(cause.Error(), 255)
+	if err := s.db.Create(rec).Error; err != nil {
+		zap.L().Warn("save rejected payment notification failed", zap.String("provider", rec.Provider), zap.Error(err))
+	}
+	return cause
+}
+
+// processNotification 校验时间窗口并占用 nonce 后执行业务处理，记录处理结果。
+// 处理失败时释放 nonce，渠道以相同 nonce 重发（如支付宝 notify_id）仍可再次处理。
+func (s *PaymentService) processNotification(rec *model.PaymentNotification, notify *PayNotifyResult, apply func() error) error {
+	fillNotification(rec, notify)
+	rec.Verified = true
+	if parsed, err := json.Marshal(notify); err == nil {
+		rec.Parsed = string(parsed)
+	}
+	if rec.NotifyTime != nil {
+		window := notifyReplayWindow()
+		if d := time.Since(*rec.NotifyTime); d > window || d < -window {
+			return s.rejectNotification(rec, errors.New("回调时间戳超出允许范围"))
+		}
+	}
+	dup, err := s.claimNotification(rec, notify)
+	if err != nil || dup {
+		return err
+	}
+
+	applyErr := apply()
+	now := time.Now()
+	updates := map[string]any{"status": model.PaymentNotifyProcessed, "process_error": "", "processed_at": now}
+	if applyErr != nil {
+		updates["status"] = model.PaymentNotifyFailed
+		updates["process_error"] = truncateText(applyErr.Error(), 255)
+		updates["replay_key"] = nil
+	}
+	if err := s.db.Model(rec).Updates(updates).Error; err != nil {
+		zap.L().Warn("update payment notification failed", zap.Uint("id", rec.ID), zap.Error(err))
+	}
+	return applyErr
+}
+
+// claimNotification 落库并占用 provider:nonce。nonce 已被占用时：与已处理通知内容一致的视为渠道重发
+// （如支付宝应答丢失后以相同 notify_id 重试），仅留痕并返回 dup=true 以应答成功；其余记为重放
+func (s *PaymentService) claimNotification(rec *model.PaymentNotification, notify *PayNotifyResult) (dup bool, err error) {
+	if rec.Nonce == "" {
+		return false, s.db.Create(rec).Error
+	}
+	key := rec.Provider + ":" + rec.Nonce
+	rec.ReplayKey = &key
+	holder := func() *model.PaymentNotification {
+		var h model.PaymentNotification
+		if s.db.Where("replay_key = ?", key).First(&h).Error != nil {
+			return nil
+		}
+		return &h
+	}
+	h := holder()
+	if h == nil {
+		err := s.db.Create(rec).Error
+		if err == nil {
+			return false, nil
+		}
+		// 并发的相同通知由唯一索引拦截
+		if h = holder(); h == nil {
+			return false, err
+		}
+		rec.ID = 0
+	}
+	rec.ReplayKey = nil
+	if h.Status == model.PaymentNotifyProcessed && sameNotification(h, notify) {
+		now := time.Now()
+		rec.Status = model.PaymentNotifyDuplicate
+		rec.ProcessedAt = &now
+		if err := s.db.Create(rec).Error; err != nil {
+			zap.L().Warn("save duplicate payment notification failed", zap.String("key", key), zap.Error(err))
+		}
+		zap.L().Info("duplicate payment notification acknowledged", zap.String("key", key), zap.Uint("original_id", h.ID))
+		return true, nil
+	}
+	rec.Status = model.PaymentNotifyReplay
+	rec.VerifyError = ErrPaymentNotifyReplay.Error()
+	if err := s.db.Create(rec).Error; err != nil {
+		zap.L().Warn("save replayed payment notification failed", zap.String("key", key), zap.Error(err))
+	}
+	zap.L().Warn("payment notification replay rejected", zap.String("key", key), zap.String("remote_ip", rec.RemoteIP))
+	return false, ErrPaymentNotifyReplay
+}
+
+// sameNotification 比较已处理通知与新通知的业务内容（单号、状态、渠道流水号与金额）
+func sameNotification(h *model.PaymentNotification, notify *PayNotifyResult) bool {
+	var prev PayNotifyResult
+	if json.Unmarshal([]byte(h.Parsed), &prev) != nil {
+		return false
+	}
+	return prev.Kind == notify.Kind && prev.PaymentNo == notify.PaymentNo && prev.RefundNo == notify.RefundNo &&
+		prev.TradeState == notify.TradeState && prev.RefundStatus == notify.RefundStatus &&
+		prev.TransactionID == notify.TransactionID && prev.RefundID == notify.RefundID && prev.AmountCents == notify.AmountCents
+}
+
+// ReprocessNotification 按已验签的通知数据重新执行业务处理（如修复数据后重放处理失败的通知）
+func (s *PaymentService) ReprocessNotification(id uint) (*model.PaymentNotification, error) {
+	var rec model.PaymentNotification
+	if err := s.db.First(&rec, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("回调通知不存在")
+		}
+		return nil, err
+	}
+	if !rec.Verified || rec.Parsed == "" {
+		return nil, errors.New("通知未通过验签，不能重新处理")
+	}
+	if rec.Status == model.PaymentNotifyReplay || rec.Status == model.PaymentNotifyDuplicate {
+		return nil, errors.New("重放的通知不能重新处理，请处理原始通知")
+	}
+	var notify PayNotifyResult
+	if err := json.Unmarshal([]byte(rec.Parsed), &notify); err != nil {
+		return nil, err
+	}
+
+	var applyErr error
+	if rec.Provider == PayNotifyProviderLegacy {
+		applyErr = s.HandleCallback(PaymentCallbackPayload{
+			PaymentNo:     notify.PaymentNo,
+			TransactionID: notify.TransactionID,
+			TradeState:    notify.TradeState,
+			PaidAt:        notify.PaidAt,
+			RawBody:       notify.Raw,
+			SkipVerify:    true,
+		})
+	} else {
+		applyErr = s.applyProviderNotify(rec.Method, &notify)
+	}
+	updates := map[string]any{
+		"status":          model.PaymentNotifyProcessed,
+		"process_error":   "",
+		"processed_at":    time.Now(),
+		"reprocess_count": gorm.Expr("reprocess_count + 1"),
+	}
+	if applyErr != nil {
+		updates["status"] = model.PaymentNotifyFailed
+		updates["process_error"] = truncateText(applyErr.Error(), 255)
+	}
+	if err := s.db.Model(&rec).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.First(&rec, rec.ID).Error; err != nil {
+		return nil, err
+	}
+	return &rec, applyErr
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+	"tea-api/pkg/utils"
+)
+
+// notifyStubProvider 直接返回预设的已验签通知
+type notifyStubProvider struct {
+	MockPaymentProvider
+	notify *PayNotifyResult
+}
+
+func (p *notifyStubProvider) ParseNotify(context.Context, http.Header, []byte) (*PayNotifyResult, error) {
+	return p.notify, nil
+}
+
+func setupNotifyDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:payment_notify?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Order{}, &model.Payment{}, &model.PaymentNotification{}, &model.StockReservation{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func createPendingPayment(t *testing.T, db *gorm.DB, orderNo, paymentNo string) model.Order {
+	t.Helper()
+	o := model.Order{OrderNo: orderNo, UserID: 1, Status: 1, PayStatus: 1, TotalAmount: decimal.NewFromInt(10), PayAmount: decimal.NewFromInt(10)}
+	db.Create(&o)
+	db.Create(&model.Payment{OrderID: o.ID, PaymentNo: paymentNo, PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(10), Status: 1})
+	return o
+}
+
+// signedCallback 按服务端规范（去掉 sign、key 排序、紧凑 JSON）计算 HMAC 并构造回调
+func signedCallback(secret string, fields map[string]any) PaymentCallbackPayload {
+	canonical, _ := json.Marshal(fields)
+	sign := utils.HMACSHA256Hex(secret, string(canonical))
+	fields["sign"] = sign
+	body, _ := json.Marshal(fields)
+	p := PaymentCallbackPayload{
+		PaymentNo:  fields["payment_no"].(string),
+		TradeState: fields["trade_state"].(string),
+		Sign:       sign,
+		RawBody:    string(body),
+	}
+	if n, ok := fields["nonce"].(string); ok {
+		p.Nonce = n
+	}
+	if ts, ok := fields["timestamp"].(int64); ok {
+		p.Timestamp = ts
+	}
+	return p
+}
+
+func TestReceiveCallback_ReplayAndLegacySign(t *testing.T) {
+	db := setupNotifyDB(t)
+	oldCfg := config.Config.WeChat.APIKey
+	oldPay := config.Config.Payment
+	config.Config.WeChat.APIKey = "notify_secret"
+	defer func() {
+		config.Config.WeChat.APIKey = oldCfg
+		config.Config.Payment = oldPay
+	}()
+	order := createPendingPayment(t, db, "ORDN1", "PN0001")
+	svc := &PaymentService{db: db}
+
+	now := time.Now().Unix()
+	payload := signedCallback("notify_secret", map[string]any{
+		"payment_no": "PN0001", "trade_state": "SUCCESS", "nonce": "n-0001", "timestamp": now,
+	})
+	if err := svc.ReceiveCallback(PaymentNotifyRequest{Body: []byte(payload.RawBody)}, payload); err != nil {
+		t.Fatalf("signed callback: %v", err)
+	}
+	db.First(&order, order.ID)
+	if order.PayStatus != 2 {
+		t.Fatalf("order should be paid, pay_status=%d", order.PayStatus)
+	}
+	// 已处理通知的原样重发（应答丢失后渠道重试）应答成功，仅留痕
+	if err := svc.ReceiveCallback(PaymentNotifyRequest{Body: []byte(payload.RawBody)}, payload); err != nil {
+		t.Fatalf("duplicate of a processed callback should be acknowledged, got %v", err)
+	}
+	// 相同 nonce 但内容不同的通知按重放拒绝
+	forged := signedCallback("notify_secret", map[string]any{
+		"payment_no": "PN0001", "trade_state": "CLOSED", "nonce": "n-0001", "timestamp": now,
+	})
+	if err := svc.ReceiveCallback(PaymentNotifyRequest{Body: []byte(forged.RawBody)}, forged); !errors.Is(err, ErrPaymentNotifyReplay) {
+		t.Fatalf("mismatched payload with a used nonce should be rejected, got %v", err)
+	}
+
+	// 超出时间窗口
+	createPendingPayment(t, db, "ORDN2", "PN0002")
+	stale := signedCallback("notify_secret", map[string]any{
+		"payment_no": "PN0002", "trade_state": "SUCCESS", "nonce": "n-0002", "timestamp": now - 3600,
+	})
+	if err := svc.ReceiveCallback(PaymentNotifyRequest{}, stale); err == nil {
+		t.Fatalf("stale callback should be rejected")
+	}
+
+	// 关闭旧版签名后 MD5 签名被拒绝
+	config.Config.Payment.DisableLegacySign = true
+	legacy := PaymentCallbackPayload{PaymentNo: "PN0002", TradeState: "SUCCESS", RawBody: `{"payment_no":"PN0002","trade_state":"SUCCESS"}`}
+	legacy.Sign = strings.ToUpper(utils.MD5Hash(fmt.Sprintf("%s|%s|%s", "PN0002", "SUCCESS", "notify_secret")))
+	if err := svc.ReceiveCallback(PaymentNotifyRequest{}, legacy); err == nil {
+		t.Fatalf("legacy md5 signature should be rejected when disabled")
+	}
+	config.Config.Payment.DisableLegacySign = false
+	if err := svc.ReceiveCallback(PaymentNotifyRequest{}, legacy); err != nil {
+		t.Fatalf("legacy md5 signature should be accepted by default: %v", err)
+	}
+
+	count := func(status int) int64 {
+		var n int64
+		db.Model(&model.PaymentNotification{}).Where("status = ?", status).Count(&n)
+		return n
+	}
+	if count(model.PaymentNotifyProcessed) != 2 || count(model.PaymentNotifyDuplicate) != 1 || count(model.PaymentNotifyReplay) != 1 ||
+		count(model.PaymentNotifyRejected) != 2 {
+		t.Fatalf("unexpected notification trail: processed=%d duplicate=%d replay=%d rejected=%d", count(model.PaymentNotifyProcessed),
+			count(model.PaymentNotifyDuplicate), count(model.PaymentNotifyReplay), count(model.PaymentNotifyRejected))
+	}
+}
+
+func TestReceiveProviderNotify_FailedThenReprocess(t *testing.T) {
+	db := setupNotifyDB(t)
+	now := time.Now()
+	provider := &notifyStubProvider{notify: &PayNotifyResult{
+		Kind: PayNotifyPayment, PaymentNo: "PN0101", TransactionID: "4200000101", TradeState: "SUCCESS",
+		Nonce: "wx-nonce-0101", NotifyTime: &now,
+	}}
+	svc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return provider, nil }}
+
+	// 支付单尚不存在，处理失败
+	if err := svc.ReceiveProviderNotify(context.Background(), PayMethodWechat, PaymentNotifyRequest{Body: []byte(`{"id":"EV-0101"}`)}); err == nil {
+		t.Fatalf("notify for unknown payment should fail")
+	}
+	var failed model.PaymentNotification
+	db.Where("payment_no = ?", "PN0101").First(&failed)
+	if failed.Status != model.PaymentNotifyFailed || !failed.Verified || failed.ProcessError == "" || failed.Body != `{"id":"EV-0101"}` {
+		t.Fatalf("failed notification should be recorded, got %+v", failed)
+	}
+
+	// 处理失败释放 nonce，渠道重发相同通知仍可处理
+	order := createPendingPayment(t, db, "ORDN101", "PN0101")
+	if err := svc.ReceiveProviderNotify(context.Background(), PayMethodWechat, PaymentNotifyRequest{}); err != nil {
+		t.Fatalf("redelivered notify: %v", err)
+	}
+	db.First(&order, order.ID)
+	if order.PayStatus != 2 {
+		t.Fatalf("order should be paid after redelivery, pay_status=%d", order.PayStatus)
+	}
+
+	rec, err := svc.ReprocessNotification(failed.ID)
+	if err != nil {
+		t.Fatalf("reprocess: %v", err)
+	}
+	if rec.Status != model.PaymentNotifyProcessed || rec.ReprocessCount != 1 || rec.ProcessError != "" {
+		t.Fatalf("reprocessed notification should be processed, got %+v", rec)
+	}
+}
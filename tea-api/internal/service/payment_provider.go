@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tea-api/internal/config"
+	"tea-api/pkg/utils"
+)
+
+// 支付方式（与 model.Payment.PaymentMethod 一致）
+const (
+	PayMethodWechat  = 1
+	PayMethodAlipay  = 2
+	PayMethodBalance = 3 // 钱包余额，不经过外部渠道
+)
+
+// 支付通知类型
+const (
+	PayNotifyPayment = "payment"
+	PayNotifyRefund  = "refund"
+)
+
+// PayCreateRequest 渠道下单参数
+type PayCreateRequest struct {
+	PaymentNo   string
+	Description string
+	AmountCents int64
+	OpenID      string     // 付款用户标识：微信 openid / 支付宝 buyer_id
+	TradeType   string     // 渠道下单方式，如支付宝 MINI / H5；为空使用渠道默认方式
+	NotifyURL   string     // 为空时使用渠道配置
+	ExpireAt    *time.Time // 支付截止时间，为空不限制
+}
+
+// PayCreateResult 渠道下单结果，ClientParams 原样返回给前端拉起支付
+type PayCreateResult struct {
+	PrepayID     string
+	ClientParams map[string]string
+	PayURL       string
+}
+
+// PayQueryResult 渠道订单查询结果
+type PayQueryResult struct {
+	PaymentNo     string
+	TransactionID string
+	TradeState    string // SUCCESS / NOTPAY / CLOSED / REFUND / PAYERROR 等，统一使用微信的取值
+	AmountCents   int64
+	PaidAt        *time.Time
+	Raw           string
+}
+
+// PayRefundRequest 渠道退款参数
+type PayRefundRequest struct {
+	PaymentNo   string
+	RefundNo    string
+	Reason      string
+	RefundCents int64
+	TotalCents  int64
+	NotifyURL   string
+}
+
+// PayRefundResult 渠道退款结果
+type PayRefundResult struct {
+	RefundNo   string
+	RefundID   string
+	Status     string // SUCCESS / PROCESSING / CLOSED / ABNORMAL
+	RefundedAt *time.Time
+	Raw        string
+}
+
+// PayNotifyResult 已验签并解密的渠道异步通知
+type PayNotifyResult struct {
+	Kind          string     `json:"kind"` // payment / refund
+	PaymentNo     string     `json:"payment_no"`
+	TransactionID string     `json:"transaction_id,omitempty"`
+	TradeState    string     `json:"trade_state,omitempty"`
+	AmountCents   int64      `json:"amount_cents,omitempty"`
+	PaidAt        *time.Time `json:"paid_at,omitempty"`
+	RefundNo      string     `json:"refund_no,omitempty"`
+	RefundID      string     `json:"refund_id,omitempty"`
+	RefundStatus  string     `json:"refund_status,omitempty"`
+	RefundedAt    *time.Time `json:"refunded_at,omitempty"`
+	Nonce         string     `json:"nonce,omitempty"`       // 微信 Wechatpay-Nonce / 支付宝 notify_id
+	NotifyTime    *time.Time `json:"notify_time,omitempty"` // 通知发送时间
+	Raw           string     `json:"raw"`                   // 解密后的业务报文
+}
+
+// PaymentProvider 支付渠道抽象：下单、查询、关单、退款与异步通知解析
+type PaymentProvider interface {
+	Name() string
+	CreatePayment(ctx context.Context, req PayCreateRequest) (*PayCreateResult, error)
+	QueryPayment(ctx context.Context, paymentNo string) (*PayQueryResult, error)
+	ClosePayment(ctx context.Context, paymentNo string) error
+	Refund(ctx context.Context, req PayRefundRequest) (*PayRefundResult, error)
+	QueryRefund(ctx context.Context, paymentNo, refundNo string) (*PayRefundResult, error)
+	ParseNotify(ctx context.Context, header http.Header, body []byte) (*PayNotifyResult, error)
+}
+
+var (
+	providerMu     sync.Mutex
+	wechatProvider *WechatPayV3Provider
+	alipayProvider *AlipayProvider
+)
+
+// NewPaymentProvider 按支付方式与配置返回支付渠道
+// wechat.pay_mode 为 v3 时微信支付走真实 APIv3，alipay.pay_mode 为 live 时支付宝走开放平台，否则使用本地模拟渠道。
+func NewPaymentProvider(method int) (PaymentProvider, error) {
+	switch method {
+	case PayMethodWechat:
+		if strings.EqualFold(config.Config.WeChat.PayMode, "v3") {
+			return sharedWechatProvider()
+		}
+		return &MockPaymentProvider{}, nil
+	case PayMethodAlipay:
+		if strings.EqualFold(config.Config.Alipay.PayMode, "live") {
+			return sharedAlipayProvider()
+		}
+		return &MockPaymentProvider{}, nil
+	default:
+		return nil, errors.New("不支持的支付方式")
+	}
+}
+
+// sharedWechatProvider 复用同一个微信支付实例，避免重复加载证书与下载平台证书
+func sharedWechatProvider() (*WechatPayV3Provider, error) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if wechatProvider != nil {
+		return wechatProvider, nil
+	}
+	p, err := NewWechatPayV3Provider(config.Config.WeChat)
+	if err != nil {
+		return nil, err
+	}
+	wechatProvider = p
+	return p, nil
+}
+
+func sharedAlipayProvider() (*AlipayProvider, error) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if alipayProvider != nil {
+		return alipayProvider, nil
+	}
+	p, err := NewAlipayProvider(config.Config.Alipay)
+	if err != nil {
+		return nil, err
+	}
+	alipayProvider = p
+	return p, nil
+}
+
+// MockPaymentProvider 本地模拟渠道：保留原有 prepay_<no> + MD5 签名行为，配合 /payment/mock-callback 使用
+type MockPaymentProvider struct{}
+
+func (m *MockPaymentProvider) Name() string { return "mock" }
+
+func (m *MockPaymentProvider) CreatePayment(_ context.Context, req PayCreateRequest) (*PayCreateResult, error) {
+	nonce := utils.GenerateRandomString(18)
+	timestamp := time.Now().Unix()
+	prepayID := fmt.Sprintf("prepay_%s", req.PaymentNo)
+	secret := config.Config.WeChat.APIKey
+	sign := strings.ToUpper(utils.MD5Hash(fmt.Sprintf("%s|%s|%d|%s", prepayID, nonce, timestamp, secret)))
+	return &PayCreateResult{
+		PrepayID: prepayID,
+		ClientParams: map[string]string{
+			"appId":     config.Config.WeChat.AppID,
+			"timeStamp": strconv.FormatInt(timestamp, 10),
+			"nonceStr":  nonce,
+			"package":   "Sign=WXPay",
+			"signType":  "MD5",
+			"paySign":   sign,
+		},
+		PayURL: fmt.Sprintf("mockpay://%s", req.PaymentNo),
+	}, nil
+}
+
+func (m *MockPaymentProvider) QueryPayment(_ context.Context, paymentNo string) (*PayQueryResult, error) {
+	return &PayQueryResult{PaymentNo: paymentNo, TradeState: "NOTPAY"}, nil
+}
+
+func (m *MockPaymentProvider) ClosePayment(context.Context, string) error { return nil }
+
+func (m *MockPaymentProvider) Refund(_ context.Context, req PayRefundRequest) (*PayRefundResult, error) {
+	now := time.Now()
+	return &PayRefundResult{
+		RefundNo:   req.RefundNo,
+		RefundID:   "mock_" + req.RefundNo,
+		Status:     "SUCCESS",
+		RefundedAt: &now,
+	}, nil
+}
+
+func (m *MockPaymentProvider) QueryRefund(_ context.Context, _ string, refundNo string) (*PayRefundResult, error) {
+	return &PayRefundResult{RefundNo: refundNo, RefundID: "mock_" + refundNo, Status: "SUCCESS"}, nil
+}
+
+func (m *MockPaymentProvider) ParseNotify(context.Context, http.Header, []byte) (*PayNotifyResult, error) {
+	return nil, errors.New("模拟渠道不支持异步通知，请使用 /payment/mock-callback")
+}
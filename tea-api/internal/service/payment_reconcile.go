@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"tea-api/internal/model"
+)
+
+// PaymentReconcileStats 单次待支付单对账结果
+type PaymentReconcileStats struct {
+	Scanned int `json:"scanned"` // 查询渠道的支付单数
+	Paid    int `json:"paid"`    // 渠道已支付、补记为支付成功
+	Failed  int `json:"failed"`  // 渠道已关闭/支付失败、同步为失败
+	Closed  int `json:"closed"`  // 过期未支付、主动关单
+	Errors  int `json:"errors"`  // 查询或处理失败，下次扫描重试
+}
+
+// PaymentReconcileMetrics 对账任务累计指标（进程级）
+type PaymentReconcileMetrics struct {
+	Runs      int64                 `json:"runs"`
+	Scanned   int64                 `json:"scanned"`
+	Paid      int64                 `json:"paid"`
+	Failed    int64                 `json:"failed"`
+	Closed    int64                 `json:"closed"`
+	Errors    int64                 `json:"errors"`
+	LastRunAt *time.Time            `json:"last_run_at"`
+	LastRun   PaymentReconcileStats `json:"last_run"`
+}
+
+var (
+	reconcileMetricsMu sync.Mutex
+	reconcileMetrics   PaymentReconcileMetrics
+)
+
+// GetPaymentReconcileMetrics 返回对账任务累计指标快照
+func GetPaymentReconcileMetrics() PaymentReconcileMetrics {
+	reconcileMetricsMu.Lock()
+	defer reconcileMetricsMu.Unlock()
+	m := reconcileMetrics
+	return m
+}
+
+func recordReconcileRun(now time.Time, st PaymentReconcileStats) {
+	reconcileMetricsMu.Lock()
+	defer reconcileMetricsMu.Unlock()
+	reconcileMetrics.Runs++
+	reconcileMetrics.Scanned += int64(st.Scanned)
+	reconcileMetrics.Paid += int64(st.Paid)
+	reconcileMetrics.Failed += int64(st.Failed)
+	reconcileMetrics.Closed += int64(st.Closed)
+	reconcileMetrics.Errors += int64(st.Errors)
+	reconcileMetrics.LastRunAt = &now
+	reconcileMetrics.LastRun = st
+}
+
+// ReconcilePendingPayments 对账超过 after 仍未收到回调的外部渠道待支付单：
+// 渠道已支付的按 HandleCallback 同一事务补记成功，已关闭/失败的同步为失败；
+// 仍未支付但已过期（订单不可支付、超过库存预占时长或已有更新的支付单）的向渠道关单后置为失败，避免之后仍可付款。
+func (s *PaymentService) ReconcilePendingPayments(now time.Time, after time.Duration, limit int) (PaymentReconcileStats, error) {
+	var st PaymentReconcileStats
+	if limit <= 0 {
+		limit = 100
+	}
+	// 按上次对账时间（updated_at）轮转，查询持续失败的支付单不会一直占满扫描窗口
+	var pending []model.Payment
+	if err := s.db.Where("status = 1 AND payment_method IN ? AND created_at <= ?", []int{PayMethodWechat, PayMethodAlipay}, now.Add(-after)).
+		Order("updated_at ASC, id ASC").Limit(limit).Find(&pending).Error; err != nil {
+		return st, err
+	}
+	for i := range pending {
+		s.reconcilePayment(context.Background(), &pending[i], now, &st)
+	}
+	recordReconcileRun(now, st)
+	return st, nil
+}
+
+func (s *PaymentService) reconcilePayment(ctx context.Context, pay *model.Payment, now time.Time, st *PaymentReconcileStats) {
+	logErr := func(msg string, err error) {
+		st.Errors++
+		zap.L().Warn(msg, zap.String("payment_no", pay.PaymentNo), zap.Error(err))
+	}
+	provider, err := s.providerFor(pay.PaymentMethod)
+	if err != nil {
+		logErr("reconcile payment: provider unavailable", err)
+		return
+	}
+	st.Scanned++
+	if err := s.db.Model(&model.Payment{}).Where("id = ? AND status = 1", pay.ID).UpdateColumn("updated_at", now).Error; err != nil {
+		logErr("reconcile payment: touch failed", err)
+		return
+	}
+	q, err := provider.QueryPayment(ctx, pay.PaymentNo)
+	if err != nil {
+		logErr("reconcile payment: query failed", err)
+		return
+	}
+	switch q.TradeState {
+	case "SUCCESS", "REFUND":
+		// REFUND 表示支付成功后已发生退款，支付本身仍需入账
+		if err := s.HandleCallback(PaymentCallbackPayload{
+			PaymentNo:     pay.PaymentNo,
+			TransactionID: q.TransactionID,
+			TradeState:    "SUCCESS",
+			PaidAt:        q.PaidAt,
+			RawBody:       q.Raw,
+			SkipVerify:    true, // 主动查询结果已由渠道验签
+			AmountCents:   q.AmountCents,
+			Method:        pay.PaymentMethod,
+		}); err != nil {
+			logErr("reconcile payment: apply success failed", err)
+			return
+		}
+		st.Paid++
+	case "CLOSED", "PAYERROR", "REVOKED":
+		if err := s.HandleCallback(PaymentCallbackPayload{
+			PaymentNo:     pay.PaymentNo,
+			TransactionID: q.TransactionID,
+			TradeState:    q.TradeState,
+			RawBody:       q.Raw,
+			SkipVerify:    true,
+			Method:        pay.PaymentMethod,
+		}); err != nil {
+			logErr("reconcile payment: apply failure failed", err)
+			return
+		}
+		st.Failed++
+	default:
+		// NOTPAY / USERPAYING：仍可支付，过期的支付单主动关单
+		stale, err := s.isStalePayment(pay, now)
+		if err != nil {
+			logErr("reconcile payment: check stale failed", err)
+			return
+		}
+		if !stale || q.TradeState == "USERPAYING" {
+			return
+		}
+		// 关单失败（如用户恰好完成支付）不落库，下次扫描按查询结果处理
+		if err := provider.ClosePayment(ctx, pay.PaymentNo); err != nil {
+			logErr("reconcile payment: close failed", err)
+			return
+		}
+		if err := s.HandleCallback(PaymentCallbackPayload{
+			PaymentNo:  pay.PaymentNo,
+			TradeState: "CLOSED",
+			RawBody:    q.Raw,
+			SkipVerify: true,
+			Method:     pay.PaymentMethod,
+		}); err != nil {
+			logErr("reconcile payment: mark closed failed", err)
+			return
+		}
+		st.Closed++
+	}
+}
+
+// isStalePayment 判断待支付单是否已无需继续支付
+func (s *PaymentService) isStalePayment(pay *model.Payment, now time.Time) (bool, error) {
+	if !pay.CreatedAt.Add(stockReserveTTL()).After(now) {
+		return true, nil
+	}
+	var order model.Order
+	if err := s.db.Select("id", "status", "pay_status").First(&order, pay.OrderID).Error; err != nil {
+		return false, err
+	}
+	if order.Status != 1 || order.PayStatus != 1 {
+		return true, nil
+	}
+	// 金额变更（调价、组合支付）后重新下单，旧支付单作废
+	var newer int64
+	if err := s.db.Model(&model.Payment{}).Where("order_id = ? AND id > ? AND payment_method <> ?", pay.OrderID, pay.ID, PayMethodBalance).
+		Count(&newer).Error; err != nil {
+		return false, err
+	}
+	return newer > 0, nil
+}
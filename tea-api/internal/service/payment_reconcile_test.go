@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+// reconcileStubProvider 按支付单号返回预设的渠道交易状态
+type reconcileStubProvider struct {
+	MockPaymentProvider
+	states map[string]string
+	closed []string
+}
+
+func (p *reconcileStubProvider) QueryPayment(_ context.Context, paymentNo string) (*PayQueryResult, error) {
+	if p.states[paymentNo] == "ERROR" {
+		return nil, errors.New("渠道查询超时")
+	}
+	res := &PayQueryResult{PaymentNo: paymentNo, TradeState: p.states[paymentNo]}
+	if res.TradeState == "SUCCESS" {
+		now := time.Now()
+		res.TransactionID = "4200000000" + paymentNo
+		res.AmountCents = 1000
+		res.PaidAt = &now
+	}
+	return res, nil
+}
+
+func (p *reconcileStubProvider) ClosePayment(_ context.Context, paymentNo string) error {
+	p.closed = append(p.closed, paymentNo)
+	return nil
+}
+
+func TestReconcilePendingPayments(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:payment_reconcile?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Order{}, &model.Payment{}, &model.StockReservation{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	now := time.Now()
+	newPending := func(no, payNo string, age time.Duration) (model.Order, model.Payment) {
+		o := model.Order{OrderNo: no, UserID: 1, Status: 1, PayStatus: 1, TotalAmount: decimal.NewFromInt(10), PayAmount: decimal.NewFromInt(10)}
+		db.Create(&o)
+		p := model.Payment{OrderID: o.ID, PaymentNo: payNo, PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(10), Status: 1}
+		p.CreatedAt = now.Add(-age)
+		db.Create(&p)
+		return o, p
+	}
+	paidOrder, paid := newPending("ORDC1", "PC0001", 10*time.Minute) // 回调丢失，渠道已支付
+	_, closed := newPending("ORDC2", "PC0002", 10*time.Minute)       // 渠道已关闭
+	_, stale := newPending("ORDC3", "PC0003", 40*time.Minute)        // 超过预占时长仍未支付
+	_, waiting := newPending("ORDC4", "PC0004", 10*time.Minute)      // 未支付且未过期
+	_, recent := newPending("ORDC5", "PC0005", time.Minute)          // 未到对账时间
+
+	provider := &reconcileStubProvider{states: map[string]string{
+		"PC0001": "SUCCESS", "PC0002": "CLOSED", "PC0003": "NOTPAY", "PC0004": "NOTPAY", "PC0005": "SUCCESS",
+	}}
+	svc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return provider, nil }}
+
+	before := GetPaymentReconcileMetrics()
+	st, err := svc.ReconcilePendingPayments(now, 5*time.Minute, 10)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if st.Scanned != 4 || st.Paid != 1 || st.Failed != 1 || st.Closed != 1 || st.Errors != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+
+	status := func(p model.Payment) int {
+		db.First(&p, p.ID)
+		return p.Status
+	}
+	db.First(&paidOrder, paidOrder.ID)
+	if status(paid) != 2 || paidOrder.PayStatus != 2 || paidOrder.Status != 2 {
+		t.Fatalf("lost callback should be recovered, pay=%d order=%d/%d", status(paid), paidOrder.Status, paidOrder.PayStatus)
+	}
+	if status(closed) != 3 || status(stale) != 3 {
+		t.Fatalf("closed and stale payments should be failed, got %d/%d", status(closed), status(stale))
+	}
+	if len(provider.closed) != 1 || provider.closed[0] != "PC0003" {
+		t.Fatalf("stale payment should be closed at provider, got %v", provider.closed)
+	}
+	if status(waiting) != 1 || status(recent) != 1 {
+		t.Fatalf("payable payments should stay pending")
+	}
+
+	after := GetPaymentReconcileMetrics()
+	if after.Runs != before.Runs+1 || after.Paid != before.Paid+1 || after.Closed != before.Closed+1 || after.LastRunAt == nil {
+		t.Fatalf("metrics not recorded: before=%+v after=%+v", before, after)
+	}
+}
+
+func TestReconcilePendingPayments_RotatesAndRefundsPaidAfterClose(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:payment_reconcile_rotate?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Order{}, &model.Payment{}, &model.Refund{}, &model.StockReservation{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	now := time.Now()
+	newPayment := func(no, payNo string, orderStatus int) model.Payment {
+		o := model.Order{OrderNo: no, UserID: 1, Status: orderStatus, PayStatus: 1, TotalAmount: decimal.NewFromInt(10), PayAmount: decimal.NewFromInt(10)}
+		db.Create(&o)
+		p := model.Payment{OrderID: o.ID, PaymentNo: payNo, PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(10), Status: 1}
+		p.CreatedAt = now.Add(-10 * time.Minute)
+		p.UpdatedAt = p.CreatedAt
+		db.Create(&p)
+		return p
+	}
+	broken := newPayment("ORDR1", "PRT001", 1)  // 渠道查询持续失败
+	lateOne := newPayment("ORDR2", "PRT002", 5) // 订单已超时取消，渠道却已支付
+
+	provider := &reconcileStubProvider{states: map[string]string{"PRT001": "ERROR", "PRT002": "SUCCESS"}}
+	svc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return provider, nil }}
+
+	if st, _ := svc.ReconcilePendingPayments(now, 5*time.Minute, 1); st.Scanned != 1 || st.Errors != 1 {
+		t.Fatalf("first run should hit the failing payment, got %+v", st)
+	}
+	if st, _ := svc.ReconcilePendingPayments(now.Add(time.Minute), 5*time.Minute, 1); st.Scanned != 1 || st.Paid != 1 {
+		t.Fatalf("failing payment should not starve the next one, got %+v", st)
+	}
+
+	db.First(&lateOne, lateOne.ID)
+	db.First(&broken, broken.ID)
+	var order model.Order
+	db.First(&order, lateOne.OrderID)
+	var refunds int64
+	db.Model(&model.Refund{}).Where("payment_id = ? AND status = ?", lateOne.ID, model.RefundStatusSuccess).Count(&refunds)
+	if lateOne.Status != model.PaymentStatusPaidAfterClose || order.Status != 5 || refunds != 1 {
+		t.Fatalf("success for a cancelled order should be refunded, pay=%d order=%d refunds=%d", lateOne.Status, order.Status, refunds)
+	}
+	if broken.Status != 1 {
+		t.Fatalf("failing payment should stay pending, got %d", broken.Status)
+	}
+}
@@ -0,0 +1,680 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tea-api/internal/config"
+	"tea-api/pkg/utils"
+)
+
+const (
+	wechatDefaultBaseURL = "https://api.mch.weixin.qq.com"
+	wechatAuthSchema     = "WECHATPAY2-SHA256-RSA2048"
+	// 应答/通知时间戳允许的最大偏差
+	wechatMaxClockSkew = 5 * time.Minute
+)
+
+// WechatPayV3Provider 微信支付 APIv3 JSAPI 渠道
+// 请求使用商户私钥 RSA-SHA256 签名；应答与回调使用平台证书验签，回调资源以 APIv3 密钥 AES-256-GCM 解密。
+type WechatPayV3Provider struct {
+	BaseURL    string
+	AppID      string
+	MchID      string
+	SerialNo   string // 商户证书序列号
+	APIv3Key   string
+	NotifyURL  string
+	PrivateKey *rsa.PrivateKey
+	Client     *http.Client
+
+	// fixedCerts 为 true 表示平台证书来自本地配置，不再自动下载
+	fixedCerts bool
+	mu         sync.RWMutex
+	certs      map[string]*x509.Certificate // 平台证书序列号 -> 证书
+	now        func() time.Time
+}
+
+// NewWechatPayV3Provider 根据配置加载商户私钥、证书序列号与平台证书
+func NewWechatPayV3Provider(cfg config.WeChat) (*WechatPayV3Provider, error) {
+	if cfg.MchID == "" || cfg.AppID == "" {
+		return nil, errors.New("微信支付未配置 app_id/mch_id")
+	}
+	if len(cfg.APIv3Key) != 32 {
+		return nil, errors.New("微信支付 api_v3_key 必须为 32 字节")
+	}
+	keyPEM, err := os.ReadFile(cfg.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取商户私钥失败: %w", err)
+	}
+	key, err := parseRSAPrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	serial := cfg.SerialNo
+	if serial == "" {
+		certPEM, err := os.ReadFile(cfg.CertPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取商户证书失败: %w", err)
+		}
+		certs, err := parseCertificates(certPEM)
+		if err != nil || len(certs) == 0 {
+			return nil, fmt.Errorf("解析商户证书失败: %v", err)
+		}
+		serial = certSerial(certs[0])
+	}
+	p := &WechatPayV3Provider{
+		BaseURL:    strings.TrimRight(cfg.APIBaseURL, "/"),
+		AppID:      cfg.AppID,
+		MchID:      cfg.MchID,
+		SerialNo:   serial,
+		APIv3Key:   cfg.APIv3Key,
+		NotifyURL:  cfg.NotifyURL,
+		PrivateKey: key,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		certs:      map[string]*x509.Certificate{},
+		now:        time.Now,
+	}
+	if p.BaseURL == "" {
+		p.BaseURL = wechatDefaultBaseURL
+	}
+	if cfg.PlatformCertPath != "" {
+		raw, err := os.ReadFile(cfg.PlatformCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取微信支付平台证书失败: %w", err)
+		}
+		certs, err := parseCertificates(raw)
+		if err != nil || len(certs) == 0 {
+			return nil, fmt.Errorf("解析微信支付平台证书失败: %v", err)
+		}
+		p.SetPlatformCertificates(certs...)
+		p.fixedCerts = true
+	}
+	return p, nil
+}
+
+func (p *WechatPayV3Provider) Name() string { return "wechat" }
+
+// SetPlatformCertificates 设置平台证书（本地配置或测试注入），设置后不再自动下载
+func (p *WechatPayV3Provider) SetPlatformCertificates(certs ...*x509.Certificate) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.certs == nil {
+		p.certs = map[string]*x509.Certificate{}
+	}
+	for _, c := range certs {
+		p.certs[certSerial(c)] = c
+	}
+	p.fixedCerts = true
+}
+
+// CreatePayment JSAPI 下单，返回小程序 wx.requestPayment 所需参数
+func (p *WechatPayV3Provider) CreatePayment(ctx context.Context, req PayCreateRequest) (*PayCreateResult, error) {
+	if req.OpenID == "" {
+		return nil, errors.New("用户未绑定微信，无法发起 JSAPI 支付")
+	}
+	if req.AmountCents <= 0 {
+		return nil, errors.New("支付金额必须大于 0")
+	}
+	notifyURL := req.NotifyURL
+	if notifyURL == "" {
+		notifyURL = p.NotifyURL
+	}
+	body := map[string]any{
+		"appid":        p.AppID,
+		"mchid":        p.MchID,
+		"description":  req.Description,
+		"out_trade_no": req.PaymentNo,
+		"notify_url":   notifyURL,
+		"amount":       map[string]any{"total": req.AmountCents, "currency": "CNY"},
+		"payer":        map[string]any{"openid": req.OpenID},
+	}
+	if req.ExpireAt != nil {
+		body["time_expire"] = req.ExpireAt.Format(time.RFC3339)
+	}
+	var resp struct {
+		PrepayID string `json:"prepay_id"`
+	}
+	if _, err := p.do(ctx, http.MethodPost, "/v3/pay/transactions/jsapi", body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.PrepayID == "" {
+		return nil, errors.New("微信支付未返回 prepay_id")
+	}
+	params, err := p.JSAPIParams(resp.PrepayID)
+	if err != nil {
+		return nil, err
+	}
+	return &PayCreateResult{PrepayID: resp.PrepayID, ClientParams: params}, nil
+}
+
+// JSAPIParams 生成调起支付参数，paySign = RSA-SHA256(appId\ntimeStamp\nnonceStr\npackage\n)
+func (p *WechatPayV3Provider) JSAPIParams(prepayID string) (map[string]string, error) {
+	ts := strconv.FormatInt(p.now().Unix(), 10)
+	nonce := utils.GenerateRandomString(32)
+	pkg := "prepay_id=" + prepayID
+	sign, err := p.sign(p.AppID + "\n" + ts + "\n" + nonce + "\n" + pkg + "\n")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"appId":     p.AppID,
+		"timeStamp": ts,
+		"nonceStr":  nonce,
+		"package":   pkg,
+		"signType":  "RSA",
+		"paySign":   sign,
+	}, nil
+}
+
+type wechatTransaction struct {
+	OutTradeNo    string `json:"out_trade_no"`
+	TransactionID string `json:"transaction_id"`
+	TradeState    string `json:"trade_state"`
+	SuccessTime   string `json:"success_time"`
+	Amount        struct {
+		Total      int64 `json:"total"`
+		PayerTotal int64 `json:"payer_total"`
+	} `json:"amount"`
+}
+
+// QueryPayment 按商户订单号查询
+func (p *WechatPayV3Provider) QueryPayment(ctx context.Context, paymentNo string) (*PayQueryResult, error) {
+	path := "/v3/pay/transactions/out-trade-no/" + url.PathEscape(paymentNo) + "?mchid=" + url.QueryEscape(p.MchID)
+	var tx wechatTransaction
+	raw, err := p.do(ctx, http.MethodGet, path, nil, &tx)
+	if err != nil {
+		// 下单未成功（如 CreatePayment 失败）或用户未拉起支付时微信侧尚无交易
+		if wechatErrorCode(raw) == "ORDER_NOT_EXIST" {
+			return &PayQueryResult{PaymentNo: paymentNo, TradeState: "NOTPAY", Raw: string(raw)}, nil
+		}
+		return nil, err
+	}
+	return &PayQueryResult{
+		PaymentNo:     tx.OutTradeNo,
+		TransactionID: tx.TransactionID,
+		TradeState:    tx.TradeState,
+		AmountCents:   tx.Amount.Total,
+		PaidAt:        parseWechatTime(tx.SuccessTime),
+		Raw:           string(raw),
+	}, nil
+}
+
+// ClosePayment 关闭未支付订单；微信侧无交易时视为已关闭
+func (p *WechatPayV3Provider) ClosePayment(ctx context.Context, paymentNo string) error {
+	path := "/v3/pay/transactions/out-trade-no/" + url.PathEscape(paymentNo) + "/close"
+	raw, err := p.do(ctx, http.MethodPost, path, map[string]any{"mchid": p.MchID}, nil)
+	if err != nil && wechatErrorCode(raw) == "ORDER_NOT_EXIST" {
+		return nil
+	}
+	return err
+}
+
+// wechatErrorCode 解析微信支付错误应答中的 code
+func wechatErrorCode(raw []byte) string {
+	var e struct {
+		Code string `json:"code"`
+	}
+	_ = json.Unmarshal(raw, &e)
+	return e.Code
+}
+
+type wechatRefund struct {
+	OutTradeNo   string `json:"out_trade_no"`
+	OutRefundNo  string `json:"out_refund_no"`
+	RefundID     string `json:"refund_id"`
+	Status       string `json:"status"`
+	RefundStatus string `json:"refund_status"` // 退款通知中的状态字段
+	SuccessTime  string `json:"success_time"`
+}
+
+// Refund 申请退款
+func (p *WechatPayV3Provider) Refund(ctx context.Context, req PayRefundRequest) (*PayRefundResult, error) {
+	notifyURL := req.NotifyURL
+	if notifyURL == "" {
+		notifyURL = p.NotifyURL
+	}
+	body := map[string]any{
+		"out_trade_no":  req.PaymentNo,
+		"out_refund_no": req.RefundNo,
+		"reason":        req.Reason,
+		"notify_url":    notifyURL,
+		"amount":        map[string]any{"refund": req.RefundCents, "total": req.TotalCents, "currency": "CNY"},
+	}
+	var resp wechatRefund
+	raw, err := p.do(ctx, http.MethodPost, "/v3/refund/domestic/refunds", body, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &PayRefundResult{
+		RefundNo:   resp.OutRefundNo,
+		RefundID:   resp.RefundID,
+		Status:     resp.Status,
+		RefundedAt: parseWechatTime(resp.SuccessTime),
+		Raw:        string(raw),
+	}, nil
+}
+
+// QueryRefund 按商户退款单号查询退款
+func (p *WechatPayV3Provider) QueryRefund(ctx context.Context, _ string, refundNo string) (*PayRefundResult, error) {
+	var resp wechatRefund
+	raw, err := p.do(ctx, http.MethodGet, "/v3/refund/domestic/refunds/"+url.PathEscape(refundNo), nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &PayRefundResult{
+		RefundNo:   resp.OutRefundNo,
+		RefundID:   resp.RefundID,
+		Status:     resp.Status,
+		RefundedAt: parseWechatTime(resp.SuccessTime),
+		Raw:        string(raw),
+	}, nil
+}
+
+// ParseNotify 验签并解密支付/退款通知
+func (p *WechatPayV3Provider) ParseNotify(ctx context.Context, header http.Header, body []byte) (*PayNotifyResult, error) {
+	if err := p.verify(ctx, header, body); err != nil {
+		return nil, err
+	}
+	var notify struct {
+		ID           string         `json:"id"`
+		EventType    string         `json:"event_type"`
+		ResourceType string         `json:"resource_type"`
+		Resource     wechatResource `json:"resource"`
+	}
+	if err := json.Unmarshal(body, &notify); err != nil {
+		return nil, fmt.Errorf("回调报文格式错误: %w", err)
+	}
+	plain, err := p.decryptResource(notify.Resource)
+	if err != nil {
+		return nil, err
+	}
+	// 签名头已在 verify 中校验，nonce/时间戳用于重放防护
+	nonce := header.Get("Wechatpay-Nonce")
+	var notifyTime *time.Time
+	if sec, err := strconv.ParseInt(header.Get("Wechatpay-Timestamp"), 10, 64); err == nil {
+		t := time.Unix(sec, 0)
+		notifyTime = &t
+	}
+	switch {
+	case strings.HasPrefix(notify.EventType, "TRANSACTION."):
+		var tx wechatTransaction
+		if err := json.Unmarshal(plain, &tx); err != nil {
+			return nil, fmt.Errorf("解析支付通知失败: %w", err)
+		}
+		return &PayNotifyResult{
+			Kind:          PayNotifyPayment,
+			PaymentNo:     tx.OutTradeNo,
+			TransactionID: tx.TransactionID,
+			TradeState:    tx.TradeState,
+			AmountCents:   tx.Amount.Total,
+			PaidAt:        parseWechatTime(tx.SuccessTime),
+			Nonce:         nonce,
+			NotifyTime:    notifyTime,
+			Raw:           string(plain),
+		}, nil
+	case strings.HasPrefix(notify.EventType, "REFUND."):
+		var rf wechatRefund
+		if err := json.Unmarshal(plain, &rf); err != nil {
+			return nil, fmt.Errorf("解析退款通知失败: %w", err)
+		}
+		return &PayNotifyResult{
+			Kind:         PayNotifyRefund,
+			PaymentNo:    rf.OutTradeNo,
+			RefundNo:     rf.OutRefundNo,
+			RefundID:     rf.RefundID,
+			RefundStatus: rf.RefundStatus,
+			RefundedAt:   parseWechatTime(rf.SuccessTime),
+			Nonce:        nonce,
+			NotifyTime:   notifyTime,
+			Raw:          string(plain),
+		}, nil
+	default:
+		return nil, fmt.Errorf("不支持的通知类型: %s", notify.EventType)
+	}
+}
+
+// do 发送已签名请求并校验应答签名；out 为空时忽略应答内容
+func (p *WechatPayV3Provider) do(ctx context.Context, method, path string, body any, out any) ([]byte, error) {
+	return p.doWithHeader(ctx, method, path, body, nil, out)
+}
+
+// doWithHeader 同 do，附加额外请求头（如含加密字段时的 Wechatpay-Serial）
+func (p *WechatPayV3Provider) doWithHeader(ctx context.Context, method, path string, body any, header http.Header, out any) ([]byte, error) {
+	var payload []byte
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = b
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.BaseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	auth, err := p.authorization(method, path, payload)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tea-api")
+	for k, vals := range header {
+		req.Header[k] = vals
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求微信支付失败: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("读取微信支付应答失败: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(raw, &e)
+		return raw, fmt.Errorf("微信支付请求失败(%d): %s %s", resp.StatusCode, e.Code, e.Message)
+	}
+	if err := p.verify(ctx, resp.Header, raw); err != nil {
+		return raw, err
+	}
+	if out != nil && len(raw) > 0 {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return raw, fmt.Errorf("解析微信支付应答失败: %w", err)
+		}
+	}
+	return raw, nil
+}
+
+// authorization 构造请求签名头，签名串：METHOD\nURL\ntimestamp\nnonce\nbody\n
+func (p *WechatPayV3Provider) authorization(method, path string, body []byte) (string, error) {
+	ts := strconv.FormatInt(p.now().Unix(), 10)
+	nonce := utils.GenerateRandomString(32)
+	sign, err := p.sign(method + "\n" + path + "\n" + ts + "\n" + nonce + "\n" + string(body) + "\n")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`%s mchid="%s",nonce_str="%s",signature="%s",timestamp="%s",serial_no="%s"`,
+		wechatAuthSchema, p.MchID, nonce, sign, ts, p.SerialNo), nil
+}
+
+func (p *WechatPayV3Provider) sign(message string) (string, error) {
+	sum := sha256.Sum256([]byte(message))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.PrivateKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", fmt.Errorf("签名失败: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// verify 使用平台证书校验应答/通知签名，签名串：timestamp\nnonce\nbody\n
+func (p *WechatPayV3Provider) verify(ctx context.Context, header http.Header, body []byte) error {
+	ts := header.Get("Wechatpay-Timestamp")
+	nonce := header.Get("Wechatpay-Nonce")
+	signature := header.Get("Wechatpay-Signature")
+	serial := header.Get("Wechatpay-Serial")
+	if ts == "" || nonce == "" || signature == "" || serial == "" {
+		return errors.New("微信支付签名头缺失")
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("微信支付签名时间戳无效")
+	}
+	if d := p.now().Sub(time.Unix(sec, 0)); d > wechatMaxClockSkew || d < -wechatMaxClockSkew {
+		return errors.New("微信支付签名已过期")
+	}
+	cert, err := p.platformCert(ctx, serial)
+	if err != nil {
+		return err
+	}
+	return verifyWithCert(cert, ts+"\n"+nonce+"\n"+string(body)+"\n", signature)
+}
+
+func verifyWithCert(cert *x509.Certificate, message, signature string) error {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("平台证书公钥类型不支持")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.New("微信支付签名格式错误")
+	}
+	sum := sha256.Sum256([]byte(message))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+		return errors.New("微信支付签名校验失败")
+	}
+	return nil
+}
+
+// platformCert 按序列号获取平台证书，未命中时（证书轮换）重新下载
+func (p *WechatPayV3Provider) platformCert(ctx context.Context, serial string) (*x509.Certificate, error) {
+	p.mu.RLock()
+	cert, ok := p.certs[serial]
+	fixed := p.fixedCerts
+	p.mu.RUnlock()
+	if ok {
+		return cert, nil
+	}
+	if fixed {
+		return nil, fmt.Errorf("未知的微信支付平台证书: %s", serial)
+	}
+	if err := p.refreshCertificates(ctx); err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if cert, ok := p.certs[serial]; ok {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("未知的微信支付平台证书: %s", serial)
+}
+
+// encryptSensitive 使用平台证书公钥（RSA-OAEP）加密敏感字段，返回所用证书序列号与密文；
+// 优先使用有效期最晚的证书，本地无证书时先下载
+func (p *WechatPayV3Provider) encryptSensitive(ctx context.Context, plain string) (string, string, error) {
+	serial, cert := p.latestPlatformCert()
+	if cert == nil {
+		if err := p.refreshCertificates(ctx); err != nil {
+			return "", "", err
+		}
+		if serial, cert = p.latestPlatformCert(); cert == nil {
+			return "", "", errors.New("缺少微信支付平台证书，无法加密敏感字段")
+		}
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return "", "", errors.New("平台证书公钥类型不支持")
+	}
+	out, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub, []byte(plain), nil)
+	if err != nil {
+		return "", "", fmt.Errorf("加密敏感字段失败: %w", err)
+	}
+	return serial, base64.StdEncoding.EncodeToString(out), nil
+}
+
+func (p *WechatPayV3Provider) latestPlatformCert() (string, *x509.Certificate) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var serial string
+	var latest *x509.Certificate
+	for k, c := range p.certs {
+		if latest == nil || c.NotAfter.After(latest.NotAfter) {
+			serial, latest = k, c
+		}
+	}
+	return serial, latest
+}
+
+type wechatResource struct {
+	Algorithm      string `json:"algorithm"`
+	Ciphertext     string `json:"ciphertext"`
+	AssociatedData string `json:"associated_data"`
+	Nonce          string `json:"nonce"`
+}
+
+// refreshCertificates 下载并解密平台证书，再用新证书校验该应答的签名
+func (p *WechatPayV3Provider) refreshCertificates(ctx context.Context) error {
+	var resp struct {
+		Data []struct {
+			SerialNo           string         `json:"serial_no"`
+			EncryptCertificate wechatResource `json:"encrypt_certificate"`
+		} `json:"data"`
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+"/v3/certificates", nil)
+	if err != nil {
+		return err
+	}
+	auth, err := p.authorization(http.MethodGet, "/v3/certificates", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "tea-api")
+	httpResp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("下载微信支付平台证书失败: %w", err)
+	}
+	defer httpResp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode >= 300 {
+		return fmt.Errorf("下载微信支付平台证书失败(%d)", httpResp.StatusCode)
+	}
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return fmt.Errorf("解析微信支付平台证书失败: %w", err)
+	}
+	fresh := map[string]*x509.Certificate{}
+	for _, d := range resp.Data {
+		plain, err := p.decryptResource(d.EncryptCertificate)
+		if err != nil {
+			return err
+		}
+		certs, err := parseCertificates(plain)
+		if err != nil || len(certs) == 0 {
+			return fmt.Errorf("解析微信支付平台证书失败: %v", err)
+		}
+		fresh[certSerial(certs[0])] = certs[0]
+	}
+	cert, ok := fresh[httpResp.Header.Get("Wechatpay-Serial")]
+	if !ok {
+		return errors.New("平台证书应答签名证书不在下载列表中")
+	}
+	h := httpResp.Header
+	if err := verifyWithCert(cert, h.Get("Wechatpay-Timestamp")+"\n"+h.Get("Wechatpay-Nonce")+"\n"+string(raw)+"\n", h.Get("Wechatpay-Signature")); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	for k, v := range fresh {
+		p.certs[k] = v
+	}
+	p.mu.Unlock()
+	return nil
+}
+
+// decryptResource AEAD_AES_256_GCM 解密回调资源/平台证书
+func (p *WechatPayV3Provider) decryptResource(r wechatResource) ([]byte, error) {
+	if r.Algorithm != "AEAD_AES_256_GCM" {
+		return nil, fmt.Errorf("不支持的加密算法: %s", r.Algorithm)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(r.Ciphertext)
+	if err != nil {
+		return nil, errors.New("密文格式错误")
+	}
+	block, err := aes.NewCipher([]byte(p.APIv3Key))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(r.Nonce))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, []byte(r.Nonce), ciphertext, []byte(r.AssociatedData))
+	if err != nil {
+		return nil, errors.New("解密微信支付通知失败")
+	}
+	return plain, nil
+}
+
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("商户私钥不是有效的 PEM")
+	}
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if rk, ok := k.(*rsa.PrivateKey); ok {
+			return rk, nil
+		}
+		return nil, errors.New("商户私钥不是 RSA 密钥")
+	}
+	k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析商户私钥失败: %w", err)
+	}
+	return k, nil
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	return certs, nil
+}
+
+// certSerial 证书序列号，与微信支付返回的大写十六进制格式一致
+func certSerial(c *x509.Certificate) string {
+	return strings.ToUpper(c.SerialNumber.Text(16))
+}
+
+func parseWechatTime(s string) *time.Time {
+	if s == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+const testAPIv3Key = "0123456789abcdef0123456789abcdef"
+
+// wechatStub 本地微信支付桩：校验商户签名，并以平台私钥签名应答
+type wechatStub struct {
+	t            *testing.T
+	merchantPub  *rsa.PublicKey
+	platformKey  *rsa.PrivateKey
+	platformCert *x509.Certificate
+	platformPEM  []byte
+	lastJSAPI    map[string]any
+	transfers    map[string]string // 商户批次号 -> 明细状态
+	lastTransfer map[string]any
+	lastSerial   string // 最近一次转账请求的 Wechatpay-Serial
+}
+
+func newWechatStub(t *testing.T, merchantPub *rsa.PublicKey) *wechatStub {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("gen platform key: %v", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(0x5157F09EFDC096DE),
+		Subject:      pkix.Name{CommonName: "Tenpay.com Root CA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &wechatStub{
+		t: t, merchantPub: merchantPub, platformKey: key, platformCert: cert,
+		platformPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func rsaSign(t *testing.T, key *rsa.PrivateKey, msg string) string {
+	sum := sha256.Sum256([]byte(msg))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func rsaVerify(pub *rsa.PublicKey, msg, sig string) bool {
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	sum := sha256.Sum256([]byte(msg))
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], raw) == nil
+}
+
+func aesGCMSeal(t *testing.T, plain, nonce, ad string) string {
+	block, _ := aes.NewCipher([]byte(testAPIv3Key))
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		t.Fatalf("gcm: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nil, []byte(nonce), []byte(plain), []byte(ad)))
+}
+
+// signedHeader 生成平台签名头
+func (s *wechatStub) signedHeader(body []byte) http.Header {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := "stubnonce"
+	h := http.Header{}
+	h.Set("Wechatpay-Timestamp", ts)
+	h.Set("Wechatpay-Nonce", nonce)
+	h.Set("Wechatpay-Serial", certSerial(s.platformCert))
+	h.Set("Wechatpay-Signature", rsaSign(s.t, s.platformKey, ts+"\n"+nonce+"\n"+string(body)+"\n"))
+	return h
+}
+
+func (s *wechatStub) reply(w http.ResponseWriter, v any) {
+	body, _ := json.Marshal(v)
+	for k, vals := range s.signedHeader(body) {
+		w.Header()[k] = vals
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+func (s *wechatStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	// 校验商户请求签名
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), wechatAuthSchema+" ")
+	fields := map[string]string{}
+	for _, kv := range strings.Split(auth, ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			fields[k] = strings.Trim(v, `"`)
+		}
+	}
+	msg := r.Method + "\n" + r.URL.RequestURI() + "\n" + fields["timestamp"] + "\n" + fields["nonce_str"] + "\n" + string(body) + "\n"
+	if !rsaVerify(s.merchantPub, msg, fields["signature"]) || fields["mchid"] != "1900000001" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"code":"SIGN_ERROR","message":"签名错误"}`))
+		return
+	}
+	switch {
+	case r.URL.Path == "/v3/certificates":
+		nonce := "certnonce123"
+		s.reply(w, map[string]any{"data": []map[string]any{{
+			"serial_no": certSerial(s.platformCert),
+			"encrypt_certificate": map[string]string{
+				"algorithm":       "AEAD_AES_256_GCM",
+				"nonce":           nonce,
+				"associated_data": "certificate",
+				"ciphertext":      aesGCMSeal(s.t, string(s.platformPEM), nonce, "certificate"),
+			},
+		}}})
+	case r.URL.Path == "/v3/pay/transactions/jsapi":
+		_ = json.Unmarshal(body, &s.lastJSAPI)
+		s.reply(w, map[string]string{"prepay_id": "wx201410272009395522657a690389285100"})
+	case r.URL.Path == "/v3/transfer/batches":
+		var req struct {
+			OutBatchNo string `json:"out_batch_no"`
+		}
+		_ = json.Unmarshal(body, &req)
+		_ = json.Unmarshal(body, &s.lastTransfer)
+		s.lastSerial = r.Header.Get("Wechatpay-Serial")
+		if s.transfers == nil {
+			s.transfers = map[string]string{}
+		}
+		s.transfers[req.OutBatchNo] = "PROCESSING"
+		s.reply(w, map[string]string{"out_batch_no": req.OutBatchNo, "batch_id": "1030000071100999991182020050700019480001", "batch_status": "ACCEPTED"})
+	case strings.HasPrefix(r.URL.Path, "/v3/transfer/batches/out-batch-no/"):
+		no := strings.Split(strings.TrimPrefix(r.URL.Path, "/v3/transfer/batches/out-batch-no/"), "/")[0]
+		status, ok := s.transfers[no]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"code":"NOT_FOUND","message":"记录不存在"}`))
+			return
+		}
+		d := map[string]string{"out_batch_no": no, "out_detail_no": no, "detail_id": "1040000071100999991182020050700019500100", "detail_status": status, "update_time": "2026-10-19T10:00:00+08:00"}
+		if status == "FAIL" {
+			d["fail_reason"] = "ACCOUNT_FROZEN"
+		}
+		s.reply(w, d)
+	case strings.HasPrefix(r.URL.Path, "/v3/pay/transactions/out-trade-no/"):
+		// 未成功下单的商户订单号
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"code":"ORDER_NOT_EXIST","message":"订单不存在"}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestWechatProvider(t *testing.T) (*WechatPayV3Provider, *wechatStub) {
+	t.Helper()
+	mkey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("gen merchant key: %v", err)
+	}
+	stub := newWechatStub(t, &mkey.PublicKey)
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	p := &WechatPayV3Provider{
+		BaseURL: srv.URL, AppID: "wxd678efh567hg6787", MchID: "1900000001", SerialNo: "MERCHANTSERIAL",
+		APIv3Key: testAPIv3Key, NotifyURL: "https://example.com/api/v1/payment/wechat/notify",
+		PrivateKey: mkey, Client: srv.Client(), certs: map[string]*x509.Certificate{}, now: time.Now,
+	}
+	return p, stub
+}
+
+func TestWechatPayV3_CreatePaymentAndPaySign(t *testing.T) {
+	p, stub := newTestWechatProvider(t)
+	res, err := p.CreatePayment(context.Background(), PayCreateRequest{
+		PaymentNo: "P202401010001", Description: "订单ORD1", AmountCents: 3600, OpenID: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	})
+	if err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+	if stub.lastJSAPI["out_trade_no"] != "P202401010001" || stub.lastJSAPI["amount"].(map[string]any)["total"].(float64) != 3600 {
+		t.Fatalf("unexpected jsapi request: %+v", stub.lastJSAPI)
+	}
+	params := res.ClientParams
+	if params["package"] != "prepay_id="+res.PrepayID || params["signType"] != "RSA" {
+		t.Fatalf("unexpected client params: %+v", params)
+	}
+	msg := params["appId"] + "\n" + params["timeStamp"] + "\n" + params["nonceStr"] + "\n" + params["package"] + "\n"
+	if !rsaVerify(&p.PrivateKey.PublicKey, msg, params["paySign"]) {
+		t.Fatalf("paySign does not verify with merchant key")
+	}
+	// 平台证书应已自动下载
+	if _, ok := p.certs[certSerial(stub.platformCert)]; !ok {
+		t.Fatalf("platform certificate should be downloaded and cached")
+	}
+
+	if _, err := p.CreatePayment(context.Background(), PayCreateRequest{PaymentNo: "P2", AmountCents: 1}); err == nil {
+		t.Fatalf("JSAPI payment without openid should fail")
+	}
+}
+
+func wechatNotifyBody(t *testing.T, eventType string, resource any) []byte {
+	plain, _ := json.Marshal(resource)
+	nonce := "fdasflkja484"
+	body, _ := json.Marshal(map[string]any{
+		"id":            "EV-2018022511223320873",
+		"event_type":    eventType,
+		"resource_type": "encrypt-resource",
+		"resource": map[string]string{
+			"algorithm":       "AEAD_AES_256_GCM",
+			"nonce":           nonce,
+			"associated_data": "transaction",
+			"ciphertext":      aesGCMSeal(t, string(plain), nonce, "transaction"),
+		},
+	})
+	return body
+}
+
+func TestWechatPayV3_NotifyMarksOrderPaid(t *testing.T) {
+	p, stub := newTestWechatProvider(t)
+	p.SetPlatformCertificates(stub.platformCert)
+
+	db, err := gorm.Open(sqlite.Open("file:wechat_notify?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Order{}, &model.Payment{}, &model.StockReservation{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	order := model.Order{OrderNo: "ORDW1", UserID: 1, Status: 1, PayStatus: 1, TotalAmount: decimal.NewFromInt(36), PayAmount: decimal.NewFromInt(36)}
+	db.Create(&order)
+	pay := model.Payment{OrderID: order.ID, PaymentNo: "PW0001", PaymentMethod: 1, Amount: decimal.NewFromInt(36), Status: 1}
+	db.Create(&pay)
+	svc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return p, nil }}
+
+	tx := map[string]any{
+		"out_trade_no": "PW0001", "transaction_id": "4200000000202401010000000001", "trade_state": "SUCCESS",
+		"success_time": "2024-01-01T10:00:00+08:00", "amount": map[string]any{"total": 3600, "payer_total": 3600},
+	}
+	body := wechatNotifyBody(t, "TRANSACTION.SUCCESS", tx)
+
+	// 篡改报文：验签失败
+	tampered := append([]byte{}, body...)
+	tampered[len(tampered)-2] = ' '
+	if err := svc.HandleProviderNotify(context.Background(), PayMethodWechat, stub.signedHeader(body), tampered); err == nil {
+		t.Fatalf("tampered notify should be rejected")
+	}
+
+	// 金额不一致：拒绝入账
+	bad := wechatNotifyBody(t, "TRANSACTION.SUCCESS", map[string]any{"out_trade_no": "PW0001", "trade_state": "SUCCESS", "amount": map[string]any{"total": 1}})
+	if err := svc.HandleProviderNotify(context.Background(), PayMethodWechat, stub.signedHeader(bad), bad); err == nil {
+		t.Fatalf("amount mismatch should be rejected")
+	}
+
+	if err := svc.HandleProviderNotify(context.Background(), PayMethodWechat, stub.signedHeader(body), body); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+	db.First(&pay, pay.ID)
+	db.First(&order, order.ID)
+	if pay.Status != 2 || pay.ThirdPayNo != "4200000000202401010000000001" || order.PayStatus != 2 || order.Status != 2 {
+		t.Fatalf("payment/order not marked paid: pay=%d order=%d/%d", pay.Status, order.Status, order.PayStatus)
+	}
+}
+
+func TestWechatPayV3_QueryMissingOrderIsNotPaid(t *testing.T) {
+	p, _ := newTestWechatProvider(t)
+	q, err := p.QueryPayment(context.Background(), "P20261019000000001")
+	if err != nil || q.TradeState != "NOTPAY" {
+		t.Fatalf("ORDER_NOT_EXIST should map to NOTPAY, got %+v err=%v", q, err)
+	}
+	if err := p.ClosePayment(context.Background(), "P20261019000000001"); err != nil {
+		t.Fatalf("closing a missing order should succeed, got %v", err)
+	}
+}
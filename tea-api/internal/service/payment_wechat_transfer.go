@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// 微信商家转账到零钱：每笔提现单独成批（批次号与明细号均取 PartnerTradeNo），批次号在微信侧唯一，重复提交不会重复打款。
+
+type wechatTransferDetail struct {
+	OutBatchNo   string `json:"out_batch_no"`
+	OutDetailNo  string `json:"out_detail_no"`
+	DetailID     string `json:"detail_id"`
+	DetailStatus string `json:"detail_status"` // INIT / WAIT_PAY / PROCESSING / SUCCESS / FAIL
+	FailReason   string `json:"fail_reason"`
+	UpdateTime   string `json:"update_time"`
+}
+
+// Transfer 发起商家转账（单笔批次）
+func (p *WechatPayV3Provider) Transfer(ctx context.Context, req TransferRequest) (*TransferResult, error) {
+	if req.OpenID == "" {
+		return nil, ErrTransferNoOpenID
+	}
+	detail := map[string]any{
+		"out_detail_no":   req.PartnerTradeNo,
+		"transfer_amount": req.AmountCents,
+		"transfer_remark": truncateText(req.Description, 32),
+		"openid":          req.OpenID,
+	}
+	var header http.Header
+	if req.AmountCents >= transferRealNameMinCents {
+		// 大额转账需传收款人实名：平台证书公钥加密，并以 Wechatpay-Serial 告知所用证书
+		if req.UserName == "" {
+			return nil, ErrTransferRealNameRequired
+		}
+		serial, cipherText, err := p.encryptSensitive(ctx, req.UserName)
+		if err != nil {
+			return nil, err
+		}
+		detail["user_name"] = cipherText
+		header = http.Header{"Wechatpay-Serial": []string{serial}}
+	}
+	body := map[string]any{
+		"appid":                p.AppID,
+		"out_batch_no":         req.PartnerTradeNo,
+		"batch_name":           truncateText(req.Description, 32),
+		"batch_remark":         truncateText(req.Description, 32),
+		"total_amount":         req.AmountCents,
+		"total_num":            1,
+		"transfer_detail_list": []map[string]any{detail},
+	}
+	if req.NotifyURL != "" {
+		body["notify_url"] = req.NotifyURL
+	}
+	var resp struct {
+		OutBatchNo  string `json:"out_batch_no"`
+		BatchID     string `json:"batch_id"`
+		BatchStatus string `json:"batch_status"`
+	}
+	raw, err := p.doWithHeader(ctx, http.MethodPost, "/v3/transfer/batches", body, header, &resp)
+	if err != nil {
+		return nil, err
+	}
+	// 受理成功仅代表批次已创建，明细结果以查询或回调为准
+	return &TransferResult{PartnerTradeNo: req.PartnerTradeNo, PaymentNo: resp.BatchID, Status: TransferStatusProcessing, Raw: string(raw)}, nil
+}
+
+// QueryTransfer 按商户批次号/明细号查询转账明细；微信侧不存在时返回 ErrTransferNotFound
+func (p *WechatPayV3Provider) QueryTransfer(ctx context.Context, partnerTradeNo string) (*TransferResult, error) {
+	path := "/v3/transfer/batches/out-batch-no/" + url.PathEscape(partnerTradeNo) + "/details/out-detail-no/" + url.PathEscape(partnerTradeNo)
+	var d wechatTransferDetail
+	raw, err := p.do(ctx, http.MethodGet, path, nil, &d)
+	if err != nil {
+		if wechatErrorCode(raw) == "NOT_FOUND" {
+			return nil, ErrTransferNotFound
+		}
+		return nil, err
+	}
+	res := &TransferResult{PartnerTradeNo: partnerTradeNo, PaymentNo: d.DetailID, Raw: string(raw)}
+	switch d.DetailStatus {
+	case "SUCCESS":
+		res.Status = TransferStatusSuccess
+		res.PaidAt = parseWechatTime(d.UpdateTime)
+	case "FAIL":
+		res.Status = TransferStatusFailed
+		res.FailCode = d.FailReason
+		res.FailReason = wechatTransferFailReason(d.FailReason)
+	default:
+		res.Status = TransferStatusProcessing
+	}
+	return res, nil
+}
+
+// ParseTransferNotify 验签并解密商家转账批次通知，返回批次号（即 PartnerTradeNo）；明细结果由调用方查询确认
+func (p *WechatPayV3Provider) ParseTransferNotify(ctx context.Context, header http.Header, body []byte) (string, error) {
+	if err := p.verify(ctx, header, body); err != nil {
+		return "", err
+	}
+	var notify struct {
+		EventType string         `json:"event_type"`
+		Resource  wechatResource `json:"resource"`
+	}
+	if err := json.Unmarshal(body, &notify); err != nil {
+		return "", fmt.Errorf("回调报文格式错误: %w", err)
+	}
+	if !strings.HasPrefix(notify.EventType, "MCHTRANSFER.") {
+		return "", fmt.Errorf("不支持的通知类型: %s", notify.EventType)
+	}
+	plain, err := p.decryptResource(notify.Resource)
+	if err != nil {
+		return "", err
+	}
+	var batch struct {
+		OutBatchNo string `json:"out_batch_no"`
+	}
+	if err := json.Unmarshal(plain, &batch); err != nil || batch.OutBatchNo == "" {
+		return "", fmt.Errorf("解析转账通知失败: %v", err)
+	}
+	return batch.OutBatchNo, nil
+}
+
+// wechatTransferFailReason 常见失败原因的中文说明，未知原因原样返回
+func wechatTransferFailReason(code string) string {
+	switch code {
+	case "ACCOUNT_FROZEN":
+		return "用户账户已冻结"
+	case "REAL_NAME_CHECK_FAIL":
+		return "用户未实名认证"
+	case "NAME_NOT_CORRECT":
+		return "用户姓名校验失败"
+	case "OPENID_INVALID":
+		return "openid 无效"
+	case "TRANSFER_QUOTA_EXCEED":
+		return "超出用户单笔收款额度"
+	case "DAY_RECEIVED_QUOTA_EXCEED":
+		return "超出用户单日收款额度"
+	case "ACCOUNT_NOT_EXIST":
+		return "用户零钱账户不存在"
+	case "TRANSFER_RISK":
+		return "转账存在风险，已被拦截"
+	case "":
+		return "转账失败"
+	default:
+		return code
+	}
+}
@@ -0,0 +1,594 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/model"
+	"tea-api/pkg/database"
+	"tea-api/pkg/utils"
+)
+
+// PointsRulesConfigKey 积分发放与抵扣规则在 SystemConfig 中的键（JSON）
+const PointsRulesConfigKey = "points.rules"
+
+// PointsMultiplier 积分倍率：按商品分类、门店或会员套餐匹配
+type PointsMultiplier struct {
+	TargetID   uint            `json:"target_id"`
+	Multiplier decimal.Decimal `json:"multiplier"`
+}
+
+// PointsRules 积分发放规则
+// 订单积分 = Σ(商品分摊实付 × 每元积分 × 分类倍率) × 门店倍率 × 会员倍率，向下取整，受每日上限约束；
+// 注册奖励与首单奖励各发放一次，不计入每日上限。
+type PointsRules struct {
+	Enabled               bool               `json:"enabled"`
+	PointsPerYuan         decimal.Decimal    `json:"points_per_yuan"`
+	CategoryMultipliers   []PointsMultiplier `json:"category_multipliers"`
+	StoreMultipliers      []PointsMultiplier `json:"store_multipliers"`
+	MembershipMultipliers []PointsMultiplier `json:"membership_multipliers"` // target_id 为会员套餐ID
+	SignupBonus           int64              `json:"signup_bonus"`
+	FirstOrderBonus       int64              `json:"first_order_bonus"`
+	DailyCap              int64              `json:"daily_cap"` // 每个用户每日订单积分上限，0 表示不限
+
+	// 下单积分抵扣：RedeemPointsPerYuan 积分抵 1 元，抵扣金额不超过（商品总额-优惠券）× RedeemMaxPercent%，
+	// 且（商品总额-优惠券）不低于 RedeemMinOrderAmount 时才可抵扣
+	RedeemEnabled        bool            `json:"redeem_enabled"`
+	RedeemPointsPerYuan  int64           `json:"redeem_points_per_yuan"`
+	RedeemMaxPercent     decimal.Decimal `json:"redeem_max_percent"`
+	RedeemMinOrderAmount decimal.Decimal `json:"redeem_min_order_amount"`
+
+	// 积分有效期：year_end 为第 N 年获得的积分于第 N+ExpiryYears 年末过期，rolling 为获得后 ExpiryDays 天过期，
+	// 为空表示不过期；ExpiryNoticeDays 为到期前多少天发送提醒，0 表示不提醒
+	ExpiryMode       string `json:"expiry_mode"`
+	ExpiryYears      int    `json:"expiry_years"`
+	ExpiryDays       int    `json:"expiry_days"`
+	ExpiryNoticeDays int    `json:"expiry_notice_days"`
+}
+
+// 积分有效期模式
+const (
+	PointsExpiryNone    = ""
+	PointsExpiryYearEnd = "year_end"
+	PointsExpiryRolling = "rolling"
+)
+
+// ExpiresAt 按有效期规则计算 earnedAt 获得的积分的到期时间，不过期时返回 nil
+func (r *PointsRules) ExpiresAt(earnedAt time.Time) *time.Time {
+	var t time.Time
+	switch r.ExpiryMode {
+	case PointsExpiryYearEnd:
+		years := max(r.ExpiryYears, 1)
+		t = time.Date(earnedAt.Year()+years+1, 1, 1, 0, 0, 0, 0, earnedAt.Location())
+	case PointsExpiryRolling:
+		if r.ExpiryDays <= 0 {
+			return nil
+		}
+		t = earnedAt.AddDate(0, 0, r.ExpiryDays)
+	default:
+		return nil
+	}
+	return &t
+}
+
+func (r *PointsRules) Validate() error {
+	if r.PointsPerYuan.IsNegative() {
+		return errors.New("每元积分不能为负")
+	}
+	if r.SignupBonus < 0 || r.FirstOrderBonus < 0 || r.DailyCap < 0 {
+		return errors.New("奖励积分与每日上限不能为负")
+	}
+	if r.RedeemEnabled {
+		if r.RedeemPointsPerYuan <= 0 {
+			return errors.New("积分抵扣比例须大于 0")
+		}
+		if !r.RedeemMaxPercent.IsPositive() || r.RedeemMaxPercent.GreaterThan(decimal.NewFromInt(100)) {
+			return errors.New("积分抵扣上限比例须在 0-100 之间")
+		}
+		if r.RedeemMinOrderAmount.IsNegative() {
+			return errors.New("积分抵扣门槛不能为负")
+		}
+	}
+	switch r.ExpiryMode {
+	case PointsExpiryNone, PointsExpiryYearEnd:
+	case PointsExpiryRolling:
+		if r.ExpiryDays <= 0 {
+			return errors.New("滚动有效期天数须大于 0")
+		}
+	default:
+		return errors.New("未知的积分有效期模式")
+	}
+	if r.ExpiryYears < 0 || r.ExpiryNoticeDays < 0 {
+		return errors.New("有效期年数与提醒天数不能为负")
+	}
+	for _, list := range [][]PointsMultiplier{r.CategoryMultipliers, r.StoreMultipliers, r.MembershipMultipliers} {
+		for _, m := range list {
+			if m.TargetID == 0 || !m.Multiplier.IsPositive() {
+				return errors.New("倍率须指定 target_id 且大于 0")
+			}
+		}
+	}
+	return nil
+}
+
+func multiplierFor(list []PointsMultiplier, id uint) decimal.Decimal {
+	for _, m := range list {
+		if m.TargetID == id {
+			return m.Multiplier
+		}
+	}
+	return decimal.NewFromInt(1)
+}
+
+type PointsService struct {
+	db *gorm.DB
+}
+
+func NewPointsService() *PointsService {
+	return &PointsService{db: database.GetDB()}
+}
+
+// LoadPointsRules 读取启用中的积分规则，未配置时返回关闭状态的默认规则
+func LoadPointsRules(db *gorm.DB) (*PointsRules, error) {
+	rules := &PointsRules{PointsPerYuan: decimal.NewFromInt(1)}
+	var cfgs []model.SystemConfig
+	if err := db.Where("config_key = ? AND status = 1", PointsRulesConfigKey).Limit(1).Find(&cfgs).Error; err != nil {
+		return nil, err
+	}
+	if len(cfgs) == 0 || strings.TrimSpace(cfgs[0].ConfigValue) == "" {
+		return rules, nil
+	}
+	if err := json.Unmarshal([]byte(cfgs[0].ConfigValue), rules); err != nil {
+		return nil, fmt.Errorf("积分规则配置格式错误: %w", err)
+	}
+	return rules, nil
+}
+
+// SaveRules 校验并保存积分规则
+func (s *PointsService) SaveRules(rules *PointsRules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	var cfg model.SystemConfig
+	if err := s.db.Where("config_key = ?", PointsRulesConfigKey).Limit(1).Find(&cfg).Error; err != nil {
+		return err
+	}
+	if cfg.ID == 0 {
+		cfg = model.SystemConfig{
+			BaseModel:   model.BaseModel{UID: utils.GenerateUID()},
+			ConfigKey:   PointsRulesConfigKey,
+			ConfigValue: string(b),
+			ConfigType:  "json",
+			Description: "积分发放与抵扣规则",
+			Status:      1,
+		}
+		return s.db.Create(&cfg).Error
+	}
+	return s.db.Model(&model.SystemConfig{}).Where("id = ?", cfg.ID).
+		Updates(map[string]any{"config_value": string(b), "status": 1}).Error
+}
+
+// postPointsTx 在调用方事务内记积分流水并同步 users.points。
+// bizKey 非空时同一键只记一次；扣减超过可用积分时只扣至 0（流水记录实际扣减值）。
+// 入账按有效期规则生成积分批次，扣减按到期先后消耗批次；过期流水由过期任务直接清零对应批次。
+func postPointsTx(tx *gorm.DB, userID uint, change int64, typ, reason string, orderID *uint, bizKey string) (*model.PointsTransaction, error) {
+	if change == 0 {
+		return nil, nil
+	}
+	if bizKey != "" {
+		var exist model.PointsTransaction
+		if err := tx.Where("biz_key = ?", bizKey).Limit(1).Find(&exist).Error; err != nil {
+			return nil, err
+		}
+		if exist.ID != 0 {
+			return &exist, nil
+		}
+	}
+	var user model.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "points").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	current := int64(user.Points)
+	if current+change < 0 {
+		change = -current
+		reason += "（可用积分不足，扣至 0）"
+	}
+	if change != 0 {
+		if err := tx.Model(&model.User{}).Where("id = ?", userID).
+			Update("points", gorm.Expr("points + ?", change)).Error; err != nil {
+			return nil, err
+		}
+	}
+	rec := &model.PointsTransaction{
+		UserID:       userID,
+		Change:       change,
+		Type:         typ,
+		Reason:       truncateText(reason, 255),
+		OrderID:      orderID,
+		BalanceAfter: current + change,
+	}
+	if bizKey != "" {
+		key := bizKey
+		rec.BizKey = &key
+	}
+	if err := tx.Create(rec).Error; err != nil {
+		return nil, err
+	}
+	switch {
+	case change > 0:
+		rules, err := LoadPointsRules(tx)
+		if err != nil {
+			return nil, err
+		}
+		txID := rec.ID
+		if err := createPointsLot(tx, userID, change, &txID, rec.CreatedAt, rules); err != nil {
+			return nil, err
+		}
+	case change < 0 && typ != model.PointsTxExpire:
+		if err := consumePointsLots(tx, userID, -change); err != nil {
+			return nil, err
+		}
+	}
+	return rec, nil
+}
+
+func createPointsLot(tx *gorm.DB, userID uint, points int64, txID *uint, earnedAt time.Time, rules *PointsRules) error {
+	return tx.Create(&model.PointsLot{
+		UserID:    userID,
+		TxID:      txID,
+		Points:    points,
+		Remaining: points,
+		EarnedAt:  earnedAt,
+		ExpiresAt: rules.ExpiresAt(earnedAt),
+	}).Error
+}
+
+// consumePointsLots 按到期时间先后扣减积分批次（不过期的批次最后扣），批次不足时扣完为止
+func consumePointsLots(tx *gorm.DB, userID uint, points int64) error {
+	var lots []model.PointsLot
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND remaining > 0", userID).
+		Order("CASE WHEN expires_at IS NULL THEN 1 ELSE 0 END, expires_at, id").
+		Find(&lots).Error; err != nil {
+		return err
+	}
+	for _, lot := range lots {
+		if points <= 0 {
+			break
+		}
+		use := min(points, lot.Remaining)
+		if err := tx.Model(&model.PointsLot{}).Where("id = ?", lot.ID).
+			Update("remaining", gorm.Expr("remaining - ?", use)).Error; err != nil {
+			return err
+		}
+		points -= use
+	}
+	return nil
+}
+
+// GrantSignupPoints 新用户注册奖励；失败只记录日志，不影响登录注册
+func GrantSignupPoints(db *gorm.DB, userID uint) {
+	rules, err := LoadPointsRules(db)
+	if err != nil || !rules.Enabled || rules.SignupBonus <= 0 {
+		return
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		_, err := postPointsTx(tx, userID, rules.SignupBonus, model.PointsTxSignupBonus, "注册奖励", nil,
+			"signup:"+strconv.FormatUint(uint64(userID), 10))
+		return err
+	}); err != nil {
+		zap.L().Warn("grant signup points failed", zap.Uint("user_id", userID), zap.Error(err))
+	}
+}
+
+// earnOrderPointsTx 订单完成时在同一事务内发放订单积分与首单奖励，充值与积分兑换订单不计积分
+func earnOrderPointsTx(tx *gorm.DB, order *model.Order, now time.Time) error {
+	if order.OrderType == OrderTypeRecharge || order.OrderType == OrderTypePointsMall {
+		return nil
+	}
+	rules, err := LoadPointsRules(tx)
+	if err != nil || !rules.Enabled {
+		return err
+	}
+	if paid, err := orderPaymentConfirmed(tx, order.ID); err != nil || !paid {
+		return err
+	}
+	orderID := order.ID
+	pts, err := orderPoints(tx, rules, order)
+	if err != nil {
+		return err
+	}
+	if pts > 0 && rules.DailyCap > 0 {
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		var today int64
+		if err := tx.Model(&model.PointsTransaction{}).Select("COALESCE(SUM(`change`), 0)").
+			Where("user_id = ? AND type = ? AND created_at >= ?", order.UserID, model.PointsTxOrderEarn, start).
+			Scan(&today).Error; err != nil {
+			return err
+		}
+		if remain := rules.DailyCap - today; pts > remain {
+			pts = max(remain, 0)
+		}
+	}
+	if _, err := postPointsTx(tx, order.UserID, pts, model.PointsTxOrderEarn, fmt.Sprintf("订单%s完成获得积分", order.OrderNo),
+		&orderID, fmt.Sprintf("order_earn:%d", order.ID)); err != nil {
+		return err
+	}
+
+	if rules.FirstOrderBonus > 0 {
+		var prior int64
+		if err := tx.Model(&model.Order{}).
+			Where("user_id = ? AND status = 4 AND order_type NOT IN ? AND id <> ?", order.UserID, []int{OrderTypeRecharge, OrderTypePointsMall}, order.ID).
+			Count(&prior).Error; err != nil {
+			return err
+		}
+		if prior == 0 {
+			if _, err := postPointsTx(tx, order.UserID, rules.FirstOrderBonus, model.PointsTxFirstOrderBonus,
+				fmt.Sprintf("首单奖励（订单%s）", order.OrderNo), &orderID,
+				"first_order:"+strconv.FormatUint(uint64(order.UserID), 10)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// orderPoints 按规则计算订单积分：实付金额按商品金额比例分摊后乘分类倍率，再乘门店与会员倍率
+func orderPoints(tx *gorm.DB, rules *PointsRules, order *model.Order) (int64, error) {
+	if !order.PayAmount.IsPositive() || !rules.PointsPerYuan.IsPositive() {
+		return 0, nil
+	}
+	var items []struct {
+		Amount     decimal.Decimal
+		CategoryID uint
+	}
+	if err := tx.Table("order_items AS oi").Select("oi.amount, p.category_id").
+		Joins("LEFT JOIN products AS p ON p.id = oi.product_id").
+		Where("oi.order_id = ? AND oi.deleted_at IS NULL", order.ID).Scan(&items).Error; err != nil {
+		return 0, err
+	}
+	itemTotal := decimal.Zero
+	for _, it := range items {
+		itemTotal = itemTotal.Add(it.Amount)
+	}
+	var pts decimal.Decimal
+	if itemTotal.IsPositive() {
+		for _, it := range items {
+			share := order.PayAmount.Mul(it.Amount).Div(itemTotal)
+			pts = pts.Add(share.Mul(rules.PointsPerYuan).Mul(multiplierFor(rules.CategoryMultipliers, it.CategoryID)))
+		}
+	} else {
+		pts = order.PayAmount.Mul(rules.PointsPerYuan)
+	}
+	if order.StoreID > 0 {
+		pts = pts.Mul(multiplierFor(rules.StoreMultipliers, order.StoreID))
+	}
+	if len(rules.MembershipMultipliers) > 0 {
+		pkg, err := activeMembershipPackage(tx, order.UserID)
+		if err != nil {
+			return 0, err
+		}
+		if pkg > 0 {
+			pts = pts.Mul(multiplierFor(rules.MembershipMultipliers, pkg))
+		}
+	}
+	return pts.Floor().IntPart(), nil
+}
+
+// activeMembershipPackage 用户当前生效的会员套餐ID，无会员记录时为 0
+func activeMembershipPackage(tx *gorm.DB, userID uint) (uint, error) {
+	var ids []uint
+	if err := tx.Table("user_memberships").Where("user_id = ? AND status = 'active'", userID).
+		Order("started_at DESC").Limit(1).Pluck("package_id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return ids[0], nil
+}
+
+// reverseOrderPointsTx 退款成功后在同一事务内按累计退款比例处理积分：
+// 扣回订单积分（应扣回 = 订单积分 × 累计成功退款 / 实付，减去已扣回部分），
+// 并按同一比例退还下单抵扣的积分；首单与注册奖励不扣回。
+func reverseOrderPointsTx(tx *gorm.DB, r *model.Refund) error {
+	var order model.Order
+	if err := tx.Select("id", "user_id", "order_no", "pay_amount", "points_used").First(&order, r.OrderID).Error; err != nil {
+		return err
+	}
+	var refunded decimal.Decimal
+	if err := tx.Model(&model.Refund{}).Select("COALESCE(SUM(refund_amount), 0)").
+		Where("order_id = ? AND status = ?", r.OrderID, model.RefundStatusSuccess).Scan(&refunded).Error; err != nil {
+		return err
+	}
+	proportional := func(points int64) int64 {
+		if !order.PayAmount.IsPositive() || !refunded.LessThan(order.PayAmount) {
+			return points
+		}
+		return decimal.NewFromInt(points).Mul(refunded).Div(order.PayAmount).Floor().IntPart()
+	}
+	orderID := order.ID
+
+	earned, err := sumOrderPoints(tx, order.ID, model.PointsTxOrderEarn)
+	if err != nil {
+		return err
+	}
+	if earned > 0 {
+		reversed, err := sumOrderPoints(tx, order.ID, model.PointsTxOrderReverse)
+		if err != nil {
+			return err
+		}
+		if delta := proportional(earned) + reversed; delta > 0 { // reversed 为负数
+			if _, err := postPointsTx(tx, order.UserID, -delta, model.PointsTxOrderReverse,
+				fmt.Sprintf("订单%s退款扣回积分", order.OrderNo), &orderID, fmt.Sprintf("order_reverse:%d", r.ID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if order.PointsUsed > 0 {
+		returned, err := sumOrderPoints(tx, order.ID, model.PointsTxDeductReturn)
+		if err != nil {
+			return err
+		}
+		if delta := proportional(order.PointsUsed) - returned; delta > 0 {
+			if _, err := postPointsTx(tx, order.UserID, delta, model.PointsTxDeductReturn,
+				fmt.Sprintf("订单%s退款退还抵扣积分", order.OrderNo), &orderID, fmt.Sprintf("refund_deduct_return:%d", r.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sumOrderPoints(tx *gorm.DB, orderID uint, typ string) (int64, error) {
+	var sum int64
+	err := tx.Model(&model.PointsTransaction{}).Select("COALESCE(SUM(`change`), 0)").
+		Where("order_id = ? AND type = ?", orderID, typ).Scan(&sum).Error
+	return sum, err
+}
+
+// applyOrderPointsDeduction 下单时按抵扣规则计算可用积分与抵扣金额，base 为商品总额减优惠券后的金额。
+// 实际使用积分 = min(请求积分, 可用积分, 上限对应积分)，抵扣金额精确到分，不足 1 分的积分不扣。
+func applyOrderPointsDeduction(tx *gorm.DB, userID uint, usePoints int64, base decimal.Decimal) (int64, decimal.Decimal, error) {
+	if usePoints <= 0 || !base.IsPositive() {
+		return 0, decimal.Zero, nil
+	}
+	rules, err := LoadPointsRules(tx)
+	if err != nil {
+		return 0, decimal.Zero, err
+	}
+	if !rules.Enabled || !rules.RedeemEnabled || rules.RedeemPointsPerYuan <= 0 {
+		return 0, decimal.Zero, errors.New("暂不支持积分抵扣")
+	}
+	if base.LessThan(rules.RedeemMinOrderAmount) {
+		return 0, decimal.Zero, fmt.Errorf("订单满%s元才可使用积分抵扣", rules.RedeemMinOrderAmount.StringFixed(2))
+	}
+	var user model.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "points").First(&user, userID).Error; err != nil {
+		return 0, decimal.Zero, err
+	}
+	if int64(user.Points) < usePoints {
+		return 0, decimal.Zero, errors.New("可用积分不足")
+	}
+	rate := decimal.NewFromInt(rules.RedeemPointsPerYuan)
+	maxDeduct := base.Mul(rules.RedeemMaxPercent).Div(decimal.NewFromInt(100)).Truncate(2)
+	deduct := decimal.NewFromInt(usePoints).Div(rate).Truncate(2)
+	if deduct.GreaterThan(maxDeduct) {
+		deduct = maxDeduct
+	}
+	if !deduct.IsPositive() {
+		return 0, decimal.Zero, nil
+	}
+	return deduct.Mul(rate).Ceil().IntPart(), deduct, nil
+}
+
+// returnCancelledOrderPointsTx 待付款订单取消时全额退还抵扣积分
+func returnCancelledOrderPointsTx(tx *gorm.DB, order *model.Order) error {
+	if order.PointsUsed <= 0 {
+		return nil
+	}
+	orderID := order.ID
+	_, err := postPointsTx(tx, order.UserID, order.PointsUsed, model.PointsTxDeductReturn,
+		fmt.Sprintf("订单%s取消退还积分", order.OrderNo), &orderID, fmt.Sprintf("cancel_deduct_return:%d", order.ID))
+	return err
+}
+
+// PointsReconcileReport 积分期初对齐结果
+type PointsReconcileReport struct {
+	Users      int   `json:"users"`       // users.points 与流水合计不一致的用户数
+	TotalDelta int64 `json:"total_delta"` // 补记的期初差额合计
+	LotUsers   int   `json:"lot_users"`   // users.points 与积分批次剩余合计不一致的用户数
+	LotDelta   int64 `json:"lot_delta"`   // 补建（正）或扣减（负）的批次积分合计
+}
+
+// ReconcileOpeningBalances 对 users.points 与流水合计不一致的用户补记期初流水（不改动 users.points），
+// 使流水合计与 users.points 一致；再按 users.points 补齐积分批次（期初批次自对齐时起按有效期规则计算到期）。
+// dryRun 为 true 时只统计不落库。重复执行只补差额。
+func (s *PointsService) ReconcileOpeningBalances(dryRun bool) (*PointsReconcileReport, error) {
+	var rows []struct {
+		ID     uint
+		Points int64
+		Total  int64
+	}
+	if err := s.db.Table("users").
+		Select("users.id, users.points, COALESCE(SUM(pt.`change`), 0) AS total").
+		Joins("LEFT JOIN points_transactions AS pt ON pt.user_id = users.id").
+		Where("users.deleted_at IS NULL").
+		Group("users.id, users.points").
+		Having("users.points <> COALESCE(SUM(pt.`change`), 0)").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	rep := &PointsReconcileReport{}
+	for _, r := range rows {
+		diff := r.Points - r.Total
+		rep.Users++
+		rep.TotalDelta += diff
+		if dryRun {
+			continue
+		}
+		if err := s.db.Create(&model.PointsTransaction{
+			UserID:       r.ID,
+			Change:       diff,
+			Type:         model.PointsTxOpeningBalance,
+			Reason:       "期初积分对齐",
+			BalanceAfter: r.Points,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var lotRows []struct {
+		ID     uint
+		Points int64
+		Total  int64
+	}
+	if err := s.db.Table("users").
+		Select("users.id, users.points, COALESCE(SUM(pl.remaining), 0) AS total").
+		Joins("LEFT JOIN points_lots AS pl ON pl.user_id = users.id").
+		Where("users.deleted_at IS NULL").
+		Group("users.id, users.points").
+		Having("users.points <> COALESCE(SUM(pl.remaining), 0)").
+		Scan(&lotRows).Error; err != nil {
+		return nil, err
+	}
+	if len(lotRows) == 0 {
+		return rep, nil
+	}
+	rules, err := LoadPointsRules(s.db)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, r := range lotRows {
+		diff := r.Points - r.Total
+		rep.LotUsers++
+		rep.LotDelta += diff
+		if dryRun {
+			continue
+		}
+		if err := s.db.Transaction(func(tx *gorm.DB) error {
+			if diff > 0 {
+				return createPointsLot(tx, r.ID, diff, nil, now, rules)
+			}
+			return consumePointsLots(tx, r.ID, -diff)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return rep, nil
+}
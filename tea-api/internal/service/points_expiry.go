@@ -0,0 +1,158 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+	"tea-api/pkg/rabbitmq"
+)
+
+// publishPointsNotice 到期提醒经通知消息队列下发，测试中可替换
+var publishPointsNotice = rabbitmq.PublishNotificationMessage
+
+// PointsExpiryReport 一次过期处理的结果
+type PointsExpiryReport struct {
+	ExpiredLots   int   `json:"expired_lots"`
+	ExpiredUsers  int   `json:"expired_users"`
+	ExpiredPoints int64 `json:"expired_points"`
+	NoticesSent   int   `json:"notices_sent"`
+	NoticesFailed int   `json:"notices_failed"`
+}
+
+// ExpiringPoints 用户在 within 时间内将到期的积分合计与最早到期时间
+func (s *PointsService) ExpiringPoints(userID uint, now time.Time, within time.Duration) (int64, *time.Time, error) {
+	var lots []model.PointsLot
+	if err := s.db.Select("remaining", "expires_at").
+		Where("user_id = ? AND remaining > 0 AND expires_at IS NOT NULL AND expires_at > ? AND expires_at <= ?", userID, now, now.Add(within)).
+		Order("expires_at").Find(&lots).Error; err != nil {
+		return 0, nil, err
+	}
+	if len(lots) == 0 {
+		return 0, nil, nil
+	}
+	var total int64
+	for _, l := range lots {
+		total += l.Remaining
+	}
+	return total, lots[0].ExpiresAt, nil
+}
+
+// RunExpiry 处理到期积分并发送到期提醒：到期批次的剩余积分逐批记过期流水（按批次幂等），
+// 随后对 ExpiryNoticeDays 天内到期且未提醒过的批次按用户汇总发送一次提醒。
+func (s *PointsService) RunExpiry(now time.Time) (*PointsExpiryReport, error) {
+	rep := &PointsExpiryReport{}
+	var lotIDs []uint
+	if err := s.db.Model(&model.PointsLot{}).
+		Where("remaining > 0 AND expires_at IS NOT NULL AND expires_at <= ?", now).
+		Order("id").Pluck("id", &lotIDs).Error; err != nil {
+		return nil, err
+	}
+	users := map[uint]struct{}{}
+	for _, id := range lotIDs {
+		var expired int64
+		var userID uint
+		if err := s.db.Transaction(func(tx *gorm.DB) error {
+			var lot model.PointsLot
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&lot, id).Error; err != nil {
+				return err
+			}
+			if lot.Remaining <= 0 || lot.ExpiresAt == nil || lot.ExpiresAt.After(now) {
+				return nil
+			}
+			rec, err := postPointsTx(tx, lot.UserID, -lot.Remaining, model.PointsTxExpire,
+				fmt.Sprintf("积分过期（%s 获得，%s 到期）", lot.EarnedAt.Format("2006-01-02"), lot.ExpiresAt.Format("2006-01-02")),
+				nil, fmt.Sprintf("expire:%d", lot.ID))
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&model.PointsLot{}).Where("id = ?", lot.ID).Update("remaining", 0).Error; err != nil {
+				return err
+			}
+			if rec != nil {
+				expired = -rec.Change
+			}
+			userID = lot.UserID
+			return nil
+		}); err != nil {
+			return rep, err
+		}
+		if userID != 0 {
+			rep.ExpiredLots++
+			rep.ExpiredPoints += expired
+			users[userID] = struct{}{}
+		}
+	}
+	rep.ExpiredUsers = len(users)
+
+	rules, err := LoadPointsRules(s.db)
+	if err != nil {
+		return rep, err
+	}
+	if rules.ExpiryNoticeDays > 0 {
+		sent, failed, err := s.sendExpiryNotices(now, rules.ExpiryNoticeDays)
+		rep.NoticesSent, rep.NoticesFailed = sent, failed
+		if err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+// sendExpiryNotices 按用户汇总 days 天内到期的未提醒批次发送提醒，发送成功后标记批次已提醒；失败的下次重试
+func (s *PointsService) sendExpiryNotices(now time.Time, days int) (int, int, error) {
+	until := now.AddDate(0, 0, days)
+	var lots []model.PointsLot
+	if err := s.db.Select("id", "user_id", "remaining", "expires_at").
+		Where("remaining > 0 AND notice_sent_at IS NULL AND expires_at IS NOT NULL AND expires_at > ? AND expires_at <= ?", now, until).
+		Order("user_id, expires_at").Find(&lots).Error; err != nil {
+		return 0, 0, err
+	}
+	type userNotice struct {
+		Total    int64
+		Earliest time.Time
+		LotIDs   []uint
+	}
+	var order []uint
+	byUser := map[uint]*userNotice{}
+	for _, l := range lots {
+		n := byUser[l.UserID]
+		if n == nil {
+			n = &userNotice{Earliest: *l.ExpiresAt}
+			byUser[l.UserID] = n
+			order = append(order, l.UserID)
+		}
+		n.Total += l.Remaining
+		n.LotIDs = append(n.LotIDs, l.ID)
+	}
+	channel := config.Config.Finance.PointsExpiry.NoticeChannel
+	if channel == "" {
+		channel = "wechat"
+	}
+	sent, failed := 0, 0
+	for _, userID := range order {
+		n := byUser[userID]
+		err := publishPointsNotice(rabbitmq.NotificationMessage{
+			UserID:    userID,
+			Type:      channel,
+			Title:     "积分即将过期提醒",
+			Content:   fmt.Sprintf("您有 %d 积分将于 %s 起陆续过期，请尽快使用。", n.Total, n.Earliest.Format("2006-01-02")),
+			Timestamp: now.Unix(),
+		})
+		if err != nil {
+			failed++
+			zap.L().Warn("points expiry notice failed", zap.Uint("user_id", userID), zap.Error(err))
+			continue
+		}
+		if err := s.db.Model(&model.PointsLot{}).Where("id IN ?", n.LotIDs).Update("notice_sent_at", now).Error; err != nil {
+			return sent, failed, err
+		}
+		sent++
+	}
+	return sent, failed, nil
+}
@@ -0,0 +1,430 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/model"
+	"tea-api/pkg/database"
+)
+
+// OrderTypePointsMall 积分兑换订单：积分在下单时扣减，现金部分走普通支付；
+// 实物商品按普通订单履约，优惠券在支付成功（或无需现金）时发放并完成订单。
+const OrderTypePointsMall = 6
+
+type PointsMallService struct {
+	db *gorm.DB
+}
+
+func NewPointsMallService() *PointsMallService {
+	return &PointsMallService{db: database.GetDB()}
+}
+
+// PointsExchangeInput 兑换请求；实物商品可指定门店与配送方式
+type PointsExchangeInput struct {
+	ItemID       uint
+	Quantity     int
+	StoreID      uint
+	DeliveryType int
+	AddressInfo  string
+	Remark       string
+}
+
+func (s *PointsMallService) validateItem(tx *gorm.DB, item *model.PointsMallItem) error {
+	item.Name = strings.TrimSpace(item.Name)
+	if item.Name == "" {
+		return errors.New("名称不能为空")
+	}
+	if item.PointsPrice <= 0 {
+		return errors.New("兑换积分须大于 0")
+	}
+	if item.CashPrice.IsNegative() {
+		return errors.New("现金部分不能为负")
+	}
+	if item.Stock < -1 || item.PerUserLimit < 0 {
+		return errors.New("库存或限兑数量不合法")
+	}
+	if item.StartAt != nil && item.EndAt != nil && !item.EndAt.After(*item.StartAt) {
+		return errors.New("结束时间须晚于开始时间")
+	}
+	if item.Status == 0 {
+		item.Status = 1
+	}
+	switch item.ItemType {
+	case model.PointsMallItemProduct:
+		if item.ProductID == nil || *item.ProductID == 0 {
+			return errors.New("商品兑换须指定 product_id")
+		}
+		var prod model.Product
+		if err := tx.First(&prod, *item.ProductID).Error; err != nil {
+			return errors.New("商品不存在")
+		}
+		if item.SkuID != nil {
+			var sku model.ProductSku
+			if err := tx.First(&sku, *item.SkuID).Error; err != nil || sku.ProductID != prod.ID {
+				return errors.New("SKU与商品不匹配")
+			}
+		}
+		item.CouponID = nil
+	case model.PointsMallItemCoupon:
+		if item.CouponID == nil || *item.CouponID == 0 {
+			return errors.New("优惠券兑换须指定 coupon_id")
+		}
+		var c model.Coupon
+		if err := tx.First(&c, *item.CouponID).Error; err != nil {
+			return errors.New("优惠券不存在")
+		}
+		item.ProductID, item.SkuID = nil, nil
+	default:
+		return errors.New("未知的兑换商品类型")
+	}
+	return nil
+}
+
+// CreateItem 创建积分商城商品
+func (s *PointsMallService) CreateItem(item *model.PointsMallItem) error {
+	if err := s.validateItem(s.db, item); err != nil {
+		return err
+	}
+	return s.db.Create(item).Error
+}
+
+// UpdateItem 整体更新积分商城商品（已兑换数量不可修改）
+func (s *PointsMallService) UpdateItem(id uint, upd *model.PointsMallItem) (*model.PointsMallItem, error) {
+	var item model.PointsMallItem
+	if err := s.db.First(&item, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("兑换商品不存在")
+		}
+		return nil, err
+	}
+	if err := s.validateItem(s.db, upd); err != nil {
+		return nil, err
+	}
+	upd.BaseModel = item.BaseModel
+	upd.ExchangedCount = item.ExchangedCount
+	if err := s.db.Save(upd).Error; err != nil {
+		return nil, err
+	}
+	return upd, nil
+}
+
+// ListItems 兑换商品列表；onlyAvailable 为 true 时仅返回上架且在兑换时间内的商品
+func (s *PointsMallService) ListItems(onlyAvailable bool, itemType, page, limit int) ([]model.PointsMallItem, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	q := s.db.Model(&model.PointsMallItem{})
+	if onlyAvailable {
+		now := time.Now()
+		q = q.Where("status = 1 AND (start_at IS NULL OR start_at <= ?) AND (end_at IS NULL OR end_at > ?)", now, now)
+	}
+	if itemType > 0 {
+		q = q.Where("item_type = ?", itemType)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []model.PointsMallItem
+	if err := q.Order("sort desc, id desc").Limit(limit).Offset((page - 1) * limit).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// Exchange 兑换积分商城商品：校验上架时间、库存与每人限兑，扣减积分并生成积分兑换订单。
+// 无需支付现金时订单直接置为已支付（优惠券当即发放）；否则订单待支付，取消或超时退还积分与库存。
+func (s *PointsMallService) Exchange(userID uint, in PointsExchangeInput) (*model.PointsExchange, *model.Order, error) {
+	if in.Quantity <= 0 {
+		in.Quantity = 1
+	}
+	if in.DeliveryType == 0 {
+		in.DeliveryType = 1
+	}
+	if in.DeliveryType != 1 && in.DeliveryType != 2 {
+		return nil, nil, errors.New("非法的配送类型")
+	}
+	var exchange *model.PointsExchange
+	var order *model.Order
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var item model.PointsMallItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, in.ItemID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("兑换商品不存在")
+			}
+			return err
+		}
+		if item.Status != 1 || (item.StartAt != nil && now.Before(*item.StartAt)) || (item.EndAt != nil && !now.Before(*item.EndAt)) {
+			return errors.New("兑换商品未上架或不在兑换时间内")
+		}
+		if item.Stock >= 0 && item.Stock < in.Quantity {
+			return errors.New("兑换库存不足")
+		}
+		if item.PerUserLimit > 0 {
+			var used int64
+			if err := tx.Model(&model.PointsExchange{}).Select("COALESCE(SUM(quantity), 0)").
+				Where("user_id = ? AND item_id = ? AND status <> ?", userID, item.ID, model.PointsExchangeCancelled).
+				Scan(&used).Error; err != nil {
+				return err
+			}
+			if used+int64(in.Quantity) > int64(item.PerUserLimit) {
+				return fmt.Errorf("每人限兑 %d 件", item.PerUserLimit)
+			}
+		}
+		qty := decimal.NewFromInt(int64(in.Quantity))
+		points := item.PointsPrice * int64(in.Quantity)
+		cash := item.CashPrice.Mul(qty)
+
+		var user model.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "points").First(&user, userID).Error; err != nil {
+			return err
+		}
+		if int64(user.Points) < points {
+			return errors.New("可用积分不足")
+		}
+
+		order = &model.Order{
+			OrderNo:        generateOrderNo("P"),
+			UserID:         userID,
+			Status:         1,
+			PayStatus:      1,
+			OrderType:      OrderTypePointsMall,
+			DeliveryType:   1,
+			AddressInfo:    "{}",
+			Remark:         in.Remark,
+			TotalAmount:    cash,
+			DiscountAmount: decimal.Zero,
+			DeliveryFee:    decimal.Zero,
+			PayAmount:      cash,
+			PointsUsed:     points,
+		}
+		var orderItems []model.OrderItem
+		if item.ItemType == model.PointsMallItemProduct {
+			oi, err := s.productOrderItem(tx, &item, in)
+			if err != nil {
+				return err
+			}
+			orderItems = append(orderItems, *oi)
+			order.StoreID = in.StoreID
+			order.DeliveryType = in.DeliveryType
+			order.AddressInfo = in.AddressInfo
+			if oi.Amount.GreaterThan(cash) {
+				order.TotalAmount = oi.Amount
+			}
+			order.PointsDeduction = order.TotalAmount.Sub(cash)
+		}
+		if err := tx.Create(order).Error; err != nil {
+			return fmt.Errorf("创建兑换订单失败: %w", err)
+		}
+		if len(orderItems) > 0 {
+			orderItems[0].OrderID = order.ID
+			if err := tx.Create(&orderItems).Error; err != nil {
+				return fmt.Errorf("创建订单明细失败: %w", err)
+			}
+			if err := reserveOrderStock(tx, order, orderItems, now); err != nil {
+				return err
+			}
+		}
+
+		exchange = &model.PointsExchange{
+			UserID:     userID,
+			ItemID:     item.ID,
+			ItemName:   item.Name,
+			ItemType:   item.ItemType,
+			Quantity:   in.Quantity,
+			Points:     points,
+			CashAmount: cash,
+			OrderID:    order.ID,
+			Status:     model.PointsExchangePending,
+		}
+		if err := tx.Create(exchange).Error; err != nil {
+			return err
+		}
+		stockUpd := map[string]any{"exchanged_count": gorm.Expr("exchanged_count + ?", in.Quantity)}
+		if item.Stock >= 0 {
+			stockUpd["stock"] = gorm.Expr("stock - ?", in.Quantity)
+		}
+		if err := tx.Model(&model.PointsMallItem{}).Where("id = ?", item.ID).Updates(stockUpd).Error; err != nil {
+			return err
+		}
+		orderID := order.ID
+		if _, err := postPointsTx(tx, userID, -points, model.PointsTxOrderDeduct,
+			fmt.Sprintf("积分兑换：%s×%d", item.Name, in.Quantity), &orderID, fmt.Sprintf("order_deduct:%d", order.ID)); err != nil {
+			return err
+		}
+
+		if !cash.IsPositive() {
+			if err := markOrderPaidTx(tx, order, &now, now); err != nil {
+				return err
+			}
+		}
+		return tx.First(exchange, exchange.ID).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return exchange, order, nil
+}
+
+// productOrderItem 校验实物兑换商品的可售库存并生成订单明细（价格按商品/SKU/门店价记录，用于展示与退款比例）
+func (s *PointsMallService) productOrderItem(tx *gorm.DB, item *model.PointsMallItem, in PointsExchangeInput) (*model.OrderItem, error) {
+	var prod model.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&prod, *item.ProductID).Error; err != nil {
+		return nil, errors.New("商品不存在")
+	}
+	if prod.Status != 1 {
+		return nil, fmt.Errorf("商品已下架: %s", prod.Name)
+	}
+	checker := newStockChecker(tx)
+	price := prod.Price
+	var skuName string
+	if item.SkuID != nil {
+		var sku model.ProductSku
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sku, *item.SkuID).Error; err != nil {
+			return nil, errors.New("SKU不存在")
+		}
+		if sku.Status != 1 {
+			return nil, fmt.Errorf("SKU未上架: %s", sku.SkuName)
+		}
+		if err := checker.checkSku(sku, in.Quantity); err != nil {
+			return nil, err
+		}
+		price = sku.Price
+		skuName = sku.SkuName
+	}
+	if in.StoreID != 0 {
+		var st model.Store
+		if err := tx.First(&st, in.StoreID).Error; err != nil || st.Status != 1 {
+			return nil, errors.New("门店不可用")
+		}
+		var sp model.StoreProduct
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("store_id = ? AND product_id = ?", in.StoreID, prod.ID).First(&sp).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("门店未上架该商品: %s", prod.Name)
+			}
+			return nil, err
+		}
+		if err := checker.checkStore(sp, in.Quantity); err != nil {
+			return nil, err
+		}
+		if sp.PriceOverride.GreaterThan(decimal.Zero) {
+			price = sp.PriceOverride
+		}
+	}
+	if err := checker.checkProduct(prod, in.Quantity); err != nil {
+		return nil, err
+	}
+	return &model.OrderItem{
+		ProductID:   prod.ID,
+		SkuID:       item.SkuID,
+		ProductName: prod.Name,
+		SkuName:     skuName,
+		Price:       price,
+		Quantity:    in.Quantity,
+		Amount:      price.Mul(decimal.NewFromInt(int64(in.Quantity))),
+	}, nil
+}
+
+// fulfillPointsExchangeTx 积分兑换订单支付成功：标记兑换成功，优惠券兑换发放用户券并完成订单
+func fulfillPointsExchangeTx(tx *gorm.DB, order *model.Order, now time.Time) error {
+	var ex model.PointsExchange
+	if err := tx.Where("order_id = ?", order.ID).First(&ex).Error; err != nil {
+		return err
+	}
+	if ex.Status != model.PointsExchangePending {
+		return nil
+	}
+	updates := map[string]any{"status": model.PointsExchangeCompleted, "completed_at": now}
+	if ex.ItemType == model.PointsMallItemCoupon {
+		var item model.PointsMallItem
+		if err := tx.First(&item, ex.ItemID).Error; err != nil {
+			return err
+		}
+		if item.CouponID == nil {
+			return errors.New("兑换商品未关联优惠券")
+		}
+		orderID := order.ID
+		for i := 0; i < ex.Quantity; i++ {
+			uc := model.UserCoupon{UserID: ex.UserID, CouponID: *item.CouponID, Status: 1}
+			if err := tx.Create(&uc).Error; err != nil {
+				return err
+			}
+			if i == 0 {
+				updates["user_coupon_id"] = uc.ID
+			}
+		}
+		// 优惠券即时到账，订单直接完成
+		if err := tx.Model(&model.Order{}).Where("id = ?", orderID).
+			Updates(map[string]any{"status": 4, "completed_at": now}).Error; err != nil {
+			return err
+		}
+		order.Status = 4
+		order.CompletedAt = &now
+	}
+	return tx.Model(&model.PointsExchange{}).Where("id = ?", ex.ID).Updates(updates).Error
+}
+
+// cancelPointsExchangeTx 积分兑换订单取消：兑换记录置为已取消并退回兑换库存（积分由订单取消统一退还）
+func cancelPointsExchangeTx(tx *gorm.DB, orderID uint, now time.Time) error {
+	var ex model.PointsExchange
+	if err := tx.Where("order_id = ?", orderID).Limit(1).Find(&ex).Error; err != nil {
+		return err
+	}
+	if ex.ID == 0 || ex.Status != model.PointsExchangePending {
+		return nil
+	}
+	if err := tx.Model(&model.PointsExchange{}).Where("id = ?", ex.ID).
+		Updates(map[string]any{"status": model.PointsExchangeCancelled, "cancelled_at": now}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&model.PointsMallItem{}).Where("id = ?", ex.ItemID).
+		Updates(map[string]any{
+			"exchanged_count": gorm.Expr("exchanged_count - ?", ex.Quantity),
+			"stock":           gorm.Expr("CASE WHEN stock >= 0 THEN stock + ? ELSE stock END", ex.Quantity),
+		}).Error
+}
+
+// ListUserExchanges 用户兑换记录
+func (s *PointsMallService) ListUserExchanges(userID uint, page, limit int) ([]model.PointsExchange, int64, error) {
+	return s.ListExchanges(userID, 0, 0, page, limit)
+}
+
+// ListExchanges 兑换记录查询（管理端），userID/itemID/status 为 0 时不过滤
+func (s *PointsMallService) ListExchanges(userID, itemID uint, status, page, limit int) ([]model.PointsExchange, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	q := s.db.Model(&model.PointsExchange{})
+	if userID > 0 {
+		q = q.Where("user_id = ?", userID)
+	}
+	if itemID > 0 {
+		q = q.Where("item_id = ?", itemID)
+	}
+	if status > 0 {
+		q = q.Where("status = ?", status)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []model.PointsExchange
+	if err := q.Order("id desc").Limit(limit).Offset((page - 1) * limit).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
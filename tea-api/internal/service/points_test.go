@@ -0,0 +1,500 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/pkg/rabbitmq"
+)
+
+func userPoints(t *testing.T, db *gorm.DB, userID uint) (int64, int64) {
+	t.Helper()
+	var u model.User
+	if err := db.Select("id", "points").First(&u, userID).Error; err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	var sum int64
+	db.Model(&model.PointsTransaction{}).Select("COALESCE(SUM(`change`), 0)").Where("user_id = ?", userID).Scan(&sum)
+	return int64(u.Points), sum
+}
+
+func TestPoints_EarnOnCompleteAndReverseOnRefund(t *testing.T) {
+	db := setupLedgerFlowDB(t, "points_flow")
+	if err := db.AutoMigrate(&model.Product{}, &model.SystemConfig{}, &model.PointsTransaction{}, &model.PointsLot{}, &model.ReferralClosure{},
+		&model.PartnerLevel{}, &model.Commission{}, &model.CommissionTransaction{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	db.Exec("ALTER TABLE users ADD COLUMN partner_level_id integer")
+	db.Exec("CREATE TABLE IF NOT EXISTS user_memberships (id integer primary key, user_id integer, package_id integer, status text, started_at datetime)")
+	rules := PointsRules{
+		Enabled:             true,
+		PointsPerYuan:       decimal.NewFromInt(1),
+		CategoryMultipliers: []PointsMultiplier{{TargetID: 7, Multiplier: decimal.NewFromInt(2)}},
+		StoreMultipliers:    []PointsMultiplier{{TargetID: 3, Multiplier: decimal.RequireFromString("1.5")}},
+		SignupBonus:         50,
+		FirstOrderBonus:     20,
+		DailyCap:            400,
+	}
+	if err := (&PointsService{db: db}).SaveRules(&rules); err != nil {
+		t.Fatalf("save rules: %v", err)
+	}
+	bad := PointsRules{StoreMultipliers: []PointsMultiplier{{TargetID: 1}}}
+	if err := bad.Validate(); err == nil {
+		t.Fatalf("zero multiplier should be rejected")
+	}
+
+	user := model.User{Phone: "13800000042", Nickname: "points", OpenID: "openid-points", Points: 30}
+	db.Create(&user)
+	// 历史积分先补记期初流水
+	svc := &PointsService{db: db}
+	if rep, err := svc.ReconcileOpeningBalances(true); err != nil || rep.Users != 1 || rep.TotalDelta != 30 {
+		t.Fatalf("dry run: %+v err=%v", rep, err)
+	}
+	if _, err := svc.ReconcileOpeningBalances(false); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if rep, _ := svc.ReconcileOpeningBalances(true); rep.Users != 0 {
+		t.Fatalf("reconcile should be idempotent: %+v", rep)
+	}
+	GrantSignupPoints(db, user.ID)
+	GrantSignupPoints(db, user.ID)
+	if pts, sum := userPoints(t, db, user.ID); pts != 80 || sum != 80 {
+		t.Fatalf("signup bonus should be granted once: points=%d sum=%d", pts, sum)
+	}
+
+	// 商品 A 属于双倍分类，实付 100 元按商品金额 60/40 分摊：60×2 + 40 = 160，门店 1.5 倍 = 240
+	pa := model.Product{Name: "龙井", CategoryID: 7, Price: decimal.NewFromInt(60)}
+	pb := model.Product{Name: "茶点", CategoryID: 8, Price: decimal.NewFromInt(40)}
+	db.Create(&pa)
+	db.Create(&pb)
+	order := model.Order{OrderNo: "PTS001", UserID: user.ID, StoreID: 3, Status: 3, PayStatus: 2, DeliveryType: 2,
+		TotalAmount: decimal.NewFromInt(110), PayAmount: decimal.NewFromInt(100)}
+	db.Create(&order)
+	// 余额支付 25 元、微信支付 75 元；推荐人为合伙人，订单完成时计提佣金
+	db.Create(&model.Wallet{UserID: user.ID})
+	db.Create(&model.Payment{OrderID: order.ID, PaymentNo: "BPTS01", PaymentMethod: PayMethodBalance, Amount: decimal.NewFromInt(25), Status: 2})
+	db.Create(&model.Payment{OrderID: order.ID, PaymentNo: "PPTS01", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(75), Status: 2})
+	level := model.PartnerLevel{Name: "合伙人", DirectCommissionRate: decimal.RequireFromString("0.10")}
+	db.Create(&level)
+	referrer := model.User{Phone: "13800000044", Nickname: "referrer", OpenID: "openid-points-referrer"}
+	db.Create(&referrer)
+	db.Exec("UPDATE users SET partner_level_id = ? WHERE id = ?", level.ID, referrer.ID)
+	db.Create(&model.ReferralClosure{AncestorUserID: referrer.ID, DescendantUserID: user.ID, Depth: 1})
+	db.Create(&model.OrderItem{OrderID: order.ID, ProductID: pa.ID, Quantity: 1, Price: decimal.NewFromInt(66), Amount: decimal.NewFromInt(66)})
+	db.Create(&model.OrderItem{OrderID: order.ID, ProductID: pb.ID, Quantity: 1, Price: decimal.NewFromInt(44), Amount: decimal.NewFromInt(44)})
+
+	osvc := &OrderService{db: db}
+	if err := osvc.Complete(0, order.ID); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if pts, sum := userPoints(t, db, user.ID); pts != 80+240+20 || sum != pts {
+		t.Fatalf("order points + first order bonus expected 340, got points=%d sum=%d", pts, sum)
+	}
+
+	// 第二单受每日上限约束（今日剩余 160），且不再发放首单奖励
+	second := model.Order{OrderNo: "PTS002", UserID: user.ID, Status: 3, PayStatus: 2, DeliveryType: 2,
+		TotalAmount: decimal.NewFromInt(300), PayAmount: decimal.NewFromInt(300)}
+	db.Create(&second)
+	db.Create(&model.Payment{OrderID: second.ID, PaymentNo: "PPTS02", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(300), Status: 2})
+	if err := osvc.Complete(0, second.ID); err != nil {
+		t.Fatalf("complete second: %v", err)
+	}
+	var earned model.PointsTransaction
+	db.Where("order_id = ? AND type = ?", second.ID, model.PointsTxOrderEarn).First(&earned)
+	if earned.Change != 160 {
+		t.Fatalf("daily cap should limit second order to 160, got %d", earned.Change)
+	}
+
+	var commissions int64
+	db.Model(&model.Commission{}).Where("order_id = ? AND status = ?", order.ID, "frozen").Count(&commissions)
+	if commissions == 0 {
+		t.Fatalf("completed order should accrue commissions")
+	}
+
+	// 已完成订单售后退款：余额部分即时退回扣回 60，微信退款成功后累计扣回 240；重复通知不重复扣
+	provider := &refundStubProvider{}
+	psvc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return provider, nil }}
+	if err := psvc.StartOrderRefund(order.ID, "售后退款"); err != nil {
+		t.Fatalf("start refund: %v", err)
+	}
+	if pts, _ := userPoints(t, db, user.ID); pts != 500-60 {
+		t.Fatalf("partial refund should reverse 60, got points=%d", pts)
+	}
+	var wx model.Refund
+	db.Joins("JOIN payments ON payments.id = refunds.payment_id").Where("payments.payment_no = ?", "PPTS01").First(&wx)
+	provider.notify = &PayNotifyResult{Kind: PayNotifyRefund, RefundNo: wx.RefundNo, RefundID: "5030000000000001", RefundStatus: "SUCCESS"}
+	for i := 0; i < 2; i++ {
+		if err := psvc.HandleProviderNotify(context.Background(), PayMethodWechat, nil, nil); err != nil {
+			t.Fatalf("refund notify: %v", err)
+		}
+	}
+	pts, sum := userPoints(t, db, user.ID)
+	if pts != 500-240 || sum != pts {
+		t.Fatalf("full refund should reverse all order points but keep bonuses, got points=%d sum=%d", pts, sum)
+	}
+	db.First(&order, order.ID)
+	db.Model(&model.Commission{}).Where("order_id = ? AND status <> ?", order.ID, "reversed").Count(&commissions)
+	if order.Status != 5 || order.PayStatus != 4 || commissions != 0 {
+		t.Fatalf("refunded order should reverse its commissions, order=%d/%d unreversed=%d", order.Status, order.PayStatus, commissions)
+	}
+
+	// 关闭规则后完成订单不再发积分
+	rules.Enabled = false
+	raw, _ := json.Marshal(rules)
+	db.Model(&model.SystemConfig{}).Where("config_key = ?", PointsRulesConfigKey).Update("config_value", string(raw))
+	third := model.Order{OrderNo: "PTS003", UserID: user.ID, Status: 3, PayStatus: 2, DeliveryType: 2, PayAmount: decimal.NewFromInt(10)}
+	db.Create(&third)
+	if err := osvc.Complete(0, third.ID); err != nil {
+		t.Fatalf("complete third: %v", err)
+	}
+	if after, _ := userPoints(t, db, user.ID); after != pts {
+		t.Fatalf("disabled rules should not post points")
+	}
+}
+
+func TestPoints_CheckoutDeductionAndMallExchange(t *testing.T) {
+	db := setupLedgerFlowDB(t, "points_redeem")
+	if err := db.AutoMigrate(&model.Product{}, &model.ProductSku{}, &model.Cart{}, &model.CartItem{}, &model.StoreSlotTemplate{},
+		&model.SystemConfig{}, &model.PointsTransaction{}, &model.PointsLot{}, &model.PointsMallItem{}, &model.PointsExchange{},
+		&model.Coupon{}, &model.UserCoupon{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	rules := PointsRules{Enabled: true, PointsPerYuan: decimal.NewFromInt(1), RedeemEnabled: true, RedeemPointsPerYuan: 100,
+		RedeemMaxPercent: decimal.NewFromInt(20), RedeemMinOrderAmount: decimal.NewFromInt(50)}
+	if err := (&PointsService{db: db}).SaveRules(&rules); err != nil {
+		t.Fatalf("save rules: %v", err)
+	}
+	user := model.User{Phone: "13800000043", Nickname: "redeem", OpenID: "openid-redeem"}
+	db.Create(&user)
+	db.Transaction(func(tx *gorm.DB) error {
+		_, err := postPointsTx(tx, user.ID, 5000, model.PointsTxOpeningBalance, "测试积分", nil, "")
+		return err
+	})
+	prod := model.Product{Name: "大红袍", Price: decimal.NewFromInt(30), Stock: 10, Status: 1}
+	db.Create(&prod)
+	osvc := &OrderService{db: db}
+
+	// 未达门槛不可抵扣
+	addToCart(t, db, user.ID, prod.ID, 1)
+	if _, err := osvc.CreateOrderFromCart(user.ID, 1, "", "", 0, 0, 1, nil, 1000); err == nil {
+		t.Fatalf("order below min amount should reject points deduction")
+	}
+	// 100 元订单最多抵扣 20% = 20 元 = 2000 积分
+	db.Where("1 = 1").Delete(&model.CartItem{})
+	addToCart(t, db, user.ID, prod.ID, 3)
+	addToCart(t, db, user.ID, prod.ID, 1) // 共 120 元
+	order, err := osvc.CreateOrderFromCart(user.ID, 1, "", "", 0, 0, 1, nil, 4000)
+	if err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	if order.PointsUsed != 2400 || !order.PointsDeduction.Equal(decimal.NewFromInt(24)) || !order.PayAmount.Equal(decimal.NewFromInt(96)) {
+		t.Fatalf("unexpected deduction: used=%d deduct=%s pay=%s", order.PointsUsed, order.PointsDeduction, order.PayAmount)
+	}
+	if pts, sum := userPoints(t, db, user.ID); pts != 2600 || sum != 2600 {
+		t.Fatalf("points should be deducted at checkout, got %d/%d", pts, sum)
+	}
+	if err := osvc.CancelOrder(user.ID, order.ID, "不要了"); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if pts, _ := userPoints(t, db, user.ID); pts != 5000 {
+		t.Fatalf("cancel should return points, got %d", pts)
+	}
+
+	// 已支付订单部分退款按比例退还抵扣积分
+	addToCart(t, db, user.ID, prod.ID, 4)
+	paid, err := osvc.CreateOrderFromCart(user.ID, 1, "", "", 0, 0, 1, nil, 1000)
+	if err != nil || paid.PointsUsed != 1000 || !paid.PayAmount.Equal(decimal.NewFromInt(110)) {
+		t.Fatalf("create paid order: %+v err=%v", paid, err)
+	}
+	db.Model(&model.Order{}).Where("id = ?", paid.ID).Updates(map[string]any{"status": 2, "pay_status": 2})
+	db.Create(&model.Wallet{UserID: user.ID})
+	db.Create(&model.Payment{OrderID: paid.ID, PaymentNo: "BRED01", PaymentMethod: PayMethodBalance, Amount: decimal.NewFromInt(55), Status: 2})
+	db.Create(&model.Payment{OrderID: paid.ID, PaymentNo: "PRED01", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(55), Status: 2})
+	psvc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return &refundStubProvider{}, nil }}
+	if err := psvc.StartOrderRefund(paid.ID, "部分退回"); err != nil {
+		t.Fatalf("start refund: %v", err)
+	}
+	now := time.Now()
+	if pts, sum := userPoints(t, db, user.ID); pts != 4500 || sum != pts {
+		t.Fatalf("half refund should return half of deducted points, got %d/%d", pts, sum)
+	}
+
+	// 积分商城：纯积分兑换优惠券即时到账；积分+现金兑换实物生成待支付订单，取消退回积分与库存
+	mall := &PointsMallService{db: db}
+	coupon := model.Coupon{Name: "满50减10", Type: 1, Amount: decimal.NewFromInt(10), TotalCount: 100, Status: 1,
+		StartTime: now.Add(-time.Hour), EndTime: now.Add(24 * time.Hour)}
+	db.Create(&coupon)
+	couponItem := model.PointsMallItem{Name: "10元券", ItemType: model.PointsMallItemCoupon, CouponID: &coupon.ID,
+		PointsPrice: 500, Stock: 5, PerUserLimit: 2}
+	if err := mall.CreateItem(&couponItem); err != nil {
+		t.Fatalf("create coupon item: %v", err)
+	}
+	ex, exOrder, err := mall.Exchange(user.ID, PointsExchangeInput{ItemID: couponItem.ID, Quantity: 2})
+	if err != nil {
+		t.Fatalf("exchange coupon: %v", err)
+	}
+	var ucCount int64
+	db.Model(&model.UserCoupon{}).Where("user_id = ? AND coupon_id = ?", user.ID, coupon.ID).Count(&ucCount)
+	if ex.Status != model.PointsExchangeCompleted || ex.UserCouponID == nil || ucCount != 2 || exOrder.Status != 4 {
+		t.Fatalf("coupon exchange should complete immediately: ex=%+v order=%+v coupons=%d", ex, exOrder, ucCount)
+	}
+	if _, _, err := mall.Exchange(user.ID, PointsExchangeInput{ItemID: couponItem.ID}); err == nil {
+		t.Fatalf("per-user limit should be enforced")
+	}
+
+	prodItem := model.PointsMallItem{Name: "大红袍兑换", ItemType: model.PointsMallItemProduct, ProductID: &prod.ID,
+		PointsPrice: 1500, CashPrice: decimal.NewFromInt(5), Stock: 1}
+	if err := mall.CreateItem(&prodItem); err != nil {
+		t.Fatalf("create product item: %v", err)
+	}
+	pex, pOrder, err := mall.Exchange(user.ID, PointsExchangeInput{ItemID: prodItem.ID})
+	if err != nil {
+		t.Fatalf("exchange product: %v", err)
+	}
+	if pex.Status != model.PointsExchangePending || pOrder.Status != 1 || !pOrder.PayAmount.Equal(decimal.NewFromInt(5)) ||
+		!pOrder.PointsDeduction.Equal(decimal.NewFromInt(25)) {
+		t.Fatalf("cash exchange should await payment: %+v %+v", pex, pOrder)
+	}
+	if pts, _ := userPoints(t, db, user.ID); pts != 4500-1000-1500 {
+		t.Fatalf("exchange should deduct points, got %d", pts)
+	}
+	if _, _, err := mall.Exchange(user.ID, PointsExchangeInput{ItemID: prodItem.ID}); err == nil {
+		t.Fatalf("exchange stock should be enforced")
+	}
+	if err := osvc.CancelOrder(user.ID, pOrder.ID, "不换了"); err != nil {
+		t.Fatalf("cancel exchange order: %v", err)
+	}
+	var reloaded model.PointsMallItem
+	db.First(&reloaded, prodItem.ID)
+	db.First(pex, pex.ID)
+	if reloaded.Stock != 1 || reloaded.ExchangedCount != 0 || pex.Status != model.PointsExchangeCancelled {
+		t.Fatalf("cancel should restore exchange stock: item=%+v ex=%+v", reloaded, pex)
+	}
+	if pts, sum := userPoints(t, db, user.ID); pts != 3500 || sum != pts {
+		t.Fatalf("cancel should return exchange points, got %d/%d", pts, sum)
+	}
+}
+
+func lotRemaining(t *testing.T, db *gorm.DB, userID uint) int64 {
+	t.Helper()
+	var sum int64
+	db.Model(&model.PointsLot{}).Select("COALESCE(SUM(remaining), 0)").Where("user_id = ?", userID).Scan(&sum)
+	return sum
+}
+
+func TestPoints_LotsExpireFIFOWithNotices(t *testing.T) {
+	db := setupLedgerFlowDB(t, "points_expiry")
+	if err := db.AutoMigrate(&model.SystemConfig{}, &model.PointsTransaction{}, &model.PointsLot{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	var notices []rabbitmq.NotificationMessage
+	oldPublish := publishPointsNotice
+	publishPointsNotice = func(msg rabbitmq.NotificationMessage) error {
+		notices = append(notices, msg)
+		return nil
+	}
+	defer func() { publishPointsNotice = oldPublish }()
+
+	svc := &PointsService{db: db}
+	rules := PointsRules{Enabled: true, PointsPerYuan: decimal.NewFromInt(1), ExpiryMode: PointsExpiryYearEnd, ExpiryYears: 1, ExpiryNoticeDays: 30}
+	if err := svc.SaveRules(&rules); err != nil {
+		t.Fatalf("save rules: %v", err)
+	}
+	if got := rules.ExpiresAt(time.Date(2025, 3, 1, 10, 0, 0, 0, time.Local)); got == nil || !got.Equal(time.Date(2027, 1, 1, 0, 0, 0, 0, time.Local)) {
+		t.Fatalf("points earned in 2025 should expire at end of 2026, got %v", got)
+	}
+	bad := PointsRules{ExpiryMode: PointsExpiryRolling}
+	if err := bad.Validate(); err == nil {
+		t.Fatalf("rolling expiry without days should be rejected")
+	}
+
+	// 历史积分经期初对齐补建批次
+	user := model.User{Phone: "13800000044", Nickname: "expiry", OpenID: "openid-expiry", Points: 100}
+	db.Create(&user)
+	rep, err := svc.ReconcileOpeningBalances(false)
+	if err != nil || rep.LotUsers != 1 || rep.LotDelta != 100 || lotRemaining(t, db, user.ID) != 100 {
+		t.Fatalf("reconcile lots: %+v err=%v", rep, err)
+	}
+
+	post := func(change int64, typ string) {
+		t.Helper()
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			_, err := postPointsTx(tx, user.ID, change, typ, "测试", nil, "")
+			return err
+		}); err != nil {
+			t.Fatalf("post %d: %v", change, err)
+		}
+	}
+	post(300, model.PointsTxOrderEarn)
+	post(200, model.PointsTxOrderEarn)
+	// 让三个批次依次到期：期初批次已过期、300 批次 10 天后到期、200 批次一年后到期
+	now := time.Now()
+	var lots []model.PointsLot
+	db.Where("user_id = ?", user.ID).Order("id").Find(&lots)
+	db.Model(&model.PointsLot{}).Where("id = ?", lots[0].ID).Update("expires_at", now.Add(-time.Hour))
+	db.Model(&model.PointsLot{}).Where("id = ?", lots[1].ID).Update("expires_at", now.AddDate(0, 0, 10))
+	db.Model(&model.PointsLot{}).Where("id = ?", lots[2].ID).Update("expires_at", now.AddDate(1, 0, 0))
+
+	// 扣减先消耗最早到期的批次
+	post(-150, model.PointsTxOrderDeduct)
+	db.Where("user_id = ?", user.ID).Order("id").Find(&lots)
+	if lots[0].Remaining != 0 || lots[1].Remaining != 250 || lots[2].Remaining != 200 {
+		t.Fatalf("deduction should consume lots FIFO by expiry: %+v", lots)
+	}
+
+	// 提前耗尽的期初批次无需作废；10 天内到期的批次发送一次提醒
+	res, err := svc.RunExpiry(now)
+	if err != nil {
+		t.Fatalf("run expiry: %v", err)
+	}
+	if res.ExpiredLots != 0 || res.NoticesSent != 1 || len(notices) != 1 || notices[0].UserID != user.ID {
+		t.Fatalf("expected a single notice and no expiry: %+v notices=%+v", res, notices)
+	}
+	if res, _ := svc.RunExpiry(now); res.NoticesSent != 0 {
+		t.Fatalf("notice should be sent only once")
+	}
+	expiring, earliest, err := svc.ExpiringPoints(user.ID, now, 30*24*time.Hour)
+	if err != nil || expiring != 250 || earliest == nil {
+		t.Fatalf("expiring points: %d %v err=%v", expiring, earliest, err)
+	}
+
+	// 到期后剩余积分作废并记过期流水，重复执行不重复作废
+	later := now.AddDate(0, 0, 11)
+	res, err = svc.RunExpiry(later)
+	if err != nil || res.ExpiredLots != 1 || res.ExpiredPoints != 250 {
+		t.Fatalf("expire: %+v err=%v", res, err)
+	}
+	if res, _ := svc.RunExpiry(later); res.ExpiredLots != 0 {
+		t.Fatalf("expiry should be idempotent")
+	}
+	pts, sum := userPoints(t, db, user.ID)
+	if pts != 200 || sum != 200 || lotRemaining(t, db, user.ID) != 200 {
+		t.Fatalf("after expiry points=%d sum=%d lots=%d", pts, sum, lotRemaining(t, db, user.ID))
+	}
+	var expireTx model.PointsTransaction
+	db.Where("user_id = ? AND type = ?", user.ID, model.PointsTxExpire).First(&expireTx)
+	if expireTx.Change != -250 || expireTx.BalanceAfter != 200 {
+		t.Fatalf("unexpected expire tx: %+v", expireTx)
+	}
+}
+
+func TestOrderComplete_ConcurrentCompletionAccruesOnce(t *testing.T) {
+	dsn := "file:" + filepath.Join(t.TempDir(), "complete.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Order{}, &model.OrderItem{}, &model.SystemConfig{}, &model.PointsTransaction{},
+		&model.PointsLot{}, &model.ReferralClosure{}, &model.PartnerLevel{}, &model.Commission{}, &model.CommissionTransaction{},
+		&model.Payment{}, &model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	db.Exec("ALTER TABLE users ADD COLUMN partner_level_id integer")
+	db.Exec("CREATE TABLE user_memberships (id integer primary key, user_id integer, package_id integer, status text, started_at datetime)")
+	if err := (&PointsService{db: db}).SaveRules(&PointsRules{Enabled: true, PointsPerYuan: decimal.NewFromInt(1)}); err != nil {
+		t.Fatalf("save rules: %v", err)
+	}
+	level := model.PartnerLevel{Name: "合伙人", DirectCommissionRate: decimal.RequireFromString("0.10")}
+	db.Create(&level)
+	referrer := model.User{Phone: "13800000045", Nickname: "referrer", OpenID: "openid-complete-referrer"}
+	buyer := model.User{Phone: "13800000046", Nickname: "buyer", OpenID: "openid-complete-buyer"}
+	db.Create(&referrer)
+	db.Create(&buyer)
+	db.Exec("UPDATE users SET partner_level_id = ? WHERE id = ?", level.ID, referrer.ID)
+	db.Create(&model.ReferralClosure{AncestorUserID: referrer.ID, DescendantUserID: buyer.ID, Depth: 1})
+	order := model.Order{OrderNo: "CMP001", UserID: buyer.ID, Status: 3, PayStatus: 2, DeliveryType: 2,
+		TotalAmount: decimal.NewFromInt(50), PayAmount: decimal.NewFromInt(50)}
+	db.Create(&order)
+	db.Create(&model.OrderItem{OrderID: order.ID, ProductID: 1, Quantity: 1, Price: decimal.NewFromInt(50), Amount: decimal.NewFromInt(50)})
+	db.Create(&model.Payment{OrderID: order.ID, PaymentNo: "PCMP01", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(50), Status: 2})
+
+	// 管理端完成与用户确认收货并发：只有一方生效，积分与佣金只发放一次
+	osvc := &OrderService{db: db}
+	const n = 6
+	var (
+		wg sync.WaitGroup
+		ok int32
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if i%2 == 0 {
+				err = osvc.Complete(0, order.ID)
+			} else {
+				err = osvc.Receive(buyer.ID, order.ID)
+			}
+			if err == nil {
+				atomic.AddInt32(&ok, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if ok != 1 {
+		t.Fatalf("exactly one completion should succeed, got %d", ok)
+	}
+	var commissions, earns int64
+	db.Model(&model.Commission{}).Where("order_id = ?", order.ID).Count(&commissions)
+	db.Model(&model.PointsTransaction{}).Where("order_id = ? AND type = ?", order.ID, model.PointsTxOrderEarn).Count(&earns)
+	if commissions != 1 || earns != 1 {
+		t.Fatalf("completion should accrue once, commissions=%d points=%d", commissions, earns)
+	}
+}
+
+func TestOrderComplete_NoAccrualWithoutRecordedPayment(t *testing.T) {
+	db := setupLedgerFlowDB(t, "points_unpaid_complete")
+	if err := db.AutoMigrate(&model.SystemConfig{}, &model.PointsTransaction{}, &model.PointsLot{}, &model.ReferralClosure{},
+		&model.PartnerLevel{}, &model.Commission{}, &model.CommissionTransaction{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	db.Exec("ALTER TABLE users ADD COLUMN partner_level_id integer")
+	db.Exec("CREATE TABLE IF NOT EXISTS user_memberships (id integer primary key, user_id integer, package_id integer, status text, started_at datetime)")
+	if err := (&PointsService{db: db}).SaveRules(&PointsRules{Enabled: true, PointsPerYuan: decimal.NewFromInt(1)}); err != nil {
+		t.Fatalf("save rules: %v", err)
+	}
+	buyer := model.User{Phone: "13800000047", Nickname: "buyer", OpenID: "openid-unpaid-buyer"}
+	db.Create(&buyer)
+
+	level := model.PartnerLevel{Name: "合伙人", DirectCommissionRate: decimal.RequireFromString("0.10")}
+	db.Create(&level)
+	referrer := model.User{Phone: "13800000048", Nickname: "referrer", OpenID: "openid-unpaid-referrer"}
+	db.Create(&referrer)
+	db.Exec("UPDATE users SET partner_level_id = ? WHERE id = ?", level.ID, referrer.ID)
+	db.Create(&model.ReferralClosure{AncestorUserID: referrer.ID, DescendantUserID: buyer.ID, Depth: 1})
+
+	// 自取订单被标记为已付款但没有支付记录：用户不可自行确认收货
+	pickup := model.Order{OrderNo: "UNPAID00", UserID: buyer.ID, Status: 2, PayStatus: 2, DeliveryType: 1,
+		TotalAmount: decimal.NewFromInt(80), PayAmount: decimal.NewFromInt(80)}
+	db.Create(&pickup)
+	if err := (&OrderService{db: db}).Receive(buyer.ID, pickup.ID); err == nil {
+		t.Fatalf("owner should not complete an order without a recorded payment")
+	}
+
+	// 订单被标记为已付款但没有任何已确认的支付单：完成时不发放积分与佣金
+	order := model.Order{OrderNo: "UNPAID01", UserID: buyer.ID, Status: 3, PayStatus: 2, DeliveryType: 2,
+		TotalAmount: decimal.NewFromInt(80), PayAmount: decimal.NewFromInt(80)}
+	db.Create(&order)
+	db.Create(&model.OrderItem{OrderID: order.ID, ProductID: 1, Quantity: 1, Price: decimal.NewFromInt(80), Amount: decimal.NewFromInt(80)})
+	if err := (&OrderService{db: db}).Complete(0, order.ID); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if pts, _ := userPoints(t, db, buyer.ID); pts != 0 {
+		t.Fatalf("order without a confirmed payment should not earn points, got %d", pts)
+	}
+	var commissions int64
+	db.Model(&model.Commission{}).Where("user_id = ?", referrer.ID).Count(&commissions)
+	if commissions != 0 {
+		t.Fatalf("order without a confirmed payment should not accrue commissions, got %d", commissions)
+	}
+}
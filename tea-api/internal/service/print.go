@@ -0,0 +1,450 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/pkg/database"
+)
+
+// 打印触发方式
+const (
+	PrintTriggerPaid    = "paid"
+	PrintTriggerReprint = "reprint"
+)
+
+var printTemplateTypes = []string{model.PrintTemplateKitchen, model.PrintTemplateReceipt, model.PrintTemplateLabel}
+
+// PrintService 门店打印：打印机登记、模板管理、订单小票渲染与下发
+type PrintService struct {
+	db         *gorm.DB
+	dispatcher func(channel string) (PrintDispatcher, error)
+	now        func() time.Time
+}
+
+func NewPrintService() *PrintService {
+	return &PrintService{db: database.GetDB(), dispatcher: NewPrintDispatcher, now: time.Now}
+}
+
+// PrintTemplateView 门店生效模板；Source 标明来源：store 门店模板 / global 全局模板 / default 内置模板
+type PrintTemplateView struct {
+	TemplateType string `json:"template_type"`
+	Content      string `json:"content"`
+	Source       string `json:"source"`
+}
+
+// PrintOrderOptions 补打选项，均为空时按门店打印机配置全部打印
+type PrintOrderOptions struct {
+	PrinterID     uint
+	TemplateTypes []string
+}
+
+func isPrintTemplateType(t string) bool {
+	for _, v := range printTemplateTypes {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePrinterTemplates 校验并规范化打印机负责的模板列表，为空时默认打印制作单和顾客小票
+func normalizePrinterTemplates(csv string) (string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range strings.Split(csv, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		if !isPrintTemplateType(t) {
+			return "", fmt.Errorf("不支持的打印模板: %s", t)
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	if len(out) == 0 {
+		out = []string{model.PrintTemplateKitchen, model.PrintTemplateReceipt}
+	}
+	return strings.Join(out, ","), nil
+}
+
+func validatePrinter(p *model.StorePrinter) error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.DeviceSN = strings.TrimSpace(p.DeviceSN)
+	p.Channel = strings.ToLower(strings.TrimSpace(p.Channel))
+	if p.Name == "" || p.DeviceSN == "" {
+		return errors.New("打印机名称和编号不能为空")
+	}
+	if p.Channel == "" {
+		p.Channel = "cloud"
+	}
+	if p.Channel != "cloud" && p.Channel != "file" {
+		return errors.New("打印通道仅支持 cloud 或 file")
+	}
+	if p.PaperWidth == 0 {
+		p.PaperWidth = 58
+	}
+	if p.PaperWidth != 58 && p.PaperWidth != 80 {
+		return errors.New("纸宽仅支持 58 或 80")
+	}
+	if p.Copies == 0 {
+		p.Copies = 1
+	}
+	if p.Copies < 1 || p.Copies > 5 {
+		return errors.New("打印份数需在 1-5 之间")
+	}
+	if p.Status == 0 {
+		p.Status = 1
+	}
+	tpls, err := normalizePrinterTemplates(p.Templates)
+	if err != nil {
+		return err
+	}
+	p.Templates = tpls
+	return nil
+}
+
+// ListPrinters 门店打印机列表
+func (s *PrintService) ListPrinters(storeID uint) ([]model.StorePrinter, error) {
+	var list []model.StorePrinter
+	err := s.db.Where("store_id = ?", storeID).Order("id ASC").Find(&list).Error
+	return list, err
+}
+
+// CreatePrinter 登记门店打印机
+func (s *PrintService) CreatePrinter(p *model.StorePrinter) error {
+	if err := validatePrinter(p); err != nil {
+		return err
+	}
+	return s.db.Create(p).Error
+}
+
+// UpdatePrinter 更新打印机；deviceKey 为空时保留原密钥
+func (s *PrintService) UpdatePrinter(storeID, id uint, p model.StorePrinter) (*model.StorePrinter, error) {
+	var cur model.StorePrinter
+	if err := s.db.Where("id = ? AND store_id = ?", id, storeID).First(&cur).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("打印机不存在")
+		}
+		return nil, err
+	}
+	if err := validatePrinter(&p); err != nil {
+		return nil, err
+	}
+	updates := map[string]any{
+		"name":        p.Name,
+		"channel":     p.Channel,
+		"device_sn":   p.DeviceSN,
+		"paper_width": p.PaperWidth,
+		"templates":   p.Templates,
+		"copies":      p.Copies,
+		"auto_print":  p.AutoPrint,
+		"status":      p.Status,
+	}
+	if p.DeviceKey != "" {
+		updates["device_key"] = p.DeviceKey
+	}
+	if err := s.db.Model(&cur).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.First(&cur, cur.ID).Error; err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+// DeletePrinter 删除打印机
+func (s *PrintService) DeletePrinter(storeID, id uint) error {
+	res := s.db.Where("id = ? AND store_id = ?", id, storeID).Delete(&model.StorePrinter{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("打印机不存在")
+	}
+	return nil
+}
+
+// ListTemplates 返回门店各类模板的生效内容（门店模板 > 全局模板 > 内置模板）
+func (s *PrintService) ListTemplates(storeID uint) ([]PrintTemplateView, error) {
+	out := make([]PrintTemplateView, 0, len(printTemplateTypes))
+	for _, t := range printTemplateTypes {
+		v, err := s.resolveTemplate(storeID, t)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (s *PrintService) resolveTemplate(storeID uint, tplType string) (PrintTemplateView, error) {
+	var list []model.PrintTemplate
+	if err := s.db.Where("store_id IN ? AND template_type = ?", []uint{storeID, 0}, tplType).Find(&list).Error; err != nil {
+		return PrintTemplateView{}, err
+	}
+	var global *model.PrintTemplate
+	for i := range list {
+		if list[i].StoreID == storeID && storeID != 0 {
+			return PrintTemplateView{TemplateType: tplType, Content: list[i].Content, Source: "store"}, nil
+		}
+		if list[i].StoreID == 0 {
+			global = &list[i]
+		}
+	}
+	if global != nil {
+		return PrintTemplateView{TemplateType: tplType, Content: global.Content, Source: "global"}, nil
+	}
+	return PrintTemplateView{TemplateType: tplType, Content: defaultPrintTemplates[tplType], Source: "default"}, nil
+}
+
+// SaveTemplate 保存门店模板（storeID=0 为全局模板），保存前使用示例数据试渲染校验
+func (s *PrintService) SaveTemplate(storeID uint, tplType, content string) (*model.PrintTemplate, error) {
+	tplType = strings.ToLower(strings.TrimSpace(tplType))
+	if !isPrintTemplateType(tplType) {
+		return nil, fmt.Errorf("不支持的打印模板: %s", tplType)
+	}
+	if strings.TrimSpace(content) == "" {
+		return nil, errors.New("模板内容不能为空")
+	}
+	if err := validatePrintTemplate(content); err != nil {
+		return nil, err
+	}
+	var tpl model.PrintTemplate
+	err := s.db.Where("store_id = ? AND template_type = ?", storeID, tplType).First(&tpl).Error
+	switch {
+	case err == nil:
+		if err := s.db.Model(&tpl).Update("content", content).Error; err != nil {
+			return nil, err
+		}
+		tpl.Content = content
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		tpl = model.PrintTemplate{StoreID: storeID, TemplateType: tplType, Content: content}
+		if err := s.db.Create(&tpl).Error; err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	return &tpl, nil
+}
+
+// DeleteTemplate 删除门店模板，恢复为全局/内置模板
+func (s *PrintService) DeleteTemplate(storeID uint, tplType string) error {
+	return s.db.Unscoped().Where("store_id = ? AND template_type = ?", storeID, tplType).Delete(&model.PrintTemplate{}).Error
+}
+
+// validatePrintTemplate 使用示例订单试渲染，提前暴露模板语法与字段错误
+func validatePrintTemplate(content string) error {
+	now := time.Now()
+	sample := PrintTicketData{
+		Store:     model.Store{Name: "示例门店"},
+		Order:     model.Order{OrderNo: "ORD000000", PaidAt: &now, DeliveryTime: &now},
+		Items:     []model.OrderItem{{ProductName: "示例商品", Quantity: 1}},
+		PrintedAt: now,
+		Columns:   printColumns(58),
+	}
+	text, err := renderPrintTemplate(content, sample)
+	if err != nil {
+		return err
+	}
+	_, err = EncodeEscPos(text, 58)
+	return err
+}
+
+// ListJobs 订单打印记录
+func (s *PrintService) ListJobs(orderID uint) ([]model.PrintJob, error) {
+	var list []model.PrintJob
+	err := s.db.Where("order_id = ?", orderID).Order("id DESC").Find(&list).Error
+	return list, err
+}
+
+// PrintOrder 渲染订单小票并下发到门店打印机，返回本次生成的打印任务
+// trigger=paid 时仅使用开启自动打印的打印机，且同一订单只自动打印一次；配送标签仅对配送订单自动打印。
+func (s *PrintService) PrintOrder(ctx context.Context, orderID uint, trigger string, operatorID uint, opts PrintOrderOptions) ([]model.PrintJob, error) {
+	var order model.Order
+	if err := s.db.First(&order, orderID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("订单不存在")
+		}
+		return nil, err
+	}
+	if order.StoreID == 0 {
+		return nil, errors.New("订单未关联门店，无法打印")
+	}
+	if order.PayStatus == 1 || order.Status == 1 || order.Status == 5 {
+		return nil, errors.New("订单未支付，无法打印")
+	}
+	if trigger == PrintTriggerPaid {
+		var printed int64
+		if err := s.db.Model(&model.PrintJob{}).Where("order_id = ? AND trigger_type = ?", order.ID, PrintTriggerPaid).Count(&printed).Error; err != nil {
+			return nil, err
+		}
+		if printed > 0 {
+			return nil, nil
+		}
+	}
+	for _, t := range opts.TemplateTypes {
+		if !isPrintTemplateType(t) {
+			return nil, fmt.Errorf("不支持的打印模板: %s", t)
+		}
+	}
+
+	q := s.db.Where("store_id = ? AND status = ?", order.StoreID, 1)
+	if opts.PrinterID > 0 {
+		q = q.Where("id = ?", opts.PrinterID)
+	}
+	if trigger == PrintTriggerPaid {
+		q = q.Where("auto_print = ?", true)
+	}
+	var printers []model.StorePrinter
+	if err := q.Order("id ASC").Find(&printers).Error; err != nil {
+		return nil, err
+	}
+	if len(printers) == 0 {
+		if trigger == PrintTriggerPaid {
+			return nil, nil
+		}
+		return nil, errors.New("门店未配置可用的打印机")
+	}
+
+	var store model.Store
+	if err := s.db.First(&store, order.StoreID).Error; err != nil {
+		return nil, fmt.Errorf("门店不存在: %w", err)
+	}
+	var items []model.OrderItem
+	if err := s.db.Where("order_id = ?", order.ID).Order("id ASC").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	contact, phone, address := parseOrderAddress(order.AddressInfo)
+	now := s.now()
+
+	templates := map[string]string{}
+	var jobs []model.PrintJob
+	for _, p := range printers {
+		for _, tplType := range strings.Split(p.Templates, ",") {
+			if !printTemplateWanted(tplType, opts.TemplateTypes, order.DeliveryType) {
+				continue
+			}
+			content, ok := templates[tplType]
+			if !ok {
+				v, err := s.resolveTemplate(order.StoreID, tplType)
+				if err != nil {
+					return jobs, err
+				}
+				content = v.Content
+				templates[tplType] = content
+			}
+			data := PrintTicketData{
+				Store: store, Order: order, Items: items,
+				Contact: contact, Phone: phone, Address: address,
+				Reprint:   trigger == PrintTriggerReprint,
+				PrintedAt: now,
+				Columns:   printColumns(p.PaperWidth),
+			}
+			jobs = append(jobs, s.dispatchJob(ctx, p, order, tplType, trigger, operatorID, content, data))
+		}
+	}
+	if len(jobs) == 0 && trigger != PrintTriggerPaid {
+		return nil, errors.New("没有匹配的打印机或模板")
+	}
+	return jobs, nil
+}
+
+// printTemplateWanted 判断打印机负责的模板在本次打印中是否需要输出
+func printTemplateWanted(tplType string, requested []string, deliveryType int) bool {
+	if len(requested) == 0 {
+		// 未指定模板时，配送标签仅对配送订单输出
+		return tplType != model.PrintTemplateLabel || deliveryType == 2
+	}
+	for _, r := range requested {
+		if r == tplType {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatchJob 渲染并下发单张小票，下发结果记录在打印任务上
+func (s *PrintService) dispatchJob(ctx context.Context, p model.StorePrinter, order model.Order, tplType, trigger string, operatorID uint, content string, data PrintTicketData) model.PrintJob {
+	job := model.PrintJob{
+		StoreID:      order.StoreID,
+		PrinterID:    p.ID,
+		OrderID:      order.ID,
+		TemplateType: tplType,
+		TriggerType:  trigger,
+		Status:       model.PrintJobPending,
+		OperatorID:   operatorID,
+	}
+	if err := s.db.Create(&job).Error; err != nil {
+		job.Status = model.PrintJobFailed
+		job.Error = err.Error()
+		return job
+	}
+
+	fail := func(err error) model.PrintJob {
+		msg := err.Error()
+		if len(msg) > 500 {
+			msg = msg[:500]
+		}
+		job.Status = model.PrintJobFailed
+		job.Error = msg
+		s.db.Model(&job).Updates(map[string]any{"status": job.Status, "error": msg})
+		return job
+	}
+
+	text, err := renderPrintTemplate(content, data)
+	if err != nil {
+		return fail(err)
+	}
+	payload, err := EncodeEscPos(text, p.PaperWidth)
+	if err != nil {
+		return fail(err)
+	}
+	dispatcher, err := s.dispatcher(p.Channel)
+	if err != nil {
+		return fail(err)
+	}
+	externalID, err := dispatcher.Send(ctx, PrintRequest{
+		Printer: p,
+		JobNo:   fmt.Sprintf("PJ%010d", job.ID),
+		Copies:  p.Copies,
+		Data:    payload,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	printedAt := s.now()
+	job.Status = model.PrintJobSuccess
+	job.ExternalID = externalID
+	job.PrintedAt = &printedAt
+	s.db.Model(&job).Updates(map[string]any{"status": job.Status, "external_id": externalID, "printed_at": printedAt})
+	return job
+}
+
+// TriggerOrderPaidPrint 订单支付成功后异步自动打印，失败只记录日志，不影响支付流程
+func TriggerOrderPaidPrint(orderID uint) {
+	if database.GetDB() == nil || orderID == 0 {
+		return
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				zap.L().Error("auto print panic", zap.Uint("order_id", orderID), zap.Any("panic", r))
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if _, err := NewPrintService().PrintOrder(ctx, orderID, PrintTriggerPaid, 0, PrintOrderOptions{}); err != nil {
+			zap.L().Warn("auto print failed", zap.Uint("order_id", orderID), zap.Error(err))
+		}
+	}()
+}
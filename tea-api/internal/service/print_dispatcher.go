@@ -0,0 +1,162 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+	"tea-api/pkg/utils"
+)
+
+// PrintRequest 下发到打印机的一张小票
+type PrintRequest struct {
+	Printer model.StorePrinter
+	JobNo   string // 本地任务号，云平台据此去重
+	Copies  int
+	Data    []byte // ESC/POS 字节流
+}
+
+// PrintDispatcher 打印下发通道，返回平台侧任务号
+type PrintDispatcher interface {
+	Name() string
+	Send(ctx context.Context, req PrintRequest) (string, error)
+}
+
+// NewPrintDispatcher 按打印机登记的通道创建下发实现
+func NewPrintDispatcher(channel string) (PrintDispatcher, error) {
+	cfg := config.Config.Print
+	switch strings.ToLower(strings.TrimSpace(channel)) {
+	case "", "cloud":
+		if cfg.CloudBaseURL == "" {
+			return nil, errors.New("未配置云打印平台地址")
+		}
+		timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		return &CloudPrintDispatcher{
+			BaseURL:   cfg.CloudBaseURL,
+			AppID:     cfg.CloudAppID,
+			AppSecret: cfg.CloudAppSecret,
+			Client:    &http.Client{Timeout: timeout},
+		}, nil
+	case "file":
+		dir := cfg.FileSinkDir
+		if dir == "" {
+			dir = "uploads/prints"
+		}
+		return &FileSinkDispatcher{Dir: dir}, nil
+	default:
+		return nil, fmt.Errorf("不支持的打印通道: %s", channel)
+	}
+}
+
+// CloudPrintDispatcher 云打印机 HTTP 下发
+// 请求：POST {BaseURL}/print，JSON 体包含 app_id、sn、key、content(base64 ESC/POS)、copies、out_job_no、timestamp、sign，
+// sign = HMAC-SHA256(app_secret, "app_id=..&copies=..&out_job_no=..&sn=..&timestamp=..") 小写十六进制；
+// 响应：{"code":0,"msg":"","data":{"job_id":"..."}}，code 非 0 视为失败。
+type CloudPrintDispatcher struct {
+	BaseURL   string
+	AppID     string
+	AppSecret string
+	Client    *http.Client
+	Now       func() time.Time
+}
+
+func (d *CloudPrintDispatcher) Name() string { return "cloud" }
+
+type cloudPrintResp struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data struct {
+		JobID string `json:"job_id"`
+	} `json:"data"`
+}
+
+func (d *CloudPrintDispatcher) Send(ctx context.Context, req PrintRequest) (string, error) {
+	now := time.Now
+	if d.Now != nil {
+		now = d.Now
+	}
+	copies := req.Copies
+	if copies <= 0 {
+		copies = 1
+	}
+	ts := strconv.FormatInt(now().Unix(), 10)
+	canonical := fmt.Sprintf("app_id=%s&copies=%d&out_job_no=%s&sn=%s&timestamp=%s", d.AppID, copies, req.JobNo, req.Printer.DeviceSN, ts)
+	body, _ := json.Marshal(map[string]any{
+		"app_id":     d.AppID,
+		"sn":         req.Printer.DeviceSN,
+		"key":        req.Printer.DeviceKey,
+		"content":    base64.StdEncoding.EncodeToString(req.Data),
+		"copies":     copies,
+		"out_job_no": req.JobNo,
+		"timestamp":  ts,
+		"sign":       utils.HMACSHA256Hex(d.AppSecret, canonical),
+	})
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(d.BaseURL, "/")+"/print", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("云打印请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("云打印平台返回 HTTP %d", resp.StatusCode)
+	}
+	var out cloudPrintResp
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return "", fmt.Errorf("云打印响应解析失败: %w", err)
+	}
+	if out.Code != 0 {
+		return "", fmt.Errorf("云打印失败(%d): %s", out.Code, out.Msg)
+	}
+	return out.Data.JobID, nil
+}
+
+// FileSinkDispatcher 将 ESC/POS 字节流写入本地文件（{Dir}/{sn}/{job_no}.bin），用于联调与测试
+type FileSinkDispatcher struct {
+	Dir string
+}
+
+func (d *FileSinkDispatcher) Name() string { return "file" }
+
+func (d *FileSinkDispatcher) Send(_ context.Context, req PrintRequest) (string, error) {
+	sn := filepath.Base(strings.TrimSpace(req.Printer.DeviceSN))
+	if sn == "" || sn == "." || sn == string(filepath.Separator) {
+		sn = "default"
+	}
+	dir := filepath.Join(d.Dir, sn)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("创建打印目录失败: %w", err)
+	}
+	copies := req.Copies
+	if copies <= 0 {
+		copies = 1
+	}
+	path := filepath.Join(dir, req.JobNo+".bin")
+	if err := os.WriteFile(path, bytes.Repeat(req.Data, copies), 0o644); err != nil {
+		return "", fmt.Errorf("写入打印文件失败: %w", err)
+	}
+	return req.JobNo, nil
+}
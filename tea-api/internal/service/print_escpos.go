@@ -0,0 +1,291 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+
+	"tea-api/internal/model"
+)
+
+// ESC/POS 指令
+var (
+	escPosInit      = []byte{0x1B, 0x40}       // ESC @ 初始化
+	escPosHanzi     = []byte{0x1C, 0x26}       // FS & 进入汉字模式
+	escPosBoldOn    = []byte{0x1B, 0x45, 0x01} // ESC E 1 加粗
+	escPosBoldOff   = []byte{0x1B, 0x45, 0x00}
+	escPosSizeLarge = []byte{0x1D, 0x21, 0x11} // GS ! 倍宽倍高
+	escPosSizeNorm  = []byte{0x1D, 0x21, 0x00}
+	escPosCut       = []byte{0x1D, 0x56, 0x42, 0x00} // GS V 66 0 走纸半切
+)
+
+// escPosAlign ESC a n：0 左 1 中 2 右
+func escPosAlign(n byte) []byte { return []byte{0x1B, 0x61, n} }
+
+// PrintTicketData 打印模板渲染数据
+type PrintTicketData struct {
+	Store     model.Store
+	Order     model.Order
+	Items     []model.OrderItem
+	Contact   string // 收货人
+	Phone     string
+	Address   string
+	Reprint   bool // 补打时为 true，模板可据此打印“补打”字样
+	PrintedAt time.Time
+	Columns   int // 当前纸宽每行可打印的半角字符数
+}
+
+// printColumns 不同纸宽每行可打印的半角字符数（58mm=32，80mm=48）
+func printColumns(paperWidth int) int {
+	if paperWidth >= 80 {
+		return 48
+	}
+	return 32
+}
+
+// textWidth 文本显示宽度：非 ASCII 字符按全角占 2 列
+func textWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			w++
+		} else {
+			w += 2
+		}
+	}
+	return w
+}
+
+// fitText 截断或右侧补空格到指定宽度
+func fitText(s string, width int) string {
+	var b strings.Builder
+	w := 0
+	for _, r := range s {
+		rw := 1
+		if r >= utf8.RuneSelf {
+			rw = 2
+		}
+		if w+rw > width {
+			break
+		}
+		b.WriteRune(r)
+		w += rw
+	}
+	return b.String() + strings.Repeat(" ", width-w)
+}
+
+// justifyText 左右两端对齐，空间不足时截断左侧文本
+func justifyText(left, right string, width int) string {
+	rw := textWidth(right)
+	if rw >= width {
+		return right
+	}
+	lw := width - rw - 1
+	if textWidth(left) > lw {
+		left = fitText(left, lw)
+	}
+	return left + strings.Repeat(" ", width-textWidth(left)-rw) + right
+}
+
+// parseOrderAddress 解析订单收货信息（兼容小程序提交的 contact/name、phone、full_address/detail/address 字段）
+func parseOrderAddress(info string) (contact, phone, address string) {
+	info = strings.TrimSpace(info)
+	if info == "" || info == "{}" || info == "null" {
+		return "", "", ""
+	}
+	var m map[string]any
+	if err := json.Unmarshal([]byte(info), &m); err != nil {
+		return "", "", info
+	}
+	pick := func(keys ...string) string {
+		for _, k := range keys {
+			if v, ok := m[k]; ok && v != nil {
+				if s := strings.TrimSpace(fmt.Sprint(v)); s != "" {
+					return s
+				}
+			}
+		}
+		return ""
+	}
+	return pick("contact", "name"), pick("phone"), pick("full_address", "detail", "address")
+}
+
+// renderPrintTemplate 执行模板得到带行首指令的文本
+func renderPrintTemplate(content string, data PrintTicketData) (string, error) {
+	cols := data.Columns
+	funcs := template.FuncMap{
+		"money": func(d decimal.Decimal) string { return d.StringFixed(2) },
+		"lr":    func(left, right string) string { return justifyText(left, right, cols) },
+		"fit":   func(s string, width int) string { return fitText(s, width) },
+		"fmtTime": func(t any) string {
+			switch v := t.(type) {
+			case time.Time:
+				return v.Format("2006-01-02 15:04")
+			case *time.Time:
+				if v != nil {
+					return v.Format("2006-01-02 15:04")
+				}
+			}
+			return ""
+		},
+	}
+	tpl, err := template.New("ticket").Funcs(funcs).Parse(content)
+	if err != nil {
+		return "", fmt.Errorf("模板解析失败: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("模板渲染失败: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// EncodeEscPos 将渲染后的文本编码为 ESC/POS 字节流（GBK 编码）
+// 行首指令可叠加：[C] 居中、[R] 右对齐、[B] 加粗倍高（倍宽后每行字符数减半）；独立一行的 [HR] 打印分隔线、[CUT] 切纸。
+// 文本末尾未显式切纸时自动追加。
+func EncodeEscPos(text string, paperWidth int) ([]byte, error) {
+	cols := printColumns(paperWidth)
+	enc := encoding.ReplaceUnsupported(simplifiedchinese.GBK.NewEncoder())
+	var out bytes.Buffer
+	out.Write(escPosInit)
+	out.Write(escPosHanzi)
+
+	cut := false
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch trimmed {
+		case "[HR]":
+			out.WriteString(strings.Repeat("-", cols))
+			out.WriteByte('\n')
+			cut = false
+			continue
+		case "[CUT]":
+			out.WriteString("\n\n\n")
+			out.Write(escPosCut)
+			cut = true
+			continue
+		}
+
+		align, large := byte(0), false
+	directives:
+		for len(line) >= 3 {
+			switch line[:3] {
+			case "[C]":
+				align = 1
+			case "[R]":
+				align = 2
+			case "[B]":
+				large = true
+			default:
+				break directives
+			}
+			line = line[3:]
+		}
+		encoded, err := enc.String(line)
+		if err != nil {
+			return nil, fmt.Errorf("文本编码失败: %w", err)
+		}
+		if align != 0 {
+			out.Write(escPosAlign(align))
+		}
+		if large {
+			out.Write(escPosBoldOn)
+			out.Write(escPosSizeLarge)
+		}
+		out.WriteString(encoded)
+		out.WriteByte('\n')
+		if large {
+			out.Write(escPosSizeNorm)
+			out.Write(escPosBoldOff)
+		}
+		if align != 0 {
+			out.Write(escPosAlign(0))
+		}
+		cut = false
+	}
+	if !cut {
+		out.WriteString("\n\n\n")
+		out.Write(escPosCut)
+	}
+	return out.Bytes(), nil
+}
+
+// 内置默认模板，门店或全局未配置时使用
+var defaultPrintTemplates = map[string]string{
+	model.PrintTemplateKitchen: `[C][B]{{.Store.Name}} 制作单
+{{- if .Reprint}}
+[C](补打)
+{{- end}}
+[HR]
+订单号：{{.Order.OrderNo}}
+下单时间：{{fmtTime .Order.CreatedAt}}
+{{- if .Order.DeliveryTime}}
+[B]预约时间：{{fmtTime .Order.DeliveryTime}}
+{{- end}}
+[HR]
+{{- range .Items}}
+[B]{{.ProductName}} x{{.Quantity}}
+{{- if .SkuName}}
+  {{.SkuName}}
+{{- end}}
+{{- end}}
+[HR]
+{{- if .Order.Remark}}
+[B]备注：{{.Order.Remark}}
+{{- end}}
+打印时间：{{fmtTime .PrintedAt}}`,
+
+	model.PrintTemplateReceipt: `[C][B]{{.Store.Name}}
+{{- if .Reprint}}
+[C](补打)
+{{- end}}
+[HR]
+订单号：{{.Order.OrderNo}}
+下单时间：{{fmtTime .Order.CreatedAt}}
+支付时间：{{fmtTime .Order.PaidAt}}
+[HR]
+{{- range .Items}}
+{{lr (printf "%s x%d" .ProductName .Quantity) (money .Amount)}}
+{{- end}}
+[HR]
+{{lr "商品合计" (money .Order.TotalAmount)}}
+{{- if .Order.DeliveryFee.IsPositive}}
+{{lr "配送费" (money .Order.DeliveryFee)}}
+{{- end}}
+{{- if .Order.DiscountAmount.IsPositive}}
+{{lr "优惠" (printf "-%s" (money .Order.DiscountAmount))}}
+{{- end}}
+[R][B]实付 {{money .Order.PayAmount}}
+[HR]
+{{- if .Store.Address}}
+地址：{{.Store.Address}}
+{{- end}}
+{{- if .Store.Phone}}
+电话：{{.Store.Phone}}
+{{- end}}
+[C]谢谢惠顾，欢迎再次光临`,
+
+	model.PrintTemplateLabel: `[B]{{.Store.Name}} 配送单
+[HR]
+订单号：{{.Order.OrderNo}}
+{{- if .Order.DeliveryTime}}
+[B]送达时间：{{fmtTime .Order.DeliveryTime}}
+{{- end}}
+[B]{{.Contact}} {{.Phone}}
+[B]{{.Address}}
+[HR]
+{{- range .Items}}
+{{lr .ProductName (printf "x%d" .Quantity)}}
+{{- end}}
+{{- if .Order.Remark}}
+备注：{{.Order.Remark}}
+{{- end}}`,
+}
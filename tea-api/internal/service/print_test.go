@@ -0,0 +1,114 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+func TestJustifyText_CountsFullWidth(t *testing.T) {
+	got := justifyText("乌龙茶 x2", "36.00", 32)
+	if textWidth(got) != 32 || !strings.HasSuffix(got, "36.00") {
+		t.Fatalf("unexpected justify result: %q (width %d)", got, textWidth(got))
+	}
+	long := justifyText(strings.Repeat("茶", 30), "1.00", 32)
+	if textWidth(long) != 32 {
+		t.Fatalf("long text should be truncated to paper width, got %d", textWidth(long))
+	}
+}
+
+func TestEncodeEscPos_DirectivesAndGBK(t *testing.T) {
+	out, err := EncodeEscPos("[C][B]茶馆\n[HR]\n普通行", 58)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if !bytes.HasPrefix(out, append(append([]byte{}, escPosInit...), escPosHanzi...)) {
+		t.Fatalf("missing init sequence")
+	}
+	title, _ := simplifiedchinese.GBK.NewEncoder().String("茶馆")
+	want := append(append(append(escPosAlign(1), escPosBoldOn...), escPosSizeLarge...), []byte(title)...)
+	if !bytes.Contains(out, want) {
+		t.Fatalf("centered bold title not encoded as expected")
+	}
+	if !bytes.Contains(out, []byte(strings.Repeat("-", 32)+"\n")) {
+		t.Fatalf("separator should span 32 columns on 58mm paper")
+	}
+	if !bytes.HasSuffix(out, escPosCut) {
+		t.Fatalf("ticket should end with a cut")
+	}
+}
+
+func TestDefaultPrintTemplatesValid(t *testing.T) {
+	for typ, content := range defaultPrintTemplates {
+		if err := validatePrintTemplate(content); err != nil {
+			t.Fatalf("default template %s invalid: %v", typ, err)
+		}
+	}
+}
+
+func TestPrintOrder_AutoPrintOnceAndReprint(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:print_order?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.Store{}, &model.Order{}, &model.OrderItem{}, &model.StorePrinter{}, &model.PrintTemplate{}, &model.PrintJob{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	store := model.Store{Name: "一号店", Address: "人民路 1 号"}
+	db.Create(&store)
+	now := time.Now()
+	order := model.Order{
+		OrderNo: "ORD1", UserID: 1, StoreID: store.ID, Status: 2, PayStatus: 2, DeliveryType: 1,
+		TotalAmount: decimal.NewFromInt(30), PayAmount: decimal.NewFromInt(30), PaidAt: &now,
+		AddressInfo: `{"contact":"张三","phone":"13800000000","detail":"幸福小区"}`,
+	}
+	db.Create(&order)
+	db.Create(&model.OrderItem{OrderID: order.ID, ProductID: 1, ProductName: "乌龙茶", Price: decimal.NewFromInt(15), Quantity: 2, Amount: decimal.NewFromInt(30)})
+	db.Create(&model.StorePrinter{StoreID: store.ID, Name: "后厨", Channel: "file", DeviceSN: "SN1", PaperWidth: 58, Templates: "kitchen,label", Copies: 1, AutoPrint: true, Status: 1})
+
+	dir := t.TempDir()
+	svc := &PrintService{db: db, now: time.Now, dispatcher: func(string) (PrintDispatcher, error) {
+		return &FileSinkDispatcher{Dir: dir}, nil
+	}}
+
+	jobs, err := svc.PrintOrder(context.Background(), order.ID, PrintTriggerPaid, 0, PrintOrderOptions{})
+	if err != nil {
+		t.Fatalf("auto print failed: %v", err)
+	}
+	// 自取订单不自动打印配送标签
+	if len(jobs) != 1 || jobs[0].TemplateType != model.PrintTemplateKitchen || jobs[0].Status != model.PrintJobSuccess {
+		t.Fatalf("unexpected auto print jobs: %+v", jobs)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "SN1", jobs[0].ExternalID+".bin"))
+	if err != nil {
+		t.Fatalf("read sink file: %v", err)
+	}
+	name, _ := simplifiedchinese.GBK.NewEncoder().String("乌龙茶")
+	if !bytes.Contains(raw, []byte(name)) {
+		t.Fatalf("ticket should contain item name")
+	}
+
+	again, err := svc.PrintOrder(context.Background(), order.ID, PrintTriggerPaid, 0, PrintOrderOptions{})
+	if err != nil || len(again) != 0 {
+		t.Fatalf("auto print should run only once, got %d jobs err=%v", len(again), err)
+	}
+
+	reprint, err := svc.PrintOrder(context.Background(), order.ID, PrintTriggerReprint, 9, PrintOrderOptions{TemplateTypes: []string{model.PrintTemplateLabel}})
+	if err != nil || len(reprint) != 1 || reprint[0].TemplateType != model.PrintTemplateLabel {
+		t.Fatalf("reprint label failed: %+v err=%v", reprint, err)
+	}
+	if _, err := svc.PrintOrder(context.Background(), order.ID, PrintTriggerReprint, 9, PrintOrderOptions{TemplateTypes: []string{model.PrintTemplateReceipt}}); err == nil {
+		t.Fatalf("reprint should fail when no printer handles the template")
+	}
+}
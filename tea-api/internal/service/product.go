@@ -215,8 +215,10 @@ func (s *ProductService) GetProduct(id uint) (*model.Product, error) {
 // ProductWithStoreDetail 商品详情（含门店维度字段）
 type ProductWithStoreDetail struct {
 	model.Product
-	StoreStock         *int    `json:"store_stock"`
-	StorePriceOverride *string `json:"store_price_override"`
+	StoreStock          *int    `json:"store_stock"`
+	StorePriceOverride  *string `json:"store_price_override"`
+	AvailableStock      int     `json:"available_stock"`       // 可售库存 = 实物库存 - 未支付订单预占
+	StoreAvailableStock *int    `json:"store_available_stock"` // 门店可售库存
 }
 
 // GetProductForStore 获取指定门店维度的商品详情
@@ -226,11 +228,19 @@ func (s *ProductService) GetProductForStore(id, storeID uint) (*ProductWithStore
 		return nil, err
 	}
 	detail := &ProductWithStoreDetail{Product: *p}
+	avail, err := AvailableStock(s.db, p.Stock, p.ID, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("查询可售库存失败: %w", err)
+	}
+	detail.AvailableStock = avail
 	// 查询门店商品绑定
 	var sp model.StoreProduct
 	if err := s.db.Where("store_id = ? AND product_id = ?", storeID, id).First(&sp).Error; err == nil {
 		stock := sp.Stock
 		detail.StoreStock = &stock
+		if storeAvail, err := AvailableStock(s.db, sp.Stock, id, nil, storeID); err == nil {
+			detail.StoreAvailableStock = &storeAvail
+		}
 		// 仅在覆盖价>0时返回字符串
 		if sp.PriceOverride.GreaterThan(decimalZero()) {
 			s := sp.PriceOverride.String()
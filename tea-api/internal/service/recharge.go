@@ -0,0 +1,364 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service/ledger"
+	"tea-api/pkg/database"
+)
+
+// OrderTypeRecharge 充值订单（无商品明细，支付成功后入账钱包）
+const OrderTypeRecharge = 5
+
+// 充值相关钱包流水类型
+const (
+	WalletTxRecharge              = "recharge"                // 充值本金
+	WalletTxRechargeBonus         = "recharge_bonus"          // 充值赠送
+	WalletTxRechargeRefund        = "recharge_refund"         // 充值退款扣回本金
+	WalletTxRechargeBonusClawback = "recharge_bonus_clawback" // 充值退款扣回赠送
+)
+
+// RechargeTier 充值档位，对应 SystemConfig recharge.packages 中的一项
+type RechargeTier struct {
+	AmountCents            int64  `json:"amount_cents"`
+	BonusTeaCoinCents      int64  `json:"bonus_tea_coin_cents,omitempty"`
+	BonusCouponTemplateIDs []uint `json:"bonus_coupon_template_ids,omitempty"`
+	LimitPerUser           int    `json:"limit_per_user,omitempty"` // 每个用户可充值该档位的次数，0 表示不限
+	Status                 int    `json:"status,omitempty"`         // 1:启用 2:停用
+}
+
+// RechargeSettings 充值配置：recharge.enabled / recharge.packages / recharge.daily_limit_cents
+type RechargeSettings struct {
+	Enabled         bool           `json:"enabled"`
+	Tiers           []RechargeTier `json:"tiers"`
+	DailyLimitCents int64          `json:"daily_limit_cents"` // 每个用户每日充值上限（分），0 表示不限
+}
+
+// UserRechargeTier 面向用户的档位，附带剩余可充值次数
+type UserRechargeTier struct {
+	RechargeTier
+	Remaining int `json:"remaining"` // -1 表示不限
+}
+
+// UserRechargeOptions 用户可见的充值配置
+type UserRechargeOptions struct {
+	Enabled             bool               `json:"enabled"`
+	Tiers               []UserRechargeTier `json:"tiers"`
+	DailyLimitCents     int64              `json:"daily_limit_cents"`
+	DailyRemainingCents int64              `json:"daily_remaining_cents"` // -1 表示不限
+}
+
+type RechargeService struct {
+	db *gorm.DB
+}
+
+func NewRechargeService() *RechargeService {
+	return &RechargeService{db: database.GetDB()}
+}
+
+// LoadSettings 读取启用中的 recharge.* 配置
+func (s *RechargeService) LoadSettings() (*RechargeSettings, error) {
+	var cfgs []model.SystemConfig
+	if err := s.db.Where("config_key LIKE ? AND status = 1", "recharge.%").Find(&cfgs).Error; err != nil {
+		return nil, err
+	}
+	st := &RechargeSettings{Tiers: []RechargeTier{}}
+	for _, c := range cfgs {
+		val := strings.TrimSpace(c.ConfigValue)
+		switch c.ConfigKey {
+		case "recharge.enabled":
+			st.Enabled = val == "1" || strings.EqualFold(val, "true")
+		case "recharge.packages":
+			if val == "" {
+				continue
+			}
+			var tiers []RechargeTier
+			if err := json.Unmarshal([]byte(val), &tiers); err != nil {
+				return nil, fmt.Errorf("充值档位配置格式错误: %w", err)
+			}
+			for _, t := range tiers {
+				if t.AmountCents > 0 && t.Status != 2 {
+					st.Tiers = append(st.Tiers, t)
+				}
+			}
+		case "recharge.daily_limit_cents":
+			st.DailyLimitCents, _ = strconv.ParseInt(val, 10, 64)
+		}
+	}
+	return st, nil
+}
+
+// paidCountByAmount 用户已到账的各档位充值次数
+func (s *RechargeService) paidCountByAmount(userID uint) (map[int64]int, error) {
+	var rows []struct {
+		AmountCents int64
+		N           int
+	}
+	if err := s.db.Model(&model.RechargeRecord{}).Select("amount_cents, COUNT(*) AS n").
+		Where("user_id = ? AND status = ?", userID, model.RechargeStatusCredited).
+		Group("amount_cents").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	res := make(map[int64]int, len(rows))
+	for _, r := range rows {
+		res[r.AmountCents] = r.N
+	}
+	return res, nil
+}
+
+// creditedToday 用户当日已到账的充值本金（分）
+func (s *RechargeService) creditedToday(userID uint, now time.Time) (int64, error) {
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var sum int64
+	err := s.db.Model(&model.RechargeRecord{}).Select("COALESCE(SUM(amount_cents), 0)").
+		Where("user_id = ? AND status = ? AND credited_at >= ?", userID, model.RechargeStatusCredited, start).
+		Scan(&sum).Error
+	return sum, err
+}
+
+// UserOptions 返回用户可选的充值档位及剩余额度
+func (s *RechargeService) UserOptions(userID uint) (*UserRechargeOptions, error) {
+	st, err := s.LoadSettings()
+	if err != nil {
+		return nil, err
+	}
+	opts := &UserRechargeOptions{Enabled: st.Enabled, Tiers: []UserRechargeTier{}, DailyLimitCents: st.DailyLimitCents, DailyRemainingCents: -1}
+	if !st.Enabled {
+		return opts, nil
+	}
+	counts, err := s.paidCountByAmount(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range st.Tiers {
+		remaining := -1
+		if t.LimitPerUser > 0 {
+			remaining = t.LimitPerUser - counts[t.AmountCents]
+			if remaining < 0 {
+				remaining = 0
+			}
+		}
+		opts.Tiers = append(opts.Tiers, UserRechargeTier{RechargeTier: t, Remaining: remaining})
+	}
+	if st.DailyLimitCents > 0 {
+		used, err := s.creditedToday(userID, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		opts.DailyRemainingCents = st.DailyLimitCents - used
+		if opts.DailyRemainingCents < 0 {
+			opts.DailyRemainingCents = 0
+		}
+	}
+	return opts, nil
+}
+
+// CreateRechargeOrder 按档位创建充值订单，之后走统一下单接口支付
+// 约定：OrderType=5 表示充值订单，StoreID=0，无商品明细；赠送内容在下单时快照，避免支付期间档位被修改。
+func (s *RechargeService) CreateRechargeOrder(userID uint, amountCents int64) (*model.Order, *model.RechargeRecord, error) {
+	if userID == 0 || amountCents <= 0 {
+		return nil, nil, errors.New("非法的充值金额")
+	}
+	st, err := s.LoadSettings()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !st.Enabled {
+		return nil, nil, errors.New("充值功能未开启")
+	}
+	var tier *RechargeTier
+	for i := range st.Tiers {
+		if st.Tiers[i].AmountCents == amountCents {
+			tier = &st.Tiers[i]
+			break
+		}
+	}
+	if tier == nil {
+		return nil, nil, errors.New("充值档位不存在或已停用")
+	}
+	if tier.LimitPerUser > 0 {
+		counts, err := s.paidCountByAmount(userID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if counts[amountCents] >= tier.LimitPerUser {
+			return nil, nil, errors.New("该充值档位已达到可充值次数上限")
+		}
+	}
+	if st.DailyLimitCents > 0 {
+		used, err := s.creditedToday(userID, time.Now())
+		if err != nil {
+			return nil, nil, err
+		}
+		if used+amountCents > st.DailyLimitCents {
+			return nil, nil, errors.New("超出每日充值限额")
+		}
+	}
+
+	couponIDs := "[]"
+	if len(tier.BonusCouponTemplateIDs) > 0 {
+		b, _ := json.Marshal(tier.BonusCouponTemplateIDs)
+		couponIDs = string(b)
+	}
+	amount := decimal.New(amountCents, -2)
+	order := &model.Order{
+		OrderNo:        generateOrderNo("R"),
+		UserID:         userID,
+		StoreID:        0,
+		Status:         1, // 待付款
+		PayStatus:      1, // 未付款
+		OrderType:      OrderTypeRecharge,
+		DeliveryType:   1, // 虚拟
+		AddressInfo:    "{}",
+		Remark:         "余额充值",
+		TotalAmount:    amount,
+		DiscountAmount: decimal.NewFromInt(0),
+		DeliveryFee:    decimal.NewFromInt(0),
+		PayAmount:      amount,
+	}
+	rec := &model.RechargeRecord{
+		UserID:         userID,
+		AmountCents:    amountCents,
+		BonusCents:     tier.BonusTeaCoinCents,
+		BonusCouponIDs: couponIDs,
+		Status:         model.RechargeStatusPending,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+		rec.OrderID = order.ID
+		return tx.Create(rec).Error
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建充值订单失败: %w", err)
+	}
+	return order, rec, nil
+}
+
+// ListUserRecharges 用户充值记录（分页）
+func (s *RechargeService) ListUserRecharges(userID uint, page, limit int) ([]model.RechargeRecord, int64, error) {
+	q := s.db.Model(&model.RechargeRecord{}).Where("user_id = ?", userID)
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []model.RechargeRecord
+	if err := q.Order("id DESC").Limit(limit).Offset((page - 1) * limit).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// rechargeClearing 充值本金的对方科目：支付成功的渠道清算科目
+func rechargeClearing(tx *gorm.DB, orderID uint) (string, error) {
+	var pay model.Payment
+	if err := tx.Select("id", "payment_method").Where("order_id = ? AND status = 2", orderID).
+		Order("id DESC").First(&pay).Error; err != nil {
+		return "", fmt.Errorf("充值支付记录不存在: %w", err)
+	}
+	return payMethodClearing(pay.PaymentMethod), nil
+}
+
+// creditRechargeTx 充值订单支付成功：在支付事务内入账本金与赠送金额（分别记流水）并发放赠送优惠券，订单直接完成
+func creditRechargeTx(tx *gorm.DB, order *model.Order, now time.Time) error {
+	var rec model.RechargeRecord
+	if err := tx.Where("order_id = ?", order.ID).First(&rec).Error; err != nil {
+		return fmt.Errorf("充值记录不存在: %w", err)
+	}
+	// 条件更新保证重复回调只入账一次
+	res := tx.Model(&model.RechargeRecord{}).Where("id = ? AND status = ?", rec.ID, model.RechargeStatusPending).
+		Updates(map[string]any{"status": model.RechargeStatusCredited, "credited_at": now})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+	clearing, err := rechargeClearing(tx, order.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := walletChange(tx, order.UserID, rec.AmountCents, WalletTxRecharge, fmt.Sprintf("充值订单%s", order.OrderNo), clearing); err != nil {
+		return err
+	}
+	if rec.BonusCents > 0 {
+		if _, err := walletChange(tx, order.UserID, rec.BonusCents, WalletTxRechargeBonus, fmt.Sprintf("充值订单%s赠送", order.OrderNo),
+			ledger.PlatformExpense); err != nil {
+			return err
+		}
+	}
+
+	var templateIDs []uint
+	_ = json.Unmarshal([]byte(rec.BonusCouponIDs), &templateIDs)
+	granted := make([]uint, 0, len(templateIDs))
+	for _, cid := range templateIDs {
+		var c model.Coupon
+		if err := tx.Select("id", "status").First(&c, cid).Error; err != nil || c.Status != 1 {
+			// 券模板已删除或停用不影响充值到账
+			zap.L().Warn("recharge bonus coupon skipped", zap.Uint("order_id", order.ID), zap.Uint("coupon_id", cid))
+			continue
+		}
+		uc := model.UserCoupon{UserID: order.UserID, CouponID: c.ID, Status: 1}
+		if err := tx.Create(&uc).Error; err != nil {
+			return err
+		}
+		granted = append(granted, uc.ID)
+	}
+	if len(granted) > 0 {
+		b, _ := json.Marshal(granted)
+		if err := tx.Model(&model.RechargeRecord{}).Where("id = ?", rec.ID).Update("granted_coupon_ids", string(b)).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&model.Order{}).Where("id = ?", order.ID).
+		Updates(map[string]any{"status": 4, "completed_at": now}).Error
+}
+
+// clawbackRechargeTx 充值订单退款：在发起退款的事务内扣回本金与赠送金额并作废未使用的赠送券
+// 余额不足（已消费）时拒绝退款，避免赠送金额被套现。
+func clawbackRechargeTx(tx *gorm.DB, order *model.Order, now time.Time) error {
+	var rec model.RechargeRecord
+	if err := tx.Where("order_id = ?", order.ID).First(&rec).Error; err != nil {
+		return fmt.Errorf("充值记录不存在: %w", err)
+	}
+	if rec.Status != model.RechargeStatusCredited {
+		return errors.New("充值未到账，无法退款")
+	}
+	var balance int64
+	if err := tx.Table("wallets").Select("balance").Where("user_id = ?", order.UserID).Scan(&balance).Error; err != nil {
+		return err
+	}
+	if balance < rec.AmountCents+rec.BonusCents {
+		return errors.New("钱包余额不足以扣回充值本金及赠送金额，无法退款")
+	}
+	clearing, err := rechargeClearing(tx, order.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := walletChange(tx, order.UserID, -rec.AmountCents, WalletTxRechargeRefund, fmt.Sprintf("充值订单%s退款", order.OrderNo), clearing); err != nil {
+		return err
+	}
+	if rec.BonusCents > 0 {
+		if _, err := walletChange(tx, order.UserID, -rec.BonusCents, WalletTxRechargeBonusClawback, fmt.Sprintf("充值订单%s退款扣回赠送", order.OrderNo),
+			ledger.PlatformExpense); err != nil {
+			return err
+		}
+	}
+	var granted []uint
+	_ = json.Unmarshal([]byte(rec.GrantedCouponIDs), &granted)
+	if len(granted) > 0 {
+		if err := tx.Model(&model.UserCoupon{}).Where("id IN ? AND status = 1", granted).Update("status", 3).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&model.RechargeRecord{}).Where("id = ?", rec.ID).
+		Updates(map[string]any{"status": model.RechargeStatusRefunded, "refunded_at": now}).Error
+}
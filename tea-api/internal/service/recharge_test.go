@@ -0,0 +1,116 @@
+package service
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+func setupRechargeDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:recharge?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.SystemConfig{}, &model.PointsTransaction{}, &model.Order{}, &model.OrderItem{}, &model.Payment{}, &model.Refund{},
+		&model.StockReservation{}, &model.StoreSlotTemplate{}, &model.Wallet{}, &model.WalletTransaction{},
+		&model.RechargeRecord{}, &model.Coupon{}, &model.UserCoupon{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func TestRecharge_CreditAndRefundClawback(t *testing.T) {
+	db := setupRechargeDB(t)
+	now := time.Now()
+	coupon := model.Coupon{Name: "充值赠券", Type: 1, Amount: decimal.NewFromInt(5), TotalCount: 100, Status: 1,
+		StartTime: now.Add(-time.Hour), EndTime: now.Add(24 * time.Hour)}
+	db.Create(&coupon)
+	db.Create(&model.SystemConfig{ConfigKey: "recharge.enabled", ConfigValue: "1", Status: 1})
+	db.Create(&model.SystemConfig{ConfigKey: "recharge.packages", ConfigType: "json", Status: 1,
+		ConfigValue: `[{"amount_cents":10000,"bonus_tea_coin_cents":1500,"bonus_coupon_template_ids":[` + strconv.Itoa(int(coupon.ID)) + `],"limit_per_user":1,"status":1},{"amount_cents":20000,"status":2}]`})
+
+	rs := &RechargeService{db: db}
+	if _, _, err := rs.CreateRechargeOrder(7, 20000); err == nil {
+		t.Fatalf("disabled tier should not be purchasable")
+	}
+	order, rec, err := rs.CreateRechargeOrder(7, 10000)
+	if err != nil {
+		t.Fatalf("create recharge order: %v", err)
+	}
+	if order.OrderType != OrderTypeRecharge || !order.PayAmount.Equal(decimal.NewFromInt(100)) || rec.BonusCents != 1500 {
+		t.Fatalf("unexpected recharge order: %+v %+v", order, rec)
+	}
+
+	ps := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return &MockPaymentProvider{}, nil }}
+	pay, _, err := ps.CreateIntent(7, order.ID, PayMethodWechat)
+	if err != nil {
+		t.Fatalf("create intent: %v", err)
+	}
+	cb := PaymentCallbackPayload{PaymentNo: pay.PaymentNo, TransactionID: "4200000001", TradeState: "SUCCESS", SkipVerify: true}
+	if err := ps.HandleCallback(cb); err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	// 重复回调不重复入账
+	if err := ps.HandleCallback(cb); err != nil {
+		t.Fatalf("duplicate callback: %v", err)
+	}
+	if b := walletBalance(t, db, 7); b != 11500 {
+		t.Fatalf("wallet should hold principal + bonus, got %d", b)
+	}
+	var txTypes []string
+	db.Model(&model.WalletTransaction{}).Where("user_id = ?", 7).Order("id").Pluck("type", &txTypes)
+	if len(txTypes) != 2 || txTypes[0] != WalletTxRecharge || txTypes[1] != WalletTxRechargeBonus {
+		t.Fatalf("principal and bonus should be separate entries, got %v", txTypes)
+	}
+	var uc model.UserCoupon
+	if err := db.Where("user_id = ? AND coupon_id = ?", 7, coupon.ID).First(&uc).Error; err != nil || uc.Status != 1 {
+		t.Fatalf("bonus coupon should be granted: %v", err)
+	}
+	db.First(order, order.ID)
+	if order.Status != 4 || order.PayStatus != 2 {
+		t.Fatalf("recharge order should be completed, got %d/%d", order.Status, order.PayStatus)
+	}
+
+	// 档位限充一次
+	if _, _, err := rs.CreateRechargeOrder(7, 10000); err == nil {
+		t.Fatalf("tier limit per user should be enforced")
+	}
+
+	if err := ps.StartOrderRefund(order.ID, "用户申请退款"); err != nil {
+		t.Fatalf("refund recharge: %v", err)
+	}
+	db.First(order, order.ID)
+	db.First(rec, rec.ID)
+	db.First(&uc, uc.ID)
+	if walletBalance(t, db, 7) != 0 || rec.Status != model.RechargeStatusRefunded || uc.Status != 3 || order.PayStatus != 4 {
+		t.Fatalf("refund should claw back principal, bonus and coupon: balance=%d rec=%d coupon=%d pay_status=%d",
+			walletBalance(t, db, 7), rec.Status, uc.Status, order.PayStatus)
+	}
+}
+
+func TestRecharge_RefundRejectedWhenBonusSpent(t *testing.T) {
+	db := setupRechargeDB(t)
+	db.Create(&model.Wallet{UserID: 8, Balance: 3000})
+	order := model.Order{OrderNo: "RCH8", UserID: 8, Status: 4, PayStatus: 2, OrderType: OrderTypeRecharge,
+		TotalAmount: decimal.NewFromInt(50), PayAmount: decimal.NewFromInt(50)}
+	db.Create(&order)
+	db.Create(&model.RechargeRecord{OrderID: order.ID, UserID: 8, AmountCents: 5000, BonusCents: 500, Status: model.RechargeStatusCredited})
+	db.Create(&model.Payment{OrderID: order.ID, PaymentNo: "PRCH8", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(50), Status: 2})
+
+	ps := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return &MockPaymentProvider{}, nil }}
+	if err := ps.StartOrderRefund(order.ID, "用户申请退款"); err == nil {
+		t.Fatalf("refund should be rejected when balance cannot cover principal + bonus")
+	}
+	db.First(&order, order.ID)
+	if order.PayStatus != 2 || walletBalance(t, db, 8) != 3000 {
+		t.Fatalf("rejected refund must not change order or wallet, pay_status=%d balance=%d", order.PayStatus, walletBalance(t, db, 8))
+	}
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+	"tea-api/pkg/database"
+)
+
+var (
+	ErrReferralSelf         = errors.New("不能绑定自己为推荐人")
+	ErrReferralCycle        = errors.New("不能绑定自己的下级为推荐人")
+	ErrReferralAlreadyBound = errors.New("已绑定推荐人，不可更换")
+	ErrReferralWindowClosed = errors.New("已超过推荐人绑定期限")
+	ErrReferrerNotFound     = errors.New("推荐人不存在")
+)
+
+// ReferralService 推荐关系维护：闭包表（referral_closures）保存每一对祖先-后代及层级，
+// 每个用户含一条 depth=0 的自身记录，depth=1 为直接推荐人
+type ReferralService struct {
+	db *gorm.DB
+}
+
+func NewReferralService() *ReferralService {
+	return &ReferralService{db: database.GetDB()}
+}
+
+// Bind 用户绑定推荐人：首次绑定生效（重复绑定同一推荐人视为成功），须在注册后的绑定期限内，
+// 且推荐人不能是自己的下级。绑定时为推荐人的每个祖先与用户子树的每个成员写入闭包记录
+func (s *ReferralService) Bind(userID, referrerID uint, now time.Time) error {
+	if referrerID == 0 || referrerID == userID {
+		return ErrReferralSelf
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// 与 MoveSubtree 一致按 id 顺序同时锁定双方，避免 A→B 与 B→A 并发绑定各自通过环检查
+		var users []model.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "created_at").
+			Where("id IN ?", []uint{userID, referrerID}).Order("id").Find(&users).Error; err != nil {
+			return err
+		}
+		var user model.User
+		found := false
+		for _, u := range users {
+			if u.ID == userID {
+				user, found = u, true
+			}
+		}
+		if !found {
+			return gorm.ErrRecordNotFound
+		}
+		parent, err := referralParent(tx, userID)
+		if err != nil {
+			return err
+		}
+		if parent == referrerID {
+			return nil
+		}
+		if parent != 0 {
+			return ErrReferralAlreadyBound
+		}
+		if h := config.Config.Finance.Referral.BindWindowHours; h > 0 && now.Sub(user.CreatedAt) > time.Duration(h)*time.Hour {
+			return ErrReferralWindowClosed
+		}
+		if len(users) != 2 {
+			return ErrReferrerNotFound
+		}
+		if err := ensureReferralSelf(tx, userID, referrerID); err != nil {
+			return err
+		}
+		subtree, err := referralSubtree(tx, userID)
+		if err != nil {
+			return err
+		}
+		if _, ok := subtree[referrerID]; ok {
+			return ErrReferralCycle
+		}
+		return linkReferralSubtree(tx, referrerID, subtree, now)
+	})
+}
+
+// MoveSubtree 管理员将用户连同其全部下级迁移到新推荐人下（newParentID 为 0 时解除推荐关系），
+// 重建子树与外部祖先之间的闭包记录并写入审计日志
+func (s *ReferralService) MoveSubtree(userID, newParentID, operatorID uint, reason string) (*model.ReferralMoveLog, error) {
+	if userID == newParentID {
+		return nil, ErrReferralSelf
+	}
+	var log model.ReferralMoveLog
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		ids := []uint{userID}
+		if newParentID != 0 {
+			ids = append(ids, newParentID)
+		}
+		var users []model.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id IN ?", ids).Order("id").Find(&users).Error; err != nil {
+			return err
+		}
+		if len(users) != len(ids) {
+			return errors.New("用户不存在")
+		}
+		oldParent, err := referralParent(tx, userID)
+		if err != nil {
+			return err
+		}
+		if oldParent == newParentID {
+			return errors.New("新推荐人与当前推荐人相同")
+		}
+		if err := ensureReferralSelf(tx, ids...); err != nil {
+			return err
+		}
+		subtree, err := referralSubtree(tx, userID)
+		if err != nil {
+			return err
+		}
+		if _, ok := subtree[newParentID]; ok {
+			return ErrReferralCycle
+		}
+		members := make([]uint, 0, len(subtree))
+		for id := range subtree {
+			members = append(members, id)
+		}
+		// 断开子树与原祖先的关系，子树内部的闭包记录保持不变
+		if err := tx.Where("descendant_user_id IN ? AND ancestor_user_id NOT IN ?", members, members).
+			Delete(&model.ReferralClosure{}).Error; err != nil {
+			return err
+		}
+		if newParentID != 0 {
+			if err := linkReferralSubtree(tx, newParentID, subtree, time.Now()); err != nil {
+				return err
+			}
+		}
+		log = model.ReferralMoveLog{UserID: userID, OldParentID: oldParent, NewParentID: newParentID,
+			SubtreeSize: len(members), OperatorID: operatorID, Reason: truncateText(reason, 255)}
+		return tx.Create(&log).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &log, nil
+}
+
+// ReferralRebuildReport 闭包表重建结果
+type ReferralRebuildReport struct {
+	Users            int    `json:"users"`             // 参与推荐关系的用户数
+	Edges            int    `json:"edges"`             // 保留的直接推荐关系数
+	DuplicateParents []uint `json:"duplicate_parents"` // 存在多个直接推荐人、已按规则择一的用户
+	CycleDropped     []uint `json:"cycle_dropped"`     // 推荐关系成环、已解除直接推荐人的用户
+	Rows             int    `json:"rows"`              // 重建后的闭包记录数（含 depth=0）
+}
+
+// RebuildClosure 由 depth=1 的直接推荐关系一次性重建闭包表，用于迁移仅有直接关系的历史数据。
+// 同一用户存在多个直接推荐人时保留绑定时间最早的一条（无绑定时间的排在最后），时间相同取推荐人 id 最小者；
+// 按用户 id 顺序挂接，会形成环的关系被丢弃。祖先关系的绑定时间取路径上最晚的一次绑定。
+// 可重复执行；dryRun 为 true 时只统计，不落库。
+func (s *ReferralService) RebuildClosure(dryRun bool) (*ReferralRebuildReport, error) {
+	rep := &ReferralRebuildReport{DuplicateParents: []uint{}, CycleDropped: []uint{}}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var edges []model.ReferralClosure
+		if err := tx.Where("depth = 1 AND ancestor_user_id <> descendant_user_id").
+			Order("descendant_user_id, bound_at IS NULL, bound_at, ancestor_user_id").Find(&edges).Error; err != nil {
+			return err
+		}
+		parent := map[uint]model.ReferralClosure{}
+		users := map[uint]bool{}
+		var children []uint
+		for _, e := range edges {
+			users[e.AncestorUserID], users[e.DescendantUserID] = true, true
+			if _, ok := parent[e.DescendantUserID]; ok {
+				if n := len(rep.DuplicateParents); n == 0 || rep.DuplicateParents[n-1] != e.DescendantUserID {
+					rep.DuplicateParents = append(rep.DuplicateParents, e.DescendantUserID)
+				}
+				continue
+			}
+			parent[e.DescendantUserID] = e
+			children = append(children, e.DescendantUserID)
+		}
+		// 按用户 id 顺序接受推荐关系，沿已接受的关系上溯遇到自身即成环
+		accepted := map[uint]model.ReferralClosure{}
+		for _, child := range children {
+			e := parent[child]
+			cycle := false
+			for p := e.AncestorUserID; ; {
+				if p == child {
+					cycle = true
+					break
+				}
+				up, ok := accepted[p]
+				if !ok {
+					break
+				}
+				p = up.AncestorUserID
+			}
+			if cycle {
+				rep.CycleDropped = append(rep.CycleDropped, child)
+				continue
+			}
+			accepted[child] = e
+		}
+
+		ids := make([]uint, 0, len(users))
+		for id := range users {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		rows := make([]model.ReferralClosure, 0, len(ids)*2)
+		for _, id := range ids {
+			rows = append(rows, model.ReferralClosure{AncestorUserID: id, DescendantUserID: id, Depth: 0})
+			var boundAt *time.Time
+			for cur, depth := id, 1; ; depth++ {
+				e, ok := accepted[cur]
+				if !ok {
+					break
+				}
+				if e.BoundAt != nil && (boundAt == nil || e.BoundAt.After(*boundAt)) {
+					boundAt = e.BoundAt
+				}
+				rows = append(rows, model.ReferralClosure{AncestorUserID: e.AncestorUserID, DescendantUserID: id, Depth: depth, BoundAt: boundAt})
+				cur = e.AncestorUserID
+			}
+		}
+		rep.Users, rep.Edges, rep.Rows = len(ids), len(accepted), len(rows)
+		if dryRun {
+			return nil
+		}
+		if err := tx.Where("1 = 1").Delete(&model.ReferralClosure{}).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(rows, 200).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// Ancestors 用户的推荐链（由近及远，不含自身）
+func (s *ReferralService) Ancestors(userID uint) ([]model.ReferralClosure, error) {
+	var list []model.ReferralClosure
+	err := s.db.Where("descendant_user_id = ? AND depth > 0", userID).Order("depth ASC").Find(&list).Error
+	return list, err
+}
+
+// ListMoveLogs 分页查询迁移审计日志，userID 非 0 时仅查询该用户作为根的迁移
+func (s *ReferralService) ListMoveLogs(userID uint, page, size int) ([]model.ReferralMoveLog, int64, error) {
+	q := s.db.Model(&model.ReferralMoveLog{})
+	if userID != 0 {
+		q = q.Where("user_id = ?", userID)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []model.ReferralMoveLog
+	err := q.Order("id DESC").Limit(size).Offset((page - 1) * size).Find(&list).Error
+	return list, total, err
+}
+
+// referralParent 用户的直接推荐人，未绑定时为 0
+func referralParent(tx *gorm.DB, userID uint) (uint, error) {
+	var rc model.ReferralClosure
+	err := tx.Where("descendant_user_id = ? AND depth = 1", userID).First(&rc).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return rc.AncestorUserID, err
+}
+
+// ensureReferralSelf 补齐用户的 depth=0 自身记录
+func ensureReferralSelf(tx *gorm.DB, userIDs ...uint) error {
+	for _, id := range userIDs {
+		rc := model.ReferralClosure{AncestorUserID: id, DescendantUserID: id, Depth: 0}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rc).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// referralSubtree 用户子树（含自身）成员到其相对深度的映射
+func referralSubtree(tx *gorm.DB, userID uint) (map[uint]int, error) {
+	var rows []model.ReferralClosure
+	if err := tx.Where("ancestor_user_id = ?", userID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := map[uint]int{userID: 0}
+	for _, r := range rows {
+		out[r.DescendantUserID] = r.Depth
+	}
+	return out, nil
+}
+
+// linkReferralSubtree 将子树挂到 parentID 下：parentID 的每个祖先（含自身）与子树每个成员写入闭包记录，
+// 层级 = 祖先到 parentID 的层级 + 1 + 成员在子树内的层级
+func linkReferralSubtree(tx *gorm.DB, parentID uint, subtree map[uint]int, now time.Time) error {
+	var ancestors []model.ReferralClosure
+	if err := tx.Where("descendant_user_id = ?", parentID).Find(&ancestors).Error; err != nil {
+		return err
+	}
+	rows := make([]model.ReferralClosure, 0, len(ancestors)*len(subtree))
+	for _, a := range ancestors {
+		for member, d := range subtree {
+			rows = append(rows, model.ReferralClosure{AncestorUserID: a.AncestorUserID, DescendantUserID: member, Depth: a.Depth + 1 + d, BoundAt: &now})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(rows, 200).Error
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service/commission"
+)
+
+// 团队成员分页上限；按成员用户ID游标翻页，避免大团队深分页
+const (
+	teamPageDefault = 20
+	teamPageMax     = 100
+)
+
+// TeamDepthCount 某一层级的成员数
+type TeamDepthCount struct {
+	Depth int   `json:"depth"`
+	Count int64 `json:"count"`
+}
+
+// TeamSummary 团队概览：成员数（直推/间推/按层级）、团队已完成订单 GMV 与本人从团队获得的佣金
+type TeamSummary struct {
+	UserID     uint             `json:"user_id"`
+	Total      int64            `json:"total"`
+	Direct     int64            `json:"direct"`
+	Indirect   int64            `json:"indirect"`
+	ByDepth    []TeamDepthCount `json:"by_depth"`
+	OrderCount int64            `json:"order_count"`
+	GMV        decimal.Decimal  `json:"gmv"`
+	Commission decimal.Decimal  `json:"commission"`
+}
+
+// TeamMember 团队成员及其贡献：GMV 为成员已完成订单实付合计，Commission 为本人因该成员获得的佣金（不含已回滚）
+type TeamMember struct {
+	UserID       uint            `json:"user_id"`
+	Depth        int             `json:"depth"`
+	Nickname     string          `json:"nickname"`
+	Avatar       string          `json:"avatar"`
+	Phone        string          `json:"phone"`
+	BoundAt      *time.Time      `json:"bound_at"`
+	RegisteredAt time.Time       `json:"registered_at"`
+	TeamSize     int64           `json:"team_size"` // 该成员自己的下级人数
+	OrderCount   int64           `json:"order_count"`
+	GMV          decimal.Decimal `json:"gmv"`
+	Commission   decimal.Decimal `json:"commission"`
+}
+
+// TeamMemberPage 游标分页结果，NextAfterID 作为下一页的 after_id
+type TeamMemberPage struct {
+	List        []TeamMember `json:"list"`
+	NextAfterID uint         `json:"next_after_id"`
+	HasMore     bool         `json:"has_more"`
+}
+
+// TeamGrowthPoint 团队规模时间序列中的一个周期
+type TeamGrowthPoint struct {
+	Period       string `json:"period"`
+	NewMembers   int64  `json:"new_members"`
+	TotalMembers int64  `json:"total_members"`
+}
+
+// teamOrderTypesExcluded 不计入团队 GMV 的订单类型（充值与积分兑换），GMV 仅统计已完成订单
+func teamOrderTypesExcluded() []int { return []int{OrderTypeRecharge, OrderTypePointsMall} }
+
+// TeamSummary 团队概览
+func (s *ReferralService) TeamSummary(userID uint) (*TeamSummary, error) {
+	out := &TeamSummary{UserID: userID, ByDepth: []TeamDepthCount{}}
+	if err := s.db.Model(&model.ReferralClosure{}).Select("depth, COUNT(*) AS count").
+		Where("ancestor_user_id = ? AND depth > 0", userID).Group("depth").Order("depth ASC").
+		Scan(&out.ByDepth).Error; err != nil {
+		return nil, err
+	}
+	for _, d := range out.ByDepth {
+		out.Total += d.Count
+		if d.Depth == 1 {
+			out.Direct = d.Count
+		} else {
+			out.Indirect += d.Count
+		}
+	}
+
+	var gmv struct {
+		Cnt int64
+		Sum decimal.Decimal
+	}
+	if err := s.db.Table("orders AS o").
+		Select("COUNT(*) AS cnt, COALESCE(SUM(o.pay_amount), 0) AS sum").
+		Joins("JOIN referral_closures rc ON rc.descendant_user_id = o.user_id").
+		Where("rc.ancestor_user_id = ? AND rc.depth > 0 AND o.status = 4 AND o.order_type NOT IN ? AND o.deleted_at IS NULL",
+			userID, teamOrderTypesExcluded()).
+		Scan(&gmv).Error; err != nil {
+		return nil, err
+	}
+	out.OrderCount, out.GMV = gmv.Cnt, gmv.Sum
+
+	if err := s.db.Model(&model.Commission{}).Select("COALESCE(SUM(net_amount), 0)").
+		Where("user_id = ? AND source_user_id IS NOT NULL AND status <> ?", userID, commission.StatusReversed).
+		Scan(&out.Commission).Error; err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TeamMembers 团队成员列表：depth 为 0 时返回全部层级，否则仅返回该层级；按成员用户ID升序以 afterID 为游标翻页，
+// 走 referral_closures 主键（ancestor_user_id, descendant_user_id）范围扫描，页内再聚合订单与佣金
+func (s *ReferralService) TeamMembers(userID uint, depth int, afterID uint, limit int) (*TeamMemberPage, error) {
+	if limit <= 0 {
+		limit = teamPageDefault
+	}
+	if limit > teamPageMax {
+		limit = teamPageMax
+	}
+	q := s.db.Table("referral_closures AS rc").
+		Select("rc.descendant_user_id AS user_id, rc.depth, rc.bound_at, u.nickname, u.avatar, u.phone, u.created_at AS registered_at").
+		Joins("JOIN users u ON u.id = rc.descendant_user_id AND u.deleted_at IS NULL").
+		Where("rc.ancestor_user_id = ? AND rc.depth > 0 AND rc.descendant_user_id > ?", userID, afterID)
+	if depth > 0 {
+		q = q.Where("rc.depth = ?", depth)
+	}
+	var list []TeamMember
+	if err := q.Order("rc.descendant_user_id ASC").Limit(limit + 1).Scan(&list).Error; err != nil {
+		return nil, err
+	}
+	page := &TeamMemberPage{List: []TeamMember{}}
+	if len(list) > limit {
+		list, page.HasMore = list[:limit], true
+	}
+	if len(list) == 0 {
+		return page, nil
+	}
+	ids := make([]uint, len(list))
+	for i := range list {
+		ids[i] = list[i].UserID
+		list[i].Phone = maskTeamPhone(list[i].Phone)
+	}
+
+	var sizes []struct {
+		UserID uint
+		Cnt    int64
+	}
+	if err := s.db.Model(&model.ReferralClosure{}).Select("ancestor_user_id AS user_id, COUNT(*) AS cnt").
+		Where("ancestor_user_id IN ? AND depth > 0", ids).Group("ancestor_user_id").Scan(&sizes).Error; err != nil {
+		return nil, err
+	}
+	var orders []struct {
+		UserID uint
+		Cnt    int64
+		Sum    decimal.Decimal
+	}
+	if err := s.db.Model(&model.Order{}).Select("user_id, COUNT(*) AS cnt, COALESCE(SUM(pay_amount), 0) AS sum").
+		Where("user_id IN ? AND status = 4 AND order_type NOT IN ?", ids, teamOrderTypesExcluded()).
+		Group("user_id").Scan(&orders).Error; err != nil {
+		return nil, err
+	}
+	var comms []struct {
+		SourceUserID uint
+		Sum          decimal.Decimal
+	}
+	if err := s.db.Model(&model.Commission{}).Select("source_user_id, COALESCE(SUM(net_amount), 0) AS sum").
+		Where("user_id = ? AND source_user_id IN ? AND status <> ?", userID, ids, commission.StatusReversed).
+		Group("source_user_id").Scan(&comms).Error; err != nil {
+		return nil, err
+	}
+
+	idx := make(map[uint]*TeamMember, len(list))
+	for i := range list {
+		idx[list[i].UserID] = &list[i]
+	}
+	for _, r := range sizes {
+		idx[r.UserID].TeamSize = r.Cnt
+	}
+	for _, r := range orders {
+		idx[r.UserID].OrderCount, idx[r.UserID].GMV = r.Cnt, r.Sum
+	}
+	for _, r := range comms {
+		idx[r.SourceUserID].Commission = r.Sum
+	}
+	page.List = list
+	page.NextAfterID = list[len(list)-1].UserID
+	return page, nil
+}
+
+// TeamGrowth 团队规模随时间变化：granularity 为 day / month，区间 [from, to)，
+// 成员加入时间取闭包记录的建立时间（历史数据为空时取注册时间）
+func (s *ReferralService) TeamGrowth(userID uint, granularity string, from, to time.Time) ([]TeamGrowthPoint, error) {
+	layout, step := "2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	if granularity == "month" {
+		layout, step = "2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+		from = time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location())
+	} else {
+		from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	}
+	if !to.After(from) {
+		return nil, errors.New("结束时间须晚于开始时间")
+	}
+	var periods []time.Time
+	for t := from; t.Before(to); t = step(t) {
+		periods = append(periods, t)
+		if len(periods) > 366 {
+			return nil, errors.New("统计区间过长")
+		}
+	}
+
+	base := s.db.Table("referral_closures AS rc").
+		Joins("JOIN users u ON u.id = rc.descendant_user_id AND u.deleted_at IS NULL").
+		Where("rc.ancestor_user_id = ? AND rc.depth > 0", userID)
+	var total int64
+	if err := base.Session(&gorm.Session{}).Where("(rc.bound_at < ? OR (rc.bound_at IS NULL AND u.created_at < ?))", from, from).
+		Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var joined []struct {
+		BoundAt   *time.Time
+		CreatedAt time.Time
+	}
+	if err := base.Session(&gorm.Session{}).Select("rc.bound_at, u.created_at").
+		Where("((rc.bound_at >= ? AND rc.bound_at < ?) OR (rc.bound_at IS NULL AND u.created_at >= ? AND u.created_at < ?))", from, to, from, to).
+		Scan(&joined).Error; err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{}
+	for _, j := range joined {
+		at := j.CreatedAt
+		if j.BoundAt != nil {
+			at = *j.BoundAt
+		}
+		counts[at.In(from.Location()).Format(layout)]++
+	}
+	out := make([]TeamGrowthPoint, 0, len(periods))
+	for _, p := range periods {
+		key := p.Format(layout)
+		total += counts[key]
+		out = append(out, TeamGrowthPoint{Period: key, NewMembers: counts[key], TotalMembers: total})
+	}
+	return out, nil
+}
+
+// maskTeamPhone 团队成员手机号脱敏，仅保留前 3 位与后 4 位
+func maskTeamPhone(p string) string {
+	if len(p) < 7 {
+		return p
+	}
+	return p[:3] + "****" + p[len(p)-4:]
+}
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+)
+
+func setupReferralDB(t *testing.T, name string, users int) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.ReferralClosure{}, &model.ReferralMoveLog{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	for i := 1; i <= users; i++ {
+		db.Create(&model.User{BaseModel: model.BaseModel{ID: uint(i)}, OpenID: fmt.Sprintf("%s-%d", name, i), Phone: fmt.Sprintf("1370000%04d", i)})
+	}
+	return db
+}
+
+// closureOf 以 "祖先>后代" 为键的层级映射（不含 depth=0 自身记录）
+func closureOf(db *gorm.DB) map[string]int {
+	var rows []model.ReferralClosure
+	db.Where("depth > 0").Find(&rows)
+	out := map[string]int{}
+	for _, r := range rows {
+		out[fmt.Sprintf("%d>%d", r.AncestorUserID, r.DescendantUserID)] = r.Depth
+	}
+	return out
+}
+
+func TestReferral_BindBuildsClosureAndRejectsCycles(t *testing.T) {
+	old := config.Config.Finance.Referral
+	config.Config.Finance.Referral = config.Referral{BindWindowHours: 72}
+	defer func() { config.Config.Finance.Referral = old }()
+
+	db := setupReferralDB(t, "referral_bind", 4)
+	svc := &ReferralService{db: db}
+	now := time.Now()
+
+	// 先绑定下级 3→2，再绑定 2→1：3 应获得祖先 1（depth 2）
+	if err := svc.Bind(3, 2, now); err != nil {
+		t.Fatalf("bind 3->2: %v", err)
+	}
+	if err := svc.Bind(2, 1, now); err != nil {
+		t.Fatalf("bind 2->1: %v", err)
+	}
+	got := closureOf(db)
+	want := map[string]int{"2>3": 1, "1>2": 1, "1>3": 2}
+	if len(got) != len(want) {
+		t.Fatalf("closure = %v, want %v", got, want)
+	}
+	for k, d := range want {
+		if got[k] != d {
+			t.Fatalf("closure = %v, want %v", got, want)
+		}
+	}
+
+	if err := svc.Bind(1, 3, now); err != ErrReferralCycle {
+		t.Fatalf("binding to a descendant should be rejected, got %v", err)
+	}
+	if err := svc.Bind(3, 2, now); err != nil {
+		t.Fatalf("rebinding the same referrer should be a no-op, got %v", err)
+	}
+	if err := svc.Bind(3, 4, now); err != ErrReferralAlreadyBound {
+		t.Fatalf("first binding should win, got %v", err)
+	}
+	if err := svc.Bind(4, 1, now.Add(73*time.Hour)); err != ErrReferralWindowClosed {
+		t.Fatalf("binding after the window should be rejected, got %v", err)
+	}
+}
+
+func TestReferral_MoveSubtreeRebuildsClosure(t *testing.T) {
+	old := config.Config.Finance.Referral
+	config.Config.Finance.Referral = config.Referral{}
+	defer func() { config.Config.Finance.Referral = old }()
+
+	db := setupReferralDB(t, "referral_move", 5)
+	svc := &ReferralService{db: db}
+	now := time.Now()
+	// 1 → 2 → 3 → 4，5 独立
+	for _, p := range [][2]uint{{2, 1}, {3, 2}, {4, 3}} {
+		if err := svc.Bind(p[0], p[1], now); err != nil {
+			t.Fatalf("bind %v: %v", p, err)
+		}
+	}
+
+	if _, err := svc.MoveSubtree(2, 4, 9, "loop"); err != ErrReferralCycle {
+		t.Fatalf("moving under own descendant should be rejected, got %v", err)
+	}
+	log, err := svc.MoveSubtree(3, 5, 9, "客服核实后更正推荐人")
+	if err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if log.OldParentID != 2 || log.NewParentID != 5 || log.SubtreeSize != 2 || log.OperatorID != 9 {
+		t.Fatalf("unexpected audit log: %+v", log)
+	}
+	got := closureOf(db)
+	want := map[string]int{"1>2": 1, "5>3": 1, "5>4": 2, "3>4": 1}
+	if len(got) != len(want) {
+		t.Fatalf("closure = %v, want %v", got, want)
+	}
+	for k, d := range want {
+		if got[k] != d {
+			t.Fatalf("closure = %v, want %v", got, want)
+		}
+	}
+
+	// 解除推荐关系：仅保留子树内部记录
+	if _, err := svc.MoveSubtree(3, 0, 9, "解除"); err != nil {
+		t.Fatalf("detach: %v", err)
+	}
+	if got := closureOf(db); len(got) != 2 || got["3>4"] != 1 || got["1>2"] != 1 {
+		t.Fatalf("detached closure = %v", got)
+	}
+	if logs, total, _ := svc.ListMoveLogs(3, 1, 10); total != 2 || len(logs) != 2 {
+		t.Fatalf("expected 2 move logs, got %d", total)
+	}
+}
+
+func TestReferral_ConcurrentMutualBindKeepsTreeAcyclic(t *testing.T) {
+	old := config.Config.Finance.Referral
+	config.Config.Finance.Referral = config.Referral{}
+	defer func() { config.Config.Finance.Referral = old }()
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "referral.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.ReferralClosure{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	db.Create(&model.User{BaseModel: model.BaseModel{ID: 1}, OpenID: "mutual-1", Phone: "13700000001"})
+	db.Create(&model.User{BaseModel: model.BaseModel{ID: 2}, OpenID: "mutual-2", Phone: "13700000002"})
+	svc := &ReferralService{db: db}
+
+	// 1→2 与 2→1 并发绑定：只有一方成功，另一方因成环被拒绝
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, p := range [][2]uint{{1, 2}, {2, 1}} {
+		wg.Add(1)
+		go func(i int, user, referrer uint) {
+			defer wg.Done()
+			errs[i] = svc.Bind(user, referrer, time.Now())
+		}(i, p[0], p[1])
+	}
+	wg.Wait()
+	if (errs[0] == nil) == (errs[1] == nil) || (errs[0] != nil && errs[0] != ErrReferralCycle) || (errs[1] != nil && errs[1] != ErrReferralCycle) {
+		t.Fatalf("exactly one mutual bind should succeed, got %v / %v", errs[0], errs[1])
+	}
+	if got := closureOf(db); len(got) != 1 {
+		t.Fatalf("closure should hold a single edge, got %v", got)
+	}
+}
+
+func TestReferral_RebuildClosureFromDirectEdges(t *testing.T) {
+	db := setupReferralDB(t, "referral_rebuild", 6)
+	svc := &ReferralService{db: db}
+	early := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	late := early.AddDate(0, 1, 0)
+	// 历史数据仅有直接关系：1 → 2 → 3；4 同时挂在 3（较晚）与 5（较早）下；6 ↔ 5 互为推荐人成环
+	for _, e := range []struct {
+		parent, child uint
+		at            *time.Time
+	}{{1, 2, nil}, {2, 3, &early}, {3, 4, &late}, {5, 4, &early}, {6, 5, nil}, {5, 6, nil}} {
+		db.Create(&model.ReferralClosure{AncestorUserID: e.parent, DescendantUserID: e.child, Depth: 1, BoundAt: e.at})
+	}
+
+	rep, err := svc.RebuildClosure(true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if len(closureOf(db)) != 6 {
+		t.Fatalf("dry run should not write")
+	}
+	if rep, err = svc.RebuildClosure(false); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	if rep.Users != 6 || rep.Edges != 4 || fmt.Sprint(rep.DuplicateParents) != "[4]" || fmt.Sprint(rep.CycleDropped) != "[6]" || rep.Rows != 6+6 {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+	got := closureOf(db)
+	want := map[string]int{"1>2": 1, "1>3": 2, "2>3": 1, "5>4": 1, "6>4": 2, "6>5": 1}
+	if len(got) != len(want) {
+		t.Fatalf("closure = %v, want %v", got, want)
+	}
+	for k, d := range want {
+		if got[k] != d {
+			t.Fatalf("closure = %v, want %v", got, want)
+		}
+	}
+	var rc model.ReferralClosure
+	db.Where("ancestor_user_id = 1 AND descendant_user_id = 3").First(&rc)
+	if rc.BoundAt == nil || !rc.BoundAt.Equal(early) {
+		t.Fatalf("ancestor bound_at should be the latest bind on the path, got %v", rc.BoundAt)
+	}
+	// 重建后可正常绑定，重复执行结果不变
+	if err := svc.Bind(4, 3, time.Now()); err != ErrReferralAlreadyBound {
+		t.Fatalf("rebuilt parent should be kept, got %v", err)
+	}
+	if rep, _ = svc.RebuildClosure(false); rep.Rows != 12 || len(rep.DuplicateParents) != 0 || len(rep.CycleDropped) != 0 {
+		t.Fatalf("rebuild should be repeatable, got %+v", rep)
+	}
+}
+
+func TestReferral_TeamAnalytics(t *testing.T) {
+	old := config.Config.Finance.Referral
+	config.Config.Finance.Referral = config.Referral{}
+	defer func() { config.Config.Finance.Referral = old }()
+
+	db := setupReferralDB(t, "referral_team", 5)
+	if err := db.AutoMigrate(&model.Order{}, &model.Commission{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	svc := &ReferralService{db: db}
+	day1 := time.Date(2026, 10, 1, 10, 0, 0, 0, time.Local)
+	day3 := day1.AddDate(0, 0, 2)
+	// 1 → 2 → 4，1 → 3 → 5
+	for _, b := range []struct {
+		user, parent uint
+		at           time.Time
+	}{{2, 1, day1}, {3, 1, day1}, {4, 2, day3}, {5, 3, day3}} {
+		if err := svc.Bind(b.user, b.parent, b.at); err != nil {
+			t.Fatalf("bind %d->%d: %v", b.user, b.parent, err)
+		}
+	}
+	db.Create(&model.Order{OrderNo: "TEAM1", UserID: 2, TotalAmount: decimal.NewFromInt(100), PayAmount: decimal.NewFromInt(100), Status: 4, OrderType: 1})
+	db.Create(&model.Order{OrderNo: "TEAM2", UserID: 4, TotalAmount: decimal.NewFromInt(50), PayAmount: decimal.NewFromInt(50), Status: 4, OrderType: 1})
+	db.Create(&model.Order{OrderNo: "TEAM3", UserID: 4, TotalAmount: decimal.NewFromInt(80), PayAmount: decimal.NewFromInt(80), Status: 2, OrderType: 1})
+	src2, src4 := uint(2), uint(4)
+	db.Create(&model.Commission{UserID: 1, SourceUserID: &src2, CommissionType: "direct", GrossAmount: decimal.NewFromInt(20), NetAmount: decimal.NewFromInt(20), Status: "frozen"})
+	db.Create(&model.Commission{UserID: 1, SourceUserID: &src4, CommissionType: "indirect", GrossAmount: decimal.NewFromInt(1), NetAmount: decimal.NewFromInt(1), Status: "reversed"})
+
+	sum, err := svc.TeamSummary(1)
+	if err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	if sum.Total != 4 || sum.Direct != 2 || sum.Indirect != 2 || sum.OrderCount != 2 ||
+		!sum.GMV.Equal(decimal.NewFromInt(150)) || !sum.Commission.Equal(decimal.NewFromInt(20)) {
+		t.Fatalf("unexpected summary: %+v", sum)
+	}
+
+	// 游标分页：每页 3 条
+	page, err := svc.TeamMembers(1, 0, 0, 3)
+	if err != nil || len(page.List) != 3 || !page.HasMore || page.NextAfterID != 4 {
+		t.Fatalf("first page = %+v err=%v", page, err)
+	}
+	m2 := page.List[0]
+	if m2.UserID != 2 || m2.Depth != 1 || m2.TeamSize != 1 || !m2.GMV.Equal(decimal.NewFromInt(100)) ||
+		!m2.Commission.Equal(decimal.NewFromInt(20)) || m2.Phone != "137****0002" {
+		t.Fatalf("unexpected member: %+v", m2)
+	}
+	if page, _ = svc.TeamMembers(1, 0, page.NextAfterID, 3); len(page.List) != 1 || page.HasMore || page.List[0].UserID != 5 {
+		t.Fatalf("second page = %+v", page)
+	}
+	if page, _ = svc.TeamMembers(1, 2, 0, 10); len(page.List) != 2 || page.List[0].Depth != 2 {
+		t.Fatalf("depth filter = %+v", page)
+	}
+
+	points, err := svc.TeamGrowth(1, "day", day1.AddDate(0, 0, -1), day3.AddDate(0, 0, 1))
+	if err != nil || len(points) != 5 {
+		t.Fatalf("growth = %+v err=%v", points, err)
+	}
+	if points[1].NewMembers != 2 || points[1].TotalMembers != 2 || points[3].NewMembers != 2 || points[3].TotalMembers != 4 || points[4].TotalMembers != 4 {
+		t.Fatalf("unexpected growth series: %+v", points)
+	}
+}
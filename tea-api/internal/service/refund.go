@@ -0,0 +1,478 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+	"tea-api/internal/service/commission"
+)
+
+// WalletTxOrderRefund 余额支付部分原路退回钱包
+const WalletTxOrderRefund = "order_refund"
+
+// refundRetryDelay 失败退款的重试间隔：1 分钟起指数退避，最长 1 小时
+func refundRetryDelay(retryCount int) time.Duration {
+	if retryCount > 6 {
+		return time.Hour
+	}
+	d := time.Minute << retryCount
+	if d > time.Hour {
+		d = time.Hour
+	}
+	return d
+}
+
+func refundMaxRetries() int {
+	if n := config.Config.Payment.RefundMaxRetries; n > 0 {
+		return n
+	}
+	return 5
+}
+
+func refundSyncAfter() time.Duration {
+	if m := config.Config.Payment.RefundSyncAfterMinutes; m > 0 {
+		return time.Duration(m) * time.Minute
+	}
+	return 10 * time.Minute
+}
+
+// StartOrderRefund 发起整单退款：订单置为退款中，按已成功的支付单逐笔生成退款单并提交渠道
+// 渠道同步返回成功或收到退款成功通知后，全部退款单成功才将订单置为已退款并回补库存等。
+// 已完成订单可售后退款：退款成功时按比例扣回完成时发放的积分，订单退款完成后回滚未提现佣金。
+func (s *PaymentService) StartOrderRefund(orderID uint, reason string) error {
+	var refunds []model.Refund
+	noPayment := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var order model.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("订单不存在")
+			}
+			return err
+		}
+		if order.PayStatus != 2 {
+			return errors.New("当前支付状态不可退款")
+		}
+		// 已完成订单（含到账即完成的充值订单）仍可退款
+		isRecharge := order.OrderType == OrderTypeRecharge
+		if order.Status != 2 && order.Status != 3 && order.Status != 4 {
+			return errors.New("当前状态不可退款")
+		}
+		updates := map[string]any{"pay_status": 3}
+		if reason != "" {
+			updates["cancel_reason"] = reason
+		}
+		res := tx.Model(&model.Order{}).Where("id = ? AND pay_status = 2", order.ID).Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errors.New("当前支付状态不可退款")
+		}
+		if isRecharge {
+			if err := clawbackRechargeTx(tx, &order, time.Now()); err != nil {
+				return err
+			}
+		}
+
+		var pays []model.Payment
+		if err := tx.Where("order_id = ? AND status = 2", order.ID).Order("id ASC").Find(&pays).Error; err != nil {
+			return err
+		}
+		noPayment = len(pays) == 0
+		for _, p := range pays {
+			r := model.Refund{
+				OrderID:      order.ID,
+				PaymentID:    p.ID,
+				RefundNo:     generatePaymentNo("R"),
+				RefundAmount: p.Amount,
+				RefundReason: reason,
+				Status:       model.RefundStatusProcessing,
+			}
+			if err := tx.Create(&r).Error; err != nil {
+				return err
+			}
+			refunds = append(refunds, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if noPayment {
+		// 无支付记录（历史订单或线下收款），直接完成退款
+		return s.finalizeOrderRefund(orderID)
+	}
+	for i := range refunds {
+		if err := s.submitRefund(context.Background(), &refunds[i]); err != nil {
+			zap.L().Warn("submit refund failed", zap.String("refund_no", refunds[i].RefundNo), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// SyncOrderRefund 同步订单退款进度：查询处理中的退款、重新提交失败的退款，全部成功后完成订单退款
+func (s *PaymentService) SyncOrderRefund(orderID uint) error {
+	var order model.Order
+	if err := s.db.First(&order, orderID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("订单不存在")
+		}
+		return err
+	}
+	if order.PayStatus == 4 {
+		return nil
+	}
+	if order.PayStatus != 3 {
+		return errors.New("未处于退款中状态")
+	}
+	var refunds []model.Refund
+	if err := s.db.Where("order_id = ?", orderID).Find(&refunds).Error; err != nil {
+		return err
+	}
+	if len(refunds) == 0 {
+		// 旧流程标记的退款中订单没有退款单，视为线下已退款
+		return s.finalizeOrderRefund(orderID)
+	}
+	for i := range refunds {
+		if err := s.syncRefund(context.Background(), &refunds[i]); err != nil {
+			zap.L().Warn("sync refund failed", zap.String("refund_no", refunds[i].RefundNo), zap.Error(err))
+		}
+	}
+	if err := s.db.Select("id", "pay_status").First(&order, orderID).Error; err != nil {
+		return err
+	}
+	if order.PayStatus != 4 {
+		return errors.New("退款尚未完成，请等待渠道退款结果")
+	}
+	return nil
+}
+
+// RetryRefund 管理端手动重试单笔退款：失败的重新提交，处理中的主动查询
+func (s *PaymentService) RetryRefund(refundID uint) (*model.Refund, error) {
+	var r model.Refund
+	if err := s.db.First(&r, refundID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("退款记录不存在")
+		}
+		return nil, err
+	}
+	if r.Status == model.RefundStatusSuccess {
+		return nil, errors.New("退款已成功，无需重试")
+	}
+	if err := s.syncRefund(context.Background(), &r); err != nil {
+		return nil, err
+	}
+	if err := s.db.First(&r, refundID).Error; err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// RetryRefunds 调度任务：重新提交到期的失败退款，并查询长时间未收到通知的处理中退款
+func (s *PaymentService) RetryRefunds(now time.Time, limit int) (int, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	var due []model.Refund
+	if err := s.db.Where("(status = ? AND next_retry_at IS NOT NULL AND next_retry_at <= ? AND retry_count < ?) OR (status = ? AND updated_at <= ?)",
+		model.RefundStatusFailed, now, refundMaxRetries(), model.RefundStatusProcessing, now.Add(-refundSyncAfter())).
+		Order("id ASC").Limit(limit).Find(&due).Error; err != nil {
+		return 0, err
+	}
+	processed := 0
+	for i := range due {
+		if err := s.syncRefund(context.Background(), &due[i]); err != nil {
+			zap.L().Warn("retry refund failed", zap.String("refund_no", due[i].RefundNo), zap.Error(err))
+			continue
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+// syncRefund 失败的退款重新提交（沿用原退款单号，渠道侧幂等），处理中的退款向渠道查询结果
+func (s *PaymentService) syncRefund(ctx context.Context, r *model.Refund) error {
+	switch r.Status {
+	case model.RefundStatusFailed:
+		return s.submitRefund(ctx, r)
+	case model.RefundStatusProcessing:
+		var pay model.Payment
+		if err := s.db.First(&pay, r.PaymentID).Error; err != nil {
+			return err
+		}
+		if pay.PaymentMethod == PayMethodBalance {
+			return s.submitRefund(ctx, r)
+		}
+		provider, err := s.providerFor(pay.PaymentMethod)
+		if err != nil {
+			return err
+		}
+		res, err := provider.QueryRefund(ctx, pay.PaymentNo, r.RefundNo)
+		if err != nil {
+			return err
+		}
+		return s.applyRefundResult(r, res)
+	default:
+		return nil
+	}
+}
+
+// submitRefund 提交单笔退款：余额支付退回钱包，外部渠道调用渠道退款接口
+func (s *PaymentService) submitRefund(ctx context.Context, r *model.Refund) error {
+	var pay model.Payment
+	if err := s.db.First(&pay, r.PaymentID).Error; err != nil {
+		return err
+	}
+	if pay.PaymentMethod == PayMethodBalance {
+		if err := s.refundToWallet(r); err != nil {
+			s.markRefundFailed(r, err.Error(), true)
+			return err
+		}
+		return s.tryFinalizeOrderRefund(r.OrderID)
+	}
+	provider, err := s.providerFor(pay.PaymentMethod)
+	if err != nil {
+		return err
+	}
+	res, err := provider.Refund(ctx, PayRefundRequest{
+		PaymentNo:   pay.PaymentNo,
+		RefundNo:    r.RefundNo,
+		Reason:      r.RefundReason,
+		RefundCents: yuanDecimalToCents(r.RefundAmount),
+		TotalCents:  yuanDecimalToCents(pay.Amount),
+	})
+	if err != nil {
+		s.markRefundFailed(r, err.Error(), true)
+		return err
+	}
+	return s.applyRefundResult(r, res)
+}
+
+// refundToWallet 余额支付部分退回钱包，退款单状态条件更新防止重复入账
+func (s *PaymentService) refundToWallet(r *model.Refund) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.Model(&model.Refund{}).Where("id = ? AND status <> ?", r.ID, model.RefundStatusSuccess).
+			Updates(map[string]any{"status": model.RefundStatusSuccess, "refunded_at": now, "last_error": ""})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		var order model.Order
+		if err := tx.Select("id", "user_id", "order_no", "store_id").First(&order, r.OrderID).Error; err != nil {
+			return err
+		}
+		wtx, err := walletChange(tx, order.UserID, yuanDecimalToCents(r.RefundAmount), WalletTxOrderRefund,
+			fmt.Sprintf("订单%s退款退回余额", order.OrderNo), orderRevenueAccount(&order))
+		if err != nil {
+			return err
+		}
+		r.Status = model.RefundStatusSuccess
+		r.RefundedAt = &now
+		r.ThirdRefundNo = fmt.Sprintf("wallet_tx_%d", wtx.ID)
+		if err := tx.Model(&model.Refund{}).Where("id = ?", r.ID).Update("third_refund_no", r.ThirdRefundNo).Error; err != nil {
+			return err
+		}
+		return reverseOrderPointsTx(tx, r)
+	})
+}
+
+// applyRefundResult 按渠道返回（同步应答、查询或异步通知）更新退款单，全部成功时完成订单退款
+func (s *PaymentService) applyRefundResult(r *model.Refund, res *PayRefundResult) error {
+	switch res.Status {
+	case "SUCCESS":
+		now := time.Now()
+		refundedAt := res.RefundedAt
+		if refundedAt == nil {
+			refundedAt = &now
+		}
+		updates := map[string]any{"status": model.RefundStatusSuccess, "refunded_at": refundedAt, "last_error": "", "third_response": res.Raw}
+		if res.RefundID != "" {
+			updates["third_refund_no"] = res.RefundID
+		}
+		// 退款单置为成功与渠道退款过账在同一事务，重复通知不重复过账
+		afterClose := false
+		if err := s.db.Transaction(func(tx *gorm.DB) error {
+			res := tx.Model(&model.Refund{}).Where("id = ? AND status <> ?", r.ID, model.RefundStatusSuccess).Updates(updates)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
+			if err := postExternalRefundTx(tx, r); err != nil {
+				return err
+			}
+			var pay model.Payment
+			if err := tx.Select("id", "status").First(&pay, r.PaymentID).Error; err != nil {
+				return err
+			}
+			// 关单后支付的自动退款与订单本身的退款流程无关，不扣回积分
+			if afterClose = pay.Status == model.PaymentStatusPaidAfterClose; afterClose {
+				return nil
+			}
+			return reverseOrderPointsTx(tx, r)
+		}); err != nil {
+			return err
+		}
+		r.Status = model.RefundStatusSuccess
+		r.RefundedAt = refundedAt
+		if afterClose {
+			return nil
+		}
+		return s.tryFinalizeOrderRefund(r.OrderID)
+	case "PROCESSING":
+		updates := map[string]any{"status": model.RefundStatusProcessing, "third_response": res.Raw}
+		if res.RefundID != "" {
+			updates["third_refund_no"] = res.RefundID
+		}
+		r.Status = model.RefundStatusProcessing
+		return s.db.Model(&model.Refund{}).Where("id = ? AND status <> ?", r.ID, model.RefundStatusSuccess).
+			Updates(updates).Error
+	default:
+		// CLOSED 可重新提交；ABNORMAL（如用户银行卡已注销）需人工处理，不自动重试
+		s.markRefundFailed(r, "渠道退款状态: "+res.Status, res.Status != "ABNORMAL")
+		return nil
+	}
+}
+
+// markRefundFailed 记录退款失败原因，retry 为 true 时按退避策略安排自动重试
+func (s *PaymentService) markRefundFailed(r *model.Refund, reason string, retry bool) {
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	updates := map[string]any{
+		"status":        model.RefundStatusFailed,
+		"retry_count":   gorm.Expr("retry_count + 1"),
+		"last_error":    reason,
+		"next_retry_at": nil,
+	}
+	if retry {
+		next := time.Now().Add(refundRetryDelay(r.RetryCount))
+		updates["next_retry_at"] = next
+		r.NextRetryAt = &next
+	}
+	if err := s.db.Model(&model.Refund{}).Where("id = ? AND status <> ?", r.ID, model.RefundStatusSuccess).
+		Updates(updates).Error; err != nil {
+		zap.L().Error("mark refund failed error", zap.String("refund_no", r.RefundNo), zap.Error(err))
+		return
+	}
+	r.Status = model.RefundStatusFailed
+	r.RetryCount++
+	r.LastError = reason
+}
+
+// handleRefundNotify 处理渠道退款结果通知
+func (s *PaymentService) handleRefundNotify(method int, notify *PayNotifyResult) error {
+	if notify.RefundNo == "" {
+		return errors.New("退款通知缺少退款单号")
+	}
+	var r model.Refund
+	if err := s.db.Where("refund_no = ?", notify.RefundNo).First(&r).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("退款记录不存在")
+		}
+		return err
+	}
+	var pay model.Payment
+	if err := s.db.Select("id", "payment_method").First(&pay, r.PaymentID).Error; err != nil {
+		return err
+	}
+	if pay.PaymentMethod != method {
+		return errors.New("退款通知渠道与支付方式不一致")
+	}
+	if r.Status == model.RefundStatusSuccess {
+		return nil
+	}
+	return s.applyRefundResult(&r, &PayRefundResult{
+		RefundNo:   notify.RefundNo,
+		RefundID:   notify.RefundID,
+		Status:     notify.RefundStatus,
+		RefundedAt: notify.RefundedAt,
+		Raw:        notify.Raw,
+	})
+}
+
+// tryFinalizeOrderRefund 订单下所有退款单均成功时完成订单退款
+func (s *PaymentService) tryFinalizeOrderRefund(orderID uint) error {
+	var pending int64
+	if err := s.db.Model(&model.Refund{}).Where("order_id = ? AND status <> ?", orderID, model.RefundStatusSuccess).
+		Count(&pending).Error; err != nil {
+		return err
+	}
+	if pending > 0 {
+		return nil
+	}
+	return s.finalizeOrderRefund(orderID)
+}
+
+// finalizeOrderRefund 退款资金确认后完成订单退款：订单置为已取消/已退款，
+// 未发货时回补库存，释放预约时段并回滚优惠券；提交后回滚未提现佣金。重复调用幂等。
+func (s *PaymentService) finalizeOrderRefund(orderID uint) error {
+	finalized := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var order model.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+			return err
+		}
+		if order.PayStatus == 4 {
+			return nil
+		}
+		if order.PayStatus != 3 {
+			return errors.New("未处于退款中状态")
+		}
+		wasUnshipped := order.Status == 2
+		now := time.Now()
+		res := tx.Model(&model.Order{}).Where("id = ? AND pay_status = 3", order.ID).
+			Updates(map[string]any{"status": 5, "pay_status": 4, "cancelled_at": now})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		order.Status = 5
+		order.PayStatus = 4
+		order.CancelledAt = &now
+		// 未发货则回补库存，已配送中不回补
+		if err := releaseOrderStock(tx, &order, StockReleaseRefund, wasUnshipped, now); err != nil {
+			return err
+		}
+		if err := releaseOrderSlot(tx, order.ID); err != nil {
+			return err
+		}
+		if err := rollbackOrderCoupon(tx, order.ID); err != nil {
+			return err
+		}
+		finalized = true
+		return nil
+	})
+	if err != nil || !finalized {
+		return err
+	}
+	// 退款完成后回滚该订单未提现的佣金；失败不影响退款结果，记录日志供财务人工处理
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		_, err := commission.ReverseOrderCommissionsTx(tx, orderID, nil, "order refunded")
+		return err
+	}); err != nil {
+		zap.L().Error("reverse order commissions failed", zap.Uint("order_id", orderID), zap.Error(err))
+	}
+	return nil
+}
+
+// rollbackOrderCoupon 回滚订单已使用的优惠券（如有）
+func rollbackOrderCoupon(tx *gorm.DB, orderID uint) error {
+	var uc model.UserCoupon
+	if err := tx.Where("order_id = ? AND status = 2", orderID).First(&uc).Error; err != nil {
+		return nil
+	}
+	if err := tx.Model(&model.UserCoupon{}).Where("id = ?", uc.ID).
+		Updates(map[string]any{"status": 1, "used_at": nil, "order_id": nil}).Error; err != nil {
+		return err
+	}
+	// 安全递减已使用计数
+	return tx.Model(&model.Coupon{}).Where("id = ? AND used_count > 0", uc.CouponID).
+		Update("used_count", gorm.Expr("used_count - 1")).Error
+}
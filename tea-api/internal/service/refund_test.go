@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+// refundStubProvider 退款受理后处理中，结果由异步通知推送
+type refundStubProvider struct {
+	MockPaymentProvider
+	refundErr error
+	notify    *PayNotifyResult
+}
+
+func (p *refundStubProvider) Refund(_ context.Context, req PayRefundRequest) (*PayRefundResult, error) {
+	if p.refundErr != nil {
+		return nil, p.refundErr
+	}
+	return &PayRefundResult{RefundNo: req.RefundNo, RefundID: "5030000000000001", Status: "PROCESSING"}, nil
+}
+
+func (p *refundStubProvider) QueryRefund(_ context.Context, _ string, refundNo string) (*PayRefundResult, error) {
+	return &PayRefundResult{RefundNo: refundNo, RefundID: "5030000000000001", Status: "PROCESSING"}, nil
+}
+
+func (p *refundStubProvider) ParseNotify(context.Context, http.Header, []byte) (*PayNotifyResult, error) {
+	return p.notify, nil
+}
+
+func setupRefundDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:order_refund?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Order{}, &model.OrderItem{}, &model.Payment{}, &model.Refund{}, &model.StockReservation{},
+		&model.StoreSlotTemplate{}, &model.Wallet{}, &model.WalletTransaction{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}, &model.PointsTransaction{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func TestOrderRefund_MixedPaymentNotifyFinalizes(t *testing.T) {
+	db := setupRefundDB(t)
+	db.Create(&model.Wallet{UserID: 1, Balance: 0})
+	order := model.Order{OrderNo: "ORDR1", UserID: 1, Status: 2, PayStatus: 2, TotalAmount: decimal.NewFromInt(20), PayAmount: decimal.NewFromInt(20)}
+	db.Create(&order)
+	db.Create(&model.Payment{OrderID: order.ID, PaymentNo: "BR0001", PaymentMethod: PayMethodBalance, Amount: decimal.NewFromInt(14), Status: 2})
+	db.Create(&model.Payment{OrderID: order.ID, PaymentNo: "PR0001", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(6), Status: 2})
+
+	provider := &refundStubProvider{}
+	svc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return provider, nil }}
+	if err := svc.StartOrderRefund(order.ID, "顾客取消"); err != nil {
+		t.Fatalf("start refund: %v", err)
+	}
+	db.First(&order, order.ID)
+	if order.PayStatus != 3 || walletBalance(t, db, 1) != 1400 {
+		t.Fatalf("order should be refunding with balance returned, pay_status=%d balance=%d", order.PayStatus, walletBalance(t, db, 1))
+	}
+	if err := svc.SyncOrderRefund(order.ID); err == nil {
+		t.Fatalf("confirm should fail while channel refund is processing")
+	}
+
+	var wx model.Refund
+	db.Joins("JOIN payments ON payments.id = refunds.payment_id").Where("payments.payment_no = ?", "PR0001").First(&wx)
+	if wx.Status != model.RefundStatusProcessing || wx.ThirdRefundNo != "5030000000000001" {
+		t.Fatalf("wechat refund should be processing, got %+v", wx)
+	}
+
+	// 支付宝通道推送的通知不能确认微信退款
+	provider.notify = &PayNotifyResult{Kind: PayNotifyRefund, RefundNo: wx.RefundNo, RefundID: "5030000000000001", RefundStatus: "SUCCESS"}
+	if err := svc.HandleProviderNotify(context.Background(), PayMethodAlipay, nil, nil); err == nil {
+		t.Fatalf("refund notify from mismatched channel should be rejected")
+	}
+	if err := svc.HandleProviderNotify(context.Background(), PayMethodWechat, nil, nil); err != nil {
+		t.Fatalf("refund notify: %v", err)
+	}
+	db.First(&wx, wx.ID)
+	db.First(&order, order.ID)
+	if wx.Status != model.RefundStatusSuccess || wx.RefundedAt == nil || order.Status != 5 || order.PayStatus != 4 {
+		t.Fatalf("order should be refunded after notify, refund=%d order=%d/%d", wx.Status, order.Status, order.PayStatus)
+	}
+	// 重复通知幂等，余额不重复退回
+	if err := svc.HandleProviderNotify(context.Background(), PayMethodWechat, nil, nil); err != nil || walletBalance(t, db, 1) != 1400 {
+		t.Fatalf("duplicate notify should be idempotent, err=%v balance=%d", err, walletBalance(t, db, 1))
+	}
+}
+
+func TestOrderRefund_FailedRefundIsRetried(t *testing.T) {
+	db := setupRefundDB(t)
+	order := model.Order{OrderNo: "ORDR2", UserID: 2, Status: 3, PayStatus: 2, TotalAmount: decimal.NewFromInt(36), PayAmount: decimal.NewFromInt(36)}
+	db.Create(&order)
+	db.Create(&model.Payment{OrderID: order.ID, PaymentNo: "PR0002", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(36), Status: 2})
+
+	var provider PaymentProvider = &refundStubProvider{refundErr: errors.New("NOT_ENOUGH: 基本账户余额不足")}
+	svc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return provider, nil }}
+	if err := svc.StartOrderRefund(order.ID, "质量问题"); err != nil {
+		t.Fatalf("start refund: %v", err)
+	}
+	var r model.Refund
+	db.Where("order_id = ?", order.ID).First(&r)
+	if r.Status != model.RefundStatusFailed || r.RetryCount != 1 || r.NextRetryAt == nil || r.LastError == "" {
+		t.Fatalf("failed refund should be scheduled for retry, got %+v", r)
+	}
+
+	// 未到重试时间不处理
+	if n, _ := svc.RetryRefunds(time.Now(), 10); n != 0 {
+		t.Fatalf("refund should not be retried before next_retry_at, got %d", n)
+	}
+	provider = &MockPaymentProvider{}
+	if n, err := svc.RetryRefunds(time.Now().Add(2*time.Minute), 10); err != nil || n != 1 {
+		t.Fatalf("retry should process 1 refund, got %d err=%v", n, err)
+	}
+	db.First(&r, r.ID)
+	db.First(&order, order.ID)
+	if r.Status != model.RefundStatusSuccess || order.Status != 5 || order.PayStatus != 4 {
+		t.Fatalf("order should be refunded after retry, refund=%d order=%d/%d", r.Status, order.Status, order.PayStatus)
+	}
+}
+
+func TestPaymentCallback_SuccessAfterCloseIsRefunded(t *testing.T) {
+	db := setupRefundDB(t)
+	// 订单已超时关闭，渠道支付成功通知迟到
+	order := model.Order{OrderNo: "ORDR3", UserID: 3, Status: 5, PayStatus: 1, TotalAmount: decimal.NewFromInt(18), PayAmount: decimal.NewFromInt(18)}
+	db.Create(&order)
+	db.Create(&model.Payment{OrderID: order.ID, PaymentNo: "PR0003", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(18), Status: 1})
+
+	svc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return &MockPaymentProvider{}, nil }}
+	cb := PaymentCallbackPayload{PaymentNo: "PR0003", TransactionID: "4200000000000003", TradeState: "SUCCESS", SkipVerify: true, Method: PayMethodWechat}
+	if err := svc.HandleCallback(cb); err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	if err := svc.HandleCallback(cb); err != nil {
+		t.Fatalf("duplicate callback: %v", err)
+	}
+
+	var pay model.Payment
+	db.Where("payment_no = ?", "PR0003").First(&pay)
+	db.First(&order, order.ID)
+	if pay.Status != model.PaymentStatusPaidAfterClose || pay.PaidAt == nil {
+		t.Fatalf("payment should be recorded as paid after close, got %+v", pay)
+	}
+	if order.Status != 5 || order.PayStatus != 1 || order.PaidAt != nil {
+		t.Fatalf("closed order must not be revived, got %d/%d", order.Status, order.PayStatus)
+	}
+	var refunds []model.Refund
+	db.Where("order_id = ?", order.ID).Find(&refunds)
+	if len(refunds) != 1 || refunds[0].PaymentID != pay.ID || refunds[0].Status != model.RefundStatusSuccess ||
+		!refunds[0].RefundAmount.Equal(decimal.NewFromInt(18)) {
+		t.Fatalf("expected one successful full refund, got %+v", refunds)
+	}
+
+	locked := model.Order{BaseModel: model.BaseModel{ID: order.ID}, Status: 5}
+	if err := markOrderPaidTx(db, &locked, nil, time.Now()); err == nil {
+		t.Fatalf("closed order should not be marked paid")
+	}
+}
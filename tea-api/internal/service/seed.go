@@ -33,6 +33,8 @@ func SeedRBAC(db *gorm.DB, opts SeedOptions) error {
 		{BaseModel: model.BaseModel{UID: "perm-marketing-banner-manage"}, Name: "marketing:banner:manage", Module: "marketing", Action: "manage", Resource: "banner"},
 		{BaseModel: model.BaseModel{UID: "perm-marketing-recharge-view"}, Name: "marketing:recharge:view", Module: "marketing", Action: "view", Resource: "recharge"},
 		{BaseModel: model.BaseModel{UID: "perm-marketing-recharge-manage"}, Name: "marketing:recharge:manage", Module: "marketing", Action: "manage", Resource: "recharge"},
+		{BaseModel: model.BaseModel{UID: "perm-marketing-points-view"}, Name: "marketing:points:view", Module: "marketing", Action: "view", Resource: "points"},
+		{BaseModel: model.BaseModel{UID: "perm-marketing-points-manage"}, Name: "marketing:points:manage", Module: "marketing", Action: "manage", Resource: "points"},
 		{BaseModel: model.BaseModel{UID: "perm-user-partner-view"}, Name: "user:partner:view", Module: "user", Action: "view", Resource: "partner"},
 		{BaseModel: model.BaseModel{UID: "perm-user-partner-manage"}, Name: "user:partner:manage", Module: "user", Action: "manage", Resource: "partner"},
 	}
@@ -93,6 +95,21 @@ func SeedRBAC(db *gorm.DB, opts SeedOptions) error {
 			FirstOrCreate(&rpAdminRechargeManage, &model.RolePermission{BaseModel: model.BaseModel{UID: "rp-admin-marketing-recharge-manage"}, RoleID: adminRole.ID, PermissionID: pRechargeManage.ID}).Error
 	}
 
+	var pPointsView model.Permission
+	_ = db.Where("name = ?", "marketing:points:view").First(&pPointsView).Error
+	if adminRole.ID > 0 && pPointsView.ID > 0 {
+		var rpAdminPointsView model.RolePermission
+		_ = db.Where("role_id = ? AND permission_id = ?", adminRole.ID, pPointsView.ID).
+			FirstOrCreate(&rpAdminPointsView, &model.RolePermission{BaseModel: model.BaseModel{UID: "rp-admin-marketing-points-view"}, RoleID: adminRole.ID, PermissionID: pPointsView.ID}).Error
+	}
+	var pPointsManage model.Permission
+	_ = db.Where("name = ?", "marketing:points:manage").First(&pPointsManage).Error
+	if adminRole.ID > 0 && pPointsManage.ID > 0 {
+		var rpAdminPointsManage model.RolePermission
+		_ = db.Where("role_id = ? AND permission_id = ?", adminRole.ID, pPointsManage.ID).
+			FirstOrCreate(&rpAdminPointsManage, &model.RolePermission{BaseModel: model.BaseModel{UID: "rp-admin-marketing-points-manage"}, RoleID: adminRole.ID, PermissionID: pPointsManage.ID}).Error
+	}
+
 	var pPartnerView model.Permission
 	_ = db.Where("name = ?", "user:partner:view").First(&pPartnerView).Error
 	if adminRole.ID > 0 && pPartnerView.ID > 0 {
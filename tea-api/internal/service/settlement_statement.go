@@ -0,0 +1,597 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/model"
+	"tea-api/pkg/database"
+)
+
+// 对账单渠道
+const (
+	SettlementProviderWechat = "wechat"
+	SettlementProviderAlipay = "alipay"
+)
+
+// 三方对账差异类型
+const (
+	SettlementMissingLocal    = "missing_local"    // 渠道有记录，本地无成功的支付/退款
+	SettlementMissingProvider = "missing_provider" // 本地成功，渠道对账单无记录
+	SettlementAmountMismatch  = "amount_mismatch"  // 两边均有记录但金额不一致
+)
+
+const settlementTimeLayout = "2006-01-02 15:04:05"
+
+type SettlementService struct {
+	db *gorm.DB
+}
+
+func NewSettlementService() *SettlementService {
+	return &SettlementService{db: database.GetDB()}
+}
+
+// settlementProviderMethod 对账单渠道对应的支付方式
+func settlementProviderMethod(provider string) (int, error) {
+	switch provider {
+	case SettlementProviderWechat:
+		return PayMethodWechat, nil
+	case SettlementProviderAlipay:
+		return PayMethodAlipay, nil
+	default:
+		return 0, errors.New("不支持的对账单渠道")
+	}
+}
+
+// SettlementImportResult 对账单导入结果
+type SettlementImportResult struct {
+	BatchNo       string          `json:"batch_no"`
+	Provider      string          `json:"provider"`
+	Lines         int             `json:"lines"`
+	PaymentCount  int             `json:"payment_count"`
+	PaymentAmount decimal.Decimal `json:"payment_amount"`
+	RefundCount   int             `json:"refund_count"`
+	RefundAmount  decimal.Decimal `json:"refund_amount"`
+	Fee           decimal.Decimal `json:"fee"`
+}
+
+// ImportStatement 解析并导入渠道日账单，按渠道交易号去重（重复导入覆盖）
+func (s *SettlementService) ImportStatement(provider string, data []byte, operatorID uint) (*SettlementImportResult, error) {
+	var (
+		lines []model.SettlementStatementLine
+		err   error
+	)
+	switch provider {
+	case SettlementProviderWechat:
+		lines, err = ParseWechatBill(data)
+	case SettlementProviderAlipay:
+		lines, err = ParseAlipayBill(data)
+	default:
+		return nil, errors.New("不支持的对账单渠道")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("对账单中没有可导入的明细")
+	}
+	res := &SettlementImportResult{
+		BatchNo:  generatePaymentNo("SB"),
+		Provider: provider,
+		Lines:    len(lines),
+	}
+	for i := range lines {
+		lines[i].BatchNo = res.BatchNo
+		lines[i].ImportedBy = operatorID
+		res.Fee = res.Fee.Add(lines[i].Fee)
+		if lines[i].LineType == model.SettlementLineRefund {
+			res.RefundCount++
+			res.RefundAmount = res.RefundAmount.Add(lines[i].Amount)
+		} else {
+			res.PaymentCount++
+			res.PaymentAmount = res.PaymentAmount.Add(lines[i].Amount)
+		}
+	}
+	err = s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "provider"}, {Name: "line_key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"batch_no", "line_type", "trade_time", "transaction_id", "payment_no",
+			"refund_no", "trade_state", "amount", "fee", "raw_line", "imported_by", "updated_at"}),
+	}).CreateInBatches(&lines, 200).Error
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// readBillCSV 读取对账单 CSV：非 UTF-8 内容按 GBK 解码（支付宝账单默认 GBK 编码）
+func readBillCSV(data []byte) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		decoded, err := simplifiedchinese.GBK.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("对账单编码无法识别: %w", err)
+		}
+		data = decoded
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	var records [][]string
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("对账单格式错误: %w", err)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// billColumns 按表头建立列索引；列名按前缀匹配，兼容「订单金额（元）」等带单位的表头
+type billColumns map[string]int
+
+func newBillColumns(header []string) billColumns {
+	cols := billColumns{}
+	for i, h := range header {
+		cols[strings.TrimSpace(h)] = i
+	}
+	return cols
+}
+
+func (b billColumns) get(rec []string, names ...string) string {
+	for _, name := range names {
+		idx, ok := b[name]
+		if !ok {
+			for k, i := range b {
+				if strings.HasPrefix(k, name) {
+					idx, ok = i, true
+					break
+				}
+			}
+		}
+		if ok && idx < len(rec) {
+			// 微信账单每个字段以 ` 开头防止被表格软件转换格式
+			return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rec[idx]), "`"))
+		}
+	}
+	return ""
+}
+
+func (b billColumns) has(names ...string) bool {
+	for _, n := range names {
+		found := false
+		for k := range b {
+			if strings.HasPrefix(k, n) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func parseBillAmount(s string) (decimal.Decimal, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	if s == "" {
+		return decimal.Zero, nil
+	}
+	return decimal.NewFromString(s)
+}
+
+// ParseWechatBill 解析微信支付交易账单（全部订单 ALL）CSV
+// 表头后每行字段以 ` 开头，「总交易单数」开始为汇总区，不作为明细导入；仅导入 SUCCESS 交易与 REFUND 退款。
+func ParseWechatBill(data []byte) ([]model.SettlementStatementLine, error) {
+	records, err := readBillCSV(data)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		cols  billColumns
+		lines []model.SettlementStatementLine
+	)
+	for n, rec := range records {
+		if len(rec) == 0 || strings.TrimSpace(strings.Join(rec, "")) == "" {
+			continue
+		}
+		first := strings.TrimSpace(rec[0])
+		if cols == nil {
+			if first == "交易时间" {
+				cols = newBillColumns(rec)
+				if !cols.has("微信订单号", "商户订单号", "交易状态", "手续费") {
+					return nil, errors.New("微信账单表头缺少必要列")
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(first, "总交易单数") {
+			break
+		}
+		state := cols.get(rec, "交易状态")
+		if state != "SUCCESS" && state != "REFUND" {
+			continue
+		}
+		tradeTime, err := time.ParseInLocation(settlementTimeLayout, cols.get(rec, "交易时间"), chinaZone())
+		if err != nil {
+			return nil, fmt.Errorf("第%d行交易时间格式错误", n+1)
+		}
+		fee, err := parseBillAmount(cols.get(rec, "手续费"))
+		if err != nil {
+			return nil, fmt.Errorf("第%d行手续费格式错误", n+1)
+		}
+		line := model.SettlementStatementLine{
+			Provider:      SettlementProviderWechat,
+			TradeTime:     tradeTime,
+			TransactionID: cols.get(rec, "微信订单号"),
+			PaymentNo:     cols.get(rec, "商户订单号"),
+			TradeState:    state,
+			Fee:           fee,
+			RawLine:       strings.Join(rec, ","),
+		}
+		var amount string
+		if state == "REFUND" {
+			line.LineType = model.SettlementLineRefund
+			line.RefundNo = cols.get(rec, "商户退款单号")
+			line.LineKey = "R:" + line.TransactionID + ":" + cols.get(rec, "微信退款单号")
+			if st := cols.get(rec, "退款状态"); st != "" {
+				line.TradeState = st
+			}
+			amount = cols.get(rec, "退款金额")
+		} else {
+			line.LineType = model.SettlementLinePayment
+			line.LineKey = "P:" + line.TransactionID
+			// 订单金额为用户实付+代金券，缺失时使用应结订单金额
+			if amount = cols.get(rec, "订单金额"); amount == "" {
+				amount = cols.get(rec, "应结订单金额")
+			}
+		}
+		if line.TransactionID == "" {
+			return nil, fmt.Errorf("第%d行缺少微信订单号", n+1)
+		}
+		if line.Amount, err = parseBillAmount(amount); err != nil {
+			return nil, fmt.Errorf("第%d行金额格式错误", n+1)
+		}
+		line.Amount = line.Amount.Abs()
+		lines = append(lines, line)
+	}
+	if cols == nil {
+		return nil, errors.New("未识别的微信账单格式")
+	}
+	return lines, nil
+}
+
+// ParseAlipayBill 解析支付宝业务明细账单 CSV（# 开头为说明/汇总行）
+// 支付宝服务费以负数表示收取，导入时取反：收取为正、退款退回为负，与微信账单口径一致。
+func ParseAlipayBill(data []byte) ([]model.SettlementStatementLine, error) {
+	records, err := readBillCSV(data)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		cols  billColumns
+		lines []model.SettlementStatementLine
+	)
+	for n, rec := range records {
+		if len(rec) == 0 || strings.HasPrefix(strings.TrimSpace(rec[0]), "#") || strings.TrimSpace(strings.Join(rec, "")) == "" {
+			continue
+		}
+		if cols == nil {
+			if strings.TrimSpace(rec[0]) == "支付宝交易号" {
+				cols = newBillColumns(rec)
+				if !cols.has("商户订单号", "业务类型", "订单金额", "服务费") {
+					return nil, errors.New("支付宝账单表头缺少必要列")
+				}
+			}
+			continue
+		}
+		bizType := cols.get(rec, "业务类型")
+		if bizType != "交易" && bizType != "退款" {
+			continue
+		}
+		ts := cols.get(rec, "完成时间")
+		if ts == "" {
+			ts = cols.get(rec, "创建时间")
+		}
+		tradeTime, err := time.ParseInLocation(settlementTimeLayout, ts, chinaZone())
+		if err != nil {
+			return nil, fmt.Errorf("第%d行完成时间格式错误", n+1)
+		}
+		amount, err := parseBillAmount(cols.get(rec, "订单金额"))
+		if err != nil {
+			return nil, fmt.Errorf("第%d行订单金额格式错误", n+1)
+		}
+		fee, err := parseBillAmount(cols.get(rec, "服务费"))
+		if err != nil {
+			return nil, fmt.Errorf("第%d行服务费格式错误", n+1)
+		}
+		line := model.SettlementStatementLine{
+			Provider:      SettlementProviderAlipay,
+			TradeTime:     tradeTime,
+			TransactionID: cols.get(rec, "支付宝交易号"),
+			PaymentNo:     cols.get(rec, "商户订单号"),
+			Amount:        amount.Abs(),
+			Fee:           fee.Neg(),
+			RawLine:       strings.Join(rec, ","),
+		}
+		if line.TransactionID == "" {
+			return nil, fmt.Errorf("第%d行缺少支付宝交易号", n+1)
+		}
+		if bizType == "退款" {
+			line.LineType = model.SettlementLineRefund
+			line.TradeState = "REFUND"
+			line.RefundNo = cols.get(rec, "退款批次号")
+			line.LineKey = "R:" + line.TransactionID + ":" + line.RefundNo
+		} else {
+			line.LineType = model.SettlementLinePayment
+			line.TradeState = "SUCCESS"
+			line.LineKey = "P:" + line.TransactionID
+		}
+		lines = append(lines, line)
+	}
+	if cols == nil {
+		return nil, errors.New("未识别的支付宝账单格式")
+	}
+	return lines, nil
+}
+
+// SettlementMatchQuery 三方对账查询条件：时间区间为 [Start, End)
+type SettlementMatchQuery struct {
+	Provider string // 为空表示全部渠道
+	Start    time.Time
+	End      time.Time
+}
+
+// SettlementMatchRow 对账差异明细
+type SettlementMatchRow struct {
+	Kind           string          `json:"kind"`
+	Provider       string          `json:"provider"`
+	LineType       string          `json:"line_type"`
+	PaymentNo      string          `json:"payment_no"`
+	RefundNo       string          `json:"refund_no"`
+	TransactionID  string          `json:"transaction_id"`
+	OrderID        uint            `json:"order_id"`
+	OrderNo        string          `json:"order_no"`
+	ProviderAmount decimal.Decimal `json:"provider_amount"`
+	LocalAmount    decimal.Decimal `json:"local_amount"`
+	DiffAmount     decimal.Decimal `json:"diff_amount"` // 渠道金额 - 本地金额
+	LocalStatus    int             `json:"local_status"`
+	TradeTime      *time.Time      `json:"trade_time"`
+}
+
+// SettlementProviderTotal 渠道汇总：对账单金额、手续费与本地成功金额
+type SettlementProviderTotal struct {
+	Provider           string          `json:"provider"`
+	PaymentCount       int             `json:"payment_count"`
+	PaymentAmount      decimal.Decimal `json:"payment_amount"`
+	RefundCount        int             `json:"refund_count"`
+	RefundAmount       decimal.Decimal `json:"refund_amount"`
+	Fee                decimal.Decimal `json:"fee"`
+	SettledAmount      decimal.Decimal `json:"settled_amount"` // 交易 - 退款 - 手续费
+	LocalPaymentCount  int             `json:"local_payment_count"`
+	LocalPaymentAmount decimal.Decimal `json:"local_payment_amount"`
+	LocalRefundCount   int             `json:"local_refund_count"`
+	LocalRefundAmount  decimal.Decimal `json:"local_refund_amount"`
+}
+
+// SettlementMatchReport 三方对账报告：渠道对账单 × 支付/退款记录 × 订单
+type SettlementMatchReport struct {
+	MissingLocal    int                       `json:"missing_local"`
+	MissingProvider int                       `json:"missing_provider"`
+	AmountMismatch  int                       `json:"amount_mismatch"`
+	Totals          []SettlementProviderTotal `json:"totals"`
+	Rows            []SettlementMatchRow      `json:"rows"`
+}
+
+// MatchReport 核对区间内的渠道对账单与本地支付/退款记录
+// 渠道侧按交易时间取区间内明细，本地按支付/退款完成时间取区间内成功记录；两边互查时不限时间，避免跨日到账误报。
+func (s *SettlementService) MatchReport(q SettlementMatchQuery) (*SettlementMatchReport, error) {
+	providers := []string{SettlementProviderWechat, SettlementProviderAlipay}
+	if q.Provider != "" {
+		if _, err := settlementProviderMethod(q.Provider); err != nil {
+			return nil, err
+		}
+		providers = []string{q.Provider}
+	}
+	report := &SettlementMatchReport{Rows: []SettlementMatchRow{}}
+	for _, p := range providers {
+		total, rows, err := s.matchProvider(p, q.Start, q.End)
+		if err != nil {
+			return nil, err
+		}
+		report.Totals = append(report.Totals, *total)
+		report.Rows = append(report.Rows, rows...)
+	}
+	orderIDs := make([]uint, 0)
+	for _, r := range report.Rows {
+		if r.OrderID != 0 {
+			orderIDs = append(orderIDs, r.OrderID)
+		}
+		switch r.Kind {
+		case SettlementMissingLocal:
+			report.MissingLocal++
+		case SettlementMissingProvider:
+			report.MissingProvider++
+		case SettlementAmountMismatch:
+			report.AmountMismatch++
+		}
+	}
+	if len(orderIDs) > 0 {
+		var orders []model.Order
+		if err := s.db.Select("id", "order_no").Where("id IN ?", orderIDs).Find(&orders).Error; err != nil {
+			return nil, err
+		}
+		nos := make(map[uint]string, len(orders))
+		for _, o := range orders {
+			nos[o.ID] = o.OrderNo
+		}
+		for i := range report.Rows {
+			report.Rows[i].OrderNo = nos[report.Rows[i].OrderID]
+		}
+	}
+	return report, nil
+}
+
+func (s *SettlementService) matchProvider(provider string, start, end time.Time) (*SettlementProviderTotal, []SettlementMatchRow, error) {
+	method, _ := settlementProviderMethod(provider)
+	total := &SettlementProviderTotal{Provider: provider}
+	rows := make([]SettlementMatchRow, 0)
+
+	var lines []model.SettlementStatementLine
+	if err := s.db.Where("provider = ? AND trade_time >= ? AND trade_time < ?", provider, start, end).
+		Order("trade_time ASC").Find(&lines).Error; err != nil {
+		return nil, nil, err
+	}
+	payNos, refundNos := make([]string, 0), make([]string, 0)
+	for _, l := range lines {
+		total.Fee = total.Fee.Add(l.Fee)
+		if l.LineType == model.SettlementLineRefund {
+			total.RefundCount++
+			total.RefundAmount = total.RefundAmount.Add(l.Amount)
+			refundNos = append(refundNos, l.RefundNo)
+		} else {
+			total.PaymentCount++
+			total.PaymentAmount = total.PaymentAmount.Add(l.Amount)
+			payNos = append(payNos, l.PaymentNo)
+		}
+	}
+	total.SettledAmount = total.PaymentAmount.Sub(total.RefundAmount).Sub(total.Fee)
+
+	// 渠道 → 本地
+	payments := map[string]model.Payment{}
+	if len(payNos) > 0 {
+		var list []model.Payment
+		if err := s.db.Where("payment_no IN ?", payNos).Find(&list).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, p := range list {
+			payments[p.PaymentNo] = p
+		}
+	}
+	refunds := map[string]model.Refund{}
+	if len(refundNos) > 0 {
+		var list []model.Refund
+		if err := s.db.Where("refund_no IN ?", refundNos).Find(&list).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, r := range list {
+			refunds[r.RefundNo] = r
+		}
+	}
+	for _, l := range lines {
+		tradeTime := l.TradeTime
+		row := SettlementMatchRow{
+			Provider: provider, LineType: l.LineType, PaymentNo: l.PaymentNo, RefundNo: l.RefundNo,
+			TransactionID: l.TransactionID, ProviderAmount: l.Amount, TradeTime: &tradeTime,
+		}
+		var (
+			found     bool
+			succeeded bool
+		)
+		if l.LineType == model.SettlementLineRefund {
+			r, ok := refunds[l.RefundNo]
+			found, succeeded = ok, ok && r.Status == model.RefundStatusSuccess
+			row.OrderID, row.LocalAmount, row.LocalStatus = r.OrderID, r.RefundAmount, r.Status
+		} else {
+			p, ok := payments[l.PaymentNo]
+			// 关单后支付的支付单渠道侧同样已收款，随后由对应退款单冲回
+			found, succeeded = ok && p.PaymentMethod == method, ok && p.PaymentMethod == method &&
+				(p.Status == 2 || p.Status == model.PaymentStatusPaidAfterClose)
+			row.OrderID, row.LocalAmount, row.LocalStatus = p.OrderID, p.Amount, p.Status
+		}
+		row.DiffAmount = row.ProviderAmount.Sub(row.LocalAmount)
+		switch {
+		case !found || !succeeded:
+			row.Kind = SettlementMissingLocal
+		case !row.DiffAmount.IsZero():
+			row.Kind = SettlementAmountMismatch
+		default:
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	// 本地 → 渠道
+	var localPays []model.Payment
+	if err := s.db.Where("payment_method = ? AND status IN ? AND paid_at >= ? AND paid_at < ?",
+		method, []int{2, model.PaymentStatusPaidAfterClose}, start, end).
+		Order("paid_at ASC").Find(&localPays).Error; err != nil {
+		return nil, nil, err
+	}
+	localPayNos := make([]string, 0, len(localPays))
+	for _, p := range localPays {
+		total.LocalPaymentCount++
+		total.LocalPaymentAmount = total.LocalPaymentAmount.Add(p.Amount)
+		localPayNos = append(localPayNos, p.PaymentNo)
+	}
+	var localRefunds []model.Refund
+	if err := s.db.Joins("JOIN payments ON payments.id = refunds.payment_id").
+		Where("payments.payment_method = ? AND refunds.status = ? AND refunds.refunded_at >= ? AND refunds.refunded_at < ?",
+			method, model.RefundStatusSuccess, start, end).
+		Order("refunds.refunded_at ASC").Find(&localRefunds).Error; err != nil {
+		return nil, nil, err
+	}
+	localRefundNos := make([]string, 0, len(localRefunds))
+	for _, r := range localRefunds {
+		total.LocalRefundCount++
+		total.LocalRefundAmount = total.LocalRefundAmount.Add(r.RefundAmount)
+		localRefundNos = append(localRefundNos, r.RefundNo)
+	}
+	onStatement := map[string]bool{}
+	if len(localPayNos) > 0 {
+		var nos []string
+		if err := s.db.Model(&model.SettlementStatementLine{}).Where("provider = ? AND line_type = ? AND payment_no IN ?",
+			provider, model.SettlementLinePayment, localPayNos).Pluck("payment_no", &nos).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, no := range nos {
+			onStatement["P:"+no] = true
+		}
+	}
+	if len(localRefundNos) > 0 {
+		var nos []string
+		if err := s.db.Model(&model.SettlementStatementLine{}).Where("provider = ? AND line_type = ? AND refund_no IN ?",
+			provider, model.SettlementLineRefund, localRefundNos).Pluck("refund_no", &nos).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, no := range nos {
+			onStatement["R:"+no] = true
+		}
+	}
+	for _, p := range localPays {
+		if onStatement["P:"+p.PaymentNo] {
+			continue
+		}
+		rows = append(rows, SettlementMatchRow{
+			Kind: SettlementMissingProvider, Provider: provider, LineType: model.SettlementLinePayment,
+			PaymentNo: p.PaymentNo, TransactionID: p.ThirdPayNo, OrderID: p.OrderID,
+			LocalAmount: p.Amount, DiffAmount: p.Amount.Neg(), LocalStatus: p.Status, TradeTime: p.PaidAt,
+		})
+	}
+	for _, r := range localRefunds {
+		if onStatement["R:"+r.RefundNo] {
+			continue
+		}
+		rows = append(rows, SettlementMatchRow{
+			Kind: SettlementMissingProvider, Provider: provider, LineType: model.SettlementLineRefund,
+			RefundNo: r.RefundNo, TransactionID: r.ThirdRefundNo, OrderID: r.OrderID,
+			LocalAmount: r.RefundAmount, DiffAmount: r.RefundAmount.Neg(), LocalStatus: r.Status, TradeTime: r.RefundedAt,
+		})
+	}
+	return total, rows, nil
+}
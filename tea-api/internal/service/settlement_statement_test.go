@@ -0,0 +1,123 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+const testWechatBill = "交易时间,公众账号ID,商户号,特约商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,付款银行,货币种类,应结订单金额,代金券金额,微信退款单号,商户退款单号,退款金额,充值券退款金额,退款类型,退款状态,商品名称,商户数据包,手续费,费率,订单金额,申请退款金额,费率备注\n" +
+	"`2024-01-01 10:00:00,`wxd678efh567hg6787,`1900000001,`0,`,`4200000001,`PS0001,`oUpF8u,`JSAPI,`SUCCESS,`OTHERS,`CNY,`36.00,`0.00,`0,`0,`0.00,`0.00,`,`,`订单ORDS1,`,`0.22,`0.60%,`36.00,`0.00,`\n" +
+	"`2024-01-01 11:00:00,`wxd678efh567hg6787,`1900000001,`0,`,`4200000002,`PS0002,`oUpF8u,`JSAPI,`SUCCESS,`OTHERS,`CNY,`20.00,`0.00,`0,`0,`0.00,`0.00,`,`,`订单ORDS2,`,`0.12,`0.60%,`20.00,`0.00,`\n" +
+	"`2024-01-01 12:00:00,`wxd678efh567hg6787,`1900000001,`0,`,`4200000003,`PX9999,`oUpF8u,`JSAPI,`SUCCESS,`OTHERS,`CNY,`8.00,`0.00,`0,`0,`0.00,`0.00,`,`,`未知订单,`,`0.05,`0.60%,`8.00,`0.00,`\n" +
+	"`2024-01-01 15:00:00,`wxd678efh567hg6787,`1900000001,`0,`,`4200000001,`PS0001,`oUpF8u,`JSAPI,`REFUND,`OTHERS,`CNY,`0.00,`0.00,`50300001,`RS0001,`36.00,`0.00,`ORIGINAL,`SUCCESS,`订单ORDS1,`,`-0.22,`0.60%,`0.00,`36.00,`\n" +
+	"总交易单数,应结订单总金额,退款总金额,充值券退款总金额,手续费总金额,订单总金额,申请退款总金额\n" +
+	"`4,`64.00,`36.00,`0.00,`0.17,`64.00,`36.00\n"
+
+func TestParseBills(t *testing.T) {
+	lines, err := ParseWechatBill([]byte(testWechatBill))
+	if err != nil {
+		t.Fatalf("parse wechat bill: %v", err)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %d", len(lines))
+	}
+	refund := lines[3]
+	if refund.LineType != model.SettlementLineRefund || refund.RefundNo != "RS0001" || !refund.Amount.Equal(decimal.NewFromInt(36)) ||
+		!refund.Fee.Equal(decimal.RequireFromString("-0.22")) || refund.LineKey != "R:4200000001:50300001" {
+		t.Fatalf("unexpected refund line: %+v", refund)
+	}
+
+	// 支付宝账单为 GBK 编码，字段带制表符
+	alipayCSV := "#支付宝业务明细查询\n#账号：[20881234567890120156]\n#-----------------------------------------业务明细列表----------------------------------------\n" +
+		"支付宝交易号,商户订单号,业务类型,商品名称,创建时间,完成时间,门店编号,门店名称,操作员,终端号,对方账户,订单金额（元）,商家实收（元）,支付宝红包（元）,集分宝（元）,支付宝优惠（元）,商家优惠（元）,券核销金额（元）,券名称,商家红包消费金额（元）,卡消费金额（元）,退款批次号/请求号,服务费（元）,分润（元）,备注\n" +
+		"2024010122001400000001\t,PA0001\t,交易,订单ORDA1,2024-01-01 09:00:00,2024-01-01 09:00:05,,,,,abc***@163.com,20.00,20.00,0.00,0.00,0.00,0.00,0.00,,0.00,0.00,,-0.12,0.00,\n" +
+		"2024010122001400000001\t,PA0001\t,退款,订单ORDA1,2024-01-01 10:00:00,2024-01-01 10:00:01,,,,,abc***@163.com,-5.00,-5.00,0.00,0.00,0.00,0.00,0.00,,0.00,0.00,RA0001\t,0.03,0.00,\n" +
+		"#-----------------------------------------业务明细列表结束------------------------------------\n#交易合计：1笔，商家实收共20.00元\n"
+	gbk, err := simplifiedchinese.GBK.NewEncoder().String(alipayCSV)
+	if err != nil {
+		t.Fatalf("encode gbk: %v", err)
+	}
+	alines, err := ParseAlipayBill([]byte(gbk))
+	if err != nil {
+		t.Fatalf("parse alipay bill: %v", err)
+	}
+	if len(alines) != 2 || alines[0].PaymentNo != "PA0001" || !alines[0].Fee.Equal(decimal.RequireFromString("0.12")) {
+		t.Fatalf("unexpected alipay lines: %+v", alines)
+	}
+	if alines[1].LineType != model.SettlementLineRefund || alines[1].RefundNo != "RA0001" || !alines[1].Amount.Equal(decimal.NewFromInt(5)) ||
+		!alines[1].Fee.Equal(decimal.RequireFromString("-0.03")) {
+		t.Fatalf("unexpected alipay refund line: %+v", alines[1])
+	}
+}
+
+func TestSettlementMatchReport(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:settlement_match?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.Order{}, &model.Payment{}, &model.Refund{}, &model.SettlementStatementLine{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	svc := &SettlementService{db: db}
+	if _, err := svc.ImportStatement(SettlementProviderWechat, []byte(testWechatBill), 1); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	// 重复导入覆盖，不产生重复明细
+	if _, err := svc.ImportStatement(SettlementProviderWechat, []byte(testWechatBill), 1); err != nil {
+		t.Fatalf("re-import: %v", err)
+	}
+	var n int64
+	db.Model(&model.SettlementStatementLine{}).Count(&n)
+	if n != 4 {
+		t.Fatalf("re-import should upsert, got %d lines", n)
+	}
+
+	at := func(h int) *time.Time {
+		v := time.Date(2024, 1, 1, h, 0, 0, 0, chinaZone())
+		return &v
+	}
+	for i, no := range []string{"ORDS1", "ORDS2", "ORDS3"} {
+		db.Create(&model.Order{OrderNo: no, UserID: 1, Status: 2, PayStatus: 2, PayAmount: decimal.NewFromInt(int64(10 * (i + 1)))})
+	}
+	p1 := model.Payment{OrderID: 1, PaymentNo: "PS0001", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(36), Status: 2, PaidAt: at(10)}
+	p2 := model.Payment{OrderID: 2, PaymentNo: "PS0002", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(25), Status: 2, PaidAt: at(11)}
+	p3 := model.Payment{OrderID: 3, PaymentNo: "PS0003", PaymentMethod: PayMethodWechat, Amount: decimal.NewFromInt(30), Status: 2, PaidAt: at(13)}
+	db.Create(&p1)
+	db.Create(&p2)
+	db.Create(&p3)
+	db.Create(&model.Refund{OrderID: 1, PaymentID: p1.ID, RefundNo: "RS0001", RefundAmount: decimal.NewFromInt(36), Status: model.RefundStatusSuccess, RefundedAt: at(15)})
+
+	report, err := svc.MatchReport(SettlementMatchQuery{
+		Provider: SettlementProviderWechat,
+		Start:    time.Date(2024, 1, 1, 0, 0, 0, 0, chinaZone()),
+		End:      time.Date(2024, 1, 2, 0, 0, 0, 0, chinaZone()),
+	})
+	if err != nil {
+		t.Fatalf("match: %v", err)
+	}
+	if report.MissingLocal != 1 || report.MissingProvider != 1 || report.AmountMismatch != 1 {
+		t.Fatalf("unexpected counts: %+v", report)
+	}
+	kinds := map[string]SettlementMatchRow{}
+	for _, r := range report.Rows {
+		kinds[r.Kind] = r
+	}
+	if kinds[SettlementMissingLocal].PaymentNo != "PX9999" || kinds[SettlementMissingProvider].PaymentNo != "PS0003" {
+		t.Fatalf("unexpected missing rows: %+v", report.Rows)
+	}
+	mm := kinds[SettlementAmountMismatch]
+	if mm.PaymentNo != "PS0002" || mm.OrderNo != "ORDS2" || !mm.DiffAmount.Equal(decimal.NewFromInt(-5)) {
+		t.Fatalf("unexpected mismatch row: %+v", mm)
+	}
+	total := report.Totals[0]
+	if !total.Fee.Equal(decimal.RequireFromString("0.17")) || !total.SettledAmount.Equal(decimal.RequireFromString("27.83")) {
+		t.Fatalf("unexpected totals: %+v", total)
+	}
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+)
+
+// 库存预占释放原因
+const (
+	StockReleaseCancel = "cancel"
+	StockReleaseExpire = "expire"
+	StockReleaseRefund = "refund"
+)
+
+// stockReserveTTL 未支付订单的库存预占时长，超时后由调度任务取消订单并释放
+func stockReserveTTL() time.Duration {
+	m := config.Config.Inventory.ReserveTTLMinutes
+	if m <= 0 {
+		m = 30
+	}
+	return time.Duration(m) * time.Minute
+}
+
+// sumActiveReserved 统计预占中的数量
+func sumActiveReserved(tx *gorm.DB, query string, args ...any) (int, error) {
+	var n int64
+	err := tx.Model(&model.StockReservation{}).
+		Where("status = ?", model.StockReservationActive).
+		Where(query, args...).
+		Select("COALESCE(SUM(quantity),0)").Scan(&n).Error
+	return int(n), err
+}
+
+// AvailableStock 可售库存 = 实物库存 - 预占中数量（不小于 0）
+// skuID 非空时按 SKU 计算，storeID 非 0 时按门店商品计算，否则按商品计算。
+func AvailableStock(tx *gorm.DB, onHand int, productID uint, skuID *uint, storeID uint) (int, error) {
+	var reserved int
+	var err error
+	switch {
+	case skuID != nil:
+		reserved, err = sumActiveReserved(tx, "sku_id = ?", *skuID)
+	case storeID != 0:
+		reserved, err = sumActiveReserved(tx, "store_id = ? AND product_id = ?", storeID, productID)
+	default:
+		reserved, err = sumActiveReserved(tx, "product_id = ?", productID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if avail := onHand - reserved; avail > 0 {
+		return avail, nil
+	}
+	return 0, nil
+}
+
+// stockChecker 下单时逐行校验可售库存，累计同一订单内已校验的数量，避免多行重复占用同一库存
+type stockChecker struct {
+	tx      *gorm.DB
+	product map[uint]int
+	sku     map[uint]int
+	store   map[[2]uint]int
+}
+
+func newStockChecker(tx *gorm.DB) *stockChecker {
+	return &stockChecker{tx: tx, product: map[uint]int{}, sku: map[uint]int{}, store: map[[2]uint]int{}}
+}
+
+// checkSku 调用方需已对 SKU 行加锁
+func (c *stockChecker) checkSku(sku model.ProductSku, qty int) error {
+	avail, err := AvailableStock(c.tx, sku.Stock, sku.ProductID, &sku.ID, 0)
+	if err != nil {
+		return fmt.Errorf("查询SKU库存失败: %w", err)
+	}
+	if avail-c.sku[sku.ID] < qty {
+		return fmt.Errorf("SKU库存不足: %s", sku.SkuName)
+	}
+	c.sku[sku.ID] += qty
+	return nil
+}
+
+// checkStore 调用方需已对门店商品行加锁
+func (c *stockChecker) checkStore(sp model.StoreProduct, qty int) error {
+	avail, err := AvailableStock(c.tx, sp.Stock, sp.ProductID, nil, sp.StoreID)
+	if err != nil {
+		return fmt.Errorf("查询门店库存失败: %w", err)
+	}
+	key := [2]uint{sp.StoreID, sp.ProductID}
+	if avail-c.store[key] < qty {
+		return fmt.Errorf("门店库存不足")
+	}
+	c.store[key] += qty
+	return nil
+}
+
+// checkProduct 调用方需已对商品行加锁
+func (c *stockChecker) checkProduct(prod model.Product, qty int) error {
+	avail, err := AvailableStock(c.tx, prod.Stock, prod.ID, nil, 0)
+	if err != nil {
+		return fmt.Errorf("查询商品库存失败: %w", err)
+	}
+	if avail-c.product[prod.ID] < qty {
+		return fmt.Errorf("商品库存不足: %s", prod.Name)
+	}
+	c.product[prod.ID] += qty
+	return nil
+}
+
+// reserveOrderStock 为订单明细写入库存预占记录（需在下单事务内、可售库存校验之后调用）
+func reserveOrderStock(tx *gorm.DB, order *model.Order, items []model.OrderItem, now time.Time) error {
+	if len(items) == 0 {
+		return nil
+	}
+	expiresAt := now.Add(stockReserveTTL())
+	resv := make([]model.StockReservation, 0, len(items))
+	for _, it := range items {
+		resv = append(resv, model.StockReservation{
+			OrderID:   order.ID,
+			ProductID: it.ProductID,
+			SkuID:     it.SkuID,
+			StoreID:   order.StoreID,
+			Quantity:  it.Quantity,
+			Status:    model.StockReservationActive,
+			ExpiresAt: expiresAt,
+		})
+	}
+	if err := tx.Create(&resv).Error; err != nil {
+		return fmt.Errorf("预占库存失败: %w", err)
+	}
+	return nil
+}
+
+// adjustStock 调整商品/SKU/门店商品实物库存；delta 为负时扣减且不低于 0
+// 支付提交发生在资金到账之后，不能因库存被后台调低而失败，因此扣减时按 0 截断。
+func adjustStock(tx *gorm.DB, productID uint, skuID *uint, storeID uint, delta int) error {
+	expr := gorm.Expr("stock + ?", delta)
+	if delta < 0 {
+		expr = gorm.Expr("CASE WHEN stock >= ? THEN stock - ? ELSE 0 END", -delta, -delta)
+	}
+	if skuID != nil {
+		if err := tx.Model(&model.ProductSku{}).Where("id = ?", *skuID).Update("stock", expr).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(&model.Product{}).Where("id = ?", productID).Update("stock", expr).Error; err != nil {
+		return err
+	}
+	if storeID != 0 {
+		if err := tx.Model(&model.StoreProduct{}).
+			Where("store_id = ? AND product_id = ?", storeID, productID).
+			Update("stock", expr).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitOrderStock 支付成功后提交预占：扣减实物库存并标记为已提交
+// 历史订单（下单时已直接扣减库存、无预占记录）无需处理。
+func commitOrderStock(tx *gorm.DB, orderID uint, now time.Time) error {
+	var list []model.StockReservation
+	if err := tx.Where("order_id = ? AND status = ?", orderID, model.StockReservationActive).Find(&list).Error; err != nil {
+		return err
+	}
+	for _, r := range list {
+		res := tx.Model(&model.StockReservation{}).
+			Where("id = ? AND status = ?", r.ID, model.StockReservationActive).
+			Updates(map[string]any{"status": model.StockReservationCommitted, "committed_at": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		if err := adjustStock(tx, r.ProductID, r.SkuID, r.StoreID, -r.Quantity); err != nil {
+			return fmt.Errorf("扣减库存失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// releaseOrderStock 释放订单库存，取消、超时、退款统一走这里：
+//   - 预占中的记录直接释放，可售库存随之恢复；
+//   - returnGoods 为 true 时（未发货退款/取消），已提交的扣减回补实物库存；
+//   - 历史订单无预占记录（下单时已扣减），returnGoods 为 true 时按订单明细回补。
+func releaseOrderStock(tx *gorm.DB, order *model.Order, reason string, returnGoods bool, now time.Time) error {
+	var list []model.StockReservation
+	if err := tx.Where("order_id = ?", order.ID).Find(&list).Error; err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		if !returnGoods {
+			return nil
+		}
+		var items []model.OrderItem
+		if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+			return err
+		}
+		for _, it := range items {
+			if err := adjustStock(tx, it.ProductID, it.SkuID, order.StoreID, it.Quantity); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, r := range list {
+		if r.Status == model.StockReservationReleased || (r.Status == model.StockReservationCommitted && !returnGoods) {
+			continue
+		}
+		// 先按原状态条件更新，避免并发重复释放导致重复回补
+		res := tx.Model(&model.StockReservation{}).Where("id = ? AND status = ?", r.ID, r.Status).
+			Updates(map[string]any{"status": model.StockReservationReleased, "released_at": now, "release_reason": reason})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 || r.Status != model.StockReservationCommitted {
+			continue
+		}
+		if err := adjustStock(tx, r.ProductID, r.SkuID, r.StoreID, r.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpireUnpaidOrders 取消库存预占已超时的待付款订单并释放库存，返回处理成功的订单数
+// 已支付但预占未提交的异常订单（如回调处理中断）直接补提交，不做取消。
+// 按最早超时时间依次处理；单笔订单失败时记录日志并跳过，汇总错误返回，不阻塞其余订单。
+func (s *OrderService) ExpireUnpaidOrders(now time.Time, limit int) (int, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	var orderIDs []uint
+	if err := s.db.Model(&model.StockReservation{}).
+		Where("status = ? AND expires_at <= ?", model.StockReservationActive, now).
+		Group("order_id").Order("MIN(expires_at), order_id").Limit(limit).Pluck("order_id", &orderIDs).Error; err != nil {
+		return 0, err
+	}
+	n := 0
+	var errs []error
+	for _, id := range orderIDs {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			var order model.Order
+			if err := tx.First(&order, id).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					// 订单已删除，仅释放预占
+					return tx.Model(&model.StockReservation{}).
+						Where("order_id = ? AND status = ?", id, model.StockReservationActive).
+						Updates(map[string]any{"status": model.StockReservationReleased, "released_at": now, "release_reason": StockReleaseExpire}).Error
+				}
+				return err
+			}
+			switch {
+			case order.Status == 1 && order.PayStatus == 1:
+				return cancelUnpaidOrder(tx, &order, "支付超时，系统自动取消", StockReleaseExpire, now)
+			case order.PayStatus == 2:
+				return commitOrderStock(tx, order.ID, now)
+			default:
+				return releaseOrderStock(tx, &order, StockReleaseExpire, false, now)
+			}
+		})
+		if err != nil {
+			zap.L().Warn("expire unpaid order failed", zap.Uint("order_id", id), zap.Error(err))
+			errs = append(errs, fmt.Errorf("处理超时订单 %d 失败: %w", id, err))
+			continue
+		}
+		n++
+	}
+	return n, errors.Join(errs...)
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+func setupStockReservationDB(t *testing.T) (*gorm.DB, *OrderService, model.Product) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:stock_resv?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.Product{}, &model.ProductSku{}, &model.Cart{}, &model.CartItem{},
+		&model.Order{}, &model.OrderItem{}, &model.StockReservation{}, &model.StoreSlotTemplate{}, &model.Payment{}, &model.Refund{},
+		&model.Wallet{}, &model.WalletTransaction{}, &model.PointsTransaction{}, &model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	prod := model.Product{Name: "铁观音", Price: decimal.NewFromInt(10), Stock: 3, Status: 1}
+	if err := db.Create(&prod).Error; err != nil {
+		t.Fatalf("create product: %v", err)
+	}
+	return db, &OrderService{db: db}, prod
+}
+
+func addToCart(t *testing.T, db *gorm.DB, userID, productID uint, qty int) {
+	t.Helper()
+	var cart model.Cart
+	if err := db.Where("user_id = ?", userID).FirstOrCreate(&cart, model.Cart{UserID: userID}).Error; err != nil {
+		t.Fatalf("cart: %v", err)
+	}
+	if err := db.Create(&model.CartItem{CartID: cart.ID, ProductID: productID, Quantity: qty}).Error; err != nil {
+		t.Fatalf("cart item: %v", err)
+	}
+}
+
+func reloadStock(t *testing.T, db *gorm.DB, id uint) int {
+	t.Helper()
+	var p model.Product
+	if err := db.First(&p, id).Error; err != nil {
+		t.Fatalf("reload product: %v", err)
+	}
+	return p.Stock
+}
+
+func TestStockReservation_ReserveCommitRelease(t *testing.T) {
+	db, svc, prod := setupStockReservationDB(t)
+
+	addToCart(t, db, 1, prod.ID, 2)
+	o1, err := svc.CreateOrderFromCart(1, 1, "", "", 0, 0, 1, nil, 0)
+	if err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	if got := reloadStock(t, db, prod.ID); got != 3 {
+		t.Fatalf("on-hand stock should be untouched on create, got %d", got)
+	}
+	if avail, _ := AvailableStock(db, 3, prod.ID, nil, 0); avail != 1 {
+		t.Fatalf("available stock should be 1, got %d", avail)
+	}
+
+	// 可售库存不足时下单失败
+	addToCart(t, db, 2, prod.ID, 2)
+	if _, err := svc.CreateOrderFromCart(2, 1, "", "", 0, 0, 1, nil, 0); err == nil {
+		t.Fatalf("order exceeding available stock should fail")
+	}
+
+	// 支付提交：扣减实物库存
+	if err := svc.MarkPaid(1, o1.ID); err != nil {
+		t.Fatalf("mark paid: %v", err)
+	}
+	if got := reloadStock(t, db, prod.ID); got != 1 {
+		t.Fatalf("stock should be 1 after commit, got %d", got)
+	}
+
+	// 取消待付款订单：释放预占，实物库存不变
+	db.Where("cart_id IN (?)", db.Model(&model.Cart{}).Select("id").Where("user_id = ?", 2)).Delete(&model.CartItem{})
+	addToCart(t, db, 2, prod.ID, 1)
+	o2, err := svc.CreateOrderFromCart(2, 1, "", "", 0, 0, 1, nil, 0)
+	if err != nil {
+		t.Fatalf("create second order: %v", err)
+	}
+	if err := svc.CancelOrder(2, o2.ID, "不想要了"); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if got := reloadStock(t, db, prod.ID); got != 1 {
+		t.Fatalf("cancel of unpaid order must not change on-hand stock, got %d", got)
+	}
+	if avail, _ := AvailableStock(db, 1, prod.ID, nil, 0); avail != 1 {
+		t.Fatalf("reservation should be released on cancel, available=%d", avail)
+	}
+
+	// 超时未支付：调度取消订单并释放
+	addToCart(t, db, 2, prod.ID, 1)
+	o3, err := svc.CreateOrderFromCart(2, 1, "", "", 0, 0, 1, nil, 0)
+	if err != nil {
+		t.Fatalf("create third order: %v", err)
+	}
+	// 更早超时且处理必然失败的异常订单（门店库存表缺失）：记录错误后跳过，不阻塞其余订单
+	bad := model.Order{OrderNo: "BADEXP", UserID: 9, Status: 2, PayStatus: 2, TotalAmount: decimal.NewFromInt(10), PayAmount: decimal.NewFromInt(10)}
+	db.Create(&bad)
+	db.Create(&model.StockReservation{OrderID: bad.ID, ProductID: prod.ID, StoreID: 5, Quantity: 1,
+		Status: model.StockReservationActive, ExpiresAt: time.Now().Add(-time.Hour)})
+	n, err := svc.ExpireUnpaidOrders(time.Now().Add(stockReserveTTL()+time.Minute), 10)
+	if n != 1 || err == nil || !strings.Contains(err.Error(), fmt.Sprintf("订单 %d ", bad.ID)) {
+		t.Fatalf("expire should skip the failing order and process 1, got %d err=%v", n, err)
+	}
+	var expired model.Order
+	db.First(&expired, o3.ID)
+	if expired.Status != 5 {
+		t.Fatalf("expired order should be cancelled, status=%d", expired.Status)
+	}
+
+	// 未发货退款：渠道退款成功后已提交的扣减回补
+	provider := &refundStubProvider{}
+	psvc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return provider, nil }}
+	if err := psvc.StartOrderRefund(o1.ID, "退款"); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	var rf model.Refund
+	db.Where("order_id = ?", o1.ID).First(&rf)
+	provider.notify = &PayNotifyResult{Kind: PayNotifyRefund, RefundNo: rf.RefundNo, RefundID: "5030000000000001", RefundStatus: "SUCCESS"}
+	if err := psvc.HandleProviderNotify(context.Background(), PayMethodWechat, nil, nil); err != nil {
+		t.Fatalf("refund notify: %v", err)
+	}
+	if got := reloadStock(t, db, prod.ID); got != 3 {
+		t.Fatalf("refund of unshipped order should restock, got %d", got)
+	}
+}
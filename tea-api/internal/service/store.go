@@ -14,6 +14,7 @@ import (
 
 	"tea-api/internal/model"
 	"tea-api/internal/service/commission"
+	"tea-api/internal/service/ledger"
 	"tea-api/pkg/database"
 	"tea-api/pkg/utils"
 )
@@ -239,6 +240,10 @@ func (s *StoreService) GetStore(id uint) (*model.Store, error) {
 //	总退款 = 已成功退款金额之和
 //	总提现 = 已申请且处理中/已完成的提现净额之和（actual_amount）
 //	可用余额 = max(总收入 - 总退款 - 总提现, 0)
+//
+// 期初补录已完成且门店已建立账本科目时，可用余额以账本为准：
+//
+//	可用余额 = max(门店待结算科目余额 - 处理中提现金额, 0)
 func (s *StoreService) GetStoreWalletSummary(storeID uint) (*StoreWalletSummary, error) {
 	if storeID == 0 {
 		return nil, errors.New("无效的门店ID")
@@ -276,6 +281,23 @@ func (s *StoreService) GetStoreWalletSummary(storeID uint) (*StoreWalletSummary,
 	}
 
 	available := paidRes.Total.Sub(refundRes.Total).Sub(wdRes.Total)
+	// 补录前首笔过账即会建立科目，但余额只含启用账本后的发生额，不能替代业务表口径
+	backfilled, err := ledger.BackfillDone(s.db)
+	if err != nil {
+		return nil, err
+	}
+	if cents, ok, err := ledger.AccountBalance(s.db, ledger.Store(storeID)); err != nil {
+		return nil, err
+	} else if ok && backfilled {
+		var processing struct{ Total decimal.Decimal }
+		if err := s.db.Model(&model.WithdrawRecord{}).
+			Where("store_id = ? AND status = ?", storeID, model.WithdrawStatusProcessing).
+			Select("COALESCE(SUM(amount), 0) AS total").
+			Scan(&processing).Error; err != nil {
+			return nil, err
+		}
+		available = decimal.New(cents, -2).Sub(processing.Total)
+	}
 	if available.IsNegative() {
 		available = decimal.NewFromInt(0)
 	}
@@ -0,0 +1,395 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/model"
+	"tea-api/pkg/database"
+)
+
+type TimeSlotService struct{ db *gorm.DB }
+
+func NewTimeSlotService() *TimeSlotService { return &TimeSlotService{db: database.GetDB()} }
+
+// SlotView 某一时段的容量与占用情况
+type SlotView struct {
+	TemplateID   uint      `json:"template_id"`
+	DeliveryType int       `json:"delivery_type"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Capacity     int       `json:"capacity"`
+	Reserved     int       `json:"reserved"`
+	Available    int       `json:"available"`
+	Bookable     bool      `json:"bookable"`
+}
+
+// SlotUtilization 门店某日时段利用率汇总
+type SlotUtilization struct {
+	StoreID       uint       `json:"store_id"`
+	Date          string     `json:"date"`
+	TotalCapacity int        `json:"total_capacity"`
+	TotalReserved int        `json:"total_reserved"`
+	Rate          float64    `json:"rate"` // 占用率（0-1）
+	Slots         []SlotView `json:"slots"`
+}
+
+// slotWindow 模板在某一天切分出的单个时段
+type slotWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// parseClock 将 HH:MM 解析为当天的分钟数
+func parseClock(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("非法的时间格式: %s", s)
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("非法的时间格式: %s", s)
+	}
+	return h*60 + m, nil
+}
+
+// weekdayMatched 判断模板的星期配置是否包含指定星期
+func weekdayMatched(weekdays string, wd time.Weekday) bool {
+	weekdays = strings.TrimSpace(weekdays)
+	if weekdays == "" {
+		return true
+	}
+	for _, p := range strings.Split(weekdays, ",") {
+		if v, err := strconv.Atoi(strings.TrimSpace(p)); err == nil && v == int(wd) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSlotTemplate 校验模板字段合法性
+func validateSlotTemplate(t *model.StoreSlotTemplate) error {
+	start, err := parseClock(t.StartTime)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(t.EndTime)
+	if err != nil {
+		return err
+	}
+	if end <= start {
+		return errors.New("结束时间必须晚于开始时间")
+	}
+	if t.SlotMinutes <= 0 || t.SlotMinutes > end-start {
+		return errors.New("时段长度非法")
+	}
+	if t.Capacity <= 0 {
+		return errors.New("时段容量必须大于0")
+	}
+	if t.CutoffMinutes < 0 || t.LeadMinutes < 0 {
+		return errors.New("截止时间与备货时间不能为负")
+	}
+	if t.DeliveryType != 0 && t.DeliveryType != 1 && t.DeliveryType != 2 {
+		return errors.New("非法的配送类型")
+	}
+	for _, p := range strings.Split(strings.TrimSpace(t.Weekdays), ",") {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+		if v, err := strconv.Atoi(strings.TrimSpace(p)); err != nil || v < 0 || v > 6 {
+			return errors.New("星期配置非法，应为 0-6 的逗号分隔列表")
+		}
+	}
+	return nil
+}
+
+// buildSlotWindows 按模板将某一天切分为时段；不足一个完整时段的尾部不生成
+func buildSlotWindows(t model.StoreSlotTemplate, day time.Time) []slotWindow {
+	if !weekdayMatched(t.Weekdays, day.Weekday()) || t.SlotMinutes <= 0 {
+		return nil
+	}
+	start, err := parseClock(t.StartTime)
+	if err != nil {
+		return nil
+	}
+	end, err := parseClock(t.EndTime)
+	if err != nil {
+		return nil
+	}
+	base := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	var out []slotWindow
+	for m := start; m+t.SlotMinutes <= end; m += t.SlotMinutes {
+		s := base.Add(time.Duration(m) * time.Minute)
+		out = append(out, slotWindow{Start: s, End: s.Add(time.Duration(t.SlotMinutes) * time.Minute)})
+	}
+	return out
+}
+
+// slotOpenAt 判断时段在 now 时刻是否仍可预约（同时满足截止时间与备货时间）
+func slotOpenAt(t model.StoreSlotTemplate, slotStart, now time.Time) bool {
+	if now.After(slotStart.Add(-time.Duration(t.CutoffMinutes) * time.Minute)) {
+		return false
+	}
+	return !now.Add(time.Duration(t.LeadMinutes) * time.Minute).After(slotStart)
+}
+
+// deliveryTypeMatched 模板配送类型为 0 时对自取与配送均生效
+func deliveryTypeMatched(t model.StoreSlotTemplate, deliveryType int) bool {
+	return t.DeliveryType == 0 || deliveryType == 0 || t.DeliveryType == deliveryType
+}
+
+// ListTemplates 列出门店时段模板
+func (s *TimeSlotService) ListTemplates(storeID uint) ([]model.StoreSlotTemplate, error) {
+	if storeID == 0 {
+		return nil, errors.New("无效的门店ID")
+	}
+	var list []model.StoreSlotTemplate
+	if err := s.db.Where("store_id = ?", storeID).Order("id ASC").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// CreateTemplate 新建门店时段模板
+func (s *TimeSlotService) CreateTemplate(t *model.StoreSlotTemplate) error {
+	if t == nil || t.StoreID == 0 {
+		return errors.New("无效的门店ID")
+	}
+	if t.Status == 0 {
+		t.Status = 1
+	}
+	if err := validateSlotTemplate(t); err != nil {
+		return err
+	}
+	if err := s.db.First(&model.Store{}, t.StoreID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("门店不存在")
+		}
+		return err
+	}
+	return s.db.Create(t).Error
+}
+
+// UpdateTemplate 更新门店时段模板；已产生的预约不受影响，新容量对后续预约生效
+func (s *TimeSlotService) UpdateTemplate(storeID, id uint, in model.StoreSlotTemplate) (*model.StoreSlotTemplate, error) {
+	var t model.StoreSlotTemplate
+	if err := s.db.Where("id = ? AND store_id = ?", id, storeID).First(&t).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("时段模板不存在")
+		}
+		return nil, err
+	}
+	t.DeliveryType = in.DeliveryType
+	t.Weekdays = in.Weekdays
+	t.StartTime = in.StartTime
+	t.EndTime = in.EndTime
+	t.SlotMinutes = in.SlotMinutes
+	t.Capacity = in.Capacity
+	t.CutoffMinutes = in.CutoffMinutes
+	t.LeadMinutes = in.LeadMinutes
+	if in.Status != 0 {
+		t.Status = in.Status
+	}
+	if err := validateSlotTemplate(&t); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteTemplate 删除门店时段模板
+func (s *TimeSlotService) DeleteTemplate(storeID, id uint) error {
+	res := s.db.Where("id = ? AND store_id = ?", id, storeID).Delete(&model.StoreSlotTemplate{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("时段模板不存在")
+	}
+	return nil
+}
+
+// collectSlots 汇总门店某日所有启用模板的时段及其占用
+func (s *TimeSlotService) collectSlots(storeID uint, day time.Time, deliveryType int, now time.Time) ([]SlotView, error) {
+	var tpls []model.StoreSlotTemplate
+	if err := s.db.Where("store_id = ? AND status = 1", storeID).Order("id ASC").Find(&tpls).Error; err != nil {
+		return nil, err
+	}
+	dayStart := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	var usages []model.StoreSlotUsage
+	if err := s.db.Where("store_id = ? AND slot_start >= ? AND slot_start < ?", storeID, dayStart, dayStart.AddDate(0, 0, 1)).
+		Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	reserved := make(map[string]int, len(usages))
+	for _, u := range usages {
+		reserved[fmt.Sprintf("%d-%d", u.TemplateID, u.SlotStart.Unix())] = u.Reserved
+	}
+
+	out := make([]SlotView, 0)
+	for _, t := range tpls {
+		if !deliveryTypeMatched(t, deliveryType) {
+			continue
+		}
+		for _, w := range buildSlotWindows(t, dayStart) {
+			r := reserved[fmt.Sprintf("%d-%d", t.ID, w.Start.Unix())]
+			avail := t.Capacity - r
+			if avail < 0 {
+				avail = 0
+			}
+			out = append(out, SlotView{
+				TemplateID:   t.ID,
+				DeliveryType: t.DeliveryType,
+				Start:        w.Start,
+				End:          w.End,
+				Capacity:     t.Capacity,
+				Reserved:     r,
+				Available:    avail,
+				Bookable:     avail > 0 && slotOpenAt(t, w.Start, now),
+			})
+		}
+	}
+	return out, nil
+}
+
+// AvailableSlots 查询门店某日可预约时段（仅返回仍可预约的时段）
+func (s *TimeSlotService) AvailableSlots(storeID uint, day time.Time, deliveryType int) ([]SlotView, error) {
+	if storeID == 0 {
+		return nil, errors.New("无效的门店ID")
+	}
+	all, err := s.collectSlots(storeID, day, deliveryType, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	out := make([]SlotView, 0, len(all))
+	for _, v := range all {
+		if v.Bookable {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+// Utilization 管理端查看门店某日全部时段的占用情况
+func (s *TimeSlotService) Utilization(storeID uint, day time.Time) (*SlotUtilization, error) {
+	if storeID == 0 {
+		return nil, errors.New("无效的门店ID")
+	}
+	slots, err := s.collectSlots(storeID, day, 0, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	res := &SlotUtilization{StoreID: storeID, Date: day.Format("2006-01-02"), Slots: slots}
+	for _, v := range slots {
+		res.TotalCapacity += v.Capacity
+		res.TotalReserved += v.Reserved
+	}
+	if res.TotalCapacity > 0 {
+		res.Rate = float64(res.TotalReserved) / float64(res.TotalCapacity)
+	}
+	return res, nil
+}
+
+// reserveOrderSlot 在下单事务中为订单占用所选时段
+// 门店未配置任何启用模板时不做时段控制；否则所选时间必须落在某个模板时段的起点且仍可预约。
+func reserveOrderSlot(tx *gorm.DB, order *model.Order, now time.Time) error {
+	if order.StoreID == 0 || order.DeliveryTime == nil {
+		return nil
+	}
+	var tpls []model.StoreSlotTemplate
+	if err := tx.Where("store_id = ? AND status = 1", order.StoreID).Order("id ASC").Find(&tpls).Error; err != nil {
+		return fmt.Errorf("查询门店时段模板失败: %w", err)
+	}
+	if len(tpls) == 0 {
+		return nil
+	}
+
+	want := order.DeliveryTime.In(time.Local)
+	var (
+		matched *model.StoreSlotTemplate
+		window  slotWindow
+	)
+	for i := range tpls {
+		if !deliveryTypeMatched(tpls[i], order.DeliveryType) {
+			continue
+		}
+		for _, w := range buildSlotWindows(tpls[i], want) {
+			if w.Start.Equal(want) {
+				matched, window = &tpls[i], w
+				break
+			}
+		}
+		if matched != nil {
+			break
+		}
+	}
+	if matched == nil {
+		return errors.New("所选时间不在门店可预约时段内")
+	}
+	if !slotOpenAt(*matched, window.Start, now) {
+		return errors.New("所选时段已截止预约")
+	}
+
+	usage := model.StoreSlotUsage{StoreID: order.StoreID, TemplateID: matched.ID, SlotStart: window.Start, Capacity: matched.Capacity}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&usage).Error; err != nil {
+		return fmt.Errorf("初始化时段占用失败: %w", err)
+	}
+	// 条件更新保证并发下不会超过容量
+	res := tx.Model(&model.StoreSlotUsage{}).
+		Where("store_id = ? AND template_id = ? AND slot_start = ? AND reserved < ?", order.StoreID, matched.ID, window.Start, matched.Capacity).
+		Updates(map[string]any{"reserved": gorm.Expr("reserved + 1"), "capacity": matched.Capacity})
+	if res.Error != nil {
+		return fmt.Errorf("占用时段失败: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("所选时段已约满")
+	}
+
+	rsv := model.StoreSlotReservation{
+		OrderID:    order.ID,
+		StoreID:    order.StoreID,
+		TemplateID: matched.ID,
+		SlotStart:  window.Start,
+		SlotEnd:    window.End,
+		Status:     model.SlotReservationActive,
+	}
+	if err := tx.Create(&rsv).Error; err != nil {
+		return fmt.Errorf("创建时段预约失败: %w", err)
+	}
+	return nil
+}
+
+// releaseOrderSlot 释放订单占用的时段（无预约或已释放时直接返回）
+func releaseOrderSlot(db *gorm.DB, orderID uint) error {
+	var rsv model.StoreSlotReservation
+	if err := db.Where("order_id = ? AND status = ?", orderID, model.SlotReservationActive).First(&rsv).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) || isMissingTableError(err) {
+			return nil
+		}
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.Model(&model.StoreSlotReservation{}).
+			Where("id = ? AND status = ?", rsv.ID, model.SlotReservationActive).
+			Updates(map[string]any{"status": model.SlotReservationReleased, "released_at": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		return tx.Model(&model.StoreSlotUsage{}).
+			Where("store_id = ? AND template_id = ? AND slot_start = ? AND reserved > 0", rsv.StoreID, rsv.TemplateID, rsv.SlotStart).
+			Update("reserved", gorm.Expr("reserved - 1")).Error
+	})
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+func TestBuildSlotWindows_SplitsWindowAndSkipsPartialTail(t *testing.T) {
+	tpl := model.StoreSlotTemplate{StartTime: "10:00", EndTime: "11:45", SlotMinutes: 30, Capacity: 5}
+	day := time.Date(2025, 3, 3, 8, 0, 0, 0, time.Local) // 周一
+	ws := buildSlotWindows(tpl, day)
+	if len(ws) != 3 {
+		t.Fatalf("expected 3 slots, got %d", len(ws))
+	}
+	if ws[0].Start.Hour() != 10 || ws[0].Start.Minute() != 0 {
+		t.Fatalf("unexpected first slot start: %v", ws[0].Start)
+	}
+	if got := ws[2].End.Format("15:04"); got != "11:30" {
+		t.Fatalf("unexpected last slot end: %s", got)
+	}
+}
+
+func TestBuildSlotWindows_WeekdayFilter(t *testing.T) {
+	tpl := model.StoreSlotTemplate{Weekdays: "1,2,3,4,5", StartTime: "10:00", EndTime: "12:00", SlotMinutes: 60, Capacity: 5}
+	sunday := time.Date(2025, 3, 2, 0, 0, 0, 0, time.Local)
+	if ws := buildSlotWindows(tpl, sunday); len(ws) != 0 {
+		t.Fatalf("expected no slots on sunday, got %d", len(ws))
+	}
+	monday := sunday.AddDate(0, 0, 1)
+	if ws := buildSlotWindows(tpl, monday); len(ws) != 2 {
+		t.Fatalf("expected 2 slots on monday, got %d", len(ws))
+	}
+}
+
+func TestSlotOpenAt_CutoffAndLead(t *testing.T) {
+	tpl := model.StoreSlotTemplate{CutoffMinutes: 15, LeadMinutes: 30}
+	start := time.Date(2025, 3, 3, 10, 0, 0, 0, time.Local)
+	if !slotOpenAt(tpl, start, start.Add(-31*time.Minute)) {
+		t.Fatalf("slot should be open 31 minutes ahead")
+	}
+	if slotOpenAt(tpl, start, start.Add(-20*time.Minute)) {
+		t.Fatalf("slot should be closed inside lead time")
+	}
+	tpl.LeadMinutes = 0
+	if slotOpenAt(tpl, start, start.Add(-10*time.Minute)) {
+		t.Fatalf("slot should be closed after cutoff")
+	}
+}
+
+func TestValidateSlotTemplate(t *testing.T) {
+	ok := model.StoreSlotTemplate{StartTime: "09:00", EndTime: "21:00", SlotMinutes: 30, Capacity: 10, Weekdays: "0,6"}
+	if err := validateSlotTemplate(&ok); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bad := []model.StoreSlotTemplate{
+		{StartTime: "21:00", EndTime: "09:00", SlotMinutes: 30, Capacity: 10},
+		{StartTime: "09:00", EndTime: "21:00", SlotMinutes: 0, Capacity: 10},
+		{StartTime: "09:00", EndTime: "21:00", SlotMinutes: 30, Capacity: 0},
+		{StartTime: "9am", EndTime: "21:00", SlotMinutes: 30, Capacity: 10},
+		{StartTime: "09:00", EndTime: "21:00", SlotMinutes: 30, Capacity: 10, Weekdays: "7"},
+	}
+	for i := range bad {
+		if err := validateSlotTemplate(&bad[i]); err == nil {
+			t.Fatalf("case %d: expected validation error", i)
+		}
+	}
+}
+
+// setupSlotDB 使用临时文件库，并发事务按写锁串行执行
+func setupSlotDB(t *testing.T, capacity int) (*gorm.DB, model.StoreSlotTemplate) {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "slot.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.StoreSlotTemplate{}, &model.StoreSlotUsage{}, &model.StoreSlotReservation{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	tpl := model.StoreSlotTemplate{StoreID: 1, StartTime: "10:00", EndTime: "12:00", SlotMinutes: 60, Capacity: capacity, Status: 1}
+	if err := db.Create(&tpl).Error; err != nil {
+		t.Fatalf("create template: %v", err)
+	}
+	return db, tpl
+}
+
+// slotOrder 构造预约明天 10:00 时段的订单
+func slotOrder(id uint) *model.Order {
+	now := time.Now()
+	at := time.Date(now.Year(), now.Month(), now.Day(), 10, 0, 0, 0, time.Local).AddDate(0, 0, 1)
+	return &model.Order{BaseModel: model.BaseModel{ID: id}, StoreID: 1, DeliveryTime: &at}
+}
+
+func slotReserved(t *testing.T, db *gorm.DB) int {
+	t.Helper()
+	var u model.StoreSlotUsage
+	if err := db.First(&u).Error; err != nil {
+		t.Fatalf("load usage: %v", err)
+	}
+	return u.Reserved
+}
+
+func TestReserveOrderSlot_CapacityAndRelease(t *testing.T) {
+	db, _ := setupSlotDB(t, 2)
+	now := time.Now()
+
+	for id := uint(1); id <= 2; id++ {
+		if err := db.Transaction(func(tx *gorm.DB) error { return reserveOrderSlot(tx, slotOrder(id), now) }); err != nil {
+			t.Fatalf("reserve order %d: %v", id, err)
+		}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error { return reserveOrderSlot(tx, slotOrder(3), now) })
+	if err == nil || err.Error() != "所选时段已约满" {
+		t.Fatalf("third reservation should be rejected as full, got %v", err)
+	}
+	if got := slotReserved(t, db); got != 2 {
+		t.Fatalf("reserved = %d, want 2", got)
+	}
+
+	// 释放后名额可再次预约；重复释放不重复扣减
+	if err := releaseOrderSlot(db, 1); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if err := releaseOrderSlot(db, 1); err != nil {
+		t.Fatalf("release again: %v", err)
+	}
+	if got := slotReserved(t, db); got != 1 {
+		t.Fatalf("reserved after release = %d, want 1", got)
+	}
+	var rsv model.StoreSlotReservation
+	db.Where("order_id = ?", 1).First(&rsv)
+	if rsv.Status != model.SlotReservationReleased || rsv.ReleasedAt == nil {
+		t.Fatalf("reservation should be released: %+v", rsv)
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error { return reserveOrderSlot(tx, slotOrder(3), now) }); err != nil {
+		t.Fatalf("reserve after release: %v", err)
+	}
+	if got := slotReserved(t, db); got != 2 {
+		t.Fatalf("reserved = %d, want 2", got)
+	}
+}
+
+func TestReserveOrderSlot_ConcurrentNeverExceedsCapacity(t *testing.T) {
+	db, _ := setupSlotDB(t, 3)
+	now := time.Now()
+
+	const n = 10
+	var (
+		wg   sync.WaitGroup
+		ok   int32
+		full int32
+	)
+	for i := 1; i <= n; i++ {
+		wg.Add(1)
+		go func(id uint) {
+			defer wg.Done()
+			err := db.Transaction(func(tx *gorm.DB) error { return reserveOrderSlot(tx, slotOrder(id), now) })
+			switch {
+			case err == nil:
+				atomic.AddInt32(&ok, 1)
+			case err.Error() == "所选时段已约满":
+				atomic.AddInt32(&full, 1)
+			default:
+				t.Errorf("order %d: %v", id, err)
+			}
+		}(uint(i))
+	}
+	wg.Wait()
+	if ok != 3 || full != n-3 {
+		t.Fatalf("succeeded=%d full=%d, want 3/%d", ok, full, n-3)
+	}
+	if got := slotReserved(t, db); got != 3 {
+		t.Fatalf("reserved = %d, want 3", got)
+	}
+	var active int64
+	db.Model(&model.StoreSlotReservation{}).Where("status = ?", model.SlotReservationActive).Count(&active)
+	if active != 3 {
+		t.Fatalf("active reservations = %d, want 3", active)
+	}
+}
@@ -101,6 +101,7 @@ func (s *UserService) Login(code string) (*LoginResponse, error) {
 			if err := s.db.Create(&user).Error; err != nil {
 				return nil, err
 			}
+			GrantSignupPoints(s.db, user.ID)
 		} else {
 			return nil, err
 		}
@@ -183,6 +184,7 @@ func (s *UserService) LoginByOpenID(openID string) (*LoginResponse, error) {
 			if err := s.db.Create(&user).Error; err != nil {
 				return nil, err
 			}
+			GrantSignupPoints(s.db, user.ID)
 		} else {
 			return nil, err
 		}
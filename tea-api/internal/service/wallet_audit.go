@@ -0,0 +1,349 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service/ledger"
+	"tea-api/pkg/database"
+)
+
+// 巡检触发方式
+const (
+	WalletAuditTriggerSchedule = "schedule"
+	WalletAuditTriggerManual   = "manual"
+)
+
+var walletAuditKindLabel = map[string]string{
+	model.WalletAuditGap:             "流水缺少 balance_after",
+	model.WalletAuditChainBreak:      "余额链断裂",
+	model.WalletAuditNegative:        "余额为负",
+	model.WalletAuditBalanceMismatch: "流水与钱包余额不一致",
+	model.WalletAuditLedgerMismatch:  "钱包与账本余额不一致",
+	model.WalletAuditFrozenMismatch:  "冻结余额与账本不一致",
+	model.WalletAuditFrozenReplay:    "冻结流水与冻结余额不一致",
+}
+
+// walletFreezeTxTypes 可用与冻结之间划转的流水类型：冻结余额变动为流水金额的相反数
+var walletFreezeTxTypes = map[string]bool{
+	"freeze":                          true,
+	"unfreeze":                        true,
+	"withdraw_freeze":                 true,
+	"withdraw_reject_unfreeze":        true,
+	"withdraw_transfer_fail_unfreeze": true,
+}
+
+// walletFrozenDelta 单条流水对冻结余额的影响：划转类取流水金额的相反数；
+// 提现完成流水金额为 0，冻结扣减金额取备注中的 amount_cents
+func walletFrozenDelta(t model.WalletTransaction) int64 {
+	if walletFreezeTxTypes[t.Type] {
+		return -t.Amount
+	}
+	if t.Type == "withdraw_paid" {
+		var rmk struct {
+			AmountCents int64 `json:"amount_cents"`
+		}
+		_ = json.Unmarshal([]byte(t.Remark), &rmk)
+		return -rmk.AmountCents
+	}
+	return 0
+}
+
+// WalletAuditService 钱包余额巡检：按用户重放钱包流水，核对 balance_after 链、wallets 余额与账本科目
+type WalletAuditService struct{ db *gorm.DB }
+
+func NewWalletAuditService() *WalletAuditService { return &WalletAuditService{db: database.GetDB()} }
+
+// WalletAuditOptions 巡检参数
+type WalletAuditOptions struct {
+	Trigger     string
+	UserID      uint // 非 0 时仅巡检该用户
+	OpenTickets bool // 新发现的问题是否自动创建工单
+	OperatorID  *uint
+}
+
+// walletAuditIssue 单个用户重放时检出的问题
+type walletAuditIssue struct {
+	Kind     string
+	TxID     uint
+	Expected int64
+	Actual   int64
+	Detail   string
+}
+
+// Run 执行一次巡检：逐用户重放流水并写入发现，已存在的问题只刷新不重复上报；
+// 本次未再检出的待处理问题自动标记为已解决（单用户巡检只处理该用户）。
+func (s *WalletAuditService) Run(opts WalletAuditOptions) (*model.WalletAuditRun, error) {
+	if opts.Trigger == "" {
+		opts.Trigger = WalletAuditTriggerManual
+	}
+	run := &model.WalletAuditRun{Trigger: opts.Trigger, Status: "running", StartedAt: time.Now(), OperatorID: opts.OperatorID}
+	if opts.UserID != 0 {
+		uid := opts.UserID
+		run.UserID = &uid
+	}
+	if err := s.db.Create(run).Error; err != nil {
+		return nil, err
+	}
+
+	err := s.run(run, opts)
+	now := time.Now()
+	run.FinishedAt = &now
+	run.Status = "success"
+	if err != nil {
+		run.Status = "failed"
+		run.Error = truncateText(err.Error(), 500)
+	}
+	if e := s.db.Save(run).Error; e != nil && err == nil {
+		err = e
+	}
+	return run, err
+}
+
+func (s *WalletAuditService) run(run *model.WalletAuditRun, opts WalletAuditOptions) error {
+	userIDs, err := s.auditUserIDs(opts.UserID)
+	if err != nil {
+		return err
+	}
+	useLedger := s.db.Migrator().HasTable(&model.LedgerAccount{})
+	for _, uid := range userIDs {
+		issues, txCount, err := s.auditUser(uid, useLedger)
+		if err != nil {
+			return fmt.Errorf("audit user %d: %w", uid, err)
+		}
+		run.UsersChecked++
+		run.TxChecked += txCount
+		for _, is := range issues {
+			f, created, err := s.saveFinding(run.ID, uid, is)
+			if err != nil {
+				return err
+			}
+			if f.Status == model.WalletAuditFindingIgnored {
+				continue
+			}
+			run.FindingCount++
+			if !created {
+				continue
+			}
+			run.NewFindings++
+			if opts.OpenTickets {
+				if err := s.openTicket(f); err != nil {
+					return err
+				}
+				run.TicketsOpened++
+			}
+		}
+	}
+
+	q := s.db.Model(&model.WalletAuditFinding{}).
+		Where("status = ? AND last_run_id <> ?", model.WalletAuditFindingOpen, run.ID)
+	if opts.UserID != 0 {
+		q = q.Where("user_id = ?", opts.UserID)
+	}
+	res := q.Updates(map[string]any{"status": model.WalletAuditFindingResolved, "resolved_at": time.Now()})
+	if res.Error != nil {
+		return res.Error
+	}
+	run.Resolved = int(res.RowsAffected)
+	return nil
+}
+
+// auditUserIDs 巡检范围：有钱包或有钱包流水的用户
+func (s *WalletAuditService) auditUserIDs(userID uint) ([]uint, error) {
+	if userID != 0 {
+		return []uint{userID}, nil
+	}
+	var fromWallets, fromTx []uint
+	if err := s.db.Table("wallets").Distinct("user_id").Pluck("user_id", &fromWallets).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(&model.WalletTransaction{}).Distinct("user_id").Pluck("user_id", &fromTx).Error; err != nil {
+		return nil, err
+	}
+	seen := make(map[uint]struct{}, len(fromWallets)+len(fromTx))
+	ids := make([]uint, 0, len(fromWallets)+len(fromTx))
+	for _, id := range append(fromWallets, fromTx...) {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// auditUser 按流水 ID 顺序重放单个用户的钱包流水：
+//   - balance_after 为空记为缺口（同一用户合并为一条），链按重放值续接；
+//   - 上一条 balance_after + 本条金额与本条 balance_after 不符记为断链，链按记录值续接；
+//   - 重放或记录余额首次为负记为负余额；
+//   - 重放合计与 wallets.balance 比对，冻结/解冻/提现完成流水重放合计与 wallets.frozen 比对；
+//   - 用户已纳入账本时再与可用/冻结科目比对。
+func (s *WalletAuditService) auditUser(uid uint, useLedger bool) ([]walletAuditIssue, int, error) {
+	var txs []model.WalletTransaction
+	if err := s.db.Select("id", "type", "amount", "balance_after", "remark").
+		Where("user_id = ?", uid).Order("id asc").Find(&txs).Error; err != nil {
+		return nil, 0, err
+	}
+	var wallet struct {
+		Balance int64
+		Frozen  int64
+	}
+	if err := s.db.Table("wallets").Select("balance, frozen").Where("user_id = ?", uid).Limit(1).Scan(&wallet).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var issues []walletAuditIssue
+	var replay, prev, frozenReplay int64
+	var gapCount int
+	var firstGap *walletAuditIssue
+	negative := false
+	for _, t := range txs {
+		replay += t.Amount
+		frozenReplay += walletFrozenDelta(t)
+		expected := prev + t.Amount
+		if t.BalanceAfter == nil {
+			gapCount++
+			if firstGap == nil {
+				firstGap = &walletAuditIssue{Kind: model.WalletAuditGap, TxID: t.ID, Expected: expected}
+			}
+			prev = expected
+		} else {
+			if *t.BalanceAfter != expected {
+				issues = append(issues, walletAuditIssue{
+					Kind: model.WalletAuditChainBreak, TxID: t.ID, Expected: expected, Actual: *t.BalanceAfter,
+					Detail: fmt.Sprintf("流水 #%d（%s，金额 %d）前余额 %d，应为 %d，记录为 %d", t.ID, t.Type, t.Amount, expected-t.Amount, expected, *t.BalanceAfter),
+				})
+			}
+			prev = *t.BalanceAfter
+		}
+		if !negative && (replay < 0 || prev < 0) {
+			negative = true
+			issues = append(issues, walletAuditIssue{
+				Kind: model.WalletAuditNegative, TxID: t.ID, Actual: min(replay, prev),
+				Detail: fmt.Sprintf("流水 #%d（%s）后余额为负：重放 %d，记录 %d", t.ID, t.Type, replay, prev),
+			})
+		}
+	}
+	if firstGap != nil {
+		firstGap.Detail = fmt.Sprintf("共 %d 条流水缺少 balance_after，首条为 #%d", gapCount, firstGap.TxID)
+		issues = append(issues, *firstGap)
+	}
+	if wallet.Balance < 0 || wallet.Frozen < 0 {
+		issues = append(issues, walletAuditIssue{
+			Kind: model.WalletAuditNegative, Actual: min(wallet.Balance, wallet.Frozen),
+			Detail: fmt.Sprintf("钱包余额为负：可用 %d，冻结 %d", wallet.Balance, wallet.Frozen),
+		})
+	}
+	if replay != wallet.Balance {
+		issues = append(issues, walletAuditIssue{
+			Kind: model.WalletAuditBalanceMismatch, Expected: replay, Actual: wallet.Balance,
+			Detail: fmt.Sprintf("%d 条流水重放余额 %d，wallets.balance 为 %d", len(txs), replay, wallet.Balance),
+		})
+	}
+
+	if frozenReplay != wallet.Frozen {
+		issues = append(issues, walletAuditIssue{
+			Kind: model.WalletAuditFrozenReplay, Expected: frozenReplay, Actual: wallet.Frozen,
+			Detail: fmt.Sprintf("冻结类流水重放冻结余额 %d，wallets.frozen 为 %d", frozenReplay, wallet.Frozen),
+		})
+	}
+
+	if useLedger {
+		bal, ok, err := ledger.AccountBalance(s.db, ledger.UserWallet(uid))
+		if err != nil {
+			return nil, 0, err
+		}
+		// 未纳入账本的用户（尚未补录期初）不做账本比对
+		if ok {
+			if bal != wallet.Balance {
+				issues = append(issues, walletAuditIssue{
+					Kind: model.WalletAuditLedgerMismatch, Expected: bal, Actual: wallet.Balance,
+					Detail: fmt.Sprintf("账本可用余额 %d，wallets.balance 为 %d", bal, wallet.Balance),
+				})
+			}
+			frozen, _, err := ledger.AccountBalance(s.db, ledger.UserFrozen(uid))
+			if err != nil {
+				return nil, 0, err
+			}
+			if frozen != wallet.Frozen {
+				issues = append(issues, walletAuditIssue{
+					Kind: model.WalletAuditFrozenMismatch, Expected: frozen, Actual: wallet.Frozen,
+					Detail: fmt.Sprintf("账本冻结余额 %d，wallets.frozen 为 %d", frozen, wallet.Frozen),
+				})
+			}
+		}
+	}
+	return issues, len(txs), nil
+}
+
+// saveFinding 按 用户+类型+流水 去重：已有待处理/已忽略的发现只刷新批次与金额，否则新建
+func (s *WalletAuditService) saveFinding(runID, uid uint, is walletAuditIssue) (*model.WalletAuditFinding, bool, error) {
+	var f model.WalletAuditFinding
+	if err := s.db.Where("user_id = ? AND kind = ? AND tx_id = ? AND status IN ?", uid, is.Kind, is.TxID,
+		[]string{model.WalletAuditFindingOpen, model.WalletAuditFindingIgnored}).
+		Order("id desc").Limit(1).Find(&f).Error; err != nil {
+		return nil, false, err
+	}
+	if f.ID != 0 {
+		f.LastRunID = runID
+		f.Expected, f.Actual, f.Detail = is.Expected, is.Actual, truncateText(is.Detail, 500)
+		err := s.db.Model(&f).Updates(map[string]any{
+			"last_run_id": f.LastRunID, "expected": f.Expected, "actual": f.Actual, "detail": f.Detail,
+		}).Error
+		return &f, false, err
+	}
+	f = model.WalletAuditFinding{
+		RunID: runID, LastRunID: runID, UserID: uid, Kind: is.Kind, TxID: is.TxID,
+		Expected: is.Expected, Actual: is.Actual, Detail: truncateText(is.Detail, 500),
+		Status: model.WalletAuditFindingOpen,
+	}
+	if err := s.db.Create(&f).Error; err != nil {
+		return nil, false, err
+	}
+	return &f, true, nil
+}
+
+// openTicket 为新发现创建高优先级工单并回写工单ID
+func (s *WalletAuditService) openTicket(f *model.WalletAuditFinding) error {
+	uid := f.UserID
+	t := &model.Ticket{
+		Type:     "other",
+		Source:   "system",
+		UserID:   &uid,
+		Title:    fmt.Sprintf("钱包巡检异常：用户%d %s", f.UserID, walletAuditKindLabel[f.Kind]),
+		Content:  fmt.Sprintf("巡检批次 #%d 发现 #%d：%s（金额单位：分）", f.RunID, f.ID, f.Detail),
+		Priority: "high",
+	}
+	if err := (&TicketService{db: s.db}).CreateTicket(t); err != nil {
+		return err
+	}
+	f.TicketID = &t.ID
+	return s.db.Model(f).Update("ticket_id", t.ID).Error
+}
+
+// IgnoreFinding 人工忽略待处理的发现（如已知的历史数据问题），后续巡检不再重复上报
+func (s *WalletAuditService) IgnoreFinding(id uint, note string) (*model.WalletAuditFinding, error) {
+	var f model.WalletAuditFinding
+	if err := s.db.First(&f, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("巡检记录不存在")
+		}
+		return nil, err
+	}
+	if f.Status != model.WalletAuditFindingOpen {
+		return nil, errors.New("仅待处理的巡检记录可忽略")
+	}
+	f.Status = model.WalletAuditFindingIgnored
+	f.Note = truncateText(note, 255)
+	if err := s.db.Model(&f).Updates(map[string]any{"status": f.Status, "note": f.Note}).Error; err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
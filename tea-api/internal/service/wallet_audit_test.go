@@ -0,0 +1,167 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+func findingsByKind(t *testing.T, db *gorm.DB, userID uint) map[string]model.WalletAuditFinding {
+	t.Helper()
+	var list []model.WalletAuditFinding
+	if err := db.Where("user_id = ?", userID).Find(&list).Error; err != nil {
+		t.Fatalf("list findings: %v", err)
+	}
+	out := map[string]model.WalletAuditFinding{}
+	for _, f := range list {
+		out[f.Kind] = f
+	}
+	return out
+}
+
+func TestWalletAudit_ReplayChainAndDedupe(t *testing.T) {
+	db := setupLedgerFlowDB(t, "wallet_audit")
+	if err := db.AutoMigrate(&model.WalletAuditRun{}, &model.WalletAuditFinding{}, &model.Ticket{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	svc := &WalletAuditService{db: db}
+
+	// 正常用户：流水经账本过账，链完整
+	clean := model.User{Phone: "13800000031", Nickname: "clean", OpenID: "openid-clean"}
+	db.Create(&clean)
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := AdjustWalletTx(tx, clean.ID, 1000, 0, "admin_credit", "充值"); err != nil {
+			return err
+		}
+		_, err := AdjustWalletTx(tx, clean.ID, -300, 300, "withdraw_freeze", "提现冻结")
+		return err
+	}); err != nil {
+		t.Fatalf("seed clean wallet: %v", err)
+	}
+
+	// 历史用户：缺 balance_after、断链，且钱包余额与流水重放不一致
+	legacy := model.User{Phone: "13800000032", Nickname: "legacy", OpenID: "openid-legacy-audit"}
+	db.Create(&legacy)
+	after := func(v int64) *int64 { return &v }
+	db.Create(&model.Wallet{UserID: legacy.ID, Balance: 500})
+	db.Create(&model.WalletTransaction{UserID: legacy.ID, Type: "recharge", Amount: 300, BalanceAfter: after(300)})
+	db.Create(&model.WalletTransaction{UserID: legacy.ID, Type: "admin_credit", Amount: 200})
+	breakTx := model.WalletTransaction{UserID: legacy.ID, Type: "admin_debit", Amount: -100, BalanceAfter: after(999)}
+	db.Create(&breakTx)
+
+	// 透支用户：记录余额为负
+	overdrawn := model.User{Phone: "13800000033", Nickname: "overdrawn", OpenID: "openid-overdrawn"}
+	db.Create(&overdrawn)
+	db.Create(&model.Wallet{UserID: overdrawn.ID, Balance: -50})
+	db.Create(&model.WalletTransaction{UserID: overdrawn.ID, Type: "order_pay", Amount: -50, BalanceAfter: after(-50)})
+
+	run, err := svc.Run(WalletAuditOptions{OpenTickets: true})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if run.Status != "success" || run.UsersChecked != 3 || run.TxChecked != 6 {
+		t.Fatalf("unexpected run: %+v", run)
+	}
+	if got := findingsByKind(t, db, clean.ID); len(got) != 0 {
+		t.Fatalf("clean wallet should have no findings, got %+v", got)
+	}
+	lf := findingsByKind(t, db, legacy.ID)
+	if len(lf) != 3 {
+		t.Fatalf("legacy should have gap/chain_break/balance_mismatch, got %+v", lf)
+	}
+	if f := lf[model.WalletAuditChainBreak]; f.TxID != breakTx.ID || f.Expected != 400 || f.Actual != 999 {
+		t.Fatalf("chain break: %+v", f)
+	}
+	if f := lf[model.WalletAuditBalanceMismatch]; f.Expected != 400 || f.Actual != 500 {
+		t.Fatalf("balance mismatch: %+v", f)
+	}
+	if _, ok := findingsByKind(t, db, overdrawn.ID)[model.WalletAuditNegative]; !ok {
+		t.Fatalf("overdrawn user should be flagged negative")
+	}
+	var tickets int64
+	db.Model(&model.Ticket{}).Where("source = ? AND priority = ?", "system", "high").Count(&tickets)
+	if int(tickets) != run.NewFindings || run.TicketsOpened != run.NewFindings || tickets == 0 {
+		t.Fatalf("each new finding should open a ticket: tickets=%d run=%+v", tickets, run)
+	}
+
+	// 复检：已有问题不重复上报；修正后的问题自动解决；忽略的问题不再出现
+	if _, err := svc.IgnoreFinding(lf[model.WalletAuditGap].ID, "历史数据"); err != nil {
+		t.Fatalf("ignore: %v", err)
+	}
+	db.Table("wallets").Where("user_id = ?", legacy.ID).Update("balance", 400)
+	db.Table("wallets").Where("user_id = ?", clean.ID).Update("frozen", 200)
+	again, err := svc.Run(WalletAuditOptions{OpenTickets: true})
+	if err != nil {
+		t.Fatalf("rerun: %v", err)
+	}
+	if again.NewFindings != 2 || again.Resolved != 1 {
+		t.Fatalf("rerun should add only frozen mismatches and resolve balance mismatch: %+v", again)
+	}
+	lf = findingsByKind(t, db, legacy.ID)
+	if lf[model.WalletAuditBalanceMismatch].Status != model.WalletAuditFindingResolved ||
+		lf[model.WalletAuditGap].Status != model.WalletAuditFindingIgnored ||
+		lf[model.WalletAuditChainBreak].LastRunID != again.ID {
+		t.Fatalf("unexpected legacy findings after rerun: %+v", lf)
+	}
+	if f := findingsByKind(t, db, clean.ID)[model.WalletAuditFrozenMismatch]; f.Expected != 300 || f.Actual != 200 || f.TicketID == nil {
+		t.Fatalf("frozen mismatch: %+v", f)
+	}
+	if f := findingsByKind(t, db, clean.ID)[model.WalletAuditFrozenReplay]; f.Expected != 300 || f.Actual != 200 {
+		t.Fatalf("frozen replay: %+v", f)
+	}
+	db.Model(&model.Ticket{}).Where("source = ?", "system").Count(&tickets)
+	if int(tickets) != run.NewFindings+2 {
+		t.Fatalf("rerun should only open tickets for the new findings, got %d", tickets)
+	}
+}
+
+func TestWalletAudit_ReplaysFrozenMovements(t *testing.T) {
+	db := setupLedgerFlowDB(t, "wallet_audit_frozen")
+	if err := db.AutoMigrate(&model.WalletAuditRun{}, &model.WalletAuditFinding{}, &model.Ticket{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	svc := &WalletAuditService{db: db}
+
+	// 冻结后部分提现完成、部分拒绝解冻：冻结余额由流水重放可得
+	user := model.User{Phone: "13800000034", Nickname: "frozen", OpenID: "openid-frozen-audit"}
+	db.Create(&user)
+	paid := model.WithdrawRecord{UserID: user.ID, WithdrawNo: "WDAUDIT1", Amount: decimal.NewFromInt(3), ActualAmount: decimal.NewFromInt(3)}
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := AdjustWalletTx(tx, user.ID, 1000, 0, "admin_credit", "充值"); err != nil {
+			return err
+		}
+		if _, err := AdjustWalletTx(tx, user.ID, -500, 500, "withdraw_freeze", "提现冻结"); err != nil {
+			return err
+		}
+		if _, err := AdjustWalletTx(tx, user.ID, 200, -200, "withdraw_reject_unfreeze", "提现拒绝"); err != nil {
+			return err
+		}
+		return SettleWithdrawTx(tx, &paid, buildWithdrawPhaseRemark("paid", paid.WithdrawNo, 300, 0, 300))
+	}); err != nil {
+		t.Fatalf("seed wallet: %v", err)
+	}
+
+	// 历史用户：未纳入账本，冻结余额没有对应的冻结流水
+	legacy := model.User{Phone: "13800000035", Nickname: "legacy-frozen", OpenID: "openid-legacy-frozen"}
+	db.Create(&legacy)
+	after := func(v int64) *int64 { return &v }
+	db.Create(&model.Wallet{UserID: legacy.ID, Balance: 100, Frozen: 400})
+	db.Create(&model.WalletTransaction{UserID: legacy.ID, Type: "recharge", Amount: 500, BalanceAfter: after(500)})
+	db.Create(&model.WalletTransaction{UserID: legacy.ID, Type: "withdraw_freeze", Amount: -400, BalanceAfter: after(100)})
+	db.Create(&model.WalletTransaction{UserID: legacy.ID, Type: "withdraw_paid", Amount: 0, BalanceAfter: after(100),
+		Remark: buildWithdrawPhaseRemark("paid", "WDAUDIT2", 150, 0, 150)})
+
+	if _, err := svc.Run(WalletAuditOptions{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := findingsByKind(t, db, user.ID); len(got) != 0 {
+		t.Fatalf("frozen movements should replay cleanly, got %+v", got)
+	}
+	lf := findingsByKind(t, db, legacy.ID)
+	if f, ok := lf[model.WalletAuditFrozenReplay]; !ok || len(lf) != 1 || f.Expected != 250 || f.Actual != 400 {
+		t.Fatalf("legacy frozen should be flagged against replayed movements, got %+v", lf)
+	}
+}
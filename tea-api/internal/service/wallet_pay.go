@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service/ledger"
+)
+
+// 余额支付相关钱包流水类型
+const (
+	WalletTxOrderPay         = "order_pay"          // 订单余额支付（扣减）
+	WalletTxOrderPayRollback = "order_pay_rollback" // 组合支付失败/取消，余额退回
+)
+
+// BalancePayResult 余额支付结果；余额不足以覆盖订单时 External 为剩余金额的渠道下单结果
+type BalancePayResult struct {
+	OrderID          uint                `json:"order_id"`
+	Paid             bool                `json:"paid"`
+	BalancePaymentNo string              `json:"balance_payment_no"`
+	BalanceCents     int64               `json:"balance_cents"`
+	ExternalCents    int64               `json:"external_cents"`
+	External         *UnifiedOrderResult `json:"external,omitempty"`
+}
+
+func yuanDecimalToCents(d decimal.Decimal) int64 {
+	return d.Mul(decimal.NewFromInt(100)).Round(0).IntPart()
+}
+
+// walletChange 变更钱包余额并记录流水：余额变动以凭证过账（对方科目为 counter），
+// wallets.balance 由账本投影更新，delta 为负时要求余额充足
+func walletChange(tx *gorm.DB, userID uint, delta int64, typ, remark, counter string) (*model.WalletTransaction, error) {
+	wtx := &model.WalletTransaction{UserID: userID, Type: typ, Amount: delta, Remark: remark}
+	if err := recordWalletTx(tx, wtx, []ledger.Line{
+		ledger.Credit(ledger.UserWallet(userID), delta),
+		ledger.Debit(counter, delta),
+	}); err != nil {
+		return nil, err
+	}
+	return wtx, nil
+}
+
+// pendingBalanceHold 查询订单待确认的余额预扣（组合支付中余额部分）
+func pendingBalanceHold(tx *gorm.DB, orderID uint) (*model.Payment, error) {
+	var holds []model.Payment
+	if err := tx.Where("order_id = ? AND payment_method = ? AND status = 1", orderID, PayMethodBalance).
+		Limit(1).Find(&holds).Error; err != nil {
+		return nil, err
+	}
+	if len(holds) == 0 {
+		return nil, nil
+	}
+	return &holds[0], nil
+}
+
+// confirmBalanceHold 外部渠道支付成功后确认余额预扣
+func confirmBalanceHold(tx *gorm.DB, orderID uint, paidAt *time.Time) error {
+	return tx.Model(&model.Payment{}).
+		Where("order_id = ? AND payment_method = ? AND status = 1", orderID, PayMethodBalance).
+		Updates(map[string]any{"status": 2, "paid_at": paidAt}).Error
+}
+
+// releaseBalanceHold 退回订单的余额预扣：外部支付失败、订单取消或超时时调用
+func releaseBalanceHold(tx *gorm.DB, orderID uint, reason string) error {
+	hold, err := pendingBalanceHold(tx, orderID)
+	if err != nil || hold == nil {
+		return err
+	}
+	// 条件更新防止并发重复退回
+	res := tx.Model(&model.Payment{}).Where("id = ? AND status = 1", hold.ID).Update("status", 3)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+	var order model.Order
+	if err := tx.Select("id", "user_id", "order_no", "store_id").First(&order, orderID).Error; err != nil {
+		return err
+	}
+	_, err = walletChange(tx, order.UserID, yuanDecimalToCents(hold.Amount), WalletTxOrderPayRollback,
+		fmt.Sprintf("订单%s余额退回：%s", order.OrderNo, reason), orderRevenueAccount(&order))
+	return err
+}
+
+// PayWithBalance 使用钱包余额支付订单
+// balanceCents 为 0 时尽量使用全部可用余额；余额足够时直接完成支付，
+// 否则余额部分先预扣，剩余金额通过 method 指定的外部渠道下单，外部下单失败时余额立即退回。
+func (s *PaymentService) PayWithBalance(userID, orderID uint, balanceCents int64, method int, opts UnifiedOrderOptions) (*BalancePayResult, error) {
+	if balanceCents < 0 {
+		return nil, errors.New("余额支付金额不能为负")
+	}
+	var order model.Order
+	if err := s.db.First(&order, orderID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("订单不存在")
+		}
+		return nil, err
+	}
+	if order.UserID != userID {
+		return nil, errors.New("无权支付该订单")
+	}
+	if order.Status != 1 || order.PayStatus != 1 {
+		return nil, errors.New("订单当前不可支付")
+	}
+	if order.OrderType == OrderTypeRecharge {
+		return nil, errors.New("充值订单不支持余额支付")
+	}
+	totalCents := yuanDecimalToCents(order.PayAmount)
+	if totalCents <= 0 {
+		return nil, errors.New("订单金额无需支付")
+	}
+	if balanceCents == 0 {
+		var balance int64
+		if err := s.db.Table("wallets").Select("balance").Where("user_id = ?", userID).Scan(&balance).Error; err != nil {
+			return nil, err
+		}
+		balanceCents = balance
+	}
+	if balanceCents > totalCents {
+		balanceCents = totalCents
+	}
+	if balanceCents <= 0 {
+		return nil, errors.New("余额不足")
+	}
+	full := balanceCents == totalCents
+	if !full && method != PayMethodWechat && method != PayMethodAlipay {
+		return nil, errors.New("余额不足以支付订单，请选择微信或支付宝支付剩余金额")
+	}
+	if err := s.closePendingExternal(order.ID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := &BalancePayResult{OrderID: order.ID, BalanceCents: balanceCents, ExternalCents: totalCents - balanceCents}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var locked model.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, order.ID).Error; err != nil {
+			return err
+		}
+		if locked.Status != 1 || locked.PayStatus != 1 {
+			return errors.New("订单当前不可支付")
+		}
+		hold, err := pendingBalanceHold(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		if hold != nil {
+			return errors.New("订单存在未完成的组合支付，请继续完成支付或取消订单")
+		}
+		wtx, err := walletChange(tx, userID, -balanceCents, WalletTxOrderPay, fmt.Sprintf("订单%s余额支付", order.OrderNo),
+			orderRevenueAccount(&locked))
+		if err != nil {
+			return err
+		}
+		pay := &model.Payment{
+			OrderID:       order.ID,
+			PaymentNo:     generatePaymentNo("B"),
+			PaymentMethod: PayMethodBalance,
+			Amount:        decimal.New(balanceCents, -2),
+			Status:        1, // 组合支付：待外部渠道支付成功后确认
+			ThirdPayNo:    fmt.Sprintf("wallet_tx_%d", wtx.ID),
+		}
+		if full {
+			pay.Status = 2
+			pay.PaidAt = &now
+		}
+		if err := tx.Create(pay).Error; err != nil {
+			return err
+		}
+		result.BalancePaymentNo = pay.PaymentNo
+		if !full {
+			return nil
+		}
+		if err := markOrderPaidTx(tx, &locked, &now, now); err != nil {
+			return err
+		}
+		result.Paid = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if full {
+		TriggerOrderPaidPrint(order.ID)
+		return result, nil
+	}
+
+	ext, err := s.UnifiedOrder(userID, order.ID, method, opts)
+	if err != nil {
+		// 外部渠道下单失败，余额部分回滚
+		if rbErr := s.db.Transaction(func(tx *gorm.DB) error {
+			return releaseBalanceHold(tx, order.ID, "外部渠道下单失败")
+		}); rbErr != nil {
+			zap.L().Error("rollback balance hold failed", zap.Uint("order_id", order.ID), zap.Error(rbErr))
+		}
+		return nil, err
+	}
+	result.External = ext
+	return result, nil
+}
+
+// closePendingExternal 关闭订单上待支付的外部渠道支付单，避免改用余额后仍可按原金额付款
+func (s *PaymentService) closePendingExternal(orderID uint) error {
+	var pending []model.Payment
+	if err := s.db.Where("order_id = ? AND payment_method IN ? AND status = 1", orderID, []int{PayMethodWechat, PayMethodAlipay}).
+		Find(&pending).Error; err != nil {
+		return err
+	}
+	for _, p := range pending {
+		provider, err := s.providerFor(p.PaymentMethod)
+		if err != nil {
+			return err
+		}
+		if err := provider.ClosePayment(context.Background(), p.PaymentNo); err != nil {
+			return fmt.Errorf("关闭原支付单失败: %w", err)
+		}
+		if err := s.db.Model(&model.Payment{}).Where("id = ? AND status = 1", p.ID).Update("status", 3).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
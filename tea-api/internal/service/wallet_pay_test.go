@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+type failingCreateProvider struct{ MockPaymentProvider }
+
+func (f *failingCreateProvider) CreatePayment(context.Context, PayCreateRequest) (*PayCreateResult, error) {
+	return nil, errors.New("渠道不可用")
+}
+
+func setupWalletPayDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:wallet_pay?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Order{}, &model.OrderItem{}, &model.Payment{}, &model.StockReservation{},
+		&model.StoreSlotTemplate{}, &model.Wallet{}, &model.WalletTransaction{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func newWalletPayOrder(t *testing.T, db *gorm.DB, userID uint, no string, amount int64) model.Order {
+	t.Helper()
+	o := model.Order{OrderNo: no, UserID: userID, Status: 1, PayStatus: 1, TotalAmount: decimal.NewFromInt(amount), PayAmount: decimal.NewFromInt(amount)}
+	if err := db.Create(&o).Error; err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	return o
+}
+
+func walletBalance(t *testing.T, db *gorm.DB, userID uint) int64 {
+	t.Helper()
+	var b int64
+	db.Table("wallets").Select("balance").Where("user_id = ?", userID).Scan(&b)
+	return b
+}
+
+func TestPayWithBalance_FullAndMixed(t *testing.T) {
+	db := setupWalletPayDB(t)
+	db.Create(&model.User{Phone: "13800000001", Nickname: "u1", OpenID: "openid-u1"})
+	db.Create(&model.Wallet{UserID: 1, Balance: 5000})
+
+	var provider PaymentProvider = &MockPaymentProvider{}
+	svc := &PaymentService{db: db, provider: func(int) (PaymentProvider, error) { return provider, nil }}
+
+	// 余额足够：直接完成支付
+	o1 := newWalletPayOrder(t, db, 1, "ORDB1", 36)
+	res, err := svc.PayWithBalance(1, o1.ID, 0, PayMethodWechat, UnifiedOrderOptions{})
+	if err != nil || !res.Paid || res.BalanceCents != 3600 {
+		t.Fatalf("full balance pay failed: %+v err=%v", res, err)
+	}
+	db.First(&o1, o1.ID)
+	if o1.PayStatus != 2 || walletBalance(t, db, 1) != 1400 {
+		t.Fatalf("order should be paid and wallet debited, pay_status=%d balance=%d", o1.PayStatus, walletBalance(t, db, 1))
+	}
+
+	// 组合支付：外部渠道下单失败时余额回滚
+	o2 := newWalletPayOrder(t, db, 1, "ORDB2", 20)
+	provider = &failingCreateProvider{}
+	if _, err := svc.PayWithBalance(1, o2.ID, 0, PayMethodWechat, UnifiedOrderOptions{}); err == nil {
+		t.Fatalf("mixed payment should fail when provider fails")
+	}
+	if walletBalance(t, db, 1) != 1400 {
+		t.Fatalf("balance should be rolled back, got %d", walletBalance(t, db, 1))
+	}
+
+	// 组合支付：余额 14 元 + 微信 6 元，外部支付成功后整单完成
+	provider = &MockPaymentProvider{}
+	res, err = svc.PayWithBalance(1, o2.ID, 0, PayMethodWechat, UnifiedOrderOptions{})
+	if err != nil || res.Paid || res.External == nil || res.ExternalCents != 600 {
+		t.Fatalf("mixed payment unexpected: %+v err=%v", res, err)
+	}
+	if !res.External.Amount.Equal(decimal.NewFromInt(6)) || walletBalance(t, db, 1) != 0 {
+		t.Fatalf("external part should be 6.00 with wallet emptied, got %s / %d", res.External.Amount, walletBalance(t, db, 1))
+	}
+	if err := svc.HandleCallback(PaymentCallbackPayload{PaymentNo: res.External.PaymentNo, TradeState: "SUCCESS", SkipVerify: true}); err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	var hold model.Payment
+	db.Where("payment_no = ?", res.BalancePaymentNo).First(&hold)
+	db.First(&o2, o2.ID)
+	if hold.Status != 2 || o2.PayStatus != 2 {
+		t.Fatalf("balance hold should be confirmed with order paid, hold=%d order=%d", hold.Status, o2.PayStatus)
+	}
+
+	// 组合支付后取消订单：余额退回
+	db.Exec("UPDATE wallets SET balance = 500 WHERE user_id = 1")
+	o3 := newWalletPayOrder(t, db, 1, "ORDB3", 10)
+	if _, err := svc.PayWithBalance(1, o3.ID, 0, PayMethodWechat, UnifiedOrderOptions{}); err != nil {
+		t.Fatalf("mixed payment: %v", err)
+	}
+	orderSvc := &OrderService{db: db}
+	if err := orderSvc.CancelOrder(1, o3.ID, "不要了"); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if walletBalance(t, db, 1) != 500 {
+		t.Fatalf("cancel should return held balance, got %d", walletBalance(t, db, 1))
+	}
+	var txCount int64
+	db.Model(&model.WalletTransaction{}).Where("user_id = 1 AND type = ?", WalletTxOrderPayRollback).Count(&txCount)
+	if txCount != 2 {
+		t.Fatalf("expected 2 rollback transactions, got %d", txCount)
+	}
+}
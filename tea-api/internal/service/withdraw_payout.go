@@ -0,0 +1,575 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+	"tea-api/pkg/database"
+)
+
+// defaultPayoutLayoutKey 未配置版式时使用的内置通用 CSV 版式
+const defaultPayoutLayoutKey = "generic_csv"
+
+var (
+	ErrPayoutLayoutNotFound = errors.New("付款文件版式不存在")
+	ErrPayoutNoEligible     = errors.New("没有可生成付款文件的提现记录")
+)
+
+// builtinPayoutLayout 内置通用 CSV 版式：付款文件与回盘文件均为逗号分隔、首行表头
+func builtinPayoutLayout() config.BankPayoutLayout {
+	return config.BankPayoutLayout{
+		Name:   "通用CSV",
+		Format: "csv",
+		Header: true,
+		Fields: []config.BankPayoutField{
+			{Key: "line_no", Title: "序号"},
+			{Key: "withdraw_no", Title: "业务参考号"},
+			{Key: "account_no", Title: "收款账号"},
+			{Key: "account_name", Title: "收款户名"},
+			{Key: "bank_name", Title: "开户行"},
+			{Key: "amount", Title: "金额"},
+			{Key: "remark", Title: "用途"},
+		},
+		Result: config.BankPayoutResult{SkipRows: 1, RefColumn: 1, StatusColumn: 2, ReasonColumn: 3, AmountColumn: 4, SuccessValues: []string{"SUCCESS", "成功"}},
+	}
+}
+
+// PayoutLayoutInfo 可选付款文件版式
+type PayoutLayoutInfo struct {
+	Key     string `json:"key"`
+	Name    string `json:"name"`
+	Format  string `json:"format"`
+	Default bool   `json:"default"`
+}
+
+// PayoutSkip 生成批次时被跳过的提现及原因
+type PayoutSkip struct {
+	WithdrawID uint   `json:"withdraw_id"`
+	Reason     string `json:"reason"`
+}
+
+// PayoutImportReport 回盘导入结果
+type PayoutImportReport struct {
+	Rows    int      `json:"rows"`
+	Success int      `json:"success"`
+	Failed  int      `json:"failed"`
+	Skipped int      `json:"skipped"`
+	Errors  []string `json:"errors"`
+}
+
+// WithdrawPayoutService 银行批量代付：由已受理的提现生成银行付款文件，导入银行回盘文件后逐笔完成或退回
+type WithdrawPayoutService struct {
+	db *gorm.DB
+}
+
+func NewWithdrawPayoutService() *WithdrawPayoutService {
+	return &WithdrawPayoutService{db: database.GetDB()}
+}
+
+// Layouts 返回已配置的付款文件版式，未配置时仅有内置通用 CSV 版式
+func (s *WithdrawPayoutService) Layouts() []PayoutLayoutInfo {
+	def := s.defaultLayoutKey()
+	layouts := config.Config.Finance.BankPayout.Layouts
+	if len(layouts) == 0 {
+		l := builtinPayoutLayout()
+		return []PayoutLayoutInfo{{Key: defaultPayoutLayoutKey, Name: l.Name, Format: l.Format, Default: true}}
+	}
+	out := make([]PayoutLayoutInfo, 0, len(layouts))
+	for key, l := range layouts {
+		out = append(out, PayoutLayoutInfo{Key: key, Name: l.Name, Format: payoutFormat(l), Default: key == def})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+func (s *WithdrawPayoutService) defaultLayoutKey() string {
+	if k := strings.TrimSpace(config.Config.Finance.BankPayout.DefaultLayout); k != "" {
+		return k
+	}
+	return defaultPayoutLayoutKey
+}
+
+func (s *WithdrawPayoutService) layout(key string) (config.BankPayoutLayout, error) {
+	if key == "" {
+		key = s.defaultLayoutKey()
+	}
+	layouts := config.Config.Finance.BankPayout.Layouts
+	if len(layouts) == 0 && key == defaultPayoutLayoutKey {
+		return builtinPayoutLayout(), nil
+	}
+	l, ok := layouts[key]
+	if !ok || len(l.Fields) == 0 {
+		return config.BankPayoutLayout{}, ErrPayoutLayoutNotFound
+	}
+	return l, nil
+}
+
+func payoutFormat(l config.BankPayoutLayout) string {
+	if strings.EqualFold(l.Format, "fixed") {
+		return "fixed"
+	}
+	return "csv"
+}
+
+// GenerateBatch 按版式生成代付批次并快照收款账户。ids 为空时取全部已受理的银行卡提现；
+// 非银行卡提现、已发起微信转账、已在待回盘/已成功代付明细中或缺少收款账户的提现会被跳过
+func (s *WithdrawPayoutService) GenerateBatch(layoutKey string, ids []uint, operatorID uint) (*model.WithdrawPayoutBatch, []PayoutSkip, error) {
+	if layoutKey == "" {
+		layoutKey = s.defaultLayoutKey()
+	}
+	if _, err := s.layout(layoutKey); err != nil {
+		return nil, nil, err
+	}
+	var batch model.WithdrawPayoutBatch
+	var skipped []PayoutSkip
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// 指定 ids 时不按类型过滤，以便逐条回报非银行卡提现的跳过原因
+		q := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("status = ?", model.WithdrawStatusProcessing)
+		if len(ids) > 0 {
+			q = q.Where("id IN ?", ids)
+		} else {
+			q = q.Where("withdraw_type = ?", model.WithdrawTypeBank)
+		}
+		var recs []model.WithdrawRecord
+		if err := q.Order("id asc").Find(&recs).Error; err != nil {
+			return err
+		}
+		found := make(map[uint]bool, len(recs))
+		for _, r := range recs {
+			found[r.ID] = true
+		}
+		for _, id := range ids {
+			if !found[id] {
+				skipped = append(skipped, PayoutSkip{WithdrawID: id, Reason: "提现不存在或非处理中"})
+			}
+		}
+
+		var lines []model.WithdrawPayoutLine
+		total := decimal.Zero
+		for i := range recs {
+			rec := &recs[i]
+			if rec.WithdrawType != model.WithdrawTypeBank {
+				skipped = append(skipped, PayoutSkip{WithdrawID: rec.ID, Reason: "非银行卡提现"})
+				continue
+			}
+			if reason, err := s.payoutBlocked(tx, rec.ID); err != nil {
+				return err
+			} else if reason != "" {
+				skipped = append(skipped, PayoutSkip{WithdrawID: rec.ID, Reason: reason})
+				continue
+			}
+			name, no, bank, err := s.payoutAccount(tx, rec)
+			if err != nil {
+				return err
+			}
+			if no == "" {
+				skipped = append(skipped, PayoutSkip{WithdrawID: rec.ID, Reason: "缺少收款银行账户"})
+				continue
+			}
+			lines = append(lines, model.WithdrawPayoutLine{
+				LineNo:      len(lines) + 1,
+				WithdrawID:  rec.ID,
+				WithdrawNo:  rec.WithdrawNo,
+				AccountName: name,
+				AccountNo:   no,
+				BankName:    bank,
+				Amount:      rec.ActualAmount,
+				Status:      model.PayoutLineStatusPending,
+			})
+			total = total.Add(rec.ActualAmount)
+		}
+		if len(lines) == 0 {
+			return ErrPayoutNoEligible
+		}
+		batch = model.WithdrawPayoutBatch{
+			BatchNo:     generateOrderNo("PB"),
+			Layout:      layoutKey,
+			Status:      model.PayoutBatchStatusGenerated,
+			TotalCount:  len(lines),
+			TotalAmount: total,
+			CreatedBy:   operatorID,
+			Lines:       lines,
+		}
+		return tx.Create(&batch).Error
+	})
+	if err != nil {
+		return nil, skipped, err
+	}
+	return &batch, skipped, nil
+}
+
+// payoutBlocked 提现是否已由其他渠道打款：存在未失败的微信转账或未失败的代付明细
+func (s *WithdrawPayoutService) payoutBlocked(tx *gorm.DB, withdrawID uint) (string, error) {
+	var cnt int64
+	if err := tx.Model(&model.WechatTransferRecord{}).
+		Where("withdraw_id = ? AND status IN ?", withdrawID, []int{model.WechatTransferStatusProcessing, model.WechatTransferStatusSuccess}).
+		Count(&cnt).Error; err != nil {
+		return "", err
+	}
+	if cnt > 0 {
+		return "已发起微信转账", nil
+	}
+	if err := tx.Model(&model.WithdrawPayoutLine{}).
+		Where("withdraw_id = ? AND status IN ?", withdrawID, []int{model.PayoutLineStatusPending, model.PayoutLineStatusSuccess}).
+		Count(&cnt).Error; err != nil {
+		return "", err
+	}
+	if cnt > 0 {
+		return "已在其他代付批次中", nil
+	}
+	return "", nil
+}
+
+// payoutAccount 收款账户：门店提现取门店银行账户，用户提现取用户银行账户；优先默认账户，否则取最近添加的账户
+func (s *WithdrawPayoutService) payoutAccount(tx *gorm.DB, rec *model.WithdrawRecord) (name, no, bank string, err error) {
+	if rec.StoreID > 0 {
+		var a model.StoreBankAccount
+		err = tx.Where("store_id = ?", rec.StoreID).Order("is_default desc, id desc").First(&a).Error
+		name, no, bank = a.AccountName, a.AccountNo, a.BankName
+	} else {
+		var a model.UserBankAccount
+		err = tx.Where("user_id = ?", rec.UserID).Order("is_default desc, id desc").First(&a).Error
+		name, no, bank = a.AccountName, a.AccountNo, a.BankName
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", "", "", nil
+	}
+	return name, no, bank, err
+}
+
+// ActivePayoutLine 提现所在的待回盘代付明细，不存在时返回 nil
+func (s *WithdrawPayoutService) ActivePayoutLine(withdrawID uint) (*model.WithdrawPayoutLine, error) {
+	var line model.WithdrawPayoutLine
+	err := s.db.Where("withdraw_id = ? AND status = ?", withdrawID, model.PayoutLineStatusPending).First(&line).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &line, nil
+}
+
+// GetBatch 查询批次及明细
+func (s *WithdrawPayoutService) GetBatch(batchID uint) (*model.WithdrawPayoutBatch, error) {
+	var batch model.WithdrawPayoutBatch
+	if err := s.db.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("line_no asc") }).First(&batch, batchID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("代付批次不存在")
+		}
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// RenderBatchFile 按批次版式生成银行付款文件内容与文件名
+func (s *WithdrawPayoutService) RenderBatchFile(batchID uint) ([]byte, string, error) {
+	batch, err := s.GetBatch(batchID)
+	if err != nil {
+		return nil, "", err
+	}
+	layout, err := s.layout(batch.Layout)
+	if err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	if payoutFormat(layout) == "fixed" {
+		for i := range batch.Lines {
+			for _, f := range layout.Fields {
+				buf.WriteString(fixedWidthField(payoutFieldValue(f.Key, batch, &batch.Lines[i]), f))
+			}
+			buf.WriteString("\r\n")
+		}
+		return buf.Bytes(), batch.BatchNo + ".txt", nil
+	}
+
+	w := csv.NewWriter(&buf)
+	if r := payoutDelimiter(layout.Delimiter); r != 0 {
+		w.Comma = r
+	}
+	if layout.Header {
+		titles := make([]string, len(layout.Fields))
+		for i, f := range layout.Fields {
+			titles[i] = f.Title
+			if titles[i] == "" {
+				titles[i] = f.Key
+			}
+		}
+		_ = w.Write(titles)
+	}
+	for i := range batch.Lines {
+		row := make([]string, len(layout.Fields))
+		for j, f := range layout.Fields {
+			row[j] = payoutFieldValue(f.Key, batch, &batch.Lines[i])
+		}
+		_ = w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), batch.BatchNo + ".csv", nil
+}
+
+func payoutFieldValue(key string, batch *model.WithdrawPayoutBatch, line *model.WithdrawPayoutLine) string {
+	switch key {
+	case "line_no":
+		return strconv.Itoa(line.LineNo)
+	case "batch_no":
+		return batch.BatchNo
+	case "withdraw_no":
+		return line.WithdrawNo
+	case "account_name":
+		return line.AccountName
+	case "account_no":
+		return line.AccountNo
+	case "bank_name":
+		return line.BankName
+	case "amount":
+		return line.Amount.StringFixed(2)
+	case "amount_cents":
+		return strconv.FormatInt(yuanDecimalToCents(line.Amount), 10)
+	case "remark":
+		return "提现 " + line.WithdrawNo
+	case "date":
+		return batch.CreatedAt.Format("20060102")
+	}
+	return ""
+}
+
+// fixedWidthField 按显示宽度（非 ASCII 字符计 2 位）截断并填充到字段宽度
+func fixedWidthField(v string, f config.BankPayoutField) string {
+	if f.Width <= 0 {
+		return v
+	}
+	pad := " "
+	if f.Pad != "" {
+		pad = f.Pad[:1]
+	}
+	var b strings.Builder
+	width := 0
+	for _, r := range v {
+		w := 1
+		if r >= utf8.RuneSelf {
+			w = 2
+		}
+		if width+w > f.Width {
+			break
+		}
+		b.WriteRune(r)
+		width += w
+	}
+	fill := strings.Repeat(pad, f.Width-width)
+	if strings.EqualFold(f.Align, "right") {
+		return fill + b.String()
+	}
+	return b.String() + fill
+}
+
+func payoutDelimiter(s string) rune {
+	switch s {
+	case "":
+		return 0
+	case `\t`, "tab":
+		return '\t'
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+// ImportResult 导入银行回盘文件：按提现单号匹配待回盘明细，成功的提现置为已完成并过账，
+// 失败的提现置为已拒绝并解冻退回（与管理端手工完成/拒绝的钱包处理一致）；已处理的明细跳过，可重复导入。
+// 回盘金额与明细金额不一致的行不做处理，计入错误供人工核对
+func (s *WithdrawPayoutService) ImportResult(batchID uint, data []byte, operatorID uint) (*PayoutImportReport, error) {
+	var batch model.WithdrawPayoutBatch
+	if err := s.db.First(&batch, batchID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("代付批次不存在")
+		}
+		return nil, err
+	}
+	layout, err := s.layout(batch.Layout)
+	if err != nil {
+		return nil, err
+	}
+	rules := layout.Result
+	if rules.RefColumn <= 0 || rules.StatusColumn <= 0 || rules.AmountColumn <= 0 {
+		return nil, errors.New("版式未配置回盘文件解析规则")
+	}
+
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	if d := payoutDelimiter(rules.Delimiter); d != 0 {
+		r.Comma = d
+	}
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("回盘文件解析失败: %w", err)
+	}
+
+	report := &PayoutImportReport{Errors: []string{}}
+	col := func(row []string, n int) string {
+		if n <= 0 || n > len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[n-1])
+	}
+	for i, row := range rows {
+		if i < rules.SkipRows {
+			continue
+		}
+		ref := col(row, rules.RefColumn)
+		if ref == "" {
+			continue
+		}
+		report.Rows++
+		success := false
+		status := col(row, rules.StatusColumn)
+		for _, v := range rules.SuccessValues {
+			if strings.EqualFold(strings.TrimSpace(v), status) {
+				success = true
+				break
+			}
+		}
+		reason := col(row, rules.ReasonColumn)
+		if !success && reason == "" {
+			reason = status
+		}
+		amount, err := decimal.NewFromString(strings.ReplaceAll(col(row, rules.AmountColumn), ",", ""))
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("第 %d 行 %s: 回盘金额格式错误", i+1, ref))
+			continue
+		}
+		if strings.EqualFold(rules.AmountUnit, "cents") {
+			amount = amount.Shift(-2)
+		}
+		applied, err := s.applyLineResult(batch.ID, ref, amount, success, reason, operatorID)
+		switch {
+		case err != nil:
+			report.Errors = append(report.Errors, fmt.Sprintf("第 %d 行 %s: %s", i+1, ref, err.Error()))
+		case !applied:
+			report.Skipped++
+		case success:
+			report.Success++
+		default:
+			report.Failed++
+		}
+	}
+	if err := s.refreshBatch(batch.ID); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// applyLineResult 落地单笔回盘结果；明细已处理时返回 false，回盘金额与明细金额不一致时返回错误
+func (s *WithdrawPayoutService) applyLineResult(batchID uint, withdrawNo string, amount decimal.Decimal, success bool, reason string, operatorID uint) (bool, error) {
+	var rec model.WithdrawRecord
+	applied := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var line model.WithdrawPayoutLine
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("batch_id = ? AND withdraw_no = ?", batchID, withdrawNo).First(&line).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("批次中不存在该提现")
+			}
+			return err
+		}
+		if line.Status != model.PayoutLineStatusPending {
+			return nil
+		}
+		if !amount.Equal(line.Amount) {
+			return fmt.Errorf("回盘金额 %s 与代付金额 %s 不一致，请人工核对", amount.StringFixed(2), line.Amount.StringFixed(2))
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rec, line.WithdrawID).Error; err != nil {
+			return err
+		}
+		if rec.Status != model.WithdrawStatusProcessing {
+			return errors.New("提现已不在处理中，请人工核对")
+		}
+		now := time.Now()
+		lineUpdates := map[string]any{"settled_at": now}
+		if success {
+			if _, err := completeWithdrawTx(tx, &rec, now, operatorID); err != nil {
+				return err
+			}
+			lineUpdates["status"] = model.PayoutLineStatusSuccess
+		} else {
+			if _, err := failWithdrawTx(tx, &rec, now, operatorID, "rejected_unfreeze", "withdraw_reject_unfreeze"); err != nil {
+				return err
+			}
+			lineUpdates["status"] = model.PayoutLineStatusFailed
+			lineUpdates["fail_reason"] = truncateText(reason, 255)
+		}
+		applied = true
+		return tx.Model(&line).Updates(lineUpdates).Error
+	})
+	if err != nil || !applied {
+		return false, err
+	}
+	if success {
+		consumeWithdrawCommissions(&rec, operatorID)
+	}
+	return true, nil
+}
+
+// refreshBatch 按明细结果重算批次计数与状态
+func (s *WithdrawPayoutService) refreshBatch(batchID uint) error {
+	var rows []struct {
+		Status int
+		Cnt    int
+	}
+	if err := s.db.Model(&model.WithdrawPayoutLine{}).Select("status, COUNT(*) AS cnt").
+		Where("batch_id = ?", batchID).Group("status").Scan(&rows).Error; err != nil {
+		return err
+	}
+	var pending, succ, fail int
+	for _, r := range rows {
+		switch r.Status {
+		case model.PayoutLineStatusSuccess:
+			succ = r.Cnt
+		case model.PayoutLineStatusFailed:
+			fail = r.Cnt
+		default:
+			pending += r.Cnt
+		}
+	}
+	status := model.PayoutBatchStatusGenerated
+	if pending == 0 {
+		status = model.PayoutBatchStatusFinished
+	} else if succ+fail > 0 {
+		status = model.PayoutBatchStatusPartial
+	}
+	return s.db.Model(&model.WithdrawPayoutBatch{}).Where("id = ?", batchID).Updates(map[string]any{
+		"status": status, "success_count": succ, "fail_count": fail, "imported_at": time.Now(),
+	}).Error
+}
+
+// ListBatches 分页查询代付批次
+func (s *WithdrawPayoutService) ListBatches(status, page, size int) ([]model.WithdrawPayoutBatch, int64, error) {
+	q := s.db.Model(&model.WithdrawPayoutBatch{})
+	if status > 0 {
+		q = q.Where("status = ?", status)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []model.WithdrawPayoutBatch
+	err := q.Order("id desc").Limit(size).Offset((page - 1) * size).Find(&list).Error
+	return list, total, err
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+)
+
+func setupWithdrawPayoutDB(t *testing.T, name string) (*gorm.DB, []model.WithdrawRecord) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.UserBankAccount{}, &model.StoreBankAccount{}, &model.WithdrawRecord{},
+		&model.WechatTransferRecord{}, &model.WithdrawPayoutBatch{}, &model.WithdrawPayoutLine{}, &model.Wallet{}, &model.WalletTransaction{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	var recs []model.WithdrawRecord
+	for i, acctName := range []string{"张三", "李四"} {
+		user := model.User{Phone: "1380000470" + string(rune('1'+i)), Nickname: acctName, OpenID: name + acctName}
+		db.Create(&user)
+		db.Create(&model.UserBankAccount{UserID: user.ID, AccountName: acctName, AccountNo: "62220000" + string(rune('1'+i)), BankName: "工商银行", IsDefault: true})
+		// 余额 100 元，申请提现 30 元（手续费 0.3 元）冻结
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if _, err := AdjustWalletTx(tx, user.ID, 10000, 0, "admin_credit", "期初充值"); err != nil {
+				return err
+			}
+			_, err := AdjustWalletTx(tx, user.ID, -3000, 3000, "withdraw_freeze", "提现冻结")
+			return err
+		}); err != nil {
+			t.Fatalf("seed wallet: %v", err)
+		}
+		rec := model.WithdrawRecord{UserID: user.ID, WithdrawNo: name + string(rune('A'+i)), Amount: decimal.NewFromInt(30),
+			Fee: decimal.RequireFromString("0.3"), ActualAmount: decimal.RequireFromString("29.7"),
+			WithdrawType: model.WithdrawTypeBank, Status: model.WithdrawStatusProcessing}
+		db.Create(&rec)
+		recs = append(recs, rec)
+	}
+	return db, recs
+}
+
+func TestWithdrawPayout_GenerateCSVAndImportResult(t *testing.T) {
+	old := config.Config.Finance.BankPayout
+	config.Config.Finance.BankPayout = config.BankPayout{}
+	defer func() { config.Config.Finance.BankPayout = old }()
+
+	db, recs := setupWithdrawPayoutDB(t, "payout_csv")
+	svc := &WithdrawPayoutService{db: db}
+
+	batch, skipped, err := svc.GenerateBatch("", nil, 1)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if batch.TotalCount != 2 || !batch.TotalAmount.Equal(decimal.RequireFromString("59.4")) || len(skipped) != 0 {
+		t.Fatalf("unexpected batch: count=%d amount=%s skipped=%v", batch.TotalCount, batch.TotalAmount, skipped)
+	}
+	// 已在批次中的提现不可重复生成
+	if _, _, err := svc.GenerateBatch("", nil, 1); err != ErrPayoutNoEligible {
+		t.Fatalf("withdraws in a pending batch should not be batched again, err=%v", err)
+	}
+
+	data, filename, err := svc.RenderBatchFile(batch.ID)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if !strings.HasSuffix(filename, ".csv") || len(lines) != 3 || lines[0] != "序号,业务参考号,收款账号,收款户名,开户行,金额,用途" {
+		t.Fatalf("unexpected csv file %s:\n%s", filename, data)
+	}
+	if want := "1," + recs[0].WithdrawNo + ",622200001,张三,工商银行,29.70,"; !strings.HasPrefix(lines[1], want) {
+		t.Fatalf("line 1 = %q, want prefix %q", lines[1], want)
+	}
+
+	// 金额与明细不一致的行不处理，明细保持待回盘
+	mismatch := "业务参考号,状态,原因,金额\n" + recs[0].WithdrawNo + ",SUCCESS,,30.00\n"
+	report, err := svc.ImportResult(batch.ID, []byte(mismatch), 1)
+	if err != nil {
+		t.Fatalf("import mismatch: %v", err)
+	}
+	if report.Success != 0 || len(report.Errors) != 1 || !strings.Contains(report.Errors[0], "不一致") {
+		t.Fatalf("amount mismatch should be rejected, got %+v", report)
+	}
+	if l, _ := svc.ActivePayoutLine(recs[0].ID); l == nil {
+		t.Fatalf("mismatched row should leave the line pending")
+	}
+
+	result := "业务参考号,状态,原因,金额\n" + recs[0].WithdrawNo + ",SUCCESS,,29.70\n" + recs[1].WithdrawNo + ",FAIL,账户已销户,29.7\n"
+	report, err = svc.ImportResult(batch.ID, []byte(result), 1)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if report.Success != 1 || report.Failed != 1 || len(report.Errors) != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	// 重复导入跳过已处理明细
+	if report, _ = svc.ImportResult(batch.ID, []byte(result), 1); report.Skipped != 2 || report.Success != 0 {
+		t.Fatalf("re-import should skip settled lines: %+v", report)
+	}
+
+	var ok, fail model.WithdrawRecord
+	db.First(&ok, recs[0].ID)
+	db.First(&fail, recs[1].ID)
+	if ok.Status != model.WithdrawStatusCompleted || fail.Status != model.WithdrawStatusRejected {
+		t.Fatalf("withdraw statuses = %d/%d", ok.Status, fail.Status)
+	}
+	if w := walletOf(t, db, ok.UserID); w.Balance != 7000 || w.Frozen != 0 {
+		t.Fatalf("paid withdraw should settle frozen funds, balance=%d frozen=%d", w.Balance, w.Frozen)
+	}
+	if w := walletOf(t, db, fail.UserID); w.Balance != 10000 || w.Frozen != 0 {
+		t.Fatalf("failed payout should return funds, balance=%d frozen=%d", w.Balance, w.Frozen)
+	}
+	got, _ := svc.GetBatch(batch.ID)
+	if got.Status != model.PayoutBatchStatusFinished || got.SuccessCount != 1 || got.FailCount != 1 || got.Lines[1].FailReason != "账户已销户" {
+		t.Fatalf("unexpected batch after import: %+v", got)
+	}
+}
+
+func TestWithdrawPayout_FixedWidthLayout(t *testing.T) {
+	old := config.Config.Finance.BankPayout
+	config.Config.Finance.BankPayout = config.BankPayout{DefaultLayout: "fixed", Layouts: map[string]config.BankPayoutLayout{
+		"fixed": {Format: "fixed", Fields: []config.BankPayoutField{
+			{Key: "account_name", Width: 6},
+			{Key: "amount_cents", Width: 8, Align: "right", Pad: "0"},
+		}, Result: config.BankPayoutResult{Delimiter: "|", RefColumn: 1, StatusColumn: 2, AmountColumn: 4, AmountUnit: "cents", SuccessValues: []string{"0000"}}},
+	}}
+	defer func() { config.Config.Finance.BankPayout = old }()
+
+	db, recs := setupWithdrawPayoutDB(t, "payout_fixed")
+	svc := &WithdrawPayoutService{db: db}
+	// 指定 ids 时微信提现同样被跳过
+	db.Model(&recs[1]).Update("withdraw_type", model.WithdrawTypeWechat)
+	batch, skipped, err := svc.GenerateBatch("", []uint{recs[0].ID, recs[1].ID}, 1)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if batch.TotalCount != 1 || len(skipped) != 1 || skipped[0].WithdrawID != recs[1].ID || skipped[0].Reason != "非银行卡提现" {
+		t.Fatalf("non-bank withdraw should be skipped, count=%d skipped=%+v", batch.TotalCount, skipped)
+	}
+	data, filename, err := svc.RenderBatchFile(batch.ID)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	// 中文按 2 位计宽："张三" 占 4 位，补 2 个空格
+	if !strings.HasSuffix(filename, ".txt") || string(data) != "张三  00002970\r\n" {
+		t.Fatalf("unexpected fixed-width file %s: %q", filename, data)
+	}
+
+	// 待回盘的提现可查到所在明细（管理端据此禁止手工完成/拒绝）；未列入批次的提现不受影响
+	var line model.WithdrawPayoutLine
+	if l, _ := svc.ActivePayoutLine(recs[0].ID); l == nil {
+		t.Fatalf("withdraw should have an active payout line")
+	} else {
+		line = *l
+	}
+	if l, _ := svc.ActivePayoutLine(recs[1].ID); l != nil {
+		t.Fatalf("withdraw outside the batch should have no payout line")
+	}
+
+	report, err := svc.ImportResult(batch.ID, []byte(line.WithdrawNo+"|0000||2970\nUNKNOWN|0000||100\n"), 1)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if report.Success != 1 || len(report.Errors) != 1 {
+		t.Fatalf("unknown reference should be reported, got %+v", report)
+	}
+}
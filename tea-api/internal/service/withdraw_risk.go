@@ -0,0 +1,210 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+	"tea-api/pkg/database"
+)
+
+// 提现风控原因级别
+const (
+	WithdrawRiskBlock  = "block"  // 拒绝申请
+	WithdrawRiskReview = "review" // 需人工复核
+)
+
+// WithdrawRiskReason 风控命中原因
+type WithdrawRiskReason struct {
+	Code    string `json:"code"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// WithdrawRiskResult 风控检查结果
+type WithdrawRiskResult struct {
+	Reasons []WithdrawRiskReason `json:"reasons"`
+}
+
+// Blocked 返回第一条拒绝原因，未命中时返回 nil
+func (r *WithdrawRiskResult) Blocked() *WithdrawRiskReason {
+	for i := range r.Reasons {
+		if r.Reasons[i].Level == WithdrawRiskBlock {
+			return &r.Reasons[i]
+		}
+	}
+	return nil
+}
+
+// ReviewReasons 需人工复核的原因
+func (r *WithdrawRiskResult) ReviewReasons() []WithdrawRiskReason {
+	var out []WithdrawRiskReason
+	for _, it := range r.Reasons {
+		if it.Level == WithdrawRiskReview {
+			out = append(out, it)
+		}
+	}
+	return out
+}
+
+// ReviewFlagsJSON 需人工复核原因的 JSON，无原因时为空串
+func (r *WithdrawRiskResult) ReviewFlagsJSON() string {
+	rs := r.ReviewReasons()
+	if len(rs) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(rs)
+	return string(b)
+}
+
+// ParseWithdrawRiskFlags 解析申请时落库的复核原因（ReviewFlagsJSON 的结果），空串或格式错误时返回空列表
+func ParseWithdrawRiskFlags(raw string) []WithdrawRiskReason {
+	out := []WithdrawRiskReason{}
+	if raw != "" {
+		_ = json.Unmarshal([]byte(raw), &out)
+	}
+	return out
+}
+
+func (r *WithdrawRiskResult) add(code, level, format string, args ...any) {
+	r.Reasons = append(r.Reasons, WithdrawRiskReason{Code: code, Level: level, Message: fmt.Sprintf(format, args...)})
+}
+
+// withdrawHistory 返回 [from, to) 内用户已发起（未拒绝）提现的笔数与金额（分）
+type withdrawHistory func(userID uint, from, to time.Time) (int64, int64, error)
+
+// WithdrawRiskService 提现风控：限额与频次、账户变更冷静期、实名一致性，以及需人工复核的可疑模式
+type WithdrawRiskService struct {
+	db *gorm.DB
+}
+
+func NewWithdrawRiskService() *WithdrawRiskService {
+	return &WithdrawRiskService{db: database.GetDB()}
+}
+
+// CheckApplication 用户申请提现时检查，历史以提现申请（withdrawal_requests）计
+func (s *WithdrawRiskService) CheckApplication(userID uint, amountCents int64, bankAccountID uint, at time.Time) (*WithdrawRiskResult, error) {
+	return s.evaluate(userID, amountCents, bankAccountID, at, s.requestHistory)
+}
+
+// CheckApplicationTx 在冻结资金的事务内检查；调用方须先锁定用户钱包行，使同一用户的并发申请串行计入限额与频次
+func (s *WithdrawRiskService) CheckApplicationTx(tx *gorm.DB, userID uint, amountCents int64, bankAccountID uint, at time.Time) (*WithdrawRiskResult, error) {
+	return (&WithdrawRiskService{db: tx}).CheckApplication(userID, amountCents, bankAccountID, at)
+}
+
+func (s *WithdrawRiskService) requestHistory(userID uint, from, to time.Time) (int64, int64, error) {
+	var row struct {
+		Cnt int64
+		Sum int64
+	}
+	err := s.db.Model(&model.WithdrawalRequest{}).
+		Select("COUNT(*) AS cnt, COALESCE(SUM(amount), 0) AS sum").
+		Where("user_id = ? AND status NOT IN ? AND requested_at >= ? AND requested_at < ?", userID, []string{"rejected", "cancelled"}, from, to).
+		Scan(&row).Error
+	return row.Cnt, row.Sum, err
+}
+
+func (s *WithdrawRiskService) evaluate(userID uint, amountCents int64, bankAccountID uint, at time.Time, history withdrawHistory) (*WithdrawRiskResult, error) {
+	cfg := config.Config.Finance.Withdrawal
+	res := &WithdrawRiskResult{}
+
+	// 单日/单月限额与频次
+	dayStart := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+	monthStart := time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, at.Location())
+	if cfg.DailyAmountCents > 0 || cfg.DailyCount > 0 {
+		cnt, sum, err := history(userID, dayStart, at)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.DailyCount > 0 && cnt+1 > cfg.DailyCount {
+			res.add("daily_count", WithdrawRiskBlock, "超出单日提现次数上限（%d 笔）", cfg.DailyCount)
+		}
+		if cfg.DailyAmountCents > 0 && sum+amountCents > cfg.DailyAmountCents {
+			res.add("daily_amount", WithdrawRiskBlock, "超出单日提现额度（%s 元）", centsToYuan(cfg.DailyAmountCents))
+		}
+	}
+	if cfg.MonthlyAmountCents > 0 || cfg.MonthlyCount > 0 {
+		cnt, sum, err := history(userID, monthStart, at)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.MonthlyCount > 0 && cnt+1 > cfg.MonthlyCount {
+			res.add("monthly_count", WithdrawRiskBlock, "超出单月提现次数上限（%d 笔）", cfg.MonthlyCount)
+		}
+		if cfg.MonthlyAmountCents > 0 && sum+amountCents > cfg.MonthlyAmountCents {
+			res.add("monthly_amount", WithdrawRiskBlock, "超出单月提现额度（%s 元）", centsToYuan(cfg.MonthlyAmountCents))
+		}
+	}
+
+	// 提现账户变更冷静期：新增或删除（软删除）账户后一段时间内不可提现
+	if cfg.AccountCoolingHours > 0 {
+		var accounts []model.UserBankAccount
+		if err := s.db.Unscoped().Select("created_at", "updated_at", "deleted_at").
+			Where("user_id = ?", userID).Find(&accounts).Error; err != nil {
+			return nil, err
+		}
+		var changed time.Time
+		for _, a := range accounts {
+			for _, t := range []time.Time{a.CreatedAt, a.UpdatedAt, a.DeletedAt.Time} {
+				if !t.After(at) && t.After(changed) {
+					changed = t
+				}
+			}
+		}
+		if cooling := time.Duration(cfg.AccountCoolingHours) * time.Hour; !changed.IsZero() && at.Sub(changed) < cooling {
+			res.add("account_cooling", WithdrawRiskBlock, "提现账户变更后 %d 小时内不可提现", cfg.AccountCoolingHours)
+		}
+	}
+
+	var user model.User
+	if err := s.db.Select("id", "created_at", "real_name", "real_name_verified_at").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	// 实名一致性：提现账户户名须与已核验的实名一致
+	if cfg.RequireRealName {
+		if user.RealNameVerifiedAt == nil || strings.TrimSpace(user.RealName) == "" {
+			res.add("real_name_unverified", WithdrawRiskBlock, "请先完成实名认证")
+		} else if bankAccountID != 0 {
+			var acct model.UserBankAccount
+			if err := s.db.Select("account_name").Where("id = ? AND user_id = ?", bankAccountID, userID).First(&acct).Error; err != nil {
+				return nil, err
+			}
+			if normalizeRealName(acct.AccountName) != normalizeRealName(user.RealName) {
+				res.add("real_name_mismatch", WithdrawRiskBlock, "提现账户户名与实名信息不一致")
+			}
+		}
+	}
+
+	// 人工复核：新注册用户、大额提现、大额充值后短时间内提现
+	if cfg.ReviewNewUserDays > 0 && at.Sub(user.CreatedAt) < time.Duration(cfg.ReviewNewUserDays)*24*time.Hour {
+		res.add("new_user", WithdrawRiskReview, "注册不满 %d 天", cfg.ReviewNewUserDays)
+	}
+	if cfg.ReviewAmountCents > 0 && amountCents >= cfg.ReviewAmountCents {
+		res.add("large_amount", WithdrawRiskReview, "单笔提现金额达到 %s 元", centsToYuan(cfg.ReviewAmountCents))
+	}
+	if cfg.ReviewRechargeCents > 0 && cfg.ReviewRechargeHours > 0 {
+		var recharged int64
+		if err := s.db.Model(&model.RechargeRecord{}).Select("COALESCE(SUM(amount_cents), 0)").
+			Where("user_id = ? AND status = ? AND credited_at >= ? AND credited_at <= ?",
+				userID, model.RechargeStatusCredited, at.Add(-time.Duration(cfg.ReviewRechargeHours)*time.Hour), at).
+			Scan(&recharged).Error; err != nil {
+			return nil, err
+		}
+		if recharged >= cfg.ReviewRechargeCents {
+			res.add("recharge_then_withdraw", WithdrawRiskReview, "%d 小时内充值 %s 元后立即提现", cfg.ReviewRechargeHours, centsToYuan(recharged))
+		}
+	}
+	return res, nil
+}
+
+// normalizeRealName 比较户名时忽略空白与全角间隔号差异
+func normalizeRealName(s string) string {
+	s = strings.Join(strings.Fields(s), "")
+	return strings.NewReplacer("·", "", "•", "", ".", "").Replace(s)
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+)
+
+func setupWithdrawRiskDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:withdraw_risk?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.UserBankAccount{}, &model.WithdrawalRequest{}, &model.WithdrawRecord{}, &model.RechargeRecord{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func riskCodes(res *WithdrawRiskResult) map[string]string {
+	out := map[string]string{}
+	for _, r := range res.Reasons {
+		out[r.Code] = r.Level
+	}
+	return out
+}
+
+func TestWithdrawRisk_LimitsCoolingAndRealName(t *testing.T) {
+	old := config.Config.Finance.Withdrawal
+	config.Config.Finance.Withdrawal = config.Withdrawal{DailyAmountCents: 50000, DailyCount: 2, MonthlyAmountCents: 100000,
+		AccountCoolingHours: 24, RequireRealName: true}
+	defer func() { config.Config.Finance.Withdrawal = old }()
+
+	db := setupWithdrawRiskDB(t)
+	svc := &WithdrawRiskService{db: db}
+	now := time.Date(2026, 10, 19, 15, 0, 0, 0, time.Local)
+	verified := now.AddDate(0, -1, 0)
+	user := model.User{Phone: "13800004601", Nickname: "risk", OpenID: "openid-risk", RealName: "张三", RealNameVerifiedAt: &verified}
+	db.Create(&user)
+	user.CreatedAt = now.AddDate(-1, 0, 0)
+	db.Save(&user)
+	acct := model.UserBankAccount{UserID: user.ID, AccountName: "张 三", AccountNo: "6222000011112222"}
+	db.Create(&acct)
+	db.Model(&acct).Updates(map[string]any{"created_at": now.Add(-48 * time.Hour), "updated_at": now.Add(-48 * time.Hour)})
+
+	res, err := svc.CheckApplication(user.ID, 20000, acct.ID, now)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if len(res.Reasons) != 0 {
+		t.Fatalf("clean application should pass, got %+v", res.Reasons)
+	}
+
+	// 当日已申请 2 笔共 400 元：次数与额度均超限；被拒绝的申请不计入
+	db.Create(&model.WithdrawalRequest{UserID: user.ID, Amount: 20000, Status: "pending", RequestedAt: now.Add(-3 * time.Hour)})
+	db.Create(&model.WithdrawalRequest{UserID: user.ID, Amount: 20000, Status: "pending", RequestedAt: now.Add(-2 * time.Hour)})
+	db.Create(&model.WithdrawalRequest{UserID: user.ID, Amount: 90000, Status: "rejected", RequestedAt: now.Add(-time.Hour)})
+	res, _ = svc.CheckApplication(user.ID, 20000, acct.ID, now)
+	codes := riskCodes(res)
+	if codes["daily_count"] != WithdrawRiskBlock || codes["daily_amount"] != WithdrawRiskBlock || res.Blocked() == nil {
+		t.Fatalf("daily limits should block, got %+v", res.Reasons)
+	}
+	if _, ok := codes["monthly_amount"]; ok {
+		t.Fatalf("rejected requests must not count toward monthly amount: %+v", res.Reasons)
+	}
+
+	// 户名与实名不一致
+	other := model.UserBankAccount{UserID: user.ID, AccountName: "李四", AccountNo: "6222000033334444"}
+	db.Create(&other)
+	db.Model(&other).Updates(map[string]any{"created_at": now.Add(-72 * time.Hour), "updated_at": now.Add(-72 * time.Hour)})
+	res, _ = svc.CheckApplication(user.ID, 100, other.ID, now.AddDate(0, 0, 1))
+	if riskCodes(res)["real_name_mismatch"] != WithdrawRiskBlock {
+		t.Fatalf("account name mismatch should block, got %+v", res.Reasons)
+	}
+
+	// 删除账户后进入冷静期
+	db.Delete(&other)
+	res, _ = svc.CheckApplication(user.ID, 100, acct.ID, time.Now())
+	if riskCodes(res)["account_cooling"] != WithdrawRiskBlock {
+		t.Fatalf("account removal should start cooling period, got %+v", res.Reasons)
+	}
+}
+
+func TestWithdrawRisk_ReviewFlagsStoredWithApplication(t *testing.T) {
+	old := config.Config.Finance.Withdrawal
+	config.Config.Finance.Withdrawal = config.Withdrawal{DailyCount: 1, ReviewNewUserDays: 7, ReviewAmountCents: 500000,
+		ReviewRechargeCents: 100000, ReviewRechargeHours: 48}
+	defer func() { config.Config.Finance.Withdrawal = old }()
+
+	db := setupWithdrawRiskDB(t)
+	svc := &WithdrawRiskService{db: db}
+	user := model.User{Phone: "13800004602", Nickname: "fresh", OpenID: "openid-fresh"}
+	db.Create(&user)
+	credited := time.Now().Add(-2 * time.Hour)
+	db.Create(&model.RechargeRecord{OrderID: 4601, UserID: user.ID, AmountCents: 200000, Status: model.RechargeStatusCredited, CreditedAt: &credited})
+
+	// 同一事务内先落库的申请计入频次：第二笔被拒绝
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res, err := svc.CheckApplicationTx(tx, user.ID, 600000, 0, time.Now())
+		if err != nil {
+			return err
+		}
+		codes := riskCodes(res)
+		for _, c := range []string{"new_user", "large_amount", "recharge_then_withdraw"} {
+			if codes[c] != WithdrawRiskReview {
+				t.Fatalf("expected review flag %s, got %+v", c, res.Reasons)
+			}
+		}
+		if res.Blocked() != nil {
+			t.Fatalf("review-only result should not block: %+v", res.Reasons)
+		}
+		wr := model.WithdrawalRequest{UserID: user.ID, Amount: 600000, Status: "pending",
+			RiskFlags: res.ReviewFlagsJSON(), NeedReview: len(res.ReviewReasons()) > 0}
+		if err := tx.Create(&wr).Error; err != nil {
+			return err
+		}
+		if flags := ParseWithdrawRiskFlags(wr.RiskFlags); len(flags) != 3 || !wr.NeedReview {
+			t.Fatalf("stored flags should round-trip, got %+v", flags)
+		}
+		again, err := svc.CheckApplicationTx(tx, user.ID, 100, 0, time.Now())
+		if err != nil {
+			return err
+		}
+		if again.Blocked() == nil || riskCodes(again)["daily_count"] != WithdrawRiskBlock {
+			t.Fatalf("second application in the same day should be blocked, got %+v", again.Reasons)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("check in tx: %v", err)
+	}
+	if got := ParseWithdrawRiskFlags(""); got == nil || len(got) != 0 {
+		t.Fatalf("empty flags should parse to an empty list, got %+v", got)
+	}
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/model"
+	"tea-api/internal/service/commission"
+)
+
+// completeWithdrawTx 处理中的提现置为已完成并过账（用户提现扣减冻结，门店提现扣减门店待结算），
+// 管理端手工完成、微信转账结果与银行代付回盘共用。状态按条件更新，提现已不在处理中时不做处理，返回 false
+func completeWithdrawTx(tx *gorm.DB, rec *model.WithdrawRecord, now time.Time, operatorID uint) (bool, error) {
+	remark := buildWithdrawPhaseRemark("paid", rec.WithdrawNo, yuanDecimalToCents(rec.Amount), yuanDecimalToCents(rec.Fee), yuanDecimalToCents(rec.ActualAmount))
+	ok, err := transitWithdrawTx(tx, rec, []int{model.WithdrawStatusProcessing}, model.WithdrawStatusCompleted, now, operatorID, remark)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := SettleWithdrawTx(tx, rec, remark); err != nil {
+		return false, fmt.Errorf("settle withdraw: %w", err)
+	}
+	return true, nil
+}
+
+// failWithdrawTx 处理中的提现打款失败：置为已拒绝，用户提现解冻退回可用余额（门店提现申请时未冻结，无需回滚）；
+// phase 与 walletType 分别为流水备注阶段与钱包流水类型。提现已不在处理中时不做处理，返回 false
+func failWithdrawTx(tx *gorm.DB, rec *model.WithdrawRecord, now time.Time, operatorID uint, phase, walletType string) (bool, error) {
+	return rejectWithdrawTx(tx, rec, []int{model.WithdrawStatusProcessing}, now, operatorID, phase, walletType)
+}
+
+// rejectWithdrawTx 提现由 from 中的状态置为已拒绝并解冻，仅状态更新成功时解冻，重复调用不会重复退回
+func rejectWithdrawTx(tx *gorm.DB, rec *model.WithdrawRecord, from []int, now time.Time, operatorID uint, phase, walletType string) (bool, error) {
+	amount := yuanDecimalToCents(rec.Amount)
+	remark := buildWithdrawPhaseRemark(phase, rec.WithdrawNo, amount, yuanDecimalToCents(rec.Fee), yuanDecimalToCents(rec.ActualAmount))
+	ok, err := transitWithdrawTx(tx, rec, from, model.WithdrawStatusRejected, now, operatorID, remark)
+	if err != nil || !ok || rec.StoreID > 0 {
+		return ok, err
+	}
+	if _, err := AdjustWalletTx(tx, rec.UserID, amount, -amount, walletType, remark); err != nil {
+		return false, fmt.Errorf("unfreeze wallet: %w", err)
+	}
+	return true, nil
+}
+
+// transitWithdrawTx 按条件更新提现状态（WHERE status IN from），未命中时返回 false；成功时同步内存中的记录
+func transitWithdrawTx(tx *gorm.DB, rec *model.WithdrawRecord, from []int, to int, now time.Time, operatorID uint, remark string) (bool, error) {
+	updates := map[string]any{"status": to, "processed_at": now, "remark": remark}
+	if operatorID != 0 {
+		updates["processed_by"] = operatorID
+	}
+	res := tx.Model(&model.WithdrawRecord{}).Where("id = ? AND status IN ?", rec.ID, from).Updates(updates)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+	rec.Status = to
+	rec.ProcessedAt = &now
+	rec.Remark = remark
+	if operatorID != 0 {
+		rec.ProcessedBy = operatorID
+	}
+	return true, nil
+}
+
+// CompleteWithdrawManually 管理端手工完成提现：锁定提现记录后校验状态，且不存在处理中的微信转账或待回盘的银行代付，
+// 与自动打款结果经同一 completeWithdrawTx 落账，并发的完成/拒绝/打款结果只有一方生效
+func CompleteWithdrawManually(db *gorm.DB, withdrawID, operatorID uint) (*model.WithdrawRecord, error) {
+	var rec model.WithdrawRecord
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockWithdrawForManualTx(tx, &rec, withdrawID); err != nil {
+			return err
+		}
+		if rec.Status != model.WithdrawStatusProcessing {
+			return errors.New("当前状态不可完成")
+		}
+		if err := ensureNoAutoPayoutTx(tx, rec.ID, "微信转账处理中，不可手工完成", "已加入银行代付批次，请导入回盘文件完成"); err != nil {
+			return err
+		}
+		ok, err := completeWithdrawTx(tx, &rec, time.Now(), operatorID)
+		if err == nil && !ok {
+			err = errors.New("当前状态不可完成")
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// RejectWithdrawManually 管理端拒绝提现（申请中或处理中）：锁定后条件更新状态并解冻，
+// 并发拒绝或与打款结果并发时只解冻一次
+func RejectWithdrawManually(db *gorm.DB, withdrawID, operatorID uint) (*model.WithdrawRecord, error) {
+	var rec model.WithdrawRecord
+	from := []int{model.WithdrawStatusPending, model.WithdrawStatusProcessing}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockWithdrawForManualTx(tx, &rec, withdrawID); err != nil {
+			return err
+		}
+		if rec.Status != model.WithdrawStatusPending && rec.Status != model.WithdrawStatusProcessing {
+			return errors.New("当前状态不可拒绝")
+		}
+		if err := ensureNoAutoPayoutTx(tx, rec.ID, "微信转账处理中，不可拒绝", "已加入银行代付批次，请导入回盘文件处理"); err != nil {
+			return err
+		}
+		ok, err := rejectWithdrawTx(tx, &rec, from, time.Now(), operatorID, "rejected_unfreeze", "withdraw_reject_unfreeze")
+		if err == nil && !ok {
+			err = errors.New("当前状态不可拒绝")
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func lockWithdrawForManualTx(tx *gorm.DB, rec *model.WithdrawRecord, withdrawID uint) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(rec, withdrawID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("记录不存在")
+		}
+		return err
+	}
+	return nil
+}
+
+// ensureNoAutoPayoutTx 在已锁定提现记录的事务内校验不存在处理中的微信转账与待回盘的代付明细；
+// 发起转账与加入代付批次同样先锁定提现记录，校验结果在本事务内有效
+func ensureNoAutoPayoutTx(tx *gorm.DB, withdrawID uint, transferMsg, payoutMsg string) error {
+	var n int64
+	if err := tx.Model(&model.WechatTransferRecord{}).
+		Where("withdraw_id = ? AND status = ?", withdrawID, model.WechatTransferStatusProcessing).Count(&n).Error; err != nil {
+		return err
+	}
+	if n > 0 {
+		return errors.New(transferMsg)
+	}
+	if err := tx.Model(&model.WithdrawPayoutLine{}).
+		Where("withdraw_id = ? AND status = ?", withdrawID, model.PayoutLineStatusPending).Count(&n).Error; err != nil {
+		return err
+	}
+	if n > 0 {
+		return errors.New(payoutMsg)
+	}
+	return nil
+}
+
+// consumeWithdrawCommissions 用户提现完成后按时间顺序消费已解冻佣金以覆盖实付金额；打款已完成，失败仅记录告警
+func consumeWithdrawCommissions(rec *model.WithdrawRecord, operatorID uint) {
+	if rec.StoreID > 0 {
+		return
+	}
+	var opIDPtr *uint
+	if operatorID != 0 {
+		opIDPtr = &operatorID
+	}
+	note := fmt.Sprintf("withdraw %s", rec.WithdrawNo)
+	if _, consumed, err := commission.ConsumeUserAvailableCommissions(rec.UserID, rec.ActualAmount, rec.WithdrawNo, opIDPtr, note); err != nil {
+		zap.L().Warn("consume commissions after withdraw payout failed", zap.String("withdraw_no", rec.WithdrawNo),
+			zap.String("consumed", consumed.String()), zap.Error(err))
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+func TestWithdrawManual_ConcurrentRejectAndCompleteSettleOnce(t *testing.T) {
+	dsn := "file:" + filepath.Join(t.TempDir(), "withdraw.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.WithdrawRecord{}, &model.WechatTransferRecord{}, &model.WithdrawPayoutLine{},
+		&model.Wallet{}, &model.WalletTransaction{}, &model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	user := model.User{Phone: "13800004701", Nickname: "张三", OpenID: "withdraw_manual"}
+	db.Create(&user)
+	// 余额 100 元，两笔各 30 元的提现冻结中
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := AdjustWalletTx(tx, user.ID, 10000, 0, "admin_credit", "期初充值"); err != nil {
+			return err
+		}
+		_, err := AdjustWalletTx(tx, user.ID, -6000, 6000, "withdraw_freeze", "提现冻结")
+		return err
+	}); err != nil {
+		t.Fatalf("seed wallet: %v", err)
+	}
+	var recs []model.WithdrawRecord
+	for _, no := range []string{"WDM01", "WDM02"} {
+		rec := model.WithdrawRecord{UserID: user.ID, WithdrawNo: no, Amount: decimal.NewFromInt(30),
+			Fee: decimal.RequireFromString("0.3"), ActualAmount: decimal.RequireFromString("29.7"),
+			WithdrawType: model.WithdrawTypeBank, Status: model.WithdrawStatusProcessing}
+		db.Create(&rec)
+		recs = append(recs, rec)
+	}
+
+	// 第一笔：并发重复拒绝，只解冻一次
+	var wg sync.WaitGroup
+	var rejected int32
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := RejectWithdrawManually(db, recs[0].ID, 1); err == nil {
+				atomic.AddInt32(&rejected, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if rejected != 1 {
+		t.Fatalf("exactly one reject should succeed, got %d", rejected)
+	}
+	if w := walletOf(t, db, user.ID); w.Balance != 7000 || w.Frozen != 3000 {
+		t.Fatalf("reject should unfreeze once: balance=%d frozen=%d", w.Balance, w.Frozen)
+	}
+
+	// 第二笔：完成与拒绝并发，只有一方生效
+	var completed int32
+	rejected = 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				if _, err := CompleteWithdrawManually(db, recs[1].ID, 1); err == nil {
+					atomic.AddInt32(&completed, 1)
+				}
+				return
+			}
+			if _, err := RejectWithdrawManually(db, recs[1].ID, 1); err == nil {
+				atomic.AddInt32(&rejected, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if completed+rejected != 1 {
+		t.Fatalf("exactly one of complete/reject should win, completed=%d rejected=%d", completed, rejected)
+	}
+	w := walletOf(t, db, user.ID)
+	if w.Frozen != 0 || (completed == 1 && w.Balance != 7000) || (rejected == 1 && w.Balance != 10000) {
+		t.Fatalf("unexpected wallet after race: balance=%d frozen=%d completed=%d", w.Balance, w.Frozen, completed)
+	}
+
+	// 存在处理中的微信转账时不可手工完成
+	db.Model(&model.WithdrawRecord{}).Where("id = ?", recs[1].ID).Update("status", model.WithdrawStatusProcessing)
+	db.Create(&model.WechatTransferRecord{WithdrawID: recs[1].ID, PartnerTradeNo: "WXT01", OpenID: "o-wdm", Amount: decimal.RequireFromString("29.7"), Status: model.WechatTransferStatusProcessing})
+	if _, err := CompleteWithdrawManually(db, recs[1].ID, 1); err == nil {
+		t.Fatalf("manual complete should be blocked while a wechat transfer is in flight")
+	}
+}
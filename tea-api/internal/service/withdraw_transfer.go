@@ -0,0 +1,404 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tea-api/internal/config"
+	"tea-api/internal/model"
+	"tea-api/pkg/database"
+)
+
+// 转账结果状态
+const (
+	TransferStatusProcessing = "PROCESSING"
+	TransferStatusSuccess    = "SUCCESS"
+	TransferStatusFailed     = "FAIL"
+)
+
+var (
+	ErrTransferNotFound = errors.New("转账单不存在")
+	ErrTransferNoOpenID = errors.New("用户未绑定微信，无法转账")
+	// ErrTransferRealNameRequired 大额转账需收款人实名，未实名时改走银行代付
+	ErrTransferRealNameRequired = errors.New("单笔 2000 元及以上的微信转账需收款人已实名，请改走银行代付")
+)
+
+// transferRealNameMinCents 微信商家转账单笔达到该金额（分）时必须传收款人实名（user_name 加密字段）
+const transferRealNameMinCents = 200000
+
+// TransferRequest 商家转账参数
+type TransferRequest struct {
+	PartnerTradeNo string
+	OpenID         string
+	AmountCents    int64
+	Description    string
+	NotifyURL      string
+	UserName       string // 收款人实名，单笔达到 transferRealNameMinCents 时必填
+}
+
+// TransferResult 渠道转账结果
+type TransferResult struct {
+	PartnerTradeNo string
+	PaymentNo      string // 渠道转账单号
+	Status         string // SUCCESS / PROCESSING / FAIL
+	FailCode       string
+	FailReason     string
+	PaidAt         *time.Time
+	Raw            string
+}
+
+// TransferProvider 转账渠道抽象：发起转账（按 PartnerTradeNo 幂等）、查询结果与回调解析
+type TransferProvider interface {
+	Name() string
+	Transfer(ctx context.Context, req TransferRequest) (*TransferResult, error)
+	QueryTransfer(ctx context.Context, partnerTradeNo string) (*TransferResult, error)
+	ParseTransferNotify(ctx context.Context, header http.Header, body []byte) (string, error)
+}
+
+var wechatTransferProvider *WechatPayV3Provider
+
+// NewTransferProvider wechat.pay_mode 为 v3 时走微信商家转账（payment.transfer_base_url 可覆盖接口地址），否则使用本地模拟渠道
+func NewTransferProvider() (TransferProvider, error) {
+	if !strings.EqualFold(config.Config.WeChat.PayMode, "v3") {
+		return &MockTransferProvider{}, nil
+	}
+	base := strings.TrimSpace(config.Config.Payment.TransferBaseURL)
+	if base == "" {
+		return sharedWechatProvider()
+	}
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if wechatTransferProvider != nil {
+		return wechatTransferProvider, nil
+	}
+	cfg := config.Config.WeChat
+	cfg.APIBaseURL = base
+	p, err := NewWechatPayV3Provider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	wechatTransferProvider = p
+	return p, nil
+}
+
+// MockTransferProvider 本地模拟渠道：转账立即成功
+type MockTransferProvider struct{}
+
+func (m *MockTransferProvider) Name() string { return "mock" }
+
+func (m *MockTransferProvider) Transfer(_ context.Context, req TransferRequest) (*TransferResult, error) {
+	if req.OpenID == "" {
+		return nil, ErrTransferNoOpenID
+	}
+	now := time.Now()
+	return &TransferResult{PartnerTradeNo: req.PartnerTradeNo, PaymentNo: "mock_" + req.PartnerTradeNo, Status: TransferStatusSuccess, PaidAt: &now}, nil
+}
+
+func (m *MockTransferProvider) QueryTransfer(_ context.Context, partnerTradeNo string) (*TransferResult, error) {
+	now := time.Now()
+	return &TransferResult{PartnerTradeNo: partnerTradeNo, PaymentNo: "mock_" + partnerTradeNo, Status: TransferStatusSuccess, PaidAt: &now}, nil
+}
+
+func (m *MockTransferProvider) ParseTransferNotify(context.Context, http.Header, []byte) (string, error) {
+	return "", errors.New("模拟渠道不支持转账回调")
+}
+
+// WithdrawTransferService 提现微信转账：审核通过的提现发起转账并记录结果，成功完成提现，失败退回钱包
+type WithdrawTransferService struct {
+	db       *gorm.DB
+	provider func() (TransferProvider, error)
+}
+
+func NewWithdrawTransferService() *WithdrawTransferService {
+	return &WithdrawTransferService{db: database.GetDB(), provider: NewTransferProvider}
+}
+
+// TransferEnabled 是否启用审核通过后自动转账
+func TransferEnabled() bool { return config.Config.Payment.TransferEnabled }
+
+func transferSyncAfter() time.Duration {
+	if m := config.Config.Payment.TransferSyncAfterMinutes; m > 0 {
+		return time.Duration(m) * time.Minute
+	}
+	return 5 * time.Minute
+}
+
+// transferTradeNo 转账单号由提现单号派生，同一提现只会对应一笔转账
+func transferTradeNo(rec *model.WithdrawRecord) string {
+	return "WT" + rec.WithdrawNo
+}
+
+// StartTransfer 对处理中（已审核）的个人微信提现发起转账；已存在转账单时不再新建，处理中的转为同步结果
+func (s *WithdrawTransferService) StartTransfer(ctx context.Context, withdrawID uint) (*model.WechatTransferRecord, error) {
+	var tr model.WechatTransferRecord
+	created := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var rec model.WithdrawRecord
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rec, withdrawID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("提现记录不存在")
+			}
+			return err
+		}
+		err := tx.Where("withdraw_id = ?", rec.ID).First(&tr).Error
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if rec.Status != model.WithdrawStatusProcessing {
+			return errors.New("仅已审核的提现可发起转账")
+		}
+		if rec.StoreID > 0 || rec.WithdrawType != 1 {
+			return errors.New("该提现不支持微信转账")
+		}
+		var inPayout int64
+		if err := tx.Model(&model.WithdrawPayoutLine{}).Where("withdraw_id = ? AND status IN ?", rec.ID,
+			[]int{model.PayoutLineStatusPending, model.PayoutLineStatusSuccess}).Count(&inPayout).Error; err != nil {
+			return err
+		}
+		if inPayout > 0 {
+			return errors.New("该提现已加入银行代付批次")
+		}
+		var user model.User
+		if err := tx.Select("id", "open_id", "real_name", "real_name_verified_at").First(&user, rec.UserID).Error; err != nil {
+			return err
+		}
+		if user.OpenID == "" {
+			return ErrTransferNoOpenID
+		}
+		if yuanDecimalToCents(rec.ActualAmount) >= transferRealNameMinCents && (user.RealName == "" || user.RealNameVerifiedAt == nil) {
+			return ErrTransferRealNameRequired
+		}
+		tr = model.WechatTransferRecord{
+			WithdrawID:     rec.ID,
+			PartnerTradeNo: transferTradeNo(&rec),
+			OpenID:         user.OpenID,
+			Amount:         rec.ActualAmount,
+			Description:    "提现到账 " + rec.WithdrawNo,
+			Status:         model.WechatTransferStatusProcessing,
+		}
+		created = true
+		return tx.Create(&tr).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case created:
+		err = s.submitTransfer(ctx, &tr)
+	case tr.Status == model.WechatTransferStatusProcessing:
+		// 重复发起视为手动同步：查询渠道结果，渠道侧不存在则按原单号重新提交
+		err = s.SyncTransfer(ctx, tr.PartnerTradeNo)
+	default:
+		return &tr, nil
+	}
+	if err != nil {
+		return &tr, err
+	}
+	return s.reload(tr.ID)
+}
+
+// StartTransfers 批量发起转账，返回每笔提现的处理结果
+func (s *WithdrawTransferService) StartTransfers(ctx context.Context, withdrawIDs []uint) map[uint]string {
+	out := make(map[uint]string, len(withdrawIDs))
+	for _, id := range withdrawIDs {
+		tr, err := s.StartTransfer(ctx, id)
+		switch {
+		case err != nil:
+			out[id] = err.Error()
+		case tr.Status == model.WechatTransferStatusSuccess:
+			out[id] = "success"
+		case tr.Status == model.WechatTransferStatusFailed:
+			out[id] = "failed"
+		default:
+			out[id] = "processing"
+		}
+	}
+	return out
+}
+
+// submitTransfer 提交渠道转账；请求异常时保持处理中，由同步任务查询或按原单号重新提交
+func (s *WithdrawTransferService) submitTransfer(ctx context.Context, tr *model.WechatTransferRecord) error {
+	provider, err := s.provider()
+	if err != nil {
+		return err
+	}
+	req := TransferRequest{
+		PartnerTradeNo: tr.PartnerTradeNo,
+		OpenID:         tr.OpenID,
+		AmountCents:    yuanDecimalToCents(tr.Amount),
+		Description:    tr.Description,
+		NotifyURL:      config.Config.Payment.TransferNotifyURL,
+	}
+	if req.AmountCents >= transferRealNameMinCents {
+		if err := s.db.Model(&model.User{}).Select("users.real_name").
+			Joins("JOIN withdraw_records ON withdraw_records.user_id = users.id").
+			Where("withdraw_records.id = ?", tr.WithdrawID).Scan(&req.UserName).Error; err != nil {
+			return err
+		}
+	}
+	res, err := provider.Transfer(ctx, req)
+	if err != nil {
+		s.db.Model(&model.WechatTransferRecord{}).Where("id = ? AND status = ?", tr.ID, model.WechatTransferStatusProcessing).
+			Update("error_msg", truncateText(err.Error(), 200))
+		return fmt.Errorf("发起转账失败: %w", err)
+	}
+	return s.applyTransferResult(tr.PartnerTradeNo, res)
+}
+
+// SyncTransfer 查询单笔处理中转账的结果；渠道侧不存在（此前提交未成功）时按原单号重新提交
+func (s *WithdrawTransferService) SyncTransfer(ctx context.Context, partnerTradeNo string) error {
+	var tr model.WechatTransferRecord
+	if err := s.db.Where("partner_trade_no = ?", partnerTradeNo).First(&tr).Error; err != nil {
+		return err
+	}
+	if tr.Status != model.WechatTransferStatusProcessing {
+		return nil
+	}
+	provider, err := s.provider()
+	if err != nil {
+		return err
+	}
+	res, err := provider.QueryTransfer(ctx, tr.PartnerTradeNo)
+	if errors.Is(err, ErrTransferNotFound) {
+		return s.submitTransfer(ctx, &tr)
+	}
+	if err != nil {
+		return err
+	}
+	return s.applyTransferResult(tr.PartnerTradeNo, res)
+}
+
+// SyncTransfers 扫描处理中超过 transfer_sync_after_minutes 的转账并同步结果，返回处理成功的笔数
+func (s *WithdrawTransferService) SyncTransfers(now time.Time, limit int) (int, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	var nos []string
+	if err := s.db.Model(&model.WechatTransferRecord{}).
+		Where("status = ? AND updated_at <= ?", model.WechatTransferStatusProcessing, now.Add(-transferSyncAfter())).
+		Order("id ASC").Limit(limit).Pluck("partner_trade_no", &nos).Error; err != nil {
+		return 0, err
+	}
+	processed := 0
+	for _, no := range nos {
+		if err := s.SyncTransfer(context.Background(), no); err != nil {
+			zap.L().Warn("sync withdraw transfer failed", zap.String("partner_trade_no", no), zap.Error(err))
+			continue
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+// HandleNotify 处理渠道转账回调：验签解析出转账单号后主动查询确认结果
+func (s *WithdrawTransferService) HandleNotify(ctx context.Context, header http.Header, body []byte) error {
+	provider, err := s.provider()
+	if err != nil {
+		return err
+	}
+	no, err := provider.ParseTransferNotify(ctx, header, body)
+	if err != nil {
+		return err
+	}
+	return s.SyncTransfer(ctx, no)
+}
+
+// applyTransferResult 落地转账结果（仅处理中的转账单生效，重复结果忽略）：
+// 成功时提现置为已完成并过账冻结资金，失败时提现置为已拒绝并解冻退回可用余额
+func (s *WithdrawTransferService) applyTransferResult(partnerTradeNo string, res *TransferResult) error {
+	if res == nil || (res.Status != TransferStatusSuccess && res.Status != TransferStatusFailed) {
+		return nil
+	}
+	var rec model.WithdrawRecord
+	applied := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var tr model.WechatTransferRecord
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("partner_trade_no = ?", partnerTradeNo).First(&tr).Error; err != nil {
+			return err
+		}
+		if tr.Status != model.WechatTransferStatusProcessing {
+			return nil
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rec, tr.WithdrawID).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		updates := map[string]any{"payment_no": res.PaymentNo}
+		if res.Status == TransferStatusSuccess {
+			paidAt := now
+			if res.PaidAt != nil {
+				paidAt = *res.PaidAt
+			}
+			updates["status"] = model.WechatTransferStatusSuccess
+			updates["payment_time"] = paidAt
+			updates["error_code"] = ""
+			updates["error_msg"] = ""
+			if err := tx.Model(&tr).Updates(updates).Error; err != nil {
+				return err
+			}
+			ok, err := completeWithdrawTx(tx, &rec, now, 0)
+			applied = ok
+			return err
+		}
+		updates["status"] = model.WechatTransferStatusFailed
+		updates["error_code"] = truncateText(res.FailCode, 20)
+		updates["error_msg"] = truncateText(res.FailReason, 200)
+		if err := tx.Model(&tr).Updates(updates).Error; err != nil {
+			return err
+		}
+		_, err := failWithdrawTx(tx, &rec, now, 0, "transfer_failed_unfreeze", "withdraw_transfer_fail_unfreeze")
+		return err
+	})
+	if err != nil || !applied {
+		return err
+	}
+	consumeWithdrawCommissions(&rec, 0)
+	return nil
+}
+
+// ActiveTransfer 返回提现对应的处理中转账单，不存在时返回 nil
+func (s *WithdrawTransferService) ActiveTransfer(withdrawID uint) (*model.WechatTransferRecord, error) {
+	var tr model.WechatTransferRecord
+	err := s.db.Where("withdraw_id = ? AND status = ?", withdrawID, model.WechatTransferStatusProcessing).First(&tr).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tr, nil
+}
+
+func (s *WithdrawTransferService) reload(id uint) (*model.WechatTransferRecord, error) {
+	var tr model.WechatTransferRecord
+	if err := s.db.First(&tr, id).Error; err != nil {
+		return nil, err
+	}
+	return &tr, nil
+}
+
+// buildWithdrawPhaseRemark 构造提现各阶段的钱包流水备注(JSON)，与管理端完成/拒绝备注结构一致
+func buildWithdrawPhaseRemark(phase, withdrawNo string, amountCents, feeCents, netCents int64) string {
+	m := map[string]any{
+		"phase":        phase,
+		"withdraw_no":  withdrawNo,
+		"amount_cents": amountCents,
+		"fee_cents":    feeCents,
+		"net_cents":    netCents,
+		"currency":     "CNY",
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"tea-api/internal/model"
+)
+
+func setupWithdrawTransferDB(t *testing.T, name string) (*gorm.DB, model.WithdrawRecord) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.WithdrawRecord{}, &model.WechatTransferRecord{}, &model.WithdrawPayoutLine{}, &model.Wallet{}, &model.WalletTransaction{},
+		&model.LedgerAccount{}, &model.LedgerEntry{}, &model.LedgerPosting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	user := model.User{Phone: "1380000" + name[len(name)-4:], Nickname: "wt", OpenID: "openid-" + name}
+	db.Create(&user)
+	// 余额 100 元，申请提现 30 元（手续费 0.3 元）冻结
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := AdjustWalletTx(tx, user.ID, 10000, 0, "admin_credit", "期初充值"); err != nil {
+			return err
+		}
+		_, err := AdjustWalletTx(tx, user.ID, -3000, 3000, "withdraw_freeze", "提现冻结")
+		return err
+	}); err != nil {
+		t.Fatalf("seed wallet: %v", err)
+	}
+	rec := model.WithdrawRecord{UserID: user.ID, WithdrawNo: "WD" + name, Amount: decimal.NewFromInt(30),
+		Fee: decimal.RequireFromString("0.3"), ActualAmount: decimal.RequireFromString("29.7"),
+		WithdrawType: 1, Status: model.WithdrawStatusProcessing}
+	db.Create(&rec)
+	return db, rec
+}
+
+func walletOf(t *testing.T, db *gorm.DB, userID uint) model.Wallet {
+	t.Helper()
+	var w model.Wallet
+	if err := db.Where("user_id = ?", userID).First(&w).Error; err != nil {
+		t.Fatalf("wallet: %v", err)
+	}
+	return w
+}
+
+func TestWithdrawTransfer_WechatSuccessAfterSync(t *testing.T) {
+	db, rec := setupWithdrawTransferDB(t, "wt_ok_0001")
+	p, stub := newTestWechatProvider(t)
+	svc := &WithdrawTransferService{db: db, provider: func() (TransferProvider, error) { return p, nil }}
+	ctx := context.Background()
+
+	tr, err := svc.StartTransfer(ctx, rec.ID)
+	if err != nil {
+		t.Fatalf("start transfer: %v", err)
+	}
+	if tr.Status != model.WechatTransferStatusProcessing || tr.PartnerTradeNo != "WT"+rec.WithdrawNo || tr.OpenID != "openid-wt_ok_0001" {
+		t.Fatalf("unexpected transfer record: %+v", tr)
+	}
+	if yuanDecimalToCents(tr.Amount) != 2970 {
+		t.Fatalf("transfer should pay the net amount, got %s", tr.Amount)
+	}
+
+	// 渠道侧批次丢失时按原单号重新提交，不新建转账单
+	delete(stub.transfers, tr.PartnerTradeNo)
+	if _, err := svc.StartTransfer(ctx, rec.ID); err != nil {
+		t.Fatalf("restart transfer: %v", err)
+	}
+	var cnt int64
+	db.Model(&model.WechatTransferRecord{}).Where("withdraw_id = ?", rec.ID).Count(&cnt)
+	if cnt != 1 || stub.transfers[tr.PartnerTradeNo] != "PROCESSING" {
+		t.Fatalf("transfer should be resubmitted under the same number, records=%d stub=%v", cnt, stub.transfers)
+	}
+
+	// 未到同步时间不查询
+	if n, _ := svc.SyncTransfers(time.Now(), 10); n != 0 {
+		t.Fatalf("fresh transfer should not be synced yet, got %d", n)
+	}
+	stub.transfers[tr.PartnerTradeNo] = "SUCCESS"
+	if n, err := svc.SyncTransfers(time.Now().Add(transferSyncAfter()+time.Minute), 10); err != nil || n != 1 {
+		t.Fatalf("sync should process 1 transfer, got %d err=%v", n, err)
+	}
+	// 重复结果不重复过账
+	if err := svc.SyncTransfer(ctx, tr.PartnerTradeNo); err != nil {
+		t.Fatalf("repeat sync: %v", err)
+	}
+
+	var got model.WechatTransferRecord
+	db.First(&got, tr.ID)
+	if got.Status != model.WechatTransferStatusSuccess || got.PaymentNo == "" || got.PaymentTime == nil {
+		t.Fatalf("transfer should be successful: %+v", got)
+	}
+	var wd model.WithdrawRecord
+	db.First(&wd, rec.ID)
+	if wd.Status != model.WithdrawStatusCompleted {
+		t.Fatalf("withdraw should be completed, status=%d", wd.Status)
+	}
+	if w := walletOf(t, db, rec.UserID); w.Balance != 7000 || w.Frozen != 0 {
+		t.Fatalf("frozen funds should be settled, balance=%d frozen=%d", w.Balance, w.Frozen)
+	}
+	var paid int64
+	db.Model(&model.WalletTransaction{}).Where("user_id = ? AND type = ?", rec.UserID, "withdraw_paid").Count(&paid)
+	if paid != 1 {
+		t.Fatalf("withdraw should be settled exactly once, got %d", paid)
+	}
+}
+
+func TestWithdrawTransfer_FailureReturnsFunds(t *testing.T) {
+	db, rec := setupWithdrawTransferDB(t, "wt_fail_0002")
+	p, stub := newTestWechatProvider(t)
+	svc := &WithdrawTransferService{db: db, provider: func() (TransferProvider, error) { return p, nil }}
+	ctx := context.Background()
+
+	tr, err := svc.StartTransfer(ctx, rec.ID)
+	if err != nil {
+		t.Fatalf("start transfer: %v", err)
+	}
+	stub.transfers[tr.PartnerTradeNo] = "FAIL"
+	if err := svc.SyncTransfer(ctx, tr.PartnerTradeNo); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	var got model.WechatTransferRecord
+	db.First(&got, tr.ID)
+	if got.Status != model.WechatTransferStatusFailed || got.ErrorCode != "ACCOUNT_FROZEN" {
+		t.Fatalf("transfer should be failed with reason: %+v", got)
+	}
+	var wd model.WithdrawRecord
+	db.First(&wd, rec.ID)
+	if wd.Status != model.WithdrawStatusRejected {
+		t.Fatalf("withdraw should be rejected, status=%d", wd.Status)
+	}
+	if w := walletOf(t, db, rec.UserID); w.Balance != 10000 || w.Frozen != 0 {
+		t.Fatalf("funds should return to wallet, balance=%d frozen=%d", w.Balance, w.Frozen)
+	}
+
+	// 已失败的转账再次发起只返回原记录，不新建转账单
+	if _, err := svc.StartTransfer(ctx, rec.ID); err != nil {
+		t.Fatalf("start on failed transfer should return the record: %v", err)
+	}
+	var cnt int64
+	db.Model(&model.WechatTransferRecord{}).Where("withdraw_id = ?", rec.ID).Count(&cnt)
+	if cnt != 1 {
+		t.Fatalf("no new transfer should be created, got %d", cnt)
+	}
+}
+
+func TestWithdrawTransfer_LargeAmountSendsEncryptedRealName(t *testing.T) {
+	db, rec := setupWithdrawTransferDB(t, "wt_big_0003")
+	db.Model(&rec).Updates(map[string]any{"amount": decimal.NewFromInt(2500), "fee": decimal.NewFromInt(25), "actual_amount": decimal.NewFromInt(2475)})
+	p, stub := newTestWechatProvider(t)
+	svc := &WithdrawTransferService{db: db, provider: func() (TransferProvider, error) { return p, nil }}
+	ctx := context.Background()
+
+	// 未实名：不发起转账，提示改走银行代付
+	if _, err := svc.StartTransfer(ctx, rec.ID); !errors.Is(err, ErrTransferRealNameRequired) {
+		t.Fatalf("large transfer without real name should be refused, err=%v", err)
+	}
+	var cnt int64
+	db.Model(&model.WechatTransferRecord{}).Where("withdraw_id = ?", rec.ID).Count(&cnt)
+	if cnt != 0 || stub.lastTransfer != nil {
+		t.Fatalf("no transfer should be created, records=%d", cnt)
+	}
+
+	now := time.Now()
+	db.Model(&model.User{}).Where("id = ?", rec.UserID).Updates(map[string]any{"real_name": "张三", "real_name_verified_at": now})
+	if _, err := svc.StartTransfer(ctx, rec.ID); err != nil {
+		t.Fatalf("start transfer: %v", err)
+	}
+	if stub.lastSerial != certSerial(stub.platformCert) {
+		t.Fatalf("Wechatpay-Serial should name the platform certificate, got %q", stub.lastSerial)
+	}
+	details, _ := stub.lastTransfer["transfer_detail_list"].([]any)
+	detail, _ := details[0].(map[string]any)
+	cipherText, _ := detail["user_name"].(string)
+	raw, err := base64.StdEncoding.DecodeString(cipherText)
+	if err != nil {
+		t.Fatalf("user_name should be base64: %v", err)
+	}
+	plain, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, stub.platformKey, raw, nil)
+	if err != nil || string(plain) != "张三" {
+		t.Fatalf("user_name should decrypt to the real name, got %q err=%v", plain, err)
+	}
+}
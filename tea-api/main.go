@@ -63,6 +63,18 @@ func main() {
 	scheduler.StartAccrualScheduler()
 	// 启动佣金解冻调度（若启用）
 	scheduler.StartCommissionReleaseScheduler()
+	// 启动库存预占超时释放（若启用）
+	scheduler.StartStockReservationExpirer()
+	// 启动退款重试与结果同步（若启用）
+	scheduler.StartRefundRetryScheduler()
+	// 启动待支付单对账（若启用）
+	scheduler.StartPaymentReconcileScheduler()
+	// 启动钱包余额巡检（若启用）
+	scheduler.StartWalletAuditScheduler()
+	// 启动积分过期处理与到期提醒（若启用）
+	scheduler.StartPointsExpiryScheduler()
+	// 启动提现转账结果同步（若启用）
+	scheduler.StartWithdrawTransferSyncScheduler()
 
 	fmt.Println("茶心阁小程序API服务启动成功!")
 	fmt.Printf("服务运行在: %s\n", config.Config.Server.Port)
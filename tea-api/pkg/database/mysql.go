@@ -89,6 +89,9 @@ func autoMigrate() error {
 		&model.Store{},
 		&model.StoreBankAccount{},
 		&model.StoreProduct{},
+		&model.StoreSlotTemplate{},
+		&model.StoreSlotUsage{},
+		&model.StoreSlotReservation{},
 		&model.Order{},
 		&model.OrderItem{},
 		&model.Cart{},
@@ -97,6 +100,7 @@ func autoMigrate() error {
 		// 支付管理
 		&model.Payment{},
 		&model.Refund{},
+		&model.PaymentNotification{},
 
 		// 外卖平台
 		&model.DeliveryOrder{},
@@ -128,16 +132,51 @@ func autoMigrate() error {
 		&model.Commission{},
 		&model.CommissionTransaction{},
 		&model.Wallet{},
+		&model.RechargeRecord{},
 		&model.WalletTransaction{},
+		&model.LedgerAccount{},
+		&model.LedgerEntry{},
+		&model.LedgerPosting{},
+		&model.WalletAuditRun{},
+		&model.WalletAuditFinding{},
+		&model.GiftCardBatch{},
+		&model.GiftCard{},
+		&model.GiftCardTransaction{},
+		&model.PointsTransaction{},
+		&model.PointsLot{},
+		&model.PointsMallItem{},
+		&model.PointsExchange{},
 		&model.MembershipPackage{},
 		&model.PartnerLevel{},
 		&model.UserBankAccount{},
 		&model.ReferralClosure{},
+		&model.ReferralMoveLog{},
 
 		// 提现管理
 		&model.WithdrawRecord{},
 		&model.WithdrawalRequest{},
 		&model.WechatTransferRecord{},
+		&model.WithdrawPayoutBatch{},
+		&model.WithdrawPayoutLine{},
+
+		// 发票管理
+		&model.InvoiceTitle{},
+		&model.Invoice{},
+		&model.InvoiceItem{},
+
+		// 幂等请求记录
+		&model.IdempotencyRecord{},
+
+		// 门店小票打印
+		&model.StorePrinter{},
+		&model.PrintTemplate{},
+		&model.PrintJob{},
+
+		// 库存预占
+		&model.StockReservation{},
+
+		// 渠道对账单
+		&model.SettlementStatementLine{},
 	)
 }
 